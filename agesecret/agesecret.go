@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	defaultIdentityEnv = "AGE_IDENTITY"
+)
+
+type AgeSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	IdentityEnv  string            `json:"identityEnv,omitempty"`
+	IdentityFile string            `json:"identityFile,omitempty"`
+	Type         corev1.SecretType `json:"type,omitempty"`
+	Data         map[string]string `json:"data,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var ageSecret AgeSecret
+	if err := pluginconfig.Unmarshal(data, &ageSecret); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&ageSecret)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(ageSecret *AgeSecret) ([][]byte, error) {
+	identities, err := readIdentities(&ageSecret.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string][]byte, len(ageSecret.Spec.Data))
+	for key, ciphertext := range ageSecret.Spec.Data {
+		plaintext, err := decrypt(identities, ciphertext)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key, err)
+		}
+		data[key] = plaintext
+	}
+
+	secret, err := makeSecret(ageSecret, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{secret}, nil
+}
+
+func readIdentities(spec *Spec) ([]age.Identity, error) {
+	if spec.IdentityFile != "" {
+		f, err := os.Open(spec.IdentityFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		return age.ParseIdentities(f)
+	}
+
+	identityEnv := spec.IdentityEnv
+	if identityEnv == "" {
+		identityEnv = defaultIdentityEnv
+	}
+
+	identity, exists := os.LookupEnv(identityEnv)
+	if !exists {
+		return nil, fmt.Errorf("%s is empty", identityEnv)
+	}
+
+	return age.ParseIdentities(strings.NewReader(identity))
+}
+
+func decrypt(identities []age.Identity, ciphertext string) ([]byte, error) {
+	var src io.Reader
+	if strings.HasPrefix(strings.TrimSpace(ciphertext), armor.Header) {
+		src = armor.NewReader(strings.NewReader(strings.TrimSpace(ciphertext)))
+	} else {
+		b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ciphertext))
+		if err != nil {
+			return nil, err
+		}
+		src = bytes.NewReader(b)
+	}
+
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadAll(r)
+}
+
+func makeSecret(ageSecret *AgeSecret, data map[string][]byte) ([]byte, error) {
+	secretType := ageSecret.Spec.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Secret{}).Name(),
+		},
+		ObjectMeta: ageSecret.ObjectMeta,
+		Type:       secretType,
+		Data:       data,
+	}
+
+	return yaml.Marshal(secret)
+}
@@ -0,0 +1,13 @@
+package main_test
+
+import (
+	"testing"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+)
+
+func TestAgeSecret(t *testing.T) {
+	g.RegisterFailHandler(ginkgo.Fail)
+	ginkgo.RunSpecs(t, "AgeSecret Suite")
+}
@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/agesecret"
+)
+
+func encrypt(recipient age.Recipient, plaintext string, armored bool) string {
+	var out bytes.Buffer
+
+	var dst io.WriteCloser = nopWriteCloser{&out}
+	if armored {
+		dst = armor.NewWriter(&out)
+	}
+
+	w, err := age.Encrypt(dst, recipient)
+	g.Expect(err).To(g.BeNil())
+	_, err = io.WriteString(w, plaintext)
+	g.Expect(err).To(g.BeNil())
+	g.Expect(w.Close()).To(g.Succeed())
+	g.Expect(dst.Close()).To(g.Succeed())
+
+	if armored {
+		return out.String()
+	}
+
+	return base64.StdEncoding.EncodeToString(out.Bytes())
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+var _ = ginkgo.Describe("AgeSecret", func() {
+	identity, err := age.GenerateX25519Identity()
+	g.Expect(err).To(g.BeNil())
+
+	otherIdentity, err := age.GenerateX25519Identity()
+	g.Expect(err).To(g.BeNil())
+
+	workingDir, err := os.MkdirTemp("", "*")
+	g.Expect(err).To(g.BeNil())
+
+	identityFile := filepath.Join(workingDir, "keys.txt")
+	g.Expect(os.WriteFile(identityFile, []byte(identity.String()+"\n"), 0600)).To(g.Succeed())
+
+	g.Expect(os.Setenv("AGE_IDENTITY", identity.String())).To(g.Succeed())
+
+	// the armored ciphertext spans lines, so it is quoted
+	data := `
+  data:
+    armored: ` + strconv.Quote(encrypt(identity.Recipient(), "hunter2", true)) + `
+    base64: ` + encrypt(identity.Recipient(), "correct horse battery staple", false) + `
+`
+
+	ginkgo.DescribeTable("", func(spec string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: AgeSecret
+metadata:
+  name: my-app
+spec:`+spec+data), &out)).To(g.Succeed())
+
+		var secret corev1.Secret
+		g.Expect(yaml.Unmarshal(out.Bytes(), &secret)).To(g.Succeed())
+
+		g.Expect(secret.Name).To(g.Equal("my-app"))
+		g.Expect(secret.Type).To(g.Equal(corev1.SecretTypeOpaque))
+		g.Expect(secret.Data).To(g.Equal(map[string][]byte{
+			"armored": []byte("hunter2"),
+			"base64":  []byte("correct horse battery staple"),
+		}))
+	},
+		ginkgo.Entry("with identity from environment", ""),
+		ginkgo.Entry("with identity from file", `
+  identityFile: `+identityFile),
+	)
+
+	ginkgo.It("fails with the wrong identity", func() {
+		g.Expect(os.Setenv("OTHER_AGE_IDENTITY", otherIdentity.String())).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: AgeSecret
+metadata:
+  name: my-app
+spec:
+  identityEnv: OTHER_AGE_IDENTITY`+data), &out)).To(g.MatchError(g.ContainSubstring("no identity matched any of the recipients")))
+	})
+})
@@ -0,0 +1,540 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	rolloutKind          = "Rollout"
+	analysisTemplateKind = "AnalysisTemplate"
+
+	prometheusProvider = "prometheus"
+	datadogProvider    = "datadog"
+
+	nameArg           = "name"
+	namespaceArg      = "namespace"
+	namespaceFieldRef = "metadata.namespace"
+
+	canaryAnalysisField    = "analysis"
+	blueGreenAnalysisField = "prePromotionAnalysis"
+
+	defaultInterval        = "1m"
+	defaultCount           = 5
+	defaultFailureLimit    = 1
+	defaultDatadogInterval = "5m"
+)
+
+var (
+	rolloutsGroupVersion = schema.GroupVersion{
+		Group:   "argoproj.io",
+		Version: "v1alpha1",
+	}
+
+	canaryStrategyPath = []string{
+		"spec",
+		"strategy",
+		"canary",
+	}
+
+	blueGreenStrategyPath = []string{
+		"spec",
+		"strategy",
+		"blueGreen",
+	}
+)
+
+type AnalysisTemplates struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Prometheus *Prometheus `json:"prometheus,omitempty"`
+	Datadog    *Datadog    `json:"datadog,omitempty"`
+	Checks     []Check     `json:"checks,omitempty"`
+	Rollouts   *Rollouts   `json:"rollouts,omitempty"`
+}
+
+type Prometheus struct {
+	Address string `json:"address,omitempty"`
+}
+
+type Datadog struct {
+	Interval string `json:"interval,omitempty"`
+}
+
+type Check struct {
+	Name             string `json:"name,omitempty"`
+	Provider         string `json:"provider,omitempty"`
+	Query            string `json:"query,omitempty"`
+	SuccessCondition string `json:"successCondition,omitempty"`
+	FailureCondition string `json:"failureCondition,omitempty"`
+	Interval         string `json:"interval,omitempty"`
+	InitialDelay     string `json:"initialDelay,omitempty"`
+	Count            *int32 `json:"count,omitempty"`
+	FailureLimit     *int32 `json:"failureLimit,omitempty"`
+}
+
+type Rollouts struct {
+	Names        []string              `json:"names,omitempty"`
+	Selector     *metav1.LabelSelector `json:"selector,omitempty"`
+	Checks       []string              `json:"checks,omitempty"`
+	StartingStep *int32                `json:"startingStep,omitempty"`
+}
+
+// The types below mirror the subset of the Argo Rollouts API written by this
+// plugin, which avoids depending on the whole Argo Rollouts module.
+
+type analysisTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              analysisTemplateSpec `json:"spec"`
+}
+
+type analysisTemplateSpec struct {
+	Args    []argument `json:"args"`
+	Metrics []metric   `json:"metrics"`
+}
+
+type argument struct {
+	Name string `json:"name"`
+}
+
+type metric struct {
+	Name             string         `json:"name"`
+	Interval         string         `json:"interval"`
+	InitialDelay     string         `json:"initialDelay,omitempty"`
+	Count            int32          `json:"count"`
+	FailureLimit     int32          `json:"failureLimit"`
+	SuccessCondition string         `json:"successCondition,omitempty"`
+	FailureCondition string         `json:"failureCondition,omitempty"`
+	Provider         metricProvider `json:"provider"`
+}
+
+type metricProvider struct {
+	Prometheus *prometheusMetric `json:"prometheus,omitempty"`
+	Datadog    *datadogMetric    `json:"datadog,omitempty"`
+}
+
+type prometheusMetric struct {
+	Address string `json:"address"`
+	Query   string `json:"query"`
+}
+
+type datadogMetric struct {
+	Interval string `json:"interval"`
+	Query    string `json:"query"`
+}
+
+type rolloutAnalysis struct {
+	Templates    []templateRef `json:"templates,omitempty"`
+	Args         []analysisArg `json:"args,omitempty"`
+	StartingStep *int32        `json:"startingStep,omitempty"`
+}
+
+type templateRef struct {
+	TemplateName string `json:"templateName"`
+}
+
+type analysisArg struct {
+	Name      string     `json:"name"`
+	Value     string     `json:"value,omitempty"`
+	ValueFrom *valueFrom `json:"valueFrom,omitempty"`
+}
+
+type valueFrom struct {
+	FieldRef *fieldRef `json:"fieldRef,omitempty"`
+}
+
+type fieldRef struct {
+	FieldPath string `json:"fieldPath"`
+}
+
+type templateKey struct {
+	namespace string
+	name      string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var analysisTemplates AnalysisTemplates
+	if err := pluginconfig.Unmarshal(data, &analysisTemplates); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	nodes, err = transform(&analysisTemplates, nodes)
+	if err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(analysisTemplates *AnalysisTemplates, nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	spec := &analysisTemplates.Spec
+
+	if len(spec.Checks) == 0 {
+		return nil, fmt.Errorf("spec.checks is empty")
+	}
+
+	metrics := make(map[string]*metric, len(spec.Checks))
+	for i := range spec.Checks {
+		check := &spec.Checks[i]
+
+		m, err := makeMetric(spec, check)
+		if err != nil {
+			return nil, fmt.Errorf("spec.checks[%d]: %w", i, err)
+		}
+
+		if _, exists := metrics[check.Name]; exists {
+			return nil, fmt.Errorf("spec.checks[%d]: duplicate check %s", i, check.Name)
+		}
+		metrics[check.Name] = m
+	}
+
+	namespaces := make(map[string]bool)
+	if spec.Rollouts != nil {
+		wired, err := wireRollouts(spec, metrics, nodes)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = wired
+	}
+	if len(namespaces) == 0 {
+		namespaces[analysisTemplates.Namespace] = true
+	}
+
+	existing := make(map[templateKey]bool)
+	for _, node := range nodes {
+		if node.GetKind() == analysisTemplateKind {
+			existing[templateKey{
+				namespace: node.GetNamespace(),
+				name:      node.GetName(),
+			}] = true
+		}
+	}
+
+	for _, namespace := range sortedKeys(namespaces) {
+		for _, check := range spec.Checks {
+			if existing[templateKey{
+				namespace: namespace,
+				name:      check.Name,
+			}] {
+				continue
+			}
+
+			objectMeta := *analysisTemplates.ObjectMeta.DeepCopy()
+			objectMeta.Name = check.Name
+			objectMeta.Namespace = namespace
+
+			b, err := yaml.Marshal(analysisTemplate{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: rolloutsGroupVersion.String(),
+					Kind:       analysisTemplateKind,
+				},
+				ObjectMeta: objectMeta,
+				Spec: analysisTemplateSpec{
+					Args: []argument{
+						{
+							Name: nameArg,
+						},
+						{
+							Name: namespaceArg,
+						},
+					},
+					Metrics: []metric{
+						*metrics[check.Name],
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+
+			node, err := kyaml.Parse(string(b))
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, node)
+		}
+	}
+
+	return nodes, nil
+}
+
+func makeMetric(spec *Spec, check *Check) (*metric, error) {
+	if check.Name == "" {
+		return nil, fmt.Errorf("check without name")
+	}
+
+	if check.Query == "" {
+		return nil, fmt.Errorf("check %s has no query", check.Name)
+	}
+
+	if check.SuccessCondition == "" && check.FailureCondition == "" {
+		return nil, fmt.Errorf("check %s has neither successCondition nor failureCondition", check.Name)
+	}
+
+	m := &metric{
+		Name:             check.Name,
+		Interval:         check.Interval,
+		InitialDelay:     check.InitialDelay,
+		Count:            defaultCount,
+		FailureLimit:     defaultFailureLimit,
+		SuccessCondition: check.SuccessCondition,
+		FailureCondition: check.FailureCondition,
+	}
+	if m.Interval == "" {
+		m.Interval = defaultInterval
+	}
+	if check.Count != nil {
+		m.Count = *check.Count
+	}
+	if check.FailureLimit != nil {
+		m.FailureLimit = *check.FailureLimit
+	}
+
+	switch check.Provider {
+	case prometheusProvider:
+		if spec.Prometheus == nil || spec.Prometheus.Address == "" {
+			return nil, fmt.Errorf("check %s requires spec.prometheus.address", check.Name)
+		}
+
+		m.Provider.Prometheus = &prometheusMetric{
+			Address: spec.Prometheus.Address,
+			Query:   check.Query,
+		}
+	case datadogProvider:
+		interval := defaultDatadogInterval
+		if spec.Datadog != nil && spec.Datadog.Interval != "" {
+			interval = spec.Datadog.Interval
+		}
+
+		m.Provider.Datadog = &datadogMetric{
+			Interval: interval,
+			Query:    check.Query,
+		}
+	default:
+		return nil, fmt.Errorf("check %s has unknown provider %q", check.Name, check.Provider)
+	}
+
+	return m, nil
+}
+
+// wireRollouts adds the checks to the background analysis of canary Rollouts
+// and to the pre-promotion analysis of blue-green ones, returning the
+// namespaces the AnalysisTemplates are needed in.
+func wireRollouts(spec *Spec, metrics map[string]*metric, nodes []*kyaml.RNode) (map[string]bool, error) {
+	rollouts := spec.Rollouts
+
+	if len(rollouts.Names) == 0 && rollouts.Selector == nil {
+		return nil, fmt.Errorf("spec.rollouts.names and spec.rollouts.selector are empty")
+	}
+
+	selector := labels.Nothing()
+	if rollouts.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(rollouts.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("spec.rollouts.selector: %w", err)
+		}
+		selector = s
+	}
+
+	checks := rollouts.Checks
+	if len(checks) == 0 {
+		for _, check := range spec.Checks {
+			checks = append(checks, check.Name)
+		}
+	}
+	for _, check := range checks {
+		if _, exists := metrics[check]; !exists {
+			return nil, fmt.Errorf("spec.rollouts.checks: unknown check %s", check)
+		}
+	}
+
+	namespaces := make(map[string]bool)
+	for _, node := range nodes {
+		if node.GetKind() != rolloutKind {
+			continue
+		}
+
+		if !containsString(rollouts.Names, node.GetName()) && !selector.Matches(labels.Set(node.GetLabels())) {
+			continue
+		}
+
+		canary := true
+		path, field := canaryStrategyPath, canaryAnalysisField
+		strategy, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return nil, err
+		}
+		if strategy == nil {
+			canary = false
+			path, field = blueGreenStrategyPath, blueGreenAnalysisField
+			strategy, err = node.Pipe(kyaml.Lookup(path...))
+			if err != nil {
+				return nil, err
+			}
+		}
+		if strategy == nil {
+			return nil, fmt.Errorf("%s %s has neither canary nor blueGreen strategy", node.GetKind(), node.GetName())
+		}
+
+		var analysis rolloutAnalysis
+		if err := decodeField(strategy, []string{field}, &analysis); err != nil {
+			return nil, err
+		}
+
+		mergeAnalysis(&analysis, node.GetName(), checks)
+		if canary && analysis.StartingStep == nil {
+			analysis.StartingStep = rollouts.StartingStep
+		}
+
+		if err := encodeField(strategy, field, &analysis); err != nil {
+			return nil, err
+		}
+
+		namespaces[node.GetNamespace()] = true
+	}
+
+	return namespaces, nil
+}
+
+// mergeAnalysis keeps the templates and args already referenced by a Rollout,
+// so checks can be added to analyses written by hand.
+func mergeAnalysis(analysis *rolloutAnalysis, name string, checks []string) {
+	for _, check := range checks {
+		referenced := false
+		for _, template := range analysis.Templates {
+			if template.TemplateName == check {
+				referenced = true
+				break
+			}
+		}
+
+		if !referenced {
+			analysis.Templates = append(analysis.Templates, templateRef{
+				TemplateName: check,
+			})
+		}
+	}
+
+	args := []analysisArg{
+		{
+			Name:  nameArg,
+			Value: name,
+		},
+		{
+			Name: namespaceArg,
+			ValueFrom: &valueFrom{
+				FieldRef: &fieldRef{
+					FieldPath: namespaceFieldRef,
+				},
+			},
+		},
+	}
+
+	for _, arg := range args {
+		declared := false
+		for _, existing := range analysis.Args {
+			if existing.Name == arg.Name {
+				declared = true
+				break
+			}
+		}
+
+		if !declared {
+			analysis.Args = append(analysis.Args, arg)
+		}
+	}
+}
+
+func decodeField(node *kyaml.RNode, path []string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
+
+func encodeField(node *kyaml.RNode, field string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	value, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return node.PipeE(kyaml.SetField(field, value))
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
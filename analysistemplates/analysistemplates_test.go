@@ -0,0 +1,224 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/analysistemplates"
+)
+
+const (
+	resources = `
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: api
+  namespace: payments
+  labels:
+    app: api
+spec:
+  strategy:
+    canary:
+      steps:
+        - setWeight: 20
+        - pause: {}
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: worker
+  namespace: payments
+spec:
+  strategy:
+    blueGreen:
+      activeService: worker
+      prePromotionAnalysis:
+        templates:
+          - templateName: smoke-tests
+        args:
+          - name: name
+            value: worker-preview
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: unrelated
+  namespace: other
+spec:
+  strategy:
+    canary: {}
+`
+
+	checks = `
+  prometheus:
+    address: http://prometheus.monitoring:9090
+  checks:
+    - name: success-rate
+      provider: prometheus
+      query: sum(rate(http_requests_total{service="{{args.name}}",code!~"5.."}[5m])) / sum(rate(http_requests_total{service="{{args.name}}"}[5m]))
+      successCondition: result[0] >= 0.95
+    - name: latency
+      provider: datadog
+      query: avg:trace.http.request.duration{service:{{args.name}}}
+      failureCondition: result > 0.5
+      interval: 30s
+      count: 10
+      failureLimit: 2
+`
+)
+
+var _ = ginkgo.Describe("AnalysisTemplates", func() {
+	transform := func(spec string) (map[string]*kyaml.RNode, error) {
+		var out bytes.Buffer
+		if err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: AnalysisTemplates
+metadata:
+  name: analysis-templates
+  namespace: default
+spec:
+`+spec), strings.NewReader(resources), &out); err != nil {
+			return nil, err
+		}
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		byName := make(map[string]*kyaml.RNode)
+		for _, node := range nodes {
+			byName[node.GetKind()+"/"+node.GetNamespace()+"/"+node.GetName()] = node
+		}
+
+		return byName, nil
+	}
+
+	decode := func(node *kyaml.RNode) map[string]interface{} {
+		s, err := node.String()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var object map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(s), &object)).To(g.Succeed())
+
+		return object
+	}
+
+	ginkgo.It("generates AnalysisTemplates and wires them into Rollouts", func() {
+		nodes, err := transform(checks + `
+  rollouts:
+    names: [worker]
+    selector:
+      matchLabels:
+        app: api
+    startingStep: 1
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(nodes).To(g.HaveLen(5))
+
+		g.Expect(decode(nodes["AnalysisTemplate/payments/success-rate"])["spec"]).To(g.Equal(map[string]interface{}{
+			"args": []interface{}{
+				map[string]interface{}{"name": "name"},
+				map[string]interface{}{"name": "namespace"},
+			},
+			"metrics": []interface{}{
+				map[string]interface{}{
+					"name":             "success-rate",
+					"interval":         "1m",
+					"count":            float64(5),
+					"failureLimit":     float64(1),
+					"successCondition": "result[0] >= 0.95",
+					"provider": map[string]interface{}{
+						"prometheus": map[string]interface{}{
+							"address": "http://prometheus.monitoring:9090",
+							"query":   `sum(rate(http_requests_total{service="{{args.name}}",code!~"5.."}[5m])) / sum(rate(http_requests_total{service="{{args.name}}"}[5m]))`,
+						},
+					},
+				},
+			},
+		}))
+		g.Expect(decode(nodes["AnalysisTemplate/payments/latency"])["spec"]).To(g.HaveKeyWithValue("metrics", []interface{}{
+			map[string]interface{}{
+				"name":             "latency",
+				"interval":         "30s",
+				"count":            float64(10),
+				"failureLimit":     float64(2),
+				"failureCondition": "result > 0.5",
+				"provider": map[string]interface{}{
+					"datadog": map[string]interface{}{
+						"interval": "5m",
+						"query":    "avg:trace.http.request.duration{service:{{args.name}}}",
+					},
+				},
+			},
+		}))
+
+		namespaceArg := map[string]interface{}{
+			"name": "namespace",
+			"valueFrom": map[string]interface{}{
+				"fieldRef": map[string]interface{}{
+					"fieldPath": "metadata.namespace",
+				},
+			},
+		}
+
+		canary := decode(nodes["Rollout/payments/api"])["spec"].(map[string]interface{})["strategy"].(map[string]interface{})["canary"].(map[string]interface{})
+		g.Expect(canary["steps"]).To(g.HaveLen(2))
+		g.Expect(canary["analysis"]).To(g.Equal(map[string]interface{}{
+			"templates": []interface{}{
+				map[string]interface{}{"templateName": "success-rate"},
+				map[string]interface{}{"templateName": "latency"},
+			},
+			"args": []interface{}{
+				map[string]interface{}{"name": "name", "value": "api"},
+				namespaceArg,
+			},
+			"startingStep": float64(1),
+		}))
+
+		blueGreen := decode(nodes["Rollout/payments/worker"])["spec"].(map[string]interface{})["strategy"].(map[string]interface{})["blueGreen"].(map[string]interface{})
+		g.Expect(blueGreen["prePromotionAnalysis"]).To(g.Equal(map[string]interface{}{
+			"templates": []interface{}{
+				map[string]interface{}{"templateName": "smoke-tests"},
+				map[string]interface{}{"templateName": "success-rate"},
+				map[string]interface{}{"templateName": "latency"},
+			},
+			"args": []interface{}{
+				map[string]interface{}{"name": "name", "value": "worker-preview"},
+				namespaceArg,
+			},
+		}))
+
+		g.Expect(decode(nodes["Rollout/other/unrelated"])["spec"]).To(g.Equal(map[string]interface{}{
+			"strategy": map[string]interface{}{
+				"canary": map[string]interface{}{},
+			},
+		}))
+	})
+
+	ginkgo.It("generates AnalysisTemplates in its own namespace without Rollouts", func() {
+		nodes, err := transform(checks)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(nodes).To(g.HaveKey("AnalysisTemplate/default/success-rate"))
+		g.Expect(nodes).To(g.HaveKey("AnalysisTemplate/default/latency"))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := transform(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without checks", "", "spec.checks is empty"),
+		ginkgo.Entry("without query", "  checks:\n    - name: a\n      provider: datadog\n", "spec.checks[0]: check a has no query"),
+		ginkgo.Entry("without conditions", "  checks:\n    - name: a\n      provider: datadog\n      query: q\n", "spec.checks[0]: check a has neither successCondition nor failureCondition"),
+		ginkgo.Entry("with unknown provider", "  checks:\n    - name: a\n      provider: newrelic\n      query: q\n      successCondition: \"true\"\n", `spec.checks[0]: check a has unknown provider "newrelic"`),
+		ginkgo.Entry("without prometheus address", "  checks:\n    - name: a\n      provider: prometheus\n      query: q\n      successCondition: \"true\"\n", "spec.checks[0]: check a requires spec.prometheus.address"),
+		ginkgo.Entry("with unknown rollout checks", checks+"  rollouts:\n    names: [api]\n    checks: [errors]\n", "spec.rollouts.checks: unknown check errors"),
+	)
+})
@@ -0,0 +1,457 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	appsetv1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/applicationset/v1alpha1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator      = ": "
+	yamlSeparator       = "---\n"
+	yamlStatusField     = "status"
+	yamlSpecField       = "spec"
+	yamlGeneratorsField = "generators"
+	yamlTemplateField   = "template"
+	nameSeparator       = "-"
+
+	environmentLabel = "incognia.com/environment"
+	teamLabel        = "incognia.com/team"
+	partOfLabel      = "app.kubernetes.io/part-of"
+
+	applicationSetKind    = "ApplicationSet"
+	defaultTargetRevision = "HEAD"
+	inClusterServer       = "https://kubernetes.default.svc"
+
+	clusterNameParam   = "{{name}}"
+	clusterServerParam = "{{server}}"
+	elementNameParam   = "{{name}}"
+	elementNameKey     = "name"
+	directoryNameParam = "{{path.basename}}"
+	directoryPathParam = "{{path}}"
+)
+
+type ApplicationSets struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Project         string           `json:"project,omitempty"`
+	RepoURL         string           `json:"repoURL,omitempty"`
+	TargetRevision  string           `json:"targetRevision,omitempty"`
+	Team            string           `json:"team,omitempty"`
+	ApplicationSets []ApplicationSet `json:"applicationSets,omitempty"`
+}
+
+type ApplicationSet struct {
+	Name       string      `json:"name,omitempty"`
+	Generators []Generator `json:"generators,omitempty"`
+	Path       string      `json:"path,omitempty"`
+	Namespace  string      `json:"namespace,omitempty"`
+	Automated  bool        `json:"automated,omitempty"`
+}
+
+type Generator struct {
+	Clusters    *ClustersGenerator    `json:"clusters,omitempty"`
+	List        []map[string]string   `json:"list,omitempty"`
+	Directories *DirectoriesGenerator `json:"directories,omitempty"`
+	Matrix      []Generator           `json:"matrix,omitempty"`
+}
+
+type ClustersGenerator struct {
+	Environment string            `json:"environment,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+}
+
+type DirectoriesGenerator struct {
+	Paths   []string `json:"paths,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// template accumulates what the generators of an ApplicationSet contribute to
+// the Applications it creates.
+type template struct {
+	nameParams  []string
+	server      string
+	path        string
+	environment string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var applicationSets ApplicationSets
+	if err := pluginconfig.Unmarshal(data, &applicationSets); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&applicationSets)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(applicationSets *ApplicationSets) ([][]byte, error) {
+	spec := &applicationSets.Spec
+
+	if spec.RepoURL == "" {
+		return nil, fmt.Errorf("spec.repoURL is empty")
+	}
+
+	if len(spec.ApplicationSets) == 0 {
+		return nil, fmt.Errorf("spec.applicationSets is empty")
+	}
+
+	manifests := make([][]byte, 0, len(spec.ApplicationSets))
+	for i := range spec.ApplicationSets {
+		applicationSet, err := makeApplicationSet(applicationSets, &spec.ApplicationSets[i])
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := marshalApplicationSet(applicationSet)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+func makeApplicationSet(applicationSets *ApplicationSets, applicationSet *ApplicationSet) (*appsetv1alpha1.ApplicationSet, error) {
+	spec := &applicationSets.Spec
+
+	if applicationSet.Name == "" {
+		return nil, fmt.Errorf("applicationSet without name")
+	}
+
+	if len(applicationSet.Generators) == 0 {
+		return nil, fmt.Errorf("applicationSet %s has no generators", applicationSet.Name)
+	}
+
+	targetRevision := spec.TargetRevision
+	if targetRevision == "" {
+		targetRevision = defaultTargetRevision
+	}
+
+	t := &template{
+		server: inClusterServer,
+		path:   applicationSet.Path,
+	}
+
+	generators := make([]appsetv1alpha1.ApplicationSetGenerator, 0, len(applicationSet.Generators))
+	for _, generator := range applicationSet.Generators {
+		g, err := makeGenerator(applicationSets, applicationSet, &generator, targetRevision, t)
+		if err != nil {
+			return nil, err
+		}
+		generators = append(generators, *g)
+	}
+
+	if t.path == "" {
+		return nil, fmt.Errorf("applicationSet %s has no path", applicationSet.Name)
+	}
+
+	project := spec.Project
+	if project == "" {
+		project = applicationSets.Name
+	}
+
+	namespace := applicationSet.Namespace
+	if namespace == "" {
+		namespace = applicationSet.Name
+	}
+
+	labels := map[string]string{
+		partOfLabel: applicationSet.Name,
+	}
+	if spec.Team != "" {
+		labels[teamLabel] = spec.Team
+	}
+
+	templateLabels := make(map[string]string, len(labels)+1)
+	for key, value := range labels {
+		templateLabels[key] = value
+	}
+	if t.environment != "" {
+		templateLabels[environmentLabel] = t.environment
+	}
+
+	objectMeta := *applicationSets.ObjectMeta.DeepCopy()
+	objectMeta.Name = applicationSet.Name
+	if objectMeta.Labels == nil {
+		objectMeta.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		objectMeta.Labels[key] = value
+	}
+
+	var syncPolicy *argov1alpha1.SyncPolicy
+	if applicationSet.Automated {
+		syncPolicy = &argov1alpha1.SyncPolicy{
+			Automated: &argov1alpha1.SyncPolicyAutomated{
+				Prune:    true,
+				SelfHeal: true,
+			},
+		}
+	}
+
+	return &appsetv1alpha1.ApplicationSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: argov1alpha1.SchemeGroupVersion.String(),
+			Kind:       applicationSetKind,
+		},
+		ObjectMeta: objectMeta,
+		Spec: appsetv1alpha1.ApplicationSetSpec{
+			Generators: generators,
+			Template: appsetv1alpha1.ApplicationSetTemplate{
+				ApplicationSetTemplateMeta: appsetv1alpha1.ApplicationSetTemplateMeta{
+					Name:   strings.Join(append([]string{applicationSet.Name}, t.nameParams...), nameSeparator),
+					Labels: templateLabels,
+				},
+				Spec: argov1alpha1.ApplicationSpec{
+					Project: project,
+					Source: argov1alpha1.ApplicationSource{
+						RepoURL:        spec.RepoURL,
+						TargetRevision: targetRevision,
+						Path:           t.path,
+					},
+					Destination: argov1alpha1.ApplicationDestination{
+						Server:    t.server,
+						Namespace: namespace,
+					},
+					SyncPolicy: syncPolicy,
+				},
+			},
+			// Deleting an ApplicationSet must not cascade to the workloads it
+			// deployed, which are only removed by deleting their Applications.
+			SyncPolicy: &appsetv1alpha1.ApplicationSetSyncPolicy{
+				PreserveResourcesOnDeletion: true,
+			},
+		},
+	}, nil
+}
+
+func makeGenerator(applicationSets *ApplicationSets, applicationSet *ApplicationSet, generator *Generator, targetRevision string, t *template) (*appsetv1alpha1.ApplicationSetGenerator, error) {
+	if len(generator.Matrix) == 0 {
+		nested, err := makeNestedGenerator(applicationSets, applicationSet, generator, targetRevision, t)
+		if err != nil {
+			return nil, err
+		}
+
+		return &appsetv1alpha1.ApplicationSetGenerator{
+			List:     nested.List,
+			Clusters: nested.Clusters,
+			Git:      nested.Git,
+		}, nil
+	}
+
+	if generator.Clusters != nil || generator.List != nil || generator.Directories != nil {
+		return nil, fmt.Errorf("generator of applicationSet %s must define exactly one of clusters, list, directories or matrix", applicationSet.Name)
+	}
+
+	// Argo CD combines exactly two generators in a matrix, so one matrix is
+	// not nested within another in order to keep parameters predictable.
+	if len(generator.Matrix) != 2 {
+		return nil, fmt.Errorf("matrix of applicationSet %s must combine exactly 2 generators", applicationSet.Name)
+	}
+
+	// Both clusters and list elements set the name parameter, and Argo CD
+	// rejects matrices whose generators set the same parameter.
+	if generator.Matrix[0].Clusters != nil && generator.Matrix[1].List != nil || generator.Matrix[0].List != nil && generator.Matrix[1].Clusters != nil {
+		return nil, fmt.Errorf("matrix of applicationSet %s must not combine clusters and list", applicationSet.Name)
+	}
+
+	nestedGenerators := make([]appsetv1alpha1.ApplicationSetNestedGenerator, 0, len(generator.Matrix))
+	for i := range generator.Matrix {
+		if len(generator.Matrix[i].Matrix) > 0 {
+			return nil, fmt.Errorf("matrix of applicationSet %s must not contain another matrix", applicationSet.Name)
+		}
+
+		nested, err := makeNestedGenerator(applicationSets, applicationSet, &generator.Matrix[i], targetRevision, t)
+		if err != nil {
+			return nil, err
+		}
+		nestedGenerators = append(nestedGenerators, *nested)
+	}
+
+	return &appsetv1alpha1.ApplicationSetGenerator{
+		Matrix: &appsetv1alpha1.MatrixGenerator{
+			Generators: nestedGenerators,
+		},
+	}, nil
+}
+
+func makeNestedGenerator(applicationSets *ApplicationSets, applicationSet *ApplicationSet, generator *Generator, targetRevision string, t *template) (*appsetv1alpha1.ApplicationSetNestedGenerator, error) {
+	count := 0
+	for _, set := range []bool{generator.Clusters != nil, generator.List != nil, generator.Directories != nil} {
+		if set {
+			count++
+		}
+	}
+	if count != 1 {
+		return nil, fmt.Errorf("generator of applicationSet %s must define exactly one of clusters, list, directories or matrix", applicationSet.Name)
+	}
+
+	switch {
+	case generator.Clusters != nil:
+		matchLabels := make(map[string]string, len(generator.Clusters.Labels)+1)
+		for key, value := range generator.Clusters.Labels {
+			matchLabels[key] = value
+		}
+		if environment := generator.Clusters.Environment; environment != "" {
+			matchLabels[environmentLabel] = environment
+			t.environment = environment
+		}
+
+		t.addNameParam(clusterNameParam)
+		t.server = clusterServerParam
+
+		return &appsetv1alpha1.ApplicationSetNestedGenerator{
+			Clusters: &appsetv1alpha1.ClusterGenerator{
+				Selector: metav1.LabelSelector{
+					MatchLabels: matchLabels,
+				},
+			},
+		}, nil
+	case generator.List != nil:
+		elements := make([]apiextensionsv1.JSON, 0, len(generator.List))
+		for _, element := range generator.List {
+			if element[elementNameKey] == "" {
+				return nil, fmt.Errorf("list element of applicationSet %s has no %s", applicationSet.Name, elementNameKey)
+			}
+
+			b, err := json.Marshal(element)
+			if err != nil {
+				return nil, err
+			}
+			elements = append(elements, apiextensionsv1.JSON{
+				Raw: b,
+			})
+		}
+
+		t.addNameParam(elementNameParam)
+
+		return &appsetv1alpha1.ApplicationSetNestedGenerator{
+			List: &appsetv1alpha1.ListGenerator{
+				Elements: elements,
+			},
+		}, nil
+	default:
+		if len(generator.Directories.Paths) == 0 {
+			return nil, fmt.Errorf("directories of applicationSet %s have no paths", applicationSet.Name)
+		}
+
+		directories := make([]appsetv1alpha1.GitDirectoryGeneratorItem, 0, len(generator.Directories.Paths)+len(generator.Directories.Exclude))
+		for _, path := range generator.Directories.Paths {
+			directories = append(directories, appsetv1alpha1.GitDirectoryGeneratorItem{
+				Path: path,
+			})
+		}
+		for _, path := range generator.Directories.Exclude {
+			directories = append(directories, appsetv1alpha1.GitDirectoryGeneratorItem{
+				Path:    path,
+				Exclude: true,
+			})
+		}
+
+		t.addNameParam(directoryNameParam)
+		if t.path == "" {
+			t.path = directoryPathParam
+		}
+
+		return &appsetv1alpha1.ApplicationSetNestedGenerator{
+			Git: &appsetv1alpha1.GitGenerator{
+				RepoURL:     applicationSets.Spec.RepoURL,
+				Revision:    targetRevision,
+				Directories: directories,
+			},
+		}, nil
+	}
+}
+
+func (t *template) addNameParam(param string) {
+	for _, nameParam := range t.nameParams {
+		if nameParam == param {
+			return
+		}
+	}
+
+	t.nameParams = append(t.nameParams, param)
+}
+
+func marshalApplicationSet(applicationSet *appsetv1alpha1.ApplicationSet) ([]byte, error) {
+	b, err := json.Marshal(applicationSet)
+	if err != nil {
+		return nil, err
+	}
+
+	var vm map[string]interface{}
+	if err := json.Unmarshal(b, &vm); err != nil {
+		return nil, err
+	}
+
+	delete(vm, yamlStatusField)
+
+	if spec, ok := vm[yamlSpecField].(map[string]interface{}); ok {
+		deleteGeneratorTemplates(spec)
+	}
+
+	return yaml.Marshal(vm)
+}
+
+// deleteGeneratorTemplates removes the templates of generators, which are
+// never overridden by this plugin but are always written by the Argo CD types.
+func deleteGeneratorTemplates(vm map[string]interface{}) {
+	generators, _ := vm[yamlGeneratorsField].([]interface{})
+	for _, generator := range generators {
+		generator, _ := generator.(map[string]interface{})
+		for _, value := range generator {
+			value, ok := value.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			delete(value, yamlTemplateField)
+			deleteGeneratorTemplates(value)
+		}
+	}
+}
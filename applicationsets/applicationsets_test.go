@@ -0,0 +1,191 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/applicationsets"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ApplicationSets", func() {
+	generate := func(spec string) ([]map[string]interface{}, error) {
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ApplicationSets
+metadata:
+  name: employees
+  namespace: argocd
+spec:
+  repoURL: https://github.com/inloco/employees.git
+  team: people
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		var applicationSets []map[string]interface{}
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var applicationSet map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(manifest), &applicationSet)).To(g.Succeed())
+			applicationSets = append(applicationSets, applicationSet)
+		}
+
+		return applicationSets, nil
+	}
+
+	ginkgo.It("generates ApplicationSets with clusters generators", func() {
+		applicationSets, err := generate(`
+  applicationSets:
+    - name: api
+      path: k8s/overlays/staging
+      automated: true
+      generators:
+        - clusters:
+            environment: staging
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(applicationSets).To(g.HaveLen(1))
+
+		g.Expect(applicationSets[0]["apiVersion"]).To(g.Equal("argoproj.io/v1alpha1"))
+		g.Expect(applicationSets[0]["kind"]).To(g.Equal("ApplicationSet"))
+		g.Expect(applicationSets[0]["metadata"]).To(g.HaveKeyWithValue("name", "api"))
+		g.Expect(applicationSets[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "argocd"))
+		g.Expect(applicationSets[0]["metadata"]).To(g.HaveKeyWithValue("labels", map[string]interface{}{
+			"app.kubernetes.io/part-of": "api",
+			"incognia.com/team":         "people",
+		}))
+		g.Expect(applicationSets[0]).NotTo(g.HaveKey("status"))
+		g.Expect(applicationSets[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"generators": []interface{}{
+				map[string]interface{}{
+					"clusters": map[string]interface{}{
+						"selector": map[string]interface{}{
+							"matchLabels": map[string]interface{}{
+								"incognia.com/environment": "staging",
+							},
+						},
+					},
+				},
+			},
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"name": "api-{{name}}",
+					"labels": map[string]interface{}{
+						"app.kubernetes.io/part-of": "api",
+						"incognia.com/environment":  "staging",
+						"incognia.com/team":         "people",
+					},
+				},
+				"spec": map[string]interface{}{
+					"project": "employees",
+					"source": map[string]interface{}{
+						"repoURL":        "https://github.com/inloco/employees.git",
+						"targetRevision": "HEAD",
+						"path":           "k8s/overlays/staging",
+					},
+					"destination": map[string]interface{}{
+						"server":    "{{server}}",
+						"namespace": "api",
+					},
+					"syncPolicy": map[string]interface{}{
+						"automated": map[string]interface{}{
+							"prune":    true,
+							"selfHeal": true,
+						},
+					},
+				},
+			},
+			"syncPolicy": map[string]interface{}{
+				"preserveResourcesOnDeletion": true,
+			},
+		}))
+	})
+
+	ginkgo.It("combines git directories and clusters in a matrix", func() {
+		applicationSets, err := generate(`
+  targetRevision: main
+  applicationSets:
+    - name: jobs
+      namespace: batch
+      generators:
+        - matrix:
+            - directories:
+                paths: [jobs/*]
+                exclude: [jobs/deprecated]
+            - clusters:
+                labels:
+                  incognia.com/batch: "true"
+    - name: tools
+      path: tools/{{name}}
+      generators:
+        - list:
+            - name: grafana
+            - name: prometheus
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(applicationSets).To(g.HaveLen(2))
+
+		spec := applicationSets[0]["spec"].(map[string]interface{})
+		matrix := spec["generators"].([]interface{})[0].(map[string]interface{})["matrix"].(map[string]interface{})
+		nested := matrix["generators"].([]interface{})
+		g.Expect(nested).To(g.HaveLen(2))
+		g.Expect(nested[0].(map[string]interface{})["git"]).To(g.Equal(map[string]interface{}{
+			"repoURL":  "https://github.com/inloco/employees.git",
+			"revision": "main",
+			"directories": []interface{}{
+				map[string]interface{}{"path": "jobs/*"},
+				map[string]interface{}{"path": "jobs/deprecated", "exclude": true},
+			},
+		}))
+		g.Expect(nested[1].(map[string]interface{})["clusters"]).To(g.HaveKeyWithValue("selector", map[string]interface{}{
+			"matchLabels": map[string]interface{}{
+				"incognia.com/batch": "true",
+			},
+		}))
+
+		template := spec["template"].(map[string]interface{})
+		g.Expect(template["metadata"]).To(g.HaveKeyWithValue("name", "jobs-{{path.basename}}-{{name}}"))
+		g.Expect(template["spec"]).To(g.HaveKeyWithValue("source", map[string]interface{}{
+			"repoURL":        "https://github.com/inloco/employees.git",
+			"targetRevision": "main",
+			"path":           "{{path}}",
+		}))
+		g.Expect(template["spec"]).To(g.HaveKeyWithValue("destination", map[string]interface{}{
+			"server":    "{{server}}",
+			"namespace": "batch",
+		}))
+
+		spec = applicationSets[1]["spec"].(map[string]interface{})
+		g.Expect(spec["generators"].([]interface{})[0].(map[string]interface{})["list"]).To(g.HaveKeyWithValue("elements", []interface{}{
+			map[string]interface{}{"name": "grafana"},
+			map[string]interface{}{"name": "prometheus"},
+		}))
+		template = spec["template"].(map[string]interface{})
+		g.Expect(template["metadata"]).To(g.HaveKeyWithValue("name", "tools-{{name}}"))
+		g.Expect(template["spec"]).To(g.HaveKeyWithValue("destination", map[string]interface{}{
+			"server":    "https://kubernetes.default.svc",
+			"namespace": "tools",
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := generate(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without applicationSets", "", "spec.applicationSets is empty"),
+		ginkgo.Entry("without generators", "  applicationSets:\n    - name: api\n", "applicationSet api has no generators"),
+		ginkgo.Entry("without path", "  applicationSets:\n    - name: api\n      generators:\n        - clusters: {}\n", "applicationSet api has no path"),
+		ginkgo.Entry("with ambiguous generators", "  applicationSets:\n    - name: api\n      path: api\n      generators:\n        - clusters: {}\n          list: [{name: api}]\n", "generator of applicationSet api must define exactly one of clusters, list, directories or matrix"),
+		ginkgo.Entry("with unnamed list elements", "  applicationSets:\n    - name: api\n      path: api\n      generators:\n        - list: [{region: us}]\n", "list element of applicationSet api has no name"),
+		ginkgo.Entry("with matrix of 3 generators", "  applicationSets:\n    - name: api\n      path: api\n      generators:\n        - matrix: [{clusters: {}}, {directories: {paths: [a]}}, {directories: {paths: [b]}}]\n", "matrix of applicationSet api must combine exactly 2 generators"),
+		ginkgo.Entry("with matrix of clusters and list", "  applicationSets:\n    - name: api\n      path: api\n      generators:\n        - matrix: [{clusters: {}}, {list: [{name: a}]}]\n", "matrix of applicationSet api must not combine clusters and list"),
+	)
+})
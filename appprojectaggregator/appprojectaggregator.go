@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	pathSeparator  = "."
+	specField      = "spec"
+
+	argocdProjectKind = "ArgoCDProject"
+)
+
+type AppProjectAggregator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Fragments []string `json:"fragments,omitempty"`
+}
+
+var (
+	// listKeys identifies the elements of lists merged by a key rather than
+	// by equality, so fragments may extend the same role or application.
+	listKeys = map[string][]string{
+		"spec.appProjectTemplate.spec.roles": {"name"},
+		"spec.applicationTemplates":          {"metadata", "name"},
+	}
+)
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var appProjectAggregator AppProjectAggregator
+	if err := pluginconfig.Unmarshal(data, &appProjectAggregator); err != nil {
+		return err
+	}
+
+	return makeManifests(&appProjectAggregator, out)
+}
+
+// makeManifests generates the manifests of each merged project as the
+// ArgoCDProject plugin does.
+func makeManifests(appProjectAggregator *AppProjectAggregator, out io.Writer) error {
+	projects, err := mergeFragments(appProjectAggregator.Spec.Fragments)
+	if err != nil {
+		return err
+	}
+
+	for _, argocdProject := range projects {
+		data, err := yaml.Marshal(argocdProject)
+		if err != nil {
+			return err
+		}
+
+		if err := argocdproject.GenerateManifestsWithOptions(data, argocdproject.Options{}, out); err != nil {
+			return fmt.Errorf("project %s: %w", argocdProject.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// mergeFragments merges the fragments targeting the same project, in the order
+// they are first found, failing when they set a field to different values.
+func mergeFragments(patterns []string) ([]*argocdproject.ArgoCDProject, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("spec.fragments is empty")
+	}
+
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("%s matches no fragments", pattern)
+		}
+
+		for _, match := range matches {
+			if !containsString(paths, match) {
+				paths = append(paths, match)
+			}
+		}
+	}
+
+	var names []string
+	specs := make(map[string]map[string]interface{})
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		var fragment argocdproject.ArgoCDProject
+		if err := yaml.Unmarshal(data, &fragment); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		if fragment.Name == "" {
+			return nil, fmt.Errorf("%s has no project name", path)
+		}
+
+		spec, err := toMap(&fragment.Spec)
+		if err != nil {
+			return nil, err
+		}
+
+		merged, exists := specs[fragment.Name]
+		if !exists {
+			names = append(names, fragment.Name)
+			specs[fragment.Name] = spec
+			continue
+		}
+
+		if _, err := merge(specField, merged, spec); err != nil {
+			return nil, fmt.Errorf("project %s: %s conflicts with previous fragments on %w", fragment.Name, path, err)
+		}
+	}
+
+	projects := make([]*argocdproject.ArgoCDProject, 0, len(names))
+	for _, name := range names {
+		b, err := json.Marshal(specs[name])
+		if err != nil {
+			return nil, err
+		}
+
+		argocdProject := &argocdproject.ArgoCDProject{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: pluginconfig.APIVersions[0],
+				Kind:       argocdProjectKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: name,
+			},
+		}
+		if err := json.Unmarshal(b, &argocdProject.Spec); err != nil {
+			return nil, err
+		}
+		projects = append(projects, argocdProject)
+	}
+
+	return projects, nil
+}
+
+// merge merges src into dst, which is modified in place unless it is a list.
+// Maps are merged key by key, lists are merged by the keys of listKeys or else
+// by equality, and scalars must be equal.
+func merge(path string, dst interface{}, src interface{}) (interface{}, error) {
+	switch dst := dst.(type) {
+	case map[string]interface{}:
+		src, ok := src.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s", path)
+		}
+
+		for _, key := range sortedKeys(src) {
+			value := src[key]
+			existing, exists := dst[key]
+			if !exists {
+				dst[key] = value
+				continue
+			}
+
+			merged, err := merge(joinPath(path, key), existing, value)
+			if err != nil {
+				return nil, err
+			}
+			dst[key] = merged
+		}
+
+		return dst, nil
+	case []interface{}:
+		src, ok := src.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s", path)
+		}
+
+		keyPath, keyed := listKeys[path]
+		for _, value := range src {
+			found := false
+			for i, existing := range dst {
+				if !keyed {
+					if reflect.DeepEqual(existing, value) {
+						found = true
+						break
+					}
+					continue
+				}
+
+				key := lookupKey(value, keyPath)
+				if key == "" || key != lookupKey(existing, keyPath) {
+					continue
+				}
+
+				merged, err := merge(fmt.Sprintf("%s[%s]", path, key), existing, value)
+				if err != nil {
+					return nil, err
+				}
+				dst[i] = merged
+				found = true
+				break
+			}
+
+			if !found {
+				dst = append(dst, value)
+			}
+		}
+
+		return dst, nil
+	default:
+		if !reflect.DeepEqual(dst, src) {
+			return nil, fmt.Errorf("%s", path)
+		}
+
+		return dst, nil
+	}
+}
+
+func lookupKey(v interface{}, keyPath []string) string {
+	for _, field := range keyPath {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		v = m[field]
+	}
+
+	key, _ := v.(string)
+	return key
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + pathSeparator + key
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var vm map[string]interface{}
+	if err := json.Unmarshal(b, &vm); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,219 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/appprojectaggregator"
+)
+
+const (
+	payments = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    ReadOnly: [sre:eng-2]
+    ReadSync: [payments:eng-0]
+  environment: production
+  appProjectTemplate:
+    spec:
+      description: Employees services
+      clusterResourceBlacklist:
+        - group: ""
+          kind: Secret
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Product
+          namespace: payroll
+`
+
+	benefits = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    ReadOnly: [sre:eng-2]
+    ReadSync: [benefits:eng-0]
+  environment: production
+  appProjectTemplate:
+    spec:
+      clusterResourceBlacklist:
+        - group: ""
+          kind: Secret
+        - group: rbac.authorization.k8s.io
+          kind: ClusterRole
+  applicationTemplates:
+    - metadata:
+        name: benefits
+      spec:
+        source:
+          repoURL: https://github.com/inloco/benefits.git
+        destination:
+          name: Global-Product
+          namespace: benefits
+`
+
+	checker = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: github-checker
+spec:
+  applicationTemplates:
+    - metadata:
+        name: github-checker
+      spec:
+        source:
+          repoURL: https://github.com/inloco/github-checker.git
+        destination:
+          name: Global-SRE
+          namespace: github-checker
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("AppProjectAggregator", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "appprojectaggregator")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+
+		for name, fragment := range map[string]string{
+			"payments.argoCDProject.yaml": payments,
+			"benefits.argoCDProject.yaml": benefits,
+			"checker.yaml":                checker,
+		} {
+			g.Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(fragment), 0644)).To(g.Succeed())
+		}
+	})
+
+	generate := func(fragments ...string) ([]string, error) {
+		spec := "spec:\n  fragments:\n"
+		for _, fragment := range fragments {
+			spec += "    - " + filepath.Join(dir, fragment) + "\n"
+		}
+
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: AppProjectAggregator
+metadata:
+  name: projects
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		return separatorYaml.Split(out.String(), -1), nil
+	}
+
+	ginkgo.It("merges fragments of the same project", func() {
+		manifests, err := generate("*.argoCDProject.yaml", "checker.yaml")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(manifests).To(g.HaveLen(5))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Name).To(g.Equal("employees"))
+		g.Expect(appProject.Spec.Description).To(g.Equal("Employees services"))
+		g.Expect(appProject.Spec.ClusterResourceBlacklist).To(g.HaveLen(2))
+		g.Expect(appProject.Spec.Destinations).To(g.Equal([]argov1alpha1.ApplicationDestination{
+			{
+				Name:      "Global-Product",
+				Namespace: "benefits",
+			},
+			{
+				Name:      "Global-Product",
+				Namespace: "payroll",
+			},
+		}))
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(2))
+		g.Expect(appProject.Spec.Roles[0].Groups).To(g.Equal([]string{"sre:eng-2"}))
+		g.Expect(appProject.Spec.Roles[1].Groups).To(g.Equal([]string{"benefits:eng-0", "payments:eng-0"}))
+
+		var apps []argov1alpha1.Application
+		for _, manifest := range manifests[1:] {
+			var app argov1alpha1.Application
+			g.Expect(yaml.Unmarshal([]byte(manifest), &app)).To(g.Succeed())
+			apps = append(apps, app)
+		}
+		g.Expect(apps[0].Name).To(g.Equal("benefits"))
+		g.Expect(apps[0].Spec.Project).To(g.Equal("employees"))
+		g.Expect(apps[0].Spec.Source.TargetRevision).To(g.Equal("env-production"))
+		g.Expect(apps[1].Name).To(g.Equal("payroll"))
+		g.Expect(apps[1].Spec.Project).To(g.Equal("employees"))
+		g.Expect(apps[2].Kind).To(g.Equal("AppProject"))
+		g.Expect(apps[2].Name).To(g.Equal("github-checker"))
+		g.Expect(apps[3].Name).To(g.Equal("github-checker"))
+		g.Expect(apps[3].Spec.Project).To(g.Equal("github-checker"))
+	})
+
+	ginkgo.It("fails on conflicting fragments", func() {
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "payroll.yaml"), []byte(`
+metadata:
+  name: employees
+spec:
+  environment: staging
+`), 0644)).To(g.Succeed())
+
+		_, err := generate("payments.argoCDProject.yaml", "payroll.yaml")
+		g.Expect(err).To(g.MatchError("project employees: " + filepath.Join(dir, "payroll.yaml") + " conflicts with previous fragments on spec.environment"))
+	})
+
+	ginkgo.It("fails on conflicting applications", func() {
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "payroll.yaml"), []byte(`
+metadata:
+  name: employees
+spec:
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        destination:
+          namespace: payroll-v2
+`), 0644)).To(g.Succeed())
+
+		_, err := generate("payments.argoCDProject.yaml", "payroll.yaml")
+		g.Expect(err).To(g.MatchError("project employees: " + filepath.Join(dir, "payroll.yaml") + " conflicts with previous fragments on spec.applicationTemplates[payroll].spec.destination.namespace"))
+	})
+
+	ginkgo.It("validates merged projects as the ArgoCDProject plugin does", func() {
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "payroll.yaml"), []byte(`
+metadata:
+  name: github-checker
+spec:
+  environment: ../production
+`), 0644)).To(g.Succeed())
+
+		_, err := generate("checker.yaml", "payroll.yaml")
+		g.Expect(err).To(g.HaveOccurred())
+		g.Expect(err.Error()).To(g.HavePrefix("project github-checker: "))
+	})
+
+	ginkgo.It("fails on patterns matching no fragments", func() {
+		_, err := generate("missing/*.yaml")
+		g.Expect(err).To(g.MatchError(filepath.Join(dir, "missing/*.yaml") + " matches no fragments"))
+	})
+})
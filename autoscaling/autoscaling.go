@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	defaultKind = "Deployment"
+)
+
+var (
+	scaleTargetGroupVersions = map[string]schema.GroupVersion{
+		"Deployment": schema.GroupVersion{
+			Group:   "apps",
+			Version: "v1",
+		},
+		"StatefulSet": schema.GroupVersion{
+			Group:   "apps",
+			Version: "v1",
+		},
+		"Rollout": schema.GroupVersion{
+			Group:   "argoproj.io",
+			Version: "v1alpha1",
+		},
+	}
+)
+
+type Autoscaling struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Targets []Target `json:"targets,omitempty"`
+}
+
+type Target struct {
+	Kind    string  `json:"kind,omitempty"`
+	Name    string  `json:"name,omitempty"`
+	Scaling Scaling `json:"scaling,omitempty"`
+}
+
+type Scaling struct {
+	Min           int32          `json:"min,omitempty"`
+	Max           int32          `json:"max,omitempty"`
+	CPU           int32          `json:"cpu,omitempty"`
+	Memory        int32          `json:"memory,omitempty"`
+	CustomMetrics []CustomMetric `json:"customMetrics,omitempty"`
+}
+
+type CustomMetric struct {
+	Name     string                         `json:"name,omitempty"`
+	Type     autoscalingv2.MetricSourceType `json:"type,omitempty"`
+	Selector *metav1.LabelSelector          `json:"selector,omitempty"`
+	Average  *resource.Quantity             `json:"average,omitempty"`
+	Value    *resource.Quantity             `json:"value,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var autoscaling Autoscaling
+	if err := pluginconfig.Unmarshal(data, &autoscaling); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&autoscaling)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(autoscaling *Autoscaling) ([][]byte, error) {
+	names := make(map[string]bool)
+	manifests := make([][]byte, 0, len(autoscaling.Spec.Targets))
+
+	for _, target := range autoscaling.Spec.Targets {
+		if target.Kind == "" {
+			target.Kind = defaultKind
+		}
+
+		if target.Name == "" {
+			return nil, fmt.Errorf("target without name")
+		}
+
+		if names[target.Name] {
+			return nil, fmt.Errorf("target %s is duplicated", target.Name)
+		}
+		names[target.Name] = true
+
+		b, err := makeHorizontalPodAutoscaler(autoscaling, &target)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+func makeHorizontalPodAutoscaler(autoscaling *Autoscaling, target *Target) ([]byte, error) {
+	groupVersion, exists := scaleTargetGroupVersions[target.Kind]
+	if !exists {
+		return nil, fmt.Errorf("target %s has unsupported kind %s", target.Name, target.Kind)
+	}
+
+	if target.Scaling.Max < 1 {
+		return nil, fmt.Errorf("target %s has no max", target.Name)
+	}
+
+	minReplicas := target.Scaling.Min
+	if minReplicas == 0 {
+		minReplicas = 1
+	}
+	if minReplicas > target.Scaling.Max {
+		return nil, fmt.Errorf("target %s has min greater than max", target.Name)
+	}
+
+	metrics, err := makeMetrics(target)
+	if err != nil {
+		return nil, err
+	}
+
+	objectMeta := *autoscaling.ObjectMeta.DeepCopy()
+	objectMeta.Name = target.Name
+
+	horizontalPodAutoscaler := autoscalingv2.HorizontalPodAutoscaler{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: autoscalingv2.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(autoscalingv2.HorizontalPodAutoscaler{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: groupVersion.String(),
+				Kind:       target.Kind,
+				Name:       target.Name,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: target.Scaling.Max,
+			Metrics:     metrics,
+		},
+	}
+
+	return yaml.Marshal(horizontalPodAutoscaler)
+}
+
+func makeMetrics(target *Target) ([]autoscalingv2.MetricSpec, error) {
+	var metrics []autoscalingv2.MetricSpec
+
+	if target.Scaling.CPU > 0 {
+		metrics = append(metrics, makeResourceMetric(corev1.ResourceCPU, target.Scaling.CPU))
+	}
+
+	if target.Scaling.Memory > 0 {
+		metrics = append(metrics, makeResourceMetric(corev1.ResourceMemory, target.Scaling.Memory))
+	}
+
+	for _, customMetric := range target.Scaling.CustomMetrics {
+		metric, err := makeCustomMetric(target, &customMetric)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+
+	if len(metrics) == 0 {
+		return nil, fmt.Errorf("target %s has no metrics", target.Name)
+	}
+
+	return metrics, nil
+}
+
+func makeResourceMetric(name corev1.ResourceName, utilization int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &utilization,
+			},
+		},
+	}
+}
+
+func makeCustomMetric(target *Target, customMetric *CustomMetric) (autoscalingv2.MetricSpec, error) {
+	if customMetric.Name == "" {
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("target %s has a custom metric without name", target.Name)
+	}
+
+	identifier := autoscalingv2.MetricIdentifier{
+		Name:     customMetric.Name,
+		Selector: customMetric.Selector,
+	}
+
+	switch customMetric.Type {
+	case autoscalingv2.PodsMetricSourceType, "":
+		if customMetric.Average == nil {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("target %s: pods metric %s has no average", target.Name, customMetric.Name)
+		}
+
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.PodsMetricSourceType,
+			Pods: &autoscalingv2.PodsMetricSource{
+				Metric: identifier,
+				Target: autoscalingv2.MetricTarget{
+					Type:         autoscalingv2.AverageValueMetricType,
+					AverageValue: customMetric.Average,
+				},
+			},
+		}, nil
+	case autoscalingv2.ExternalMetricSourceType:
+		metricTarget := autoscalingv2.MetricTarget{
+			Type:         autoscalingv2.AverageValueMetricType,
+			AverageValue: customMetric.Average,
+		}
+		if customMetric.Value != nil {
+			metricTarget = autoscalingv2.MetricTarget{
+				Type:  autoscalingv2.ValueMetricType,
+				Value: customMetric.Value,
+			}
+		}
+		if customMetric.Average == nil && customMetric.Value == nil {
+			return autoscalingv2.MetricSpec{}, fmt.Errorf("target %s: external metric %s has no average or value", target.Name, customMetric.Name)
+		}
+
+		return autoscalingv2.MetricSpec{
+			Type: autoscalingv2.ExternalMetricSourceType,
+			External: &autoscalingv2.ExternalMetricSource{
+				Metric: identifier,
+				Target: metricTarget,
+			},
+		}, nil
+	default:
+		return autoscalingv2.MetricSpec{}, fmt.Errorf("target %s: custom metric %s has unsupported type %s", target.Name, customMetric.Name, customMetric.Type)
+	}
+}
@@ -0,0 +1,96 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/autoscaling"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("Autoscaling", func() {
+	ginkgo.It("generates HPAs for each target", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Autoscaling
+metadata:
+  name: autoscaling
+  namespace: my-namespace
+spec:
+  targets:
+    - name: my-app
+      scaling:
+        min: 2
+        max: 10
+        cpu: 70
+        memory: 80
+    - kind: Rollout
+      name: my-rollout
+      scaling:
+        max: 5
+        customMetrics:
+          - name: requests_per_second
+            average: "100"
+          - name: queue_depth
+            type: External
+            value: "30"
+`), &out)).To(g.Succeed())
+
+		hpas := make(map[string]autoscalingv2.HorizontalPodAutoscaler)
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var hpa autoscalingv2.HorizontalPodAutoscaler
+			g.Expect(yaml.Unmarshal([]byte(manifest), &hpa)).To(g.Succeed())
+			g.Expect(hpa.APIVersion).To(g.Equal("autoscaling/v2"))
+			g.Expect(hpa.Namespace).To(g.Equal("my-namespace"))
+			hpas[hpa.Name] = hpa
+		}
+		g.Expect(hpas).To(g.HaveLen(2))
+
+		myApp := hpas["my-app"].Spec
+		g.Expect(myApp.ScaleTargetRef).To(g.Equal(autoscalingv2.CrossVersionObjectReference{
+			APIVersion: "apps/v1",
+			Kind:       "Deployment",
+			Name:       "my-app",
+		}))
+		g.Expect(*myApp.MinReplicas).To(g.Equal(int32(2)))
+		g.Expect(myApp.MaxReplicas).To(g.Equal(int32(10)))
+		g.Expect(myApp.Metrics).To(g.HaveLen(2))
+		g.Expect(myApp.Metrics[0].Resource.Name).To(g.Equal(corev1.ResourceCPU))
+		g.Expect(*myApp.Metrics[0].Resource.Target.AverageUtilization).To(g.Equal(int32(70)))
+
+		myRollout := hpas["my-rollout"].Spec
+		g.Expect(myRollout.ScaleTargetRef.APIVersion).To(g.Equal("argoproj.io/v1alpha1"))
+		g.Expect(*myRollout.MinReplicas).To(g.Equal(int32(1)))
+		g.Expect(myRollout.Metrics).To(g.HaveLen(2))
+		g.Expect(myRollout.Metrics[0].Pods.Target.AverageValue.Equal(resource.MustParse("100"))).To(g.BeTrue())
+		g.Expect(myRollout.Metrics[1].External.Target.Type).To(g.Equal(autoscalingv2.ValueMetricType))
+	})
+
+	ginkgo.DescribeTable("fails", func(target string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Autoscaling
+metadata:
+  name: autoscaling
+spec:
+  targets:
+    - `+target), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without metrics", "{name: my-app, scaling: {max: 3}}", "target my-app has no metrics"),
+		ginkgo.Entry("without max", "{name: my-app, scaling: {cpu: 70}}", "target my-app has no max"),
+		ginkgo.Entry("with min greater than max", "{name: my-app, scaling: {min: 4, max: 3, cpu: 70}}", "target my-app has min greater than max"),
+		ginkgo.Entry("with unsupported kind", "{kind: DaemonSet, name: my-app, scaling: {max: 3, cpu: 70}}", "target my-app has unsupported kind DaemonSet"),
+	)
+})
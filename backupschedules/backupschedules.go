@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	scheduleKind           = "Schedule"
+	defaultVeleroNamespace = "velero"
+	defaultStorageLocation = "default"
+	cronFields             = 5
+	cronDescriptorPrefix   = "@"
+	daySuffix              = "d"
+)
+
+var (
+	veleroGroupVersion = schema.GroupVersion{
+		Group:   "velero.io",
+		Version: "v1",
+	}
+)
+
+type BackupSchedules struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	VeleroNamespace string      `json:"veleroNamespace,omitempty"`
+	StorageLocation string      `json:"storageLocation,omitempty"`
+	Namespaces      []string    `json:"namespaces,omitempty"`
+	SnapshotVolumes *bool       `json:"snapshotVolumes,omitempty"`
+	Retention       []Retention `json:"retention,omitempty"`
+}
+
+type Retention struct {
+	Name            string `json:"name,omitempty"`
+	Schedule        string `json:"schedule,omitempty"`
+	TTL             string `json:"ttl,omitempty"`
+	StorageLocation string `json:"storageLocation,omitempty"`
+}
+
+// The types below mirror the subset of the Velero API written by this plugin,
+// which avoids depending on the whole Velero module.
+
+type schedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              scheduleSpec `json:"spec"`
+}
+
+type scheduleSpec struct {
+	Schedule string     `json:"schedule"`
+	Template backupSpec `json:"template"`
+}
+
+type backupSpec struct {
+	IncludedNamespaces []string        `json:"includedNamespaces"`
+	TTL                metav1.Duration `json:"ttl"`
+	StorageLocation    string          `json:"storageLocation"`
+	SnapshotVolumes    *bool           `json:"snapshotVolumes,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var backupSchedules BackupSchedules
+	if err := pluginconfig.Unmarshal(data, &backupSchedules); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&backupSchedules)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(backupSchedules *BackupSchedules) ([][]byte, error) {
+	spec := &backupSchedules.Spec
+
+	if len(spec.Retention) == 0 {
+		return nil, fmt.Errorf("spec.retention is empty")
+	}
+
+	namespaces := spec.Namespaces
+	if len(namespaces) == 0 {
+		if backupSchedules.Namespace == "" {
+			return nil, fmt.Errorf("spec.namespaces is empty")
+		}
+		namespaces = []string{backupSchedules.Namespace}
+	}
+
+	veleroNamespace := spec.VeleroNamespace
+	if veleroNamespace == "" {
+		veleroNamespace = defaultVeleroNamespace
+	}
+
+	var manifests [][]byte
+	for _, namespace := range namespaces {
+		for _, retention := range spec.Retention {
+			if retention.Name == "" {
+				return nil, fmt.Errorf("retention has no name")
+			}
+
+			if err := validateSchedule(retention.Schedule); err != nil {
+				return nil, fmt.Errorf("retention %s: %w", retention.Name, err)
+			}
+
+			ttl, err := parseTTL(retention.TTL)
+			if err != nil {
+				return nil, fmt.Errorf("retention %s: %w", retention.Name, err)
+			}
+
+			storageLocation := retention.StorageLocation
+			if storageLocation == "" {
+				storageLocation = spec.StorageLocation
+			}
+			if storageLocation == "" {
+				storageLocation = defaultStorageLocation
+			}
+
+			objectMeta := *backupSchedules.ObjectMeta.DeepCopy()
+			objectMeta.Name = fmt.Sprintf("%s-%s", namespace, retention.Name)
+			objectMeta.Namespace = veleroNamespace
+
+			b, err := yaml.Marshal(schedule{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: veleroGroupVersion.String(),
+					Kind:       scheduleKind,
+				},
+				ObjectMeta: objectMeta,
+				Spec: scheduleSpec{
+					Schedule: retention.Schedule,
+					Template: backupSpec{
+						IncludedNamespaces: []string{
+							namespace,
+						},
+						TTL: metav1.Duration{
+							Duration: ttl,
+						},
+						StorageLocation: storageLocation,
+						SnapshotVolumes: spec.SnapshotVolumes,
+					},
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, b)
+		}
+	}
+
+	return manifests, nil
+}
+
+func validateSchedule(schedule string) error {
+	if strings.HasPrefix(schedule, cronDescriptorPrefix) {
+		return nil
+	}
+
+	if len(strings.Fields(schedule)) != cronFields {
+		return fmt.Errorf("invalid schedule %q", schedule)
+	}
+
+	return nil
+}
+
+// parseTTL accepts days besides the units understood by time.ParseDuration,
+// since retention is usually expressed in days.
+func parseTTL(ttl string) (time.Duration, error) {
+	if strings.HasSuffix(ttl, daySuffix) {
+		days, err := strconv.Atoi(strings.TrimSuffix(ttl, daySuffix))
+		if err != nil || days <= 0 {
+			return 0, fmt.Errorf("invalid ttl %q", ttl)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(ttl)
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid ttl %q", ttl)
+	}
+
+	return duration, nil
+}
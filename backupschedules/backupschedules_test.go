@@ -0,0 +1,116 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/backupschedules"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("BackupSchedules", func() {
+	ginkgo.It("generates Schedules for each namespace and retention", func() {
+		manifests := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: BackupSchedules
+metadata:
+  name: backups
+  namespace: my-namespace
+  labels:
+    team: my-team
+spec:
+  storageLocation: s3
+  snapshotVolumes: false
+  retention:
+    - name: daily
+      schedule: "0 3 * * *"
+      ttl: 7d
+    - name: weekly
+      schedule: "@weekly"
+      ttl: 720h
+      storageLocation: s3-glacier
+`)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		g.Expect(manifests[0]["apiVersion"]).To(g.Equal("velero.io/v1"))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("Schedule"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "my-namespace-daily"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "velero"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("labels", map[string]interface{}{"team": "my-team"}))
+		g.Expect(manifests[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"schedule": "0 3 * * *",
+			"template": map[string]interface{}{
+				"includedNamespaces": []interface{}{"my-namespace"},
+				"ttl":                "168h0m0s",
+				"storageLocation":    "s3",
+				"snapshotVolumes":    false,
+			},
+		}))
+
+		g.Expect(manifests[1]["metadata"]).To(g.HaveKeyWithValue("name", "my-namespace-weekly"))
+		g.Expect(manifests[1]["spec"]).To(g.HaveKeyWithValue("template", g.HaveKeyWithValue("ttl", "720h0m0s")))
+		g.Expect(manifests[1]["spec"]).To(g.HaveKeyWithValue("template", g.HaveKeyWithValue("storageLocation", "s3-glacier")))
+	})
+
+	ginkgo.It("generates Schedules for the listed namespaces", func() {
+		manifests := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: BackupSchedules
+metadata:
+  name: backups
+spec:
+  veleroNamespace: backups
+  namespaces:
+    - first
+    - second
+  retention:
+    - name: daily
+      schedule: "@daily"
+      ttl: 1d
+`)
+		g.Expect(manifests).To(g.HaveLen(2))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "first-daily"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "backups"))
+		g.Expect(manifests[0]["spec"]).To(g.HaveKeyWithValue("template", g.HaveKeyWithValue("storageLocation", "default")))
+		g.Expect(manifests[1]["metadata"]).To(g.HaveKeyWithValue("name", "second-daily"))
+		g.Expect(manifests[1]["spec"]).To(g.HaveKeyWithValue("template", g.HaveKeyWithValue("includedNamespaces", []interface{}{"second"})))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: BackupSchedules
+metadata:
+  name: backups
+  namespace: my-namespace
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without retention", "  storageLocation: s3\n", "spec.retention is empty"),
+		ginkgo.Entry("with invalid schedule", "  retention: [{name: daily, schedule: '0 3 *', ttl: 7d}]\n", `retention daily: invalid schedule "0 3 *"`),
+		ginkgo.Entry("with invalid ttl", "  retention: [{name: daily, schedule: '@daily', ttl: week}]\n", `retention daily: invalid ttl "week"`),
+		ginkgo.Entry("without ttl", "  retention: [{name: daily, schedule: '@daily'}]\n", `retention daily: invalid ttl ""`),
+	)
+})
+
+func generate(config string) []map[string]interface{} {
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.Succeed())
+
+	var manifests []map[string]interface{}
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var m map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	environmentLabel = "incognia.com/environment"
+
+	certificateKind      = "Certificate"
+	defaultIssuerKind    = "ClusterIssuer"
+	secretNameSuffix     = "-tls"
+	wildcardName         = "wildcard"
+	fullyQualifiedSuffix = "."
+)
+
+var (
+	certManagerGroupVersion = schema.GroupVersion{
+		Group:   "cert-manager.io",
+		Version: "v1",
+	}
+)
+
+type Certificates struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Hosts        []string               `json:"hosts,omitempty"`
+	Issuer       *Issuer                `json:"issuer,omitempty"`
+	Environment  string                 `json:"environment,omitempty"`
+	Environments map[string]Environment `json:"environments,omitempty"`
+}
+
+type Issuer struct {
+	Name string `json:"name,omitempty"`
+	Kind string `json:"kind,omitempty"`
+}
+
+type Environment struct {
+	Domain string  `json:"domain,omitempty"`
+	Issuer *Issuer `json:"issuer,omitempty"`
+}
+
+// The types below mirror the subset of the cert-manager API written by this
+// plugin, which avoids depending on the whole cert-manager module.
+
+type certificate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              certificateSpec `json:"spec"`
+}
+
+type certificateSpec struct {
+	SecretName string    `json:"secretName"`
+	DNSNames   []string  `json:"dnsNames"`
+	IssuerRef  issuerRef `json:"issuerRef"`
+}
+
+type issuerRef struct {
+	Name  string `json:"name"`
+	Kind  string `json:"kind"`
+	Group string `json:"group"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var certificates Certificates
+	if err := pluginconfig.Unmarshal(data, &certificates); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&certificates)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(certificates *Certificates) ([][]byte, error) {
+	spec := &certificates.Spec
+
+	if len(spec.Hosts) == 0 {
+		return nil, fmt.Errorf("spec.hosts is empty")
+	}
+
+	environmentName := spec.Environment
+	if environmentName == "" {
+		environmentName = certificates.Labels[environmentLabel]
+	}
+
+	var environment Environment
+	if environmentName != "" && spec.Environments != nil {
+		e, exists := spec.Environments[environmentName]
+		if !exists {
+			return nil, fmt.Errorf("environment %s has no settings", environmentName)
+		}
+		environment = e
+	}
+
+	issuer := spec.Issuer
+	if issuer == nil {
+		issuer = environment.Issuer
+	}
+	if issuer == nil || issuer.Name == "" {
+		return nil, fmt.Errorf("spec.issuer is empty")
+	}
+
+	issuerKind := issuer.Kind
+	if issuerKind == "" {
+		issuerKind = defaultIssuerKind
+	}
+
+	names := make(map[string]bool, len(spec.Hosts))
+	manifests := make([][]byte, 0, len(spec.Hosts))
+	for _, host := range spec.Hosts {
+		dnsName, name, err := resolveHost(host, environment.Domain)
+		if err != nil {
+			return nil, err
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("host %s is duplicated", host)
+		}
+		names[name] = true
+
+		objectMeta := *certificates.ObjectMeta.DeepCopy()
+		objectMeta.Name = name
+
+		b, err := yaml.Marshal(certificate{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: certManagerGroupVersion.String(),
+				Kind:       certificateKind,
+			},
+			ObjectMeta: objectMeta,
+			Spec: certificateSpec{
+				SecretName: name + secretNameSuffix,
+				DNSNames: []string{
+					dnsName,
+				},
+				IssuerRef: issuerRef{
+					Name:  issuer.Name,
+					Kind:  issuerKind,
+					Group: certManagerGroupVersion.Group,
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+// resolveHost qualifies a host with the domain of the environment, unless it
+// ends with a dot, and names its Certificate after its DNS labels.
+func resolveHost(host string, domain string) (string, string, error) {
+	dnsName := strings.TrimSuffix(host, fullyQualifiedSuffix)
+	if !strings.HasSuffix(host, fullyQualifiedSuffix) {
+		if domain == "" {
+			return "", "", fmt.Errorf("host %s is relative but the environment has no domain", host)
+		}
+		dnsName = fmt.Sprintf("%s.%s", host, domain)
+	}
+
+	if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(dnsName, "*.")); len(errs) > 0 {
+		return "", "", fmt.Errorf("host %s is invalid: %s", host, strings.Join(errs, ", "))
+	}
+
+	name := strings.TrimSuffix(host, fullyQualifiedSuffix)
+	if strings.HasPrefix(name, "*.") {
+		name = wildcardName + "." + strings.TrimPrefix(name, "*.")
+	}
+	name = strings.ReplaceAll(name, ".", "-")
+
+	return dnsName, name, nil
+}
@@ -0,0 +1,94 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/certificates"
+)
+
+const (
+	environments = `
+  environments:
+    production:
+      domain: example.com
+      issuer:
+        name: letsencrypt-production
+    staging:
+      domain: staging.example.com
+      issuer:
+        name: letsencrypt-staging
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("Certificates", func() {
+	ginkgo.It("generates Certificates for each host", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Certificates
+metadata:
+  name: certificates
+  namespace: my-namespace
+  labels:
+    incognia.com/environment: staging
+spec:
+  hosts:
+    - my-app
+    - "*.my-app"
+    - my-app.example.org.
+`+environments), &out)).To(g.Succeed())
+
+		var certificates []map[string]interface{}
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var certificate map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(manifest), &certificate)).To(g.Succeed())
+			certificates = append(certificates, certificate)
+		}
+		g.Expect(certificates).To(g.HaveLen(3))
+
+		g.Expect(certificates[0]["apiVersion"]).To(g.Equal("cert-manager.io/v1"))
+		g.Expect(certificates[0]["kind"]).To(g.Equal("Certificate"))
+		g.Expect(certificates[0]["metadata"]).To(g.HaveKeyWithValue("name", "my-app"))
+		g.Expect(certificates[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "my-namespace"))
+		g.Expect(certificates[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"secretName": "my-app-tls",
+			"dnsNames":   []interface{}{"my-app.staging.example.com"},
+			"issuerRef": map[string]interface{}{
+				"name":  "letsencrypt-staging",
+				"kind":  "ClusterIssuer",
+				"group": "cert-manager.io",
+			},
+		}))
+
+		g.Expect(certificates[1]["metadata"]).To(g.HaveKeyWithValue("name", "wildcard-my-app"))
+		g.Expect(certificates[1]["spec"]).To(g.HaveKeyWithValue("dnsNames", []interface{}{"*.my-app.staging.example.com"}))
+
+		g.Expect(certificates[2]["metadata"]).To(g.HaveKeyWithValue("name", "my-app-example-org"))
+		g.Expect(certificates[2]["spec"]).To(g.HaveKeyWithValue("dnsNames", []interface{}{"my-app.example.org"}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Certificates
+metadata:
+  name: certificates
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without hosts", "  issuer: {name: letsencrypt}\n", "spec.hosts is empty"),
+		ginkgo.Entry("without issuer", "  hosts: [my-app.example.com.]\n", "spec.issuer is empty"),
+		ginkgo.Entry("with relative host without domain", "  hosts: [my-app]\n  issuer: {name: letsencrypt}\n", "host my-app is relative but the environment has no domain"),
+		ginkgo.Entry("with unknown environment", "  hosts: [my-app]\n  environment: qa\n"+environments, "environment qa has no settings"),
+	)
+})
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultAnnotation = "incognia.com/config-checksum"
+)
+
+var (
+	configMapKind = reflect.TypeOf(corev1.ConfigMap{}).Name()
+	secretKind    = reflect.TypeOf(corev1.Secret{}).Name()
+
+	defaultKinds = []string{
+		"DaemonSet",
+		"Deployment",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+)
+
+type ConfigChecksum struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Annotation string   `json:"annotation,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"`
+}
+
+type configKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var configChecksum ConfigChecksum
+	if err := pluginconfig.Unmarshal(data, &configChecksum); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&configChecksum, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(configChecksum *ConfigChecksum, nodes []*kyaml.RNode) error {
+	annotation := configChecksum.Spec.Annotation
+	if annotation == "" {
+		annotation = defaultAnnotation
+	}
+
+	kinds := configChecksum.Spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	configs, err := indexConfigs(nodes)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(podTemplatePath...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		podSpec, err := readPodSpec(template)
+		if err != nil {
+			return err
+		}
+
+		checksum, err := makeChecksum(configs, node.GetNamespace(), podSpec)
+		if err != nil {
+			return err
+		}
+		if checksum == "" {
+			continue
+		}
+
+		if err := template.PipeE(kyaml.SetAnnotation(annotation, checksum)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func indexConfigs(nodes []*kyaml.RNode) (map[configKey][]byte, error) {
+	configs := make(map[configKey][]byte)
+
+	for _, node := range nodes {
+		kind := node.GetKind()
+		if kind != configMapKind && kind != secretKind {
+			continue
+		}
+
+		var content []byte
+		for _, field := range []string{"data", "binaryData", "stringData"} {
+			value, err := node.Pipe(kyaml.Lookup(field))
+			if err != nil {
+				return nil, err
+			}
+			if value == nil {
+				continue
+			}
+
+			s, err := value.String()
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, fmt.Sprintf("%s:\n%s", field, s)...)
+		}
+
+		configs[configKey{
+			kind:      kind,
+			namespace: node.GetNamespace(),
+			name:      node.GetName(),
+		}] = content
+	}
+
+	return configs, nil
+}
+
+func readPodSpec(template *kyaml.RNode) (*corev1.PodSpec, error) {
+	spec, err := template.Pipe(kyaml.Lookup("spec"))
+	if err != nil {
+		return nil, err
+	}
+
+	var podSpec corev1.PodSpec
+	if spec == nil {
+		return &podSpec, nil
+	}
+
+	s, err := spec.String()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal([]byte(s), &podSpec); err != nil {
+		return nil, err
+	}
+
+	return &podSpec, nil
+}
+
+func makeChecksum(configs map[configKey][]byte, namespace string, podSpec *corev1.PodSpec) (string, error) {
+	references := findReferences(podSpec)
+
+	var keys []configKey
+	for reference := range references {
+		key := configKey{
+			kind:      reference.kind,
+			namespace: namespace,
+			name:      reference.name,
+		}
+		if _, exists := configs[key]; exists {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		return "", nil
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+	})
+
+	hash := sha256.New()
+	for _, key := range keys {
+		if _, err := fmt.Fprintf(hash, "%s/%s\n", key.kind, key.name); err != nil {
+			return "", err
+		}
+
+		if _, err := hash.Write(configs[key]); err != nil {
+			return "", err
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func findReferences(podSpec *corev1.PodSpec) map[configKey]bool {
+	references := make(map[configKey]bool)
+
+	addConfigMap := func(name string) {
+		references[configKey{
+			kind: configMapKind,
+			name: name,
+		}] = true
+	}
+
+	addSecret := func(name string) {
+		references[configKey{
+			kind: secretKind,
+			name: name,
+		}] = true
+	}
+
+	for _, volume := range podSpec.Volumes {
+		if volume.ConfigMap != nil {
+			addConfigMap(volume.ConfigMap.Name)
+		}
+
+		if volume.Secret != nil {
+			addSecret(volume.Secret.SecretName)
+		}
+
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ConfigMap != nil {
+					addConfigMap(source.ConfigMap.Name)
+				}
+
+				if source.Secret != nil {
+					addSecret(source.Secret.Name)
+				}
+			}
+		}
+	}
+
+	containers := append(podSpec.InitContainers, podSpec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.ConfigMapRef != nil {
+				addConfigMap(envFrom.ConfigMapRef.Name)
+			}
+
+			if envFrom.SecretRef != nil {
+				addSecret(envFrom.SecretRef.Name)
+			}
+		}
+
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				addConfigMap(env.ValueFrom.ConfigMapKeyRef.Name)
+			}
+
+			if env.ValueFrom.SecretKeyRef != nil {
+				addSecret(env.ValueFrom.SecretKeyRef.Name)
+			}
+		}
+	}
+
+	return references
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,156 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/configchecksum"
+)
+
+const (
+	annotation = "incognia.com/config-checksum"
+
+	resources = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-app
+data:
+  LOG_LEVEL: info
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: my-app
+stringData:
+  PASSWORD: hunter2
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: with-env-from
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          envFrom:
+            - configMapRef:
+                name: my-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: with-volumes
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+      volumes:
+        - name: config
+          configMap:
+            name: my-app
+        - name: secret
+          secret:
+            secretName: my-app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: without-references
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: in-other-namespace
+  namespace: other
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          envFrom:
+            - configMapRef:
+                name: my-app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ConfigChecksum", func() {
+	config := []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ConfigChecksum
+metadata:
+  name: config-checksum
+`)
+
+	ginkgo.It("annotates only workloads with references", func() {
+		checksums := transform(config, resources)
+
+		g.Expect(checksums).To(g.HaveKeyWithValue("with-env-from", g.HaveLen(64)))
+		g.Expect(checksums).To(g.HaveKeyWithValue("with-volumes", g.HaveLen(64)))
+		g.Expect(checksums["with-env-from"]).NotTo(g.Equal(checksums["with-volumes"]))
+		g.Expect(checksums).To(g.HaveKeyWithValue("without-references", ""))
+		g.Expect(checksums).To(g.HaveKeyWithValue("in-other-namespace", ""))
+	})
+
+	ginkgo.It("changes checksums along with configuration", func() {
+		before := transform(config, resources)
+		after := transform(config, strings.Replace(resources, "LOG_LEVEL: info", "LOG_LEVEL: debug", 1))
+
+		g.Expect(after["with-env-from"]).NotTo(g.Equal(before["with-env-from"]))
+		g.Expect(after["with-volumes"]).NotTo(g.Equal(before["with-volumes"]))
+	})
+
+	ginkgo.It("skips kinds not configured", func() {
+		checksums := transform([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ConfigChecksum
+metadata:
+  name: config-checksum
+spec:
+  kinds:
+    - StatefulSet
+`), resources)
+
+		for _, checksum := range checksums {
+			g.Expect(checksum).To(g.BeEmpty())
+		}
+	})
+})
+
+func transform(config []byte, resources string) map[string]string {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests(config, strings.NewReader(resources), &out)).To(g.Succeed())
+
+	checksums := make(map[string]string)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var meta metav1.TypeMeta
+		g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+		if meta.Kind != "Deployment" {
+			continue
+		}
+
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+		checksums[deployment.Name] = deployment.Spec.Template.Annotations[annotation]
+	}
+
+	return checksums
+}
@@ -0,0 +1,365 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	configManagementPluginKind = "ConfigManagementPlugin"
+	deploymentKind             = "Deployment"
+	defaultRepoServer          = "argocd-repo-server"
+	configMapSuffix            = "-cmp"
+	tmpVolumeSuffix            = "-cmp-tmp"
+	pluginFileName             = "plugin.yaml"
+
+	cmpServerCommand = "/var/run/argocd/argocd-cmp-server"
+	varFilesVolume   = "var-files"
+	varFilesPath     = "/var/run/argocd"
+	pluginsVolume    = "plugins"
+	pluginsPath      = "/home/argocd/cmp-server/plugins"
+	pluginConfigPath = "/home/argocd/cmp-server/config/" + pluginFileName
+	tmpPath          = "/tmp"
+	argoCDUser       = 999
+)
+
+var (
+	argoGroupVersion = schema.GroupVersion{
+		Group:   "argoproj.io",
+		Version: "v1alpha1",
+	}
+)
+
+type ConfigManagementPlugins struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	RepoServer string   `json:"repoServer,omitempty"`
+	Plugins    []Plugin `json:"plugins,omitempty"`
+}
+
+type Plugin struct {
+	Name             string                      `json:"name,omitempty"`
+	Image            string                      `json:"image,omitempty"`
+	Version          string                      `json:"version,omitempty"`
+	Init             *Command                    `json:"init,omitempty"`
+	Generate         *Command                    `json:"generate,omitempty"`
+	Discover         *Discover                   `json:"discover,omitempty"`
+	AllowConcurrency bool                        `json:"allowConcurrency,omitempty"`
+	LockRepo         bool                        `json:"lockRepo,omitempty"`
+	Env              []corev1.EnvVar             `json:"env,omitempty"`
+	Resources        corev1.ResourceRequirements `json:"resources,omitempty"`
+}
+
+type Command struct {
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+}
+
+type Discover struct {
+	FileName string `json:"fileName,omitempty"`
+	Find     *Find  `json:"find,omitempty"`
+}
+
+type Find struct {
+	Command `json:",inline"`
+	Glob    string `json:"glob,omitempty"`
+}
+
+// The types below mirror the plugin configuration read by the Argo CD CMP
+// server from plugin.yaml, which is not registered as an API of the cluster.
+
+type configManagementPlugin struct {
+	metav1.TypeMeta `json:",inline"`
+	Metadata        pluginMetadata `json:"metadata"`
+	Spec            pluginSpec     `json:"spec"`
+}
+
+type pluginMetadata struct {
+	Name string `json:"name"`
+}
+
+type pluginSpec struct {
+	Version          string    `json:"version,omitempty"`
+	Init             *Command  `json:"init,omitempty"`
+	Generate         *Command  `json:"generate"`
+	Discover         *Discover `json:"discover,omitempty"`
+	AllowConcurrency bool      `json:"allowConcurrency,omitempty"`
+	LockRepo         bool      `json:"lockRepo,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var configManagementPlugins ConfigManagementPlugins
+	if err := pluginconfig.Unmarshal(data, &configManagementPlugins); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	nodes, err = transform(&configManagementPlugins, nodes)
+	if err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(configManagementPlugins *ConfigManagementPlugins, nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	spec := &configManagementPlugins.Spec
+
+	if len(spec.Plugins) == 0 {
+		return nil, fmt.Errorf("spec.plugins is empty")
+	}
+
+	repoServerName := spec.RepoServer
+	if repoServerName == "" {
+		repoServerName = defaultRepoServer
+	}
+
+	var repoServer *kyaml.RNode
+	for _, node := range nodes {
+		if node.GetKind() == deploymentKind && node.GetName() == repoServerName {
+			repoServer = node
+			break
+		}
+	}
+	if repoServer == nil {
+		return nil, fmt.Errorf("%s %s not found", deploymentKind, repoServerName)
+	}
+
+	template, err := repoServer.Pipe(kyaml.Lookup("spec", "template"))
+	if err != nil {
+		return nil, err
+	}
+	if template == nil {
+		return nil, fmt.Errorf("%s %s has no pod template", deploymentKind, repoServerName)
+	}
+
+	for _, volume := range []string{varFilesVolume, pluginsVolume} {
+		if err := appendElement(template, []string{"spec", "volumes"}, "name", volume, corev1.Volume{
+			Name: volume,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i := range spec.Plugins {
+		plugin := &spec.Plugins[i]
+
+		if plugin.Name == "" {
+			return nil, fmt.Errorf("spec.plugins[%d] has no name", i)
+		}
+
+		if seen[plugin.Name] {
+			return nil, fmt.Errorf("plugin %s is declared more than once", plugin.Name)
+		}
+		seen[plugin.Name] = true
+
+		if plugin.Image == "" {
+			return nil, fmt.Errorf("plugin %s has no image", plugin.Name)
+		}
+
+		if plugin.Generate == nil || len(plugin.Generate.Command) == 0 {
+			return nil, fmt.Errorf("plugin %s has no generate command", plugin.Name)
+		}
+
+		configMap, err := makeConfigMap(plugin, repoServer.GetNamespace())
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, configMap)
+
+		if err := register(template, plugin); err != nil {
+			return nil, fmt.Errorf("plugin %s: %w", plugin.Name, err)
+		}
+	}
+
+	return nodes, nil
+}
+
+func makeConfigMap(plugin *Plugin, namespace string) (*kyaml.RNode, error) {
+	b, err := yaml.Marshal(configManagementPlugin{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: argoGroupVersion.String(),
+			Kind:       configManagementPluginKind,
+		},
+		Metadata: pluginMetadata{
+			Name: plugin.Name,
+		},
+		Spec: pluginSpec{
+			Version:          plugin.Version,
+			Init:             plugin.Init,
+			Generate:         plugin.Generate,
+			Discover:         plugin.Discover,
+			AllowConcurrency: plugin.AllowConcurrency,
+			LockRepo:         plugin.LockRepo,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	b, err = yaml.Marshal(corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ConfigMap{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      plugin.Name + configMapSuffix,
+			Namespace: namespace,
+		},
+		Data: map[string]string{
+			pluginFileName: string(b),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return kyaml.Parse(string(b))
+}
+
+// register adds the plugin to the repo server as a sidecar running the CMP
+// server as the argocd user, which is how Argo CD discovers sidecar plugins.
+func register(template *kyaml.RNode, plugin *Plugin) error {
+	configVolume := plugin.Name + configMapSuffix
+	tmpVolume := plugin.Name + tmpVolumeSuffix
+
+	if err := appendElement(template, []string{"spec", "volumes"}, "name", configVolume, corev1.Volume{
+		Name: configVolume,
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: configVolume,
+				},
+			},
+		},
+	}); err != nil {
+		return err
+	}
+
+	if err := appendElement(template, []string{"spec", "volumes"}, "name", tmpVolume, corev1.Volume{
+		Name: tmpVolume,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{},
+		},
+	}); err != nil {
+		return err
+	}
+
+	runAsNonRoot := true
+	runAsUser := int64(argoCDUser)
+
+	return appendElement(template, []string{"spec", "containers"}, "name", plugin.Name, corev1.Container{
+		Name:      plugin.Name,
+		Image:     plugin.Image,
+		Command:   []string{cmpServerCommand},
+		Env:       plugin.Env,
+		Resources: plugin.Resources,
+		SecurityContext: &corev1.SecurityContext{
+			RunAsNonRoot: &runAsNonRoot,
+			RunAsUser:    &runAsUser,
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      varFilesVolume,
+				MountPath: varFilesPath,
+			},
+			{
+				Name:      pluginsVolume,
+				MountPath: pluginsPath,
+			},
+			{
+				Name:      configVolume,
+				MountPath: pluginConfigPath,
+				SubPath:   pluginFileName,
+			},
+			{
+				Name:      tmpVolume,
+				MountPath: tmpPath,
+			},
+		},
+	})
+}
+
+// appendElement appends v to the list at path unless an element with the same
+// key is already there.
+func appendElement(node *kyaml.RNode, path []string, key string, value string, v interface{}) error {
+	list, err := node.Pipe(kyaml.LookupCreate(kyaml.SequenceNode, path...))
+	if err != nil {
+		return err
+	}
+
+	values, err := list.ElementValues(key)
+	if err != nil {
+		return err
+	}
+	if containsString(values, value) {
+		return nil
+	}
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	element, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return list.PipeE(kyaml.Append(element.YNode()))
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
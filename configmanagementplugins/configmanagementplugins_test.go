@@ -0,0 +1,147 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/configmanagementplugins"
+)
+
+const (
+	repoServer = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: argocd-repo-server
+  namespace: argocd
+spec:
+  template:
+    spec:
+      containers:
+        - name: argocd-repo-server
+          image: quay.io/argoproj/argocd:v2.4.0
+      volumes:
+        - name: var-files
+          emptyDir: {}
+`
+
+	plugins = `
+  plugins:
+    - name: kustomize-plugins
+      image: ghcr.io/inloco/iac-kustomize-plugins:latest
+      version: v1
+      generate:
+        command: [kustomize, build, --enable-alpha-plugins, .]
+      discover:
+        find:
+          glob: "**/kustomization.yaml"
+      lockRepo: true
+`
+)
+
+var _ = ginkgo.Describe("ConfigManagementPlugins", func() {
+	transform := func(resources string, spec string) ([]string, error) {
+		var out bytes.Buffer
+		if err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ConfigManagementPlugins
+metadata:
+  name: plugins
+spec:
+`+spec), strings.NewReader(resources), &out); err != nil {
+			return nil, err
+		}
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var manifests []string
+		for _, node := range nodes {
+			manifests = append(manifests, node.MustString())
+		}
+
+		return manifests, nil
+	}
+
+	ginkgo.It("registers plugins with the repo server", func() {
+		manifests, err := transform(repoServer, plugins)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var configMap corev1.ConfigMap
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &configMap)).To(g.Succeed())
+		g.Expect(configMap.Name).To(g.Equal("kustomize-plugins-cmp"))
+		g.Expect(configMap.Namespace).To(g.Equal("argocd"))
+		g.Expect(configMap.Data).To(g.Equal(map[string]string{
+			"plugin.yaml": `apiVersion: argoproj.io/v1alpha1
+kind: ConfigManagementPlugin
+metadata:
+  name: kustomize-plugins
+spec:
+  discover:
+    find:
+      glob: '**/kustomization.yaml'
+  generate:
+    command:
+    - kustomize
+    - build
+    - --enable-alpha-plugins
+    - .
+  lockRepo: true
+  version: v1
+`,
+		}))
+
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &deployment)).To(g.Succeed())
+
+		podSpec := deployment.Spec.Template.Spec
+		g.Expect(podSpec.Volumes).To(g.HaveLen(4))
+		g.Expect(podSpec.Volumes[0].EmptyDir).NotTo(g.BeNil())
+		g.Expect(podSpec.Volumes[1].Name).To(g.Equal("plugins"))
+		g.Expect(podSpec.Volumes[2].Name).To(g.Equal("kustomize-plugins-cmp"))
+		g.Expect(podSpec.Volumes[2].ConfigMap.Name).To(g.Equal("kustomize-plugins-cmp"))
+		g.Expect(podSpec.Volumes[3].Name).To(g.Equal("kustomize-plugins-cmp-tmp"))
+
+		g.Expect(podSpec.Containers).To(g.HaveLen(2))
+		sidecar := podSpec.Containers[1]
+		g.Expect(sidecar.Name).To(g.Equal("kustomize-plugins"))
+		g.Expect(sidecar.Image).To(g.Equal("ghcr.io/inloco/iac-kustomize-plugins:latest"))
+		g.Expect(sidecar.Command).To(g.Equal([]string{"/var/run/argocd/argocd-cmp-server"}))
+		g.Expect(*sidecar.SecurityContext.RunAsUser).To(g.Equal(int64(999)))
+		g.Expect(sidecar.VolumeMounts).To(g.ContainElement(corev1.VolumeMount{
+			Name:      "kustomize-plugins-cmp",
+			MountPath: "/home/argocd/cmp-server/config/plugin.yaml",
+			SubPath:   "plugin.yaml",
+		}))
+	})
+
+	ginkgo.It("keeps plugins already registered", func() {
+		manifests, err := transform(repoServer, plugins)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		again, err := transform(manifests[0], plugins)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(again[0]).To(g.Equal(manifests[0]))
+	})
+
+	ginkgo.DescribeTable("fails", func(resources string, spec string, expectedError string) {
+		_, err := transform(resources, spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without plugins", repoServer, "", "spec.plugins is empty"),
+		ginkgo.Entry("without repo server", "", plugins, "Deployment argocd-repo-server not found"),
+		ginkgo.Entry("without image", repoServer, "  plugins:\n    - name: a\n", "plugin a has no image"),
+		ginkgo.Entry("without generate command", repoServer, "  plugins:\n    - name: a\n      image: a\n", "plugin a has no generate command"),
+	)
+})
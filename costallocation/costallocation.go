@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-cost-allocation"
+
+	defaultTeamLabel = "incognia.com/team"
+	costCenterLabel  = "incognia.com/cost-center"
+	productLabel     = "incognia.com/product"
+	ownerLabel       = "incognia.com/owner"
+
+	cronJobKind = "CronJob"
+)
+
+var (
+	namespaceKind = reflect.TypeOf(corev1.Namespace{}).Name()
+
+	podTemplateKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"ReplicaSet",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+)
+
+type CostAllocation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Team      string          `json:"team,omitempty"`
+	TeamLabel string          `json:"teamLabel,omitempty"`
+	Registry  string          `json:"registry,omitempty"`
+	Teams     map[string]Team `json:"teams,omitempty"`
+	Override  bool            `json:"override,omitempty"`
+}
+
+type Team struct {
+	CostCenter string `json:"costCenter,omitempty"`
+	Product    string `json:"product,omitempty"`
+	Owner      string `json:"owner,omitempty"`
+}
+
+func (t Team) Labels() map[string]string {
+	labels := make(map[string]string)
+	for key, value := range map[string]string{
+		costCenterLabel: t.CostCenter,
+		productLabel:    t.Product,
+		ownerLabel:      t.Owner,
+	} {
+		if value != "" {
+			labels[key] = value
+		}
+	}
+
+	return labels
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var costAllocation CostAllocation
+	if err := pluginconfig.Unmarshal(data, &costAllocation); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&costAllocation, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(costAllocation *CostAllocation, nodes []*kyaml.RNode) error {
+	spec := &costAllocation.Spec
+
+	teamLabel := spec.TeamLabel
+	if teamLabel == "" {
+		teamLabel = defaultTeamLabel
+	}
+
+	teams, err := readTeams(spec)
+	if err != nil {
+		return err
+	}
+
+	namespaceTeams := make(map[string]string)
+	for _, node := range nodes {
+		if node.GetKind() != namespaceKind {
+			continue
+		}
+
+		if team, exists := node.GetLabels()[teamLabel]; exists {
+			namespaceTeams[node.GetName()] = team
+		}
+	}
+
+	for _, node := range nodes {
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		teamName := node.GetLabels()[teamLabel]
+		if teamName == "" && node.GetKind() != namespaceKind {
+			teamName = namespaceTeams[node.GetNamespace()]
+		}
+		if teamName == "" {
+			teamName = spec.Team
+		}
+		if teamName == "" {
+			continue
+		}
+
+		team, exists := teams[teamName]
+		if !exists {
+			return fmt.Errorf("team %s of %s %s is not in the registry", teamName, node.GetKind(), node.GetName())
+		}
+		labels := team.Labels()
+
+		if err := setLabels(node, labels, spec.Override); err != nil {
+			return err
+		}
+
+		if !containsString(podTemplateKinds, node.GetKind()) {
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		if err := setLabels(template, labels, spec.Override); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readTeams loads the registry file, if any, and lets the teams defined
+// inline take precedence over it.
+func readTeams(spec *Spec) (map[string]Team, error) {
+	teams := make(map[string]Team)
+
+	if spec.Registry != "" {
+		data, err := ioutil.ReadFile(spec.Registry)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.Unmarshal(data, &teams); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Registry, err)
+		}
+	}
+
+	for name, team := range spec.Teams {
+		teams[name] = team
+	}
+
+	for name, team := range teams {
+		for key, value := range team.Labels() {
+			if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+				return nil, fmt.Errorf("team %s has invalid %s: %s", name, key, strings.Join(errs, ", "))
+			}
+		}
+	}
+
+	return teams, nil
+}
+
+func setLabels(node *kyaml.RNode, labels map[string]string, override bool) error {
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	current := node.GetLabels()
+	for _, key := range keys {
+		if _, exists := current[key]; exists && !override {
+			continue
+		}
+
+		if err := node.PipeE(kyaml.SetLabel(key, labels[key])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,170 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/costallocation"
+)
+
+const (
+	resources = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payments
+  labels:
+    incognia.com/team: payments
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+spec:
+  template:
+    spec:
+      containers:
+        - name: api
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: other
+  labels:
+    incognia.com/team: data
+    incognia.com/owner: someone-else
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: report
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: orphan
+  namespace: other
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: skipped
+  namespace: payments
+  annotations:
+    incognia.com/skip-cost-allocation: "true"
+`
+
+	registry = `
+payments:
+  costCenter: cc-1001
+  product: checkout
+  owner: payments-team
+data:
+  costCenter: cc-2002
+  product: analytics
+  owner: data-team
+`
+)
+
+var _ = ginkgo.Describe("CostAllocation", func() {
+	var registryPath string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "costallocation")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		registryPath = filepath.Join(dir, "teams.yaml")
+		g.Expect(ioutil.WriteFile(registryPath, []byte(registry), 0644)).To(g.Succeed())
+	})
+
+	transform := func(spec string) map[string]*kyaml.RNode {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: CostAllocation
+metadata:
+  name: cost-allocation
+spec:
+  registry: `+registryPath+`
+`+spec), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		byName := make(map[string]*kyaml.RNode)
+		for _, node := range nodes {
+			byName[node.GetName()] = node
+		}
+
+		return byName
+	}
+
+	templateLabels := func(node *kyaml.RNode, path ...string) map[string]string {
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		return template.GetLabels()
+	}
+
+	ginkgo.It("labels resources and pod templates of each team", func() {
+		nodes := transform("")
+
+		g.Expect(nodes["payments"].GetLabels()).To(g.Equal(map[string]string{
+			"incognia.com/team":        "payments",
+			"incognia.com/cost-center": "cc-1001",
+			"incognia.com/product":     "checkout",
+			"incognia.com/owner":       "payments-team",
+		}))
+
+		g.Expect(nodes["api"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/cost-center", "cc-1001"))
+		g.Expect(templateLabels(nodes["api"], "spec", "template")).To(g.HaveKeyWithValue("incognia.com/product", "checkout"))
+
+		g.Expect(nodes["report"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/cost-center", "cc-2002"))
+		g.Expect(nodes["report"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/owner", "someone-else"))
+		g.Expect(templateLabels(nodes["report"], "spec", "jobTemplate", "spec", "template")).To(g.HaveKeyWithValue("incognia.com/owner", "data-team"))
+
+		g.Expect(nodes["orphan"].GetLabels()).To(g.BeEmpty())
+		g.Expect(nodes["skipped"].GetLabels()).To(g.BeEmpty())
+	})
+
+	ginkgo.It("falls back to the team of the spec", func() {
+		nodes := transform("  team: data\n")
+		g.Expect(nodes["orphan"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/product", "analytics"))
+		g.Expect(nodes["api"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/product", "checkout"))
+	})
+
+	ginkgo.It("overrides existing labels", func() {
+		nodes := transform("  override: true\n")
+		g.Expect(nodes["report"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/owner", "data-team"))
+	})
+
+	ginkgo.It("prefers teams defined inline", func() {
+		nodes := transform("  teams:\n    payments:\n      costCenter: cc-3003\n")
+		g.Expect(nodes["api"].GetLabels()).To(g.HaveKeyWithValue("incognia.com/cost-center", "cc-3003"))
+		g.Expect(nodes["api"].GetLabels()).NotTo(g.HaveKey("incognia.com/product"))
+	})
+
+	ginkgo.It("fails on unknown teams", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: CostAllocation
+metadata:
+  name: cost-allocation
+`), strings.NewReader(resources), &out)).To(g.MatchError("team payments of Namespace payments is not in the registry"))
+	})
+})
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	cronWorkflowKind     = "CronWorkflow"
+	cronFields           = 5
+	cronDescriptorPrefix = "@"
+
+	defaultConcurrencyPolicy = "Forbid"
+	defaultRetries           = 2
+	defaultRetryPolicy       = "Always"
+	defaultBackoffDuration   = "1m"
+	defaultBackoffFactor     = 2
+	defaultPodGCStrategy     = "OnPodSuccess"
+	defaultNotifierImage     = "curlimages/curl:8.5.0"
+
+	successfulJobsHistoryLimit = 3
+	failedJobsHistoryLimit     = 3
+	secondsAfterSuccess        = 24 * 60 * 60
+	secondsAfterFailure        = 7 * 24 * 60 * 60
+
+	mainContainerName    = "main"
+	exitHandlerTemplate  = "exit-handler"
+	notifyTemplate       = "notify"
+	webhookEnv           = "SLACK_WEBHOOK_URL"
+	workflowStatus       = "{{workflow.status}}"
+	workflowSucceeded    = "Succeeded"
+	notificationTemplate = "CronWorkflow %s/%s finished with status %s: {{workflow.name}}"
+)
+
+var (
+	argoGroupVersion = schema.GroupVersion{
+		Group:   "argoproj.io",
+		Version: "v1alpha1",
+	}
+)
+
+type CronWorkflows struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	ServiceAccountName string                 `json:"serviceAccountName,omitempty"`
+	ArchiveLogs        *bool                  `json:"archiveLogs,omitempty"`
+	ArtifactRepository *ArtifactRepositoryRef `json:"artifactRepository,omitempty"`
+	Notifications      *Notifications         `json:"notifications,omitempty"`
+	Jobs               []Job                  `json:"jobs,omitempty"`
+}
+
+type ArtifactRepositoryRef struct {
+	ConfigMap string `json:"configMap,omitempty"`
+	Key       string `json:"key,omitempty"`
+}
+
+type Notifications struct {
+	Slack     *Slack `json:"slack,omitempty"`
+	OnSuccess bool   `json:"onSuccess,omitempty"`
+	OnFailure *bool  `json:"onFailure,omitempty"`
+}
+
+type Slack struct {
+	Channel       string                   `json:"channel,omitempty"`
+	WebhookSecret corev1.SecretKeySelector `json:"webhookSecret,omitempty"`
+}
+
+type Job struct {
+	Name          string                      `json:"name,omitempty"`
+	Schedule      string                      `json:"schedule,omitempty"`
+	Timezone      string                      `json:"timezone,omitempty"`
+	Suspend       bool                        `json:"suspend,omitempty"`
+	Image         string                      `json:"image,omitempty"`
+	Command       []string                    `json:"command,omitempty"`
+	Args          []string                    `json:"args,omitempty"`
+	Env           []corev1.EnvVar             `json:"env,omitempty"`
+	Resources     corev1.ResourceRequirements `json:"resources,omitempty"`
+	Retries       *int32                      `json:"retries,omitempty"`
+	Artifacts     []Artifact                  `json:"artifacts,omitempty"`
+	Notifications *Notifications              `json:"notifications,omitempty"`
+}
+
+type Artifact struct {
+	Name string `json:"name,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// The types below mirror the subset of the Argo Workflows API written by this
+// plugin, which avoids depending on the whole Argo Workflows module.
+
+type cronWorkflow struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              cronWorkflowSpec `json:"spec"`
+}
+
+type cronWorkflowSpec struct {
+	Schedule                   string       `json:"schedule"`
+	Timezone                   string       `json:"timezone,omitempty"`
+	Suspend                    bool         `json:"suspend,omitempty"`
+	ConcurrencyPolicy          string       `json:"concurrencyPolicy"`
+	SuccessfulJobsHistoryLimit int32        `json:"successfulJobsHistoryLimit"`
+	FailedJobsHistoryLimit     int32        `json:"failedJobsHistoryLimit"`
+	WorkflowSpec               workflowSpec `json:"workflowSpec"`
+}
+
+type workflowSpec struct {
+	Entrypoint            string                 `json:"entrypoint"`
+	OnExit                string                 `json:"onExit,omitempty"`
+	ServiceAccountName    string                 `json:"serviceAccountName,omitempty"`
+	ArchiveLogs           bool                   `json:"archiveLogs"`
+	ArtifactRepositoryRef *ArtifactRepositoryRef `json:"artifactRepositoryRef,omitempty"`
+	TTLStrategy           ttlStrategy            `json:"ttlStrategy"`
+	PodGC                 podGC                  `json:"podGC"`
+	Templates             []workflowTemplate     `json:"templates"`
+}
+
+type ttlStrategy struct {
+	SecondsAfterSuccess int32 `json:"secondsAfterSuccess"`
+	SecondsAfterFailure int32 `json:"secondsAfterFailure"`
+}
+
+type podGC struct {
+	Strategy string `json:"strategy"`
+}
+
+type workflowTemplate struct {
+	Name          string            `json:"name"`
+	Container     *corev1.Container `json:"container,omitempty"`
+	Steps         [][]workflowStep  `json:"steps,omitempty"`
+	RetryStrategy *retryStrategy    `json:"retryStrategy,omitempty"`
+	Outputs       *outputs          `json:"outputs,omitempty"`
+}
+
+type workflowStep struct {
+	Name     string `json:"name"`
+	Template string `json:"template"`
+	When     string `json:"when,omitempty"`
+}
+
+type retryStrategy struct {
+	Limit       int32   `json:"limit"`
+	RetryPolicy string  `json:"retryPolicy"`
+	Backoff     backoff `json:"backoff"`
+}
+
+type backoff struct {
+	Duration string `json:"duration"`
+	Factor   int32  `json:"factor"`
+}
+
+type outputs struct {
+	Artifacts []Artifact `json:"artifacts"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var cronWorkflows CronWorkflows
+	if err := pluginconfig.Unmarshal(data, &cronWorkflows); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&cronWorkflows)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(cronWorkflows *CronWorkflows) ([][]byte, error) {
+	spec := &cronWorkflows.Spec
+
+	if len(spec.Jobs) == 0 {
+		return nil, fmt.Errorf("spec.jobs is empty")
+	}
+
+	archiveLogs := true
+	if spec.ArchiveLogs != nil {
+		archiveLogs = *spec.ArchiveLogs
+	}
+
+	manifests := make([][]byte, 0, len(spec.Jobs))
+	for i := range spec.Jobs {
+		job := &spec.Jobs[i]
+
+		if job.Name == "" {
+			return nil, fmt.Errorf("job without name")
+		}
+
+		if err := validateSchedule(job.Schedule); err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.Name, err)
+		}
+
+		if job.Image == "" {
+			return nil, fmt.Errorf("job %s has no image", job.Name)
+		}
+
+		retries := int32(defaultRetries)
+		if job.Retries != nil {
+			retries = *job.Retries
+		}
+
+		template := workflowTemplate{
+			Name: job.Name,
+			Container: &corev1.Container{
+				Name:      mainContainerName,
+				Image:     job.Image,
+				Command:   job.Command,
+				Args:      job.Args,
+				Env:       job.Env,
+				Resources: job.Resources,
+			},
+		}
+		if retries > 0 {
+			template.RetryStrategy = &retryStrategy{
+				Limit:       retries,
+				RetryPolicy: defaultRetryPolicy,
+				Backoff: backoff{
+					Duration: defaultBackoffDuration,
+					Factor:   defaultBackoffFactor,
+				},
+			}
+		}
+		if len(job.Artifacts) > 0 {
+			template.Outputs = &outputs{
+				Artifacts: job.Artifacts,
+			}
+		}
+
+		objectMeta := *cronWorkflows.ObjectMeta.DeepCopy()
+		objectMeta.Name = job.Name
+
+		workflow := workflowSpec{
+			Entrypoint:            job.Name,
+			ServiceAccountName:    spec.ServiceAccountName,
+			ArchiveLogs:           archiveLogs,
+			ArtifactRepositoryRef: spec.ArtifactRepository,
+			TTLStrategy: ttlStrategy{
+				SecondsAfterSuccess: secondsAfterSuccess,
+				SecondsAfterFailure: secondsAfterFailure,
+			},
+			PodGC: podGC{
+				Strategy: defaultPodGCStrategy,
+			},
+			Templates: []workflowTemplate{
+				template,
+			},
+		}
+
+		notifications := job.Notifications
+		if notifications == nil {
+			notifications = spec.Notifications
+		}
+
+		templates, err := makeNotificationTemplates(&objectMeta, notifications)
+		if err != nil {
+			return nil, fmt.Errorf("job %s: %w", job.Name, err)
+		}
+		if len(templates) > 0 {
+			workflow.OnExit = exitHandlerTemplate
+			workflow.Templates = append(workflow.Templates, templates...)
+		}
+
+		b, err := yaml.Marshal(cronWorkflow{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: argoGroupVersion.String(),
+				Kind:       cronWorkflowKind,
+			},
+			ObjectMeta: objectMeta,
+			Spec: cronWorkflowSpec{
+				Schedule:                   job.Schedule,
+				Timezone:                   job.Timezone,
+				Suspend:                    job.Suspend,
+				ConcurrencyPolicy:          defaultConcurrencyPolicy,
+				SuccessfulJobsHistoryLimit: successfulJobsHistoryLimit,
+				FailedJobsHistoryLimit:     failedJobsHistoryLimit,
+				WorkflowSpec:               workflow,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+// makeNotificationTemplates returns an exit handler posting the status of
+// workflows to Slack, and its webhook is expanded by Kubernetes from the
+// environment of the container so it never shows up in the workflow.
+func makeNotificationTemplates(objectMeta *metav1.ObjectMeta, notifications *Notifications) ([]workflowTemplate, error) {
+	if notifications == nil || notifications.Slack == nil {
+		return nil, nil
+	}
+
+	slack := notifications.Slack
+	if slack.WebhookSecret.Name == "" || slack.WebhookSecret.Key == "" {
+		return nil, fmt.Errorf("slack notifications require a webhookSecret with name and key")
+	}
+
+	onFailure := true
+	if notifications.OnFailure != nil {
+		onFailure = *notifications.OnFailure
+	}
+
+	var when string
+	switch {
+	case onFailure && notifications.OnSuccess:
+	case onFailure:
+		when = fmt.Sprintf("%s != %s", workflowStatus, workflowSucceeded)
+	case notifications.OnSuccess:
+		when = fmt.Sprintf("%s == %s", workflowStatus, workflowSucceeded)
+	default:
+		return nil, nil
+	}
+
+	payload := map[string]string{
+		"text": fmt.Sprintf(notificationTemplate, objectMeta.Namespace, objectMeta.Name, workflowStatus),
+	}
+	if slack.Channel != "" {
+		payload["channel"] = slack.Channel
+	}
+
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return []workflowTemplate{
+		{
+			Name: exitHandlerTemplate,
+			Steps: [][]workflowStep{
+				{
+					{
+						Name:     notifyTemplate,
+						Template: notifyTemplate,
+						When:     when,
+					},
+				},
+			},
+		},
+		{
+			Name: notifyTemplate,
+			Container: &corev1.Container{
+				Name:  mainContainerName,
+				Image: defaultNotifierImage,
+				Command: []string{
+					"curl",
+					"--fail",
+					"--silent",
+					"--show-error",
+					"--header",
+					"Content-Type: application/json",
+					"--data",
+					string(b),
+					fmt.Sprintf("$(%s)", webhookEnv),
+				},
+				Env: []corev1.EnvVar{
+					{
+						Name: webhookEnv,
+						ValueFrom: &corev1.EnvVarSource{
+							SecretKeyRef: &slack.WebhookSecret,
+						},
+					},
+				},
+			},
+		},
+	}, nil
+}
+
+func validateSchedule(schedule string) error {
+	if strings.HasPrefix(schedule, cronDescriptorPrefix) {
+		return nil
+	}
+
+	if len(strings.Fields(schedule)) != cronFields {
+		return fmt.Errorf("invalid schedule %q", schedule)
+	}
+
+	return nil
+}
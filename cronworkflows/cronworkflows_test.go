@@ -0,0 +1,228 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/cronworkflows"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("CronWorkflows", func() {
+	generate := func(spec string) ([]map[string]interface{}, error) {
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: CronWorkflows
+metadata:
+  name: reports
+  namespace: data
+spec:
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		var cronWorkflows []map[string]interface{}
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var cronWorkflow map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(manifest), &cronWorkflow)).To(g.Succeed())
+			cronWorkflows = append(cronWorkflows, cronWorkflow)
+		}
+
+		return cronWorkflows, nil
+	}
+
+	ginkgo.It("generates CronWorkflows with defaults", func() {
+		cronWorkflows, err := generate(`
+  serviceAccountName: reports
+  jobs:
+    - name: daily-report
+      schedule: "0 6 * * *"
+      timezone: America/Sao_Paulo
+      image: reports:1.0.0
+      command: [report]
+      args: [--daily]
+      resources:
+        requests:
+          cpu: 100m
+          memory: 128Mi
+      artifacts:
+        - name: report
+          path: /tmp/report.csv
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(cronWorkflows).To(g.HaveLen(1))
+
+		g.Expect(cronWorkflows[0]["apiVersion"]).To(g.Equal("argoproj.io/v1alpha1"))
+		g.Expect(cronWorkflows[0]["kind"]).To(g.Equal("CronWorkflow"))
+		g.Expect(cronWorkflows[0]["metadata"]).To(g.HaveKeyWithValue("name", "daily-report"))
+		g.Expect(cronWorkflows[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "data"))
+		g.Expect(cronWorkflows[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"schedule":                   "0 6 * * *",
+			"timezone":                   "America/Sao_Paulo",
+			"concurrencyPolicy":          "Forbid",
+			"successfulJobsHistoryLimit": float64(3),
+			"failedJobsHistoryLimit":     float64(3),
+			"workflowSpec": map[string]interface{}{
+				"entrypoint":         "daily-report",
+				"serviceAccountName": "reports",
+				"archiveLogs":        true,
+				"ttlStrategy": map[string]interface{}{
+					"secondsAfterSuccess": float64(86400),
+					"secondsAfterFailure": float64(604800),
+				},
+				"podGC": map[string]interface{}{
+					"strategy": "OnPodSuccess",
+				},
+				"templates": []interface{}{
+					map[string]interface{}{
+						"name": "daily-report",
+						"container": map[string]interface{}{
+							"name":    "main",
+							"image":   "reports:1.0.0",
+							"command": []interface{}{"report"},
+							"args":    []interface{}{"--daily"},
+							"resources": map[string]interface{}{
+								"requests": map[string]interface{}{
+									"cpu":    "100m",
+									"memory": "128Mi",
+								},
+							},
+						},
+						"retryStrategy": map[string]interface{}{
+							"limit":       float64(2),
+							"retryPolicy": "Always",
+							"backoff": map[string]interface{}{
+								"duration": "1m",
+								"factor":   float64(2),
+							},
+						},
+						"outputs": map[string]interface{}{
+							"artifacts": []interface{}{
+								map[string]interface{}{
+									"name": "report",
+									"path": "/tmp/report.csv",
+								},
+							},
+						},
+					},
+				},
+			},
+		}))
+	})
+
+	ginkgo.It("notifies Slack on exit", func() {
+		cronWorkflows, err := generate(`
+  archiveLogs: false
+  notifications:
+    slack:
+      channel: "#data-alerts"
+      webhookSecret:
+        name: slack
+        key: webhook
+  jobs:
+    - name: daily-report
+      schedule: "@daily"
+      image: reports:1.0.0
+      retries: 0
+    - name: weekly-report
+      schedule: "@weekly"
+      image: reports:1.0.0
+      notifications:
+        slack:
+          webhookSecret:
+            name: slack
+            key: webhook
+        onSuccess: true
+    - name: monthly-report
+      schedule: "@monthly"
+      image: reports:1.0.0
+      notifications:
+        onFailure: false
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(cronWorkflows).To(g.HaveLen(3))
+
+		workflowSpec := cronWorkflows[0]["spec"].(map[string]interface{})["workflowSpec"].(map[string]interface{})
+		g.Expect(workflowSpec).To(g.HaveKeyWithValue("archiveLogs", false))
+		g.Expect(workflowSpec).To(g.HaveKeyWithValue("onExit", "exit-handler"))
+
+		templates := workflowSpec["templates"].([]interface{})
+		g.Expect(templates).To(g.HaveLen(3))
+		g.Expect(templates[0]).NotTo(g.HaveKey("retryStrategy"))
+		g.Expect(templates[1]).To(g.Equal(map[string]interface{}{
+			"name": "exit-handler",
+			"steps": []interface{}{
+				[]interface{}{
+					map[string]interface{}{
+						"name":     "notify",
+						"template": "notify",
+						"when":     "{{workflow.status}} != Succeeded",
+					},
+				},
+			},
+		}))
+		g.Expect(templates[2]).To(g.Equal(map[string]interface{}{
+			"name": "notify",
+			"container": map[string]interface{}{
+				"name":  "main",
+				"image": "curlimages/curl:8.5.0",
+				"command": []interface{}{
+					"curl",
+					"--fail",
+					"--silent",
+					"--show-error",
+					"--header",
+					"Content-Type: application/json",
+					"--data",
+					`{"channel":"#data-alerts","text":"CronWorkflow data/daily-report finished with status {{workflow.status}}: {{workflow.name}}"}`,
+					"$(SLACK_WEBHOOK_URL)",
+				},
+				"env": []interface{}{
+					map[string]interface{}{
+						"name": "SLACK_WEBHOOK_URL",
+						"valueFrom": map[string]interface{}{
+							"secretKeyRef": map[string]interface{}{
+								"name": "slack",
+								"key":  "webhook",
+							},
+						},
+					},
+				},
+				"resources": map[string]interface{}{},
+			},
+		}))
+
+		workflowSpec = cronWorkflows[1]["spec"].(map[string]interface{})["workflowSpec"].(map[string]interface{})
+		templates = workflowSpec["templates"].([]interface{})
+		g.Expect(templates[1].(map[string]interface{})["steps"]).To(g.Equal([]interface{}{
+			[]interface{}{
+				map[string]interface{}{
+					"name":     "notify",
+					"template": "notify",
+				},
+			},
+		}))
+
+		workflowSpec = cronWorkflows[2]["spec"].(map[string]interface{})["workflowSpec"].(map[string]interface{})
+		g.Expect(workflowSpec).NotTo(g.HaveKey("onExit"))
+		g.Expect(workflowSpec["templates"]).To(g.HaveLen(1))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := generate(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without jobs", "", "spec.jobs is empty"),
+		ginkgo.Entry("with invalid schedules", "  jobs:\n    - name: report\n      schedule: 0 6 *\n      image: reports\n", `job report: invalid schedule "0 6 *"`),
+		ginkgo.Entry("without image", "  jobs:\n    - name: report\n      schedule: \"@daily\"\n", "job report has no image"),
+		ginkgo.Entry("without webhook secret", "  notifications:\n    slack:\n      channel: \"#alerts\"\n  jobs:\n    - name: report\n      schedule: \"@daily\"\n      image: reports\n", "job report: slack notifications require a webhookSecret with name and key"),
+	)
+})
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-datadog-autodiscovery"
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+	defaultServiceLabel     = "incognia.com/service"
+	defaultVersionLabel     = "app.kubernetes.io/version"
+
+	envTag     = "tags.datadoghq.com/env"
+	serviceTag = "tags.datadoghq.com/service"
+	versionTag = "tags.datadoghq.com/version"
+
+	autodiscoveryPrefix = "ad.datadoghq.com/"
+	logsSuffix          = ".logs"
+	checksSuffix        = ".checks"
+
+	cronJobKind = "CronJob"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+)
+
+type DatadogAutodiscovery struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Environment      string              `json:"environment,omitempty"`
+	EnvironmentLabel string              `json:"environmentLabel,omitempty"`
+	ServiceLabel     string              `json:"serviceLabel,omitempty"`
+	VersionLabel     string              `json:"versionLabel,omitempty"`
+	Containers       []string            `json:"containers,omitempty"`
+	Kinds            []string            `json:"kinds,omitempty"`
+	Default          Settings            `json:"default,omitempty"`
+	Environments     map[string]Settings `json:"environments,omitempty"`
+}
+
+type Settings struct {
+	Disabled bool             `json:"disabled,omitempty"`
+	Logs     *Logs            `json:"logs,omitempty"`
+	Checks   map[string]Check `json:"checks,omitempty"`
+}
+
+type Logs struct {
+	Source string `json:"source,omitempty"`
+}
+
+type Check struct {
+	InitConfig map[string]interface{}   `json:"init_config"`
+	Instances  []map[string]interface{} `json:"instances"`
+}
+
+type logsConfig struct {
+	Source  string `json:"source,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var datadogAutodiscovery DatadogAutodiscovery
+	if err := pluginconfig.Unmarshal(data, &datadogAutodiscovery); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&datadogAutodiscovery, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(datadogAutodiscovery *DatadogAutodiscovery, nodes []*kyaml.RNode) error {
+	spec := &datadogAutodiscovery.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	serviceLabel := spec.ServiceLabel
+	if serviceLabel == "" {
+		serviceLabel = defaultServiceLabel
+	}
+
+	versionLabel := spec.VersionLabel
+	if versionLabel == "" {
+		versionLabel = defaultVersionLabel
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		nodeLabels := node.GetLabels()
+
+		environment := spec.Environment
+		if environment == "" {
+			environment = nodeLabels[environmentLabel]
+		}
+
+		settings := mergeSettings(&spec.Default, spec.Environments[environment])
+		if settings.Disabled {
+			continue
+		}
+
+		service := nodeLabels[serviceLabel]
+		if service == "" {
+			service = node.GetName()
+		}
+
+		containers, err := readContainers(template)
+		if err != nil {
+			return err
+		}
+
+		version := nodeLabels[versionLabel]
+		if version == "" && len(containers) > 0 {
+			version = imageTag(containers[0].image)
+		}
+
+		tags := map[string]string{
+			envTag:     environment,
+			serviceTag: service,
+			versionTag: version,
+		}
+		for _, target := range []*kyaml.RNode{node, template} {
+			if err := setTags(target, tags); err != nil {
+				return err
+			}
+		}
+
+		annotations, err := makeAnnotations(settings, service, containers, spec.Containers)
+		if err != nil {
+			return err
+		}
+
+		current := template.GetAnnotations()
+		for _, key := range sortedKeys(annotations) {
+			if _, exists := current[key]; exists {
+				continue
+			}
+
+			if err := template.PipeE(kyaml.SetAnnotation(key, annotations[key])); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// mergeSettings overlays the settings of an environment on the default ones,
+// with checks merged by name. Datadog rejects checks with a null init_config,
+// so it defaults to an empty one.
+func mergeSettings(defaults *Settings, environment Settings) *Settings {
+	merged := Settings{
+		Disabled: defaults.Disabled || environment.Disabled,
+		Logs:     defaults.Logs,
+		Checks:   make(map[string]Check),
+	}
+
+	if environment.Logs != nil {
+		merged.Logs = environment.Logs
+	}
+
+	for name, check := range defaults.Checks {
+		merged.Checks[name] = check
+	}
+
+	for name, check := range environment.Checks {
+		merged.Checks[name] = check
+	}
+
+	for name, check := range merged.Checks {
+		if check.InitConfig == nil {
+			check.InitConfig = make(map[string]interface{})
+			merged.Checks[name] = check
+		}
+	}
+
+	return &merged
+}
+
+type container struct {
+	name  string
+	image string
+}
+
+func readContainers(template *kyaml.RNode) ([]container, error) {
+	list, err := template.Pipe(kyaml.Lookup("spec", "containers"))
+	if err != nil {
+		return nil, err
+	}
+	if list == nil {
+		return nil, nil
+	}
+
+	elements, err := list.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	containers := make([]container, 0, len(elements))
+	for _, element := range elements {
+		name, err := element.Pipe(kyaml.Lookup("name"))
+		if err != nil {
+			return nil, err
+		}
+
+		image, err := element.Pipe(kyaml.Lookup("image"))
+		if err != nil {
+			return nil, err
+		}
+
+		containers = append(containers, container{
+			name:  kyaml.GetValue(name),
+			image: kyaml.GetValue(image),
+		})
+	}
+
+	return containers, nil
+}
+
+func makeAnnotations(settings *Settings, service string, containers []container, names []string) (map[string]string, error) {
+	annotations := make(map[string]string)
+
+	for _, c := range containers {
+		if len(names) > 0 && !containsString(names, c.name) {
+			continue
+		}
+
+		if settings.Logs != nil {
+			b, err := json.Marshal([]logsConfig{
+				logsConfig{
+					Source:  settings.Logs.Source,
+					Service: service,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			annotations[autodiscoveryPrefix+c.name+logsSuffix] = string(b)
+		}
+
+		if len(settings.Checks) > 0 {
+			b, err := json.Marshal(settings.Checks)
+			if err != nil {
+				return nil, err
+			}
+			annotations[autodiscoveryPrefix+c.name+checksSuffix] = string(b)
+		}
+	}
+
+	return annotations, nil
+}
+
+func setTags(node *kyaml.RNode, tags map[string]string) error {
+	current := node.GetLabels()
+
+	for _, key := range sortedKeys(tags) {
+		if tags[key] == "" {
+			continue
+		}
+
+		if _, exists := current[key]; exists {
+			continue
+		}
+
+		if err := node.PipeE(kyaml.SetLabel(key, tags[key])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// imageTag returns the tag of an image reference, ignoring its digest and the
+// port of its registry.
+func imageTag(image string) string {
+	image = strings.SplitN(image, "@", 2)[0]
+
+	i := strings.LastIndex(image, ":")
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+
+	return image[i+1:]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,115 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/datadogautodiscovery"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: DatadogAutodiscovery
+metadata:
+  name: datadog-autodiscovery
+spec:
+  containers:
+    - app
+  default:
+    logs:
+      source: go
+  environments:
+    production:
+      checks:
+        openmetrics:
+          instances:
+            - openmetrics_endpoint: http://%%host%%:9090/metrics
+    sandbox:
+      disabled: true
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: production
+    incognia.com/service: my-service
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.2.3
+        - name: istio-proxy
+          image: istio/proxyv2:1.13.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-sandbox
+  labels:
+    incognia.com/environment: sandbox
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: my-app:1.2.3
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("DatadogAutodiscovery", func() {
+	ginkgo.It("injects unified service tags and autodiscovery annotations", func() {
+		deployments := transform()
+
+		deployment := deployments["my-app"]
+		tags := map[string]string{
+			"tags.datadoghq.com/env":     "production",
+			"tags.datadoghq.com/service": "my-service",
+			"tags.datadoghq.com/version": "1.2.3",
+		}
+		for key, value := range tags {
+			g.Expect(deployment.Labels).To(g.HaveKeyWithValue(key, value))
+			g.Expect(deployment.Spec.Template.Labels).To(g.HaveKeyWithValue(key, value))
+		}
+
+		g.Expect(deployment.Spec.Template.Annotations).To(g.Equal(map[string]string{
+			"ad.datadoghq.com/app.logs":   `[{"source":"go","service":"my-service"}]`,
+			"ad.datadoghq.com/app.checks": `{"openmetrics":{"init_config":{},"instances":[{"openmetrics_endpoint":"http://%%host%%:9090/metrics"}]}}`,
+		}))
+	})
+
+	ginkgo.It("skips disabled environments", func() {
+		deployment := transform()["my-sandbox"]
+
+		g.Expect(deployment.Labels).NotTo(g.HaveKey("tags.datadoghq.com/env"))
+		g.Expect(deployment.Spec.Template.Annotations).To(g.BeEmpty())
+	})
+})
+
+func transform() map[string]appsv1.Deployment {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	deployments := make(map[string]appsv1.Deployment)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+		deployments[deployment.Name] = deployment
+	}
+
+	return deployments
+}
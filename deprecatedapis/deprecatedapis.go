@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	pluginName     = "deprecatedapis"
+	removedAPIRule = "removed-api"
+
+	versionPrefix    = "v"
+	versionSeparator = "."
+)
+
+type removal struct {
+	apiVersion  string
+	kinds       []string
+	removedIn   int
+	replacement string
+	rewritable  bool
+	guidance    string
+}
+
+// removals lists the APIs removed up to Kubernetes 1.32 by the minor version
+// removing them. Only APIs whose schema is unchanged by the replacement are
+// rewritable, the others need to be migrated by hand.
+var removals = []removal{
+	{"extensions/v1beta1", []string{"DaemonSet", "Deployment", "ReplicaSet"}, 16, "apps/v1", false, "spec.selector is required and must match the pod template labels"},
+	{"apps/v1beta1", []string{"Deployment", "StatefulSet"}, 16, "apps/v1", false, "spec.selector is required and must match the pod template labels"},
+	{"apps/v1beta2", []string{"DaemonSet", "Deployment", "ReplicaSet", "StatefulSet"}, 16, "apps/v1", false, "spec.selector is required and must match the pod template labels"},
+	{"extensions/v1beta1", []string{"NetworkPolicy"}, 16, "networking.k8s.io/v1", true, ""},
+	{"extensions/v1beta1", []string{"PodSecurityPolicy"}, 16, "policy/v1beta1", true, ""},
+	{"extensions/v1beta1", []string{"Ingress"}, 22, "networking.k8s.io/v1", false, "backends are nested under service with port.number or port.name, and pathType is required"},
+	{"networking.k8s.io/v1beta1", []string{"Ingress"}, 22, "networking.k8s.io/v1", false, "backends are nested under service with port.number or port.name, and pathType is required"},
+	{"networking.k8s.io/v1beta1", []string{"IngressClass"}, 22, "networking.k8s.io/v1", true, ""},
+	{"rbac.authorization.k8s.io/v1beta1", []string{"ClusterRole", "ClusterRoleBinding", "Role", "RoleBinding"}, 22, "rbac.authorization.k8s.io/v1", true, ""},
+	{"apiextensions.k8s.io/v1beta1", []string{"CustomResourceDefinition"}, 22, "apiextensions.k8s.io/v1", false, "schemas are required per version under spec.versions[*].schema.openAPIV3Schema"},
+	{"admissionregistration.k8s.io/v1beta1", []string{"MutatingWebhookConfiguration", "ValidatingWebhookConfiguration"}, 22, "admissionregistration.k8s.io/v1", false, "webhooks[*].sideEffects and webhooks[*].admissionReviewVersions are required"},
+	{"scheduling.k8s.io/v1beta1", []string{"PriorityClass"}, 22, "scheduling.k8s.io/v1", true, ""},
+	{"storage.k8s.io/v1beta1", []string{"CSIDriver", "CSINode", "StorageClass", "VolumeAttachment"}, 22, "storage.k8s.io/v1", true, ""},
+	{"coordination.k8s.io/v1beta1", []string{"Lease"}, 22, "coordination.k8s.io/v1", true, ""},
+	{"certificates.k8s.io/v1beta1", []string{"CertificateSigningRequest"}, 22, "certificates.k8s.io/v1", false, "spec.signerName is required"},
+	{"batch/v1beta1", []string{"CronJob"}, 25, "batch/v1", true, ""},
+	{"discovery.k8s.io/v1beta1", []string{"EndpointSlice"}, 25, "discovery.k8s.io/v1", false, "endpoints[*].topology is replaced by nodeName and zone"},
+	{"events.k8s.io/v1beta1", []string{"Event"}, 25, "events.k8s.io/v1", false, "deprecatedFirstTimestamp, deprecatedLastTimestamp and deprecatedCount replace their v1beta1 counterparts"},
+	{"autoscaling/v2beta1", []string{"HorizontalPodAutoscaler"}, 25, "autoscaling/v2", false, "metric targets are nested under target with type and value, averageValue or averageUtilization"},
+	{"policy/v1beta1", []string{"PodDisruptionBudget"}, 25, "policy/v1", false, "an empty spec.selector selects every pod of the namespace"},
+	{"policy/v1beta1", []string{"PodSecurityPolicy"}, 25, "", false, "use Pod Security Admission instead"},
+	{"node.k8s.io/v1beta1", []string{"RuntimeClass"}, 25, "node.k8s.io/v1", true, ""},
+	{"autoscaling/v2beta2", []string{"HorizontalPodAutoscaler"}, 26, "autoscaling/v2", true, ""},
+	{"flowcontrol.apiserver.k8s.io/v1beta1", []string{"FlowSchema", "PriorityLevelConfiguration"}, 26, "flowcontrol.apiserver.k8s.io/v1", true, ""},
+	{"storage.k8s.io/v1beta1", []string{"CSIStorageCapacity"}, 27, "storage.k8s.io/v1", true, ""},
+	{"flowcontrol.apiserver.k8s.io/v1beta2", []string{"FlowSchema", "PriorityLevelConfiguration"}, 29, "flowcontrol.apiserver.k8s.io/v1", true, ""},
+	{"flowcontrol.apiserver.k8s.io/v1beta3", []string{"FlowSchema", "PriorityLevelConfiguration"}, 32, "flowcontrol.apiserver.k8s.io/v1", true, ""},
+}
+
+type DeprecatedAPIs struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	TargetVersion string `json:"targetVersion,omitempty"`
+	Rewrite       bool   `json:"rewrite,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var deprecatedAPIs DeprecatedAPIs
+	if err := pluginconfig.Unmarshal(data, &deprecatedAPIs); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&deprecatedAPIs, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(deprecatedAPIs *DeprecatedAPIs, nodes []*kyaml.RNode) error {
+	spec := &deprecatedAPIs.Spec
+
+	if spec.TargetVersion == "" {
+		return fmt.Errorf("spec.targetVersion is empty")
+	}
+
+	targetMinor, err := parseMinor(spec.TargetVersion)
+	if err != nil {
+		return err
+	}
+
+	var found []findings.Finding
+	for _, node := range nodes {
+		// Replacements may have been removed as well, so rewriting goes on
+		// until a supported API is reached.
+		for {
+			removal := findRemoval(node.GetApiVersion(), node.GetKind(), targetMinor)
+			if removal == nil {
+				break
+			}
+
+			if spec.Rewrite && removal.rewritable {
+				node.SetApiVersion(removal.replacement)
+				continue
+			}
+
+			found = append(found, describeViolation(node, removal))
+			break
+		}
+	}
+
+	if err := findings.WriteReportFromEnv(pluginName, deprecatedAPIs.Name, found); err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("resources using APIs removed by Kubernetes %s:\n%s", spec.TargetVersion, findings.Lines(found))
+	}
+
+	return nil
+}
+
+func findRemoval(apiVersion string, kind string, targetMinor int) *removal {
+	for i := range removals {
+		removal := &removals[i]
+		if removal.apiVersion != apiVersion || removal.removedIn > targetMinor {
+			continue
+		}
+
+		if containsString(removal.kinds, kind) {
+			return removal
+		}
+	}
+
+	return nil
+}
+
+func describeViolation(node *kyaml.RNode, removal *removal) findings.Finding {
+	description := fmt.Sprintf("%s was removed in 1.%d", removal.apiVersion, removal.removedIn)
+
+	if removal.replacement != "" {
+		description += fmt.Sprintf(", use %s", removal.replacement)
+	}
+
+	if removal.rewritable {
+		description += " (rewritable by setting spec.rewrite)"
+	} else if removal.guidance != "" {
+		description += fmt.Sprintf(" (%s)", removal.guidance)
+	}
+
+	return findings.Finding{
+		Path:     findings.SourcePath(node.GetAnnotations()),
+		Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+		Rule:     removedAPIRule,
+		Message:  description,
+	}
+}
+
+// parseMinor returns the minor version of a Kubernetes version such as 1.25,
+// v1.25 or 1.25.3.
+func parseMinor(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, versionPrefix), versionSeparator)
+	if len(parts) < 2 || parts[0] != "1" {
+		return 0, fmt.Errorf("invalid target version %s", version)
+	}
+
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, fmt.Errorf("invalid target version %s", version)
+	}
+
+	return minor, nil
+}
+
+func resourceName(node *kyaml.RNode) string {
+	if namespace := node.GetNamespace(); namespace != "" {
+		return namespace + "/" + node.GetName()
+	}
+
+	return node.GetName()
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
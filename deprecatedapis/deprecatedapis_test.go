@@ -0,0 +1,118 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/deprecatedapis"
+)
+
+const (
+	resources = `
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: report
+  namespace: my-namespace
+---
+apiVersion: rbac.authorization.k8s.io/v1beta1
+kind: ClusterRole
+metadata:
+  name: reader
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+`
+
+	ingress = `
+---
+apiVersion: networking.k8s.io/v1beta1
+kind: Ingress
+metadata:
+  name: my-app
+  namespace: my-namespace
+`
+)
+
+var _ = ginkgo.Describe("DeprecatedAPIs", func() {
+	transform := func(spec string, resources string) (string, error) {
+		var out bytes.Buffer
+		err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: DeprecatedAPIs
+metadata:
+  name: deprecated-apis
+spec:
+`+spec), strings.NewReader(resources), &out)
+
+		return out.String(), err
+	}
+
+	ginkgo.It("passes resources through for older targets", func() {
+		out, err := transform("  targetVersion: \"1.21\"\n", resources+ingress)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.ContainSubstring("apiVersion: batch/v1beta1"))
+		g.Expect(out).To(g.ContainSubstring("apiVersion: networking.k8s.io/v1beta1"))
+	})
+
+	ginkgo.It("fails with guidance", func() {
+		_, err := transform("  targetVersion: v1.25.3\n", resources+ingress)
+		g.Expect(err).To(g.MatchError(`resources using APIs removed by Kubernetes v1.25.3:
+CronJob my-namespace/report: batch/v1beta1 was removed in 1.25, use batch/v1 (rewritable by setting spec.rewrite)
+ClusterRole reader: rbac.authorization.k8s.io/v1beta1 was removed in 1.22, use rbac.authorization.k8s.io/v1 (rewritable by setting spec.rewrite)
+Ingress my-namespace/my-app: networking.k8s.io/v1beta1 was removed in 1.22, use networking.k8s.io/v1 (backends are nested under service with port.number or port.name, and pathType is required)`))
+	})
+
+	ginkgo.It("reports resources using removed APIs", func() {
+		dir, err := ioutil.TempDir("", "deprecatedapis")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		g.Expect(os.Setenv("FINDINGS_DIR", dir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_DIR")
+
+		_, err = transform("  targetVersion: v1.25.3\n", resources)
+		g.Expect(err).To(g.HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "deprecatedapis-deprecated-apis.sarif"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring(`"ruleId": "removed-api"`))
+		g.Expect(string(data)).To(g.ContainSubstring(`"name": "CronJob my-namespace/report"`))
+	})
+
+	ginkgo.It("rewrites APIs with unchanged schemas", func() {
+		out, err := transform("  targetVersion: \"1.25\"\n  rewrite: true\n", resources)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.ContainSubstring("apiVersion: batch/v1\nkind: CronJob"))
+		g.Expect(out).To(g.ContainSubstring("apiVersion: rbac.authorization.k8s.io/v1\nkind: ClusterRole"))
+
+		_, err = transform("  targetVersion: \"1.25\"\n  rewrite: true\n", resources+ingress)
+		g.Expect(err).To(g.MatchError(g.ContainSubstring("Ingress my-namespace/my-app")))
+	})
+
+	ginkgo.It("fails on replacements removed as well", func() {
+		_, err := transform("  targetVersion: \"1.25\"\n  rewrite: true\n", `
+apiVersion: extensions/v1beta1
+kind: PodSecurityPolicy
+metadata:
+  name: restricted
+`)
+		g.Expect(err).To(g.MatchError(g.ContainSubstring("PodSecurityPolicy restricted: policy/v1beta1 was removed in 1.25 (use Pod Security Admission instead)")))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := transform(spec, resources)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without target version", "  rewrite: true\n", "spec.targetVersion is empty"),
+		ginkgo.Entry("with invalid target version", "  targetVersion: latest\n", "invalid target version latest"),
+	)
+})
@@ -0,0 +1,433 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	pathSeparator  = "."
+
+	defaultContextEnv    = "KUBE_CONTEXT"
+	defaultTrackingLabel = "app.kubernetes.io/instance"
+
+	redactedValue  = "<redacted>"
+	statusField    = "status"
+	stringDataPath = "stringData"
+)
+
+var (
+	secretKind = reflect.TypeOf(corev1.Secret{}).Name()
+)
+
+type DriftReport struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	ContextEnv    string `json:"contextEnv,omitempty"`
+	Application   string `json:"application,omitempty"`
+	TrackingLabel string `json:"trackingLabel,omitempty"`
+	Output        string `json:"output,omitempty"`
+}
+
+type Report struct {
+	Context string     `json:"context,omitempty"`
+	Missing []Resource `json:"missing"`
+	Drifted []Resource `json:"drifted"`
+	Extra   []Resource `json:"extra"`
+}
+
+type Resource struct {
+	APIVersion string  `json:"apiVersion"`
+	Kind       string  `json:"kind"`
+	Namespace  string  `json:"namespace,omitempty"`
+	Name       string  `json:"name"`
+	Fields     []Field `json:"fields,omitempty"`
+}
+
+type Field struct {
+	Path     string      `json:"path"`
+	Expected interface{} `json:"expected"`
+	Actual   interface{} `json:"actual"`
+}
+
+type reportDocument struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Report            Report `json:"report"`
+}
+
+// Cluster reads live objects, returning nil for objects or kinds missing
+// from the cluster.
+type Cluster interface {
+	Get(ctx context.Context, gvk schema.GroupVersionKind, namespace string, name string) (map[string]interface{}, error)
+	List(ctx context.Context, gvk schema.GroupVersionKind, namespace string, selector string) ([]map[string]interface{}, error)
+}
+
+type ClusterFactory func(context string) (Cluster, error)
+
+type kubernetesCluster struct {
+	client    dynamic.Interface
+	mapper    meta.RESTMapper
+	namespace string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	return TransformManifestsWithClusterFactory(newKubernetesCluster, data, in, out)
+}
+
+func TransformManifestsWithClusterFactory(newCluster ClusterFactory, data []byte, in io.Reader, out io.Writer) error {
+	var driftReport DriftReport
+	if err := pluginconfig.Unmarshal(data, &driftReport); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	contextEnv := driftReport.Spec.ContextEnv
+	if contextEnv == "" {
+		contextEnv = defaultContextEnv
+	}
+	kubeContext := os.Getenv(contextEnv)
+
+	cluster, err := newCluster(kubeContext)
+	if err != nil {
+		return err
+	}
+
+	report, err := makeReport(cluster, &driftReport, nodes)
+	if err != nil {
+		return err
+	}
+	report.Context = kubeContext
+
+	b, err := yaml.Marshal(reportDocument{
+		TypeMeta:   driftReport.TypeMeta,
+		ObjectMeta: driftReport.ObjectMeta,
+		Report:     *report,
+	})
+	if err != nil {
+		return err
+	}
+
+	if driftReport.Spec.Output == "" {
+		_, err := out.Write(b)
+		return err
+	}
+
+	if err := ioutil.WriteFile(driftReport.Spec.Output, b, 0644); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func newKubernetesCluster(kubeContext string) (Cluster, error) {
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{
+			CurrentContext: kubeContext,
+		},
+	)
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubernetesCluster{
+		client:    client,
+		mapper:    restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient)),
+		namespace: namespace,
+	}, nil
+}
+
+func (c *kubernetesCluster) resource(gvk schema.GroupVersionKind, namespace string) (dynamic.ResourceInterface, error) {
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return nil, err
+	}
+
+	if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+		return c.client.Resource(mapping.Resource), nil
+	}
+
+	if namespace == "" {
+		namespace = c.namespace
+	}
+
+	return c.client.Resource(mapping.Resource).Namespace(namespace), nil
+}
+
+func (c *kubernetesCluster) Get(ctx context.Context, gvk schema.GroupVersionKind, namespace string, name string) (map[string]interface{}, error) {
+	resource, err := c.resource(gvk, namespace)
+	if meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	object, err := resource.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return object.Object, nil
+}
+
+func (c *kubernetesCluster) List(ctx context.Context, gvk schema.GroupVersionKind, namespace string, selector string) ([]map[string]interface{}, error) {
+	resource, err := c.resource(gvk, namespace)
+	if meta.IsNoMatchError(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := resource.List(ctx, metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]map[string]interface{}, 0, len(list.Items))
+	for _, item := range list.Items {
+		objects = append(objects, item.Object)
+	}
+
+	return objects, nil
+}
+
+func makeReport(cluster Cluster, driftReport *DriftReport, nodes []*kyaml.RNode) (*Report, error) {
+	spec := &driftReport.Spec
+	ctx := context.Background()
+
+	report := Report{
+		Missing: []Resource{},
+		Drifted: []Resource{},
+		Extra:   []Resource{},
+	}
+
+	type scope struct {
+		gvk       schema.GroupVersionKind
+		namespace string
+	}
+	var scopes []scope
+	rendered := make(map[scope][]string)
+
+	for _, node := range nodes {
+		gvk := schema.FromAPIVersionAndKind(node.GetApiVersion(), node.GetKind())
+		resource := Resource{
+			APIVersion: node.GetApiVersion(),
+			Kind:       node.GetKind(),
+			Namespace:  node.GetNamespace(),
+			Name:       node.GetName(),
+		}
+
+		s := scope{gvk, resource.Namespace}
+		if _, exists := rendered[s]; !exists {
+			scopes = append(scopes, s)
+		}
+		rendered[s] = append(rendered[s], resource.Name)
+
+		live, err := cluster.Get(ctx, gvk, resource.Namespace, resource.Name)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", resource.Kind, resource.Name, err)
+		}
+		if live == nil {
+			report.Missing = append(report.Missing, resource)
+			continue
+		}
+
+		expected, err := node.Map()
+		if err != nil {
+			return nil, err
+		}
+		delete(expected, statusField)
+		if resource.Kind == secretKind {
+			delete(expected, stringDataPath)
+		}
+
+		compare("", expected, live, &resource.Fields)
+		if len(resource.Fields) == 0 {
+			continue
+		}
+
+		if resource.Kind == secretKind {
+			for i := range resource.Fields {
+				resource.Fields[i].Expected = redactedValue
+				resource.Fields[i].Actual = redactedValue
+			}
+		}
+		report.Drifted = append(report.Drifted, resource)
+	}
+
+	if spec.Application == "" {
+		return &report, nil
+	}
+
+	trackingLabel := spec.TrackingLabel
+	if trackingLabel == "" {
+		trackingLabel = defaultTrackingLabel
+	}
+	selector := fmt.Sprintf("%s=%s", trackingLabel, spec.Application)
+
+	for _, s := range scopes {
+		objects, err := cluster.List(ctx, s.gvk, s.namespace, selector)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", s.gvk.Kind, err)
+		}
+
+		var extra []Resource
+		for _, object := range objects {
+			metadata, _ := object["metadata"].(map[string]interface{})
+			name, _ := metadata["name"].(string)
+			if containsString(rendered[s], name) {
+				continue
+			}
+
+			namespace, _ := metadata["namespace"].(string)
+			extra = append(extra, Resource{
+				APIVersion: s.gvk.GroupVersion().String(),
+				Kind:       s.gvk.Kind,
+				Namespace:  namespace,
+				Name:       name,
+			})
+		}
+
+		sort.Slice(extra, func(i, j int) bool {
+			return extra[i].Name < extra[j].Name
+		})
+		report.Extra = append(report.Extra, extra...)
+	}
+
+	return &report, nil
+}
+
+// compare walks the fields declared by the build only, since live objects
+// carry defaults and fields written by controllers. Lists are compared element
+// by element when their lengths match, and as a whole otherwise.
+func compare(path string, expected interface{}, actual interface{}, fields *[]Field) {
+	switch expected := expected.(type) {
+	case map[string]interface{}:
+		actual, ok := actual.(map[string]interface{})
+		if !ok {
+			*fields = append(*fields, Field{path, expected, actual})
+			return
+		}
+
+		keys := make([]string, 0, len(expected))
+		for key := range expected {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			compare(joinPath(path, key), expected[key], actual[key], fields)
+		}
+	case []interface{}:
+		actual, ok := actual.([]interface{})
+		if !ok || len(actual) != len(expected) {
+			*fields = append(*fields, Field{path, expected, actual})
+			return
+		}
+
+		for i := range expected {
+			compare(fmt.Sprintf("%s[%d]", path, i), expected[i], actual[i], fields)
+		}
+	default:
+		if expected == nil || fmt.Sprint(expected) == fmt.Sprint(actual) {
+			return
+		}
+
+		*fields = append(*fields, Field{path, expected, actual})
+	}
+}
+
+func joinPath(path string, key string) string {
+	if strings.ContainsAny(key, "./") {
+		key = fmt.Sprintf("[%s]", key)
+	} else if path != "" {
+		key = pathSeparator + key
+	}
+
+	return path + key
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
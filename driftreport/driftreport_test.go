@@ -0,0 +1,228 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/driftreport"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-namespace
+  labels:
+    app.kubernetes.io/instance: my-app
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+        - name: app
+          image: my-app:1.0.0
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: my-app
+  namespace: my-namespace
+data:
+  PASSWORD: aHVudGVyMg==
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-app
+  namespace: my-namespace
+data:
+  LOG_LEVEL: info
+`
+
+	live = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-namespace
+  labels:
+    app.kubernetes.io/instance: my-app
+  uid: 0123
+spec:
+  replicas: 5
+  template:
+    spec:
+      containers:
+        - name: app
+          image: my-app:1.0.0
+          imagePullPolicy: IfNotPresent
+status:
+  replicas: 5
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: hotfix
+  namespace: my-namespace
+  labels:
+    app.kubernetes.io/instance: my-app
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: my-app
+  namespace: my-namespace
+data:
+  PASSWORD: Y2hhbmdlZA==
+`
+)
+
+type fakeCluster struct {
+	objects []map[string]interface{}
+}
+
+func (c fakeCluster) Get(_ context.Context, gvk schema.GroupVersionKind, namespace string, name string) (map[string]interface{}, error) {
+	for _, object := range c.objects {
+		if object["kind"] == gvk.Kind && metadata(object)["namespace"] == namespace && metadata(object)["name"] == name {
+			return object, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (c fakeCluster) List(_ context.Context, gvk schema.GroupVersionKind, namespace string, selector string) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for _, object := range c.objects {
+		labels, _ := metadata(object)["labels"].(map[string]interface{})
+		instance, _ := labels["app.kubernetes.io/instance"].(string)
+		if object["kind"] == gvk.Kind && metadata(object)["namespace"] == namespace && "app.kubernetes.io/instance="+instance == selector {
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+func metadata(object map[string]interface{}) map[string]interface{} {
+	return object["metadata"].(map[string]interface{})
+}
+
+var _ = ginkgo.Describe("DriftReport", func() {
+	var cluster fakeCluster
+	var kubeContext string
+	newFakeCluster := func(context string) (main.Cluster, error) {
+		kubeContext = context
+		return cluster, nil
+	}
+
+	ginkgo.BeforeEach(func() {
+		cluster = fakeCluster{}
+		for _, document := range strings.Split(live, "\n---\n") {
+			var object map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(document), &object)).To(g.Succeed())
+			cluster.objects = append(cluster.objects, object)
+		}
+
+		g.Expect(os.Setenv("KUBE_CONTEXT", "staging")).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "KUBE_CONTEXT")
+	})
+
+	ginkgo.It("reports missing, drifted and extra resources", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifestsWithClusterFactory(newFakeCluster, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: DriftReport
+metadata:
+  name: drift-report
+spec:
+  application: my-app
+`), strings.NewReader(resources), &out)).To(g.Succeed())
+		g.Expect(kubeContext).To(g.Equal("staging"))
+
+		var document map[string]interface{}
+		g.Expect(yaml.Unmarshal(out.Bytes(), &document)).To(g.Succeed())
+		g.Expect(document["kind"]).To(g.Equal("DriftReport"))
+		g.Expect(document["report"]).To(g.Equal(map[string]interface{}{
+			"context": "staging",
+			"missing": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "ConfigMap",
+					"namespace":  "my-namespace",
+					"name":       "my-app",
+				},
+			},
+			"drifted": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "apps/v1",
+					"kind":       "Deployment",
+					"namespace":  "my-namespace",
+					"name":       "my-app",
+					"fields": []interface{}{
+						map[string]interface{}{
+							"path":     "spec.replicas",
+							"expected": float64(2),
+							"actual":   float64(5),
+						},
+					},
+				},
+				map[string]interface{}{
+					"apiVersion": "v1",
+					"kind":       "Secret",
+					"namespace":  "my-namespace",
+					"name":       "my-app",
+					"fields": []interface{}{
+						map[string]interface{}{
+							"path":     "data.PASSWORD",
+							"expected": "<redacted>",
+							"actual":   "<redacted>",
+						},
+					},
+				},
+			},
+			"extra": []interface{}{
+				map[string]interface{}{
+					"apiVersion": "apps/v1",
+					"kind":       "Deployment",
+					"namespace":  "my-namespace",
+					"name":       "hotfix",
+				},
+			},
+		}))
+	})
+
+	ginkgo.It("writes the report to a file and passes resources through", func() {
+		dir, err := ioutil.TempDir("", "driftreport")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+		output := filepath.Join(dir, "drift.yaml")
+
+		var out bytes.Buffer
+		g.Expect(main.TransformManifestsWithClusterFactory(newFakeCluster, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: DriftReport
+metadata:
+  name: drift-report
+spec:
+  output: `+output+`
+`), strings.NewReader(resources), &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.ContainSubstring("kind: ConfigMap"))
+
+		data, err := ioutil.ReadFile(output)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring("extra: []"))
+		g.Expect(string(data)).To(g.ContainSubstring("path: spec.replicas"))
+	})
+})
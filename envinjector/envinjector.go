@@ -0,0 +1,272 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	excludeAnnotation  = "incognia.com/env-injection-exclude"
+	cronJobKind        = "CronJob"
+	containerSeparator = ","
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+
+	containerFields = []string{
+		"containers",
+		"initContainers",
+	}
+)
+
+type EnvInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Env                []EnvVar `json:"env,omitempty"`
+	ExcludedContainers []string `json:"excludedContainers,omitempty"`
+	Override           bool     `json:"override,omitempty"`
+	Kinds              []string `json:"kinds,omitempty"`
+}
+
+type EnvVar struct {
+	Name      string               `json:"name,omitempty"`
+	Value     string               `json:"value,omitempty"`
+	Label     string               `json:"label,omitempty"`
+	ValueFrom *corev1.EnvVarSource `json:"valueFrom,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var envInjector EnvInjector
+	if err := pluginconfig.Unmarshal(data, &envInjector); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&envInjector, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(envInjector *EnvInjector, nodes []*kyaml.RNode) error {
+	spec := &envInjector.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	for i, envVar := range spec.Env {
+		if envVar.Name == "" {
+			return fmt.Errorf("spec.env[%d].name is empty", i)
+		}
+
+		sources := 0
+		for _, set := range []bool{envVar.Value != "", envVar.Label != "", envVar.ValueFrom != nil} {
+			if set {
+				sources++
+			}
+		}
+		if sources != 1 {
+			return fmt.Errorf("spec.env[%d] must set exactly one of value, label and valueFrom", i)
+		}
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		envVars := makeEnvVars(spec.Env, node.GetLabels())
+		if len(envVars) == 0 {
+			continue
+		}
+
+		excluded := append([]string{}, spec.ExcludedContainers...)
+		for _, annotations := range []map[string]string{node.GetAnnotations(), template.GetAnnotations()} {
+			for _, name := range strings.Split(annotations[excludeAnnotation], containerSeparator) {
+				if name = strings.TrimSpace(name); name != "" {
+					excluded = append(excluded, name)
+				}
+			}
+		}
+
+		for _, field := range containerFields {
+			containers, err := template.Pipe(kyaml.Lookup("spec", field))
+			if err != nil {
+				return err
+			}
+			if containers == nil {
+				continue
+			}
+
+			elements, err := containers.Elements()
+			if err != nil {
+				return err
+			}
+
+			for _, container := range elements {
+				name, err := container.Pipe(kyaml.Lookup("name"))
+				if err != nil {
+					return err
+				}
+				if containsString(excluded, kyaml.GetValue(name)) {
+					continue
+				}
+
+				if err := mergeEnvVars(container, envVars, spec.Override); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// makeEnvVars resolves the configured variables for a workload, leaving out
+// the ones taken from labels it does not have.
+func makeEnvVars(specEnvVars []EnvVar, labels map[string]string) []corev1.EnvVar {
+	envVars := make([]corev1.EnvVar, 0, len(specEnvVars))
+
+	for _, specEnvVar := range specEnvVars {
+		envVar := corev1.EnvVar{
+			Name:      specEnvVar.Name,
+			Value:     specEnvVar.Value,
+			ValueFrom: specEnvVar.ValueFrom,
+		}
+
+		if specEnvVar.Label != "" {
+			value, exists := labels[specEnvVar.Label]
+			if !exists {
+				continue
+			}
+			envVar.Value = value
+		}
+
+		envVars = append(envVars, envVar)
+	}
+
+	return envVars
+}
+
+func mergeEnvVars(container *kyaml.RNode, envVars []corev1.EnvVar, override bool) error {
+	env, err := container.Pipe(kyaml.LookupCreate(kyaml.SequenceNode, "env"))
+	if err != nil {
+		return err
+	}
+
+	for _, envVar := range envVars {
+		b, err := yaml.Marshal(envVar)
+		if err != nil {
+			return err
+		}
+
+		element, err := kyaml.Parse(string(b))
+		if err != nil {
+			return err
+		}
+
+		current, err := env.Pipe(kyaml.MatchElement("name", envVar.Name))
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			if err := env.PipeE(kyaml.Append(element.YNode())); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if override {
+			current.SetYNode(element.YNode())
+		}
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
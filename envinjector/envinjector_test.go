@@ -0,0 +1,110 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/envinjector"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: EnvInjector
+metadata:
+  name: env-injector
+spec:
+  env:
+    - name: DD_ENV
+      label: incognia.com/environment
+    - name: SERVICE_NAME
+      label: incognia.com/service
+    - name: AWS_REGION
+      value: us-east-1
+  excludedContainers:
+    - istio-proxy
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: production
+  annotations:
+    incognia.com/env-injection-exclude: migrations
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrations
+      containers:
+        - name: app
+          env:
+            - name: AWS_REGION
+              value: sa-east-1
+        - name: istio-proxy
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("EnvInjector", func() {
+	ginkgo.It("merges env vars into containers", func() {
+		podSpec := transform(config).Spec.Template.Spec
+
+		g.Expect(podSpec.Containers[0].Env).To(g.Equal([]corev1.EnvVar{
+			{Name: "AWS_REGION", Value: "sa-east-1"},
+			{Name: "DD_ENV", Value: "production"},
+		}))
+		g.Expect(podSpec.Containers[1].Env).To(g.BeEmpty())
+		g.Expect(podSpec.InitContainers[0].Env).To(g.BeEmpty())
+	})
+
+	ginkgo.It("overrides env vars when configured", func() {
+		podSpec := transform(config + "  override: true\n").Spec.Template.Spec
+
+		g.Expect(podSpec.Containers[0].Env).To(g.Equal([]corev1.EnvVar{
+			{Name: "AWS_REGION", Value: "us-east-1"},
+			{Name: "DD_ENV", Value: "production"},
+		}))
+	})
+
+	ginkgo.It("fails on env vars with several sources", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: EnvInjector
+metadata:
+  name: env-injector
+spec:
+  env:
+    - name: DD_ENV
+      value: production
+      label: incognia.com/environment
+`), strings.NewReader(resources), &out)).To(g.MatchError("spec.env[0] must set exactly one of value, label and valueFrom"))
+	})
+})
+
+func transform(config string) appsv1.Deployment {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	manifests := separatorYaml.Split(out.String(), -1)
+	g.Expect(manifests).To(g.HaveLen(1))
+
+	var deployment appsv1.Deployment
+	g.Expect(yaml.Unmarshal([]byte(manifests[0]), &deployment)).To(g.Succeed())
+
+	return deployment
+}
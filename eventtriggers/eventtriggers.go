@@ -0,0 +1,724 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	eventSourceKind     = "EventSource"
+	sensorKind          = "Sensor"
+	workflowKind        = "Workflow"
+	applicationKind     = "Application"
+	eventSourceSuffix   = "-eventsource"
+	sensorSuffix        = "-sensor"
+	roleARNAnnotation   = "eks.amazonaws.com/role-arn"
+	argoGroup           = "argoproj.io"
+	submitOperation     = "submit"
+	patchOperation      = "patch"
+	mergePatchStrategy  = "application/merge-patch+json"
+	initiatedBy         = "argo-events"
+	stringFilterType    = "string"
+	s3BucketPath        = "body.Records.0.s3.bucket.name"
+	s3KeyPath           = "body.Records.0.s3.object.key"
+	parameterDestFormat = "spec.arguments.parameters.%d.value"
+
+	defaultWebhookPort     = 12000
+	defaultWebhookMethod   = "POST"
+	defaultWaitTimeSeconds = 20
+	defaultArgoCDNamespace = "argocd"
+)
+
+var (
+	argoGroupVersion = schema.GroupVersion{
+		Group:   argoGroup,
+		Version: "v1alpha1",
+	}
+
+	roleARNRegexp = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`)
+)
+
+type EventTriggers struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	EventBusName string    `json:"eventBusName,omitempty"`
+	RoleARN      string    `json:"roleARN,omitempty"`
+	Triggers     []Trigger `json:"triggers,omitempty"`
+}
+
+type Trigger struct {
+	Name     string    `json:"name,omitempty"`
+	Webhook  *Webhook  `json:"webhook,omitempty"`
+	SQS      *SQS      `json:"sqs,omitempty"`
+	S3       *S3       `json:"s3,omitempty"`
+	Workflow *Workflow `json:"workflow,omitempty"`
+	Sync     *Sync     `json:"sync,omitempty"`
+}
+
+type Webhook struct {
+	Port       int32                     `json:"port,omitempty"`
+	Endpoint   string                    `json:"endpoint,omitempty"`
+	Method     string                    `json:"method,omitempty"`
+	AuthSecret *corev1.SecretKeySelector `json:"authSecret,omitempty"`
+}
+
+type SQS struct {
+	Region          string `json:"region,omitempty"`
+	Queue           string `json:"queue,omitempty"`
+	WaitTimeSeconds int64  `json:"waitTimeSeconds,omitempty"`
+}
+
+type S3 struct {
+	Region string `json:"region,omitempty"`
+	Queue  string `json:"queue,omitempty"`
+	Bucket string `json:"bucket,omitempty"`
+	Prefix string `json:"prefix,omitempty"`
+	Suffix string `json:"suffix,omitempty"`
+}
+
+type Workflow struct {
+	Template   string      `json:"template,omitempty"`
+	Parameters []Parameter `json:"parameters,omitempty"`
+}
+
+type Parameter struct {
+	Name    string `json:"name,omitempty"`
+	DataKey string `json:"dataKey,omitempty"`
+}
+
+type Sync struct {
+	Application string `json:"application,omitempty"`
+	Namespace   string `json:"namespace,omitempty"`
+}
+
+// The types below mirror the subset of the Argo Events API written by this
+// plugin, which avoids depending on the whole Argo Events module.
+
+type eventSource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              eventSourceSpec `json:"spec"`
+}
+
+type eventSourceSpec struct {
+	EventBusName string                    `json:"eventBusName,omitempty"`
+	Template     *podTemplate              `json:"template,omitempty"`
+	Service      *service                  `json:"service,omitempty"`
+	Webhook      map[string]webhookEvent   `json:"webhook,omitempty"`
+	SQS          map[string]sqsEventSource `json:"sqs,omitempty"`
+}
+
+type podTemplate struct {
+	ServiceAccountName string `json:"serviceAccountName"`
+}
+
+type service struct {
+	Ports []corev1.ServicePort `json:"ports"`
+}
+
+type webhookEvent struct {
+	Port       string                    `json:"port"`
+	Endpoint   string                    `json:"endpoint"`
+	Method     string                    `json:"method"`
+	AuthSecret *corev1.SecretKeySelector `json:"authSecret"`
+}
+
+type sqsEventSource struct {
+	Region          string `json:"region"`
+	Queue           string `json:"queue"`
+	WaitTimeSeconds int64  `json:"waitTimeSeconds"`
+	JSONBody        bool   `json:"jsonBody"`
+}
+
+type sensor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              sensorSpec `json:"spec"`
+}
+
+type sensorSpec struct {
+	EventBusName string          `json:"eventBusName,omitempty"`
+	Template     podTemplate     `json:"template"`
+	Dependencies []dependency    `json:"dependencies"`
+	Triggers     []sensorTrigger `json:"triggers"`
+}
+
+type dependency struct {
+	Name            string   `json:"name"`
+	EventSourceName string   `json:"eventSourceName"`
+	EventName       string   `json:"eventName"`
+	Filters         *filters `json:"filters,omitempty"`
+}
+
+type filters struct {
+	Data []dataFilter `json:"data"`
+}
+
+type dataFilter struct {
+	Path  string   `json:"path"`
+	Type  string   `json:"type"`
+	Value []string `json:"value"`
+}
+
+type sensorTrigger struct {
+	Template triggerTemplate `json:"template"`
+}
+
+type triggerTemplate struct {
+	Name         string           `json:"name"`
+	ArgoWorkflow *resourceTrigger `json:"argoWorkflow,omitempty"`
+	K8s          *resourceTrigger `json:"k8s,omitempty"`
+}
+
+type resourceTrigger struct {
+	Operation     string             `json:"operation"`
+	PatchStrategy string             `json:"patchStrategy,omitempty"`
+	Source        artifactLocation   `json:"source"`
+	Parameters    []triggerParameter `json:"parameters,omitempty"`
+}
+
+type artifactLocation struct {
+	Resource map[string]interface{} `json:"resource"`
+}
+
+type triggerParameter struct {
+	Src  triggerParameterSource `json:"src"`
+	Dest string                 `json:"dest"`
+}
+
+type triggerParameterSource struct {
+	DependencyName string `json:"dependencyName"`
+	DataKey        string `json:"dataKey"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var eventTriggers EventTriggers
+	if err := pluginconfig.Unmarshal(data, &eventTriggers); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&eventTriggers)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(eventTriggers *EventTriggers) ([][]byte, error) {
+	spec := &eventTriggers.Spec
+
+	if eventTriggers.Name == "" {
+		return nil, fmt.Errorf("metadata.name is empty")
+	}
+
+	if len(spec.Triggers) == 0 {
+		return nil, fmt.Errorf("spec.triggers is empty")
+	}
+
+	sourceSpec := eventSourceSpec{
+		EventBusName: spec.EventBusName,
+	}
+	sensorSpec := sensorSpec{
+		EventBusName: spec.EventBusName,
+		Template: podTemplate{
+			ServiceAccountName: eventTriggers.Name + sensorSuffix,
+		},
+	}
+
+	ports := make(map[int32]bool)
+	rules := make(map[string][]rbacv1.PolicyRule)
+	usesAWS := false
+	seen := make(map[string]bool)
+	for i, trigger := range spec.Triggers {
+		if trigger.Name == "" {
+			return nil, fmt.Errorf("spec.triggers[%d] has no name", i)
+		}
+
+		if seen[trigger.Name] {
+			return nil, fmt.Errorf("trigger %s is declared more than once", trigger.Name)
+		}
+		seen[trigger.Name] = true
+
+		dependency := dependency{
+			Name:            trigger.Name,
+			EventSourceName: eventTriggers.Name,
+			EventName:       trigger.Name,
+		}
+
+		switch {
+		case countSet(trigger.Webhook != nil, trigger.SQS != nil, trigger.S3 != nil) != 1:
+			return nil, fmt.Errorf("trigger %s must have exactly one of webhook, sqs and s3", trigger.Name)
+
+		case trigger.Webhook != nil:
+			webhook, err := makeWebhookEvent(&trigger)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %s: %w", trigger.Name, err)
+			}
+			if sourceSpec.Webhook == nil {
+				sourceSpec.Webhook = make(map[string]webhookEvent)
+			}
+			sourceSpec.Webhook[trigger.Name] = webhook
+
+			port, err := strconv.ParseInt(webhook.Port, 10, 32)
+			if err != nil {
+				return nil, err
+			}
+			ports[int32(port)] = true
+
+		case trigger.SQS != nil:
+			if trigger.SQS.Region == "" || trigger.SQS.Queue == "" {
+				return nil, fmt.Errorf("trigger %s: sqs requires region and queue", trigger.Name)
+			}
+			if sourceSpec.SQS == nil {
+				sourceSpec.SQS = make(map[string]sqsEventSource)
+			}
+			sourceSpec.SQS[trigger.Name] = makeSQSEventSource(trigger.SQS.Region, trigger.SQS.Queue, trigger.SQS.WaitTimeSeconds)
+			usesAWS = true
+
+		case trigger.S3 != nil:
+			s3 := trigger.S3
+			if s3.Region == "" || s3.Queue == "" || s3.Bucket == "" {
+				return nil, fmt.Errorf("trigger %s: s3 requires region, queue and bucket", trigger.Name)
+			}
+			if sourceSpec.SQS == nil {
+				sourceSpec.SQS = make(map[string]sqsEventSource)
+			}
+			sourceSpec.SQS[trigger.Name] = makeSQSEventSource(s3.Region, s3.Queue, 0)
+			dependency.Filters = makeS3Filters(s3)
+			usesAWS = true
+		}
+
+		template := triggerTemplate{
+			Name: trigger.Name,
+		}
+
+		switch {
+		case countSet(trigger.Workflow != nil, trigger.Sync != nil) != 1:
+			return nil, fmt.Errorf("trigger %s must have exactly one of workflow and sync", trigger.Name)
+
+		case trigger.Workflow != nil:
+			argoWorkflow, err := makeWorkflowTrigger(eventTriggers, &trigger)
+			if err != nil {
+				return nil, fmt.Errorf("trigger %s: %w", trigger.Name, err)
+			}
+			template.ArgoWorkflow = argoWorkflow
+
+			rules[eventTriggers.Namespace] = appendRule(rules[eventTriggers.Namespace], rbacv1.PolicyRule{
+				APIGroups: []string{argoGroup},
+				Resources: []string{"workflows"},
+				Verbs:     []string{"create"},
+			})
+			rules[eventTriggers.Namespace] = appendRule(rules[eventTriggers.Namespace], rbacv1.PolicyRule{
+				APIGroups: []string{argoGroup},
+				Resources: []string{"workflowtemplates"},
+				Verbs:     []string{"get"},
+			})
+
+		case trigger.Sync != nil:
+			if trigger.Sync.Application == "" {
+				return nil, fmt.Errorf("trigger %s: sync has no application", trigger.Name)
+			}
+
+			namespace := trigger.Sync.Namespace
+			if namespace == "" {
+				namespace = defaultArgoCDNamespace
+			}
+			template.K8s = makeSyncTrigger(trigger.Sync.Application, namespace)
+
+			rules[namespace] = appendRule(rules[namespace], rbacv1.PolicyRule{
+				APIGroups:     []string{argoGroup},
+				Resources:     []string{"applications"},
+				ResourceNames: []string{trigger.Sync.Application},
+				Verbs:         []string{"get", "patch"},
+			})
+		}
+
+		sensorSpec.Dependencies = append(sensorSpec.Dependencies, dependency)
+		sensorSpec.Triggers = append(sensorSpec.Triggers, sensorTrigger{
+			Template: template,
+		})
+	}
+
+	for _, port := range sortedPorts(ports) {
+		if sourceSpec.Service == nil {
+			sourceSpec.Service = &service{}
+		}
+		sourceSpec.Service.Ports = append(sourceSpec.Service.Ports, corev1.ServicePort{
+			Port:       port,
+			TargetPort: intstr.FromInt(int(port)),
+		})
+	}
+
+	var manifests [][]byte
+
+	if usesAWS {
+		if spec.RoleARN == "" {
+			return nil, fmt.Errorf("spec.roleARN is required by sqs and s3 sources")
+		}
+
+		if !roleARNRegexp.MatchString(spec.RoleARN) {
+			return nil, fmt.Errorf("invalid spec.roleARN %q", spec.RoleARN)
+		}
+
+		serviceAccount, err := makeServiceAccount(eventTriggers, eventTriggers.Name+eventSourceSuffix, map[string]string{
+			roleARNAnnotation: spec.RoleARN,
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, serviceAccount)
+
+		sourceSpec.Template = &podTemplate{
+			ServiceAccountName: eventTriggers.Name + eventSourceSuffix,
+		}
+	}
+
+	serviceAccount, err := makeServiceAccount(eventTriggers, sensorSpec.Template.ServiceAccountName, nil)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, serviceAccount)
+
+	for _, namespace := range sortedKeys(rules) {
+		rbac, err := makeRBAC(eventTriggers, namespace, rules[namespace])
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, rbac...)
+	}
+
+	b, err := yaml.Marshal(eventSource{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: argoGroupVersion.String(),
+			Kind:       eventSourceKind,
+		},
+		ObjectMeta: *eventTriggers.ObjectMeta.DeepCopy(),
+		Spec:       sourceSpec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, b)
+
+	b, err = yaml.Marshal(sensor{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: argoGroupVersion.String(),
+			Kind:       sensorKind,
+		},
+		ObjectMeta: *eventTriggers.ObjectMeta.DeepCopy(),
+		Spec:       sensorSpec,
+	})
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, b)
+
+	return manifests, nil
+}
+
+// makeWebhookEvent requires webhooks to authenticate their callers, as event
+// sources are exposed to other namespaces and, often, to the Internet.
+func makeWebhookEvent(trigger *Trigger) (webhookEvent, error) {
+	webhook := trigger.Webhook
+
+	if webhook.AuthSecret == nil || webhook.AuthSecret.Name == "" || webhook.AuthSecret.Key == "" {
+		return webhookEvent{}, fmt.Errorf("webhook requires an authSecret with name and key")
+	}
+
+	port := webhook.Port
+	if port == 0 {
+		port = defaultWebhookPort
+	}
+
+	endpoint := webhook.Endpoint
+	if endpoint == "" {
+		endpoint = "/" + trigger.Name
+	}
+
+	method := webhook.Method
+	if method == "" {
+		method = defaultWebhookMethod
+	}
+
+	return webhookEvent{
+		Port:       strconv.Itoa(int(port)),
+		Endpoint:   endpoint,
+		Method:     method,
+		AuthSecret: webhook.AuthSecret,
+	}, nil
+}
+
+func makeSQSEventSource(region, queue string, waitTimeSeconds int64) sqsEventSource {
+	if waitTimeSeconds == 0 {
+		waitTimeSeconds = defaultWaitTimeSeconds
+	}
+
+	return sqsEventSource{
+		Region:          region,
+		Queue:           queue,
+		WaitTimeSeconds: waitTimeSeconds,
+		JSONBody:        true,
+	}
+}
+
+// makeS3Filters matches the S3 event notifications delivered to the queue,
+// whose string values are regular expressions for Argo Events.
+func makeS3Filters(s3 *S3) *filters {
+	data := []dataFilter{
+		{
+			Path:  s3BucketPath,
+			Type:  stringFilterType,
+			Value: []string{"^" + regexp.QuoteMeta(s3.Bucket) + "$"},
+		},
+	}
+
+	if s3.Prefix != "" || s3.Suffix != "" {
+		data = append(data, dataFilter{
+			Path:  s3KeyPath,
+			Type:  stringFilterType,
+			Value: []string{"^" + regexp.QuoteMeta(s3.Prefix) + ".*" + regexp.QuoteMeta(s3.Suffix) + "$"},
+		})
+	}
+
+	return &filters{
+		Data: data,
+	}
+}
+
+func makeWorkflowTrigger(eventTriggers *EventTriggers, trigger *Trigger) (*resourceTrigger, error) {
+	workflow := trigger.Workflow
+
+	if workflow.Template == "" {
+		return nil, fmt.Errorf("workflow has no template")
+	}
+
+	metadata := map[string]interface{}{
+		"generateName": trigger.Name + "-",
+	}
+	if eventTriggers.Namespace != "" {
+		metadata["namespace"] = eventTriggers.Namespace
+	}
+
+	workflowSpec := map[string]interface{}{
+		"workflowTemplateRef": map[string]interface{}{
+			"name": workflow.Template,
+		},
+	}
+
+	var arguments []interface{}
+	var parameters []triggerParameter
+	for i, parameter := range workflow.Parameters {
+		if parameter.Name == "" || parameter.DataKey == "" {
+			return nil, fmt.Errorf("workflow.parameters[%d] requires name and dataKey", i)
+		}
+
+		arguments = append(arguments, map[string]interface{}{
+			"name": parameter.Name,
+		})
+		parameters = append(parameters, triggerParameter{
+			Src: triggerParameterSource{
+				DependencyName: trigger.Name,
+				DataKey:        parameter.DataKey,
+			},
+			Dest: fmt.Sprintf(parameterDestFormat, i),
+		})
+	}
+	if len(arguments) > 0 {
+		workflowSpec["arguments"] = map[string]interface{}{
+			"parameters": arguments,
+		}
+	}
+
+	return &resourceTrigger{
+		Operation: submitOperation,
+		Source: artifactLocation{
+			Resource: map[string]interface{}{
+				"apiVersion": argoGroupVersion.String(),
+				"kind":       workflowKind,
+				"metadata":   metadata,
+				"spec":       workflowSpec,
+			},
+		},
+		Parameters: parameters,
+	}, nil
+}
+
+// makeSyncTrigger starts a sync the same way the Argo CD UI does, by setting
+// the operation of the Application, which the controller then runs.
+func makeSyncTrigger(application, namespace string) *resourceTrigger {
+	return &resourceTrigger{
+		Operation:     patchOperation,
+		PatchStrategy: mergePatchStrategy,
+		Source: artifactLocation{
+			Resource: map[string]interface{}{
+				"apiVersion": argoGroupVersion.String(),
+				"kind":       applicationKind,
+				"metadata": map[string]interface{}{
+					"name":      application,
+					"namespace": namespace,
+				},
+				"operation": map[string]interface{}{
+					"initiatedBy": map[string]interface{}{
+						"username":  initiatedBy,
+						"automated": true,
+					},
+					"sync": map[string]interface{}{},
+				},
+			},
+		},
+	}
+}
+
+func makeServiceAccount(eventTriggers *EventTriggers, name string, annotations map[string]string) ([]byte, error) {
+	return yaml.Marshal(corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ServiceAccount{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   eventTriggers.Namespace,
+			Labels:      eventTriggers.Labels,
+			Annotations: annotations,
+		},
+	})
+}
+
+func makeRBAC(eventTriggers *EventTriggers, namespace string, rules []rbacv1.PolicyRule) ([][]byte, error) {
+	name := eventTriggers.Name + sensorSuffix
+
+	role, err := yaml.Marshal(rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.Role{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    eventTriggers.Labels,
+		},
+		Rules: rules,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roleBinding, err := yaml.Marshal(rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.RoleBinding{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    eventTriggers.Labels,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      name,
+				Namespace: eventTriggers.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     reflect.TypeOf(rbacv1.Role{}).Name(),
+			Name:     name,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{role, roleBinding}, nil
+}
+
+func appendRule(rules []rbacv1.PolicyRule, rule rbacv1.PolicyRule) []rbacv1.PolicyRule {
+	for _, r := range rules {
+		if reflect.DeepEqual(r, rule) {
+			return rules
+		}
+	}
+
+	return append(rules, rule)
+}
+
+func countSet(values ...bool) int {
+	count := 0
+	for _, value := range values {
+		if value {
+			count++
+		}
+	}
+
+	return count
+}
+
+func sortedPorts(m map[int32]bool) []int32 {
+	ports := make([]int32, 0, len(m))
+	for port := range m {
+		ports = append(ports, port)
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i] < ports[j]
+	})
+
+	return ports
+}
+
+func sortedKeys(m map[string][]rbacv1.PolicyRule) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
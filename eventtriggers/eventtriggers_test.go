@@ -0,0 +1,242 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/eventtriggers"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("EventTriggers", func() {
+	generate := func(spec string) ([]map[string]interface{}, error) {
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: EventTriggers
+metadata:
+  name: reports
+  namespace: reports
+spec:
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		var manifests []map[string]interface{}
+		for _, document := range separatorYaml.Split(out.String(), -1) {
+			var manifest map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(document), &manifest)).To(g.Succeed())
+			manifests = append(manifests, manifest)
+		}
+
+		return manifests, nil
+	}
+
+	ginkgo.It("generates an EventSource and a Sensor with their service accounts", func() {
+		manifests, err := generate(`
+  roleARN: arn:aws:iam::123456789876:role/eks/reports-eventsource
+  triggers:
+    - name: upload
+      s3:
+        region: us-east-1
+        queue: reports-uploads
+        bucket: incognia-reports
+        prefix: daily/
+        suffix: .csv
+      workflow:
+        template: process-report
+        parameters:
+          - name: key
+            dataKey: body.Records.0.s3.object.key
+    - name: deploy
+      webhook:
+        authSecret:
+          name: reports-webhook
+          key: token
+      sync:
+        application: reports
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var kinds []string
+		for _, manifest := range manifests {
+			metadata := manifest["metadata"].(map[string]interface{})
+			kinds = append(kinds, manifest["kind"].(string)+"/"+metadata["namespace"].(string)+"/"+metadata["name"].(string))
+		}
+		g.Expect(kinds).To(g.Equal([]string{
+			"ServiceAccount/reports/reports-eventsource",
+			"ServiceAccount/reports/reports-sensor",
+			"Role/argocd/reports-sensor",
+			"RoleBinding/argocd/reports-sensor",
+			"Role/reports/reports-sensor",
+			"RoleBinding/reports/reports-sensor",
+			"EventSource/reports/reports",
+			"Sensor/reports/reports",
+		}))
+
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("annotations", map[string]interface{}{
+			"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789876:role/eks/reports-eventsource",
+		}))
+		g.Expect(manifests[2]["rules"]).To(g.Equal([]interface{}{
+			map[string]interface{}{
+				"apiGroups":     []interface{}{"argoproj.io"},
+				"resources":     []interface{}{"applications"},
+				"resourceNames": []interface{}{"reports"},
+				"verbs":         []interface{}{"get", "patch"},
+			},
+		}))
+		g.Expect(manifests[3]["subjects"]).To(g.Equal([]interface{}{
+			map[string]interface{}{
+				"kind":      "ServiceAccount",
+				"name":      "reports-sensor",
+				"namespace": "reports",
+			},
+		}))
+
+		g.Expect(manifests[6]["spec"]).To(g.Equal(map[string]interface{}{
+			"template": map[string]interface{}{
+				"serviceAccountName": "reports-eventsource",
+			},
+			"service": map[string]interface{}{
+				"ports": []interface{}{
+					map[string]interface{}{"port": float64(12000), "targetPort": float64(12000)},
+				},
+			},
+			"webhook": map[string]interface{}{
+				"deploy": map[string]interface{}{
+					"port":     "12000",
+					"endpoint": "/deploy",
+					"method":   "POST",
+					"authSecret": map[string]interface{}{
+						"name": "reports-webhook",
+						"key":  "token",
+					},
+				},
+			},
+			"sqs": map[string]interface{}{
+				"upload": map[string]interface{}{
+					"region":          "us-east-1",
+					"queue":           "reports-uploads",
+					"waitTimeSeconds": float64(20),
+					"jsonBody":        true,
+				},
+			},
+		}))
+
+		sensor := manifests[7]["spec"].(map[string]interface{})
+		g.Expect(sensor["template"]).To(g.Equal(map[string]interface{}{
+			"serviceAccountName": "reports-sensor",
+		}))
+		g.Expect(sensor["dependencies"]).To(g.Equal([]interface{}{
+			map[string]interface{}{
+				"name":            "upload",
+				"eventSourceName": "reports",
+				"eventName":       "upload",
+				"filters": map[string]interface{}{
+					"data": []interface{}{
+						map[string]interface{}{
+							"path":  "body.Records.0.s3.bucket.name",
+							"type":  "string",
+							"value": []interface{}{`^incognia-reports$`},
+						},
+						map[string]interface{}{
+							"path":  "body.Records.0.s3.object.key",
+							"type":  "string",
+							"value": []interface{}{`^daily/.*\.csv$`},
+						},
+					},
+				},
+			},
+			map[string]interface{}{
+				"name":            "deploy",
+				"eventSourceName": "reports",
+				"eventName":       "deploy",
+			},
+		}))
+		g.Expect(sensor["triggers"]).To(g.Equal([]interface{}{
+			map[string]interface{}{
+				"template": map[string]interface{}{
+					"name": "upload",
+					"argoWorkflow": map[string]interface{}{
+						"operation": "submit",
+						"source": map[string]interface{}{
+							"resource": map[string]interface{}{
+								"apiVersion": "argoproj.io/v1alpha1",
+								"kind":       "Workflow",
+								"metadata": map[string]interface{}{
+									"generateName": "upload-",
+									"namespace":    "reports",
+								},
+								"spec": map[string]interface{}{
+									"workflowTemplateRef": map[string]interface{}{
+										"name": "process-report",
+									},
+									"arguments": map[string]interface{}{
+										"parameters": []interface{}{
+											map[string]interface{}{"name": "key"},
+										},
+									},
+								},
+							},
+						},
+						"parameters": []interface{}{
+							map[string]interface{}{
+								"src": map[string]interface{}{
+									"dependencyName": "upload",
+									"dataKey":        "body.Records.0.s3.object.key",
+								},
+								"dest": "spec.arguments.parameters.0.value",
+							},
+						},
+					},
+				},
+			},
+			map[string]interface{}{
+				"template": map[string]interface{}{
+					"name": "deploy",
+					"k8s": map[string]interface{}{
+						"operation":     "patch",
+						"patchStrategy": "application/merge-patch+json",
+						"source": map[string]interface{}{
+							"resource": map[string]interface{}{
+								"apiVersion": "argoproj.io/v1alpha1",
+								"kind":       "Application",
+								"metadata": map[string]interface{}{
+									"name":      "reports",
+									"namespace": "argocd",
+								},
+								"operation": map[string]interface{}{
+									"initiatedBy": map[string]interface{}{
+										"username":  "argo-events",
+										"automated": true,
+									},
+									"sync": map[string]interface{}{},
+								},
+							},
+						},
+					},
+				},
+			},
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := generate(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without triggers", "", "spec.triggers is empty"),
+		ginkgo.Entry("without sources", "  triggers:\n    - name: a\n      sync:\n        application: a\n", "trigger a must have exactly one of webhook, sqs and s3"),
+		ginkgo.Entry("without actions", "  triggers:\n    - name: a\n      sqs:\n        region: us-east-1\n        queue: a\n", "trigger a must have exactly one of workflow and sync"),
+		ginkgo.Entry("with unauthenticated webhooks", "  triggers:\n    - name: a\n      webhook: {}\n      sync:\n        application: a\n", "trigger a: webhook requires an authSecret with name and key"),
+		ginkgo.Entry("with sqs without role", "  triggers:\n    - name: a\n      sqs:\n        region: us-east-1\n        queue: a\n      sync:\n        application: a\n", "spec.roleARN is required by sqs and s3 sources"),
+		ginkgo.Entry("with repeated triggers", "  triggers:\n"+strings.Repeat("    - name: a\n      webhook:\n        authSecret: {name: a, key: a}\n      sync:\n        application: a\n", 2), "trigger a is declared more than once"),
+	)
+})
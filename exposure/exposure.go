@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	environmentLabel = "incognia.com/environment"
+
+	clusterIssuerAnnotation = "cert-manager.io/cluster-issuer"
+	issuerAnnotation        = "cert-manager.io/issuer"
+
+	externalDNSHostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	externalDNSTargetAnnotation   = "external-dns.alpha.kubernetes.io/target"
+	externalDNSTTLAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+
+	defaultPath     = "/"
+	tlsSecretSuffix = "-tls"
+)
+
+var (
+	defaultPathType = networkingv1.PathTypePrefix
+)
+
+type Exposure struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Host             string                 `json:"host,omitempty"`
+	Paths            []Path                 `json:"paths,omitempty"`
+	Service          Service                `json:"service,omitempty"`
+	TLS              *TLS                   `json:"tls,omitempty"`
+	IngressClassName string                 `json:"ingressClassName,omitempty"`
+	Environment      string                 `json:"environment,omitempty"`
+	Environments     map[string]Environment `json:"environments,omitempty"`
+}
+
+type Path struct {
+	Path     string                 `json:"path,omitempty"`
+	PathType *networkingv1.PathType `json:"pathType,omitempty"`
+	Service  *Service               `json:"service,omitempty"`
+}
+
+type Service struct {
+	Name string             `json:"name,omitempty"`
+	Port intstr.IntOrString `json:"port,omitempty"`
+}
+
+type TLS struct {
+	Issuer        string `json:"issuer,omitempty"`
+	ClusterIssuer string `json:"clusterIssuer,omitempty"`
+	SecretName    string `json:"secretName,omitempty"`
+}
+
+type Environment struct {
+	IngressClassName string       `json:"ingressClassName,omitempty"`
+	ClusterIssuer    string       `json:"clusterIssuer,omitempty"`
+	ExternalDNS      *ExternalDNS `json:"externalDNS,omitempty"`
+}
+
+type ExternalDNS struct {
+	Target string `json:"target,omitempty"`
+	TTL    int32  `json:"ttl,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var exposure Exposure
+	if err := pluginconfig.Unmarshal(data, &exposure); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&exposure)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(exposure *Exposure) ([][]byte, error) {
+	ingress, err := makeIngress(exposure)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{ingress}, nil
+}
+
+func makeIngress(exposure *Exposure) ([]byte, error) {
+	spec := &exposure.Spec
+
+	if spec.Host == "" {
+		return nil, fmt.Errorf("spec.host is empty")
+	}
+	if errs := validation.IsDNS1123Subdomain(strings.TrimPrefix(spec.Host, "*.")); len(errs) > 0 {
+		return nil, fmt.Errorf("spec.host is invalid: %s", strings.Join(errs, ", "))
+	}
+
+	environmentName := spec.Environment
+	if environmentName == "" {
+		environmentName = exposure.Labels[environmentLabel]
+	}
+
+	var environment Environment
+	if environmentName != "" && spec.Environments != nil {
+		e, exists := spec.Environments[environmentName]
+		if !exists {
+			return nil, fmt.Errorf("environment %s has no settings", environmentName)
+		}
+		environment = e
+	}
+
+	objectMeta := *exposure.ObjectMeta.DeepCopy()
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = make(map[string]string)
+	}
+
+	ingressClassName := spec.IngressClassName
+	if ingressClassName == "" {
+		ingressClassName = environment.IngressClassName
+	}
+
+	paths, err := makePaths(exposure)
+	if err != nil {
+		return nil, err
+	}
+
+	ingressSpec := networkingv1.IngressSpec{
+		Rules: []networkingv1.IngressRule{
+			networkingv1.IngressRule{
+				Host: spec.Host,
+				IngressRuleValue: networkingv1.IngressRuleValue{
+					HTTP: &networkingv1.HTTPIngressRuleValue{
+						Paths: paths,
+					},
+				},
+			},
+		},
+	}
+	if ingressClassName != "" {
+		ingressSpec.IngressClassName = &ingressClassName
+	}
+
+	tls := spec.TLS
+	if tls == nil {
+		tls = &TLS{}
+	}
+	if tls.Issuer != "" && tls.ClusterIssuer != "" {
+		return nil, fmt.Errorf("spec.tls.issuer and spec.tls.clusterIssuer are mutually exclusive")
+	}
+
+	clusterIssuer := tls.ClusterIssuer
+	if clusterIssuer == "" && tls.Issuer == "" {
+		clusterIssuer = environment.ClusterIssuer
+	}
+
+	if clusterIssuer != "" || tls.Issuer != "" {
+		if clusterIssuer != "" {
+			objectMeta.Annotations[clusterIssuerAnnotation] = clusterIssuer
+		} else {
+			objectMeta.Annotations[issuerAnnotation] = tls.Issuer
+		}
+
+		secretName := tls.SecretName
+		if secretName == "" {
+			secretName = exposure.Name + tlsSecretSuffix
+		}
+
+		ingressSpec.TLS = []networkingv1.IngressTLS{
+			networkingv1.IngressTLS{
+				Hosts: []string{
+					spec.Host,
+				},
+				SecretName: secretName,
+			},
+		}
+	}
+
+	if externalDNS := environment.ExternalDNS; externalDNS != nil {
+		objectMeta.Annotations[externalDNSHostnameAnnotation] = spec.Host
+
+		if externalDNS.Target != "" {
+			objectMeta.Annotations[externalDNSTargetAnnotation] = externalDNS.Target
+		}
+
+		if externalDNS.TTL > 0 {
+			objectMeta.Annotations[externalDNSTTLAnnotation] = strconv.Itoa(int(externalDNS.TTL))
+		}
+	}
+
+	if len(objectMeta.Annotations) == 0 {
+		objectMeta.Annotations = nil
+	}
+
+	ingress := networkingv1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(networkingv1.Ingress{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Spec:       ingressSpec,
+	}
+
+	return yaml.Marshal(ingress)
+}
+
+func makePaths(exposure *Exposure) ([]networkingv1.HTTPIngressPath, error) {
+	specPaths := exposure.Spec.Paths
+	if len(specPaths) == 0 {
+		specPaths = []Path{
+			Path{
+				Path: defaultPath,
+			},
+		}
+	}
+
+	paths := make([]networkingv1.HTTPIngressPath, 0, len(specPaths))
+	for i, specPath := range specPaths {
+		path := specPath.Path
+		if path == "" {
+			path = defaultPath
+		}
+
+		pathType := specPath.PathType
+		if pathType == nil {
+			pathType = &defaultPathType
+		}
+
+		service := exposure.Spec.Service
+		if specPath.Service != nil {
+			service = *specPath.Service
+		}
+		if service.Name == "" {
+			service.Name = exposure.Name
+		}
+
+		var port networkingv1.ServiceBackendPort
+		switch {
+		case service.Port.Type == intstr.String && service.Port.StrVal != "":
+			port.Name = service.Port.StrVal
+		case service.Port.Type == intstr.Int && service.Port.IntVal > 0:
+			port.Number = service.Port.IntVal
+		default:
+			return nil, fmt.Errorf("spec.paths[%d] has no service port", i)
+		}
+
+		paths = append(paths, networkingv1.HTTPIngressPath{
+			Path:     path,
+			PathType: pathType,
+			Backend: networkingv1.IngressBackend{
+				Service: &networkingv1.IngressServiceBackend{
+					Name: service.Name,
+					Port: port,
+				},
+			},
+		})
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,123 @@
+package main_test
+
+import (
+	"bytes"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/exposure"
+)
+
+const (
+	environments = `
+  environments:
+    production:
+      ingressClassName: nginx-public
+      clusterIssuer: letsencrypt-production
+      externalDNS:
+        target: public.example.com
+        ttl: 300
+    staging:
+      ingressClassName: nginx-internal
+`
+)
+
+var _ = ginkgo.Describe("Exposure", func() {
+	ginkgo.It("generates Ingresses with cert-manager and external-dns wiring", func() {
+		ingress := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: Exposure
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  host: my-app.example.com
+  environment: production
+  service:
+    port: http
+  paths:
+    - path: /
+    - path: /admin
+      pathType: Exact
+      service:
+        name: my-admin
+        port: 8080
+` + environments)
+
+		g.Expect(ingress.Name).To(g.Equal("my-app"))
+		g.Expect(ingress.Namespace).To(g.Equal("my-namespace"))
+		g.Expect(*ingress.Spec.IngressClassName).To(g.Equal("nginx-public"))
+		g.Expect(ingress.Annotations).To(g.Equal(map[string]string{
+			"cert-manager.io/cluster-issuer":            "letsencrypt-production",
+			"external-dns.alpha.kubernetes.io/hostname": "my-app.example.com",
+			"external-dns.alpha.kubernetes.io/target":   "public.example.com",
+			"external-dns.alpha.kubernetes.io/ttl":      "300",
+		}))
+		g.Expect(ingress.Spec.TLS).To(g.Equal([]networkingv1.IngressTLS{
+			{
+				Hosts:      []string{"my-app.example.com"},
+				SecretName: "my-app-tls",
+			},
+		}))
+
+		paths := ingress.Spec.Rules[0].HTTP.Paths
+		g.Expect(paths).To(g.HaveLen(2))
+		g.Expect(*paths[0].PathType).To(g.Equal(networkingv1.PathTypePrefix))
+		g.Expect(paths[0].Backend.Service).To(g.Equal(&networkingv1.IngressServiceBackend{
+			Name: "my-app",
+			Port: networkingv1.ServiceBackendPort{
+				Name: "http",
+			},
+		}))
+		g.Expect(*paths[1].PathType).To(g.Equal(networkingv1.PathTypeExact))
+		g.Expect(paths[1].Backend.Service.Port.Number).To(g.Equal(int32(8080)))
+	})
+
+	ginkgo.It("generates Ingresses without TLS nor DNS hints when the environment has none", func() {
+		ingress := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: Exposure
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: staging
+spec:
+  host: my-app.staging.example.com
+  service:
+    port: 80
+` + environments)
+
+		g.Expect(*ingress.Spec.IngressClassName).To(g.Equal("nginx-internal"))
+		g.Expect(ingress.Annotations).To(g.BeEmpty())
+		g.Expect(ingress.Spec.TLS).To(g.BeEmpty())
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Exposure
+metadata:
+  name: my-app
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without host", "  service: {port: 80}\n", "spec.host is empty"),
+		ginkgo.Entry("without port", "  host: my-app.example.com\n", "spec.paths[0] has no service port"),
+		ginkgo.Entry("with unknown environment", "  host: my-app.example.com\n  environment: qa\n"+environments, "environment qa has no settings"),
+	)
+})
+
+func generate(config string) networkingv1.Ingress {
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.Succeed())
+
+	var ingress networkingv1.Ingress
+	g.Expect(yaml.Unmarshal(out.Bytes(), &ingress)).To(g.Succeed())
+	g.Expect(ingress.Kind).To(g.Equal("Ingress"))
+
+	return ingress
+}
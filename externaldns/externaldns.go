@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-external-dns"
+
+	hostnameAnnotation = "external-dns.alpha.kubernetes.io/hostname"
+	ttlAnnotation      = "external-dns.alpha.kubernetes.io/ttl"
+	aliasAnnotation    = "external-dns.alpha.kubernetes.io/alias"
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+	defaultServiceLabel     = "incognia.com/service"
+	defaultHostnameTemplate = "{{ .Service }}.{{ .Domain }}"
+
+	ingressKind             = "Ingress"
+	serviceKind             = "Service"
+	loadBalancerServiceType = "LoadBalancer"
+)
+
+type ExternalDNS struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Environment      string            `json:"environment,omitempty"`
+	EnvironmentLabel string            `json:"environmentLabel,omitempty"`
+	ServiceLabel     string            `json:"serviceLabel,omitempty"`
+	HostnameTemplate string            `json:"hostnameTemplate,omitempty"`
+	Domains          map[string]Domain `json:"domains,omitempty"`
+}
+
+type Domain struct {
+	Name  string `json:"name,omitempty"`
+	TTL   int32  `json:"ttl,omitempty"`
+	Alias bool   `json:"alias,omitempty"`
+}
+
+type HostnameTemplateData struct {
+	Name        string
+	Namespace   string
+	Service     string
+	Environment string
+	Domain      string
+}
+
+var hostnameTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"replace": func(old string, new string, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var externalDNS ExternalDNS
+	if err := pluginconfig.Unmarshal(data, &externalDNS); err != nil {
+		return err
+	}
+
+	outputs, err := terraform.ReadOutputsFromEnv()
+	if err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&externalDNS, outputs, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(externalDNS *ExternalDNS, outputs terraform.Outputs, nodes []*kyaml.RNode) error {
+	spec := &externalDNS.Spec
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	serviceLabel := spec.ServiceLabel
+	if serviceLabel == "" {
+		serviceLabel = defaultServiceLabel
+	}
+
+	hostnameTemplate := spec.HostnameTemplate
+	if hostnameTemplate == "" {
+		hostnameTemplate = defaultHostnameTemplate
+	}
+
+	// the placeholders of Terraform are replaced before the template is
+	// parsed, as they are in the files of the other plugins
+	hostnameTemplate, err := outputs.Substitute(hostnameTemplate)
+	if err != nil {
+		return fmt.Errorf("spec.hostnameTemplate: %w", err)
+	}
+
+	tmpl, err := template.New("hostnameTemplate").Option("missingkey=error").Funcs(hostnameTemplateFuncs).Parse(hostnameTemplate)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		eligible, err := isEligible(node)
+		if err != nil {
+			return err
+		}
+		if !eligible {
+			continue
+		}
+
+		annotations := node.GetAnnotations()
+		if annotations[skipAnnotation] == "true" {
+			continue
+		}
+
+		if _, exists := annotations[hostnameAnnotation]; exists {
+			continue
+		}
+
+		labels := node.GetLabels()
+
+		environment := spec.Environment
+		if environment == "" {
+			environment = labels[environmentLabel]
+		}
+
+		domain, exists := spec.Domains[environment]
+		if !exists {
+			continue
+		}
+
+		service := labels[serviceLabel]
+		if service == "" {
+			service = node.GetName()
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, HostnameTemplateData{
+			Name:        node.GetName(),
+			Namespace:   node.GetNamespace(),
+			Service:     service,
+			Environment: environment,
+			Domain:      domain.Name,
+		}); err != nil {
+			return err
+		}
+
+		hostname := sb.String()
+		if errs := validation.IsDNS1123Subdomain(hostname); len(errs) > 0 {
+			return fmt.Errorf("%s %s maps to invalid hostname '%s': %s", node.GetKind(), node.GetName(), hostname, strings.Join(errs, ", "))
+		}
+
+		if err := node.PipeE(kyaml.SetAnnotation(hostnameAnnotation, hostname)); err != nil {
+			return err
+		}
+
+		if _, exists := annotations[ttlAnnotation]; !exists && domain.TTL > 0 {
+			if err := node.PipeE(kyaml.SetAnnotation(ttlAnnotation, strconv.Itoa(int(domain.TTL)))); err != nil {
+				return err
+			}
+		}
+
+		if _, exists := annotations[aliasAnnotation]; !exists && domain.Alias {
+			if err := node.PipeE(kyaml.SetAnnotation(aliasAnnotation, "true")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// isEligible tells whether external-dns publishes records for the resource:
+// every Ingress and only LoadBalancer Services.
+func isEligible(node *kyaml.RNode) (bool, error) {
+	switch node.GetKind() {
+	case ingressKind:
+		return true, nil
+	case serviceKind:
+		serviceType, err := node.Pipe(kyaml.Lookup("spec", "type"))
+		if err != nil {
+			return false, err
+		}
+
+		return kyaml.GetValue(serviceType) == loadBalancerServiceType, nil
+	default:
+		return false, nil
+	}
+}
@@ -0,0 +1,146 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/externaldns"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: ExternalDNS
+metadata:
+  name: external-dns
+spec:
+  domains:
+    production:
+      name: example.com
+      ttl: 300
+      alias: true
+    staging:
+      name: staging.example.com
+`
+
+	resources = `
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: production
+    incognia.com/service: my-service
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: my-lb
+  labels:
+    incognia.com/environment: staging
+spec:
+  type: LoadBalancer
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: my-cluster-ip
+  labels:
+    incognia.com/environment: staging
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: hand-written
+  labels:
+    incognia.com/environment: staging
+  annotations:
+    external-dns.alpha.kubernetes.io/hostname: custom.example.com
+---
+apiVersion: networking.k8s.io/v1
+kind: Ingress
+metadata:
+  name: unknown-environment
+  labels:
+    incognia.com/environment: sandbox
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ExternalDNS", func() {
+	ginkgo.It("annotates Ingresses and LoadBalancer Services", func() {
+		annotations := transform(config)
+
+		g.Expect(annotations["my-app"]).To(g.Equal(map[string]string{
+			"external-dns.alpha.kubernetes.io/hostname": "my-service.example.com",
+			"external-dns.alpha.kubernetes.io/ttl":      "300",
+			"external-dns.alpha.kubernetes.io/alias":    "true",
+		}))
+		g.Expect(annotations["my-lb"]).To(g.Equal(map[string]string{
+			"external-dns.alpha.kubernetes.io/hostname": "my-lb.staging.example.com",
+		}))
+		g.Expect(annotations["my-cluster-ip"]).To(g.BeEmpty())
+		g.Expect(annotations["hand-written"]).To(g.Equal(map[string]string{
+			"external-dns.alpha.kubernetes.io/hostname": "custom.example.com",
+		}))
+		g.Expect(annotations["unknown-environment"]).To(g.BeEmpty())
+	})
+
+	ginkgo.It("uses the hostname template", func() {
+		annotations := transform(config + `
+  hostnameTemplate: "{{ .Name }}-{{ .Environment }}.{{ .Domain }}"
+`)
+
+		g.Expect(annotations["my-app"]).To(g.HaveKeyWithValue("external-dns.alpha.kubernetes.io/hostname", "my-app-production.example.com"))
+	})
+
+	ginkgo.It("uses the outputs of Terraform in the hostname template", func() {
+		d, err := ioutil.TempDir("", "externaldns")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+
+		outputsPath := filepath.Join(d, "outputs.json")
+		g.Expect(ioutil.WriteFile(outputsPath, []byte(`{"edge_domain": {"sensitive": false, "type": "string", "value": "edge.example.com"}}`), 0644)).To(g.Succeed())
+		g.Expect(os.Setenv("TERRAFORM_OUTPUTS", outputsPath)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "TERRAFORM_OUTPUTS")
+
+		annotations := transform(config + `
+  hostnameTemplate: "{{ .Service }}.{{terraform.edge_domain}}"
+`)
+
+		g.Expect(annotations["my-app"]).To(g.HaveKeyWithValue("external-dns.alpha.kubernetes.io/hostname", "my-service.edge.example.com"))
+	})
+
+	ginkgo.It("fails on missing outputs of Terraform", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config+`
+  hostnameTemplate: "{{ .Service }}.{{terraform.edge_domain}}"
+`), strings.NewReader(resources), &out)).To(g.MatchError("spec.hostnameTemplate: terraform output edge_domain is missing"))
+	})
+})
+
+func transform(config string) map[string]map[string]string {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	annotations := make(map[string]map[string]string)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var object metav1.PartialObjectMetadata
+		g.Expect(yaml.Unmarshal([]byte(manifest), &object)).To(g.Succeed())
+		annotations[object.Name] = object.Annotations
+	}
+
+	return annotations
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	externalSecretsGroup   = "external-secrets.io"
+	externalSecretsVersion = "v1beta1"
+	externalSecretKind     = "ExternalSecret"
+
+	defaultRefreshInterval = "1h"
+)
+
+type ExternalSecrets struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	SecretStoreRef  SecretStoreRef `json:"secretStoreRef,omitempty"`
+	RefreshInterval string         `json:"refreshInterval,omitempty"`
+	Secrets         []Secret       `json:"secrets,omitempty"`
+}
+
+type SecretStoreRef struct {
+	Name string `json:"name,omitempty"`
+	Kind string `json:"kind,omitempty"`
+}
+
+type Secret struct {
+	Name           string      `json:"name,omitempty"`
+	CreationPolicy string      `json:"creationPolicy,omitempty"`
+	RemoteKeys     []RemoteKey `json:"remoteKeys,omitempty"`
+	Template       *Template   `json:"template,omitempty"`
+}
+
+type RemoteKey struct {
+	Key       string `json:"key,omitempty"`
+	Property  string `json:"property,omitempty"`
+	Version   string `json:"version,omitempty"`
+	SecretKey string `json:"secretKey,omitempty"`
+}
+
+type Template struct {
+	Type corev1.SecretType `json:"type,omitempty"`
+	Data map[string]string `json:"data,omitempty"`
+}
+
+type externalSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              externalSecretSpec `json:"spec"`
+}
+
+type externalSecretSpec struct {
+	SecretStoreRef  SecretStoreRef          `json:"secretStoreRef"`
+	RefreshInterval string                  `json:"refreshInterval,omitempty"`
+	Target          externalSecretTarget    `json:"target"`
+	Data            []externalSecretData    `json:"data,omitempty"`
+	DataFrom        []externalSecretDataRef `json:"dataFrom,omitempty"`
+}
+
+type externalSecretTarget struct {
+	Name           string    `json:"name,omitempty"`
+	CreationPolicy string    `json:"creationPolicy,omitempty"`
+	Template       *Template `json:"template,omitempty"`
+}
+
+type externalSecretData struct {
+	SecretKey string            `json:"secretKey"`
+	RemoteRef externalSecretRef `json:"remoteRef"`
+}
+
+type externalSecretDataRef struct {
+	Extract externalSecretRef `json:"extract"`
+}
+
+type externalSecretRef struct {
+	Key      string `json:"key"`
+	Property string `json:"property,omitempty"`
+	Version  string `json:"version,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var externalSecrets ExternalSecrets
+	if err := pluginconfig.Unmarshal(data, &externalSecrets); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&externalSecrets)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(externalSecrets *ExternalSecrets) ([][]byte, error) {
+	if externalSecrets.Spec.SecretStoreRef.Name == "" {
+		return nil, fmt.Errorf("spec.secretStoreRef.name is empty")
+	}
+
+	names := make(map[string]bool)
+	manifests := make([][]byte, 0, len(externalSecrets.Spec.Secrets))
+
+	for _, secret := range externalSecrets.Spec.Secrets {
+		name := secret.Name
+		if name == "" {
+			name = externalSecrets.Name
+		}
+
+		if names[name] {
+			return nil, fmt.Errorf("secret name %s is duplicated", name)
+		}
+		names[name] = true
+
+		b, err := makeExternalSecret(externalSecrets, name, &secret)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+func makeExternalSecret(externalSecrets *ExternalSecrets, name string, secret *Secret) ([]byte, error) {
+	if len(secret.RemoteKeys) == 0 {
+		return nil, fmt.Errorf("secret %s has no remoteKeys", name)
+	}
+
+	var data []externalSecretData
+	var dataFrom []externalSecretDataRef
+	for _, remoteKey := range secret.RemoteKeys {
+		if remoteKey.Key == "" {
+			return nil, fmt.Errorf("secret %s has a remoteKey without key", name)
+		}
+
+		ref := externalSecretRef{
+			Key:      remoteKey.Key,
+			Property: remoteKey.Property,
+			Version:  remoteKey.Version,
+		}
+
+		if remoteKey.SecretKey == "" && remoteKey.Property == "" {
+			dataFrom = append(dataFrom, externalSecretDataRef{
+				Extract: ref,
+			})
+			continue
+		}
+
+		secretKey := remoteKey.SecretKey
+		if secretKey == "" {
+			secretKey = remoteKey.Property
+		}
+
+		data = append(data, externalSecretData{
+			SecretKey: secretKey,
+			RemoteRef: ref,
+		})
+	}
+
+	refreshInterval := externalSecrets.Spec.RefreshInterval
+	if refreshInterval == "" {
+		refreshInterval = defaultRefreshInterval
+	}
+
+	objectMeta := *externalSecrets.ObjectMeta.DeepCopy()
+	objectMeta.Name = name
+
+	manifest := externalSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   externalSecretsGroup,
+				Version: externalSecretsVersion,
+			}.String(),
+			Kind: externalSecretKind,
+		},
+		ObjectMeta: objectMeta,
+		Spec: externalSecretSpec{
+			SecretStoreRef:  externalSecrets.Spec.SecretStoreRef,
+			RefreshInterval: refreshInterval,
+			Target: externalSecretTarget{
+				Name:           name,
+				CreationPolicy: secret.CreationPolicy,
+				Template:       secret.Template,
+			},
+			Data:     data,
+			DataFrom: dataFrom,
+		},
+	}
+
+	return yaml.Marshal(manifest)
+}
@@ -0,0 +1,159 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/externalsecrets"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ExternalSecrets", func() {
+	ginkgo.DescribeTable("", ExternalSecrets,
+		ginkgo.Entry("with properties and extracted keys",
+			makeExternalSecrets(main.Spec{
+				SecretStoreRef: main.SecretStoreRef{
+					Name: "aws-secrets-manager",
+					Kind: "ClusterSecretStore",
+				},
+				Secrets: []main.Secret{
+					{
+						RemoteKeys: []main.RemoteKey{
+							{
+								Key:      "production/my-app/db",
+								Property: "password",
+							},
+							{
+								Key:       "production/my-app/api",
+								Property:  "token",
+								SecretKey: "API_TOKEN",
+							},
+						},
+					},
+					{
+						Name: "my-app-extra",
+						RemoteKeys: []main.RemoteKey{
+							{
+								Key: "production/my-app/extra",
+							},
+						},
+						Template: &main.Template{
+							Type: "kubernetes.io/dockerconfigjson",
+						},
+					},
+				},
+			}),
+			[]string{
+				`
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  data:
+    - secretKey: password
+      remoteRef:
+        key: production/my-app/db
+        property: password
+    - secretKey: API_TOKEN
+      remoteRef:
+        key: production/my-app/api
+        property: token
+  refreshInterval: 1h
+  secretStoreRef:
+    kind: ClusterSecretStore
+    name: aws-secrets-manager
+  target:
+    name: my-app
+`,
+				`
+apiVersion: external-secrets.io/v1beta1
+kind: ExternalSecret
+metadata:
+  name: my-app-extra
+  namespace: my-namespace
+spec:
+  dataFrom:
+    - extract:
+        key: production/my-app/extra
+  refreshInterval: 1h
+  secretStoreRef:
+    kind: ClusterSecretStore
+    name: aws-secrets-manager
+  target:
+    name: my-app-extra
+    template:
+      type: kubernetes.io/dockerconfigjson
+`,
+			},
+		),
+	)
+
+	ginkgo.It("fails without secret store", func() {
+		data, err := yaml.Marshal(makeExternalSecrets(main.Spec{
+			Secrets: []main.Secret{
+				{
+					RemoteKeys: []main.RemoteKey{
+						{
+							Key: "production/my-app/extra",
+						},
+					},
+				},
+			},
+		}))
+		g.Expect(err).To(g.BeNil())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests(data, &out)).To(g.MatchError("spec.secretStoreRef.name is empty"))
+	})
+})
+
+func ExternalSecrets(externalSecrets main.ExternalSecrets, expectedManifests []string) {
+	var externalSecretsYaml []byte
+	if data, err := yaml.Marshal(externalSecrets); g.Expect(err).To(g.BeNil()) {
+		externalSecretsYaml = data
+	}
+
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests(externalSecretsYaml, &out)).To(g.Succeed())
+
+	manifests := separatorYaml.Split(out.String(), -1)
+	g.Expect(manifests).To(g.HaveLen(len(expectedManifests)))
+
+	for i, manifest := range manifests {
+		var actual, expected unstructured.Unstructured
+		g.Expect(yaml.Unmarshal([]byte(manifest), &actual.Object)).To(g.Succeed())
+		g.Expect(yaml.Unmarshal([]byte(expectedManifests[i]), &expected.Object)).To(g.Succeed())
+
+		unstructured.RemoveNestedField(actual.Object, "metadata", "creationTimestamp")
+		g.Expect(actual.Object).To(g.Equal(expected.Object))
+	}
+}
+
+func makeExternalSecrets(spec main.Spec) main.ExternalSecrets {
+	return main.ExternalSecrets{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   "incognia.com",
+				Version: "v1alpha1",
+			}.String(),
+			Kind: "ExternalSecrets",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "my-app",
+			Namespace: "my-namespace",
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	environmentLabel = "incognia.com/environment"
+
+	constraintTemplateKind   = "ConstraintTemplate"
+	admissionTarget          = "admission.k8s.gatekeeper.sh"
+	defaultEnforcementAction = "deny"
+)
+
+var (
+	templatesGroupVersion = schema.GroupVersion{
+		Group:   "templates.gatekeeper.sh",
+		Version: "v1",
+	}
+
+	constraintsGroupVersion = schema.GroupVersion{
+		Group:   "constraints.gatekeeper.sh",
+		Version: "v1beta1",
+	}
+
+	enforcementActions = []string{
+		"deny",
+		"dryrun",
+		"warn",
+	}
+)
+
+type GatekeeperPolicies struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Catalog     string `json:"catalog,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+type Catalog struct {
+	Environments map[string]string `json:"environments,omitempty"`
+	Policies     []Policy          `json:"policies,omitempty"`
+}
+
+type Policy struct {
+	Kind        string                 `json:"kind,omitempty"`
+	Rego        string                 `json:"rego,omitempty"`
+	Libs        []string               `json:"libs,omitempty"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Constraints []Constraint           `json:"constraints,omitempty"`
+}
+
+type Constraint struct {
+	Name              string                 `json:"name,omitempty"`
+	Match             map[string]interface{} `json:"match,omitempty"`
+	Parameters        map[string]interface{} `json:"parameters,omitempty"`
+	EnforcementAction string                 `json:"enforcementAction,omitempty"`
+	Environments      map[string]string      `json:"environments,omitempty"`
+}
+
+// The types below mirror the subset of the Gatekeeper API written by this
+// plugin, which avoids depending on the whole Gatekeeper module.
+
+type constraintTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              constraintTemplateSpec `json:"spec"`
+}
+
+type constraintTemplateSpec struct {
+	CRD     crd      `json:"crd"`
+	Targets []target `json:"targets"`
+}
+
+type crd struct {
+	Spec crdSpec `json:"spec"`
+}
+
+type crdSpec struct {
+	Names      names       `json:"names"`
+	Validation *validation `json:"validation,omitempty"`
+}
+
+type names struct {
+	Kind string `json:"kind"`
+}
+
+type validation struct {
+	OpenAPIV3Schema map[string]interface{} `json:"openAPIV3Schema"`
+}
+
+type target struct {
+	Target string   `json:"target"`
+	Rego   string   `json:"rego"`
+	Libs   []string `json:"libs,omitempty"`
+}
+
+type constraint struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              constraintSpec `json:"spec"`
+}
+
+type constraintSpec struct {
+	EnforcementAction string                 `json:"enforcementAction"`
+	Match             map[string]interface{} `json:"match,omitempty"`
+	Parameters        map[string]interface{} `json:"parameters,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var gatekeeperPolicies GatekeeperPolicies
+	if err := pluginconfig.Unmarshal(data, &gatekeeperPolicies); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&gatekeeperPolicies)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(gatekeeperPolicies *GatekeeperPolicies) ([][]byte, error) {
+	spec := &gatekeeperPolicies.Spec
+
+	if spec.Catalog == "" {
+		return nil, fmt.Errorf("spec.catalog is empty")
+	}
+
+	catalog, err := readCatalog(spec.Catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	environment := spec.Environment
+	if environment == "" {
+		environment = gatekeeperPolicies.Labels[environmentLabel]
+	}
+
+	objectMeta := *gatekeeperPolicies.ObjectMeta.DeepCopy()
+	objectMeta.Namespace = ""
+
+	var manifests [][]byte
+	for _, policy := range catalog.Policies {
+		if policy.Kind == "" {
+			return nil, fmt.Errorf("policy has no kind")
+		}
+
+		if policy.Rego == "" {
+			return nil, fmt.Errorf("policy %s has no rego", policy.Kind)
+		}
+
+		templateMeta := *objectMeta.DeepCopy()
+		templateMeta.Name = strings.ToLower(policy.Kind)
+
+		var templateValidation *validation
+		if policy.Parameters != nil {
+			templateValidation = &validation{
+				OpenAPIV3Schema: policy.Parameters,
+			}
+		}
+
+		b, err := yaml.Marshal(constraintTemplate{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: templatesGroupVersion.String(),
+				Kind:       constraintTemplateKind,
+			},
+			ObjectMeta: templateMeta,
+			Spec: constraintTemplateSpec{
+				CRD: crd{
+					Spec: crdSpec{
+						Names: names{
+							Kind: policy.Kind,
+						},
+						Validation: templateValidation,
+					},
+				},
+				Targets: []target{
+					{
+						Target: admissionTarget,
+						Rego:   policy.Rego,
+						Libs:   policy.Libs,
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+
+		for _, c := range policy.Constraints {
+			if c.Name == "" {
+				return nil, fmt.Errorf("policy %s has a constraint without name", policy.Kind)
+			}
+
+			enforcementAction := resolveEnforcementAction(catalog, &c, environment)
+			if !containsString(enforcementActions, enforcementAction) {
+				return nil, fmt.Errorf("constraint %s has unsupported enforcement action %s", c.Name, enforcementAction)
+			}
+
+			constraintMeta := *objectMeta.DeepCopy()
+			constraintMeta.Name = c.Name
+
+			b, err := yaml.Marshal(constraint{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: constraintsGroupVersion.String(),
+					Kind:       policy.Kind,
+				},
+				ObjectMeta: constraintMeta,
+				Spec: constraintSpec{
+					EnforcementAction: enforcementAction,
+					Match:             c.Match,
+					Parameters:        c.Parameters,
+				},
+			})
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, b)
+		}
+	}
+
+	return manifests, nil
+}
+
+func readCatalog(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &catalog, nil
+}
+
+// resolveEnforcementAction prefers the action of the constraint for the
+// environment, then its own action, then the action of the catalog for the
+// environment, falling back to Gatekeeper's default.
+func resolveEnforcementAction(catalog *Catalog, c *Constraint, environment string) string {
+	if action, exists := c.Environments[environment]; exists {
+		return action
+	}
+
+	if c.EnforcementAction != "" {
+		return c.EnforcementAction
+	}
+
+	if action, exists := catalog.Environments[environment]; exists {
+		return action
+	}
+
+	return defaultEnforcementAction
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
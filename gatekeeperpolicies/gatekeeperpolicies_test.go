@@ -0,0 +1,161 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/gatekeeperpolicies"
+)
+
+const (
+	catalog = `
+environments:
+  production: deny
+  staging: warn
+policies:
+  - kind: K8sRequiredLabels
+    rego: |
+      package k8srequiredlabels
+      violation[{"msg": msg}] {
+        missing := {label | label := input.parameters.labels[_]; not input.review.object.metadata.labels[label]}
+        count(missing) > 0
+        msg := sprintf("missing labels: %v", [missing])
+      }
+    parameters:
+      type: object
+      properties:
+        labels:
+          type: array
+          items:
+            type: string
+    constraints:
+      - name: namespaces-must-have-team
+        match:
+          kinds:
+            - apiGroups: [""]
+              kinds: ["Namespace"]
+        parameters:
+          labels: ["team"]
+      - name: deployments-must-have-service
+        enforcementAction: dryrun
+        environments:
+          production: warn
+        parameters:
+          labels: ["incognia.com/service"]
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("GatekeeperPolicies", func() {
+	var catalogPath string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "gatekeeperpolicies")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		catalogPath = filepath.Join(dir, "catalog.yaml")
+		g.Expect(ioutil.WriteFile(catalogPath, []byte(catalog), 0644)).To(g.Succeed())
+	})
+
+	generate := func(environment string) []map[string]interface{} {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: GatekeeperPolicies
+metadata:
+  name: policies
+spec:
+  catalog: `+catalogPath+`
+  environment: `+environment+`
+`), &out)).To(g.Succeed())
+
+		var manifests []map[string]interface{}
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var m map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+			manifests = append(manifests, m)
+		}
+
+		return manifests
+	}
+
+	ginkgo.It("generates ConstraintTemplates and Constraints", func() {
+		manifests := generate("staging")
+		g.Expect(manifests).To(g.HaveLen(3))
+
+		g.Expect(manifests[0]["apiVersion"]).To(g.Equal("templates.gatekeeper.sh/v1"))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("ConstraintTemplate"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "k8srequiredlabels"))
+		g.Expect(manifests[0]["spec"]).To(g.HaveKeyWithValue("crd", map[string]interface{}{
+			"spec": map[string]interface{}{
+				"names": map[string]interface{}{
+					"kind": "K8sRequiredLabels",
+				},
+				"validation": map[string]interface{}{
+					"openAPIV3Schema": map[string]interface{}{
+						"type": "object",
+						"properties": map[string]interface{}{
+							"labels": map[string]interface{}{
+								"type":  "array",
+								"items": map[string]interface{}{"type": "string"},
+							},
+						},
+					},
+				},
+			},
+		}))
+		g.Expect(manifests[0]["spec"]).To(g.HaveKeyWithValue("targets", g.ConsistOf(g.HaveKeyWithValue("target", "admission.k8s.gatekeeper.sh"))))
+
+		g.Expect(manifests[1]["apiVersion"]).To(g.Equal("constraints.gatekeeper.sh/v1beta1"))
+		g.Expect(manifests[1]["kind"]).To(g.Equal("K8sRequiredLabels"))
+		g.Expect(manifests[1]["metadata"]).To(g.HaveKeyWithValue("name", "namespaces-must-have-team"))
+		g.Expect(manifests[1]["spec"]).To(g.Equal(map[string]interface{}{
+			"enforcementAction": "warn",
+			"match": map[string]interface{}{
+				"kinds": []interface{}{
+					map[string]interface{}{
+						"apiGroups": []interface{}{""},
+						"kinds":     []interface{}{"Namespace"},
+					},
+				},
+			},
+			"parameters": map[string]interface{}{
+				"labels": []interface{}{"team"},
+			},
+		}))
+
+		g.Expect(manifests[2]["metadata"]).To(g.HaveKeyWithValue("name", "deployments-must-have-service"))
+		g.Expect(manifests[2]["spec"]).To(g.HaveKeyWithValue("enforcementAction", "dryrun"))
+	})
+
+	ginkgo.It("resolves enforcement actions per environment", func() {
+		manifests := generate("production")
+		g.Expect(manifests[1]["spec"]).To(g.HaveKeyWithValue("enforcementAction", "deny"))
+		g.Expect(manifests[2]["spec"]).To(g.HaveKeyWithValue("enforcementAction", "warn"))
+	})
+
+	ginkgo.It("falls back to deny", func() {
+		manifests := generate("development")
+		g.Expect(manifests[1]["spec"]).To(g.HaveKeyWithValue("enforcementAction", "deny"))
+	})
+
+	ginkgo.It("fails without catalog", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: GatekeeperPolicies
+metadata:
+  name: policies
+`), &out)).To(g.MatchError("spec.catalog is empty"))
+	})
+})
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	defaultLabel            = "grafana_dashboard"
+	defaultLabelValue       = "1"
+	defaultFolderAnnotation = "grafana_folder"
+
+	// defaultMaxSize keeps ConfigMaps under the 256KiB limit of annotations,
+	// which client-side applies store the whole resource in.
+	defaultMaxSize = 256 * 1024
+
+	dashboardExtension = ".json"
+)
+
+type GrafanaDashboards struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Directory        string `json:"directory,omitempty"`
+	Folder           string `json:"folder,omitempty"`
+	Label            string `json:"label,omitempty"`
+	LabelValue       string `json:"labelValue,omitempty"`
+	FolderAnnotation string `json:"folderAnnotation,omitempty"`
+	MaxSize          int    `json:"maxSize,omitempty"`
+}
+
+type dashboard struct {
+	key  string
+	data string
+}
+
+type folder struct {
+	name       string
+	slug       string
+	dashboards []dashboard
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var grafanaDashboards GrafanaDashboards
+	if err := pluginconfig.Unmarshal(data, &grafanaDashboards); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&grafanaDashboards)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(grafanaDashboards *GrafanaDashboards) ([][]byte, error) {
+	spec := &grafanaDashboards.Spec
+
+	if spec.Directory == "" {
+		return nil, fmt.Errorf("spec.directory is empty")
+	}
+
+	maxSize := spec.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultMaxSize
+	}
+
+	folders, err := readFolders(spec.Directory, spec.Folder)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests [][]byte
+	for _, f := range folders {
+		chunks, err := splitDashboards(f.dashboards, maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, chunk := range chunks {
+			name := grafanaDashboards.Name
+			if f.slug != "" {
+				name = fmt.Sprintf("%s-%s", name, f.slug)
+			}
+			if len(chunks) > 1 {
+				name = fmt.Sprintf("%s-%d", name, i)
+			}
+
+			b, err := makeConfigMap(grafanaDashboards, name, f.name, chunk)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, b)
+		}
+	}
+
+	return manifests, nil
+}
+
+// readFolders reads the dashboards at the root of the directory into the
+// default folder and the ones in each subdirectory into a folder named after
+// it. Dashboards are compacted to make the most of the ConfigMap size limit.
+func readFolders(directory string, defaultFolder string) ([]folder, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	root := folder{
+		name: defaultFolder,
+	}
+
+	var folders []folder
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		dashboards, err := readDashboards(filepath.Join(directory, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(dashboards) == 0 {
+			continue
+		}
+
+		slug := strings.ToLower(entry.Name())
+		if errs := validation.IsDNS1123Label(slug); len(errs) > 0 {
+			return nil, fmt.Errorf("folder %s is not a valid name: %s", entry.Name(), strings.Join(errs, ", "))
+		}
+
+		folders = append(folders, folder{
+			name:       entry.Name(),
+			slug:       slug,
+			dashboards: dashboards,
+		})
+	}
+
+	dashboards, err := readDashboards(directory)
+	if err != nil {
+		return nil, err
+	}
+	if len(dashboards) > 0 {
+		root.dashboards = dashboards
+		folders = append([]folder{root}, folders...)
+	}
+
+	if len(folders) == 0 {
+		return nil, fmt.Errorf("%s has no dashboards", directory)
+	}
+
+	return folders, nil
+}
+
+func readDashboards(directory string) ([]dashboard, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+
+	var dashboards []dashboard
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != dashboardExtension {
+			continue
+		}
+
+		if errs := validation.IsConfigMapKey(entry.Name()); len(errs) > 0 {
+			return nil, fmt.Errorf("dashboard %s is not a valid key: %s", entry.Name(), strings.Join(errs, ", "))
+		}
+
+		entryPath := filepath.Join(directory, entry.Name())
+		data, err := ioutil.ReadFile(entryPath)
+		if err != nil {
+			return nil, err
+		}
+
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, data); err != nil {
+			return nil, fmt.Errorf("%s: %w", entryPath, err)
+		}
+
+		dashboards = append(dashboards, dashboard{
+			key:  entry.Name(),
+			data: compacted.String(),
+		})
+	}
+
+	return dashboards, nil
+}
+
+// splitDashboards packs dashboards, in order, into as few chunks within the
+// size limit as possible without reordering them, so names stay stable.
+func splitDashboards(dashboards []dashboard, maxSize int) ([][]dashboard, error) {
+	var chunks [][]dashboard
+	var chunk []dashboard
+	size := 0
+
+	for _, d := range dashboards {
+		dashboardSize := len(d.key) + len(d.data)
+		if dashboardSize > maxSize {
+			return nil, fmt.Errorf("dashboard %s has %d bytes, more than the maximum of %d", d.key, dashboardSize, maxSize)
+		}
+
+		if size+dashboardSize > maxSize {
+			chunks = append(chunks, chunk)
+			chunk = nil
+			size = 0
+		}
+
+		chunk = append(chunk, d)
+		size += dashboardSize
+	}
+
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+
+	return chunks, nil
+}
+
+func makeConfigMap(grafanaDashboards *GrafanaDashboards, name string, folderName string, dashboards []dashboard) ([]byte, error) {
+	spec := &grafanaDashboards.Spec
+
+	label := spec.Label
+	if label == "" {
+		label = defaultLabel
+	}
+
+	labelValue := spec.LabelValue
+	if labelValue == "" {
+		labelValue = defaultLabelValue
+	}
+
+	folderAnnotation := spec.FolderAnnotation
+	if folderAnnotation == "" {
+		folderAnnotation = defaultFolderAnnotation
+	}
+
+	objectMeta := *grafanaDashboards.ObjectMeta.DeepCopy()
+	objectMeta.Name = name
+
+	if objectMeta.Labels == nil {
+		objectMeta.Labels = make(map[string]string)
+	}
+	objectMeta.Labels[label] = labelValue
+
+	if folderName != "" {
+		if objectMeta.Annotations == nil {
+			objectMeta.Annotations = make(map[string]string)
+		}
+		objectMeta.Annotations[folderAnnotation] = folderName
+	}
+
+	data := make(map[string]string, len(dashboards))
+	for _, d := range dashboards {
+		data[d.key] = d.data
+	}
+
+	configMap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ConfigMap{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Data:       data,
+	}
+
+	return yaml.Marshal(configMap)
+}
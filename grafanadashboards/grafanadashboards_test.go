@@ -0,0 +1,109 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/grafanadashboards"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("GrafanaDashboards", func() {
+	var directory string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "grafanadashboards")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+		directory = dir
+
+		g.Expect(os.Mkdir(filepath.Join(directory, "Databases"), 0755)).To(g.Succeed())
+		for path, content := range map[string]string{
+			"overview.json":           `{"title": "Overview"}`,
+			"latency.json":            `{"title": "Latency",  "panels": []}`,
+			"README.md":               `not a dashboard`,
+			"Databases/postgres.json": `{"title": "Postgres"}`,
+		} {
+			g.Expect(ioutil.WriteFile(filepath.Join(directory, path), []byte(content), 0644)).To(g.Succeed())
+		}
+	})
+
+	generate := func(spec string) []corev1.ConfigMap {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: GrafanaDashboards
+metadata:
+  name: dashboards
+  namespace: monitoring
+spec:
+  directory: `+directory+`
+`+spec), &out)).To(g.Succeed())
+
+		var configMaps []corev1.ConfigMap
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var configMap corev1.ConfigMap
+			g.Expect(yaml.Unmarshal([]byte(manifest), &configMap)).To(g.Succeed())
+			configMaps = append(configMaps, configMap)
+		}
+
+		return configMaps
+	}
+
+	ginkgo.It("packages dashboards into ConfigMaps per folder", func() {
+		configMaps := generate("  folder: My App\n")
+		g.Expect(configMaps).To(g.HaveLen(2))
+
+		g.Expect(configMaps[0].Name).To(g.Equal("dashboards"))
+		g.Expect(configMaps[0].Namespace).To(g.Equal("monitoring"))
+		g.Expect(configMaps[0].Labels).To(g.HaveKeyWithValue("grafana_dashboard", "1"))
+		g.Expect(configMaps[0].Annotations).To(g.HaveKeyWithValue("grafana_folder", "My App"))
+		g.Expect(configMaps[0].Data).To(g.Equal(map[string]string{
+			"latency.json":  `{"title":"Latency","panels":[]}`,
+			"overview.json": `{"title":"Overview"}`,
+		}))
+
+		g.Expect(configMaps[1].Name).To(g.Equal("dashboards-databases"))
+		g.Expect(configMaps[1].Annotations).To(g.HaveKeyWithValue("grafana_folder", "Databases"))
+		g.Expect(configMaps[1].Data).To(g.HaveKey("postgres.json"))
+	})
+
+	ginkgo.It("splits dashboards exceeding the maximum size", func() {
+		configMaps := generate("  maxSize: 50\n")
+		g.Expect(configMaps).To(g.HaveLen(3))
+
+		g.Expect(configMaps[0].Name).To(g.Equal("dashboards-0"))
+		g.Expect(configMaps[0].Data).To(g.HaveKey("latency.json"))
+		g.Expect(configMaps[0].Annotations).To(g.BeEmpty())
+		g.Expect(configMaps[1].Name).To(g.Equal("dashboards-1"))
+		g.Expect(configMaps[1].Data).To(g.HaveKey("overview.json"))
+		g.Expect(configMaps[2].Name).To(g.Equal("dashboards-databases"))
+	})
+
+	ginkgo.It("fails on invalid dashboards", func() {
+		g.Expect(ioutil.WriteFile(filepath.Join(directory, "broken.json"), []byte(`{"title": `), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: GrafanaDashboards
+metadata:
+  name: dashboards
+spec:
+  directory: `+directory+`
+`), &out)
+		g.Expect(err).To(g.HaveOccurred())
+		g.Expect(strings.HasPrefix(err.Error(), filepath.Join(directory, "broken.json"))).To(g.BeTrue())
+	})
+})
@@ -6,10 +6,15 @@ OS_NAME=$(uname -s | tr '[:upper:]' '[:lower:]')
 PLACEMENT=${XDG_CONFIG_HOME:-$HOME/.config}/kustomize/plugin/incognia.com/v1alpha1
 RELEASE_URL=https://github.com/inloco/iac-kustomize-plugins/releases/download/v0.0.0
 
-for KIND in ArgoCDProject ClusterRoles KustomizeBuild Namespace Unnamespaced
+for KIND in AgeSecret AnalysisTemplates ApplicationSets AppProjectAggregator ArgoCDProject Autoscaling BackupSchedules Certificates ClusterRoles ConfigChecksum ConfigManagementPlugins CostAllocation CronWorkflows DatadogAutodiscovery DeprecatedAPIs DriftReport EnvInjector EventTriggers Exposure ExternalDNS ExternalSecrets GatekeeperPolicies GrafanaDashboards HierarchicalNamespaces ImageDigests IRSAServiceAccount IstioRouting KustomizeBuild KyvernoPolicies Monitors Namespace NamespaceLabelPropagator NamingConventions NetworkPolicies NodePlacement NodePools NotificationsCatalog Ownership PodDisruptionBudgets PodSecurityLabels PriorityClasses PriorityClassInjector RegistryAllowlist RegistryCredentials Replicas ResourceCustomizations ResourceDefaults RolloutConverter SealedSecret ServiceAccountInjector ServiceLevelObjectives SidecarInjector SSMParameters StandardLabels SyncDependencies SyncWaves TeamRBAC TeamsRegistry Tenant TenantNamespace TenantQuota TLSSecret TopologySpread Unnamespaced VaultSecret VulnerabilityGate
 do
 	KIND_LOWERCASE=$(echo ${KIND} | tr '[:upper:]' '[:lower:]')
 	mkdir -p ${PLACEMENT}/${KIND_LOWERCASE}
 	wget -O ${PLACEMENT}/${KIND_LOWERCASE}/${KIND} ${RELEASE_URL}/${KIND_LOWERCASE}-${OS_NAME}-amd64
 	chmod +x ${PLACEMENT}/${KIND_LOWERCASE}/${KIND}
 done
+
+# ArgoCDProject also generates project files of incognia.com/v1beta1.
+V1BETA1_PLACEMENT=$(dirname ${PLACEMENT})/v1beta1
+mkdir -p ${V1BETA1_PLACEMENT}/argocdproject
+cp ${PLACEMENT}/argocdproject/ArgoCDProject ${V1BETA1_PLACEMENT}/argocdproject/ArgoCDProject
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	hncGroup               = "hnc.x-k8s.io"
+	hncVersion             = "v1alpha2"
+	subnamespaceAnchorKind = "SubnamespaceAnchor"
+	hncConfigurationKind   = "HNCConfiguration"
+	hncConfigurationName   = "config"
+)
+
+type SynchronizationMode string
+
+const (
+	Propagate      SynchronizationMode = "Propagate"
+	Ignore         SynchronizationMode = "Ignore"
+	Remove         SynchronizationMode = "Remove"
+	AllowPropagate SynchronizationMode = "AllowPropagate"
+)
+
+type HierarchicalNamespaces struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Tree      []Node     `json:"tree,omitempty"`
+	Resources []Resource `json:"resources,omitempty"`
+}
+
+type Node struct {
+	Name     string `json:"name,omitempty"`
+	Children []Node `json:"children,omitempty"`
+}
+
+type Resource struct {
+	Group    string              `json:"group,omitempty"`
+	Resource string              `json:"resource,omitempty"`
+	Mode     SynchronizationMode `json:"mode,omitempty"`
+}
+
+type subnamespaceAnchor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+}
+
+type hncConfiguration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              hncConfigurationSpec `json:"spec"`
+}
+
+type hncConfigurationSpec struct {
+	Resources []Resource `json:"resources,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var hierarchicalNamespaces HierarchicalNamespaces
+	if err := pluginconfig.Unmarshal(data, &hierarchicalNamespaces); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&hierarchicalNamespaces)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(hierarchicalNamespaces *HierarchicalNamespaces) ([][]byte, error) {
+	var manifests [][]byte
+
+	seen := make(map[string]bool)
+	for _, root := range hierarchicalNamespaces.Spec.Tree {
+		anchors, err := makeSubnamespaceAnchors(hierarchicalNamespaces, &root, seen)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, anchors...)
+	}
+
+	if len(hierarchicalNamespaces.Spec.Resources) > 0 {
+		configuration, err := makeHNCConfiguration(hierarchicalNamespaces)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, configuration)
+	}
+
+	return manifests, nil
+}
+
+func makeSubnamespaceAnchors(hierarchicalNamespaces *HierarchicalNamespaces, parent *Node, seen map[string]bool) ([][]byte, error) {
+	if errs := validation.IsDNS1123Label(parent.Name); len(errs) > 0 {
+		return nil, fmt.Errorf("namespace name %q is invalid: %s", parent.Name, strings.Join(errs, ", "))
+	}
+
+	if seen[parent.Name] {
+		return nil, fmt.Errorf("namespace %s is duplicated", parent.Name)
+	}
+	seen[parent.Name] = true
+
+	var manifests [][]byte
+	for _, child := range parent.Children {
+		anchor := subnamespaceAnchor{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: schema.GroupVersion{
+					Group:   hncGroup,
+					Version: hncVersion,
+				}.String(),
+				Kind: subnamespaceAnchorKind,
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        child.Name,
+				Namespace:   parent.Name,
+				Labels:      hierarchicalNamespaces.Labels,
+				Annotations: hierarchicalNamespaces.Annotations,
+			},
+		}
+
+		b, err := yaml.Marshal(anchor)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+
+		descendants, err := makeSubnamespaceAnchors(hierarchicalNamespaces, &child, seen)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, descendants...)
+	}
+
+	return manifests, nil
+}
+
+func makeHNCConfiguration(hierarchicalNamespaces *HierarchicalNamespaces) ([]byte, error) {
+	for _, resource := range hierarchicalNamespaces.Spec.Resources {
+		if resource.Resource == "" {
+			return nil, fmt.Errorf("spec.resources has a resource without name")
+		}
+
+		switch resource.Mode {
+		case Propagate, Ignore, Remove, AllowPropagate:
+		default:
+			return nil, fmt.Errorf("resource %s has unknown mode %q", resource.Resource, resource.Mode)
+		}
+	}
+
+	configuration := hncConfiguration{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   hncGroup,
+				Version: hncVersion,
+			}.String(),
+			Kind: hncConfigurationKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        hncConfigurationName,
+			Labels:      hierarchicalNamespaces.Labels,
+			Annotations: hierarchicalNamespaces.Annotations,
+		},
+		Spec: hncConfigurationSpec{
+			Resources: hierarchicalNamespaces.Spec.Resources,
+		},
+	}
+
+	return yaml.Marshal(configuration)
+}
@@ -0,0 +1,108 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/hierarchicalnamespaces"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+type resource struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Resources []main.Resource `json:"resources,omitempty"`
+	} `json:"spec,omitempty"`
+}
+
+var _ = ginkgo.Describe("HierarchicalNamespaces", func() {
+	ginkgo.It("generates anchors for every child and the propagation config", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: HierarchicalNamespaces
+metadata:
+  name: hierarchical-namespaces
+spec:
+  tree:
+    - name: sre
+      children:
+        - name: sre-monitoring
+          children:
+            - name: sre-monitoring-dev
+        - name: sre-logging
+  resources:
+    - resource: secrets
+      mode: Propagate
+    - group: networking.k8s.io
+      resource: networkpolicies
+      mode: Propagate
+`), &out)).To(g.Succeed())
+
+		var anchors []string
+		var configurations []resource
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var r resource
+			g.Expect(yaml.Unmarshal([]byte(manifest), &r)).To(g.Succeed())
+			g.Expect(r.APIVersion).To(g.Equal("hnc.x-k8s.io/v1alpha2"))
+
+			switch r.Kind {
+			case "SubnamespaceAnchor":
+				anchors = append(anchors, r.Namespace+"/"+r.Name)
+			case "HNCConfiguration":
+				configurations = append(configurations, r)
+			default:
+				ginkgo.Fail("unexpected kind " + r.Kind)
+			}
+		}
+
+		g.Expect(anchors).To(g.Equal([]string{
+			"sre/sre-monitoring",
+			"sre-monitoring/sre-monitoring-dev",
+			"sre/sre-logging",
+		}))
+
+		g.Expect(configurations).To(g.HaveLen(1))
+		g.Expect(configurations[0].Name).To(g.Equal("config"))
+		g.Expect(configurations[0].Spec.Resources).To(g.HaveLen(2))
+	})
+
+	ginkgo.DescribeTable("fails", func(config string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with duplicated namespaces", `
+apiVersion: incognia.com/v1alpha1
+kind: HierarchicalNamespaces
+metadata:
+  name: hierarchical-namespaces
+spec:
+  tree:
+    - name: sre
+      children:
+        - name: sre-dev
+    - name: platform
+      children:
+        - name: sre-dev
+`, "namespace sre-dev is duplicated"),
+		ginkgo.Entry("with unknown mode", `
+apiVersion: incognia.com/v1alpha1
+kind: HierarchicalNamespaces
+metadata:
+  name: hierarchical-namespaces
+spec:
+  resources:
+    - resource: secrets
+      mode: Copy
+`, `resource secrets has unknown mode "Copy"`),
+	)
+})
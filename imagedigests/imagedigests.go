@@ -0,0 +1,343 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	cronJobKind = "CronJob"
+	defaultTag  = "latest"
+)
+
+var (
+	ecrImageRegexp = regexp.MustCompile(`^([0-9]+)\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com/([^:@]+)(?::([^:@]+))?$`)
+
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podSpecPath = []string{
+		"spec",
+		"template",
+		"spec",
+	}
+
+	cronJobPodSpecPath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+		"spec",
+	}
+
+	containerFields = []string{
+		"containers",
+		"initContainers",
+	}
+)
+
+type ImageDigests struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Lockfile string   `json:"lockfile,omitempty"`
+	Offline  bool     `json:"offline,omitempty"`
+	Refresh  bool     `json:"refresh,omitempty"`
+	Kinds    []string `json:"kinds,omitempty"`
+}
+
+type ECRClient interface {
+	DescribeImages(ctx context.Context, params *ecr.DescribeImagesInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error)
+}
+
+type ECRClientFactory func(region string) (ECRClient, error)
+
+type ecrImage struct {
+	registryID string
+	region     string
+	repository string
+	tag        string
+}
+
+// resolver looks digests up on ECR, querying each image and creating each
+// regional client at most once per build, unless the image is locked.
+type resolver struct {
+	newECRClient ECRClientFactory
+	offline      bool
+	clients      map[string]ECRClient
+	locked       map[string]string
+	digests      map[string]string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	return TransformManifestsWithECRClientFactory(newECRClient, data, in, out)
+}
+
+func TransformManifestsWithECRClientFactory(newECRClient ECRClientFactory, data []byte, in io.Reader, out io.Writer) error {
+	var imageDigests ImageDigests
+	if err := pluginconfig.Unmarshal(data, &imageDigests); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(newECRClient, &imageDigests, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func newECRClient(region string) (ECRClient, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return ecr.NewFromConfig(cfg), nil
+}
+
+func transform(newECRClient ECRClientFactory, imageDigests *ImageDigests, nodes []*kyaml.RNode) error {
+	spec := &imageDigests.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	if spec.Offline && spec.Lockfile == "" {
+		return fmt.Errorf("spec.lockfile is empty")
+	}
+
+	if spec.Offline && spec.Refresh {
+		return fmt.Errorf("spec.refresh cannot be used offline")
+	}
+
+	locked, err := readLockfile(spec.Lockfile)
+	if err != nil {
+		return err
+	}
+
+	r := &resolver{
+		newECRClient: newECRClient,
+		offline:      spec.Offline,
+		clients:      make(map[string]ECRClient),
+		locked:       locked,
+		digests:      make(map[string]string),
+	}
+	// refreshing resolves every image again, rewriting the lockfile with the
+	// digests their tags point to now
+	if spec.Refresh {
+		r.locked = make(map[string]string)
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		path := podSpecPath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodSpecPath
+		}
+
+		for _, field := range containerFields {
+			containers, err := node.Pipe(kyaml.Lookup(append(path, field)...))
+			if err != nil {
+				return err
+			}
+			if containers == nil {
+				continue
+			}
+
+			elements, err := containers.Elements()
+			if err != nil {
+				return err
+			}
+
+			for _, container := range elements {
+				if err := pinImage(r, container); err != nil {
+					return fmt.Errorf("%s %s: %w", node.GetKind(), node.GetName(), err)
+				}
+			}
+		}
+	}
+
+	if spec.Lockfile == "" || spec.Offline {
+		return nil
+	}
+
+	// only the images of the build are written, so the lockfile drops the
+	// images no longer used
+
+	return writeLockfile(spec.Lockfile, r.digests)
+}
+
+func pinImage(r *resolver, container *kyaml.RNode) error {
+	value, err := container.Pipe(kyaml.Lookup("image"))
+	if err != nil {
+		return err
+	}
+
+	image := kyaml.GetValue(value)
+	matches := ecrImageRegexp.FindStringSubmatch(image)
+	if matches == nil {
+		return nil
+	}
+
+	tag := matches[4]
+	if tag == "" {
+		tag = defaultTag
+		image = fmt.Sprintf("%s:%s", image, tag)
+	}
+
+	digest, err := r.resolve(image, &ecrImage{
+		registryID: matches[1],
+		region:     matches[2],
+		repository: matches[3],
+		tag:        tag,
+	})
+	if err != nil {
+		return err
+	}
+
+	return container.PipeE(kyaml.SetField("image", kyaml.NewStringRNode(fmt.Sprintf("%s@%s", image, digest))))
+}
+
+func (r *resolver) resolve(image string, ecrImage *ecrImage) (string, error) {
+	if digest, exists := r.digests[image]; exists {
+		return digest, nil
+	}
+
+	if digest, exists := r.locked[image]; exists {
+		r.digests[image] = digest
+		return digest, nil
+	}
+
+	if r.offline {
+		return "", fmt.Errorf("image %s is not locked", image)
+	}
+
+	client, exists := r.clients[ecrImage.region]
+	if !exists {
+		c, err := r.newECRClient(ecrImage.region)
+		if err != nil {
+			return "", err
+		}
+		r.clients[ecrImage.region] = c
+		client = c
+	}
+
+	output, err := client.DescribeImages(context.Background(), &ecr.DescribeImagesInput{
+		RegistryId:     aws.String(ecrImage.registryID),
+		RepositoryName: aws.String(ecrImage.repository),
+		ImageIds: []ecrtypes.ImageIdentifier{
+			ecrtypes.ImageIdentifier{
+				ImageTag: aws.String(ecrImage.tag),
+			},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if len(output.ImageDetails) == 0 || output.ImageDetails[0].ImageDigest == nil {
+		return "", fmt.Errorf("image %s not found", image)
+	}
+
+	digest := aws.ToString(output.ImageDetails[0].ImageDigest)
+	r.digests[image] = digest
+
+	return digest, nil
+}
+
+func readLockfile(filePath string) (map[string]string, error) {
+	digests := make(map[string]string)
+	if filePath == "" {
+		return digests, nil
+	}
+
+	data, err := ioutil.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return digests, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, &digests); err != nil {
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	return digests, nil
+}
+
+// writeLockfile records the digests resolved by the build, which yaml.Marshal
+// sorts by image so lockfiles diff cleanly.
+func writeLockfile(filePath string, digests map[string]string) error {
+	data, err := yaml.Marshal(digests)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(filePath, data, 0644)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
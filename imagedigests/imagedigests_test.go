@@ -0,0 +1,206 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/imagedigests"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrations
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0
+      containers:
+        - name: app
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0
+        - name: worker
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker
+        - name: sidecar
+          image: envoyproxy/envoy:v1.21.0
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+type fakeECRClient struct {
+	calls *int
+}
+
+func (c fakeECRClient) DescribeImages(_ context.Context, input *ecr.DescribeImagesInput, _ ...func(*ecr.Options)) (*ecr.DescribeImagesOutput, error) {
+	*c.calls++
+
+	return &ecr.DescribeImagesOutput{
+		ImageDetails: []ecrtypes.ImageDetail{
+			{
+				ImageDigest: aws.String("sha256:" + aws.ToString(input.RepositoryName) + "-" + aws.ToString(input.ImageIds[0].ImageTag)),
+			},
+		},
+	}, nil
+}
+
+var _ = ginkgo.Describe("ImageDigests", func() {
+	var calls int
+	newFakeECRClient := func(string) (main.ECRClient, error) {
+		return fakeECRClient{
+			calls: &calls,
+		}, nil
+	}
+
+	var lockfile string
+	ginkgo.BeforeEach(func() {
+		calls = 0
+
+		dir, err := ioutil.TempDir("", "imagedigests")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		lockfile = filepath.Join(dir, "images.lock.yaml")
+	})
+
+	transform := func(config string) appsv1.Deployment {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifestsWithECRClientFactory(newFakeECRClient, []byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(1))
+
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &deployment)).To(g.Succeed())
+
+		return deployment
+	}
+
+	ginkgo.It("pins ECR images to digests and writes the lockfile", func() {
+		podSpec := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: ` + lockfile + `
+`).Spec.Template.Spec
+
+		g.Expect(podSpec.InitContainers[0].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0@sha256:my-app-1.0.0"))
+		g.Expect(podSpec.Containers[0].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0@sha256:my-app-1.0.0"))
+		g.Expect(podSpec.Containers[1].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest@sha256:my-worker-latest"))
+		g.Expect(podSpec.Containers[2].Image).To(g.Equal("envoyproxy/envoy:v1.21.0"))
+		g.Expect(calls).To(g.Equal(2))
+
+		data, err := ioutil.ReadFile(lockfile)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var digests map[string]string
+		g.Expect(yaml.Unmarshal(data, &digests)).To(g.Succeed())
+		g.Expect(digests).To(g.Equal(map[string]string{
+			"123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0":     "sha256:my-app-1.0.0",
+			"123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest": "sha256:my-worker-latest",
+		}))
+	})
+
+	ginkgo.It("keeps locked images without resolving them again", func() {
+		g.Expect(ioutil.WriteFile(lockfile, []byte(`
+123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0: sha256:locked-app
+123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:0.9.0: sha256:unused-app
+`), 0644)).To(g.Succeed())
+
+		podSpec := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: ` + lockfile + `
+`).Spec.Template.Spec
+
+		g.Expect(podSpec.Containers[0].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0@sha256:locked-app"))
+		g.Expect(podSpec.Containers[1].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest@sha256:my-worker-latest"))
+		g.Expect(calls).To(g.Equal(1))
+
+		data, err := ioutil.ReadFile(lockfile)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var digests map[string]string
+		g.Expect(yaml.Unmarshal(data, &digests)).To(g.Succeed())
+		g.Expect(digests).To(g.Equal(map[string]string{
+			"123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0":     "sha256:locked-app",
+			"123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest": "sha256:my-worker-latest",
+		}))
+	})
+
+	ginkgo.It("resolves locked images again when refreshing", func() {
+		g.Expect(ioutil.WriteFile(lockfile, []byte(`
+123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0: sha256:locked-app
+`), 0644)).To(g.Succeed())
+
+		podSpec := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: ` + lockfile + `
+  refresh: true
+`).Spec.Template.Spec
+
+		g.Expect(podSpec.Containers[0].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0@sha256:my-app-1.0.0"))
+		g.Expect(calls).To(g.Equal(2))
+	})
+
+	ginkgo.It("pins images from the lockfile when offline", func() {
+		g.Expect(ioutil.WriteFile(lockfile, []byte(`
+123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0: sha256:locked-app
+123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest: sha256:locked-worker
+`), 0644)).To(g.Succeed())
+
+		podSpec := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: ` + lockfile + `
+  offline: true
+`).Spec.Template.Spec
+
+		g.Expect(podSpec.Containers[0].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0@sha256:locked-app"))
+		g.Expect(podSpec.Containers[1].Image).To(g.Equal("123456789876.dkr.ecr.us-east-1.amazonaws.com/my-worker:latest@sha256:locked-worker"))
+		g.Expect(calls).To(g.BeZero())
+	})
+
+	ginkgo.It("fails on images missing from the lockfile when offline", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifestsWithECRClientFactory(newFakeECRClient, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: `+lockfile+`
+  offline: true
+`), strings.NewReader(resources), &out)).To(g.MatchError("Deployment my-app: image 123456789876.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0 is not locked"))
+	})
+})
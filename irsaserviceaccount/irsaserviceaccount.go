@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	roleARNAnnotation              = "eks.amazonaws.com/role-arn"
+	stsRegionalEndpointsAnnotation = "eks.amazonaws.com/sts-regional-endpoints"
+	tokenExpirationAnnotation      = "eks.amazonaws.com/token-expiration"
+
+	defaultPartition = "aws"
+)
+
+var (
+	roleARNRegexp   = regexp.MustCompile(`^arn:aws[a-z-]*:iam::[0-9]{12}:role/[\w+=,.@/-]+$`)
+	accountIDRegexp = regexp.MustCompile(`^[0-9]{12}$`)
+)
+
+type IRSAServiceAccount struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	RoleARN              string `json:"roleARN,omitempty"`
+	RoleNameTemplate     string `json:"roleNameTemplate,omitempty"`
+	AccountID            string `json:"accountID,omitempty"`
+	Partition            string `json:"partition,omitempty"`
+	STSRegionalEndpoints bool   `json:"stsRegionalEndpoints,omitempty"`
+	TokenExpiration      int64  `json:"tokenExpiration,omitempty"`
+}
+
+type roleNameData struct {
+	Name      string
+	Namespace string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var irsaServiceAccount IRSAServiceAccount
+	if err := pluginconfig.Unmarshal(data, &irsaServiceAccount); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&irsaServiceAccount)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(irsaServiceAccount *IRSAServiceAccount) ([][]byte, error) {
+	if irsaServiceAccount.Name == "" {
+		return nil, fmt.Errorf("metadata.name is empty")
+	}
+
+	roleARN, err := makeRoleARN(irsaServiceAccount)
+	if err != nil {
+		return nil, err
+	}
+
+	serviceAccount, err := makeServiceAccount(irsaServiceAccount, roleARN)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{serviceAccount}, nil
+}
+
+func makeRoleARN(irsaServiceAccount *IRSAServiceAccount) (string, error) {
+	spec := &irsaServiceAccount.Spec
+
+	switch {
+	case spec.RoleARN != "" && spec.RoleNameTemplate != "":
+		return "", fmt.Errorf("spec.roleARN and spec.roleNameTemplate are mutually exclusive")
+	case spec.RoleARN != "":
+		if !roleARNRegexp.MatchString(spec.RoleARN) {
+			return "", fmt.Errorf("spec.roleARN %s is not an IAM role ARN", spec.RoleARN)
+		}
+
+		return spec.RoleARN, nil
+	case spec.RoleNameTemplate != "":
+		if !accountIDRegexp.MatchString(spec.AccountID) {
+			return "", fmt.Errorf("spec.accountID %q is not an AWS account ID", spec.AccountID)
+		}
+
+		tmpl, err := template.New("roleNameTemplate").Option("missingkey=error").Parse(spec.RoleNameTemplate)
+		if err != nil {
+			return "", err
+		}
+
+		var roleName strings.Builder
+		if err := tmpl.Execute(&roleName, roleNameData{
+			Name:      irsaServiceAccount.Name,
+			Namespace: irsaServiceAccount.Namespace,
+		}); err != nil {
+			return "", err
+		}
+
+		partition := spec.Partition
+		if partition == "" {
+			partition = defaultPartition
+		}
+
+		roleARN := fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, spec.AccountID, roleName.String())
+		if !roleARNRegexp.MatchString(roleARN) {
+			return "", fmt.Errorf("%s is not an IAM role ARN", roleARN)
+		}
+
+		return roleARN, nil
+	default:
+		return "", fmt.Errorf("one of spec.roleARN or spec.roleNameTemplate must be set")
+	}
+}
+
+func makeServiceAccount(irsaServiceAccount *IRSAServiceAccount, roleARN string) ([]byte, error) {
+	objectMeta := *irsaServiceAccount.ObjectMeta.DeepCopy()
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = make(map[string]string)
+	}
+	objectMeta.Annotations[roleARNAnnotation] = roleARN
+
+	if irsaServiceAccount.Spec.STSRegionalEndpoints {
+		objectMeta.Annotations[stsRegionalEndpointsAnnotation] = strconv.FormatBool(true)
+	}
+
+	if irsaServiceAccount.Spec.TokenExpiration != 0 {
+		objectMeta.Annotations[tokenExpirationAnnotation] = strconv.FormatInt(irsaServiceAccount.Spec.TokenExpiration, 10)
+	}
+
+	serviceAccount := corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ServiceAccount{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+	}
+
+	return yaml.Marshal(serviceAccount)
+}
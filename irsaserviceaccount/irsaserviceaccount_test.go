@@ -0,0 +1,64 @@
+package main_test
+
+import (
+	"bytes"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/irsaserviceaccount"
+)
+
+var _ = ginkgo.Describe("IRSAServiceAccount", func() {
+	ginkgo.DescribeTable("", func(spec string, expectedAnnotations map[string]string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: IRSAServiceAccount
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec: `+spec+`
+`), &out)).To(g.Succeed())
+
+		var serviceAccount corev1.ServiceAccount
+		g.Expect(yaml.Unmarshal(out.Bytes(), &serviceAccount)).To(g.Succeed())
+
+		g.Expect(serviceAccount.Kind).To(g.Equal("ServiceAccount"))
+		g.Expect(serviceAccount.Name).To(g.Equal("my-app"))
+		g.Expect(serviceAccount.Namespace).To(g.Equal("my-namespace"))
+		g.Expect(serviceAccount.Annotations).To(g.Equal(expectedAnnotations))
+	},
+		ginkgo.Entry("with role ARN", "{roleARN: arn:aws:iam::123456789876:role/my-app}", map[string]string{
+			"eks.amazonaws.com/role-arn": "arn:aws:iam::123456789876:role/my-app",
+		}),
+		ginkgo.Entry("with role name template", `
+  roleNameTemplate: eks/{{ .Namespace }}-{{ .Name }}
+  accountID: "123456789876"
+  stsRegionalEndpoints: true
+  tokenExpiration: 3600
+`, map[string]string{
+			"eks.amazonaws.com/role-arn":               "arn:aws:iam::123456789876:role/eks/my-namespace-my-app",
+			"eks.amazonaws.com/sts-regional-endpoints": "true",
+			"eks.amazonaws.com/token-expiration":       "3600",
+		}),
+	)
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: IRSAServiceAccount
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec: `+spec+`
+`), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without role", "{}", "one of spec.roleARN or spec.roleNameTemplate must be set"),
+		ginkgo.Entry("with invalid role ARN", "{roleARN: arn:aws:iam::123456789876:user/my-app}", "spec.roleARN arn:aws:iam::123456789876:user/my-app is not an IAM role ARN"),
+		ginkgo.Entry("with role name template and without account ID", `{roleNameTemplate: "{{ .Name }}"}`, `spec.accountID "" is not an AWS account ID`),
+	)
+})
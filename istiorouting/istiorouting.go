@@ -0,0 +1,420 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	gatewayKind         = "Gateway"
+	virtualServiceKind  = "VirtualService"
+	destinationRuleKind = "DestinationRule"
+
+	httpPortNumber  = 80
+	httpsPortNumber = 443
+	httpProtocol    = "HTTP"
+	httpsProtocol   = "HTTPS"
+	simpleTLSMode   = "SIMPLE"
+
+	totalWeight = 100
+)
+
+var (
+	istioNetworkingGroupVersion = schema.GroupVersion{
+		Group:   "networking.istio.io",
+		Version: "v1beta1",
+	}
+
+	defaultGatewaySelector = map[string]string{
+		"istio": "ingressgateway",
+	}
+)
+
+type IstioRouting struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Host    string   `json:"host,omitempty"`
+	Service Service  `json:"service,omitempty"`
+	Gateway Gateway  `json:"gateway,omitempty"`
+	Subsets []Subset `json:"subsets,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+	Retries *Retries `json:"retries,omitempty"`
+}
+
+type Service struct {
+	Name string `json:"name,omitempty"`
+	Port uint32 `json:"port,omitempty"`
+}
+
+type Gateway struct {
+	Name           string            `json:"name,omitempty"`
+	Selector       map[string]string `json:"selector,omitempty"`
+	CredentialName string            `json:"credentialName,omitempty"`
+}
+
+type Subset struct {
+	Name   string            `json:"name,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Weight int32             `json:"weight,omitempty"`
+}
+
+type Retries struct {
+	Attempts      int32  `json:"attempts,omitempty"`
+	PerTryTimeout string `json:"perTryTimeout,omitempty"`
+	RetryOn       string `json:"retryOn,omitempty"`
+}
+
+// The types below mirror the subset of the Istio networking API written by
+// this plugin, which avoids depending on the whole Istio module.
+
+type gateway struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              gatewaySpec `json:"spec"`
+}
+
+type gatewaySpec struct {
+	Selector map[string]string `json:"selector"`
+	Servers  []server          `json:"servers"`
+}
+
+type server struct {
+	Port  port       `json:"port"`
+	Hosts []string   `json:"hosts"`
+	TLS   *serverTLS `json:"tls,omitempty"`
+}
+
+type port struct {
+	Number   uint32 `json:"number"`
+	Name     string `json:"name"`
+	Protocol string `json:"protocol"`
+}
+
+type serverTLS struct {
+	Mode           string `json:"mode"`
+	CredentialName string `json:"credentialName"`
+}
+
+type virtualService struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              virtualServiceSpec `json:"spec"`
+}
+
+type virtualServiceSpec struct {
+	Hosts    []string    `json:"hosts"`
+	Gateways []string    `json:"gateways,omitempty"`
+	HTTP     []httpRoute `json:"http"`
+}
+
+type httpRoute struct {
+	Route   []routeDestination `json:"route"`
+	Timeout string             `json:"timeout,omitempty"`
+	Retries *Retries           `json:"retries,omitempty"`
+}
+
+type routeDestination struct {
+	Destination destination `json:"destination"`
+	Weight      int32       `json:"weight,omitempty"`
+}
+
+type destination struct {
+	Host   string        `json:"host"`
+	Subset string        `json:"subset,omitempty"`
+	Port   *portSelector `json:"port,omitempty"`
+}
+
+type portSelector struct {
+	Number uint32 `json:"number"`
+}
+
+type destinationRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              destinationRuleSpec `json:"spec"`
+}
+
+type destinationRuleSpec struct {
+	Host    string   `json:"host"`
+	Subsets []subset `json:"subsets,omitempty"`
+}
+
+type subset struct {
+	Name   string            `json:"name"`
+	Labels map[string]string `json:"labels"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var istioRouting IstioRouting
+	if err := pluginconfig.Unmarshal(data, &istioRouting); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&istioRouting)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(istioRouting *IstioRouting) ([][]byte, error) {
+	spec := &istioRouting.Spec
+
+	if spec.Service.Name == "" {
+		spec.Service.Name = istioRouting.Name
+	}
+
+	if err := validateSpec(spec); err != nil {
+		return nil, err
+	}
+
+	var manifests [][]byte
+
+	gatewayName := spec.Gateway.Name
+	if spec.Host != "" && gatewayName == "" {
+		b, err := makeGateway(istioRouting)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+
+		gatewayName = istioRouting.Name
+	}
+
+	b, err := makeVirtualService(istioRouting, gatewayName)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, b)
+
+	b, err = makeDestinationRule(istioRouting)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, b)
+
+	return manifests, nil
+}
+
+func validateSpec(spec *Spec) error {
+	if spec.Gateway.Name != "" && spec.Host == "" {
+		return fmt.Errorf("spec.gateway.name requires spec.host")
+	}
+
+	if spec.Timeout != "" {
+		if _, err := time.ParseDuration(spec.Timeout); err != nil {
+			return fmt.Errorf("spec.timeout: %w", err)
+		}
+	}
+
+	if spec.Retries != nil && spec.Retries.PerTryTimeout != "" {
+		if _, err := time.ParseDuration(spec.Retries.PerTryTimeout); err != nil {
+			return fmt.Errorf("spec.retries.perTryTimeout: %w", err)
+		}
+	}
+
+	names := make(map[string]bool, len(spec.Subsets))
+	var weights int32
+	for i, s := range spec.Subsets {
+		if s.Name == "" {
+			return fmt.Errorf("spec.subsets[%d].name is empty", i)
+		}
+
+		if names[s.Name] {
+			return fmt.Errorf("subset %s is duplicated", s.Name)
+		}
+		names[s.Name] = true
+
+		if len(s.Labels) == 0 {
+			return fmt.Errorf("subset %s has no labels", s.Name)
+		}
+
+		weights += s.Weight
+	}
+
+	if weights > 0 && weights != totalWeight {
+		return fmt.Errorf("subset weights sum up to %d instead of %d", weights, totalWeight)
+	}
+
+	return nil
+}
+
+func makeGateway(istioRouting *IstioRouting) ([]byte, error) {
+	spec := &istioRouting.Spec
+
+	selector := spec.Gateway.Selector
+	if len(selector) == 0 {
+		selector = defaultGatewaySelector
+	}
+
+	srv := server{
+		Port: port{
+			Number:   httpPortNumber,
+			Name:     "http",
+			Protocol: httpProtocol,
+		},
+		Hosts: []string{
+			spec.Host,
+		},
+	}
+
+	if spec.Gateway.CredentialName != "" {
+		srv.Port = port{
+			Number:   httpsPortNumber,
+			Name:     "https",
+			Protocol: httpsProtocol,
+		}
+		srv.TLS = &serverTLS{
+			Mode:           simpleTLSMode,
+			CredentialName: spec.Gateway.CredentialName,
+		}
+	}
+
+	return yaml.Marshal(gateway{
+		TypeMeta:   makeTypeMeta(gatewayKind),
+		ObjectMeta: istioRouting.ObjectMeta,
+		Spec: gatewaySpec{
+			Selector: selector,
+			Servers: []server{
+				srv,
+			},
+		},
+	})
+}
+
+func makeVirtualService(istioRouting *IstioRouting, gatewayName string) ([]byte, error) {
+	spec := &istioRouting.Spec
+
+	hosts := []string{
+		spec.Service.Name,
+	}
+
+	var gateways []string
+	if gatewayName != "" {
+		hosts = []string{
+			spec.Host,
+		}
+		gateways = []string{
+			gatewayName,
+		}
+	}
+
+	var destinationPort *portSelector
+	if spec.Service.Port > 0 {
+		destinationPort = &portSelector{
+			Number: spec.Service.Port,
+		}
+	}
+
+	var routes []routeDestination
+	for _, s := range spec.Subsets {
+		if s.Weight == 0 {
+			continue
+		}
+
+		routes = append(routes, routeDestination{
+			Destination: destination{
+				Host:   spec.Service.Name,
+				Subset: s.Name,
+				Port:   destinationPort,
+			},
+			Weight: s.Weight,
+		})
+	}
+
+	if len(routes) == 0 {
+		routes = []routeDestination{
+			routeDestination{
+				Destination: destination{
+					Host: spec.Service.Name,
+					Port: destinationPort,
+				},
+			},
+		}
+	}
+
+	return yaml.Marshal(virtualService{
+		TypeMeta:   makeTypeMeta(virtualServiceKind),
+		ObjectMeta: istioRouting.ObjectMeta,
+		Spec: virtualServiceSpec{
+			Hosts:    hosts,
+			Gateways: gateways,
+			HTTP: []httpRoute{
+				httpRoute{
+					Route:   routes,
+					Timeout: spec.Timeout,
+					Retries: spec.Retries,
+				},
+			},
+		},
+	})
+}
+
+func makeDestinationRule(istioRouting *IstioRouting) ([]byte, error) {
+	spec := &istioRouting.Spec
+
+	subsets := make([]subset, 0, len(spec.Subsets))
+	for _, s := range spec.Subsets {
+		subsets = append(subsets, subset{
+			Name:   s.Name,
+			Labels: s.Labels,
+		})
+	}
+
+	return yaml.Marshal(destinationRule{
+		TypeMeta:   makeTypeMeta(destinationRuleKind),
+		ObjectMeta: istioRouting.ObjectMeta,
+		Spec: destinationRuleSpec{
+			Host:    spec.Service.Name,
+			Subsets: subsets,
+		},
+	})
+}
+
+func makeTypeMeta(kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{
+		APIVersion: istioNetworkingGroupVersion.String(),
+		Kind:       kind,
+	}
+}
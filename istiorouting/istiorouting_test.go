@@ -0,0 +1,164 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/istiorouting"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("IstioRouting", func() {
+	ginkgo.It("generates Gateway, VirtualService and DestinationRule", func() {
+		manifests := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: IstioRouting
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  host: my-app.example.com
+  service:
+    port: 8080
+  gateway:
+    credentialName: my-app-tls
+  subsets:
+    - name: stable
+      labels:
+        version: v1
+      weight: 90
+    - name: canary
+      labels:
+        version: v2
+      weight: 10
+  timeout: 10s
+  retries:
+    attempts: 3
+    perTryTimeout: 2s
+    retryOn: 5xx
+`)
+		g.Expect(manifests).To(g.HaveLen(3))
+
+		g.Expect(manifests[0]["apiVersion"]).To(g.Equal("networking.istio.io/v1beta1"))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("Gateway"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("namespace", "my-namespace"))
+		g.Expect(manifests[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"selector": map[string]interface{}{
+				"istio": "ingressgateway",
+			},
+			"servers": []interface{}{
+				map[string]interface{}{
+					"port": map[string]interface{}{
+						"number":   float64(443),
+						"name":     "https",
+						"protocol": "HTTPS",
+					},
+					"hosts": []interface{}{"my-app.example.com"},
+					"tls": map[string]interface{}{
+						"mode":           "SIMPLE",
+						"credentialName": "my-app-tls",
+					},
+				},
+			},
+		}))
+
+		g.Expect(manifests[1]["kind"]).To(g.Equal("VirtualService"))
+		g.Expect(manifests[1]["spec"]).To(g.Equal(map[string]interface{}{
+			"hosts":    []interface{}{"my-app.example.com"},
+			"gateways": []interface{}{"my-app"},
+			"http": []interface{}{
+				map[string]interface{}{
+					"route": []interface{}{
+						map[string]interface{}{
+							"destination": map[string]interface{}{
+								"host":   "my-app",
+								"subset": "stable",
+								"port":   map[string]interface{}{"number": float64(8080)},
+							},
+							"weight": float64(90),
+						},
+						map[string]interface{}{
+							"destination": map[string]interface{}{
+								"host":   "my-app",
+								"subset": "canary",
+								"port":   map[string]interface{}{"number": float64(8080)},
+							},
+							"weight": float64(10),
+						},
+					},
+					"timeout": "10s",
+					"retries": map[string]interface{}{
+						"attempts":      float64(3),
+						"perTryTimeout": "2s",
+						"retryOn":       "5xx",
+					},
+				},
+			},
+		}))
+
+		g.Expect(manifests[2]["kind"]).To(g.Equal("DestinationRule"))
+		g.Expect(manifests[2]["spec"]).To(g.HaveKeyWithValue("host", "my-app"))
+		g.Expect(manifests[2]["spec"]).To(g.HaveKeyWithValue("subsets", g.HaveLen(2)))
+	})
+
+	ginkgo.It("routes mesh traffic without host", func() {
+		manifests := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: IstioRouting
+metadata:
+  name: my-app
+spec:
+  timeout: 5s
+`)
+		g.Expect(manifests).To(g.HaveLen(2))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("VirtualService"))
+		g.Expect(manifests[0]["spec"]).To(g.HaveKeyWithValue("hosts", []interface{}{"my-app"}))
+		g.Expect(manifests[0]["spec"]).NotTo(g.HaveKey("gateways"))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: IstioRouting
+metadata:
+  name: my-app
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with weights not summing up to 100", `
+  subsets:
+    - {name: stable, labels: {version: v1}, weight: 50}
+    - {name: canary, labels: {version: v2}, weight: 10}
+`, "subset weights sum up to 60 instead of 100"),
+		ginkgo.Entry("with subset without labels", `
+  subsets:
+    - {name: stable}
+`, "subset stable has no labels"),
+		ginkgo.Entry("with existing gateway without host", `
+  gateway:
+    name: istio-system/public
+`, "spec.gateway.name requires spec.host"),
+	)
+})
+
+func generate(config string) []map[string]interface{} {
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.Succeed())
+
+	var manifests []map[string]interface{}
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var m map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
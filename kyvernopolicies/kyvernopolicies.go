@@ -0,0 +1,325 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	environmentLabel = "incognia.com/environment"
+
+	clusterPolicyKind = "ClusterPolicy"
+	podKind           = "Pod"
+	auditAction       = "Audit"
+	enforceAction     = "Enforce"
+
+	requiredLabelsSuffix       = "-required-labels"
+	disallowedRegistriesSuffix = "-disallowed-registries"
+	requiredProbesSuffix       = "-required-probes"
+
+	anyValuePattern      = "?*"
+	positivePattern      = ">0"
+	negationPattern      = "!%s/*"
+	conjunctionSeparator = " & "
+	probeSuffix          = "Probe"
+)
+
+var (
+	kyvernoGroupVersion = schema.GroupVersion{
+		Group:   "kyverno.io",
+		Version: "v1",
+	}
+
+	validationFailureActions = []string{
+		auditAction,
+		enforceAction,
+	}
+
+	probes = []string{
+		"liveness",
+		"readiness",
+		"startup",
+	}
+)
+
+type KyvernoPolicies struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	RequiredLabels          []string          `json:"requiredLabels,omitempty"`
+	DisallowedRegistries    []string          `json:"disallowedRegistries,omitempty"`
+	RequiredProbes          []string          `json:"requiredProbes,omitempty"`
+	ExcludedNamespaces      []string          `json:"excludedNamespaces,omitempty"`
+	ValidationFailureAction string            `json:"validationFailureAction,omitempty"`
+	Environment             string            `json:"environment,omitempty"`
+	Environments            map[string]string `json:"environments,omitempty"`
+}
+
+// The types below mirror the subset of the Kyverno API written by this plugin,
+// which avoids depending on the whole Kyverno module.
+
+type clusterPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              policySpec `json:"spec"`
+}
+
+type policySpec struct {
+	ValidationFailureAction string `json:"validationFailureAction"`
+	Background              bool   `json:"background"`
+	Rules                   []rule `json:"rules"`
+}
+
+type rule struct {
+	Name     string           `json:"name"`
+	Match    matchResources   `json:"match"`
+	Exclude  *matchResources  `json:"exclude,omitempty"`
+	Validate validationResult `json:"validate"`
+}
+
+type matchResources struct {
+	Any []resourceFilter `json:"any"`
+}
+
+type resourceFilter struct {
+	Resources resourceDescription `json:"resources"`
+}
+
+type resourceDescription struct {
+	Kinds      []string `json:"kinds,omitempty"`
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+type validationResult struct {
+	Message string                 `json:"message"`
+	Pattern map[string]interface{} `json:"pattern"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var kyvernoPolicies KyvernoPolicies
+	if err := pluginconfig.Unmarshal(data, &kyvernoPolicies); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&kyvernoPolicies)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(kyvernoPolicies *KyvernoPolicies) ([][]byte, error) {
+	spec := &kyvernoPolicies.Spec
+
+	environment := spec.Environment
+	if environment == "" {
+		environment = kyvernoPolicies.Labels[environmentLabel]
+	}
+
+	action, exists := spec.Environments[environment]
+	if !exists {
+		action = spec.ValidationFailureAction
+	}
+	if action == "" {
+		action = auditAction
+	}
+	if !containsString(validationFailureActions, action) {
+		return nil, fmt.Errorf("validation failure action %s is not supported", action)
+	}
+
+	for _, probe := range spec.RequiredProbes {
+		if !containsString(probes, probe) {
+			return nil, fmt.Errorf("probe %s is not supported", probe)
+		}
+	}
+
+	validations := []struct {
+		suffix   string
+		validate func(*Spec) *validationResult
+	}{
+		{requiredLabelsSuffix, requiredLabels},
+		{disallowedRegistriesSuffix, disallowedRegistries},
+		{requiredProbesSuffix, requiredProbes},
+	}
+
+	var exclude *matchResources
+	if len(spec.ExcludedNamespaces) > 0 {
+		exclude = &matchResources{
+			Any: []resourceFilter{
+				{
+					Resources: resourceDescription{
+						Namespaces: spec.ExcludedNamespaces,
+					},
+				},
+			},
+		}
+	}
+
+	objectMeta := *kyvernoPolicies.ObjectMeta.DeepCopy()
+	objectMeta.Namespace = ""
+
+	var manifests [][]byte
+	for _, validation := range validations {
+		validate := validation.validate(spec)
+		if validate == nil {
+			continue
+		}
+
+		policyMeta := *objectMeta.DeepCopy()
+		policyMeta.Name = objectMeta.Name + validation.suffix
+
+		b, err := yaml.Marshal(clusterPolicy{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: kyvernoGroupVersion.String(),
+				Kind:       clusterPolicyKind,
+			},
+			ObjectMeta: policyMeta,
+			Spec: policySpec{
+				ValidationFailureAction: action,
+				Background:              true,
+				Rules: []rule{
+					{
+						Name: strings.TrimPrefix(validation.suffix, "-"),
+						Match: matchResources{
+							Any: []resourceFilter{
+								{
+									Resources: resourceDescription{
+										Kinds: []string{
+											podKind,
+										},
+									},
+								},
+							},
+						},
+						Exclude:  exclude,
+						Validate: *validate,
+					},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+func requiredLabels(spec *Spec) *validationResult {
+	if len(spec.RequiredLabels) == 0 {
+		return nil
+	}
+
+	labels := make(map[string]interface{}, len(spec.RequiredLabels))
+	for _, label := range spec.RequiredLabels {
+		labels[label] = anyValuePattern
+	}
+
+	return &validationResult{
+		Message: fmt.Sprintf("The labels %s are required.", strings.Join(spec.RequiredLabels, ", ")),
+		Pattern: map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": labels,
+			},
+		},
+	}
+}
+
+func disallowedRegistries(spec *Spec) *validationResult {
+	if len(spec.DisallowedRegistries) == 0 {
+		return nil
+	}
+
+	negations := make([]string, 0, len(spec.DisallowedRegistries))
+	for _, registry := range spec.DisallowedRegistries {
+		negations = append(negations, fmt.Sprintf(negationPattern, registry))
+	}
+
+	return &validationResult{
+		Message: fmt.Sprintf("Images from %s are not allowed.", strings.Join(spec.DisallowedRegistries, ", ")),
+		Pattern: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{
+						"image": strings.Join(negations, conjunctionSeparator),
+					},
+				},
+			},
+		},
+	}
+}
+
+func requiredProbes(spec *Spec) *validationResult {
+	if len(spec.RequiredProbes) == 0 {
+		return nil
+	}
+
+	container := make(map[string]interface{}, len(spec.RequiredProbes))
+	for _, probe := range spec.RequiredProbes {
+		container[probe+probeSuffix] = map[string]interface{}{
+			"periodSeconds": positivePattern,
+		}
+	}
+
+	return &validationResult{
+		Message: fmt.Sprintf("The probes %s are required.", strings.Join(spec.RequiredProbes, ", ")),
+		Pattern: map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					container,
+				},
+			},
+		},
+	}
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
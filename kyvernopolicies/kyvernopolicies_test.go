@@ -0,0 +1,163 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/kyvernopolicies"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: KyvernoPolicies
+metadata:
+  name: baseline
+  labels:
+    incognia.com/environment: staging
+spec:
+  requiredLabels:
+    - incognia.com/service
+  disallowedRegistries:
+    - docker.io
+    - quay.io
+  requiredProbes:
+    - liveness
+    - readiness
+  excludedNamespaces:
+    - kube-system
+  environments:
+    production: Enforce
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("KyvernoPolicies", func() {
+	ginkgo.It("generates a ClusterPolicy for each requirement", func() {
+		manifests := generate(config)
+		g.Expect(manifests).To(g.HaveLen(3))
+
+		g.Expect(manifests[0]["apiVersion"]).To(g.Equal("kyverno.io/v1"))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("ClusterPolicy"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "baseline-required-labels"))
+		g.Expect(manifests[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"validationFailureAction": "Audit",
+			"background":              true,
+			"rules": []interface{}{
+				map[string]interface{}{
+					"name": "required-labels",
+					"match": map[string]interface{}{
+						"any": []interface{}{
+							map[string]interface{}{
+								"resources": map[string]interface{}{
+									"kinds": []interface{}{"Pod"},
+								},
+							},
+						},
+					},
+					"exclude": map[string]interface{}{
+						"any": []interface{}{
+							map[string]interface{}{
+								"resources": map[string]interface{}{
+									"namespaces": []interface{}{"kube-system"},
+								},
+							},
+						},
+					},
+					"validate": map[string]interface{}{
+						"message": "The labels incognia.com/service are required.",
+						"pattern": map[string]interface{}{
+							"metadata": map[string]interface{}{
+								"labels": map[string]interface{}{
+									"incognia.com/service": "?*",
+								},
+							},
+						},
+					},
+				},
+			},
+		}))
+
+		g.Expect(manifests[1]["metadata"]).To(g.HaveKeyWithValue("name", "baseline-disallowed-registries"))
+		g.Expect(validate(manifests[1])).To(g.HaveKeyWithValue("pattern", map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{"image": "!docker.io/* & !quay.io/*"},
+				},
+			},
+		}))
+
+		g.Expect(manifests[2]["metadata"]).To(g.HaveKeyWithValue("name", "baseline-required-probes"))
+		g.Expect(validate(manifests[2])).To(g.HaveKeyWithValue("pattern", map[string]interface{}{
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{
+						"livenessProbe":  map[string]interface{}{"periodSeconds": ">0"},
+						"readinessProbe": map[string]interface{}{"periodSeconds": ">0"},
+					},
+				},
+			},
+		}))
+	})
+
+	ginkgo.It("uses the mode of the environment", func() {
+		manifests := generate(config + "  environment: production\n")
+		for _, manifest := range manifests {
+			g.Expect(manifest["spec"]).To(g.HaveKeyWithValue("validationFailureAction", "Enforce"))
+		}
+	})
+
+	ginkgo.It("skips requirements not configured", func() {
+		manifests := generate(`
+apiVersion: incognia.com/v1alpha1
+kind: KyvernoPolicies
+metadata:
+  name: baseline
+spec:
+  requiredProbes:
+    - readiness
+`)
+		g.Expect(manifests).To(g.HaveLen(1))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "baseline-required-probes"))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: KyvernoPolicies
+metadata:
+  name: baseline
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with unsupported mode", "  validationFailureAction: Block\n", "validation failure action Block is not supported"),
+		ginkgo.Entry("with unsupported probe", "  requiredProbes: [health]\n", "probe health is not supported"),
+	)
+})
+
+func generate(config string) []map[string]interface{} {
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.Succeed())
+
+	var manifests []map[string]interface{}
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var m map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
+
+func validate(manifest map[string]interface{}) map[string]interface{} {
+	rules := manifest["spec"].(map[string]interface{})["rules"].([]interface{})
+	return rules[0].(map[string]interface{})["validate"].(map[string]interface{})
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	serviceKind        = "Service"
+	serviceMonitorKind = "ServiceMonitor"
+	podMonitorKind     = "PodMonitor"
+
+	defaultScrapeAnnotation = "prometheus.io/scrape"
+	defaultPortAnnotation   = "prometheus.io/port"
+	defaultPathAnnotation   = "prometheus.io/path"
+
+	defaultPath     = "/metrics"
+	defaultInterval = "30s"
+)
+
+var (
+	monitoringGroupVersion = schema.GroupVersion{
+		Group:   "monitoring.coreos.com",
+		Version: "v1",
+	}
+)
+
+type Monitors struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Monitors    []Monitor   `json:"monitors,omitempty"`
+	Annotations Annotations `json:"annotations,omitempty"`
+	Interval    string      `json:"interval,omitempty"`
+}
+
+type Monitor struct {
+	Kind      string                `json:"kind,omitempty"`
+	Name      string                `json:"name,omitempty"`
+	Namespace string                `json:"namespace,omitempty"`
+	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
+	Port      string                `json:"port,omitempty"`
+	Path      string                `json:"path,omitempty"`
+	Interval  string                `json:"interval,omitempty"`
+}
+
+type Annotations struct {
+	Scrape string `json:"scrape,omitempty"`
+	Port   string `json:"port,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// The types below mirror the subset of the Prometheus Operator API written by
+// this plugin, which avoids depending on the whole Prometheus Operator module.
+
+type monitor struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              monitorSpec `json:"spec"`
+}
+
+type monitorSpec struct {
+	Selector            metav1.LabelSelector `json:"selector"`
+	Endpoints           []endpoint           `json:"endpoints,omitempty"`
+	PodMetricsEndpoints []endpoint           `json:"podMetricsEndpoints,omitempty"`
+}
+
+type endpoint struct {
+	Port     string `json:"port"`
+	Path     string `json:"path,omitempty"`
+	Interval string `json:"interval,omitempty"`
+}
+
+type monitorKey struct {
+	kind      string
+	namespace string
+	name      string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var monitors Monitors
+	if err := pluginconfig.Unmarshal(data, &monitors); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	nodes, err = transform(&monitors, nodes)
+	if err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(monitors *Monitors, nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	spec := &monitors.Spec
+
+	annotations := spec.Annotations
+	if annotations.Scrape == "" {
+		annotations.Scrape = defaultScrapeAnnotation
+	}
+	if annotations.Port == "" {
+		annotations.Port = defaultPortAnnotation
+	}
+	if annotations.Path == "" {
+		annotations.Path = defaultPathAnnotation
+	}
+
+	interval := spec.Interval
+	if interval == "" {
+		interval = defaultInterval
+	}
+
+	existing := make(map[monitorKey]bool)
+	for _, node := range nodes {
+		if kind := node.GetKind(); kind == serviceMonitorKind || kind == podMonitorKind {
+			existing[monitorKey{
+				kind:      kind,
+				namespace: node.GetNamespace(),
+				name:      node.GetName(),
+			}] = true
+		}
+	}
+
+	var generated []*monitor
+	for i, m := range spec.Monitors {
+		if m.Namespace == "" {
+			m.Namespace = monitors.Namespace
+		}
+		if m.Interval == "" {
+			m.Interval = interval
+		}
+
+		generatedMonitor, err := makeMonitor(&m)
+		if err != nil {
+			return nil, fmt.Errorf("spec.monitors[%d]: %w", i, err)
+		}
+		generated = append(generated, generatedMonitor)
+	}
+
+	for _, node := range nodes {
+		if node.GetKind() != serviceKind || node.GetAnnotations()[annotations.Scrape] != "true" {
+			continue
+		}
+
+		generatedMonitor, err := makeServiceMonitor(node, &annotations, interval)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", node.GetKind(), node.GetName(), err)
+		}
+		generated = append(generated, generatedMonitor)
+	}
+
+	for _, generatedMonitor := range generated {
+		key := monitorKey{
+			kind:      generatedMonitor.Kind,
+			namespace: generatedMonitor.Namespace,
+			name:      generatedMonitor.Name,
+		}
+		if existing[key] {
+			continue
+		}
+		existing[key] = true
+
+		b, err := yaml.Marshal(generatedMonitor)
+		if err != nil {
+			return nil, err
+		}
+
+		node, err := kyaml.Parse(string(b))
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+
+	return nodes, nil
+}
+
+func makeMonitor(m *Monitor) (*monitor, error) {
+	if m.Name == "" {
+		return nil, fmt.Errorf("name is empty")
+	}
+
+	if m.Port == "" {
+		return nil, fmt.Errorf("port is empty")
+	}
+
+	if m.Selector == nil {
+		return nil, fmt.Errorf("selector is empty")
+	}
+
+	kind := m.Kind
+	if kind == "" {
+		kind = serviceMonitorKind
+	}
+
+	path := m.Path
+	if path == "" {
+		path = defaultPath
+	}
+
+	endpoints := []endpoint{
+		endpoint{
+			Port:     m.Port,
+			Path:     path,
+			Interval: m.Interval,
+		},
+	}
+
+	monitorSpec := monitorSpec{
+		Selector: *m.Selector,
+	}
+
+	switch kind {
+	case serviceMonitorKind:
+		monitorSpec.Endpoints = endpoints
+	case podMonitorKind:
+		monitorSpec.PodMetricsEndpoints = endpoints
+	default:
+		return nil, fmt.Errorf("unsupported kind %s", kind)
+	}
+
+	return &monitor{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringGroupVersion.String(),
+			Kind:       kind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      m.Name,
+			Namespace: m.Namespace,
+		},
+		Spec: monitorSpec,
+	}, nil
+}
+
+// makeServiceMonitor introspects a Service annotated for scraping. As
+// ServiceMonitors reference ports by name, numeric port annotations are
+// resolved against the ports of the Service.
+func makeServiceMonitor(node *kyaml.RNode, annotations *Annotations, interval string) (*monitor, error) {
+	serviceLabels := node.GetLabels()
+	if len(serviceLabels) == 0 {
+		return nil, fmt.Errorf("service has no labels to be selected by")
+	}
+
+	var ports []corev1.ServicePort
+	if err := decodeField(node, []string{"spec", "ports"}, &ports); err != nil {
+		return nil, err
+	}
+
+	portName, err := resolvePort(ports, node.GetAnnotations()[annotations.Port])
+	if err != nil {
+		return nil, err
+	}
+
+	path := node.GetAnnotations()[annotations.Path]
+	if path == "" {
+		path = defaultPath
+	}
+
+	return makeMonitor(&Monitor{
+		Kind:      serviceMonitorKind,
+		Name:      node.GetName(),
+		Namespace: node.GetNamespace(),
+		Selector: &metav1.LabelSelector{
+			MatchLabels: serviceLabels,
+		},
+		Port:     portName,
+		Path:     path,
+		Interval: interval,
+	})
+}
+
+func resolvePort(ports []corev1.ServicePort, value string) (string, error) {
+	if value == "" {
+		for _, port := range ports {
+			if port.Name != "" {
+				return port.Name, nil
+			}
+		}
+
+		return "", fmt.Errorf("no named port")
+	}
+
+	number, err := strconv.ParseInt(value, 10, 32)
+	if err != nil {
+		return value, nil
+	}
+
+	for _, port := range ports {
+		if port.Port != int32(number) {
+			continue
+		}
+
+		if port.Name == "" {
+			return "", fmt.Errorf("port %d has no name", number)
+		}
+
+		return port.Name, nil
+	}
+
+	return "", fmt.Errorf("port %d not found", number)
+}
+
+func decodeField(node *kyaml.RNode, path []string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
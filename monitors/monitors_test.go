@@ -0,0 +1,150 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/monitors"
+)
+
+const (
+	resources = `
+apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+  namespace: my-namespace
+  labels:
+    app: my-app
+  annotations:
+    prometheus.io/scrape: "true"
+    prometheus.io/port: "9090"
+spec:
+  ports:
+    - name: http
+      port: 80
+    - name: metrics
+      port: 9090
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: not-scraped
+  labels:
+    app: not-scraped
+spec:
+  ports:
+    - name: http
+      port: 80
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("Monitors", func() {
+	ginkgo.It("generates monitors from the spec and from annotated Services", func() {
+		monitors := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: Monitors
+metadata:
+  name: monitors
+  namespace: my-namespace
+spec:
+  interval: 1m
+  monitors:
+    - kind: PodMonitor
+      name: my-worker
+      selector:
+        matchLabels:
+          app: my-worker
+      port: metrics
+      path: /prometheus
+`)
+		g.Expect(monitors).To(g.HaveLen(2))
+
+		g.Expect(monitors).To(g.HaveKeyWithValue("PodMonitor/my-worker", map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "PodMonitor",
+			"metadata": map[string]interface{}{
+				"creationTimestamp": nil,
+				"name":              "my-worker",
+				"namespace":         "my-namespace",
+			},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"app": "my-worker",
+					},
+				},
+				"podMetricsEndpoints": []interface{}{
+					map[string]interface{}{
+						"port":     "metrics",
+						"path":     "/prometheus",
+						"interval": "1m",
+					},
+				},
+			},
+		}))
+
+		g.Expect(monitors).To(g.HaveKeyWithValue("ServiceMonitor/my-app", map[string]interface{}{
+			"apiVersion": "monitoring.coreos.com/v1",
+			"kind":       "ServiceMonitor",
+			"metadata": map[string]interface{}{
+				"creationTimestamp": nil,
+				"name":              "my-app",
+				"namespace":         "my-namespace",
+			},
+			"spec": map[string]interface{}{
+				"selector": map[string]interface{}{
+					"matchLabels": map[string]interface{}{
+						"app": "my-app",
+					},
+				},
+				"endpoints": []interface{}{
+					map[string]interface{}{
+						"port":     "metrics",
+						"path":     "/metrics",
+						"interval": "1m",
+					},
+				},
+			},
+		}))
+	})
+
+	ginkgo.It("fails on annotated Services with unnamed ports", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Monitors
+metadata:
+  name: monitors
+`), strings.NewReader(strings.Replace(resources, "- name: metrics\n", "- ", 1)), &out)).To(g.MatchError("Service my-app: port 9090 has no name"))
+	})
+})
+
+func transform(config string) map[string]map[string]interface{} {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	monitors := make(map[string]map[string]interface{})
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var m map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+
+		if m["kind"] == "Service" {
+			continue
+		}
+
+		metadata := m["metadata"].(map[string]interface{})
+		monitors[m["kind"].(string)+"/"+metadata["name"].(string)] = m
+	}
+
+	return monitors
+}
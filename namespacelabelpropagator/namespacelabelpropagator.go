@@ -0,0 +1,134 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+)
+
+var (
+	namespaceKind = reflect.TypeOf(corev1.Namespace{}).Name()
+
+	defaultLabels = []string{
+		"incognia.com/team",
+		"incognia.com/environment",
+		"incognia.com/cost-center",
+	}
+)
+
+type NamespaceLabelPropagator struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Labels   []string `json:"labels,omitempty"`
+	Override bool     `json:"override,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var namespaceLabelPropagator NamespaceLabelPropagator
+	if err := pluginconfig.Unmarshal(data, &namespaceLabelPropagator); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&namespaceLabelPropagator, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(namespaceLabelPropagator *NamespaceLabelPropagator, nodes []*kyaml.RNode) error {
+	keys := namespaceLabelPropagator.Spec.Labels
+	if len(keys) == 0 {
+		keys = defaultLabels
+	}
+
+	namespaceLabels := make(map[string]map[string]string)
+	for _, node := range nodes {
+		if node.GetKind() != namespaceKind {
+			continue
+		}
+
+		labels := make(map[string]string)
+		for key, value := range node.GetLabels() {
+			if containsString(keys, key) {
+				labels[key] = value
+			}
+		}
+		namespaceLabels[node.GetName()] = labels
+	}
+
+	for _, node := range nodes {
+		labels, exists := namespaceLabels[node.GetNamespace()]
+		if !exists {
+			continue
+		}
+
+		current := node.GetLabels()
+		for _, key := range keys {
+			value, exists := labels[key]
+			if !exists {
+				continue
+			}
+
+			if _, exists := current[key]; exists && !namespaceLabelPropagator.Spec.Override {
+				continue
+			}
+
+			if err := node.PipeE(kyaml.SetLabel(key, value)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,112 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+
+	"github.com/inloco/iac-kustomize-plugins/namespacelabelpropagator"
+)
+
+const (
+	resources = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: my-app
+  labels:
+    incognia.com/team: sre
+    incognia.com/environment: production
+    incognia.com/cost-center: cc-1234
+    unrelated: label
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-app
+  namespace: my-app
+  labels:
+    incognia.com/team: platform
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: other
+  namespace: other
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: my-app
+`
+)
+
+var _ = ginkgo.Describe("NamespaceLabelPropagator", func() {
+	ginkgo.It("propagates labels without overriding", func() {
+		labels := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: NamespaceLabelPropagator
+metadata:
+  name: namespace-label-propagator
+`)
+
+		g.Expect(labels["Deployment/my-app"]).To(g.Equal(map[string]string{
+			"incognia.com/team":        "sre",
+			"incognia.com/environment": "production",
+			"incognia.com/cost-center": "cc-1234",
+		}))
+		g.Expect(labels["ConfigMap/my-app"]).To(g.Equal(map[string]string{
+			"incognia.com/team":        "platform",
+			"incognia.com/environment": "production",
+			"incognia.com/cost-center": "cc-1234",
+		}))
+		g.Expect(labels["ConfigMap/other"]).To(g.BeEmpty())
+		g.Expect(labels["ClusterRole/my-app"]).To(g.BeEmpty())
+	})
+
+	ginkgo.It("propagates selected labels with overriding", func() {
+		labels := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: NamespaceLabelPropagator
+metadata:
+  name: namespace-label-propagator
+spec:
+  labels:
+    - incognia.com/team
+    - unrelated
+  override: true
+`)
+
+		g.Expect(labels["ConfigMap/my-app"]).To(g.Equal(map[string]string{
+			"incognia.com/team": "sre",
+			"unrelated":         "label",
+		}))
+	})
+})
+
+func transform(config string) map[string]map[string]string {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                &out,
+		OmitReaderAnnotations: true,
+	}).Read()
+	g.Expect(err).To(g.BeNil())
+
+	labels := make(map[string]map[string]string)
+	for _, node := range nodes {
+		labels[node.GetKind()+"/"+node.GetName()] = node.GetLabels()
+	}
+
+	return labels
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	pluginName = "namingconventions"
+	namingRule = "naming"
+
+	skipAnnotation = "incognia.com/skip-naming-conventions"
+)
+
+type NamingConventions struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Rules []Rule `json:"rules,omitempty"`
+}
+
+type Rule struct {
+	Kinds     []string `json:"kinds,omitempty"`
+	Prefixes  []string `json:"prefixes,omitempty"`
+	MaxLength int      `json:"maxLength,omitempty"`
+	Pattern   string   `json:"pattern,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var namingConventions NamingConventions
+	if err := pluginconfig.Unmarshal(data, &namingConventions); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := validate(&namingConventions, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func validate(namingConventions *NamingConventions, nodes []*kyaml.RNode) error {
+	rules := namingConventions.Spec.Rules
+	if len(rules) == 0 {
+		return fmt.Errorf("spec.rules is empty")
+	}
+
+	patterns := make([]*regexp.Regexp, len(rules))
+	for i, rule := range rules {
+		if rule.Pattern == "" {
+			continue
+		}
+
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("rule %d: %w", i, err)
+		}
+		patterns[i] = pattern
+	}
+
+	var found []findings.Finding
+	for _, node := range nodes {
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		name := node.GetName()
+		for i, rule := range rules {
+			if len(rule.Kinds) > 0 && !containsString(rule.Kinds, node.GetKind()) {
+				continue
+			}
+
+			var reasons []string
+			if len(rule.Prefixes) > 0 && !hasAnyPrefix(name, rule.Prefixes) {
+				reasons = append(reasons, fmt.Sprintf("does not start with %s", strings.Join(rule.Prefixes, " or ")))
+			}
+
+			if rule.MaxLength > 0 && len(name) > rule.MaxLength {
+				reasons = append(reasons, fmt.Sprintf("is longer than %d characters", rule.MaxLength))
+			}
+
+			if patterns[i] != nil && !patterns[i].MatchString(name) {
+				reasons = append(reasons, fmt.Sprintf("does not match %s", rule.Pattern))
+			}
+
+			for _, reason := range reasons {
+				found = append(found, findings.Finding{
+					Path:     findings.SourcePath(node.GetAnnotations()),
+					Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+					Rule:     namingRule,
+					Message:  "name " + reason,
+				})
+			}
+		}
+	}
+
+	if err := findings.WriteReportFromEnv(pluginName, namingConventions.Name, found); err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("resources violating naming conventions:\n%s", findings.Lines(found))
+	}
+
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func resourceName(node *kyaml.RNode) string {
+	if namespace := node.GetNamespace(); namespace != "" {
+		return namespace + "/" + node.GetName()
+	}
+
+	return node.GetName()
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/namingconventions"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: NamingConventions
+metadata:
+  name: naming-conventions
+spec:
+  rules:
+    - maxLength: 20
+      pattern: ^[a-z0-9-]+$
+    - kinds:
+        - ClusterRole
+        - ClusterRoleBinding
+      prefixes:
+        - "incognia:"
+        - "platform:"
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-namespace
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: platform:reader
+  annotations:
+    incognia.com/skip-naming-conventions: "true"
+`
+)
+
+var _ = ginkgo.Describe("NamingConventions", func() {
+	ginkgo.It("passes resources through when names follow conventions", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.ContainSubstring("name: my-app"))
+		g.Expect(out.String()).To(g.ContainSubstring("name: platform:reader"))
+	})
+
+	ginkgo.It("lists resources violating conventions", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources+`
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: My_Very_Long_ConfigMap_Name
+  namespace: my-namespace
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+`), &out)).To(g.MatchError(`resources violating naming conventions:
+ConfigMap my-namespace/My_Very_Long_ConfigMap_Name: name is longer than 20 characters
+ConfigMap my-namespace/My_Very_Long_ConfigMap_Name: name does not match ^[a-z0-9-]+$
+ClusterRole reader: name does not start with incognia: or platform:`))
+	})
+
+	ginkgo.It("reports resources violating conventions", func() {
+		dir, err := ioutil.TempDir("", "namingconventions")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		g.Expect(os.Setenv("FINDINGS_DIR", dir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_DIR")
+
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(`
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: reader
+`), &out)).To(g.HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "namingconventions-naming-conventions.sarif"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring(`"ruleId": "naming"`))
+		g.Expect(string(data)).To(g.ContainSubstring(`"name": "ClusterRole reader"`))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NamingConventions
+metadata:
+  name: naming-conventions
+spec:
+`+spec), strings.NewReader(resources), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without rules", "  rules: []\n", "spec.rules is empty"),
+		ginkgo.Entry("with invalid pattern", "  rules: [{pattern: '['}]\n", "rule 0: error parsing regexp: missing closing ]: `[`"),
+	)
+})
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultClassLabel       = "incognia.com/node-class"
+	defaultEnvironmentLabel = "incognia.com/environment"
+	cronJobKind             = "CronJob"
+
+	capacityTypeLabel = "karpenter.sh/capacity-type"
+	gpuCountLabel     = "karpenter.k8s.aws/instance-gpu-count"
+	gpuResourceName   = "nvidia.com/gpu"
+	spotTaint         = "incognia.com/spot"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podSpecPath = []string{
+		"spec",
+		"template",
+		"spec",
+	}
+
+	cronJobPodSpecPath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+		"spec",
+	}
+
+	presets = map[string]Placement{
+		"on-demand": Placement{
+			NodeSelector: map[string]string{
+				capacityTypeLabel: "on-demand",
+			},
+		},
+		"spot": Placement{
+			NodeSelector: map[string]string{
+				capacityTypeLabel: "spot",
+			},
+			Tolerations: []corev1.Toleration{
+				corev1.Toleration{
+					Key:      spotTaint,
+					Operator: corev1.TolerationOpExists,
+					Effect:   corev1.TaintEffectNoSchedule,
+				},
+			},
+		},
+		"gpu": Placement{
+			Tolerations: []corev1.Toleration{
+				corev1.Toleration{
+					Key:      gpuResourceName,
+					Operator: corev1.TolerationOpExists,
+					Effect:   corev1.TaintEffectNoSchedule,
+				},
+			},
+			Affinity: &corev1.Affinity{
+				NodeAffinity: &corev1.NodeAffinity{
+					RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+						NodeSelectorTerms: []corev1.NodeSelectorTerm{
+							corev1.NodeSelectorTerm{
+								MatchExpressions: []corev1.NodeSelectorRequirement{
+									corev1.NodeSelectorRequirement{
+										Key:      gpuCountLabel,
+										Operator: corev1.NodeSelectorOpGt,
+										Values: []string{
+											"0",
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+)
+
+type NodePlacement struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds            []string             `json:"kinds,omitempty"`
+	ClassLabel       string               `json:"classLabel,omitempty"`
+	Environment      string               `json:"environment,omitempty"`
+	EnvironmentLabel string               `json:"environmentLabel,omitempty"`
+	DefaultClass     string               `json:"defaultClass,omitempty"`
+	Environments     map[string]string    `json:"environments,omitempty"`
+	Classes          map[string]Placement `json:"classes,omitempty"`
+}
+
+type Placement struct {
+	NodeSelector map[string]string   `json:"nodeSelector,omitempty"`
+	Tolerations  []corev1.Toleration `json:"tolerations,omitempty"`
+	Affinity     *corev1.Affinity    `json:"affinity,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var nodePlacement NodePlacement
+	if err := pluginconfig.Unmarshal(data, &nodePlacement); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&nodePlacement, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(nodePlacement *NodePlacement, nodes []*kyaml.RNode) error {
+	spec := &nodePlacement.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	classLabel := spec.ClassLabel
+	if classLabel == "" {
+		classLabel = defaultClassLabel
+	}
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	classes := make(map[string]Placement, len(presets)+len(spec.Classes))
+	for name, placement := range presets {
+		classes[name] = placement
+	}
+	for name, placement := range spec.Classes {
+		classes[name] = placement
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		environment := spec.Environment
+		if environment == "" {
+			environment = node.GetLabels()[environmentLabel]
+		}
+
+		class := node.GetLabels()[classLabel]
+		if class == "" {
+			class = spec.Environments[environment]
+		}
+		if class == "" {
+			class = spec.DefaultClass
+		}
+		if class == "" {
+			continue
+		}
+
+		placement, exists := classes[class]
+		if !exists {
+			return fmt.Errorf("%s %s: unknown node class %s", node.GetKind(), node.GetName(), class)
+		}
+
+		path := podSpecPath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodSpecPath
+		}
+
+		podSpec, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if podSpec == nil {
+			continue
+		}
+
+		if err := applyPlacement(podSpec, &placement); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func applyPlacement(podSpec *kyaml.RNode, placement *Placement) error {
+	keys := make([]string, 0, len(placement.NodeSelector))
+	for key := range placement.NodeSelector {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := podSpec.PipeE(
+			kyaml.LookupCreate(kyaml.MappingNode, "nodeSelector"),
+			kyaml.SetField(key, kyaml.NewStringRNode(placement.NodeSelector[key])),
+		); err != nil {
+			return err
+		}
+	}
+
+	if len(placement.Tolerations) > 0 {
+		var tolerations []corev1.Toleration
+		if err := decodeField(podSpec, "tolerations", &tolerations); err != nil {
+			return err
+		}
+
+		for _, toleration := range placement.Tolerations {
+			if !containsToleration(tolerations, toleration) {
+				tolerations = append(tolerations, toleration)
+			}
+		}
+
+		if err := encodeField(podSpec, "tolerations", tolerations); err != nil {
+			return err
+		}
+	}
+
+	if placement.Affinity != nil {
+		current, err := podSpec.Pipe(kyaml.Lookup("affinity"))
+		if err != nil {
+			return err
+		}
+
+		if current == nil {
+			if err := encodeField(podSpec, "affinity", placement.Affinity); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func decodeField(node *kyaml.RNode, field string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(field))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
+
+func encodeField(node *kyaml.RNode, field string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	value, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return node.PipeE(kyaml.SetField(field, value))
+}
+
+func containsToleration(tolerations []corev1.Toleration, toleration corev1.Toleration) bool {
+	for _, item := range tolerations {
+		if reflect.DeepEqual(item, toleration) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
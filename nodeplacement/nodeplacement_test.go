@@ -0,0 +1,143 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/nodeplacement"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: NodePlacement
+metadata:
+  name: node-placement
+spec:
+  defaultClass: on-demand
+  environments:
+    staging: spot
+  classes:
+    batch:
+      nodeSelector:
+        incognia.com/pool: batch
+      tolerations:
+        - key: incognia.com/pool
+          operator: Equal
+          value: batch
+          effect: NoSchedule
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: production
+  labels:
+    incognia.com/environment: production
+spec:
+  template:
+    spec:
+      nodeSelector:
+        kubernetes.io/os: linux
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: staging
+  labels:
+    incognia.com/environment: staging
+spec:
+  template:
+    spec:
+      tolerations:
+        - key: incognia.com/spot
+          operator: Exists
+          effect: NoSchedule
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: gpu
+  labels:
+    incognia.com/node-class: gpu
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: batch
+  labels:
+    incognia.com/node-class: batch
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("NodePlacement", func() {
+	ginkgo.It("places workloads by class and environment", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		podSpecs := make(map[string]corev1.PodSpec)
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var deployment appsv1.Deployment
+			g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+			podSpecs[deployment.Name] = deployment.Spec.Template.Spec
+		}
+
+		g.Expect(podSpecs["production"].NodeSelector).To(g.Equal(map[string]string{
+			"kubernetes.io/os":           "linux",
+			"karpenter.sh/capacity-type": "on-demand",
+		}))
+		g.Expect(podSpecs["production"].Tolerations).To(g.BeEmpty())
+
+		g.Expect(podSpecs["staging"].NodeSelector).To(g.Equal(map[string]string{
+			"karpenter.sh/capacity-type": "spot",
+		}))
+		g.Expect(podSpecs["staging"].Tolerations).To(g.HaveLen(1))
+
+		g.Expect(podSpecs["gpu"].NodeSelector).To(g.BeEmpty())
+		g.Expect(podSpecs["gpu"].Tolerations).To(g.ConsistOf(g.HaveField("Key", "nvidia.com/gpu")))
+		g.Expect(podSpecs["gpu"].Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms).To(g.HaveLen(1))
+
+		g.Expect(podSpecs["batch"].NodeSelector).To(g.Equal(map[string]string{
+			"incognia.com/pool": "batch",
+		}))
+		g.Expect(podSpecs["batch"].Tolerations).To(g.ConsistOf(g.HaveField("Value", "batch")))
+	})
+
+	ginkgo.It("fails with unknown classes", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NodePlacement
+metadata:
+  name: node-placement
+spec:
+  defaultClass: arm
+`), strings.NewReader(resources), &out)).To(g.MatchError("Deployment production: unknown node class arm"))
+	})
+})
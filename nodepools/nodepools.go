@@ -0,0 +1,369 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	environmentLabel = "incognia.com/environment"
+
+	nodePoolKind     = "NodePool"
+	ec2NodeClassKind = "EC2NodeClass"
+	defaultAMIAlias  = "al2023@latest"
+	discoveryTag     = "karpenter.sh/discovery"
+
+	instanceFamilyLabel = "karpenter.k8s.aws/instance-family"
+	capacityTypeLabel   = "karpenter.sh/capacity-type"
+)
+
+var (
+	karpenterGroupVersion = schema.GroupVersion{
+		Group:   "karpenter.sh",
+		Version: "v1",
+	}
+
+	karpenterAWSGroupVersion = schema.GroupVersion{
+		Group:   "karpenter.k8s.aws",
+		Version: "v1",
+	}
+
+	capacityTypes = []string{
+		"on-demand",
+		"spot",
+	}
+)
+
+type NodePools struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Capacity     `json:",inline"`
+	Environment  string              `json:"environment,omitempty"`
+	Environments map[string]Capacity `json:"environments,omitempty"`
+}
+
+type Capacity struct {
+	InstanceFamilies []string            `json:"instanceFamilies,omitempty"`
+	CapacityTypes    []string            `json:"capacityTypes,omitempty"`
+	Architectures    []string            `json:"architectures,omitempty"`
+	Labels           map[string]string   `json:"labels,omitempty"`
+	Taints           []corev1.Taint      `json:"taints,omitempty"`
+	Limits           corev1.ResourceList `json:"limits,omitempty"`
+	Role             string              `json:"role,omitempty"`
+	AMIAlias         string              `json:"amiAlias,omitempty"`
+	ClusterName      string              `json:"clusterName,omitempty"`
+}
+
+// The types below mirror the subset of the Karpenter API written by this
+// plugin, which avoids depending on the whole Karpenter module.
+
+type nodePool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              nodePoolSpec `json:"spec"`
+}
+
+type nodePoolSpec struct {
+	Template nodeClaimTemplate   `json:"template"`
+	Limits   corev1.ResourceList `json:"limits,omitempty"`
+}
+
+type nodeClaimTemplate struct {
+	Metadata nodeClaimMetadata `json:"metadata,omitempty"`
+	Spec     nodeClaimSpec     `json:"spec"`
+}
+
+type nodeClaimMetadata struct {
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+type nodeClaimSpec struct {
+	NodeClassRef nodeClassRef                     `json:"nodeClassRef"`
+	Requirements []corev1.NodeSelectorRequirement `json:"requirements,omitempty"`
+	Taints       []corev1.Taint                   `json:"taints,omitempty"`
+}
+
+type nodeClassRef struct {
+	Group string `json:"group"`
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+}
+
+type ec2NodeClass struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ec2NodeClassSpec `json:"spec"`
+}
+
+type ec2NodeClassSpec struct {
+	Role                       string         `json:"role"`
+	AMISelectorTerms           []selectorTerm `json:"amiSelectorTerms"`
+	SubnetSelectorTerms        []selectorTerm `json:"subnetSelectorTerms"`
+	SecurityGroupSelectorTerms []selectorTerm `json:"securityGroupSelectorTerms"`
+}
+
+type selectorTerm struct {
+	Alias string            `json:"alias,omitempty"`
+	Tags  map[string]string `json:"tags,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var nodePools NodePools
+	if err := pluginconfig.Unmarshal(data, &nodePools); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&nodePools)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(nodePools *NodePools) ([][]byte, error) {
+	spec := &nodePools.Spec
+
+	environment := spec.Environment
+	if environment == "" {
+		environment = nodePools.Labels[environmentLabel]
+	}
+
+	capacity := spec.Capacity
+	if environment != "" && spec.Environments != nil {
+		defaults, exists := spec.Environments[environment]
+		if !exists {
+			return nil, fmt.Errorf("environment %s has no capacity", environment)
+		}
+		capacity = mergeCapacity(defaults, spec.Capacity)
+	}
+
+	if err := validateCapacity(&capacity); err != nil {
+		return nil, err
+	}
+
+	amiAlias := capacity.AMIAlias
+	if amiAlias == "" {
+		amiAlias = defaultAMIAlias
+	}
+
+	discoveryTags := map[string]string{
+		discoveryTag: capacity.ClusterName,
+	}
+
+	objectMeta := *nodePools.ObjectMeta.DeepCopy()
+	objectMeta.Namespace = ""
+
+	nodeClass, err := yaml.Marshal(ec2NodeClass{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: karpenterAWSGroupVersion.String(),
+			Kind:       ec2NodeClassKind,
+		},
+		ObjectMeta: objectMeta,
+		Spec: ec2NodeClassSpec{
+			Role: capacity.Role,
+			AMISelectorTerms: []selectorTerm{
+				{
+					Alias: amiAlias,
+				},
+			},
+			SubnetSelectorTerms: []selectorTerm{
+				{
+					Tags: discoveryTags,
+				},
+			},
+			SecurityGroupSelectorTerms: []selectorTerm{
+				{
+					Tags: discoveryTags,
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := yaml.Marshal(nodePool{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: karpenterGroupVersion.String(),
+			Kind:       nodePoolKind,
+		},
+		ObjectMeta: objectMeta,
+		Spec: nodePoolSpec{
+			Template: nodeClaimTemplate{
+				Metadata: nodeClaimMetadata{
+					Labels: capacity.Labels,
+				},
+				Spec: nodeClaimSpec{
+					NodeClassRef: nodeClassRef{
+						Group: karpenterAWSGroupVersion.Group,
+						Kind:  ec2NodeClassKind,
+						Name:  objectMeta.Name,
+					},
+					Requirements: makeRequirements(&capacity),
+					Taints:       capacity.Taints,
+				},
+			},
+			Limits: capacity.Limits,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{nodeClass, pool}, nil
+}
+
+// mergeCapacity overrides the defaults of an environment with the attributes
+// set by the capacity, merging labels and limits key by key.
+func mergeCapacity(defaults Capacity, capacity Capacity) Capacity {
+	merged := defaults
+
+	if len(capacity.InstanceFamilies) > 0 {
+		merged.InstanceFamilies = capacity.InstanceFamilies
+	}
+
+	if len(capacity.CapacityTypes) > 0 {
+		merged.CapacityTypes = capacity.CapacityTypes
+	}
+
+	if len(capacity.Architectures) > 0 {
+		merged.Architectures = capacity.Architectures
+	}
+
+	if len(capacity.Taints) > 0 {
+		merged.Taints = capacity.Taints
+	}
+
+	if capacity.Role != "" {
+		merged.Role = capacity.Role
+	}
+
+	if capacity.AMIAlias != "" {
+		merged.AMIAlias = capacity.AMIAlias
+	}
+
+	if capacity.ClusterName != "" {
+		merged.ClusterName = capacity.ClusterName
+	}
+
+	merged.Labels = make(map[string]string, len(defaults.Labels)+len(capacity.Labels))
+	for key, value := range defaults.Labels {
+		merged.Labels[key] = value
+	}
+	for key, value := range capacity.Labels {
+		merged.Labels[key] = value
+	}
+
+	merged.Limits = make(corev1.ResourceList, len(defaults.Limits)+len(capacity.Limits))
+	for name, quantity := range defaults.Limits {
+		merged.Limits[name] = quantity
+	}
+	for name, quantity := range capacity.Limits {
+		merged.Limits[name] = quantity
+	}
+
+	return merged
+}
+
+func validateCapacity(capacity *Capacity) error {
+	if capacity.Role == "" {
+		return fmt.Errorf("spec.role is empty")
+	}
+
+	if capacity.ClusterName == "" {
+		return fmt.Errorf("spec.clusterName is empty")
+	}
+
+	if len(capacity.InstanceFamilies) == 0 {
+		return fmt.Errorf("spec.instanceFamilies is empty")
+	}
+
+	for _, capacityType := range capacity.CapacityTypes {
+		if !containsString(capacityTypes, capacityType) {
+			return fmt.Errorf("capacity type %s is not supported", capacityType)
+		}
+	}
+
+	return nil
+}
+
+func makeRequirements(capacity *Capacity) []corev1.NodeSelectorRequirement {
+	values := map[string][]string{
+		instanceFamilyLabel:    capacity.InstanceFamilies,
+		capacityTypeLabel:      capacity.CapacityTypes,
+		corev1.LabelArchStable: capacity.Architectures,
+	}
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	requirements := make([]corev1.NodeSelectorRequirement, 0, len(keys))
+	for _, key := range keys {
+		if len(values[key]) == 0 {
+			continue
+		}
+
+		requirements = append(requirements, corev1.NodeSelectorRequirement{
+			Key:      key,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   values[key],
+		})
+	}
+
+	return requirements
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/nodepools"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: NodePools
+metadata:
+  name: general
+  labels:
+    incognia.com/environment: staging
+spec:
+  clusterName: my-cluster
+  instanceFamilies:
+    - m6i
+    - m7i
+  taints:
+    - key: workload
+      value: general
+      effect: NoSchedule
+  limits:
+    cpu: "100"
+  labels:
+    workload: general
+  environments:
+    production:
+      role: karpenter-production
+      capacityTypes:
+        - on-demand
+      limits:
+        memory: 1Ti
+    staging:
+      role: karpenter-staging
+      capacityTypes:
+        - spot
+      architectures:
+        - arm64
+      limits:
+        cpu: "10"
+        memory: 100Gi
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("NodePools", func() {
+	ginkgo.It("generates EC2NodeClass and NodePool with environment defaults", func() {
+		manifests := generate(config)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		g.Expect(manifests[0]["apiVersion"]).To(g.Equal("karpenter.k8s.aws/v1"))
+		g.Expect(manifests[0]["kind"]).To(g.Equal("EC2NodeClass"))
+		g.Expect(manifests[0]["metadata"]).To(g.HaveKeyWithValue("name", "general"))
+		g.Expect(manifests[0]["spec"]).To(g.Equal(map[string]interface{}{
+			"role": "karpenter-staging",
+			"amiSelectorTerms": []interface{}{
+				map[string]interface{}{"alias": "al2023@latest"},
+			},
+			"subnetSelectorTerms": []interface{}{
+				map[string]interface{}{"tags": map[string]interface{}{"karpenter.sh/discovery": "my-cluster"}},
+			},
+			"securityGroupSelectorTerms": []interface{}{
+				map[string]interface{}{"tags": map[string]interface{}{"karpenter.sh/discovery": "my-cluster"}},
+			},
+		}))
+
+		g.Expect(manifests[1]["apiVersion"]).To(g.Equal("karpenter.sh/v1"))
+		g.Expect(manifests[1]["kind"]).To(g.Equal("NodePool"))
+		g.Expect(manifests[1]["spec"]).To(g.Equal(map[string]interface{}{
+			"template": map[string]interface{}{
+				"metadata": map[string]interface{}{
+					"labels": map[string]interface{}{"workload": "general"},
+				},
+				"spec": map[string]interface{}{
+					"nodeClassRef": map[string]interface{}{
+						"group": "karpenter.k8s.aws",
+						"kind":  "EC2NodeClass",
+						"name":  "general",
+					},
+					"requirements": []interface{}{
+						map[string]interface{}{
+							"key":      "karpenter.k8s.aws/instance-family",
+							"operator": "In",
+							"values":   []interface{}{"m6i", "m7i"},
+						},
+						map[string]interface{}{
+							"key":      "karpenter.sh/capacity-type",
+							"operator": "In",
+							"values":   []interface{}{"spot"},
+						},
+						map[string]interface{}{
+							"key":      "kubernetes.io/arch",
+							"operator": "In",
+							"values":   []interface{}{"arm64"},
+						},
+					},
+					"taints": []interface{}{
+						map[string]interface{}{
+							"key":    "workload",
+							"value":  "general",
+							"effect": "NoSchedule",
+						},
+					},
+				},
+			},
+			"limits": map[string]interface{}{
+				"cpu":    "100",
+				"memory": "100Gi",
+			},
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NodePools
+metadata:
+  name: general
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without role", "  clusterName: my-cluster\n  instanceFamilies: [m6i]\n", "spec.role is empty"),
+		ginkgo.Entry("without cluster name", "  role: karpenter\n  instanceFamilies: [m6i]\n", "spec.clusterName is empty"),
+		ginkgo.Entry("without instance families", "  role: karpenter\n  clusterName: my-cluster\n", "spec.instanceFamilies is empty"),
+		ginkgo.Entry("with unsupported capacity type", "  role: karpenter\n  clusterName: my-cluster\n  instanceFamilies: [m6i]\n  capacityTypes: [reserved]\n", "capacity type reserved is not supported"),
+		ginkgo.Entry("with unknown environment", "  environment: qa\n  environments:\n    production: {}\n", "environment qa has no capacity"),
+	)
+})
+
+func generate(config string) []map[string]interface{} {
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests([]byte(config), &out)).To(g.Succeed())
+
+	var manifests []map[string]interface{}
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var m map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &m)).To(g.Succeed())
+		manifests = append(manifests, m)
+	}
+
+	return manifests
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	configMapName          = "argocd-notifications-cm"
+	defaultSlackTokenKey   = "slack-token"
+	secretReferencePrefix  = "$"
+	slackServiceKey        = "service.slack"
+	templateKeyPrefix      = "template."
+	triggerKeyPrefix       = "trigger."
+	subscriptionsKey       = "subscriptions"
+	defaultTriggersKey     = "defaultTriggers"
+	slackRecipientPrefix   = "slack:"
+	recipientTypeSeparator = ":"
+)
+
+type NotificationsCatalog struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Catalog         string         `json:"catalog,omitempty"`
+	Slack           *Slack         `json:"slack,omitempty"`
+	Subscriptions   []Subscription `json:"subscriptions,omitempty"`
+	DefaultTriggers []string       `json:"defaultTriggers,omitempty"`
+}
+
+type Slack struct {
+	TokenKey string `json:"tokenKey,omitempty"`
+	Username string `json:"username,omitempty"`
+	Icon     string `json:"icon,omitempty"`
+}
+
+type Subscription struct {
+	Recipients []string `json:"recipients,omitempty"`
+	Triggers   []string `json:"triggers,omitempty"`
+}
+
+type Catalog struct {
+	Templates map[string]map[string]interface{} `json:"templates,omitempty"`
+	Triggers  map[string][]Condition            `json:"triggers,omitempty"`
+}
+
+type Condition struct {
+	Description string   `json:"description,omitempty"`
+	When        string   `json:"when,omitempty"`
+	Send        []string `json:"send,omitempty"`
+	OncePer     string   `json:"oncePer,omitempty"`
+}
+
+// The type below mirrors the Slack service settings of Argo CD Notifications,
+// whose token references a key of argocd-notifications-secret.
+
+type slackService struct {
+	Token    string `json:"token"`
+	Username string `json:"username,omitempty"`
+	Icon     string `json:"icon,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var notificationsCatalog NotificationsCatalog
+	if err := pluginconfig.Unmarshal(data, &notificationsCatalog); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&notificationsCatalog)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(notificationsCatalog *NotificationsCatalog) ([][]byte, error) {
+	spec := &notificationsCatalog.Spec
+
+	if spec.Catalog == "" {
+		return nil, fmt.Errorf("spec.catalog is empty")
+	}
+
+	catalog, err := readCatalog(spec.Catalog)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make(map[string]string)
+
+	for name, template := range catalog.Templates {
+		b, err := yaml.Marshal(template)
+		if err != nil {
+			return nil, err
+		}
+		data[templateKeyPrefix+name] = string(b)
+	}
+
+	for _, name := range sortedKeys(catalog.Triggers) {
+		conditions := catalog.Triggers[name]
+		if len(conditions) == 0 {
+			return nil, fmt.Errorf("trigger %s has no conditions", name)
+		}
+
+		for _, condition := range conditions {
+			if condition.When == "" {
+				return nil, fmt.Errorf("trigger %s has a condition without when", name)
+			}
+
+			if len(condition.Send) == 0 {
+				return nil, fmt.Errorf("trigger %s has a condition without templates to send", name)
+			}
+
+			for _, template := range condition.Send {
+				if _, exists := catalog.Templates[template]; !exists {
+					return nil, fmt.Errorf("trigger %s sends unknown template %s", name, template)
+				}
+			}
+		}
+
+		b, err := yaml.Marshal(conditions)
+		if err != nil {
+			return nil, err
+		}
+		data[triggerKeyPrefix+name] = string(b)
+	}
+
+	usesSlack := false
+	for i, subscription := range spec.Subscriptions {
+		if len(subscription.Recipients) == 0 {
+			return nil, fmt.Errorf("spec.subscriptions[%d] has no recipients", i)
+		}
+
+		for _, recipient := range subscription.Recipients {
+			if !strings.Contains(recipient, recipientTypeSeparator) {
+				return nil, fmt.Errorf("spec.subscriptions[%d] has recipient %q without service", i, recipient)
+			}
+
+			if strings.HasPrefix(recipient, slackRecipientPrefix) {
+				usesSlack = true
+			}
+		}
+
+		if err := validateTriggers(catalog, subscription.Triggers); err != nil {
+			return nil, fmt.Errorf("spec.subscriptions[%d]: %w", i, err)
+		}
+	}
+
+	if len(spec.Subscriptions) > 0 {
+		b, err := yaml.Marshal(spec.Subscriptions)
+		if err != nil {
+			return nil, err
+		}
+		data[subscriptionsKey] = string(b)
+	}
+
+	if err := validateTriggers(catalog, spec.DefaultTriggers); err != nil {
+		return nil, fmt.Errorf("spec.defaultTriggers: %w", err)
+	}
+
+	if len(spec.DefaultTriggers) > 0 {
+		b, err := yaml.Marshal(spec.DefaultTriggers)
+		if err != nil {
+			return nil, err
+		}
+		data[defaultTriggersKey] = string(b)
+	}
+
+	if spec.Slack == nil && usesSlack {
+		return nil, fmt.Errorf("spec.slack is required by slack recipients")
+	}
+
+	if spec.Slack != nil {
+		tokenKey := spec.Slack.TokenKey
+		if tokenKey == "" {
+			tokenKey = defaultSlackTokenKey
+		}
+
+		b, err := yaml.Marshal(slackService{
+			Token:    secretReferencePrefix + tokenKey,
+			Username: spec.Slack.Username,
+			Icon:     spec.Slack.Icon,
+		})
+		if err != nil {
+			return nil, err
+		}
+		data[slackServiceKey] = string(b)
+	}
+
+	objectMeta := *notificationsCatalog.ObjectMeta.DeepCopy()
+	objectMeta.Name = configMapName
+
+	b, err := yaml.Marshal(corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ConfigMap{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Data:       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{b}, nil
+}
+
+func validateTriggers(catalog *Catalog, triggers []string) error {
+	for _, trigger := range triggers {
+		if _, exists := catalog.Triggers[trigger]; !exists {
+			return fmt.Errorf("unknown trigger %s", trigger)
+		}
+	}
+
+	return nil
+}
+
+func readCatalog(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var catalog Catalog
+	if err := yaml.Unmarshal(data, &catalog); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return &catalog, nil
+}
+
+func sortedKeys(m map[string][]Condition) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
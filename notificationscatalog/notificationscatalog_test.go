@@ -0,0 +1,134 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/notificationscatalog"
+)
+
+const (
+	catalog = `
+templates:
+  app-sync-failed:
+    message: Application {{.app.metadata.name}} failed to sync.
+    slack:
+      attachments: |
+        [{"title": "{{.app.metadata.name}}", "color": "#E96D76"}]
+  app-health-degraded:
+    message: Application {{.app.metadata.name}} is degraded.
+triggers:
+  on-sync-failed:
+    - description: Application syncing has failed
+      when: app.status.operationState.phase in ['Error', 'Failed']
+      send: [app-sync-failed]
+  on-health-degraded:
+    - when: app.status.health.status == 'Degraded'
+      send: [app-health-degraded]
+      oncePer: app.status.sync.revision
+`
+)
+
+var _ = ginkgo.Describe("NotificationsCatalog", func() {
+	var catalogPath string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "notificationscatalog")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		catalogPath = filepath.Join(dir, "catalog.yaml")
+		g.Expect(ioutil.WriteFile(catalogPath, []byte(catalog), 0644)).To(g.Succeed())
+	})
+
+	generate := func(spec string) (*corev1.ConfigMap, error) {
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NotificationsCatalog
+metadata:
+  name: notifications
+  namespace: argocd
+spec:
+  catalog: `+catalogPath+`
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		var configMap corev1.ConfigMap
+		g.Expect(yaml.Unmarshal(out.Bytes(), &configMap)).To(g.Succeed())
+
+		return &configMap, nil
+	}
+
+	ginkgo.It("generates argocd-notifications-cm", func() {
+		configMap, err := generate(`
+  slack:
+    username: argocd
+  subscriptions:
+    - recipients: [slack:deployments]
+      triggers: [on-sync-failed, on-health-degraded]
+  defaultTriggers: [on-sync-failed]
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		g.Expect(configMap.APIVersion).To(g.Equal("v1"))
+		g.Expect(configMap.Kind).To(g.Equal("ConfigMap"))
+		g.Expect(configMap.Name).To(g.Equal("argocd-notifications-cm"))
+		g.Expect(configMap.Namespace).To(g.Equal("argocd"))
+		g.Expect(configMap.Data).To(g.Equal(map[string]string{
+			"service.slack": "token: $slack-token\nusername: argocd\n",
+			"template.app-sync-failed": `message: Application {{.app.metadata.name}} failed to sync.
+slack:
+  attachments: |
+    [{"title": "{{.app.metadata.name}}", "color": "#E96D76"}]
+`,
+			"template.app-health-degraded": "message: Application {{.app.metadata.name}} is degraded.\n",
+			"trigger.on-sync-failed": `- description: Application syncing has failed
+  send:
+  - app-sync-failed
+  when: app.status.operationState.phase in ['Error', 'Failed']
+`,
+			"trigger.on-health-degraded": `- oncePer: app.status.sync.revision
+  send:
+  - app-health-degraded
+  when: app.status.health.status == 'Degraded'
+`,
+			"subscriptions": `- recipients:
+  - slack:deployments
+  triggers:
+  - on-sync-failed
+  - on-health-degraded
+`,
+			"defaultTriggers": "- on-sync-failed\n",
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := generate(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with unknown subscribed triggers", "  subscriptions:\n    - recipients: [email:sre@incognia.com]\n      triggers: [on-deployed]\n", "spec.subscriptions[0]: unknown trigger on-deployed"),
+		ginkgo.Entry("with unknown default triggers", "  defaultTriggers: [on-deployed]\n", "spec.defaultTriggers: unknown trigger on-deployed"),
+		ginkgo.Entry("with recipients without service", "  subscriptions:\n    - recipients: [deployments]\n", `spec.subscriptions[0] has recipient "deployments" without service`),
+		ginkgo.Entry("with slack recipients without slack", "  subscriptions:\n    - recipients: [slack:deployments]\n", "spec.slack is required by slack recipients"),
+	)
+
+	ginkgo.It("fails on triggers sending unknown templates", func() {
+		g.Expect(ioutil.WriteFile(catalogPath, []byte(`
+triggers:
+  on-deployed:
+    - when: "true"
+      send: [app-deployed]
+`), 0644)).To(g.Succeed())
+
+		_, err := generate("")
+		g.Expect(err).To(g.MatchError("trigger on-deployed sends unknown template app-deployed"))
+	})
+})
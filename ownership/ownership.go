@@ -0,0 +1,256 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	pluginName    = "ownership"
+	ownershipRule = "ownership"
+
+	skipAnnotation = "incognia.com/skip-ownership"
+
+	defaultTeamLabel     = "incognia.com/team"
+	ownerAnnotation      = "incognia.com/owner"
+	escalationAnnotation = "incognia.com/escalation"
+	repositoryAnnotation = "incognia.com/repository"
+)
+
+var (
+	namespaceKind = reflect.TypeOf(corev1.Namespace{}).Name()
+
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	requiredAnnotations = []string{
+		ownerAnnotation,
+		escalationAnnotation,
+		repositoryAnnotation,
+	}
+)
+
+type Ownership struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Team      string          `json:"team,omitempty"`
+	TeamLabel string          `json:"teamLabel,omitempty"`
+	Registry  string          `json:"registry,omitempty"`
+	Teams     map[string]Team `json:"teams,omitempty"`
+	Kinds     []string        `json:"kinds,omitempty"`
+}
+
+type Team struct {
+	Owner      string `json:"owner,omitempty"`
+	Escalation string `json:"escalation,omitempty"`
+	Repository string `json:"repository,omitempty"`
+}
+
+func (t Team) Annotations() map[string]string {
+	return map[string]string{
+		ownerAnnotation:      t.Owner,
+		escalationAnnotation: t.Escalation,
+		repositoryAnnotation: t.Repository,
+	}
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var ownership Ownership
+	if err := pluginconfig.Unmarshal(data, &ownership); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&ownership, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(ownership *Ownership, nodes []*kyaml.RNode) error {
+	spec := &ownership.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	teamLabel := spec.TeamLabel
+	if teamLabel == "" {
+		teamLabel = defaultTeamLabel
+	}
+
+	teams, err := readTeams(spec)
+	if err != nil {
+		return err
+	}
+
+	namespaceTeams := make(map[string]string)
+	for _, node := range nodes {
+		if node.GetKind() != namespaceKind {
+			continue
+		}
+
+		if team, exists := node.GetLabels()[teamLabel]; exists {
+			namespaceTeams[node.GetName()] = team
+		}
+	}
+
+	var found []findings.Finding
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		teamName := node.GetLabels()[teamLabel]
+		if teamName == "" {
+			teamName = namespaceTeams[node.GetNamespace()]
+		}
+		if teamName == "" {
+			teamName = spec.Team
+		}
+
+		if team, exists := teams[teamName]; exists {
+			if err := setAnnotations(node, team.Annotations()); err != nil {
+				return err
+			}
+		}
+
+		var missing []string
+		current := node.GetAnnotations()
+		for _, annotation := range requiredAnnotations {
+			if current[annotation] == "" {
+				missing = append(missing, annotation)
+			}
+		}
+
+		if len(missing) > 0 {
+			found = append(found, findings.Finding{
+				Path:     findings.SourcePath(node.GetAnnotations()),
+				Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+				Rule:     ownershipRule,
+				Message:  "missing " + strings.Join(missing, ", "),
+			})
+		}
+	}
+
+	if err := findings.WriteReportFromEnv(pluginName, ownership.Name, found); err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("workloads without ownership:\n%s", findings.Lines(found))
+	}
+
+	return nil
+}
+
+// readTeams loads the registry file, if any, and lets the teams defined
+// inline take precedence over it.
+func readTeams(spec *Spec) (map[string]Team, error) {
+	teams := make(map[string]Team)
+
+	if spec.Registry != "" {
+		data, err := ioutil.ReadFile(spec.Registry)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := yaml.Unmarshal(data, &teams); err != nil {
+			return nil, fmt.Errorf("%s: %w", spec.Registry, err)
+		}
+	}
+
+	for name, team := range spec.Teams {
+		teams[name] = team
+	}
+
+	return teams, nil
+}
+
+func setAnnotations(node *kyaml.RNode, annotations map[string]string) error {
+	current := node.GetAnnotations()
+
+	for _, key := range requiredAnnotations {
+		if annotations[key] == "" || current[key] != "" {
+			continue
+		}
+
+		if err := node.PipeE(kyaml.SetAnnotation(key, annotations[key])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func resourceName(node *kyaml.RNode) string {
+	if namespace := node.GetNamespace(); namespace != "" {
+		return namespace + "/" + node.GetName()
+	}
+
+	return node.GetName()
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
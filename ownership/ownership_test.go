@@ -0,0 +1,166 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/ownership"
+)
+
+const (
+	resources = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payments
+  labels:
+    incognia.com/team: payments
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: worker
+  namespace: payments
+  annotations:
+    incognia.com/escalation: "#payments-workers"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-workload
+  namespace: other
+`
+
+	registry = `
+payments:
+  owner: payments-team
+  escalation: pagerduty:PAYMENTS
+  repository: https://github.com/inloco/payments
+  costCenter: cc-1001
+`
+)
+
+var _ = ginkgo.Describe("Ownership", func() {
+	var registryPath string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "ownership")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		registryPath = filepath.Join(dir, "teams.yaml")
+		g.Expect(ioutil.WriteFile(registryPath, []byte(registry), 0644)).To(g.Succeed())
+	})
+
+	transform := func(spec string, resources string) (map[string]*kyaml.RNode, error) {
+		var out bytes.Buffer
+		if err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Ownership
+metadata:
+  name: ownership
+spec:
+  registry: `+registryPath+`
+`+spec), strings.NewReader(resources), &out); err != nil {
+			return nil, err
+		}
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		byName := make(map[string]*kyaml.RNode)
+		for _, node := range nodes {
+			byName[node.GetName()] = node
+		}
+
+		return byName, nil
+	}
+
+	ginkgo.It("fills annotations from the team registry", func() {
+		nodes, err := transform("", resources)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		g.Expect(nodes["api"].GetAnnotations()).To(g.Equal(map[string]string{
+			"incognia.com/owner":      "payments-team",
+			"incognia.com/escalation": "pagerduty:PAYMENTS",
+			"incognia.com/repository": "https://github.com/inloco/payments",
+		}))
+		g.Expect(nodes["worker"].GetAnnotations()).To(g.HaveKeyWithValue("incognia.com/escalation", "#payments-workers"))
+		g.Expect(nodes["not-a-workload"].GetAnnotations()).To(g.BeEmpty())
+	})
+
+	ginkgo.It("fails when ownership is not derivable", func() {
+		_, err := transform("", resources+`
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: other
+  annotations:
+    incognia.com/owner: data-team
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: exempted
+  annotations:
+    incognia.com/skip-ownership: "true"
+`)
+		g.Expect(err).To(g.MatchError(`workloads without ownership:
+CronJob other/report: missing incognia.com/escalation, incognia.com/repository`))
+	})
+
+	ginkgo.It("reports workloads without ownership", func() {
+		dir, err := ioutil.TempDir("", "ownership")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		g.Expect(os.Setenv("FINDINGS_DIR", dir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_DIR")
+
+		_, err = transform("", `
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: other
+`)
+		g.Expect(err).To(g.HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "ownership-ownership.sarif"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring(`"ruleId": "ownership"`))
+		g.Expect(string(data)).To(g.ContainSubstring(`"name": "CronJob other/report"`))
+	})
+
+	ginkgo.It("falls back to the team of the spec", func() {
+		nodes, err := transform("  team: data\n  teams:\n    data:\n      owner: data-team\n      escalation: \"#data\"\n      repository: https://github.com/inloco/data\n", resources+`
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: other
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(nodes["report"].GetAnnotations()).To(g.HaveKeyWithValue("incognia.com/owner", "data-team"))
+		g.Expect(nodes["api"].GetAnnotations()).To(g.HaveKeyWithValue("incognia.com/owner", "payments-team"))
+	})
+})
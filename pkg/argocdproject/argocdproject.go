@@ -0,0 +1,1953 @@
+// Package argocdproject generates the Argo CD AppProject and Applications of
+// ArgoCDProject files, for the Kustomize plugin and the tools embedding it.
+package argocdproject
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/argoproj/argo-cd/v2/pkg/apis/application"
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+const (
+	yamlSeparator   = "---\n"
+	yamlStatusField = "status"
+	yamlSpecField   = "spec"
+	yamlMetaField   = "metadata"
+
+	permitOnlyProjectScopedClustersField = "permitOnlyProjectScopedClusters"
+	destinationServiceAccountsField      = "destinationServiceAccounts"
+	sourceNamespacesField                = "sourceNamespaces"
+
+	projectPlaceholder     = "{{project}}"
+	applicationPlaceholder = "{{application}}"
+	namespacePlaceholder   = "{{namespace}}"
+	environmentPlaceholder = "{{environment}}"
+	rolePlaceholder        = "{{role}}"
+
+	// The cluster Argo CD runs in is known by this name and server without
+	// being in the cluster registry.
+	inClusterName   = "in-cluster"
+	inClusterServer = "https://kubernetes.default.svc"
+
+	breakGlassRole             = "break-glass"
+	breakGlassCleanupSuffix    = "-break-glass-cleanup"
+	defaultCleanupImage        = "bitnami/kubectl:1.23"
+	defaultArgoCDNamespace     = "argocd"
+	appProjectsResource        = "appprojects"
+	breakGlassCleanupContainer = "cleanup"
+
+	negationPrefix = "!"
+	anyPattern     = "*"
+	globCharacters = "*?["
+
+	// Names interpolated into policies cannot have the separators of their
+	// fields, lines, subjects and objects, nor globs, which would add clauses
+	// or widen the scope of the policies.
+	policyMetaCharacters = ",\"\r\n :/" + globCharacters
+
+	// Platform projects manage everything the other capabilities cover.
+	platformCapability = "platform"
+
+	bannerAnnotation         = "incognia.com/banner"
+	bannerSeverityAnnotation = "incognia.com/banner-severity"
+	environmentAnnotation    = "incognia.com/environment"
+	projectNameAnnotation    = "incognia.com/project-name"
+	productionEnvironment    = "production"
+	environmentBanner        = "environment: %s"
+	changeFreezeBanner       = " — change freeze applies"
+
+	// Sanitized project names fit in labels, as other resources are named
+	// after them.
+	maxProjectNameLength  = validation.DNS1123LabelMaxLength
+	projectNameHashLength = 8
+)
+
+var (
+	invalidNameCharacters = regexp.MustCompile(`[^a-z0-9-]+`)
+
+	clusterCapabilities = map[string][]metav1.GroupKind{
+		"namespaces": {
+			{Group: "", Kind: "Namespace"},
+		},
+		"crds": {
+			{Group: "apiextensions.k8s.io", Kind: "CustomResourceDefinition"},
+		},
+		"rbac": {
+			{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+			{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"},
+		},
+		"admission": {
+			{Group: "admissionregistration.k8s.io", Kind: "MutatingWebhookConfiguration"},
+			{Group: "admissionregistration.k8s.io", Kind: "ValidatingWebhookConfiguration"},
+		},
+		"storage": {
+			{Group: "storage.k8s.io", Kind: "StorageClass"},
+			{Group: "", Kind: "PersistentVolume"},
+		},
+		"scheduling": {
+			{Group: "scheduling.k8s.io", Kind: "PriorityClass"},
+		},
+	}
+)
+
+type accessLevel int
+
+const (
+	ReadOnly accessLevel = iota
+	ReadSync
+	OverrideParameters
+)
+
+// PolicyFunc returns the policies of the role of an access level in an
+// AppProject.
+type PolicyFunc func(appProjectName string) []string
+
+type accessLevelDefinition struct {
+	name     string
+	policies PolicyFunc
+	groups   func(accessControl *AppProjectAccessControl) []string
+	// Unlike the read roles, the other tiers are only created on demand.
+	onDemand bool
+}
+
+var (
+	// accessLevels are indexed by accessLevel, where the ones after the
+	// built-in levels are added by RegisterAccessLevel.
+	accessLevels = []accessLevelDefinition{
+		{
+			name:     "read-only",
+			policies: readOnlyPolicies,
+			groups: func(accessControl *AppProjectAccessControl) []string {
+				return accessControl.ReadOnly
+			},
+		},
+		{
+			name:     "read-sync",
+			policies: readSyncPolicies,
+			groups: func(accessControl *AppProjectAccessControl) []string {
+				return accessControl.ReadSync
+			},
+		},
+		{
+			name:     "override-parameters",
+			policies: overrideParametersPolicies,
+			groups: func(accessControl *AppProjectAccessControl) []string {
+				return accessControl.OverrideParameters
+			},
+			onDemand: true,
+		},
+	}
+	builtinAccessLevels = len(accessLevels)
+)
+
+// RegisterAccessLevel adds an access level to the projects generated
+// afterwards, whose role is granted to the groups of its name in
+// spec.accessControl.groups and is only created when there are any. It panics
+// when the name is not a valid role name or is already taken, so it is meant
+// to be called by the init functions of embedding tools.
+func RegisterAccessLevel(name string, policies PolicyFunc) {
+	if !roleNameExpression.MatchString(name) || name == breakGlassRole {
+		panic(fmt.Sprintf("invalid access level %s", name))
+	}
+
+	if _, err := parseAccessLevel(name); err == nil {
+		panic(fmt.Sprintf("access level %s is already registered", name))
+	}
+
+	accessLevels = append(accessLevels, accessLevelDefinition{
+		name:     name,
+		policies: policies,
+		groups: func(accessControl *AppProjectAccessControl) []string {
+			return accessControl.Groups[name]
+		},
+		onDemand: true,
+	})
+}
+
+func (a accessLevel) definition() *accessLevelDefinition {
+	if a < 0 || int(a) >= len(accessLevels) {
+		panic(fmt.Sprintf("unknown access level %d", a))
+	}
+
+	return &accessLevels[a]
+}
+
+func (a accessLevel) String() string {
+	return a.definition().name
+}
+
+func (a accessLevel) Policies(appProjectName string) []string {
+	return a.definition().policies(appProjectName)
+}
+
+func readOnlyPolicies(appProjectName string) []string {
+	return []string{
+		fmt.Sprintf("p, proj:%s:read-only, *, get, %s/*, allow", appProjectName, appProjectName),
+	}
+}
+
+func readSyncPolicies(appProjectName string) []string {
+	return []string{
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/apps/Deployment/restart, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/argoproj.io/Rollout/abort, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/argoproj.io/Rollout/promote-full, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/argoproj.io/Rollout/restart, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/argoproj.io/Rollout/resume, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, action/argoproj.io/Rollout/retry, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:read-sync, applications, sync, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("g, proj:%s:read-sync, proj:%s:read-only", appProjectName, appProjectName),
+	}
+}
+
+func overrideParametersPolicies(appProjectName string) []string {
+	return []string{
+		fmt.Sprintf("p, proj:%s:override-parameters, applications, override, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("p, proj:%s:override-parameters, applications, action/*, %s/*, allow", appProjectName, appProjectName),
+		fmt.Sprintf("g, proj:%s:override-parameters, proj:%s:read-sync", appProjectName, appProjectName),
+	}
+}
+
+func parseAccessLevel(name string) (accessLevel, error) {
+	for i := range accessLevels {
+		if accessLevels[i].name == name {
+			return accessLevel(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("unknown access level %s", name)
+}
+
+// ArgoCDProject is a project file, of the incognia.com/v1alpha1 apiVersion.
+type ArgoCDProject struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ProjectSpec `json:"spec,omitempty"`
+}
+
+// ProjectSpec declares the AppProject and Applications of a project, whose
+// fields are documented in the README of the plugin.
+type ProjectSpec struct {
+	AccessControl                   AppProjectAccessControl               `json:"accessControl,omitempty"`
+	Environment                     string                                `json:"environment,omitempty"`
+	Environments                    []string                              `json:"environments,omitempty"`
+	Info                            []argov1alpha1.Info                   `json:"info,omitempty"`
+	DenyDestinations                []argov1alpha1.ApplicationDestination `json:"denyDestinations,omitempty"`
+	ClusterRegistry                 string                                `json:"clusterRegistry,omitempty"`
+	Banner                          *Banner                               `json:"banner,omitempty"`
+	ChangeFreeze                    bool                                  `json:"changeFreeze,omitempty"`
+	Namespace                       string                                `json:"namespace,omitempty"`
+	ApplicationNamespace            string                                `json:"applicationNamespace,omitempty"`
+	PermitOnlyProjectScopedClusters bool                                  `json:"permitOnlyProjectScopedClusters,omitempty"`
+	DestinationServiceAccounts      []DestinationServiceAccount           `json:"destinationServiceAccounts,omitempty"`
+	AppProject                      argov1alpha1.AppProject               `json:"appProjectTemplate,omitempty"`
+	ApplicationTemplates            []argov1alpha1.Application            `json:"applicationTemplates,omitempty"`
+}
+
+// DestinationServiceAccount mirrors the ApplicationDestinationServiceAccount
+// of Argo CD 2.13, which syncs impersonating the service account.
+type DestinationServiceAccount struct {
+	Server                string `json:"server,omitempty"`
+	Namespace             string `json:"namespace,omitempty"`
+	DefaultServiceAccount string `json:"defaultServiceAccount"`
+}
+
+// Options customize the generation of manifests, where the zero value
+// generates them as the Kustomize plugin does.
+type Options struct {
+	// Environment overrides spec.environment.
+	Environment string
+	// Namespace overrides spec.namespace.
+	Namespace string
+	// Defaults are the fields of spec used where the project file leaves them
+	// unset.
+	Defaults *ProjectSpec
+	// Validation is how strictly project files are decoded.
+	Validation ValidationLevel
+	// Overrides set fields of the project file, as path=value pairs whose
+	// paths index lists with [n].
+	Overrides []string
+	// Output selects the resources written.
+	Output Output
+	// Dir is the directory the relative paths of the project file, such as
+	// spec.clusterRegistry, are read from instead of the working directory.
+	Dir string
+	// RejectPaths rejects the project files naming files to read, such as
+	// spec.clusterRegistry, for callers that cannot read the files of the host.
+	RejectPaths bool
+	// TerraformOutputs replace the {{terraform.name}} placeholders of the
+	// strings of the project file.
+	TerraformOutputs terraform.Outputs
+	// OmitEmpty drops the null fields and the empty metadata of the resources
+	// written, such as the creationTimestamp: null of every one, which diff
+	// against the resources of clusters.
+	OmitEmpty bool
+	// EmptyTemplates is what is done with projects without application
+	// templates, whose AppProject would have no destinations.
+	EmptyTemplates EmptyTemplatesPolicy
+	// EnvironmentPattern is the expression environments must match, as they
+	// are part of git refs and paths, which DefaultEnvironmentPattern is when
+	// empty.
+	EnvironmentPattern string
+	// ReservedEnvironments are names environments cannot have.
+	ReservedEnvironments []string
+	// RequireGroupsEnvironments are the environments whose projects must
+	// grant read-only and read-sync access to some group.
+	RequireGroupsEnvironments []string
+	// MaxApplications is how many applications a project may generate, which
+	// is unlimited when zero.
+	MaxApplications int
+	// MaxOutputSize is how many bytes the written resources may have, which is
+	// unlimited when zero.
+	MaxOutputSize int
+}
+
+// MaxApplicationsEnv and MaxOutputSizeEnv are the defaults of the quotas of the
+// plugin, as Kustomize runs it without flags.
+const (
+	MaxApplicationsEnv = "ARGOCDPROJECT_MAX_APPLICATIONS"
+	MaxOutputSizeEnv   = "ARGOCDPROJECT_MAX_OUTPUT_SIZE"
+)
+
+// DefaultEnvironmentPattern matches the environments that are valid parts of
+// git refs and paths. Environments with slashes, whitespace or .. are rejected
+// whatever the EnvironmentPattern.
+const DefaultEnvironmentPattern = `^[a-zA-Z0-9]([-_.a-zA-Z0-9]*[a-zA-Z0-9])?$`
+
+// ValidationLevel is how strictly project files are decoded, where lenient
+// files may have unknown fields, as Kustomize has always accepted.
+type ValidationLevel string
+
+const (
+	ValidationLenient ValidationLevel = "lenient"
+	ValidationStrict  ValidationLevel = "strict"
+)
+
+func (v ValidationLevel) Validate() error {
+	switch v {
+	case "", ValidationLenient, ValidationStrict:
+		return nil
+	default:
+		return fmt.Errorf("unknown validation level %s", v)
+	}
+}
+
+// Output selects the resources written by GenerateManifestsWithOptions.
+type Output string
+
+const (
+	OutputAll          Output = "all"
+	OutputAppProject   Output = "appproject"
+	OutputApplications Output = "applications"
+)
+
+func (o Output) Validate() error {
+	switch o {
+	case "", OutputAll, OutputAppProject, OutputApplications:
+		return nil
+	default:
+		return fmt.Errorf("unknown output %s", o)
+	}
+}
+
+// Includes reports whether resources of a kind are written to the output,
+// where the AppProject output has everything but the Applications, such as
+// the cleanup of break-glass roles.
+func (o Output) Includes(kind string) bool {
+	switch o {
+	case OutputAppProject:
+		return kind != application.ApplicationKind
+	case OutputApplications:
+		return kind == application.ApplicationKind
+	default:
+		return true
+	}
+}
+
+// EmptyTemplatesPolicy is what is done with projects without application
+// templates nor destinations, whose AppProject Argo CD reads as denying every
+// destination.
+type EmptyTemplatesPolicy string
+
+const (
+	EmptyTemplatesAllow EmptyTemplatesPolicy = "allow"
+	EmptyTemplatesWarn  EmptyTemplatesPolicy = "warn"
+	EmptyTemplatesFail  EmptyTemplatesPolicy = "fail"
+	// EmptyTemplatesDefaults gives the AppProject the destinations of the
+	// appProjectTemplate of the defaults, failing without them.
+	EmptyTemplatesDefaults EmptyTemplatesPolicy = "defaults"
+)
+
+func (e EmptyTemplatesPolicy) Validate() error {
+	switch e {
+	case "", EmptyTemplatesAllow, EmptyTemplatesWarn, EmptyTemplatesFail, EmptyTemplatesDefaults:
+		return nil
+	default:
+		return fmt.Errorf("unknown empty templates policy %s", e)
+	}
+}
+
+// Severity is how prominently the banner of a project is shown.
+type Severity string
+
+const (
+	Info     Severity = "info"
+	Warning  Severity = "warning"
+	Critical Severity = "critical"
+)
+
+func (s Severity) Validate() error {
+	switch s {
+	case Info, Warning, Critical:
+		return nil
+	default:
+		return fmt.Errorf("unknown severity %s", s)
+	}
+}
+
+// Banner is the message shown on the project and its applications.
+type Banner struct {
+	Message  string   `json:"message,omitempty"`
+	Severity Severity `json:"severity,omitempty"`
+}
+
+// ClusterRegistry is the file of spec.clusterRegistry.
+type ClusterRegistry struct {
+	Clusters []Cluster `json:"clusters,omitempty"`
+}
+
+type Cluster struct {
+	Name   string `json:"name,omitempty"`
+	Server string `json:"server,omitempty"`
+}
+
+// AppProjectAccessControl declares the groups of each role of the AppProject.
+type AppProjectAccessControl struct {
+	Disabled            bool                `json:"disabled,omitempty"`
+	PolicyTemplates     string              `json:"policyTemplates,omitempty"`
+	ReadOnly            []string            `json:"ReadOnly,omitempty"`
+	ReadSync            []string            `json:"ReadSync,omitempty"`
+	OverrideParameters  []string            `json:"OverrideParameters,omitempty"`
+	Groups              map[string][]string `json:"groups,omitempty"`
+	BreakGlass          *BreakGlass         `json:"breakGlass,omitempty"`
+	ClusterCapabilities []string            `json:"clusterCapabilities,omitempty"`
+}
+
+// BreakGlass grants groups full access to the project until it expires.
+type BreakGlass struct {
+	Groups    []string           `json:"groups,omitempty"`
+	Reason    string             `json:"reason,omitempty"`
+	ExpiresAt *metav1.Time       `json:"expiresAt,omitempty"`
+	Cleanup   *BreakGlassCleanup `json:"cleanup,omitempty"`
+}
+
+// BreakGlassCleanup configures the CronJob removing expired break-glass roles.
+type BreakGlassCleanup struct {
+	Namespace string `json:"namespace,omitempty"`
+	Image     string `json:"image,omitempty"`
+}
+
+// GenerateManifests writes the manifests of a project file to out, separated
+// as a YAML stream.
+func GenerateManifests(data []byte, out io.Writer) error {
+	return GenerateManifestsWithOptions(data, Options{}, out)
+}
+
+// GenerateManifestsWithOptions generates the manifests of a project file after
+// applying its defaults and setting the fields of its overrides and options,
+// writing only the resources of its output.
+func GenerateManifestsWithOptions(data []byte, options Options, out io.Writer) error {
+	argocdProject, err := resolveProject(data, options, nil)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(argocdProject)
+	if err != nil {
+		return err
+	}
+
+	if err := validateCasbinPolicies(&argocdProject.Spec.AppProject); err != nil {
+		return fmt.Errorf("spec.accessControl: %w", err)
+	}
+
+	// the quotas are checked before anything is written, so a project over
+	// them generates nothing
+	var written [][]byte
+	applications, size := 0, 0
+	for _, manifest := range manifests {
+		var typeMeta metav1.TypeMeta
+		if err := yaml.Unmarshal(manifest, &typeMeta); err != nil {
+			return err
+		}
+
+		if typeMeta.Kind == application.ApplicationKind {
+			applications++
+		}
+
+		if !options.Output.Includes(typeMeta.Kind) {
+			continue
+		}
+
+		if options.OmitEmpty {
+			if manifest, err = omitEmpty(manifest); err != nil {
+				return err
+			}
+		}
+
+		written = append(written, manifest)
+		size += len(yamlSeparator) + len(manifest)
+	}
+
+	if options.MaxApplications > 0 && applications > options.MaxApplications {
+		return fmt.Errorf("project generates %d applications, more than the %d allowed", applications, options.MaxApplications)
+	}
+
+	if options.MaxOutputSize > 0 && size > options.MaxOutputSize {
+		return fmt.Errorf("project generates %d bytes, more than the %d allowed", size, options.MaxOutputSize)
+	}
+
+	for _, manifest := range written {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveProject decodes a project file after applying its defaults and
+// setting the fields of its overrides and options, validating the result.
+// Each stage of the resolution is recorded in stages, when given, so the
+// values of the project can be explained by the stage that set them.
+func resolveProject(data []byte, options Options, stages *[]stage) (*ArgoCDProject, error) {
+	if err := options.Output.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := options.Validation.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := options.EmptyTemplates.Validate(); err != nil {
+		return nil, err
+	}
+
+	if options.MaxApplications < 0 {
+		return nil, fmt.Errorf("max applications %d is negative", options.MaxApplications)
+	}
+
+	if options.MaxOutputSize < 0 {
+		return nil, fmt.Errorf("max output size %d is negative", options.MaxOutputSize)
+	}
+
+	if err := recordData(stages, fileSource, data); err != nil {
+		return nil, err
+	}
+
+	data, err := applyOverrides(data, options.Overrides)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordData(stages, setSource, data); err != nil {
+		return nil, err
+	}
+
+	data, err = applyTerraformOutputs(data, options.TerraformOutputs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordData(stages, terraformSource, data); err != nil {
+		return nil, err
+	}
+
+	argocdProject, err := decodeProject(data, options.Validation == ValidationStrict)
+	if err != nil {
+		return nil, err
+	}
+
+	if options.Validation == ValidationStrict {
+		if errs := validateTypeMeta(argocdProject); len(errs) > 0 {
+			return nil, errs[0]
+		}
+	}
+
+	if err := validateConfigType(argocdProject); err != nil {
+		return nil, err
+	}
+
+	if err := applyDefaults(argocdProject, options.Defaults); err != nil {
+		return nil, err
+	}
+
+	if err := recordProject(stages, defaultsSource, argocdProject); err != nil {
+		return nil, err
+	}
+
+	if options.Environment != "" {
+		argocdProject.Spec.Environment, argocdProject.Spec.Environments = options.Environment, nil
+	}
+
+	if err := recordProject(stages, envSource, argocdProject); err != nil {
+		return nil, err
+	}
+
+	if options.Namespace != "" {
+		argocdProject.Spec.Namespace = options.Namespace
+	}
+
+	if err := recordProject(stages, namespaceSource, argocdProject); err != nil {
+		return nil, err
+	}
+
+	if options.RejectPaths {
+		if err := rejectPaths(argocdProject); err != nil {
+			return nil, err
+		}
+	}
+
+	if options.Dir != "" {
+		if err := resolvePaths(argocdProject, options.Dir); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := recordProject(stages, dirSource, argocdProject); err != nil {
+		return nil, err
+	}
+
+	if err := applyEmptyTemplatesPolicy(argocdProject, options); err != nil {
+		return nil, err
+	}
+
+	if err := recordProject(stages, emptyTemplatesSource, argocdProject); err != nil {
+		return nil, err
+	}
+
+	if err := validateEnvironments(argocdProject, options.EnvironmentPattern, options.ReservedEnvironments); err != nil {
+		return nil, err
+	}
+
+	if err := validateRequiredGroups(argocdProject, options.RequireGroupsEnvironments); err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i := range argocdProject.Spec.ApplicationTemplates {
+		app := &argocdProject.Spec.ApplicationTemplates[i]
+		if app.Name == "" {
+			errs = append(errs, fmt.Errorf("spec.applicationTemplates[%d].metadata.name is empty", i))
+			continue
+		}
+
+		for _, err := range validateApplicationTemplate(i, app) {
+			errs = append(errs, fmt.Errorf("application %s: %w", app.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+
+	return argocdProject, nil
+}
+
+func applyEmptyTemplatesPolicy(argocdProject *ArgoCDProject, options Options) error {
+	if len(argocdProject.Spec.ApplicationTemplates) > 0 || len(argocdProject.Spec.AppProject.Spec.Destinations) > 0 {
+		return nil
+	}
+
+	switch options.EmptyTemplates {
+	case EmptyTemplatesWarn:
+		log.Printf("%s: spec.applicationTemplates is empty, so the AppProject denies every destination", argocdProject.Name)
+	case EmptyTemplatesFail:
+		return fmt.Errorf("spec.applicationTemplates is empty, so the AppProject would deny every destination")
+	case EmptyTemplatesDefaults:
+		if options.Defaults == nil || len(options.Defaults.AppProject.Spec.Destinations) == 0 {
+			return fmt.Errorf("spec.applicationTemplates is empty and the defaults have no appProjectTemplate.spec.destinations")
+		}
+		argocdProject.Spec.AppProject.Spec.Destinations = options.Defaults.AppProject.Spec.Destinations
+	}
+
+	return nil
+}
+
+func makeManifests(argocdProject *ArgoCDProject) ([][]byte, error) {
+	var manifests [][]byte
+
+	if err := expandEnvironments(argocdProject); err != nil {
+		return nil, err
+	}
+
+	if err := expandDestinations(argocdProject); err != nil {
+		return nil, err
+	}
+
+	if err := setNamespaces(argocdProject); err != nil {
+		return nil, err
+	}
+
+	banner, err := makeBanner(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+	if banner != nil {
+		setBanner(&argocdProject.Spec.AppProject.ObjectMeta, banner)
+		for i := range argocdProject.Spec.ApplicationTemplates {
+			setBanner(&argocdProject.Spec.ApplicationTemplates[i].ObjectMeta, banner)
+		}
+	}
+
+	b, err := makeAppProject(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, b)
+
+	bs, err := makeBreakGlassCleanup(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, bs...)
+
+	bs, err = makeApplications(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, bs...)
+
+	return manifests, nil
+}
+
+// makeBanner returns the banner shown on the project and its applications,
+// which defaults to the environment of the project and warns about change
+// freezes, so changes to production are deliberate.
+func makeBanner(argocdProject *ArgoCDProject) (*Banner, error) {
+	if argocdProject.Spec.Banner == nil && !argocdProject.Spec.ChangeFreeze {
+		return nil, nil
+	}
+
+	var banner Banner
+	if argocdProject.Spec.Banner != nil {
+		banner = *argocdProject.Spec.Banner
+	}
+
+	if banner.Message == "" {
+		if argocdProject.Spec.Environment == "" {
+			return nil, fmt.Errorf("spec.banner.message is required without spec.environment")
+		}
+
+		banner.Message = fmt.Sprintf(environmentBanner, argocdProject.Spec.Environment)
+		if argocdProject.Spec.ChangeFreeze {
+			banner.Message += changeFreezeBanner
+		}
+	}
+
+	if banner.Severity == "" {
+		switch {
+		case argocdProject.Spec.ChangeFreeze:
+			banner.Severity = Critical
+		case argocdProject.Spec.Environment == productionEnvironment:
+			banner.Severity = Warning
+		}
+	}
+
+	if banner.Severity == "" {
+		banner.Severity = Info
+	}
+	if err := banner.Severity.Validate(); err != nil {
+		return nil, fmt.Errorf("spec.banner: %w", err)
+	}
+
+	return &banner, nil
+}
+
+func setBanner(objectMeta *metav1.ObjectMeta, banner *Banner) {
+	if objectMeta.Annotations == nil {
+		objectMeta.Annotations = make(map[string]string)
+	}
+
+	objectMeta.Annotations[bannerAnnotation] = banner.Message
+	objectMeta.Annotations[bannerSeverityAnnotation] = string(banner.Severity)
+}
+
+// rejectPaths fails on the fields of a project naming files to read.
+func rejectPaths(argocdProject *ArgoCDProject) error {
+	if argocdProject.Spec.ClusterRegistry != "" {
+		return fmt.Errorf("spec.clusterRegistry names a file, which cannot be read")
+	}
+
+	if argocdProject.Spec.AccessControl.PolicyTemplates != "" {
+		return fmt.Errorf("spec.accessControl.policyTemplates names a file, which cannot be read")
+	}
+
+	return nil
+}
+
+// resolvePaths reads the paths of a project file from a directory, which they
+// cannot be outside of.
+func resolvePaths(argocdProject *ArgoCDProject, dir string) error {
+	for _, field := range []struct {
+		name string
+		path *string
+	}{
+		{"spec.clusterRegistry", &argocdProject.Spec.ClusterRegistry},
+		{"spec.accessControl.policyTemplates", &argocdProject.Spec.AccessControl.PolicyTemplates},
+	} {
+		if *field.path == "" {
+			continue
+		}
+
+		path, err := resolvePath(dir, *field.path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.path = path
+	}
+
+	return nil
+}
+
+// resolvePath joins a path to a directory, failing when it is outside of the
+// directory, so paths given by others cannot read the rest of the host.
+func resolvePath(dir string, path string) (string, error) {
+	dir = filepath.Clean(dir)
+	resolved := filepath.Join(dir, path)
+	if resolved != dir && !strings.HasPrefix(resolved, dir+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", path, dir)
+	}
+
+	return resolved, nil
+}
+
+// expandEnvironments fans the applications out to each of spec.environments,
+// annotating the environment of each, where a single environment is the
+// environment of the project.
+func expandEnvironments(argocdProject *ArgoCDProject) error {
+	environments := argocdProject.Spec.Environments
+	if len(environments) == 0 {
+		return nil
+	}
+
+	if argocdProject.Spec.Environment != "" {
+		return fmt.Errorf("spec.environment and spec.environments are mutually exclusive")
+	}
+
+	for i, environment := range environments {
+		if environment == "" {
+			return fmt.Errorf("spec.environments[%d] is empty", i)
+		}
+
+		if containsString(environments[:i], environment) {
+			return fmt.Errorf("spec.environments[%d] repeats %s", i, environment)
+		}
+	}
+
+	if len(environments) == 1 {
+		argocdProject.Spec.Environment, argocdProject.Spec.Environments = environments[0], nil
+		return nil
+	}
+
+	apps := make([]argov1alpha1.Application, 0, len(argocdProject.Spec.ApplicationTemplates)*len(environments))
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		for _, environment := range environments {
+			environmentApp := *app.DeepCopy()
+			environmentApp.Name = fmt.Sprintf("%s-%s", app.Name, makeNameSuffix(environment))
+			if environmentApp.Annotations == nil {
+				environmentApp.Annotations = make(map[string]string)
+			}
+			environmentApp.Annotations[environmentAnnotation] = environment
+			apps = append(apps, environmentApp)
+		}
+	}
+	argocdProject.Spec.ApplicationTemplates = apps
+
+	return nil
+}
+
+// validateApplicationTemplate checks the fields of an application template
+// without which Argo CD marks its applications invalid.
+func validateApplicationTemplate(i int, app *argov1alpha1.Application) []error {
+	var errs []error
+
+	if app.Spec.Destination.Server == "" && app.Spec.Destination.Name == "" {
+		errs = append(errs, fmt.Errorf("spec.applicationTemplates[%d].spec.destination has neither server nor name", i))
+	}
+
+	if app.Spec.Destination.Namespace == "" {
+		errs = append(errs, fmt.Errorf("spec.applicationTemplates[%d].spec.destination.namespace is empty", i))
+	}
+
+	if app.Spec.Source.RepoURL == "" {
+		errs = append(errs, fmt.Errorf("spec.applicationTemplates[%d].spec.source.repoURL is empty", i))
+	}
+
+	return errs
+}
+
+// validateEnvironments checks the environments of a project, which become the
+// overlay directories and the git refs of its applications.
+func validateEnvironments(argocdProject *ArgoCDProject, pattern string, reserved []string) error {
+	if pattern == "" {
+		pattern = DefaultEnvironmentPattern
+	}
+
+	expression, err := regexp.Compile(pattern)
+	if err != nil {
+		return fmt.Errorf("environment pattern: %w", err)
+	}
+
+	validate := func(field string, environment string) error {
+		switch {
+		case environment == "":
+			return nil
+		case strings.ContainsAny(environment, `/\`) || strings.Contains(environment, "..") || strings.IndexFunc(environment, unicode.IsSpace) >= 0:
+			return fmt.Errorf("%s %q cannot have slashes, whitespace or ..", field, environment)
+		case !expression.MatchString(environment):
+			return fmt.Errorf("%s %q does not match %s", field, environment, pattern)
+		case containsString(reserved, environment):
+			return fmt.Errorf("%s %q is reserved", field, environment)
+		}
+		return nil
+	}
+
+	if err := validate("spec.environment", argocdProject.Spec.Environment); err != nil {
+		return err
+	}
+
+	for i, environment := range argocdProject.Spec.Environments {
+		if err := validate(fmt.Sprintf("spec.environments[%d]", i), environment); err != nil {
+			return err
+		}
+	}
+
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		if err := validate(fmt.Sprintf("application %s: annotation %s", app.Name, environmentAnnotation), app.Annotations[environmentAnnotation]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRequiredGroups checks that projects of the environments requiring
+// them have groups in their read-only and read-sync roles, so they are not
+// deployed where nobody can see them, unless their RBAC is managed elsewhere.
+func validateRequiredGroups(argocdProject *ArgoCDProject, environments []string) error {
+	accessControl := &argocdProject.Spec.AccessControl
+	if len(environments) == 0 || accessControl.Disabled {
+		return nil
+	}
+
+	projectEnvironments := append([]string{argocdProject.Spec.Environment}, argocdProject.Spec.Environments...)
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		projectEnvironments = append(projectEnvironments, app.Annotations[environmentAnnotation])
+	}
+
+	for _, environment := range projectEnvironments {
+		if !containsString(environments, environment) {
+			continue
+		}
+
+		for _, accessLevel := range []accessLevel{ReadOnly, ReadSync} {
+			if len(accessLevel.definition().groups(accessControl)) > 0 || hasProjectRoleGroups(&argocdProject.Spec.AppProject, accessLevel.String()) {
+				continue
+			}
+
+			return fmt.Errorf("spec.accessControl: role %s has no groups, which environment %s requires", accessLevel, environment)
+		}
+	}
+
+	return nil
+}
+
+// applicationEnvironment returns the environment of an application, which
+// the applications fanned out to many environments are annotated with.
+func applicationEnvironment(argocdProject *ArgoCDProject, app *argov1alpha1.Application) string {
+	if environment, exists := app.Annotations[environmentAnnotation]; exists {
+		return environment
+	}
+
+	return argocdProject.Spec.Environment
+}
+
+func makeNameSuffix(s string) string {
+	return strings.Trim(invalidNameCharacters.ReplaceAllString(strings.ToLower(s), "-"), "-")
+}
+
+// makeProjectName returns the name of the AppProject of a project, which is
+// part of the subjects and objects of its policies. Names that are not valid
+// resource names, such as those with colons, which separate the fields of
+// subjects, or uppercase letters, are sanitized with a hash of the original
+// suffixed, so distinct names never collide.
+func makeProjectName(name string) string {
+	if name == "" || len(name) <= maxProjectNameLength && len(validation.IsDNS1123Subdomain(name)) == 0 {
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(name))
+	hash := hex.EncodeToString(sum[:])[:projectNameHashLength]
+
+	sanitized := makeNameSuffix(name)
+	if maxLength := maxProjectNameLength - len(hash) - 1; len(sanitized) > maxLength {
+		sanitized = strings.TrimRight(sanitized[:maxLength], "-")
+	}
+	if sanitized == "" {
+		return hash
+	}
+
+	return sanitized + "-" + hash
+}
+
+// expandDestinations replaces destination names with wildcards by the names
+// of the matching clusters of the registry, fanning applications out when
+// there are many, so new clusters are picked up without editing projects.
+func expandDestinations(argocdProject *ArgoCDProject) error {
+	var registry *ClusterRegistry
+
+	apps := make([]argov1alpha1.Application, 0, len(argocdProject.Spec.ApplicationTemplates))
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		pattern := app.Spec.Destination.Name
+		if !strings.ContainsAny(pattern, globCharacters) {
+			apps = append(apps, app)
+			continue
+		}
+
+		if registry == nil {
+			if argocdProject.Spec.ClusterRegistry == "" {
+				return fmt.Errorf("application %s: spec.clusterRegistry is required by destination %s", app.Name, pattern)
+			}
+
+			r, err := readClusterRegistry(argocdProject.Spec.ClusterRegistry)
+			if err != nil {
+				return err
+			}
+			registry = r
+		}
+
+		var clusters []string
+		for _, cluster := range registry.Clusters {
+			if matches(pattern, cluster.Name) {
+				clusters = append(clusters, cluster.Name)
+			}
+		}
+
+		switch len(clusters) {
+		case 0:
+			return fmt.Errorf("application %s: destination %s matches no clusters", app.Name, pattern)
+		case 1:
+			app.Spec.Destination.Name = clusters[0]
+			apps = append(apps, app)
+		default:
+			for _, cluster := range clusters {
+				clusterApp := *app.DeepCopy()
+				clusterApp.Name = fmt.Sprintf("%s-%s", app.Name, makeNameSuffix(cluster))
+				clusterApp.Spec.Destination.Name = cluster
+				apps = append(apps, clusterApp)
+			}
+		}
+	}
+	argocdProject.Spec.ApplicationTemplates = apps
+
+	return nil
+}
+
+// setNamespaces places the AppProject in spec.namespace and the Applications
+// in spec.applicationNamespace, or spec.namespace, unless their templates
+// already have one.
+func setNamespaces(argocdProject *ArgoCDProject) error {
+	for _, namespace := range []struct {
+		field string
+		value string
+	}{
+		{"spec.namespace", argocdProject.Spec.Namespace},
+		{"spec.applicationNamespace", argocdProject.Spec.ApplicationNamespace},
+	} {
+		if namespace.value == "" {
+			continue
+		}
+
+		if errs := validation.IsDNS1123Label(namespace.value); len(errs) > 0 {
+			return fmt.Errorf("%s %s is invalid: %s", namespace.field, namespace.value, strings.Join(errs, ", "))
+		}
+	}
+
+	if argocdProject.Spec.AppProject.Namespace == "" {
+		argocdProject.Spec.AppProject.Namespace = argocdProject.Spec.Namespace
+	}
+
+	applicationNamespace := argocdProject.Spec.ApplicationNamespace
+	if applicationNamespace == "" {
+		applicationNamespace = argocdProject.Spec.Namespace
+	}
+
+	for i := range argocdProject.Spec.ApplicationTemplates {
+		app := &argocdProject.Spec.ApplicationTemplates[i]
+		if app.Namespace == "" {
+			app.Namespace = applicationNamespace
+		}
+	}
+
+	return nil
+}
+
+// makeSourceNamespaces returns the namespaces of the Applications outside the
+// namespace of the AppProject, which Argo CD only reconciles when the
+// AppProject lists them.
+func makeSourceNamespaces(argocdProject *ArgoCDProject) []string {
+	projectNamespace := argocdProject.Spec.AppProject.Namespace
+	if projectNamespace == "" {
+		projectNamespace = defaultArgoCDNamespace
+	}
+
+	var namespaces []string
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		if app.Namespace == "" || app.Namespace == projectNamespace || containsString(namespaces, app.Namespace) {
+			continue
+		}
+		namespaces = append(namespaces, app.Namespace)
+	}
+	sort.Strings(namespaces)
+
+	return namespaces
+}
+
+func readClusterRegistry(path string) (*ClusterRegistry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var registry ClusterRegistry
+	if err := yaml.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	servers := make(map[string]string, len(registry.Clusters))
+	for i, cluster := range registry.Clusters {
+		if cluster.Name == "" {
+			return nil, fmt.Errorf("%s: clusters[%d] has no name", path, i)
+		}
+
+		if cluster.Server == "" {
+			continue
+		}
+		server := normalizeServer(cluster.Server)
+		if name, exists := servers[server]; exists && name != cluster.Name {
+			return nil, fmt.Errorf("%s: clusters[%d] repeats the server of cluster %s", path, i, name)
+		}
+		servers[server] = cluster.Name
+	}
+
+	return &registry, nil
+}
+
+func makeAppProject(argocdProject *ArgoCDProject) ([]byte, error) {
+	appProject := &argocdProject.Spec.AppProject
+
+	appProject.TypeMeta = metav1.TypeMeta{
+		APIVersion: argov1alpha1.SchemeGroupVersion.String(),
+		Kind:       application.AppProjectKind,
+	}
+
+	appProject.Name = makeProjectName(argocdProject.Name)
+	if appProject.Name != argocdProject.Name {
+		if appProject.Annotations == nil {
+			appProject.Annotations = make(map[string]string)
+		}
+		appProject.Annotations[projectNameAnnotation] = argocdProject.Name
+	}
+
+	appProject.Spec.NamespaceResourceWhitelist = []metav1.GroupKind{
+		metav1.GroupKind{
+			Group: "*",
+			Kind:  "*",
+		},
+	}
+
+	clusterResourceWhitelist, err := makeClusterResourceWhitelist(argocdProject.Spec.AccessControl.ClusterCapabilities, appProject.Spec.ClusterResourceWhitelist)
+	if err != nil {
+		return nil, err
+	}
+	appProject.Spec.ClusterResourceWhitelist = clusterResourceWhitelist
+
+	// TODO only allow SourceRepos required by applications to avoid unnecessary permissions
+	appProject.Spec.SourceRepos = []string{
+		"*",
+	}
+
+	if appProject.Spec.Destinations == nil {
+		destinations, err := makeDestinations(argocdProject)
+		if err != nil {
+			return nil, err
+		}
+		appProject.Spec.Destinations = destinations
+	}
+
+	denyDestinations, err := makeDenyDestinations(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+	appProject.Spec.Destinations = append(appProject.Spec.Destinations, denyDestinations...)
+
+	if !argocdProject.Spec.AccessControl.Disabled {
+		policyTemplates, err := readPolicyTemplates(argocdProject.Spec.AccessControl.PolicyTemplates)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := validateAccessLevelGroups(&argocdProject.Spec.AccessControl); err != nil {
+			return nil, err
+		}
+
+		for i := range accessLevels {
+			accessLevel := accessLevel(i)
+			if hasProjectRole(appProject, accessLevel.String()) {
+				continue
+			}
+
+			if accessLevels[i].onDemand && len(accessLevels[i].groups(&argocdProject.Spec.AccessControl)) == 0 {
+				continue
+			}
+
+			projectRole, err := makeProjectRole(accessLevel, argocdProject, appProject, policyTemplates)
+			if err != nil {
+				return nil, fmt.Errorf("spec.accessControl: %w", err)
+			}
+			appProject.Spec.Roles = append(appProject.Spec.Roles, *projectRole)
+		}
+	}
+
+	if breakGlass := argocdProject.Spec.AccessControl.BreakGlass; breakGlass != nil {
+		projectRole, err := makeBreakGlassRole(breakGlass, appProject)
+		if err != nil {
+			return nil, fmt.Errorf("spec.accessControl.breakGlass: %w", err)
+		}
+		if projectRole != nil {
+			appProject.Spec.Roles = append(appProject.Spec.Roles, *projectRole)
+		}
+	}
+
+	destinationServiceAccounts, err := makeDestinationServiceAccounts(argocdProject)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalAppProject(argocdProject, appProject, destinationServiceAccounts)
+}
+
+func makeProjectRole(accessLevel accessLevel, argocdProject *ArgoCDProject, appProject *argov1alpha1.AppProject, policyTemplates map[string][]string) (*argov1alpha1.ProjectRole, error) {
+	if err := validatePolicyValues(appProject.Name, accessLevel.String()); err != nil {
+		return nil, err
+	}
+
+	groups := accessLevel.definition().groups(&argocdProject.Spec.AccessControl)
+
+	policies := accessLevel.Policies(appProject.Name)
+	if templates, exists := policyTemplates[accessLevel.String()]; exists {
+		replacer := strings.NewReplacer(
+			projectPlaceholder, appProject.Name,
+			rolePlaceholder, accessLevel.String(),
+		)
+
+		policies = make([]string, 0, len(templates))
+		for _, template := range templates {
+			policies = append(policies, replacer.Replace(template))
+		}
+	}
+
+	if err := validatePolicyLines(policies); err != nil {
+		return nil, fmt.Errorf("role %s: %w", accessLevel, err)
+	}
+
+	return &argov1alpha1.ProjectRole{
+		Name:     accessLevel.String(),
+		Policies: policies,
+		Groups:   groups,
+	}, nil
+}
+
+// validatePolicyValues checks the names interpolated into the policies of a
+// role, so a crafted name, such as a project named *, cannot grant more than
+// the role of its project.
+func validatePolicyValues(project string, role string) error {
+	if strings.ContainsAny(project, policyMetaCharacters) {
+		return fmt.Errorf("project %q cannot be part of policies", project)
+	}
+
+	if strings.ContainsAny(role, policyMetaCharacters) {
+		return fmt.Errorf("role %q cannot be part of policies", role)
+	}
+
+	return nil
+}
+
+// validatePolicyLines checks that every policy is a single line of the policy
+// CSV of Argo CD, which would otherwise read the rest as further policies.
+func validatePolicyLines(policies []string) error {
+	for _, policy := range policies {
+		if strings.ContainsAny(policy, "\r\n") {
+			return fmt.Errorf("policy %q spans many lines", policy)
+		}
+	}
+
+	return nil
+}
+
+// validateAccessLevelGroups checks that spec.accessControl.groups only names
+// registered access levels, whose built-in ones have fields of their own.
+func validateAccessLevelGroups(accessControl *AppProjectAccessControl) error {
+	for name := range accessControl.Groups {
+		accessLevel, err := parseAccessLevel(name)
+		if err != nil || int(accessLevel) < builtinAccessLevels {
+			return fmt.Errorf("spec.accessControl.groups.%s is not a registered access level", name)
+		}
+	}
+
+	return nil
+}
+
+// makeBreakGlassRole returns a role granting full access to the applications
+// of the project until it expires, after which it is no longer rendered.
+func makeBreakGlassRole(breakGlass *BreakGlass, appProject *argov1alpha1.AppProject) (*argov1alpha1.ProjectRole, error) {
+	if len(breakGlass.Groups) == 0 {
+		return nil, fmt.Errorf("groups is empty")
+	}
+
+	if breakGlass.Reason == "" {
+		return nil, fmt.Errorf("reason is empty")
+	}
+
+	if breakGlass.ExpiresAt == nil {
+		return nil, fmt.Errorf("expiresAt is empty")
+	}
+
+	if !breakGlass.ExpiresAt.After(time.Now()) {
+		return nil, nil
+	}
+
+	if hasProjectRole(appProject, breakGlassRole) {
+		return nil, fmt.Errorf("role %s is already defined", breakGlassRole)
+	}
+
+	if err := validatePolicyValues(appProject.Name, breakGlassRole); err != nil {
+		return nil, err
+	}
+
+	expiresAt := breakGlass.ExpiresAt.UTC()
+
+	return &argov1alpha1.ProjectRole{
+		Name:        breakGlassRole,
+		Description: fmt.Sprintf("Break-glass access until %s: %s", expiresAt.Format(time.RFC3339), breakGlass.Reason),
+		Policies: []string{
+			fmt.Sprintf("p, proj:%s:%s, applications, *, %s/*, allow", appProject.Name, breakGlassRole, appProject.Name),
+		},
+		Groups: breakGlass.Groups,
+		JWTTokens: []argov1alpha1.JWTToken{
+			{
+				ID:        fmt.Sprintf("%s-%d", breakGlassRole, expiresAt.Unix()),
+				ExpiresAt: expiresAt.Unix(),
+			},
+		},
+	}, nil
+}
+
+// makeBreakGlassCleanup returns a CronJob removing the break-glass role from
+// the AppProject when it expires, for clusters where the AppProject is not
+// rendered again in time. The patch tests the name of the role before removing
+// it, so it never removes other roles.
+func makeBreakGlassCleanup(argocdProject *ArgoCDProject) ([][]byte, error) {
+	breakGlass := argocdProject.Spec.AccessControl.BreakGlass
+	if breakGlass == nil || breakGlass.Cleanup == nil {
+		return nil, nil
+	}
+
+	appProject := &argocdProject.Spec.AppProject
+
+	index := -1
+	for i, role := range appProject.Spec.Roles {
+		if role.Name == breakGlassRole {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, nil
+	}
+
+	namespace := breakGlass.Cleanup.Namespace
+	if namespace == "" {
+		namespace = appProject.Namespace
+	}
+	if namespace == "" {
+		namespace = defaultArgoCDNamespace
+	}
+
+	image := breakGlass.Cleanup.Image
+	if image == "" {
+		image = defaultCleanupImage
+	}
+
+	patch, err := json.Marshal([]map[string]interface{}{
+		{
+			"op":    "test",
+			"path":  fmt.Sprintf("/spec/roles/%d/name", index),
+			"value": breakGlassRole,
+		},
+		{
+			"op":   "remove",
+			"path": fmt.Sprintf("/spec/roles/%d", index),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	name := appProject.Name + breakGlassCleanupSuffix
+	expiresAt := breakGlass.ExpiresAt.UTC()
+
+	serviceAccount, err := yaml.Marshal(corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ServiceAccount{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	role, err := yaml.Marshal(rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.Role{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Rules: []rbacv1.PolicyRule{
+			{
+				APIGroups:     []string{application.Group},
+				Resources:     []string{appProjectsResource},
+				ResourceNames: []string{appProject.Name},
+				Verbs:         []string{"get", "patch"},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	roleBinding, err := yaml.Marshal(rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.RoleBinding{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      name,
+				Namespace: namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     reflect.TypeOf(rbacv1.Role{}).Name(),
+			Name:     name,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cronJob, err := yaml.Marshal(batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(batchv1.CronJob{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:          fmt.Sprintf("%d %d %d %d *", expiresAt.Minute(), expiresAt.Hour(), expiresAt.Day(), expiresAt.Month()),
+			ConcurrencyPolicy: batchv1.ForbidConcurrent,
+			JobTemplate: batchv1.JobTemplateSpec{
+				Spec: batchv1.JobSpec{
+					Template: corev1.PodTemplateSpec{
+						Spec: corev1.PodSpec{
+							ServiceAccountName: name,
+							RestartPolicy:      corev1.RestartPolicyOnFailure,
+							Containers: []corev1.Container{
+								{
+									Name:  breakGlassCleanupContainer,
+									Image: image,
+									Command: []string{
+										"kubectl",
+										"patch",
+										"appproject",
+										appProject.Name,
+										"--namespace",
+										namespace,
+										"--type",
+										"json",
+										"--patch",
+										string(patch),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{serviceAccount, role, roleBinding, cronJob}, nil
+}
+
+// readPolicyTemplates reads the policies of each access level from a file,
+// so their wording changes without a new release of the plugin.
+func readPolicyTemplates(path string) (map[string][]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policyTemplates map[string][]string
+	if err := yaml.UnmarshalStrict(data, &policyTemplates); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for name, templates := range policyTemplates {
+		if _, err := parseAccessLevel(name); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		for _, template := range templates {
+			if strings.TrimSpace(template) == "" {
+				return nil, fmt.Errorf("%s: %s has an empty policy", path, name)
+			}
+		}
+	}
+
+	return policyTemplates, nil
+}
+
+// makeClusterResourceWhitelist appends the cluster-scoped kinds of each
+// capability to the ones allowed by the template.
+func makeClusterResourceWhitelist(capabilities []string, whitelist []metav1.GroupKind) ([]metav1.GroupKind, error) {
+	var names []string
+	for _, capability := range capabilities {
+		if capability == platformCapability {
+			for name := range clusterCapabilities {
+				names = append(names, name)
+			}
+			continue
+		}
+
+		if _, exists := clusterCapabilities[capability]; !exists {
+			return nil, fmt.Errorf("spec.accessControl.clusterCapabilities: unknown capability %s", capability)
+		}
+		names = append(names, capability)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		for _, groupKind := range clusterCapabilities[name] {
+			if !containsGroupKind(whitelist, groupKind) {
+				whitelist = append(whitelist, groupKind)
+			}
+		}
+	}
+
+	return whitelist, nil
+}
+
+func containsGroupKind(slice []metav1.GroupKind, groupKind metav1.GroupKind) bool {
+	for _, item := range slice {
+		if item == groupKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// makeDestinations returns the destinations of the applications, merging the
+// ones that are equal but for the trailing slashes of their servers or for
+// naming a cluster whose server another one gives, as known from the cluster
+// registry, so the AppProject lists each destination once.
+func makeDestinations(argocdProject *ArgoCDProject) ([]argov1alpha1.ApplicationDestination, error) {
+	servers := map[string]string{
+		inClusterName: inClusterServer,
+	}
+	if argocdProject.Spec.ClusterRegistry != "" {
+		registry, err := readClusterRegistry(argocdProject.Spec.ClusterRegistry)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, cluster := range registry.Clusters {
+			if cluster.Server != "" {
+				servers[cluster.Name] = normalizeServer(cluster.Server)
+			}
+		}
+	}
+
+	var destinations []argov1alpha1.ApplicationDestination
+	seen := make(map[string]bool)
+	for _, app := range argocdProject.Spec.ApplicationTemplates {
+		destination := app.Spec.Destination
+		destination.Server = normalizeServer(destination.Server)
+
+		if server, exists := servers[destination.Name]; exists {
+			if destination.Server != "" && destination.Server != server {
+				return nil, fmt.Errorf("application %s: cluster %s has server %s instead of %s", app.Name, destination.Name, server, destination.Server)
+			}
+			destination.Name, destination.Server = "", server
+		}
+
+		if key := destination.String(); !seen[key] {
+			seen[key] = true
+			destinations = append(destinations, destination)
+		}
+	}
+
+	return destinations, nil
+}
+
+func normalizeServer(server string) string {
+	return strings.TrimRight(server, "/")
+}
+
+// makeDenyDestinations negates the fields of each denied destination, which
+// Argo CD evaluates before the allowed ones, and rejects applications that
+// would not be allowed to sync.
+func makeDenyDestinations(argocdProject *ArgoCDProject) ([]argov1alpha1.ApplicationDestination, error) {
+	destinations := make([]argov1alpha1.ApplicationDestination, 0, len(argocdProject.Spec.DenyDestinations))
+	for i, deny := range argocdProject.Spec.DenyDestinations {
+		if deny.Server == "" && deny.Name == "" && deny.Namespace == "" {
+			return nil, fmt.Errorf("spec.denyDestinations[%d] is empty", i)
+		}
+
+		if deny.Server != "" && deny.Name != "" {
+			return nil, fmt.Errorf("spec.denyDestinations[%d] has both server and name", i)
+		}
+
+		for _, field := range []string{deny.Server, deny.Name, deny.Namespace} {
+			if strings.HasPrefix(field, negationPrefix) {
+				return nil, fmt.Errorf("spec.denyDestinations[%d] is already negated", i)
+			}
+		}
+
+		for _, app := range argocdProject.Spec.ApplicationTemplates {
+			if isDenied(&deny, &app.Spec.Destination) {
+				return nil, fmt.Errorf("application %s targets spec.denyDestinations[%d]", app.Name, i)
+			}
+		}
+
+		destination := argov1alpha1.ApplicationDestination{
+			Namespace: negate(deny.Namespace),
+		}
+		switch {
+		case deny.Name != "":
+			destination.Name = negate(deny.Name)
+		default:
+			destination.Server = negate(deny.Server)
+		}
+		destinations = append(destinations, destination)
+	}
+
+	return destinations, nil
+}
+
+func makeDestinationServiceAccounts(argocdProject *ArgoCDProject) ([]DestinationServiceAccount, error) {
+	destinationServiceAccounts := make([]DestinationServiceAccount, 0, len(argocdProject.Spec.DestinationServiceAccounts))
+	for i, destinationServiceAccount := range argocdProject.Spec.DestinationServiceAccounts {
+		if destinationServiceAccount.DefaultServiceAccount == "" {
+			return nil, fmt.Errorf("spec.destinationServiceAccounts[%d] has no defaultServiceAccount", i)
+		}
+
+		if destinationServiceAccount.Server == "" {
+			destinationServiceAccount.Server = anyPattern
+		}
+
+		if destinationServiceAccount.Namespace == "" {
+			destinationServiceAccount.Namespace = anyPattern
+		}
+
+		for _, previous := range destinationServiceAccounts {
+			if previous.Server == destinationServiceAccount.Server && previous.Namespace == destinationServiceAccount.Namespace {
+				return nil, fmt.Errorf("spec.destinationServiceAccounts[%d] repeats server %s and namespace %s", i, previous.Server, previous.Namespace)
+			}
+		}
+
+		destinationServiceAccounts = append(destinationServiceAccounts, destinationServiceAccount)
+	}
+
+	return destinationServiceAccounts, nil
+}
+
+func isDenied(deny *argov1alpha1.ApplicationDestination, destination *argov1alpha1.ApplicationDestination) bool {
+	switch {
+	case deny.Name != "" && !matches(deny.Name, destination.Name):
+		return false
+	case deny.Server != "" && !matches(deny.Server, destination.Server):
+		return false
+	case deny.Namespace != "" && !matches(deny.Namespace, destination.Namespace):
+		return false
+	default:
+		return true
+	}
+}
+
+func matches(pattern string, value string) bool {
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+func negate(pattern string) string {
+	if pattern == "" {
+		return anyPattern
+	}
+
+	return negationPrefix + pattern
+}
+
+// hasProjectRole tells whether the template already defines a role, as
+// templates rendered by previous runs do.
+func hasProjectRole(appProject *argov1alpha1.AppProject, name string) bool {
+	for _, role := range appProject.Spec.Roles {
+		if role.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasProjectRoleGroups(appProject *argov1alpha1.AppProject, name string) bool {
+	for _, role := range appProject.Spec.Roles {
+		if role.Name == name && len(role.Groups) > 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+func makeApplications(argocdProject *ArgoCDProject) ([][]byte, error) {
+	apps := argocdProject.Spec.ApplicationTemplates
+	manifests := make([][]byte, 0, len(apps))
+
+	for i := range apps {
+		app := &apps[i]
+
+		app.TypeMeta = metav1.TypeMeta{
+			APIVersion: argov1alpha1.SchemeGroupVersion.String(),
+			Kind:       application.ApplicationKind,
+		}
+
+		app.Spec.Project = makeProjectName(argocdProject.Name)
+
+		if environment := applicationEnvironment(argocdProject, app); environment != "" {
+			app.Spec.Source.Path = fmt.Sprintf("./k8s/overlays/%s", environment)
+			app.Spec.Source.TargetRevision = fmt.Sprintf("env-%s", environment)
+		}
+
+		app.Spec.Info = mergeInfo(argocdProject, app)
+
+		b, err := marshalYAMLWithoutStatusField(app)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+// mergeInfo prepends the project's info to the application's own, which
+// replaces project entries of the same name in place. The project placeholder
+// stands for the name of the AppProject, which links resolve to.
+func mergeInfo(argocdProject *ArgoCDProject, app *argov1alpha1.Application) []argov1alpha1.Info {
+	if len(argocdProject.Spec.Info) == 0 {
+		return app.Spec.Info
+	}
+
+	replacer := strings.NewReplacer(
+		projectPlaceholder, makeProjectName(argocdProject.Name),
+		applicationPlaceholder, app.Name,
+		namespacePlaceholder, app.Spec.Destination.Namespace,
+		environmentPlaceholder, applicationEnvironment(argocdProject, app),
+	)
+
+	overrides := make(map[string]string, len(app.Spec.Info))
+	for _, info := range app.Spec.Info {
+		overrides[info.Name] = info.Value
+	}
+
+	infos := make([]argov1alpha1.Info, 0, len(argocdProject.Spec.Info)+len(app.Spec.Info))
+	defaults := make(map[string]bool, len(argocdProject.Spec.Info))
+	for _, info := range argocdProject.Spec.Info {
+		defaults[info.Name] = true
+
+		value, exists := overrides[info.Name]
+		if !exists {
+			value = replacer.Replace(info.Value)
+		}
+
+		infos = append(infos, argov1alpha1.Info{
+			Name:  info.Name,
+			Value: value,
+		})
+	}
+
+	for _, info := range app.Spec.Info {
+		if !defaults[info.Name] {
+			infos = append(infos, info)
+		}
+	}
+
+	return infos
+}
+
+// marshalAppProject sets the fields of AppProjects that are newer than the
+// Argo CD module this repository depends on.
+func marshalAppProject(argocdProject *ArgoCDProject, appProject *argov1alpha1.AppProject, destinationServiceAccounts []DestinationServiceAccount) ([]byte, error) {
+	vm, err := toMapWithoutStatusField(appProject)
+	if err != nil {
+		return nil, err
+	}
+
+	spec, ok := vm[yamlSpecField].(map[string]interface{})
+	if !ok {
+		spec = make(map[string]interface{})
+		vm[yamlSpecField] = spec
+	}
+
+	if argocdProject.Spec.PermitOnlyProjectScopedClusters {
+		spec[permitOnlyProjectScopedClustersField] = true
+	}
+
+	if len(destinationServiceAccounts) > 0 {
+		spec[destinationServiceAccountsField] = destinationServiceAccounts
+	}
+
+	if sourceNamespaces := makeSourceNamespaces(argocdProject); len(sourceNamespaces) > 0 {
+		spec[sourceNamespacesField] = sourceNamespaces
+	}
+
+	return yaml.Marshal(vm)
+}
+
+func marshalYAMLWithoutStatusField(v interface{}) ([]byte, error) {
+	vm, err := toMapWithoutStatusField(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(vm)
+}
+
+// omitEmpty drops the null fields of a manifest and the empty maps of its
+// metadata, keeping other empty maps, such as spec.syncPolicy.automated, whose
+// presence is meaningful.
+func omitEmpty(manifest []byte) ([]byte, error) {
+	var vm map[string]interface{}
+	if err := yaml.Unmarshal(manifest, &vm); err != nil {
+		return nil, err
+	}
+
+	omitNulls(vm)
+
+	if metadata, ok := vm[yamlMetaField].(map[string]interface{}); ok {
+		for key, value := range metadata {
+			if value, ok := value.(map[string]interface{}); ok && len(value) == 0 {
+				delete(metadata, key)
+			}
+		}
+
+		if len(metadata) == 0 {
+			delete(vm, yamlMetaField)
+		}
+	}
+
+	return yaml.Marshal(vm)
+}
+
+func omitNulls(v interface{}) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for key, value := range v {
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			omitNulls(value)
+		}
+	case []interface{}:
+		for _, value := range v {
+			omitNulls(value)
+		}
+	}
+}
+
+func toMapWithoutStatusField(v interface{}) (map[string]interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var vm map[string]interface{}
+	if err := json.Unmarshal(b, &vm); err != nil {
+		return nil, err
+	}
+
+	delete(vm, yamlStatusField)
+
+	return vm, nil
+}
@@ -1,4 +1,4 @@
-package main_test
+package argocdproject_test
 
 import (
 	"testing"
@@ -0,0 +1,1539 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"github.com/onsi/gomega/gstruct"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ArgoCDProject", func() {
+	ginkgo.DescribeTable("", ArgoCDProject,
+		ginkgo.Entry("with single application", argocdproject.ArgoCDProject{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: schema.GroupVersion{
+					Group:   "incognia.com",
+					Version: "v1alpha1",
+				}.String(),
+				Kind: "ArgoCDProject",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "github-checker",
+			},
+			Spec: argocdproject.ProjectSpec{
+				AccessControl: argocdproject.AppProjectAccessControl{
+					ReadOnly: []string{
+						"sre:eng-2",
+					},
+					ReadSync: []string{
+						"sre:eng-0",
+						"sre:eng-1",
+					},
+				},
+				Environment: "production",
+				ApplicationTemplates: []argov1alpha1.Application{
+					argov1alpha1.Application{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "github-checker-app",
+						},
+						Spec: argov1alpha1.ApplicationSpec{
+							Source: argov1alpha1.ApplicationSource{
+								RepoURL: "https://github.com/inloco/github-checker.git",
+							},
+							Destination: argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-SRE",
+								Namespace: "github-checker",
+							},
+						},
+					},
+				},
+			},
+		}),
+		ginkgo.Entry("with multiple applications", argocdproject.ArgoCDProject{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: schema.GroupVersion{
+					Group:   "incognia.com",
+					Version: "v1alpha1",
+				}.String(),
+				Kind: "ArgoCDProject",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name: "github-checker",
+			},
+			Spec: argocdproject.ProjectSpec{
+				AccessControl: argocdproject.AppProjectAccessControl{
+					ReadSync: []string{
+						"sre:eng-0",
+					},
+				},
+				AppProject: argov1alpha1.AppProject{
+					Spec: argov1alpha1.AppProjectSpec{
+						Destinations: []argov1alpha1.ApplicationDestination{
+							argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-SRE",
+								Namespace: "github-checker",
+							},
+							argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-Product",
+								Namespace: "another-checker",
+							},
+							argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-FortKnox",
+								Namespace: "foreground-checker",
+							},
+						},
+						ClusterResourceWhitelist: []metav1.GroupKind{
+							metav1.GroupKind{
+								Group: "*",
+								Kind:  "*",
+							},
+						},
+					},
+				},
+				ApplicationTemplates: []argov1alpha1.Application{
+					argov1alpha1.Application{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "github-checker-app",
+						},
+						Spec: argov1alpha1.ApplicationSpec{
+							Source: argov1alpha1.ApplicationSource{
+								RepoURL:        "https://github.com/inloco/github-checker.git",
+								Path:           "namespaces/example/environment-overlays/env/cluster-overlays/cluster",
+								TargetRevision: "HEAD",
+							},
+							Destination: argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-SRE",
+								Namespace: "github-checker",
+							},
+						},
+					},
+					argov1alpha1.Application{
+						ObjectMeta: metav1.ObjectMeta{
+							Name: "another-checker-app",
+						},
+						Spec: argov1alpha1.ApplicationSpec{
+							Source: argov1alpha1.ApplicationSource{
+								RepoURL:        "https://github.com/inloco/another-checker.git",
+								Path:           "namespaces/example/environment-overlays/env/cluster-overlays/cluster",
+								TargetRevision: "HEAD",
+							},
+							Destination: argov1alpha1.ApplicationDestination{
+								Name:      "arn:aws:eks:us:123456789876:cluster/Global-Product",
+								Namespace: "another-checker",
+							},
+						},
+					},
+				},
+			},
+		}),
+	)
+
+	ginkgo.It("skips roles when access control is disabled", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    disabled: true
+    ReadOnly: [sre:eng-2]
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.BeEmpty())
+	})
+
+	ginkgo.It("keeps roles already defined by the template", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    ReadSync: [sre:eng-0]
+  appProjectTemplate:
+    spec:
+      roles:
+        - name: read-only
+          groups: [sre:eng-2]
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(2))
+		g.Expect(appProject.Spec.Roles[0].Groups).To(g.Equal([]string{"sre:eng-2"}))
+		g.Expect(appProject.Spec.Roles[0].Policies).To(g.BeEmpty())
+		g.Expect(appProject.Spec.Roles[1].Name).To(g.Equal("read-sync"))
+	})
+
+	ginkgo.It("creates the override-parameters role on demand", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    OverrideParameters: [sre:oncall]
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(3))
+		g.Expect(appProject.Spec.Roles[2]).To(g.Equal(argov1alpha1.ProjectRole{
+			Name: "override-parameters",
+			Policies: []string{
+				"p, proj:employees:override-parameters, applications, override, employees/*, allow",
+				"p, proj:employees:override-parameters, applications, action/*, employees/*, allow",
+				"g, proj:employees:override-parameters, proj:employees:read-sync",
+			},
+			Groups: []string{"sre:oncall"},
+		}))
+	})
+
+	ginkgo.It("creates roles of registered access levels on demand", func() {
+		argocdproject.RegisterAccessLevel("deployer", func(appProjectName string) []string {
+			return []string{
+				fmt.Sprintf("p, proj:%s:deployer, applications, sync, %s/*, allow", appProjectName, appProjectName),
+			}
+		})
+		g.Expect(func() {
+			argocdproject.RegisterAccessLevel("deployer", nil)
+		}).To(g.PanicWith("access level deployer is already registered"))
+		g.Expect(func() {
+			argocdproject.RegisterAccessLevel("read-only", nil)
+		}).To(g.PanicWith("access level read-only is already registered"))
+		g.Expect(func() {
+			argocdproject.RegisterAccessLevel("deployer:", nil)
+		}).To(g.PanicWith("invalid access level deployer:"))
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    groups:
+      deployer: [sre:deploy]
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(3))
+		g.Expect(appProject.Spec.Roles[2]).To(g.Equal(argov1alpha1.ProjectRole{
+			Name: "deployer",
+			Policies: []string{
+				"p, proj:employees:deployer, applications, sync, employees/*, allow",
+			},
+			Groups: []string{"sre:deploy"},
+		}))
+
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    groups:
+      read-only: [sre:eng-2]
+`), &out)).To(g.MatchError("spec.accessControl.groups.read-only is not a registered access level"))
+	})
+
+	ginkgo.DescribeTable("sanitizes names of projects that are not resource names", func(name string, expectedName string) {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(fmt.Sprintf(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: %q
+spec:
+  accessControl:
+    ReadOnly: [sre:eng-2]
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`, name)), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Name).To(g.Equal(expectedName))
+		g.Expect(appProject.Annotations).To(g.Equal(map[string]string{"incognia.com/project-name": name}))
+		g.Expect(appProject.Spec.Roles[0].Policies).To(g.Equal([]string{
+			fmt.Sprintf("p, proj:%s:read-only, *, get, %s/*, allow", expectedName, expectedName),
+		}))
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+		g.Expect(app.Spec.Project).To(g.Equal(expectedName))
+	},
+		ginkgo.Entry("with colons", "sre:employees", "sre-employees-533d9f02"),
+		ginkgo.Entry("with uppercase letters", "Employees", "employees-c9827031"),
+		ginkgo.Entry("longer than labels", strings.Repeat("employees-", 7), strings.Repeat("employees-", 5)+"empl-8c2efef3"),
+	)
+
+	ginkgo.It("keeps names of projects that are resource names", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Name).To(g.Equal("employees"))
+		g.Expect(appProject.Annotations).To(g.BeEmpty())
+	})
+
+	ginkgo.It("creates an expiring break-glass role", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    breakGlass:
+      groups: [sre:oncall]
+      reason: INC-1234
+      expiresAt: "2099-10-20T18:30:00Z"
+      cleanup: {}
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(5))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(3))
+		g.Expect(appProject.Spec.Roles[2]).To(g.Equal(argov1alpha1.ProjectRole{
+			Name:        "break-glass",
+			Description: "Break-glass access until 2099-10-20T18:30:00Z: INC-1234",
+			Policies: []string{
+				"p, proj:employees:break-glass, applications, *, employees/*, allow",
+			},
+			Groups: []string{"sre:oncall"},
+			JWTTokens: []argov1alpha1.JWTToken{
+				{
+					ID:        "break-glass-4096204200",
+					ExpiresAt: 4096204200,
+				},
+			},
+		}))
+
+		var cronJob batchv1.CronJob
+		g.Expect(yaml.Unmarshal([]byte(manifests[4]), &cronJob)).To(g.Succeed())
+		g.Expect(cronJob.Name).To(g.Equal("employees-break-glass-cleanup"))
+		g.Expect(cronJob.Namespace).To(g.Equal("argocd"))
+		g.Expect(cronJob.Spec.Schedule).To(g.Equal("30 18 20 10 *"))
+		g.Expect(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Command).To(g.Equal([]string{
+			"kubectl", "patch", "appproject", "employees", "--namespace", "argocd", "--type", "json", "--patch",
+			`[{"op":"test","path":"/spec/roles/2/name","value":"break-glass"},{"op":"remove","path":"/spec/roles/2"}]`,
+		}))
+	})
+
+	ginkgo.It("drops expired break-glass roles", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    breakGlass:
+      groups: [sre:oncall]
+      reason: INC-1234
+      expiresAt: "2020-10-20T18:30:00Z"
+      cleanup: {}
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(1))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(2))
+	})
+
+	ginkgo.It("appends denied destinations", func() {
+		project := `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  denyDestinations:
+    - name: "*-Production"
+    - namespace: kube-system
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Staging
+          namespace: payroll
+`
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(separatorYaml.Split(out.String(), -1)[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Destinations).To(g.Equal([]argov1alpha1.ApplicationDestination{
+			{
+				Name:      "Global-Staging",
+				Namespace: "payroll",
+			},
+			{
+				Name:      "!*-Production",
+				Namespace: "*",
+			},
+			{
+				Server:    "*",
+				Namespace: "!kube-system",
+			},
+		}))
+
+		project = strings.Replace(project, "Global-Staging", "Global-Production", 1)
+		g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.MatchError("application payroll targets spec.denyDestinations[0]"))
+	})
+
+	ginkgo.It("expands destinations from the cluster registry", func() {
+		dir, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		clusterRegistry := filepath.Join(dir, "clusters.yaml")
+		g.Expect(ioutil.WriteFile(clusterRegistry, []byte(`
+clusters:
+  - name: US-Staging
+    server: https://us-staging.eks.amazonaws.com
+  - name: BR-Staging
+  - name: US-Production
+  - name: Global-SRE
+`), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  clusterRegistry: `+clusterRegistry+`
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: "*-Staging"
+          namespace: payroll
+    - metadata:
+        name: checker
+      spec:
+        source:
+          repoURL: https://github.com/inloco/checker.git
+        destination:
+          name: Global-*
+          namespace: checker
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(4))
+
+		var destinations []string
+		for _, manifest := range manifests[1:] {
+			var app argov1alpha1.Application
+			g.Expect(yaml.Unmarshal([]byte(manifest), &app)).To(g.Succeed())
+			destinations = append(destinations, app.Name+"@"+app.Spec.Destination.Name)
+		}
+		g.Expect(destinations).To(g.Equal([]string{
+			"payroll-us-staging@US-Staging",
+			"payroll-br-staging@BR-Staging",
+			"checker@Global-SRE",
+		}))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Destinations).To(g.HaveLen(3))
+
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+spec:
+  clusterRegistry: `+clusterRegistry+`
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: "*-Development"
+          namespace: payroll
+`), &out)).To(g.MatchError("application payroll: destination *-Development matches no clusters"))
+	})
+
+	ginkgo.It("merges destinations of the same cluster", func() {
+		dir, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		clusterRegistry := filepath.Join(dir, "clusters.yaml")
+		g.Expect(ioutil.WriteFile(clusterRegistry, []byte(`
+clusters:
+  - name: US-Staging
+    server: https://us-staging.eks.amazonaws.com/
+  - name: BR-Staging
+`), 0644)).To(g.Succeed())
+
+		project := `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  clusterRegistry: ` + clusterRegistry + `
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: US-Staging
+          namespace: payroll
+    - metadata:
+        name: payroll-api
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll-api.git
+        destination:
+          server: https://us-staging.eks.amazonaws.com
+          namespace: payroll
+    - metadata:
+        name: payroll-worker
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll-worker.git
+        destination:
+          server: https://kubernetes.default.svc/
+          namespace: payroll
+    - metadata:
+        name: payroll-cron
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll-cron.git
+        destination:
+          name: in-cluster
+          namespace: payroll
+    - metadata:
+        name: checker
+      spec:
+        source:
+          repoURL: https://github.com/inloco/checker.git
+        destination:
+          name: BR-Staging
+          namespace: payroll
+`
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(separatorYaml.Split(out.String(), -1)[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Destinations).To(g.Equal([]argov1alpha1.ApplicationDestination{
+			{Server: "https://us-staging.eks.amazonaws.com", Namespace: "payroll"},
+			{Server: "https://kubernetes.default.svc", Namespace: "payroll"},
+			{Name: "BR-Staging", Namespace: "payroll"},
+		}))
+
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+spec:
+  clusterRegistry: `+clusterRegistry+`
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: US-Staging
+          server: https://br-staging.eks.amazonaws.com
+          namespace: payroll
+`), &out)).To(g.MatchError("application payroll: cluster US-Staging has server https://us-staging.eks.amazonaws.com instead of https://br-staging.eks.amazonaws.com"))
+
+		g.Expect(ioutil.WriteFile(clusterRegistry, []byte(`
+clusters:
+  - name: US-Staging
+    server: https://us-staging.eks.amazonaws.com
+  - name: BR-Staging
+    server: https://us-staging.eks.amazonaws.com/
+`), 0644)).To(g.Succeed())
+		g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.MatchError(clusterRegistry + ": clusters[1] repeats the server of cluster US-Staging"))
+	})
+
+	ginkgo.It("allows cluster resources of capabilities", func() {
+		project := `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: platform
+spec:
+  accessControl:
+    clusterCapabilities: [rbac, namespaces]
+  appProjectTemplate:
+    spec:
+      clusterResourceWhitelist:
+        - group: ""
+          kind: Namespace
+`
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.ClusterResourceWhitelist).To(g.Equal([]metav1.GroupKind{
+			{Group: "", Kind: "Namespace"},
+			{Group: "rbac.authorization.k8s.io", Kind: "ClusterRole"},
+			{Group: "rbac.authorization.k8s.io", Kind: "ClusterRoleBinding"},
+		}))
+
+		out.Reset()
+		g.Expect(argocdproject.GenerateManifests([]byte(strings.Replace(project, "[rbac, namespaces]", "[platform]", 1)), &out)).To(g.Succeed())
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.ClusterResourceWhitelist).To(g.HaveLen(9))
+
+		g.Expect(argocdproject.GenerateManifests([]byte(strings.Replace(project, "[rbac, namespaces]", "[network]", 1)), &out)).To(g.MatchError("spec.accessControl.clusterCapabilities: unknown capability network"))
+	})
+
+	ginkgo.DescribeTable("annotates banners", func(spec string, message string, severity string) {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+`+spec+`
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`), &out)).To(g.Succeed())
+
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var object metav1.PartialObjectMetadata
+			g.Expect(yaml.Unmarshal([]byte(manifest), &object)).To(g.Succeed())
+			g.Expect(object.Annotations).To(g.Equal(map[string]string{
+				"incognia.com/banner":          message,
+				"incognia.com/banner-severity": severity,
+			}))
+		}
+	},
+		ginkgo.Entry("of production", "  environment: production\n  banner: {}\n", "environment: production", "warning"),
+		ginkgo.Entry("of change freezes", "  environment: production\n  changeFreeze: true\n", "environment: production — change freeze applies", "critical"),
+		ginkgo.Entry("of custom messages", "  environment: staging\n  banner:\n    message: Shared with QA\n", "Shared with QA", "info"),
+	)
+
+	ginkgo.DescribeTable("selects the output", func(output argocdproject.Output, expectedKinds []string) {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+    - metadata:
+        name: benefits
+      spec:
+        source:
+          repoURL: https://github.com/inloco/benefits.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: benefits
+`), argocdproject.Options{Output: output}, &out)).To(g.Succeed())
+
+		var kinds []string
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var typeMeta metav1.TypeMeta
+			g.Expect(yaml.Unmarshal([]byte(manifest), &typeMeta)).To(g.Succeed())
+			kinds = append(kinds, typeMeta.Kind)
+		}
+		g.Expect(kinds).To(g.Equal(expectedKinds))
+	},
+		ginkgo.Entry("with all resources by default", argocdproject.Output(""), []string{"AppProject", "Application", "Application"}),
+		ginkgo.Entry("with all resources", argocdproject.OutputAll, []string{"AppProject", "Application", "Application"}),
+		ginkgo.Entry("with the AppProject", argocdproject.OutputAppProject, []string{"AppProject"}),
+		ginkgo.Entry("with the Applications", argocdproject.OutputApplications, []string{"Application", "Application"}),
+	)
+
+	ginkgo.It("fails on unknown outputs", func() {
+		err := argocdproject.GenerateManifestsWithOptions([]byte("metadata:\n  name: employees\n"), argocdproject.Options{Output: "secrets"}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError("unknown output secrets"))
+	})
+
+	ginkgo.It("permits only project scoped clusters", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: tenant
+spec:
+  permitOnlyProjectScopedClusters: true
+`), &out)).To(g.Succeed())
+
+		var appProject map[string]interface{}
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject["spec"]).To(g.HaveKeyWithValue("permitOnlyProjectScopedClusters", true))
+	})
+
+	ginkgo.It("maps destinations to service accounts", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: tenant
+spec:
+  destinationServiceAccounts:
+    - server: https://kubernetes.default.svc
+      namespace: tenant
+      defaultServiceAccount: tenant-deployer
+    - defaultServiceAccount: argocd:readonly
+`), &out)).To(g.Succeed())
+
+		var appProject map[string]interface{}
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject["spec"]).To(g.HaveKeyWithValue("destinationServiceAccounts", []interface{}{
+			map[string]interface{}{
+				"server":                "https://kubernetes.default.svc",
+				"namespace":             "tenant",
+				"defaultServiceAccount": "tenant-deployer",
+			},
+			map[string]interface{}{
+				"server":                "*",
+				"namespace":             "*",
+				"defaultServiceAccount": "argocd:readonly",
+			},
+		}))
+	})
+
+	ginkgo.DescribeTable("fails on destination service accounts", func(destinationServiceAccounts string, expectedError string) {
+		var out bytes.Buffer
+		err := argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: tenant
+spec:
+  destinationServiceAccounts:
+`+destinationServiceAccounts), &out)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without defaultServiceAccount", "    - namespace: tenant\n", "spec.destinationServiceAccounts[0] has no defaultServiceAccount"),
+		ginkgo.Entry("with repeated destinations", "    - defaultServiceAccount: a\n    - server: '*'\n      defaultServiceAccount: b\n", "spec.destinationServiceAccounts[1] repeats server * and namespace *"),
+	)
+
+	ginkgo.It("assigns namespaces", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: tenant
+spec:
+  namespace: platform
+  applicationNamespace: tenant-apps
+  applicationTemplates:
+    - metadata:
+        name: api
+      spec:
+        source:
+          repoURL: https://github.com/inloco/api.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: api
+    - metadata:
+        name: worker
+        namespace: tenant-workers
+      spec:
+        source:
+          repoURL: https://github.com/inloco/worker.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: worker
+`), argocdproject.Options{Namespace: "argocd"}, &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(3))
+
+		var appProject map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject["metadata"]).To(g.HaveKeyWithValue("namespace", "argocd"))
+		g.Expect(appProject["spec"]).To(g.HaveKeyWithValue("sourceNamespaces", []interface{}{"tenant-apps", "tenant-workers"}))
+
+		var namespaces []string
+		for _, manifest := range manifests[1:] {
+			var app argov1alpha1.Application
+			g.Expect(yaml.Unmarshal([]byte(manifest), &app)).To(g.Succeed())
+			namespaces = append(namespaces, app.Namespace)
+		}
+		g.Expect(namespaces).To(g.Equal([]string{"tenant-apps", "tenant-workers"}))
+	})
+
+	ginkgo.It("omits source namespaces of applications in the namespace of the project", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: tenant
+spec:
+  namespace: argocd
+  applicationTemplates:
+    - metadata:
+        name: api
+      spec:
+        source:
+          repoURL: https://github.com/inloco/api.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: api
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		var appProject map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject["metadata"]).To(g.HaveKeyWithValue("namespace", "argocd"))
+		g.Expect(appProject["spec"]).NotTo(g.HaveKey("sourceNamespaces"))
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+		g.Expect(app.Namespace).To(g.Equal("argocd"))
+	})
+
+	ginkgo.It("overrides the environment", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: staging
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`), argocdproject.Options{Environment: "production"}, &out)).To(g.Succeed())
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(separatorYaml.Split(out.String(), -1)[1]), &app)).To(g.Succeed())
+		g.Expect(app.Spec.Source.Path).To(g.Equal("./k8s/overlays/production"))
+		g.Expect(app.Spec.Source.TargetRevision).To(g.Equal("env-production"))
+	})
+
+	ginkgo.It("omits null fields and empty metadata", func() {
+		project := []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+        labels: {}
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+        syncPolicy:
+          automated: {}
+`)
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.ContainSubstring("creationTimestamp: null"))
+
+		out.Reset()
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{OmitEmpty: true}, &out)).To(g.Succeed())
+		g.Expect(out.String()).NotTo(g.ContainSubstring("creationTimestamp"))
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var app map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+		g.Expect(app).To(g.HaveKeyWithValue("metadata", map[string]interface{}{"name": "payroll"}))
+		g.Expect(app).To(g.HaveKeyWithValue("spec", g.HaveKeyWithValue("syncPolicy", map[string]interface{}{
+			"automated": map[string]interface{}{},
+		})))
+	})
+
+	ginkgo.It("applies defaults the project leaves unset", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: staging
+  accessControl:
+    readOnly:
+      - sre:eng-1
+`), argocdproject.Options{
+			Defaults: &argocdproject.ProjectSpec{
+				Environment: "production",
+				Namespace:   "argocd",
+				AccessControl: argocdproject.AppProjectAccessControl{
+					ReadOnly: []string{"sre:eng-0"},
+					ReadSync: []string{"sre:eng-0"},
+				},
+			},
+		}, &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Namespace).To(g.Equal("argocd"))
+		g.Expect(appProject.Spec.Roles).To(g.HaveLen(2))
+		g.Expect(appProject.Spec.Roles[0].Groups).To(g.Equal([]string{"sre:eng-1"}))
+		g.Expect(appProject.Spec.Roles[1].Groups).To(g.Equal([]string{"sre:eng-0"}))
+	})
+
+	ginkgo.DescribeTable("rejects files of other resources leniently", func(project string, expectedError string) {
+		err := argocdproject.GenerateManifests([]byte(project), ioutil.Discard)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without apiVersion nor kind", "metadata:\n  name: employees\n", ""),
+		ginkgo.Entry("of other versions", "apiVersion: incognia.com/v9\nkind: ArgoCDProject\nmetadata:\n  name: employees\n", "file is incognia.com/v9 ArgoCDProject instead of an ArgoCDProject of incognia.com/v1alpha1 or incognia.com/v1beta1, the only files the plugin generates manifests from"),
+		ginkgo.Entry("of other kinds", "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: employees\n", "file is apps/v1 Deployment instead of an ArgoCDProject of incognia.com/v1alpha1 or incognia.com/v1beta1, the only files the plugin generates manifests from"),
+		ginkgo.Entry("of other groups", "apiVersion: argoproj.io/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\n", "file is argoproj.io/v1alpha1 ArgoCDProject instead of an ArgoCDProject of incognia.com/v1alpha1 or incognia.com/v1beta1, the only files the plugin generates manifests from"),
+	)
+
+	ginkgo.DescribeTable("reads paths from the directory only", func(clusterRegistry string, expectedError string) {
+		err := argocdproject.GenerateManifestsWithOptions([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  clusterRegistry: `+clusterRegistry+`
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`), argocdproject.Options{Dir: "/repo/employees"}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError(g.ContainSubstring(expectedError)))
+	},
+		ginkgo.Entry("with relative paths", "clusters.yaml", "open /repo/employees/clusters.yaml"),
+		ginkgo.Entry("with absolute paths", "/etc/passwd", "open /repo/employees/etc/passwd"),
+		ginkgo.Entry("with paths outside of it", "../../etc/passwd", "spec.clusterRegistry: ../../etc/passwd is outside of /repo/employees"),
+	)
+
+	ginkgo.DescribeTable("validates environments", func(project string, options argocdproject.Options, expectedError string) {
+		err := argocdproject.GenerateManifestsWithOptions([]byte(project), options, ioutil.Discard)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("valid environments", "spec:\n  environment: us-production\n", argocdproject.Options{}, ""),
+		ginkgo.Entry("slashes", "spec:\n  environment: production/us\n", argocdproject.Options{}, `spec.environment "production/us" cannot have slashes, whitespace or ..`),
+		ginkgo.Entry("whitespace", "spec:\n  environments: [staging, us production]\n", argocdproject.Options{}, `spec.environments[1] "us production" cannot have slashes, whitespace or ..`),
+		ginkgo.Entry("path traversal", "spec:\n  environment: production\n", argocdproject.Options{Environment: ".."}, `spec.environment ".." cannot have slashes, whitespace or ..`),
+		ginkgo.Entry("annotations", "spec:\n  applicationTemplates:\n    - metadata:\n        name: payroll\n        annotations:\n          incognia.com/environment: ../production\n", argocdproject.Options{}, `application payroll: annotation incognia.com/environment "../production" cannot have slashes, whitespace or ..`),
+		ginkgo.Entry("other patterns", "spec:\n  environment: Production\n", argocdproject.Options{EnvironmentPattern: "^[a-z]+$"}, `spec.environment "Production" does not match ^[a-z]+$`),
+		ginkgo.Entry("reserved names", "spec:\n  environment: base\n", argocdproject.Options{ReservedEnvironments: []string{"base"}}, `spec.environment "base" is reserved`),
+	)
+
+	ginkgo.DescribeTable("requires groups in environments", func(project string, expectedError string) {
+		err := argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+			RequireGroupsEnvironments: []string{"production"},
+		}, ioutil.Discard)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with groups", "spec:\n  environment: production\n  accessControl:\n    ReadOnly: [sre:eng-0]\n    ReadSync: [sre:eng-1]\n", ""),
+		ginkgo.Entry("with groups of template roles", "spec:\n  environment: production\n  accessControl:\n    ReadSync: [sre:eng-1]\n  appProjectTemplate:\n    spec:\n      roles:\n        - name: read-only\n          groups: [sre:eng-0]\n", ""),
+		ginkgo.Entry("of other environments", "spec:\n  environment: staging\n", ""),
+		ginkgo.Entry("with access control disabled", "spec:\n  environment: production\n  accessControl:\n    disabled: true\n", ""),
+		ginkgo.Entry("without read-only groups", "spec:\n  environment: production\n  accessControl:\n    ReadSync: [sre:eng-1]\n", "spec.accessControl: role read-only has no groups, which environment production requires"),
+		ginkgo.Entry("without read-sync groups of fanned out environments", "spec:\n  environments: [staging, production]\n  accessControl:\n    ReadOnly: [sre:eng-0]\n", "spec.accessControl: role read-sync has no groups, which environment production requires"),
+	)
+
+	ginkgo.It("enforces quotas", func() {
+		project := []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments: [staging, production]
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`)
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{
+			MaxApplications: 2,
+		}, &out)).To(g.Succeed())
+		size := out.Len()
+
+		out.Reset()
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{
+			MaxApplications: 1,
+		}, &out)).To(g.MatchError("project generates 2 applications, more than the 1 allowed"))
+		g.Expect(out.Len()).To(g.BeZero())
+
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{
+			MaxOutputSize: size,
+		}, ioutil.Discard)).To(g.Succeed())
+
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{
+			MaxOutputSize: size - 1,
+		}, ioutil.Discard)).To(g.MatchError(fmt.Sprintf("project generates %d bytes, more than the %d allowed", size, size-1)))
+
+		g.Expect(argocdproject.GenerateManifestsWithOptions(project, argocdproject.Options{
+			MaxApplications: -1,
+		}, ioutil.Discard)).To(g.MatchError("max applications -1 is negative"))
+	})
+
+	ginkgo.Describe("projects without application templates", func() {
+		const project = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+`
+
+		ginkgo.It("are allowed by default", func() {
+			var out bytes.Buffer
+			g.Expect(argocdproject.GenerateManifests([]byte(project), &out)).To(g.Succeed())
+
+			var appProject argov1alpha1.AppProject
+			g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+			g.Expect(appProject.Spec.Destinations).To(g.BeEmpty())
+		})
+
+		ginkgo.It("are warned about", func() {
+			var logs bytes.Buffer
+			log.SetOutput(&logs)
+			ginkgo.DeferCleanup(log.SetOutput, os.Stderr)
+
+			g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+				EmptyTemplates: argocdproject.EmptyTemplatesWarn,
+			}, ioutil.Discard)).To(g.Succeed())
+			g.Expect(logs.String()).To(g.ContainSubstring("employees: spec.applicationTemplates is empty, so the AppProject denies every destination"))
+		})
+
+		ginkgo.It("fail", func() {
+			g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+				EmptyTemplates: argocdproject.EmptyTemplatesFail,
+			}, ioutil.Discard)).To(g.MatchError("spec.applicationTemplates is empty, so the AppProject would deny every destination"))
+		})
+
+		ginkgo.It("get the destinations of the defaults", func() {
+			destinations := []argov1alpha1.ApplicationDestination{
+				{Server: "https://kubernetes.default.svc", Namespace: "employees"},
+			}
+
+			var out bytes.Buffer
+			g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+				EmptyTemplates: argocdproject.EmptyTemplatesDefaults,
+				Defaults: &argocdproject.ProjectSpec{
+					AppProject: argov1alpha1.AppProject{
+						Spec: argov1alpha1.AppProjectSpec{
+							Destinations: destinations,
+						},
+					},
+				},
+			}, &out)).To(g.Succeed())
+
+			var appProject argov1alpha1.AppProject
+			g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+			g.Expect(appProject.Spec.Destinations).To(g.Equal(destinations))
+
+			g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+				EmptyTemplates: argocdproject.EmptyTemplatesDefaults,
+			}, ioutil.Discard)).To(g.MatchError("spec.applicationTemplates is empty and the defaults have no appProjectTemplate.spec.destinations"))
+		})
+
+		ginkgo.It("fail on unknown policies", func() {
+			g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+				EmptyTemplates: "ignore",
+			}, ioutil.Discard)).To(g.MatchError("unknown empty templates policy ignore"))
+		})
+	})
+
+	ginkgo.DescribeTable("validates strictly", func(project string, expectedError string) {
+		err := argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{Validation: argocdproject.ValidationStrict}, ioutil.Discard)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(g.ContainSubstring(expectedError)))
+	},
+		ginkgo.Entry("valid projects", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\n", ""),
+		ginkgo.Entry("unknown fields", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  enviroment: staging\n", `unknown field "enviroment"`),
+		ginkgo.Entry("other kinds", "apiVersion: incognia.com/v1alpha1\nkind: AppProject\nmetadata:\n  name: employees\n", `kind is "AppProject" instead of ArgoCDProject`),
+	)
+
+	ginkgo.DescribeTable("requires the fields Argo CD needs on application templates", func(project string, expectedError string) {
+		g.Expect(argocdproject.GenerateManifests([]byte(project), ioutil.Discard)).To(g.MatchError(g.ContainSubstring(expectedError)))
+	},
+		ginkgo.Entry("application templates without names", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  applicationTemplates:\n    - spec: {}\n", "spec.applicationTemplates[0].metadata.name is empty"),
+		ginkgo.Entry("application templates without destinations", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  applicationTemplates:\n    - metadata:\n        name: payroll\n", "application payroll: spec.applicationTemplates[0].spec.destination has neither server nor name"),
+		ginkgo.Entry("application templates without namespaces", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  applicationTemplates:\n    - metadata:\n        name: payroll\n      spec:\n        destination:\n          name: in-cluster\n", "application payroll: spec.applicationTemplates[0].spec.destination.namespace is empty"),
+		ginkgo.Entry("application templates without repositories", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  applicationTemplates:\n    - metadata:\n        name: payroll\n      spec:\n        destination:\n          name: in-cluster\n          namespace: payroll\n", "application payroll: spec.applicationTemplates[0].spec.source.repoURL is empty"),
+		ginkgo.Entry("every application template", "apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  applicationTemplates:\n    - metadata:\n        name: payroll\n      spec:\n        destination:\n          name: in-cluster\n    - metadata:\n        name: benefits\n      spec:\n        destination:\n          name: in-cluster\n          namespace: benefits\n", "[application payroll: spec.applicationTemplates[0].spec.destination.namespace is empty, application payroll: spec.applicationTemplates[0].spec.source.repoURL is empty, application benefits: spec.applicationTemplates[1].spec.source.repoURL is empty]"),
+	)
+
+	ginkgo.It("fans applications out to environments", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - staging
+    - production
+  accessControl:
+    readOnlyGroups:
+      - sre:eng-1
+  info:
+    - name: Environment
+      value: "{{environment}}"
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(3))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles[0].Groups).To(g.Equal([]string{"sre:eng-1"}))
+
+		for i, environment := range []string{"staging", "production"} {
+			var app argov1alpha1.Application
+			g.Expect(yaml.Unmarshal([]byte(manifests[i+1]), &app)).To(g.Succeed())
+			g.Expect(app.Name).To(g.Equal("payroll-" + environment))
+			g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/environment", environment))
+			g.Expect(app.Spec.Source.Path).To(g.Equal("./k8s/overlays/" + environment))
+			g.Expect(app.Spec.Source.TargetRevision).To(g.Equal("env-" + environment))
+			g.Expect(app.Spec.Info).To(g.Equal([]argov1alpha1.Info{{Name: "Environment", Value: environment}}))
+		}
+	})
+
+	ginkgo.DescribeTable("fails on environments", func(spec string, expectedError string) {
+		err := argocdproject.GenerateManifests([]byte("apiVersion: incognia.com/v1alpha1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n"+spec), ioutil.Discard)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with both environment fields", "  environment: staging\n  environments: [production]\n", "spec.environment and spec.environments are mutually exclusive"),
+		ginkgo.Entry("with repeated environments", "  environments: [staging, staging]\n", "spec.environments[1] repeats staging"),
+	)
+
+	ginkgo.It("rejects fields of other apiVersions strictly", func() {
+		err := argocdproject.GenerateManifestsWithOptions([]byte("apiVersion: incognia.com/v1beta1\nkind: ArgoCDProject\nmetadata:\n  name: employees\nspec:\n  environment: staging\n"), argocdproject.Options{Validation: argocdproject.ValidationStrict}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError(g.ContainSubstring(`unknown field "environment"`)))
+	})
+
+	ginkgo.It("fails on unknown validation levels", func() {
+		err := argocdproject.GenerateManifestsWithOptions([]byte("metadata:\n  name: employees\n"), argocdproject.Options{Validation: "pedantic"}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError("unknown validation level pedantic"))
+	})
+
+	ginkgo.It("fails on invalid namespaces", func() {
+		err := argocdproject.GenerateManifestsWithOptions([]byte("metadata:\n  name: tenant\n"), argocdproject.Options{Namespace: "Tenant_Apps"}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError(g.HavePrefix("spec.namespace Tenant_Apps is invalid: ")))
+	})
+
+	ginkgo.It("renders policies from templates", func() {
+		dir, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		policyTemplates := filepath.Join(dir, "policies.yaml")
+		g.Expect(ioutil.WriteFile(policyTemplates, []byte(`
+read-only:
+  - p, proj:{{project}}:{{role}}, applications, get, {{project}}/*, allow
+  - p, proj:{{project}}:{{role}}, logs, get, {{project}}/*, allow
+`), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    policyTemplates: `+policyTemplates+`
+`), &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal(out.Bytes(), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Spec.Roles[0].Policies).To(g.Equal([]string{
+			"p, proj:employees:read-only, applications, get, employees/*, allow",
+			"p, proj:employees:read-only, logs, get, employees/*, allow",
+		}))
+		g.Expect(appProject.Spec.Roles[1].Policies).To(g.Equal(argocdproject.ReadSync.Policies("employees")))
+
+		g.Expect(ioutil.WriteFile(policyTemplates, []byte("read-write: []\n"), 0644)).To(g.Succeed())
+		g.Expect(argocdproject.GenerateManifests([]byte("spec:\n  accessControl:\n    policyTemplates: "+policyTemplates+"\n"), &out)).To(g.MatchError(policyTemplates + ": unknown access level read-write"))
+	})
+
+	ginkgo.It("rejects policies injecting further lines", func() {
+		dir, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		policyTemplates := filepath.Join(dir, "policies.yaml")
+		g.Expect(ioutil.WriteFile(policyTemplates, []byte(`
+read-only:
+  - "p, proj:{{project}}:{{role}}, applications, get, {{project}}/*, allow\np, proj:{{project}}:{{role}}, *, *, */*, allow"
+`), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    policyTemplates: `+policyTemplates+`
+`), &out)).To(g.MatchError(`spec.accessControl: role read-only: policy "p, proj:employees:read-only, applications, get, employees/*, allow\np, proj:employees:read-only, *, *, */*, allow" spans many lines`))
+	})
+
+	ginkgo.It("merges project info into applications", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+  info:
+    - name: Dashboard
+      value: https://grafana.incognia.com/d/{{application}}?var-namespace={{namespace}}
+    - name: Runbook
+      value: https://runbooks.incognia.com/{{project}}
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        info:
+          - name: Owner
+            value: payments
+          - name: Runbook
+            value: https://runbooks.incognia.com/payroll
+        destination:
+          namespace: payroll
+          server: https://kubernetes.default.svc
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+		g.Expect(app.Spec.Info).To(g.Equal([]argov1alpha1.Info{
+			{
+				Name:  "Dashboard",
+				Value: "https://grafana.incognia.com/d/payroll?var-namespace=payroll",
+			},
+			{
+				Name:  "Runbook",
+				Value: "https://runbooks.incognia.com/payroll",
+			},
+			{
+				Name:  "Owner",
+				Value: "payments",
+			},
+		}))
+	})
+	ginkgo.It("merges the sanitized project name into application info", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: Employees
+spec:
+  info:
+    - name: Runbook
+      value: https://runbooks.incognia.com/{{project}}
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          namespace: payroll
+          server: https://kubernetes.default.svc
+`), &out)).To(g.Succeed())
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+		g.Expect(app.Spec.Project).NotTo(g.Equal("Employees"))
+		g.Expect(app.Spec.Info).To(g.Equal([]argov1alpha1.Info{
+			{
+				Name:  "Runbook",
+				Value: "https://runbooks.incognia.com/" + app.Spec.Project,
+			},
+		}))
+	})
+})
+
+func ArgoCDProject(argoCDProject argocdproject.ArgoCDProject) {
+	var argoCDProjectYaml []byte
+	if data, err := yaml.Marshal(argoCDProject); g.Expect(err).To(g.BeNil()) {
+		argoCDProjectYaml = data
+	}
+
+	ginkgo.By("contains only expected GKVs", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests(argoCDProjectYaml, &out)).To(g.Succeed())
+
+		var actualGVKs []schema.GroupVersionKind
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var meta metav1.TypeMeta
+			g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+			actualGVKs = append(actualGVKs, meta.GroupVersionKind())
+		}
+
+		var findings []schema.GroupVersionKind
+
+		g.Expect(actualGVKs).To(g.ContainElement(argov1alpha1.AppProjectSchemaGroupVersionKind, &findings))
+		g.Expect(findings).To(g.HaveLen(1))
+
+		g.Expect(actualGVKs).To(g.ContainElement(argov1alpha1.ApplicationSchemaGroupVersionKind, &findings))
+		g.Expect(findings).To(g.HaveLen(len(argoCDProject.Spec.ApplicationTemplates)))
+
+		g.Expect(actualGVKs).To(g.HaveLen(1 + len(argoCDProject.Spec.ApplicationTemplates)))
+	})
+
+	ginkgo.By("contains expected AppProject", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests(argoCDProjectYaml, &out)).To(g.Succeed())
+
+		var appProject argov1alpha1.AppProject
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var meta metav1.TypeMeta
+			g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+
+			if meta.GroupVersionKind() == argov1alpha1.AppProjectSchemaGroupVersionKind {
+				g.Expect(yaml.Unmarshal([]byte(manifest), &appProject)).To(g.Succeed())
+				break
+			}
+		}
+
+		destinations := argoCDProject.Spec.AppProject.Spec.Destinations
+		if destinations == nil {
+			destinationMap := make(map[string]argov1alpha1.ApplicationDestination)
+			for _, applicationTemplate := range argoCDProject.Spec.ApplicationTemplates {
+				destination := applicationTemplate.Spec.Destination
+				destinationMap[destination.String()] = destination
+			}
+
+			destinations = make([]argov1alpha1.ApplicationDestination, 0, len(destinationMap))
+			for _, destination := range destinationMap {
+				destinations = append(destinations, destination)
+			}
+		}
+		specDestinationsMatcher := g.And(
+			g.ContainElements(destinations),
+			g.HaveLen(len(destinations)),
+		)
+
+		g.Expect(appProject).To(gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+			"ObjectMeta": g.Equal(argoCDProject.ObjectMeta),
+			"Spec": gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+				"SourceRepos": g.Equal([]string{
+					"*",
+				}),
+				"Destinations":             specDestinationsMatcher,
+				"ClusterResourceWhitelist": g.Equal(argoCDProject.Spec.AppProject.Spec.ClusterResourceWhitelist),
+				"NamespaceResourceWhitelist": g.Equal([]metav1.GroupKind{{
+					Group: "*",
+					Kind:  "*",
+				}}),
+				"Roles": gstruct.MatchAllElements(func(e interface{}) string {
+					return e.(argov1alpha1.ProjectRole).Name
+				}, gstruct.Elements{
+					argocdproject.ReadOnly.String(): gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+						"Groups":   g.ContainElements(argoCDProject.Spec.AccessControl.ReadOnly),
+						"Policies": g.ContainElements(argocdproject.ReadOnly.Policies(argoCDProject.Name)),
+					}),
+					argocdproject.ReadSync.String(): gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+						"Groups":   g.ContainElements(argoCDProject.Spec.AccessControl.ReadSync),
+						"Policies": g.ContainElements(argocdproject.ReadSync.Policies(argoCDProject.Name)),
+					}),
+				}),
+			}),
+		}))
+	})
+
+	ginkgo.By("contains expected Applications", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests(argoCDProjectYaml, &out)).To(g.Succeed())
+
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var meta metav1.TypeMeta
+			g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+
+			if meta.GroupVersionKind() != argov1alpha1.ApplicationSchemaGroupVersionKind {
+				continue
+			}
+
+			var app argov1alpha1.Application
+			g.Expect(yaml.Unmarshal([]byte(manifest), &app)).To(g.Succeed())
+
+			var argoCdProjectApp argov1alpha1.Application
+			for _, appTemplate := range argoCDProject.Spec.ApplicationTemplates {
+				if app.Name == appTemplate.Name {
+					argoCdProjectApp = appTemplate
+					break
+				}
+			}
+
+			specSourcePathMatcher := g.Equal(argoCdProjectApp.Spec.Source.Path)
+			specSourceTargetRevisionMatcher := g.Equal(argoCdProjectApp.Spec.Source.TargetRevision)
+
+			if argoCDProject.Spec.Environment != "" {
+				specSourcePathMatcher = g.Equal(fmt.Sprintf("./k8s/overlays/%s", argoCDProject.Spec.Environment))
+				specSourceTargetRevisionMatcher = g.Equal(fmt.Sprintf("env-%s", argoCDProject.Spec.Environment))
+			}
+
+			g.Expect(app).To(gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+				"ObjectMeta": g.Equal(argoCdProjectApp.ObjectMeta),
+				"Spec": gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+					"Project": g.Equal(argoCDProject.Name),
+					"Source": gstruct.MatchFields(gstruct.IgnoreExtras, gstruct.Fields{
+						"RepoURL":        g.Equal(argoCdProjectApp.Spec.Source.RepoURL),
+						"Path":           specSourcePathMatcher,
+						"TargetRevision": specSourceTargetRevisionMatcher,
+					}),
+					"Destination": g.Equal(argoCdProjectApp.Spec.Destination),
+				}),
+			}))
+		}
+
+		ginkgo.By("manifests contain nil status field", func() {
+			var out bytes.Buffer
+			g.Expect(argocdproject.GenerateManifests(argoCDProjectYaml, &out)).To(g.Succeed())
+
+			for _, manifest := range separatorYaml.Split(out.String(), -1) {
+				var resource map[string]interface{}
+				g.Expect(yaml.Unmarshal([]byte(manifest), &resource)).To(g.Succeed())
+				g.Expect(resource["status"]).To(g.BeNil())
+			}
+		})
+	})
+}
@@ -0,0 +1,165 @@
+package argocdproject
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/argoproj/argo-cd/v2/cmpserver/apiclient"
+	"github.com/argoproj/argo-cd/v2/common"
+	"github.com/argoproj/argo-cd/v2/util/cmp"
+	"google.golang.org/grpc"
+)
+
+const (
+	cmpSocketName  = "argocdproject.sock"
+	cmpSocketType  = "unix"
+	cmpWorkPattern = "argocdproject"
+)
+
+// CMPServer serves the Config Management Plugin protocol of Argo CD on a
+// socket, as a sidecar of the repo server, generating the project files of
+// the path of each application until it is terminated.
+func CMPServer(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("cmp-server", flag.ContinueOnError)
+	socket := flags.String("socket", filepath.Join(common.GetPluginSockFilePath(), cmpSocketName), "path of the socket of the plugin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := os.Remove(*socket); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	listener, err := net.Listen(cmpSocketType, *socket)
+	if err != nil {
+		return err
+	}
+
+	server := NewCMPServer()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		server.GracefulStop()
+	}()
+
+	if _, err := fmt.Fprintf(out, "serving on %s\n", *socket); err != nil {
+		return err
+	}
+
+	return server.Serve(listener)
+}
+
+// NewCMPServer returns a gRPC server of the Config Management Plugin protocol
+// of Argo CD, for tools serving it on their own listeners.
+func NewCMPServer() *grpc.Server {
+	server := grpc.NewServer(
+		grpc.MaxRecvMsgSize(apiclient.MaxGRPCMessageSize),
+		grpc.MaxSendMsgSize(apiclient.MaxGRPCMessageSize),
+	)
+	apiclient.RegisterConfigManagementPluginServiceServer(server, &cmpService{})
+
+	return server
+}
+
+type cmpService struct{}
+
+// GenerateManifest generates the project files of the path of an application,
+// whose relative paths are read from it, as Kustomize runs the plugin there.
+func (s *cmpService) GenerateManifest(stream apiclient.ConfigManagementPluginService_GenerateManifestServer) error {
+	workDir, err := ioutil.TempDir(os.TempDir(), cmpWorkPattern)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	metadata, err := cmp.ReceiveRepoStream(stream.Context(), stream, workDir)
+	if err != nil {
+		return err
+	}
+
+	appPath, err := resolveAppPath(workDir, metadata.GetAppRelPath())
+	if err != nil {
+		return err
+	}
+
+	paths, err := findProjectFiles(appPath)
+	if err != nil {
+		return err
+	}
+
+	var manifests []string
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var out bytes.Buffer
+		if err := GenerateManifestsWithOptions(data, Options{Dir: appPath}, &out); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(path), err)
+		}
+
+		for _, manifest := range strings.Split(out.String(), yamlSeparator) {
+			if manifest != "" {
+				manifests = append(manifests, manifest)
+			}
+		}
+	}
+
+	return stream.SendAndClose(&apiclient.ManifestResponse{
+		Manifests: manifests,
+	})
+}
+
+// MatchRepository supports the applications whose path has project files.
+func (s *cmpService) MatchRepository(stream apiclient.ConfigManagementPluginService_MatchRepositoryServer) error {
+	workDir, err := ioutil.TempDir(os.TempDir(), cmpWorkPattern)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	metadata, err := cmp.ReceiveRepoStream(stream.Context(), stream, workDir)
+	if err != nil {
+		return err
+	}
+
+	appPath, err := resolveAppPath(workDir, metadata.GetAppRelPath())
+	if err != nil {
+		return err
+	}
+
+	paths, err := findProjectFiles(appPath)
+	if err != nil {
+		return err
+	}
+
+	return stream.SendAndClose(&apiclient.RepositoryResponse{
+		IsSupported: len(paths) > 0,
+	})
+}
+
+func resolveAppPath(workDir string, appRelPath string) (string, error) {
+	appPath, err := resolvePath(workDir, appRelPath)
+	if err != nil {
+		return "", fmt.Errorf("application path %s is outside of the repository", appRelPath)
+	}
+
+	return appPath, nil
+}
+
+func findProjectFiles(dir string) ([]string, error) {
+	return filepath.Glob(filepath.Join(dir, "*"+projectFileSuffix))
+}
@@ -0,0 +1,109 @@
+package argocdproject_test
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/argoproj/argo-cd/v2/cmpserver/apiclient"
+	"github.com/argoproj/argo-cd/v2/util/cmp"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("CMPServer", func() {
+	var repo string
+	var client apiclient.ConfigManagementPluginServiceClient
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+
+		repo = filepath.Join(d, "repo")
+		g.Expect(os.MkdirAll(filepath.Join(repo, "employees"), 0755)).To(g.Succeed())
+		g.Expect(os.MkdirAll(filepath.Join(repo, "docs"), 0755)).To(g.Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(repo, "docs", "README.md"), []byte("# Docs\n"), 0644)).To(g.Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(repo, "employees", "clusters.yaml"), []byte("clusters:\n  - name: Global-Product\n"), 0644)).To(g.Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(repo, "employees", "employees.argoCDProject.yaml"), []byte(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - production
+  clusterRegistry: clusters.yaml
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-*
+          namespace: payroll
+`), 0644)).To(g.Succeed())
+
+		listener, err := net.Listen("unix", filepath.Join(d, "argocdproject.sock"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		server := argocdproject.NewCMPServer()
+		go server.Serve(listener)
+		ginkgo.DeferCleanup(server.Stop)
+
+		conn, err := grpc.Dial("unix://"+listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(conn.Close)
+
+		client = apiclient.NewConfigManagementPluginServiceClient(conn)
+	})
+
+	ginkgo.It("generates the project files of applications", func() {
+		stream, err := client.GenerateManifest(context.Background())
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(cmp.SendRepoStream(context.Background(), filepath.Join(repo, "employees"), repo, stream, nil)).To(g.Succeed())
+
+		response, err := stream.CloseAndRecv()
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(response.Manifests).To(g.HaveLen(2))
+		g.Expect(response.Manifests[0]).To(g.ContainSubstring("kind: AppProject\n"))
+		g.Expect(response.Manifests[1]).To(g.ContainSubstring("name: Global-Product\n"))
+	})
+
+	ginkgo.It("reads the paths of project files from the application only", func() {
+		g.Expect(ioutil.WriteFile(filepath.Join(repo, "docs", "docs.argoCDProject.yaml"), []byte(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: docs
+spec:
+  clusterRegistry: ../employees/clusters.yaml
+`), 0644)).To(g.Succeed())
+
+		stream, err := client.GenerateManifest(context.Background())
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(cmp.SendRepoStream(context.Background(), filepath.Join(repo, "docs"), repo, stream, nil)).To(g.Succeed())
+
+		_, err = stream.CloseAndRecv()
+		g.Expect(err).To(g.MatchError(g.ContainSubstring("spec.clusterRegistry: ../employees/clusters.yaml is outside of")))
+	})
+
+	ginkgo.DescribeTable("matches applications with project files", func(app string, expectedSupported bool) {
+		stream, err := client.MatchRepository(context.Background())
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(cmp.SendRepoStream(context.Background(), filepath.Join(repo, app), repo, stream, nil)).To(g.Succeed())
+
+		response, err := stream.CloseAndRecv()
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(response.IsSupported).To(g.Equal(expectedSupported))
+	},
+		ginkgo.Entry("with project files", "employees", true),
+		ginkgo.Entry("without project files", "docs", false),
+	)
+})
@@ -0,0 +1,483 @@
+package argocdproject
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/restmapper"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	controllerName    = "argocdproject"
+	controllerWorkers = 2
+	controllerResync  = 10 * time.Minute
+
+	// ReadyCondition is true when the resources of an ArgoCDProject are up to
+	// date with its spec.
+	ReadyCondition = "Ready"
+
+	ReasonGenerated        = "Generated"
+	ReasonGenerationFailed = "GenerationFailed"
+	ReasonForbidden        = "Forbidden"
+	ReasonApplyFailed      = "ApplyFailed"
+
+	// ownerLabel is the UID of the ArgoCDProject of the resources created by
+	// the controller, which are deleted once no longer generated.
+	ownerLabel = "argocdproject.incognia.com/owner"
+)
+
+var (
+	// ownedGroupKinds are the kinds of the resources the controller always
+	// creates, while the others must be permitted.
+	ownedGroupKinds = []schema.GroupKind{
+		{Group: "argoproj.io", Kind: "AppProject"},
+		{Group: "argoproj.io", Kind: "Application"},
+	}
+)
+
+// ProjectStatus is the status of the ArgoCDProject resources reconciled by
+// the controller.
+type ProjectStatus struct {
+	ObservedGeneration int64              `json:"observedGeneration,omitempty"`
+	Conditions         []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// Permissions bound the resources the controller creates from ArgoCDProject
+// resources, which tenants may write, to AppProjects and Applications in the
+// namespace of their ArgoCDProject, unless they permit more.
+type Permissions struct {
+	// GroupKinds are the kinds created besides AppProjects and Applications,
+	// such as the ServiceAccount, Role, RoleBinding and CronJob of the cleanup
+	// of break-glass roles.
+	GroupKinds []schema.GroupKind
+	// Namespaces are the namespaces resources are created in besides the
+	// namespace of their ArgoCDProject.
+	Namespaces []string
+	// AppProjectTemplates permits spec.appProjectTemplate, whose spec may grant
+	// any repository, destination or cluster resource.
+	AppProjectTemplates bool
+}
+
+// ProjectResource returns the resource of ArgoCDProject in its latest
+// apiVersion, as served by the CustomResourceDefinition of Schema.
+func ProjectResource() schema.GroupVersionResource {
+	return schema.FromAPIVersionAndKind(projectAPIVersion, "").GroupVersion().WithResource(makeProjectNames().Plural)
+}
+
+// Controller reconciles the ArgoCDProject resources of a cluster, creating and
+// updating their AppProject and Applications, until it is terminated.
+func Controller(args []string, out io.Writer) error {
+	var options Options
+	flags := flag.NewFlagSet("controller", flag.ContinueOnError)
+	namespace := flags.String("namespace", metav1.NamespaceAll, "namespace of the ArgoCDProject resources reconciled, all of them unless given")
+	workers := flags.Int("workers", controllerWorkers, "number of ArgoCDProject resources reconciled concurrently")
+	kubeContext := flags.String("context", "", "context of the kubeconfig, the current one unless given")
+	flags.StringVar(&options.Dir, "dir", "", "directory the relative paths of ArgoCDProject resources are read from")
+	allowKinds := flags.String("allow-kinds", "", "comma-separated kinds, as Kind.group, created besides AppProjects and Applications")
+	allowNamespaces := flags.String("allow-namespaces", "", "comma-separated namespaces resources are created in besides the namespace of their ArgoCDProject")
+	var permissions Permissions
+	flags.BoolVar(&permissions.AppProjectTemplates, "allow-appproject-templates", false, "permit spec.appProjectTemplate, which may grant any repository, destination or cluster resource")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *allowKinds != "" {
+		for _, kind := range strings.Split(*allowKinds, ",") {
+			permissions.GroupKinds = append(permissions.GroupKinds, schema.ParseGroupKind(kind))
+		}
+	}
+
+	if *allowNamespaces != "" {
+		permissions.Namespaces = strings.Split(*allowNamespaces, ",")
+	}
+
+	restConfig, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{
+			CurrentContext: *kubeContext,
+		},
+	).ClientConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := dynamic.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+	mapper := restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	if _, err := fmt.Fprintf(out, "reconciling %s\n", ProjectResource().GroupResource()); err != nil {
+		return err
+	}
+
+	return NewController(client, mapper, options, permissions).Run(ctx, *namespace, *workers)
+}
+
+// ProjectController creates and updates the resources generated from
+// ArgoCDProject resources, reporting the outcome as their Ready condition.
+// Resources in the namespace of their ArgoCDProject are owned by it, so they
+// are deleted along with it, and resources no longer generated are deleted.
+type ProjectController struct {
+	client      dynamic.Interface
+	mapper      meta.RESTMapper
+	options     Options
+	permissions Permissions
+}
+
+// NewController returns a controller generating resources with options, whose
+// Dir is where the relative paths of ArgoCDProject resources are read from,
+// creating only the resources of permissions.
+func NewController(client dynamic.Interface, mapper meta.RESTMapper, options Options, permissions Permissions) *ProjectController {
+	return &ProjectController{
+		client:      client,
+		mapper:      mapper,
+		options:     options,
+		permissions: permissions,
+	}
+}
+
+// Run reconciles the ArgoCDProject resources of a namespace, or of every
+// namespace when it is empty, until ctx is done.
+func (c *ProjectController) Run(ctx context.Context, namespace string, workers int) error {
+	queue := workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), controllerName)
+	defer queue.ShutDown()
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(c.client, ProjectResource(), namespace, controllerResync, cache.Indexers{}, nil).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			enqueue(queue, obj)
+		},
+		UpdateFunc: func(oldObj interface{}, newObj interface{}) {
+			// Updates of the status alone are made by the controller itself.
+			oldMeta, newMeta := oldObj.(metav1.Object), newObj.(metav1.Object)
+			if oldMeta.GetGeneration() == newMeta.GetGeneration() && oldMeta.GetResourceVersion() != newMeta.GetResourceVersion() {
+				return
+			}
+			enqueue(queue, newObj)
+		},
+	})
+
+	go informer.Run(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return ctx.Err()
+	}
+
+	for i := 0; i < workers; i++ {
+		go func() {
+			for c.processNextItem(ctx, queue) {
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	return nil
+}
+
+func enqueue(queue workqueue.Interface, obj interface{}) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	queue.Add(key)
+}
+
+func (c *ProjectController) processNextItem(ctx context.Context, queue workqueue.RateLimitingInterface) bool {
+	key, shutdown := queue.Get()
+	if shutdown {
+		return false
+	}
+	defer queue.Done(key)
+
+	namespace, name, err := cache.SplitMetaNamespaceKey(key.(string))
+	if err == nil {
+		err = c.Reconcile(ctx, namespace, name)
+	}
+	if err != nil {
+		log.Printf("%s: %s", key, err)
+		queue.AddRateLimited(key)
+		return true
+	}
+
+	queue.Forget(key)
+	return true
+}
+
+// Reconcile creates and updates the resources of an ArgoCDProject and sets
+// its Ready condition, returning errors worth retrying, such as those of the
+// API server, but not those of generation, which only a new spec fixes.
+func (c *ProjectController) Reconcile(ctx context.Context, namespace string, name string) error {
+	project, err := c.client.Resource(ProjectResource()).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	condition := metav1.Condition{
+		Type:    ReadyCondition,
+		Status:  metav1.ConditionTrue,
+		Reason:  ReasonGenerated,
+		Message: "resources are up to date",
+	}
+
+	objects, err := c.generate(project)
+	if err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonGenerationFailed, err.Error()
+		return c.updateStatus(ctx, project, condition)
+	}
+
+	if err := c.authorize(project, objects); err != nil {
+		condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonForbidden, err.Error()
+		return c.updateStatus(ctx, project, condition)
+	}
+
+	var applyErr error
+	for _, object := range objects {
+		if err := c.apply(ctx, project, object); err != nil {
+			applyErr = fmt.Errorf("%s %s: %w", object.GetKind(), object.GetName(), err)
+			condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonApplyFailed, applyErr.Error()
+			break
+		}
+	}
+
+	// resources are only pruned once every generated one is applied, so
+	// failures never leave projects without them
+	if applyErr == nil {
+		if err := c.prune(ctx, project, objects); err != nil {
+			applyErr = err
+			condition.Status, condition.Reason, condition.Message = metav1.ConditionFalse, ReasonApplyFailed, applyErr.Error()
+		}
+	}
+
+	if err := c.updateStatus(ctx, project, condition); err != nil {
+		return err
+	}
+
+	return applyErr
+}
+
+func (c *ProjectController) generate(project *unstructured.Unstructured) ([]*unstructured.Unstructured, error) {
+	data, err := json.Marshal(project.Object)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	if err := GenerateManifestsWithOptions(data, c.options, &out); err != nil {
+		return nil, err
+	}
+
+	var objects []*unstructured.Unstructured
+	for _, manifest := range strings.Split(out.String(), yamlSeparator) {
+		if manifest == "" {
+			continue
+		}
+
+		object := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(manifest), &object.Object); err != nil {
+			return nil, err
+		}
+		objects = append(objects, object)
+	}
+
+	return objects, nil
+}
+
+// authorize fails on projects generating resources their permissions do not
+// permit, setting the namespace of the namespaced resources without one.
+func (c *ProjectController) authorize(project *unstructured.Unstructured, objects []*unstructured.Unstructured) error {
+	if appProjectTemplate, _, _ := unstructured.NestedMap(project.Object, "spec", "appProjectTemplate"); len(appProjectTemplate) > 0 && !c.permissions.AppProjectTemplates {
+		return fmt.Errorf("spec.appProjectTemplate is not permitted")
+	}
+
+	groupKinds := append(append([]schema.GroupKind(nil), ownedGroupKinds...), c.permissions.GroupKinds...)
+	namespaces := append([]string{project.GetNamespace()}, c.permissions.Namespaces...)
+	for _, object := range objects {
+		gvk := object.GroupVersionKind()
+		if !containsSchemaGroupKind(groupKinds, gvk.GroupKind()) {
+			return fmt.Errorf("%s %s: kind %s is not permitted", object.GetKind(), object.GetName(), gvk.GroupKind())
+		}
+
+		mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return err
+		}
+		if mapping.Scope.Name() != meta.RESTScopeNameNamespace {
+			continue
+		}
+
+		if object.GetNamespace() == "" {
+			object.SetNamespace(project.GetNamespace())
+		}
+		if !containsString(namespaces, object.GetNamespace()) {
+			return fmt.Errorf("%s %s: namespace %s is not permitted", object.GetKind(), object.GetName(), object.GetNamespace())
+		}
+	}
+
+	return nil
+}
+
+// apply creates or updates an object, keeping the status of existing ones,
+// which Argo CD writes to Applications.
+func (c *ProjectController) apply(ctx context.Context, project *unstructured.Unstructured, object *unstructured.Unstructured) error {
+	gvk := object.GroupVersionKind()
+	mapping, err := c.mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+	if err != nil {
+		return err
+	}
+
+	labels := object.GetLabels()
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	labels[ownerLabel] = string(project.GetUID())
+	object.SetLabels(labels)
+
+	var resource dynamic.ResourceInterface = c.client.Resource(mapping.Resource)
+	if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+		if object.GetNamespace() == project.GetNamespace() {
+			object.SetOwnerReferences([]metav1.OwnerReference{
+				*metav1.NewControllerRef(project, project.GroupVersionKind()),
+			})
+		}
+		resource = c.client.Resource(mapping.Resource).Namespace(object.GetNamespace())
+	}
+
+	existing, err := resource.Get(ctx, object.GetName(), metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err := resource.Create(ctx, object, metav1.CreateOptions{FieldManager: controllerName})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	object.SetResourceVersion(existing.GetResourceVersion())
+	if status, exists := existing.Object[yamlStatusField]; exists {
+		object.Object[yamlStatusField] = status
+	}
+
+	_, err = resource.Update(ctx, object, metav1.UpdateOptions{FieldManager: controllerName})
+	return err
+}
+
+// prune deletes the resources of a project, found by their ownerLabel, that
+// are no longer generated.
+func (c *ProjectController) prune(ctx context.Context, project *unstructured.Unstructured, objects []*unstructured.Unstructured) error {
+	generated := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		generated[resourceKey(object)] = true
+	}
+
+	selector := metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", ownerLabel, project.GetUID()),
+	}
+
+	namespaces := append([]string{project.GetNamespace()}, c.permissions.Namespaces...)
+	for _, groupKind := range append(append([]schema.GroupKind(nil), ownedGroupKinds...), c.permissions.GroupKinds...) {
+		mapping, err := c.mapper.RESTMapping(groupKind)
+		if err != nil {
+			return err
+		}
+
+		var resources []dynamic.ResourceInterface
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			for _, namespace := range namespaces {
+				resources = append(resources, c.client.Resource(mapping.Resource).Namespace(namespace))
+			}
+		} else {
+			resources = append(resources, c.client.Resource(mapping.Resource))
+		}
+
+		for _, resource := range resources {
+			list, err := resource.List(ctx, selector)
+			if err != nil {
+				return err
+			}
+
+			for i := range list.Items {
+				object := &list.Items[i]
+				if generated[resourceKey(object)] {
+					continue
+				}
+
+				if err := resource.Delete(ctx, object.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+					return fmt.Errorf("%s %s: %w", object.GetKind(), object.GetName(), err)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func resourceKey(object *unstructured.Unstructured) string {
+	return strings.Join([]string{object.GroupVersionKind().GroupKind().String(), object.GetNamespace(), object.GetName()}, "/")
+}
+
+func containsSchemaGroupKind(slice []schema.GroupKind, groupKind schema.GroupKind) bool {
+	for _, item := range slice {
+		if item == groupKind {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *ProjectController) updateStatus(ctx context.Context, project *unstructured.Unstructured, condition metav1.Condition) error {
+	var status ProjectStatus
+	if object, exists := project.Object[yamlStatusField].(map[string]interface{}); exists {
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object, &status); err != nil {
+			return err
+		}
+	}
+
+	status.ObservedGeneration = project.GetGeneration()
+	condition.ObservedGeneration = project.GetGeneration()
+	meta.SetStatusCondition(&status.Conditions, condition)
+
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&status)
+	if err != nil {
+		return err
+	}
+	project.Object[yamlStatusField] = object
+
+	_, err = c.client.Resource(ProjectResource()).Namespace(project.GetNamespace()).UpdateStatus(ctx, project, metav1.UpdateOptions{FieldManager: controllerName})
+	return err
+}
@@ -0,0 +1,190 @@
+package argocdproject_test
+
+import (
+	"context"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("ProjectController", func() {
+	var (
+		appProjectResource  = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "appprojects"}
+		applicationResource = schema.GroupVersionResource{Group: "argoproj.io", Version: "v1alpha1", Resource: "applications"}
+	)
+
+	newProject := func(manifest string) *unstructured.Unstructured {
+		project := &unstructured.Unstructured{}
+		g.Expect(yaml.Unmarshal([]byte(manifest), &project.Object)).To(g.Succeed())
+		project.SetGeneration(2)
+		return project
+	}
+
+	newController := func(permissions argocdproject.Permissions, objects ...runtime.Object) (*argocdproject.ProjectController, *fake.FakeDynamicClient) {
+		client := fake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+			argocdproject.ProjectResource(): "ArgoCDProjectList",
+			appProjectResource:              "AppProjectList",
+			applicationResource:             "ApplicationList",
+		}, objects...)
+
+		mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{appProjectResource.GroupVersion()})
+		mapper.Add(appProjectResource.GroupVersion().WithKind("AppProject"), meta.RESTScopeNamespace)
+		mapper.Add(applicationResource.GroupVersion().WithKind("Application"), meta.RESTScopeNamespace)
+
+		return argocdproject.NewController(client, mapper, argocdproject.Options{}, permissions), client
+	}
+
+	nestedString := func(object *unstructured.Unstructured, fields ...string) string {
+		value, _, err := unstructured.NestedString(object.Object, fields...)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		return value
+	}
+
+	readyCondition := func(client *fake.FakeDynamicClient) *metav1.Condition {
+		project, err := client.Resource(argocdproject.ProjectResource()).Namespace("argocd").Get(context.Background(), "employees", metav1.GetOptions{})
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var status argocdproject.ProjectStatus
+		g.Expect(runtime.DefaultUnstructuredConverter.FromUnstructured(project.Object["status"].(map[string]interface{}), &status)).To(g.Succeed())
+		g.Expect(status.ObservedGeneration).To(g.Equal(int64(2)))
+
+		return meta.FindStatusCondition(status.Conditions, argocdproject.ReadyCondition)
+	}
+
+	const project = `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+  namespace: argocd
+  uid: 6a0e2f1c-0b9e-4a47-9d55-4ec37d4d1f2a
+spec:
+  environments:
+    - production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`
+
+	ginkgo.It("creates the resources of projects", func() {
+		controller, client := newController(argocdproject.Permissions{}, newProject(project))
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+
+		appProject, err := client.Resource(appProjectResource).Namespace("argocd").Get(context.Background(), "employees", metav1.GetOptions{})
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(appProject.GetOwnerReferences()).To(g.HaveLen(1))
+		g.Expect(appProject.GetOwnerReferences()[0].Name).To(g.Equal("employees"))
+		g.Expect(*appProject.GetOwnerReferences()[0].Controller).To(g.BeTrue())
+
+		app, err := client.Resource(applicationResource).Namespace("argocd").Get(context.Background(), "payroll", metav1.GetOptions{})
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(nestedString(app, "spec", "source", "path")).To(g.Equal("./k8s/overlays/production"))
+
+		condition := readyCondition(client)
+		g.Expect(condition.Status).To(g.Equal(metav1.ConditionTrue))
+		g.Expect(condition.Reason).To(g.Equal(argocdproject.ReasonGenerated))
+	})
+
+	ginkgo.It("updates the resources of projects, keeping their status", func() {
+		app := &unstructured.Unstructured{}
+		app.SetGroupVersionKind(applicationResource.GroupVersion().WithKind("Application"))
+		app.SetNamespace("argocd")
+		app.SetName("payroll")
+		g.Expect(unstructured.SetNestedField(app.Object, "./k8s/overlays/staging", "spec", "source", "path")).To(g.Succeed())
+		g.Expect(unstructured.SetNestedField(app.Object, "Synced", "status", "sync", "status")).To(g.Succeed())
+
+		controller, client := newController(argocdproject.Permissions{}, newProject(project), app)
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+
+		app, err := client.Resource(applicationResource).Namespace("argocd").Get(context.Background(), "payroll", metav1.GetOptions{})
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(nestedString(app, "spec", "source", "path")).To(g.Equal("./k8s/overlays/production"))
+		g.Expect(nestedString(app, "status", "sync", "status")).To(g.Equal("Synced"))
+	})
+
+	ginkgo.It("reports projects failing to generate", func() {
+		invalid := newProject(project)
+		g.Expect(unstructured.SetNestedStringSlice(invalid.Object, []string{"production", "production"}, "spec", "environments")).To(g.Succeed())
+
+		controller, client := newController(argocdproject.Permissions{}, invalid)
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+
+		condition := readyCondition(client)
+		g.Expect(condition.Status).To(g.Equal(metav1.ConditionFalse))
+		g.Expect(condition.Reason).To(g.Equal(argocdproject.ReasonGenerationFailed))
+		g.Expect(condition.Message).To(g.Equal("spec.environments[1] repeats production"))
+
+		_, err := client.Resource(appProjectResource).Namespace("argocd").Get(context.Background(), "employees", metav1.GetOptions{})
+		g.Expect(err).To(g.HaveOccurred())
+	})
+
+	ginkgo.It("deletes the resources no longer generated", func() {
+		newApp := func(name string, labels map[string]string) *unstructured.Unstructured {
+			app := &unstructured.Unstructured{}
+			app.SetGroupVersionKind(applicationResource.GroupVersion().WithKind("Application"))
+			app.SetNamespace("argocd")
+			app.SetName(name)
+			app.SetLabels(labels)
+			return app
+		}
+
+		controller, client := newController(argocdproject.Permissions{},
+			newProject(project),
+			newApp("timesheets", map[string]string{"argocdproject.incognia.com/owner": "6a0e2f1c-0b9e-4a47-9d55-4ec37d4d1f2a"}),
+			newApp("hiring", map[string]string{"argocdproject.incognia.com/owner": "9b1c3d4e-0000-4000-8000-000000000000"}),
+			newApp("benefits", nil),
+		)
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+
+		apps, err := client.Resource(applicationResource).Namespace("argocd").List(context.Background(), metav1.ListOptions{})
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var names []string
+		for _, app := range apps.Items {
+			names = append(names, app.GetName())
+		}
+		g.Expect(names).To(g.ConsistOf("payroll", "hiring", "benefits"))
+	})
+
+	ginkgo.DescribeTable("creates only the permitted resources", func(spec string, permissions argocdproject.Permissions, expectedMessage string) {
+		controller, client := newController(permissions, newProject(project+spec))
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+
+		condition := readyCondition(client)
+		if expectedMessage == "" {
+			g.Expect(condition.Reason).To(g.Equal(argocdproject.ReasonGenerated))
+			return
+		}
+		g.Expect(condition.Status).To(g.Equal(metav1.ConditionFalse))
+		g.Expect(condition.Reason).To(g.Equal(argocdproject.ReasonForbidden))
+		g.Expect(condition.Message).To(g.Equal(expectedMessage))
+
+		_, err := client.Resource(appProjectResource).Namespace("argocd").Get(context.Background(), "employees", metav1.GetOptions{})
+		g.Expect(err).To(g.HaveOccurred())
+	},
+		ginkgo.Entry("in other namespaces", "  namespace: kube-system\n", argocdproject.Permissions{}, "AppProject employees: namespace kube-system is not permitted"),
+		ginkgo.Entry("in permitted namespaces", "  namespace: argocd-apps\n", argocdproject.Permissions{Namespaces: []string{"argocd-apps"}}, ""),
+		ginkgo.Entry("with AppProject templates", "  appProjectTemplate:\n    spec:\n      sourceRepos:\n        - '*'\n", argocdproject.Permissions{}, "spec.appProjectTemplate is not permitted"),
+		ginkgo.Entry("with permitted AppProject templates", "  appProjectTemplate:\n    spec:\n      sourceRepos:\n        - '*'\n", argocdproject.Permissions{AppProjectTemplates: true}, ""),
+	)
+
+	ginkgo.It("ignores deleted projects", func() {
+		controller, _ := newController(argocdproject.Permissions{})
+		g.Expect(controller.Reconcile(context.Background(), "argocd", "employees")).To(g.Succeed())
+	})
+})
@@ -0,0 +1,197 @@
+package argocdproject
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// conversion rewrites a project file of an apiVersion to the next one,
+// renaming its fields and migrating its deprecated blocks.
+type conversion struct {
+	from    string
+	to      string
+	convert func(node *kyaml.RNode) error
+}
+
+const (
+	accessControlField = "accessControl"
+	environmentField   = "environment"
+	environmentsField  = "environments"
+)
+
+var (
+	// conversions chain the previous apiVersions of ArgoCDProject up to the
+	// latest one, and grow as its schema evolves.
+	conversions = []conversion{
+		{from: v1alpha1APIVersion, to: v1beta1APIVersion, convert: convertV1alpha1ToV1beta1},
+	}
+
+	// v1beta1AccessControlFields are the fields of accessControl renamed by
+	// v1beta1, as the groups of each access level.
+	v1beta1AccessControlFields = []struct {
+		from string
+		to   string
+	}{
+		{"readOnly", "readOnlyGroups"},
+		{"readSync", "readSyncGroups"},
+		{"overrideParameters", "overrideParametersGroups"},
+	}
+)
+
+// Convert rewrites project files to the latest apiVersion, keeping their
+// comments, and writes them to out, or in place with -w.
+func Convert(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	write := flags.Bool("w", false, "write the converted files in place")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no paths to convert")
+	}
+
+	for _, path := range flags.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		node, err := kyaml.Parse(string(data))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		converted, err := convertProject(node)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		s, err := node.String()
+		if err != nil {
+			return err
+		}
+
+		if !*write {
+			if _, err := io.WriteString(out, yamlSeparator+s); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !converted {
+			continue
+		}
+
+		if err := ioutil.WriteFile(path, []byte(s), projectFilePerm); err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "converted %s\n", path); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func convertProject(node *kyaml.RNode) (bool, error) {
+	if kind := reflect.TypeOf(ArgoCDProject{}).Name(); node.GetKind() != kind {
+		return false, fmt.Errorf("kind is %q instead of %s", node.GetKind(), kind)
+	}
+
+	// Files without apiVersion are decoded as v1alpha1, so they are converted
+	// from it, with the apiVersion first as in files written by hand.
+	converted := false
+	if node.GetApiVersion() == "" {
+		node.YNode().Content = append([]*kyaml.Node{
+			kyaml.NewStringRNode(kyaml.APIVersionField).YNode(),
+			kyaml.NewStringRNode(v1alpha1APIVersion).YNode(),
+		}, node.YNode().Content...)
+		converted = true
+	}
+
+	for node.GetApiVersion() != projectAPIVersion {
+		conversion := findConversion(node.GetApiVersion())
+		if conversion == nil {
+			return false, fmt.Errorf("unknown apiVersion %q", node.GetApiVersion())
+		}
+
+		if err := conversion.convert(node); err != nil {
+			return false, fmt.Errorf("%s to %s: %w", conversion.from, conversion.to, err)
+		}
+
+		node.SetApiVersion(conversion.to)
+		converted = true
+	}
+
+	return converted, nil
+}
+
+func findConversion(apiVersion string) *conversion {
+	for i := range conversions {
+		if conversions[i].from == apiVersion {
+			return &conversions[i]
+		}
+	}
+
+	return nil
+}
+
+// convertV1alpha1ToV1beta1 renames the groups of the access levels and turns
+// the environment of the project into its list of environments.
+func convertV1alpha1ToV1beta1(node *kyaml.RNode) error {
+	_, spec := findField(node.YNode(), yamlSpecField)
+	if spec == nil {
+		return nil
+	}
+
+	if _, accessControl := findField(spec, accessControlField); accessControl != nil {
+		for _, field := range v1beta1AccessControlFields {
+			if key, _ := findField(accessControl, field.from); key != nil {
+				key.Value = field.to
+			}
+		}
+	}
+
+	key, value := findField(spec, environmentField)
+	if key == nil {
+		return nil
+	}
+
+	if value.Kind != kyaml.ScalarNode {
+		return fmt.Errorf("spec.%s is not a string", environmentField)
+	}
+
+	environment := *value
+	key.Value = environmentsField
+	*value = kyaml.Node{
+		Kind:    kyaml.SequenceNode,
+		Tag:     kyaml.NodeTagSeq,
+		Content: []*kyaml.Node{&environment},
+	}
+
+	return nil
+}
+
+// findField returns the key and value of a field of a mapping, matched
+// case-insensitively as project files are decoded.
+func findField(node *kyaml.Node, name string) (*kyaml.Node, *kyaml.Node) {
+	if node.Kind != kyaml.MappingNode {
+		return nil, nil
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if strings.EqualFold(node.Content[i].Value, name) {
+			return node.Content[i], node.Content[i+1]
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,113 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+const (
+	v1alpha1Project = `apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  # Production only.
+  environment: production # until the migration
+  accessControl:
+    ReadOnly:
+      - sre:eng-1
+    readSync:
+      - sre:eng-0
+`
+	latestProject = `apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  # Production only.
+  environments:
+  - production # until the migration
+  accessControl:
+    readOnlyGroups:
+    - sre:eng-1
+    readSyncGroups:
+    - sre:eng-0
+`
+)
+
+var _ = ginkgo.Describe("Convert", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+	})
+
+	write := func(data string) string {
+		path := filepath.Join(dir, "employees.argoCDProject.yaml")
+		g.Expect(ioutil.WriteFile(path, []byte(data), 0644)).To(g.Succeed())
+		return path
+	}
+
+	ginkgo.It("keeps files of the latest apiVersion", func() {
+		path := write(latestProject)
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Convert([]string{path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.Equal("---\n" + latestProject))
+
+		out.Reset()
+		g.Expect(argocdproject.Convert([]string{"-w", path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.BeEmpty())
+	})
+
+	ginkgo.It("converts files of previous apiVersions", func() {
+		path := write(v1alpha1Project)
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Convert([]string{path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.Equal("---\n" + latestProject))
+
+		out.Reset()
+		g.Expect(argocdproject.Convert([]string{"-w", path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.Equal("converted " + path + "\n"))
+
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.Equal(latestProject))
+
+		var v1alpha1Manifests, latestManifests bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(v1alpha1Project), &v1alpha1Manifests)).To(g.Succeed())
+		g.Expect(argocdproject.GenerateManifests(data, &latestManifests)).To(g.Succeed())
+		g.Expect(latestManifests.String()).To(g.Equal(v1alpha1Manifests.String()))
+	})
+
+	ginkgo.It("converts files without apiVersion from v1alpha1", func() {
+		path := write(strings.TrimPrefix(v1alpha1Project, "apiVersion: incognia.com/v1alpha1\n"))
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Convert([]string{"-w", path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.Equal("converted " + path + "\n"))
+
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.Equal(latestProject))
+	})
+
+	ginkgo.DescribeTable("fails", func(data string, expectedError string) {
+		path := write(data)
+		g.Expect(argocdproject.Convert([]string{path}, ioutil.Discard)).To(g.MatchError(path + ": " + expectedError))
+	},
+		ginkgo.Entry("with unknown apiVersion", "apiVersion: incognia.com/v2\nkind: ArgoCDProject\n", `unknown apiVersion "incognia.com/v2"`),
+		ginkgo.Entry("with other kinds", "apiVersion: incognia.com/v1alpha1\nkind: Namespace\n", `kind is "Namespace" instead of ArgoCDProject`),
+	)
+})
@@ -0,0 +1,348 @@
+package argocdproject
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+)
+
+const (
+	pathSeparator    = "."
+	nameField        = "name"
+	decoderBufferLen = 4096
+)
+
+var (
+	// ErrDifferences is returned by Diff when the generated manifests differ
+	// from the rendered ones.
+	ErrDifferences = errors.New("generated manifests differ from rendered ones")
+
+	renderedExtensions = []string{".yaml", ".yml"}
+)
+
+// Change is a field whose value differs between the rendered and generated
+// manifests, where a nil value means the field or element is absent.
+type Change struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// Diff generates project files in memory, with the same flags as the plugin,
+// and writes the fields that differ from the manifests rendered in the
+// directory of -against.
+func Diff(args []string, out io.Writer) error {
+	var options Options
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	against := flags.String("against", "", "directory of the rendered manifests")
+	completeOptions := options.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := completeOptions(); err != nil {
+		return err
+	}
+
+	if *against == "" {
+		return fmt.Errorf("-against is required")
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no paths to diff")
+	}
+
+	var generated bytes.Buffer
+	for _, path := range flags.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if err := GenerateManifestsWithOptions(data, options, &generated); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	after, err := decodeObjects(generated.Bytes())
+	if err != nil {
+		return err
+	}
+
+	before, err := readRenderedObjects(*against)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(before)+len(after))
+	for key := range before {
+		keys = append(keys, key)
+	}
+	for key := range after {
+		if _, exists := before[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	differs := false
+	for _, key := range keys {
+		var line string
+		var changes []Change
+
+		switch {
+		case before[key] == nil:
+			line = fmt.Sprintf("+ %s\n", key)
+		case after[key] == nil:
+			line = fmt.Sprintf("- %s\n", key)
+		default:
+			compare("", before[key], after[key], &changes)
+			if len(changes) == 0 {
+				continue
+			}
+			line = fmt.Sprintf("~ %s\n", key)
+		}
+		differs = true
+
+		if _, err := io.WriteString(out, line); err != nil {
+			return err
+		}
+
+		for _, change := range changes {
+			if _, err := fmt.Fprintf(out, "    %s: %s -> %s\n", change.Path, formatValue(change.Before), formatValue(change.After)); err != nil {
+				return err
+			}
+		}
+	}
+
+	if differs {
+		return ErrDifferences
+	}
+
+	return nil
+}
+
+func readRenderedObjects(dir string) (map[string]map[string]interface{}, error) {
+	objects := make(map[string]map[string]interface{})
+
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() || !containsString(renderedExtensions, filepath.Ext(path)) {
+			return nil
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		fileObjects, err := decodeObjects(data)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		for key, object := range fileObjects {
+			if _, exists := objects[key]; exists {
+				return fmt.Errorf("%s: %s is rendered more than once", path, key)
+			}
+			objects[key] = object
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func decodeObjects(data []byte) (map[string]map[string]interface{}, error) {
+	objects := make(map[string]map[string]interface{})
+
+	decoder := utilyaml.NewYAMLOrJSONDecoder(bytes.NewReader(data), decoderBufferLen)
+	for {
+		var object map[string]interface{}
+		if err := decoder.Decode(&object); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if len(object) == 0 {
+			continue
+		}
+
+		key := objectKey(object)
+		if _, exists := objects[key]; exists {
+			return nil, fmt.Errorf("%s is repeated", key)
+		}
+		objects[key] = object
+	}
+
+	return objects, nil
+}
+
+func objectKey(object map[string]interface{}) string {
+	kind, _ := object["kind"].(string)
+	metadata, _ := object["metadata"].(map[string]interface{})
+	namespace, _ := metadata["namespace"].(string)
+	name, _ := metadata["name"].(string)
+
+	if namespace == "" {
+		return fmt.Sprintf("%s/%s", kind, name)
+	}
+
+	return fmt.Sprintf("%s/%s/%s", kind, namespace, name)
+}
+
+// compare walks the fields of both manifests. Lists of named elements are
+// matched by name, and other lists as sets, so reordering is not a change.
+func compare(path string, before interface{}, after interface{}, changes *[]Change) {
+	switch before := before.(type) {
+	case map[string]interface{}:
+		after, ok := after.(map[string]interface{})
+		if !ok {
+			break
+		}
+
+		keys := make([]string, 0, len(before)+len(after))
+		for key := range before {
+			keys = append(keys, key)
+		}
+		for key := range after {
+			if _, exists := before[key]; !exists {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			compare(joinPath(path, key), before[key], after[key], changes)
+		}
+
+		return
+	case []interface{}:
+		after, ok := after.([]interface{})
+		if !ok {
+			break
+		}
+
+		beforeNames, beforeNamed := indexByName(before)
+		afterNames, afterNamed := indexByName(after)
+		if beforeNamed && afterNamed {
+			names := make([]string, 0, len(beforeNames)+len(afterNames))
+			for name := range beforeNames {
+				names = append(names, name)
+			}
+			for name := range afterNames {
+				if _, exists := beforeNames[name]; !exists {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				compare(fmt.Sprintf("%s[%s]", path, name), beforeNames[name], afterNames[name], changes)
+			}
+
+			return
+		}
+
+		for _, element := range subtract(before, after) {
+			*changes = append(*changes, Change{path + "[]", element, nil})
+		}
+		for _, element := range subtract(after, before) {
+			*changes = append(*changes, Change{path + "[]", nil, element})
+		}
+
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		*changes = append(*changes, Change{path, before, after})
+	}
+}
+
+func indexByName(elements []interface{}) (map[string]interface{}, bool) {
+	names := make(map[string]interface{}, len(elements))
+	for _, element := range elements {
+		object, ok := element.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+
+		name, ok := object[nameField].(string)
+		if !ok {
+			return nil, false
+		}
+
+		if _, exists := names[name]; exists {
+			return nil, false
+		}
+		names[name] = element
+	}
+
+	return names, true
+}
+
+// subtract returns the elements of a missing from b, counting repetitions and
+// sorted by value, since the order of the elements is not a change.
+func subtract(a []interface{}, b []interface{}) []interface{} {
+	remaining := append([]interface{}{}, b...)
+
+	var elements []interface{}
+	for _, element := range a {
+		found := false
+		for i, other := range remaining {
+			if reflect.DeepEqual(element, other) {
+				remaining = append(remaining[:i], remaining[i+1:]...)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			elements = append(elements, element)
+		}
+	}
+
+	sort.Slice(elements, func(i, j int) bool {
+		return formatValue(elements[i]) < formatValue(elements[j])
+	})
+
+	return elements
+}
+
+func joinPath(path string, key string) string {
+	if strings.ContainsAny(key, "./") {
+		key = fmt.Sprintf("[%s]", key)
+	} else if path != "" {
+		key = pathSeparator + key
+	}
+
+	return path + key
+}
+
+func formatValue(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+
+	return string(b)
+}
@@ -0,0 +1,159 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+const (
+	renderedProject = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    readOnly: [sre:eng-1, sre:eng-2]
+    readSync: [sre:eng-0]
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Product
+          namespace: payroll
+    - metadata:
+        name: benefits
+      spec:
+        source:
+          repoURL: https://github.com/inloco/benefits.git
+        destination:
+          name: Global-Product
+          namespace: benefits
+`
+)
+
+var _ = ginkgo.Describe("Diff", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+
+		var rendered bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests([]byte(renderedProject), &rendered)).To(g.Succeed())
+		g.Expect(os.Mkdir(filepath.Join(dir, "rendered"), 0755)).To(g.Succeed())
+		g.Expect(ioutil.WriteFile(filepath.Join(dir, "rendered", "employees.yaml"), rendered.Bytes(), 0644)).To(g.Succeed())
+	})
+
+	diff := func(project string, flags ...string) (string, error) {
+		path := filepath.Join(dir, "employees.argoCDProject.yaml")
+		g.Expect(ioutil.WriteFile(path, []byte(project), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		err := argocdproject.Diff(append(append([]string{"--against", filepath.Join(dir, "rendered")}, flags...), path), &out)
+
+		return out.String(), err
+	}
+
+	ginkgo.It("ignores reordering", func() {
+		out, err := diff(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    readOnly: [sre:eng-2, sre:eng-1]
+    readSync: [sre:eng-0]
+  applicationTemplates:
+    - metadata:
+        name: benefits
+      spec:
+        source:
+          repoURL: https://github.com/inloco/benefits.git
+        destination:
+          name: Global-Product
+          namespace: benefits
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Product
+          namespace: payroll
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.BeEmpty())
+	})
+
+	ginkgo.It("prints changed fields and objects", func() {
+		out, err := diff(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    readOnly: [sre:eng-1]
+    readSync: [sre:eng-0]
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Product
+          namespace: payroll-v2
+    - metadata:
+        name: pensions
+      spec:
+        source:
+          repoURL: https://github.com/inloco/pensions.git
+        destination:
+          name: Global-Product
+          namespace: pensions
+`)
+		g.Expect(err).To(g.MatchError(argocdproject.ErrDifferences))
+		g.Expect(out).To(g.Equal(`~ AppProject/employees
+    spec.destinations[]: {"name":"Global-Product","namespace":"benefits"} -> null
+    spec.destinations[]: {"name":"Global-Product","namespace":"payroll"} -> null
+    spec.destinations[]: null -> {"name":"Global-Product","namespace":"payroll-v2"}
+    spec.destinations[]: null -> {"name":"Global-Product","namespace":"pensions"}
+    spec.roles[read-only].groups[]: "sre:eng-2" -> null
+- Application/benefits
+~ Application/payroll
+    spec.destination.namespace: "payroll" -> "payroll-v2"
++ Application/pensions
+`))
+	})
+
+	ginkgo.It("generates with the flags of the plugin", func() {
+		out, err := diff(renderedProject, "--set", "spec.applicationTemplates[0].spec.destination.namespace=payroll-v2", "--output", "applications")
+		g.Expect(err).To(g.MatchError(argocdproject.ErrDifferences))
+		g.Expect(out).To(g.Equal(`- AppProject/employees
+~ Application/payroll
+    spec.destination.namespace: "payroll" -> "payroll-v2"
+`))
+	})
+
+	ginkgo.It("fails without rendered manifests", func() {
+		_, err := diff(renderedProject)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		err = argocdproject.Diff([]string{filepath.Join(dir, "employees.argoCDProject.yaml")}, ioutil.Discard)
+		g.Expect(err).To(g.MatchError("-against is required"))
+	})
+})
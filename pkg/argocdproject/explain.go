@@ -0,0 +1,349 @@
+package argocdproject
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strconv"
+	"strings"
+
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	fileSource           = "file"
+	defaultSource        = "default"
+	setSource            = "--set"
+	terraformSource      = "--terraform-outputs"
+	defaultsSource       = "--defaults"
+	envSource            = "--env"
+	namespaceSource      = "--namespace"
+	dirSource            = "--dir"
+	emptyTemplatesSource = "--empty-templates"
+
+	sourcePathSeparator = "/"
+)
+
+var (
+	// explainSources are the fields a value not declared in the file is
+	// derived from, by the prefix of its path, where list elements are named
+	// after their name field or their index, negated for negated elements.
+	explainSources = []struct {
+		pattern string
+		source  string
+	}{
+		{"spec/appProjectTemplate/metadata/name", "metadata.name"},
+		{"spec/appProjectTemplate/metadata/namespace", "spec.namespace"},
+		{"spec/appProjectTemplate/metadata/annotations", "spec.banner"},
+		{"spec/appProjectTemplate/spec/clusterResourceWhitelist", "spec.accessControl.clusterCapabilities"},
+		{"spec/appProjectTemplate/spec/destinations/" + negationPrefix + "*", "spec.denyDestinations"},
+		{"spec/appProjectTemplate/spec/destinations", "spec.applicationTemplates"},
+		{"spec/appProjectTemplate/spec/roles/" + breakGlassRole, "spec.accessControl.breakGlass"},
+		{"spec/appProjectTemplate/spec/roles", "spec.accessControl"},
+		{"spec/applicationTemplates/*/metadata/name", "spec.clusterRegistry"},
+		{"spec/applicationTemplates/*/metadata/annotations", "spec.banner"},
+		{"spec/applicationTemplates/*/metadata/namespace", "spec.applicationNamespace"},
+		{"spec/applicationTemplates/*/spec/destination/name", "spec.clusterRegistry"},
+		{"spec/applicationTemplates/*/spec/project", "metadata.name"},
+		{"spec/applicationTemplates/*/spec/source/path", "spec.environment"},
+		{"spec/applicationTemplates/*/spec/source/targetRevision", "spec.environment"},
+		{"spec/applicationTemplates/*/spec/info", "spec.info"},
+	}
+
+	// v1beta1Sources are the sources renamed by v1beta1.
+	v1beta1Sources = map[string]string{
+		"spec.environment": "spec.environments",
+	}
+)
+
+// stage is a step of the resolution of a project file, with the values of the
+// project after it, named after the source of the values it sets.
+type stage struct {
+	source string
+	values interface{}
+}
+
+// recordData records the values of a project file as a stage, unless stages
+// are not recorded.
+func recordData(stages *[]stage, source string, data []byte) error {
+	if stages == nil {
+		return nil
+	}
+
+	var values map[string]interface{}
+	if err := yaml.Unmarshal(data, &values); err != nil {
+		return err
+	}
+
+	*stages = append(*stages, stage{source, values})
+	return nil
+}
+
+// recordProject records the values of a project, in the apiVersion of its
+// file, as a stage, unless stages are not recorded.
+func recordProject(stages *[]stage, source string, argocdProject *ArgoCDProject) error {
+	if stages == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(encodeProject(argocdProject, argocdProject.APIVersion))
+	if err != nil {
+		return err
+	}
+
+	var values map[string]interface{}
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+
+	*stages = append(*stages, stage{source, values})
+	return nil
+}
+
+// Explain writes a project file as resolved by the plugin, taking its flags,
+// with a comment on each value naming whether it was declared in the file, set
+// by a flag, is a default or the field of the file it was derived from.
+func Explain(args []string, out io.Writer) error {
+	var options Options
+	flags := flag.NewFlagSet("explain", flag.ContinueOnError)
+	completeOptions := options.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if err := completeOptions(); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("explain requires exactly one path")
+	}
+
+	data, err := ioutil.ReadFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	var stages []stage
+	argocdProject, err := resolveProject(data, options, &stages)
+	if err != nil {
+		return err
+	}
+	apiVersion := argocdProject.APIVersion
+
+	if _, err := makeManifests(argocdProject); err != nil {
+		return err
+	}
+
+	if err := validateCasbinPolicies(&argocdProject.Spec.AppProject); err != nil {
+		return fmt.Errorf("spec.accessControl: %w", err)
+	}
+
+	b, err := json.Marshal(encodeProject(argocdProject, apiVersion))
+	if err != nil {
+		return err
+	}
+
+	var resolved interface{}
+	if err := json.Unmarshal(b, &resolved); err != nil {
+		return err
+	}
+
+	b, err = yaml.Marshal(pruneResolved(resolved))
+	if err != nil {
+		return err
+	}
+
+	node, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+	explainNode(node.YNode(), stages, nil, apiVersion)
+
+	s, err := node.String()
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(out, s)
+	return err
+}
+
+// pruneResolved removes the empty fields and statuses the Argo CD types
+// marshal, which are not part of the configuration.
+func pruneResolved(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		delete(v, yamlStatusField)
+		for key, value := range v {
+			value = pruneResolved(value)
+			if value == nil {
+				delete(v, key)
+				continue
+			}
+			v[key] = value
+		}
+
+		if len(v) == 0 {
+			return nil
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = pruneResolved(v[i])
+		}
+	}
+
+	return v
+}
+
+// explainNode comments the values of a node with their sources, where stages
+// hold the values of the node after each stage of the resolution.
+func explainNode(node *kyaml.Node, stages []stage, segments []string, apiVersion string) {
+	switch node.Kind {
+	case kyaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			children := make([]stage, len(stages))
+			for j, stage := range stages {
+				declaredMap, _ := stage.values.(map[string]interface{})
+				children[j] = stage
+				children[j].values = lookupDeclared(declaredMap, key)
+			}
+			explainNode(node.Content[i+1], children, appendSegment(segments, key), apiVersion)
+		}
+	case kyaml.SequenceNode:
+		for i, item := range node.Content {
+			name := nodeName(item)
+			segment := strconv.Itoa(i)
+			if name != "" {
+				segment = name
+			}
+			if isNegated(item) {
+				segment = negationPrefix + segment
+			}
+
+			children := make([]stage, len(stages))
+			for j, stage := range stages {
+				declaredList, _ := stage.values.([]interface{})
+				children[j] = stage
+				children[j].values = nil
+				if name != "" {
+					children[j].values = findDeclared(declaredList, name)
+				} else if i < len(declaredList) {
+					children[j].values = declaredList[i]
+				}
+			}
+			explainNode(item, children, appendSegment(segments, segment), apiVersion)
+		}
+	default:
+		node.LineComment = explainSource(segments, node.Value, stages, apiVersion)
+	}
+}
+
+// explainSource names the first stage setting a value, or the field it was
+// derived from when no stage did.
+func explainSource(segments []string, value string, stages []stage, apiVersion string) string {
+	for _, stage := range stages {
+		if stage.values != nil && fmt.Sprint(stage.values) == value {
+			return stage.source
+		}
+	}
+
+	for _, explainSource := range explainSources {
+		n := strings.Count(explainSource.pattern, sourcePathSeparator) + 1
+		if len(segments) < n {
+			continue
+		}
+
+		if matched, _ := path.Match(explainSource.pattern, strings.Join(segments[:n], sourcePathSeparator)); matched {
+			if source, renamed := v1beta1Sources[explainSource.source]; renamed && apiVersion == v1beta1APIVersion {
+				return source
+			}
+			return explainSource.source
+		}
+	}
+
+	return defaultSource
+}
+
+// lookupDeclared returns the declared value of a key, which the decoding of
+// the file matches case-insensitively to the fields of the plugin.
+func lookupDeclared(declaredMap map[string]interface{}, key string) interface{} {
+	if value, exists := declaredMap[key]; exists {
+		return value
+	}
+
+	for declaredKey, value := range declaredMap {
+		if strings.EqualFold(declaredKey, key) {
+			return value
+		}
+	}
+
+	return nil
+}
+
+func isNegated(node *kyaml.Node) bool {
+	if node.Kind != kyaml.MappingNode {
+		return false
+	}
+
+	for i := 1; i < len(node.Content); i += 2 {
+		if strings.HasPrefix(node.Content[i].Value, negationPrefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func nodeName(node *kyaml.Node) string {
+	if node.Kind != kyaml.MappingNode {
+		return ""
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == nameField {
+			return node.Content[i+1].Value
+		}
+	}
+
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "metadata" {
+			return nodeName(node.Content[i+1])
+		}
+	}
+
+	return ""
+}
+
+// findDeclared returns the declared element of a name, or the one it was
+// expanded from, whose name is a prefix of it.
+func findDeclared(declaredList []interface{}, name string) interface{} {
+	var found interface{}
+	foundName := ""
+	for _, item := range declaredList {
+		object, _ := item.(map[string]interface{})
+		itemName, _ := object[nameField].(string)
+		if metadata, ok := object["metadata"].(map[string]interface{}); ok {
+			itemName, _ = metadata[nameField].(string)
+		}
+
+		if itemName == name {
+			return item
+		}
+
+		if itemName != "" && strings.HasPrefix(name, itemName+"-") && len(itemName) > len(foundName) {
+			found, foundName = item, itemName
+		}
+	}
+
+	return found
+}
+
+func appendSegment(segments []string, segment string) []string {
+	return append(append(make([]string, 0, len(segments)+1), segments...), segment)
+}
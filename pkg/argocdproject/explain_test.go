@@ -0,0 +1,165 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Explain", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+	})
+
+	explain := func(project string, flags ...string) (string, error) {
+		path := filepath.Join(dir, "employees.argoCDProject.yaml")
+		g.Expect(ioutil.WriteFile(path, []byte(project), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		err := argocdproject.Explain(append(flags, path), &out)
+
+		return out.String(), err
+	}
+
+	ginkgo.It("annotates values with their sources", func() {
+		registry := filepath.Join(dir, "clusters.yaml")
+		g.Expect(ioutil.WriteFile(registry, []byte("clusters:\n  - name: Global-Product\n  - name: Staging-Product\n"), 0644)).To(g.Succeed())
+
+		out, err := explain(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+  clusterRegistry: ` + registry + `
+  accessControl:
+    disabled: true
+  denyDestinations:
+    - namespace: kube-system
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-*
+          namespace: payroll
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.Equal(`apiVersion: incognia.com/v1alpha1 # file
+kind: ArgoCDProject # file
+metadata:
+  name: employees # file
+spec:
+  accessControl:
+    disabled: true # file
+  appProjectTemplate:
+    apiVersion: argoproj.io/v1alpha1 # default
+    kind: AppProject # default
+    metadata:
+      name: employees # metadata.name
+    spec:
+      destinations:
+      - name: Global-Product # spec.applicationTemplates
+        namespace: payroll # spec.applicationTemplates
+      - namespace: '!kube-system' # spec.denyDestinations
+        server: '*' # spec.denyDestinations
+      namespaceResourceWhitelist:
+      - group: '*' # default
+        kind: '*' # default
+      sourceRepos:
+      - '*' # default
+  applicationTemplates:
+  - apiVersion: argoproj.io/v1alpha1 # default
+    kind: Application # default
+    metadata:
+      name: payroll # file
+    spec:
+      destination:
+        name: Global-Product # spec.clusterRegistry
+        namespace: payroll # file
+      project: employees # metadata.name
+      source:
+        path: ./k8s/overlays/production # spec.environment
+        repoURL: https://github.com/inloco/payroll.git # file
+        targetRevision: env-production # spec.environment
+  clusterRegistry: ` + registry + ` # file
+  denyDestinations:
+  - namespace: kube-system # file
+  environment: production # file
+`))
+	})
+
+	ginkgo.It("names the sources of the apiVersion of the file", func() {
+		out, err := explain(`
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - production
+  accessControl:
+    readOnlyGroups:
+      - sre:eng-1
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: Global-Product
+          namespace: payroll
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.ContainSubstring("\n    readOnlyGroups:\n    - sre:eng-1 # file\n"))
+		g.Expect(out).To(g.ContainSubstring("\n        path: ./k8s/overlays/production # spec.environments\n"))
+		g.Expect(out).To(g.ContainSubstring("\n  environments:\n  - production # file\n"))
+	})
+
+	ginkgo.It("names the flags values are set by", func() {
+		defaults := filepath.Join(dir, "defaults.yaml")
+		g.Expect(ioutil.WriteFile(defaults, []byte("accessControl:\n  ReadOnly:\n    - security:eng-0\n"), 0644)).To(g.Succeed())
+
+		out, err := explain(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+`, "--defaults", defaults, "--set", "spec.environment=staging", "--namespace", "argocd")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(out).To(g.ContainSubstring("\n    ReadOnly:\n    - security:eng-0 # --defaults\n"))
+		g.Expect(out).To(g.ContainSubstring("\n  environment: staging # --set\n"))
+		g.Expect(out).To(g.ContainSubstring("\n        path: ./k8s/overlays/staging # spec.environment\n"))
+		g.Expect(out).To(g.ContainSubstring("\n  namespace: argocd # --namespace\n"))
+	})
+
+	ginkgo.It("fails on invalid projects", func() {
+		_, err := explain("spec:\n  banner:\n    severity: urgent\n")
+		g.Expect(err).To(g.HaveOccurred())
+	})
+})
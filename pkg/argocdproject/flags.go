@@ -0,0 +1,102 @@
+package argocdproject
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+// overrides is a repeated --set flag.
+type overrides []string
+
+func (o *overrides) String() string {
+	return strings.Join(*o, ",")
+}
+
+func (o *overrides) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+// AddFlags defines the flags of the options on a flag set, returning the
+// function that completes the options once the flags are parsed, reading the
+// files they name, so every command generating projects takes the same flags.
+func (o *Options) AddFlags(flags *flag.FlagSet) func() error {
+	maxApplications, maxApplicationsErr := intFromEnv(MaxApplicationsEnv)
+	maxOutputSize, maxOutputSizeErr := intFromEnv(MaxOutputSizeEnv)
+
+	flags.Var((*overrides)(&o.Overrides), "set", "path=value of a field to override, which may be repeated")
+	flags.StringVar((*string)(&o.Output), "output", string(OutputAll), "resources to generate: appproject, applications or all")
+	flags.StringVar(&o.Namespace, "namespace", "", "namespace of the generated resources, overriding spec.namespace")
+	flags.StringVar(&o.Environment, "env", "", "environment of the project, overriding spec.environment")
+	flags.StringVar((*string)(&o.Validation), "validation", string(ValidationLenient), "how strictly the project file is decoded: lenient or strict")
+	flags.StringVar((*string)(&o.EmptyTemplates), "empty-templates", string(EmptyTemplatesAllow), "what to do with projects without application templates: allow, warn, fail or defaults")
+	flags.BoolVar(&o.OmitEmpty, "omit-empty", false, "drop null fields, such as creationTimestamp, and empty metadata from the generated resources")
+	flags.StringVar(&o.EnvironmentPattern, "environment-pattern", DefaultEnvironmentPattern, "regular expression environments must match")
+	reservedEnvironments := flags.String("reserved-environments", "", "comma-separated names environments cannot have")
+	requireGroupsEnvironments := flags.String("require-groups", "", "comma-separated environments whose projects must have read-only and read-sync groups")
+	flags.IntVar(&o.MaxApplications, "max-applications", maxApplications, "most applications a project may generate, unlimited when 0")
+	flags.IntVar(&o.MaxOutputSize, "max-output-size", maxOutputSize, "most bytes the generated resources may have, unlimited when 0")
+	defaultsPath := flags.String("defaults", "", "path of a file with the spec fields used where the project file leaves them unset")
+	terraformOutputsPath := flags.String("terraform-outputs", os.Getenv(terraform.OutputsEnv), "path of a file of terraform output -json whose values replace {{terraform.name}} placeholders")
+
+	return func() error {
+		for _, err := range []error{maxApplicationsErr, maxOutputSizeErr} {
+			if err != nil {
+				return err
+			}
+		}
+
+		if *reservedEnvironments != "" {
+			o.ReservedEnvironments = strings.Split(*reservedEnvironments, ",")
+		}
+
+		if *requireGroupsEnvironments != "" {
+			o.RequireGroupsEnvironments = strings.Split(*requireGroupsEnvironments, ",")
+		}
+
+		if *defaultsPath != "" {
+			data, err := ioutil.ReadFile(*defaultsPath)
+			if err != nil {
+				return err
+			}
+
+			o.Defaults = &ProjectSpec{}
+			if err := yaml.Unmarshal(data, o.Defaults); err != nil {
+				return fmt.Errorf("%s: %w", *defaultsPath, err)
+			}
+		}
+
+		if *terraformOutputsPath != "" {
+			outputs, err := terraform.ReadOutputs(*terraformOutputsPath)
+			if err != nil {
+				return fmt.Errorf("%s: %w", *terraformOutputsPath, err)
+			}
+			o.TerraformOutputs = outputs
+		}
+
+		return nil
+	}
+}
+
+// intFromEnv is the integer of an environment variable, which is 0 when unset.
+func intFromEnv(name string) (int, error) {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return 0, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", name, err)
+	}
+
+	return i, nil
+}
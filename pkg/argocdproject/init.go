@@ -0,0 +1,100 @@
+package argocdproject
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+const (
+	standardOutput    = "-"
+	projectFileSuffix = ".argoCDProject.yaml"
+	projectFilePerm   = 0644
+	projectFileFlags  = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+
+	starterProject = `apiVersion: %[1]s
+kind: ArgoCDProject
+metadata:
+  name: %[2]s
+spec:
+  environments:
+    - %[3]s
+  # The groups of each access level of the project, from the least to the
+  # most privileged: readOnlyGroups, readSyncGroups and
+  # overrideParametersGroups.
+  # accessControl:
+  #   readOnlyGroups:
+  #     - %[2]s:viewers
+  #   readSyncGroups:
+  #     - %[2]s:developers
+  # The applications of the project, whose source path and revision are
+  # derived from the environment, fanned out to each of many environments.
+  # applicationTemplates:
+  #   - metadata:
+  #       name: %[2]s
+  #     spec:
+  #       source:
+  #         repoURL: https://github.com/inloco/%[2]s.git
+  #       destination:
+  #         name: Global-Product
+  #         namespace: %[2]s
+`
+)
+
+// Init writes a starter project file, whose examples are commented out so
+// the file is valid as written.
+func Init(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("init", flag.ContinueOnError)
+	name := flags.String("name", "", "name of the project")
+	environment := flags.String("env", "", "environment of the project")
+	output := flags.String("output", "", "path of the project file, - for the standard output, or <name>"+projectFileSuffix+" by default")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return fmt.Errorf("-name is required")
+	}
+
+	if errs := validation.IsDNS1123Label(*name); len(errs) > 0 {
+		return fmt.Errorf("-name %s is invalid: %s", *name, strings.Join(errs, ", "))
+	}
+
+	if *environment == "" {
+		return fmt.Errorf("-env is required")
+	}
+
+	data := fmt.Sprintf(starterProject, projectAPIVersion, *name, *environment)
+
+	if *output == standardOutput {
+		_, err := io.WriteString(out, data)
+		return err
+	}
+
+	path := *output
+	if path == "" {
+		path = *name + projectFileSuffix
+	}
+
+	// Existing project files are never overwritten.
+	file, err := os.OpenFile(path, projectFileFlags, projectFilePerm)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if _, err := io.WriteString(file, data); err != nil {
+		return err
+	}
+
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "wrote %s\n", path)
+	return err
+}
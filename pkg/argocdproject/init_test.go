@@ -0,0 +1,71 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Init", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+	})
+
+	ginkgo.It("writes a valid starter project", func() {
+		path := filepath.Join(dir, "payroll.argoCDProject.yaml")
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Init([]string{"--name", "payroll", "--env", "production", "--output", path}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.Equal("wrote " + path + "\n"))
+
+		g.Expect(argocdproject.Lint([]string{path}, ioutil.Discard)).To(g.Succeed())
+
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring("  environments:\n    - production\n"))
+
+		uncommented := regexp.MustCompile(`(?m)^  # (  |accessControl|applicationTemplates)`).ReplaceAll(data, []byte("  $1"))
+		g.Expect(ioutil.WriteFile(path, uncommented, 0644)).To(g.Succeed())
+		g.Expect(argocdproject.Lint([]string{path}, ioutil.Discard)).To(g.Succeed())
+
+		var manifests bytes.Buffer
+		g.Expect(argocdproject.GenerateManifests(uncommented, &manifests)).To(g.Succeed())
+		g.Expect(separatorYaml.Split(manifests.String(), -1)).To(g.HaveLen(2))
+	})
+
+	ginkgo.It("writes to the standard output", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.Init([]string{"--name", "payroll", "--env", "staging", "--output", "-"}, &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.HavePrefix("apiVersion: incognia.com/v1beta1\nkind: ArgoCDProject\nmetadata:\n  name: payroll\n"))
+	})
+
+	ginkgo.It("does not overwrite project files", func() {
+		path := filepath.Join(dir, "payroll.argoCDProject.yaml")
+		g.Expect(ioutil.WriteFile(path, []byte("existing"), 0644)).To(g.Succeed())
+
+		g.Expect(argocdproject.Init([]string{"--name", "payroll", "--env", "production", "--output", path}, ioutil.Discard)).NotTo(g.Succeed())
+
+		data, err := ioutil.ReadFile(path)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.Equal("existing"))
+	})
+
+	ginkgo.DescribeTable("fails", func(args []string, expectedError string) {
+		g.Expect(argocdproject.Init(args, ioutil.Discard)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without name", []string{"--env", "production"}, "-name is required"),
+		ginkgo.Entry("without environment", []string{"--name", "payroll"}, "-env is required"),
+		ginkgo.Entry("with invalid name", []string{"--name", "Payroll", "--env", "production"}, "-name Payroll is invalid: a lowercase RFC 1123 label must consist of lower case alphanumeric characters or '-', and must start and end with an alphanumeric character (e.g. 'my-name',  or '123-abc', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?')"),
+	)
+})
@@ -0,0 +1,269 @@
+package argocdproject
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"regexp"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+)
+
+const (
+	lintFormatText = "text"
+	lintTool       = "argocdproject"
+
+	policyRule   = "p"
+	groupingRule = "g"
+	allowEffect  = "allow"
+	denyEffect   = "deny"
+
+	ruleRead     = "read"
+	ruleDecode   = "decode"
+	ruleSchema   = "schema"
+	ruleNaming   = "naming"
+	rulePolicy   = "policy"
+	ruleGenerate = "generate"
+)
+
+var (
+	// ErrFindings is returned by Lint when any file has findings, so the
+	// command exits with a failure without panicking.
+	ErrFindings = errors.New("found problems in project files")
+
+	// The expressions below mirror the validation of AppProjects by Argo CD.
+	roleNameExpression     = regexp.MustCompile(`^[a-zA-Z0-9]([-_a-zA-Z0-9]*[a-zA-Z0-9])?$`)
+	policyObjectExpression = `^%s/[*\w-.]+$`
+	invalidGroupCharacters = regexp.MustCompile("[\"\n\r\t]")
+	policyResources        = []string{"applications", "repositories", "clusters", anyPattern}
+)
+
+// Finding is a problem of a project file found by Lint.
+type Finding = findings.Finding
+
+// Lint checks project files without emitting their manifests and writes
+// the findings to out, as text lines, as a JSON array or as a SARIF log.
+func Lint(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	format := flags.String("format", lintFormatText, "format of the findings, text, json or sarif")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *format != lintFormatText && *format != findings.FormatJSON && *format != findings.FormatSARIF {
+		return fmt.Errorf("unknown format %s", *format)
+	}
+
+	if flags.NArg() == 0 {
+		return fmt.Errorf("no paths to lint")
+	}
+
+	found := make([]Finding, 0)
+	for _, path := range flags.Args() {
+		found = append(found, lintFile(path)...)
+	}
+
+	switch *format {
+	case lintFormatText:
+		for _, finding := range found {
+			if _, err := fmt.Fprintf(out, "%s: %s: %s\n", finding.Path, finding.Rule, finding.Message); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := findings.Write(out, *format, lintTool, found); err != nil {
+			return err
+		}
+	}
+
+	if len(found) > 0 {
+		return ErrFindings
+	}
+
+	return nil
+}
+
+func lintFile(path string) []Finding {
+	var found []Finding
+	report := func(rule string, format string, a ...interface{}) {
+		found = append(found, Finding{
+			Path:    path,
+			Rule:    rule,
+			Message: fmt.Sprintf(format, a...),
+		})
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		report(ruleRead, "%v", err)
+		return found
+	}
+
+	argocdProject, err := decodeProject(data, true)
+	if err != nil {
+		report(ruleDecode, "%v", err)
+		return found
+	}
+
+	for _, err := range validateTypeMeta(argocdProject) {
+		report(ruleSchema, "%v", err)
+	}
+
+	if argocdProject.Name == "" {
+		report(ruleSchema, "metadata.name is empty")
+	} else if errs := validation.IsDNS1123Subdomain(argocdProject.Name); len(errs) > 0 {
+		report(ruleNaming, "metadata.name %s is invalid: %s", argocdProject.Name, strings.Join(errs, ", "))
+	}
+
+	names := make(map[string]bool)
+	for i, app := range argocdProject.Spec.ApplicationTemplates {
+		switch {
+		case app.Name == "":
+			report(ruleSchema, "spec.applicationTemplates[%d].metadata.name is empty", i)
+		case names[app.Name]:
+			report(ruleNaming, "spec.applicationTemplates[%d].metadata.name %s is repeated", i, app.Name)
+		default:
+			if errs := validation.IsDNS1123Subdomain(app.Name); len(errs) > 0 {
+				report(ruleNaming, "spec.applicationTemplates[%d].metadata.name %s is invalid: %s", i, app.Name, strings.Join(errs, ", "))
+			}
+		}
+		names[app.Name] = true
+
+		for _, err := range validateApplicationTemplate(i, &argocdProject.Spec.ApplicationTemplates[i]) {
+			report(ruleSchema, "%v", err)
+		}
+	}
+
+	for i, role := range argocdProject.Spec.AppProject.Spec.Roles {
+		if !roleNameExpression.MatchString(role.Name) {
+			report(ruleNaming, "spec.appProjectTemplate.spec.roles[%d].name %q is invalid", i, role.Name)
+		}
+	}
+
+	if len(found) > 0 {
+		return found
+	}
+
+	manifests, err := makeManifests(argocdProject)
+	if err != nil {
+		report(ruleGenerate, "%v", err)
+		return found
+	}
+
+	var appProject argov1alpha1.AppProject
+	if err := yaml.Unmarshal(manifests[0], &appProject); err != nil {
+		report(ruleGenerate, "%v", err)
+		return found
+	}
+
+	for _, role := range appProject.Spec.Roles {
+		for _, policy := range role.Policies {
+			if err := parsePolicy(appProject.Name, role.Name, policy); err != nil {
+				report(rulePolicy, "role %s: %v", role.Name, err)
+			}
+		}
+
+		for _, group := range role.Groups {
+			if err := validateGroup(group); err != nil {
+				report(rulePolicy, "role %s: %v", role.Name, err)
+			}
+		}
+	}
+
+	return found
+}
+
+func validateTypeMeta(argocdProject *ArgoCDProject) []error {
+	var errs []error
+
+	if err := validateAPIVersion(argocdProject.APIVersion); err != nil {
+		errs = append(errs, err)
+	}
+
+	if kind := reflect.TypeOf(*argocdProject).Name(); argocdProject.Kind != kind {
+		errs = append(errs, fmt.Errorf("kind is %q instead of %s", argocdProject.Kind, kind))
+	}
+
+	return errs
+}
+
+// parsePolicy checks a policy of a project role, which is either a casbin
+// policy rule of the role or a grouping rule to another role of the project.
+func parsePolicy(project string, role string, policy string) error {
+	fields, err := parseCasbinPolicy(policy)
+	if err != nil {
+		return err
+	}
+
+	subject := fmt.Sprintf("proj:%s:%s", project, role)
+
+	switch fields[0] {
+	case policyRule:
+		if fields[1] != subject {
+			return fmt.Errorf("policy %q has subject %s instead of %s", policy, fields[1], subject)
+		}
+
+		if !containsString(policyResources, fields[2]) {
+			return fmt.Errorf("policy %q has unknown resource %s", policy, fields[2])
+		}
+
+		if fields[3] == "" {
+			return fmt.Errorf("policy %q has no action", policy)
+		}
+
+		if !regexp.MustCompile(fmt.Sprintf(policyObjectExpression, regexp.QuoteMeta(project))).MatchString(fields[4]) {
+			return fmt.Errorf("policy %q has object %s outside of project %s", policy, fields[4], project)
+		}
+
+		if fields[5] != allowEffect && fields[5] != denyEffect {
+			return fmt.Errorf("policy %q has unknown effect %s", policy, fields[5])
+		}
+	case groupingRule:
+		if fields[1] != subject {
+			return fmt.Errorf("policy %q has subject %s instead of %s", policy, fields[1], subject)
+		}
+
+		if !strings.HasPrefix(fields[2], fmt.Sprintf("proj:%s:", project)) {
+			return fmt.Errorf("policy %q inherits role %s outside of project %s", policy, fields[2], project)
+		}
+	}
+
+	return nil
+}
+
+func validateGroup(group string) error {
+	name := strings.TrimSpace(group)
+	if len(name) > 1 && strings.HasPrefix(name, `"`) && strings.HasSuffix(name, `"`) {
+		name = name[1 : len(name)-1]
+	} else if strings.Contains(name, ",") {
+		return fmt.Errorf("group %q must be quoted", group)
+	}
+
+	if name == "" {
+		return fmt.Errorf("group %q is empty", group)
+	}
+
+	if invalidGroupCharacters.MatchString(name) {
+		return fmt.Errorf("group %q has invalid characters", group)
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
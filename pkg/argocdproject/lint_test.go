@@ -0,0 +1,196 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Lint", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+	})
+
+	write := func(name string, data string) string {
+		path := filepath.Join(dir, name)
+		g.Expect(ioutil.WriteFile(path, []byte(data), 0644)).To(g.Succeed())
+		return path
+	}
+
+	lint := func(paths ...string) ([]argocdproject.Finding, error) {
+		var out bytes.Buffer
+		err := argocdproject.Lint(append([]string{"-format", "json"}, paths...), &out)
+
+		var findings []argocdproject.Finding
+		g.Expect(json.Unmarshal(out.Bytes(), &findings)).To(g.Succeed())
+
+		return findings, err
+	}
+
+	ginkgo.It("accepts valid projects", func() {
+		findings, err := lint(write("employees.yaml", `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    readOnly: [sre:eng-1]
+  applicationTemplates:
+    - metadata:
+        name: employees
+      spec:
+        source:
+          repoURL: https://github.com/inloco/employees.git
+        destination:
+          name: GlobalStaging-Product
+          namespace: employees
+`))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(findings).To(g.BeEmpty())
+	})
+
+	ginkgo.It("reports findings of every file", func() {
+		unknown := write("unknown.yaml", `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  enviroment: production
+`)
+		invalid := write("invalid.yaml", `
+apiVersion: incognia.com/v2
+kind: ArgoCDProject
+metadata:
+  name: Employees
+spec:
+  applicationTemplates:
+    - metadata:
+        name: employees
+      spec:
+        destination:
+          namespace: employees
+`)
+		missing := filepath.Join(dir, "missing.yaml")
+
+		findings, err := lint(unknown, invalid, missing)
+		g.Expect(err).To(g.MatchError(argocdproject.ErrFindings))
+		g.Expect(findings).To(g.HaveLen(6))
+		g.Expect(findings[0].Path).To(g.Equal(unknown))
+		g.Expect(findings[0].Rule).To(g.Equal("decode"))
+		g.Expect(findings[0].Message).To(g.ContainSubstring(`unknown field "enviroment"`))
+		g.Expect(findings[1:5]).To(g.Equal([]argocdproject.Finding{
+			{
+				Path:    invalid,
+				Rule:    "schema",
+				Message: `apiVersion is "incognia.com/v2" instead of incognia.com/v1alpha1 or incognia.com/v1beta1`,
+			},
+			{
+				Path:    invalid,
+				Rule:    "naming",
+				Message: "metadata.name Employees is invalid: a lowercase RFC 1123 subdomain must consist of lower case alphanumeric characters, '-' or '.', and must start and end with an alphanumeric character (e.g. 'example.com', regex used for validation is '[a-z0-9]([-a-z0-9]*[a-z0-9])?(\\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*')",
+			},
+			{
+				Path:    invalid,
+				Rule:    "schema",
+				Message: "spec.applicationTemplates[0].spec.destination has neither server nor name",
+			},
+			{
+				Path:    invalid,
+				Rule:    "schema",
+				Message: "spec.applicationTemplates[0].spec.source.repoURL is empty",
+			},
+		}))
+		g.Expect(findings[5].Path).To(g.Equal(missing))
+		g.Expect(findings[5].Rule).To(g.Equal("read"))
+	})
+
+	ginkgo.It("reports generation errors", func() {
+		findings, err := lint(write("employees.yaml", `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  banner:
+    severity: urgent
+`))
+		g.Expect(err).To(g.MatchError(argocdproject.ErrFindings))
+		g.Expect(findings).To(g.HaveLen(1))
+		g.Expect(findings[0].Rule).To(g.Equal("generate"))
+	})
+
+	ginkgo.It("parses policies of roles", func() {
+		policyTemplates := write("policies.yaml", `
+read-only:
+  - p, proj:{{project}}:{{role}}, applications, get, "{{project}}/*", allow
+read-sync:
+  - p, proj:{{project}}:{{role}}, applications, sync, other/*, allow
+  - p, proj:{{project}}:{{role}}, applications, sync, {{project}}/*
+  - g, proj:{{project}}:{{role}}, proj:other:read-only
+`)
+		findings, err := lint(write("employees.yaml", `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    policyTemplates: `+policyTemplates+`
+    readSync: ["sre,eng-0"]
+`))
+		g.Expect(err).To(g.MatchError(argocdproject.ErrFindings))
+		g.Expect(findings).To(g.HaveLen(4))
+		g.Expect(findings[0].Message).To(g.Equal(`role read-sync: policy "p, proj:employees:read-sync, applications, sync, other/*, allow" has object other/* outside of project employees`))
+		g.Expect(findings[1].Message).To(g.Equal(`role read-sync: policy "p, proj:employees:read-sync, applications, sync, employees/*" has 4 fields instead of the 5 of its type`))
+		g.Expect(findings[2].Message).To(g.Equal(`role read-sync: policy "g, proj:employees:read-sync, proj:other:read-only" inherits role proj:other:read-only outside of project employees`))
+		g.Expect(findings[3].Message).To(g.Equal(`role read-sync: group "sre,eng-0" must be quoted`))
+	})
+
+	ginkgo.It("writes findings as text", func() {
+		path := write("employees.yaml", "kind: ArgoCDProject\napiVersion: incognia.com/v1alpha1\n")
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Lint([]string{path}, &out)).To(g.MatchError(argocdproject.ErrFindings))
+		g.Expect(out.String()).To(g.Equal(path + ": schema: metadata.name is empty\n"))
+	})
+
+	ginkgo.It("writes findings as SARIF", func() {
+		path := write("employees.yaml", "kind: ArgoCDProject\napiVersion: incognia.com/v1alpha1\n")
+
+		var out bytes.Buffer
+		g.Expect(argocdproject.Lint([]string{"-format", "sarif", path}, &out)).To(g.MatchError(argocdproject.ErrFindings))
+
+		var log struct {
+			Runs []struct {
+				Results []struct {
+					RuleID    string `json:"ruleId"`
+					Locations []struct {
+						PhysicalLocation struct {
+							ArtifactLocation struct {
+								URI string `json:"uri"`
+							} `json:"artifactLocation"`
+						} `json:"physicalLocation"`
+					} `json:"locations"`
+				} `json:"results"`
+			} `json:"runs"`
+		}
+		g.Expect(json.Unmarshal(out.Bytes(), &log)).To(g.Succeed())
+		g.Expect(log.Runs).To(g.HaveLen(1))
+		g.Expect(log.Runs[0].Results).To(g.HaveLen(1))
+		g.Expect(log.Runs[0].Results[0].RuleID).To(g.Equal("schema"))
+		g.Expect(log.Runs[0].Results[0].Locations[0].PhysicalLocation.ArtifactLocation.URI).To(g.Equal("file://" + path))
+	})
+})
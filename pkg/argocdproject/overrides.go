@@ -0,0 +1,340 @@
+package argocdproject
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+const (
+	overrideSeparator      = "="
+	escapedPathSeparator   = `\.`
+	escapedPathPlaceholder = "\x00"
+	indexExpressionSuffix  = "]"
+)
+
+var (
+	indexExpression = regexp.MustCompile(`^(.*)\[([0-9]+)\]$`)
+)
+
+// applyOverrides sets the fields of overrides in a project file, each a
+// path=value pair whose path indexes lists with [n], so renders can be tweaked
+// without editing files.
+func applyOverrides(data []byte, overrides []string) ([]byte, error) {
+	if len(overrides) == 0 {
+		return data, nil
+	}
+
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	for _, override := range overrides {
+		i := strings.Index(override, overrideSeparator)
+		if i < 0 {
+			return nil, fmt.Errorf("--set %s is not of the form path=value", override)
+		}
+
+		segments := splitPath(override[:i])
+		value := overrideValue(override[i+1:], fieldType(projectType(object), segments))
+
+		if err := setPath(object, segments, value); err != nil {
+			return nil, fmt.Errorf("--set %s: %w", override, err)
+		}
+	}
+
+	return json.Marshal(object)
+}
+
+// overrideValue returns the value of an override as the type of its field:
+// booleans and numbers are kept as written for fields of strings, and numbers
+// are decoded without rounding them to floats, so neither is mangled.
+func overrideValue(raw string, field reflect.Type) interface{} {
+	if field != nil && field.Kind() == reflect.String {
+		return raw
+	}
+
+	b, err := yaml.YAMLToJSON([]byte(raw))
+	if err != nil {
+		return raw
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return raw
+	}
+
+	switch v.(type) {
+	case bool, json.Number:
+		return v
+	}
+
+	return raw
+}
+
+// projectType returns the type a project file is decoded into, as
+// decodeProject does.
+func projectType(object map[string]interface{}) reflect.Type {
+	if object["apiVersion"] == v1beta1APIVersion {
+		return reflect.TypeOf(ArgoCDProjectV1beta1{})
+	}
+
+	return reflect.TypeOf(ArgoCDProject{})
+}
+
+// fieldType returns the type of the field of a path, or nil when the path
+// leads to no field of the type, such as unknown fields.
+func fieldType(t reflect.Type, segments []string) reflect.Type {
+	for _, segment := range segments {
+		indexed := false
+		if match := indexExpression.FindStringSubmatch(segment); match != nil {
+			segment, indexed = match[1], true
+		}
+
+		switch t = indirectType(t); t.Kind() {
+		case reflect.Struct:
+			t = structFieldType(t, segment)
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil
+		}
+		if t == nil {
+			return nil
+		}
+
+		if indexed {
+			if t = indirectType(t); t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+				return nil
+			}
+			t = t.Elem()
+		}
+	}
+
+	return indirectType(t)
+}
+
+// structFieldType finds fields case-insensitively, as they are decoded, and
+// within inlined structs.
+func structFieldType(t reflect.Type, name string) reflect.Type {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+
+		tag, options := parseJSONTag(field)
+		if tag == jsonTagSkip {
+			continue
+		}
+
+		if (field.Anonymous && tag == "") || strings.Contains(options, jsonTagInline) {
+			if inlined := indirectType(field.Type); inlined.Kind() == reflect.Struct {
+				if inlinedType := structFieldType(inlined, name); inlinedType != nil {
+					return inlinedType
+				}
+			}
+			continue
+		}
+
+		if tag == "" {
+			tag = field.Name
+		}
+		if strings.EqualFold(tag, name) {
+			return field.Type
+		}
+	}
+
+	return nil
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t
+}
+
+// applyTerraformOutputs substitutes the outputs of Terraform into the strings
+// of a project file, before it is decoded, so they may set any field.
+func applyTerraformOutputs(data []byte, outputs terraform.Outputs) ([]byte, error) {
+	if outputs == nil {
+		return data, nil
+	}
+
+	var object map[string]interface{}
+	if err := yaml.Unmarshal(data, &object); err != nil {
+		return nil, err
+	}
+
+	substituted, err := outputs.SubstituteAll(object)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(substituted)
+}
+
+// applyDefaults sets the fields of defaults the spec of a project leaves
+// unset, whose objects are merged field by field. Defaults are applied to the
+// hub, so their fields are the same whatever the apiVersion of the file.
+func applyDefaults(argocdProject *ArgoCDProject, defaults *ProjectSpec) error {
+	if defaults == nil {
+		return nil
+	}
+
+	object, err := toMapWithoutStatusField(argocdProject)
+	if err != nil {
+		return err
+	}
+
+	spec, err := toMapWithoutStatusField(defaults)
+	if err != nil {
+		return err
+	}
+
+	object = mergeDefaults(object, map[string]interface{}{
+		yamlSpecField: pruneResolved(spec),
+	})
+
+	b, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	*argocdProject = ArgoCDProject{}
+	return json.Unmarshal(b, argocdProject)
+}
+
+func mergeDefaults(object map[string]interface{}, defaults map[string]interface{}) map[string]interface{} {
+	if object == nil {
+		object = make(map[string]interface{})
+	}
+
+	for key, value := range defaults {
+		if value == nil {
+			continue
+		}
+
+		// Fields are decoded case-insensitively, so defaults never add a
+		// field declared with another case.
+		for objectKey := range object {
+			if strings.EqualFold(objectKey, key) {
+				key = objectKey
+				break
+			}
+		}
+
+		valueMap, ok := value.(map[string]interface{})
+		if !ok {
+			if _, exists := object[key]; !exists {
+				object[key] = value
+			}
+			continue
+		}
+
+		objectMap, ok := object[key].(map[string]interface{})
+		if !ok && object[key] != nil {
+			continue
+		}
+		object[key] = mergeDefaults(objectMap, valueMap)
+	}
+
+	return object
+}
+
+// splitPath splits a path on dots, except escaped ones.
+func splitPath(path string) []string {
+	segments := strings.Split(strings.ReplaceAll(path, escapedPathSeparator, escapedPathPlaceholder), pathSeparator)
+	for i := range segments {
+		segments[i] = strings.ReplaceAll(segments[i], escapedPathPlaceholder, pathSeparator)
+	}
+
+	return segments
+}
+
+func setPath(object map[string]interface{}, segments []string, value interface{}) error {
+	segment := segments[0]
+
+	index := -1
+	if match := indexExpression.FindStringSubmatch(segment); match != nil {
+		segment = match[1]
+		index, _ = strconv.Atoi(match[2])
+	} else if strings.HasSuffix(segment, indexExpressionSuffix) {
+		return fmt.Errorf("%s has an invalid index", segment)
+	}
+
+	if segment == "" {
+		return fmt.Errorf("path has an empty field")
+	}
+
+	// Fields are decoded case-insensitively, so overrides replace the
+	// declared fields whatever their case.
+	for key := range object {
+		if strings.EqualFold(key, segment) {
+			segment = key
+			break
+		}
+	}
+
+	if index < 0 {
+		if len(segments) == 1 {
+			object[segment] = value
+			return nil
+		}
+
+		child, ok := object[segment].(map[string]interface{})
+		if !ok {
+			if object[segment] != nil {
+				return fmt.Errorf("%s is not an object", segment)
+			}
+			child = make(map[string]interface{})
+			object[segment] = child
+		}
+
+		return setPath(child, segments[1:], value)
+	}
+
+	list, ok := object[segment].([]interface{})
+	if !ok && object[segment] != nil {
+		return fmt.Errorf("%s is not a list", segment)
+	}
+
+	switch {
+	case index == len(list):
+		list = append(list, nil)
+	case index > len(list):
+		return fmt.Errorf("%s[%d] is out of range", segment, index)
+	}
+	object[segment] = list
+
+	if len(segments) == 1 {
+		list[index] = value
+		return nil
+	}
+
+	child, ok := list[index].(map[string]interface{})
+	if !ok {
+		if list[index] != nil {
+			return fmt.Errorf("%s[%d] is not an object", segment, index)
+		}
+		child = make(map[string]interface{})
+		list[index] = child
+	}
+
+	return setPath(child, segments[1:], value)
+}
@@ -0,0 +1,158 @@
+package argocdproject_test
+
+import (
+	"bytes"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+const (
+	overriddenProject = `
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+  accessControl:
+    readOnly: [sre:eng-1]
+    readSync: [sre:eng-0]
+  applicationTemplates:
+    - metadata:
+        name: employees
+      spec:
+        source:
+          repoURL: https://github.com/inloco/employees.git
+        destination:
+          name: Global-Product
+          namespace: employees
+`
+)
+
+var _ = ginkgo.Describe("Overrides", func() {
+	generate := func(overrides ...string) (*argov1alpha1.AppProject, *argov1alpha1.Application, error) {
+		var out bytes.Buffer
+		if err := argocdproject.GenerateManifestsWithOptions([]byte(overriddenProject), argocdproject.Options{Overrides: overrides}, &out); err != nil {
+			return nil, nil, err
+		}
+
+		manifests := separatorYaml.Split(out.String(), -1)
+		g.Expect(manifests).To(g.HaveLen(2))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(manifests[0]), &appProject)).To(g.Succeed())
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal([]byte(manifests[1]), &app)).To(g.Succeed())
+
+		return &appProject, &app, nil
+	}
+
+	ginkgo.It("overrides fields after loading the file", func() {
+		appProject, app, err := generate(
+			"spec.environment=staging",
+			"spec.accessControl.ReadSync[0]=group-x",
+			"spec.accessControl.ReadSync[1]=group-y",
+			"spec.applicationTemplates[0].metadata.annotations.incognia\\.com/owner=sre",
+			"spec.changeFreeze=true",
+		)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(appProject.Spec.Roles[1].Groups).To(g.Equal([]string{"group-x", "group-y"}))
+		g.Expect(app.Spec.Source.TargetRevision).To(g.Equal("env-staging"))
+		g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/owner", "sre"))
+		g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/banner-severity", "critical"))
+	})
+
+	ginkgo.It("keeps values as the types of their fields", func() {
+		appProject, app, err := generate(
+			"spec.environment=2024",
+			"spec.accessControl.readOnly[0]=true",
+			"spec.applicationTemplates[0].metadata.annotations.incognia\\.com/build=12345678901234567890",
+			"spec.applicationTemplates[0].metadata.annotations.incognia\\.com/version=1.10",
+			"spec.applicationTemplates[0].spec.revisionHistoryLimit=5",
+		)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(appProject.Spec.Roles[0].Groups).To(g.Equal([]string{"true"}))
+		g.Expect(app.Spec.Source.TargetRevision).To(g.Equal("env-2024"))
+		g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/build", "12345678901234567890"))
+		g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/version", "1.10"))
+		g.Expect(app.Spec.RevisionHistoryLimit).NotTo(g.BeNil())
+		g.Expect(*app.Spec.RevisionHistoryLimit).To(g.Equal(int64(5)))
+	})
+
+	ginkgo.It("keeps the file without overrides", func() {
+		appProject, app, err := generate()
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(appProject.Spec.Roles[1].Groups).To(g.Equal([]string{"sre:eng-0"}))
+		g.Expect(app.Spec.Source.TargetRevision).To(g.Equal("env-production"))
+	})
+
+	ginkgo.DescribeTable("fails", func(override string, expectedError string) {
+		_, _, err := generate(override)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without value", "spec.environment", "--set spec.environment is not of the form path=value"),
+		ginkgo.Entry("with indexes out of range", "spec.accessControl.readOnly[2]=x", "--set spec.accessControl.readOnly[2]=x: readOnly[2] is out of range"),
+		ginkgo.Entry("with invalid indexes", "spec.accessControl.readOnly[a]=x", "--set spec.accessControl.readOnly[a]=x: readOnly[a] has an invalid index"),
+		ginkgo.Entry("with fields of scalars", "spec.environment.name=x", "--set spec.environment.name=x: environment is not an object"),
+		ginkgo.Entry("with indexes of objects", "spec[0]=x", "--set spec[0]=x: spec is not a list"),
+	)
+})
+
+var _ = ginkgo.Describe("TerraformOutputs", func() {
+	const project = `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+        annotations:
+          incognia.com/role: "{{terraform.roles.payroll}}"
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: "{{terraform.cluster_endpoint}}"
+          namespace: payroll
+`
+
+	outputs := terraform.Outputs{
+		"cluster_endpoint": "https://eks.example.com",
+		"roles": map[string]interface{}{
+			"payroll": "arn:aws:iam::123456789012:role/payroll",
+		},
+	}
+
+	ginkgo.It("substitutes the outputs of Terraform", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+			TerraformOutputs: outputs,
+			Output:           argocdproject.OutputApplications,
+		}, &out)).To(g.Succeed())
+
+		var app argov1alpha1.Application
+		g.Expect(yaml.Unmarshal(out.Bytes(), &app)).To(g.Succeed())
+		g.Expect(app.Spec.Destination.Server).To(g.Equal("https://eks.example.com"))
+		g.Expect(app.Annotations).To(g.HaveKeyWithValue("incognia.com/role", "arn:aws:iam::123456789012:role/payroll"))
+	})
+
+	ginkgo.It("fails on missing outputs", func() {
+		err := argocdproject.GenerateManifestsWithOptions([]byte(project), argocdproject.Options{
+			TerraformOutputs: terraform.Outputs{
+				"cluster_endpoint": "https://eks.example.com",
+			},
+		}, &bytes.Buffer{})
+		g.Expect(err).To(g.MatchError("terraform output roles.payroll is missing"))
+	})
+})
@@ -0,0 +1,83 @@
+package argocdproject
+
+import (
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/argoproj/argo-cd/v2/util/assets"
+	"github.com/argoproj/argo-cd/v2/util/rbac"
+	"github.com/casbin/casbin/v2/model"
+)
+
+const (
+	policyCommentPrefix = "#"
+)
+
+var (
+	// casbinModel is the built-in model of Argo CD, whose policy and role
+	// definitions are the policy types of project roles.
+	casbinModel = func() model.Model {
+		m, err := model.NewModelFromString(assets.ModelConf)
+		if err != nil {
+			panic(err)
+		}
+
+		return m
+	}()
+)
+
+// validateCasbinPolicies parses the policies of the roles of an AppProject as
+// Argo CD loads them, which silently ignores the lines it cannot use, so broken
+// policy templates fail at build time.
+func validateCasbinPolicies(appProject *argov1alpha1.AppProject) error {
+	for _, role := range appProject.Spec.Roles {
+		for _, policy := range role.Policies {
+			if _, err := parseCasbinPolicy(policy); err != nil {
+				return fmt.Errorf("role %s: %w", role.Name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// parseCasbinPolicy loads the policy with the rbac package of Argo CD and
+// returns its fields, as its loader splits them, after checking them against
+// the definition of its type in the model of Argo CD.
+func parseCasbinPolicy(policy string) ([]string, error) {
+	if strings.TrimSpace(policy) == "" || strings.HasPrefix(policy, policyCommentPrefix) {
+		return nil, fmt.Errorf("policy %q is empty or a comment", policy)
+	}
+
+	if err := rbac.ValidatePolicy(policy); err != nil {
+		return nil, fmt.Errorf("policy %q cannot be loaded by Argo CD", policy)
+	}
+
+	reader := csv.NewReader(strings.NewReader(policy))
+	reader.TrimLeadingSpace = true
+
+	tokens, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("policy %q: %w", policy, err)
+	}
+
+	fields := -1
+	if assertion, exists := casbinModel[policyRule][tokens[0]]; exists {
+		fields = len(assertion.Tokens)
+	}
+	// roles are defined by their placeholders, as in _, _
+	if assertion, exists := casbinModel[groupingRule][tokens[0]]; exists {
+		fields = strings.Count(assertion.Value, "_")
+	}
+	if fields < 0 {
+		return nil, fmt.Errorf("policy %q has type %s, which the casbin model of Argo CD does not define", policy, tokens[0])
+	}
+
+	if len(tokens)-1 != fields {
+		return nil, fmt.Errorf("policy %q has %d fields instead of the %d of its type", policy, len(tokens)-1, fields)
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,50 @@
+package argocdproject_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Policies", func() {
+	var policyTemplates string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "argocdproject")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		policyTemplates = filepath.Join(dir, "policies.yaml")
+	})
+
+	ginkgo.DescribeTable("are parsed as casbin loads them", func(policy string, expectedError string) {
+		g.Expect(ioutil.WriteFile(policyTemplates, []byte("read-only:\n  - '"+policy+"'\n"), 0644)).To(g.Succeed())
+
+		err := argocdproject.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  accessControl:
+    policyTemplates: `+policyTemplates+`
+`), ioutil.Discard)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("policy rules", "p, proj:{{project}}:{{role}}, applications, get, {{project}}/*, allow", ""),
+		ginkgo.Entry("quoted fields", `p, proj:{{project}}:{{role}}, applications, get, "{{project}}/*", allow`, ""),
+		ginkgo.Entry("grouping rules", "g, proj:{{project}}:{{role}}, proj:{{project}}:read-sync", ""),
+		ginkgo.Entry("comments", "# p, proj:{{project}}:{{role}}, *, get, {{project}}/*, allow", `spec.accessControl: role read-only: policy "# p, proj:employees:read-only, *, get, employees/*, allow" is empty or a comment`),
+		ginkgo.Entry("unknown types", "r, proj:{{project}}:{{role}}, applications, get, {{project}}/*, allow", `spec.accessControl: role read-only: policy "r, proj:employees:read-only, applications, get, employees/*, allow" has type r, which the casbin model of Argo CD does not define`),
+		ginkgo.Entry("missing fields", "p, proj:{{project}}:{{role}}, applications, get, {{project}}/*", `spec.accessControl: role read-only: policy "p, proj:employees:read-only, applications, get, employees/*" has 4 fields instead of the 5 of its type`),
+		ginkgo.Entry("unterminated quotes", `p, proj:{{project}}:{{role}}, applications, get, "{{project}}/*, allow`, `spec.accessControl: role read-only: policy "p, proj:employees:read-only, applications, get, \"employees/*, allow" cannot be loaded by Argo CD`),
+	)
+})
@@ -0,0 +1,287 @@
+package argocdproject
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+const (
+	schemaFormatCRD     = "crd"
+	schemaFormatOpenAPI = "openapi"
+
+	jsonTagSeparator = ","
+	jsonTagInline    = "inline"
+	jsonTagSkip      = "-"
+	metadataField    = "metadata"
+
+	schemaTypeObject  = "object"
+	schemaTypeArray   = "array"
+	schemaTypeString  = "string"
+	schemaTypeInteger = "integer"
+	schemaTypeNumber  = "number"
+	schemaTypeBoolean = "boolean"
+
+	dateTimeFormat = "date-time"
+	byteFormat     = "byte"
+)
+
+var (
+	// The types below marshal themselves differently from their fields.
+	dateTimeTypes = []reflect.Type{
+		reflect.TypeOf(metav1.Time{}),
+		reflect.TypeOf(metav1.MicroTime{}),
+	}
+	stringTypes = []reflect.Type{
+		reflect.TypeOf(metav1.Duration{}),
+	}
+	intOrStringTypes = []reflect.Type{
+		reflect.TypeOf(intstr.IntOrString{}),
+		reflect.TypeOf(resource.Quantity{}),
+	}
+	unknownFieldsTypes = []reflect.Type{
+		reflect.TypeOf(runtime.RawExtension{}),
+		reflect.TypeOf(apiextensionsv1.JSON{}),
+		reflect.TypeOf(json.RawMessage{}),
+		reflect.TypeOf(metav1.ObjectMeta{}),
+	}
+
+	schemaEnums = map[reflect.Type][]string{
+		reflect.TypeOf(Severity("")): {string(Info), string(Warning), string(Critical)},
+	}
+
+	projectTypes = map[string]reflect.Type{
+		v1alpha1APIVersion: reflect.TypeOf(ArgoCDProject{}),
+		v1beta1APIVersion:  reflect.TypeOf(ArgoCDProjectV1beta1{}),
+	}
+)
+
+// Schema writes the schema of ArgoCDProject, generated from its types, as a
+// CustomResourceDefinition of every apiVersion or as the OpenAPI schema of one,
+// so editors and CI can validate project files.
+func Schema(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("schema", flag.ContinueOnError)
+	format := flags.String("format", schemaFormatCRD, "format of the schema, crd or openapi")
+	apiVersion := flags.String("api-version", projectAPIVersion, "apiVersion of the openapi schema")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	switch *format {
+	case schemaFormatOpenAPI:
+		if err := validateAPIVersion(*apiVersion); err != nil {
+			return err
+		}
+
+		encoder := json.NewEncoder(out)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(makeProjectSchema(*apiVersion))
+	case schemaFormatCRD:
+		b, err := makeCustomResourceDefinition()
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(b)
+		return err
+	default:
+		return fmt.Errorf("unknown format %s", *format)
+	}
+}
+
+func makeProjectSchema(apiVersion string) apiextensionsv1.JSONSchemaProps {
+	props := makeSchema(projectTypes[apiVersion], nil)
+	props.Properties[metadataField] = apiextensionsv1.JSONSchemaProps{
+		Type: schemaTypeObject,
+	}
+
+	return props
+}
+
+// makeCustomResourceDefinition serves every apiVersion, storing the latest.
+func makeCustomResourceDefinition() ([]byte, error) {
+	groupVersion, err := schema.ParseGroupVersion(projectAPIVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]apiextensionsv1.CustomResourceDefinitionVersion, 0, len(apiVersions))
+	for _, apiVersion := range apiVersions {
+		versionGroupVersion, err := schema.ParseGroupVersion(apiVersion)
+		if err != nil {
+			return nil, err
+		}
+
+		// The status is written by the controller, never by project files.
+		props := makeProjectSchema(apiVersion)
+		props.Properties[yamlStatusField] = makeSchema(reflect.TypeOf(ProjectStatus{}), nil)
+		versions = append(versions, apiextensionsv1.CustomResourceDefinitionVersion{
+			Name:    versionGroupVersion.Version,
+			Served:  true,
+			Storage: apiVersion == projectAPIVersion,
+			Schema: &apiextensionsv1.CustomResourceValidation{
+				OpenAPIV3Schema: &props,
+			},
+			Subresources: &apiextensionsv1.CustomResourceSubresources{
+				Status: &apiextensionsv1.CustomResourceSubresourceStatus{},
+			},
+		})
+	}
+
+	names := makeProjectNames()
+
+	return marshalYAMLWithoutStatusField(apiextensionsv1.CustomResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: apiextensionsv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(apiextensionsv1.CustomResourceDefinition{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s.%s", names.Plural, groupVersion.Group),
+		},
+		Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+			Group:    groupVersion.Group,
+			Names:    names,
+			Scope:    apiextensionsv1.NamespaceScoped,
+			Versions: versions,
+		},
+	})
+}
+
+func makeProjectNames() apiextensionsv1.CustomResourceDefinitionNames {
+	kind := reflect.TypeOf(ArgoCDProject{}).Name()
+
+	return apiextensionsv1.CustomResourceDefinitionNames{
+		Kind:     kind,
+		ListKind: kind + "List",
+		Plural:   strings.ToLower(kind) + "s",
+		Singular: strings.ToLower(kind),
+	}
+}
+
+// makeSchema follows the JSON encoding of a type, where types already being
+// walked, which are recursive, accept any fields.
+func makeSchema(t reflect.Type, walking map[reflect.Type]bool) apiextensionsv1.JSONSchemaProps {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case containsType(dateTimeTypes, t):
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeString, Format: dateTimeFormat}
+	case containsType(stringTypes, t):
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeString}
+	case containsType(intOrStringTypes, t):
+		return apiextensionsv1.JSONSchemaProps{XIntOrString: true}
+	case containsType(unknownFieldsTypes, t), t.Kind() == reflect.Interface, walking[t]:
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeObject, XPreserveUnknownFields: boolPtr(true)}
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		props := apiextensionsv1.JSONSchemaProps{
+			Type:       schemaTypeObject,
+			Properties: make(map[string]apiextensionsv1.JSONSchemaProps),
+		}
+
+		nested := make(map[reflect.Type]bool, len(walking)+1)
+		for walkingType := range walking {
+			nested[walkingType] = true
+		}
+		nested[t] = true
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" && !field.Anonymous {
+				continue
+			}
+
+			name, options := parseJSONTag(field)
+			if name == jsonTagSkip {
+				continue
+			}
+
+			fieldProps := makeSchema(field.Type, nested)
+			if (field.Anonymous && name == "") || strings.Contains(options, jsonTagInline) {
+				for property, propertyProps := range fieldProps.Properties {
+					props.Properties[property] = propertyProps
+				}
+				continue
+			}
+
+			if name == "" {
+				name = field.Name
+			}
+			props.Properties[name] = fieldProps
+		}
+
+		return props
+	case reflect.Map:
+		valueProps := makeSchema(t.Elem(), walking)
+		return apiextensionsv1.JSONSchemaProps{
+			Type: schemaTypeObject,
+			AdditionalProperties: &apiextensionsv1.JSONSchemaPropsOrBool{
+				Allows: true,
+				Schema: &valueProps,
+			},
+		}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return apiextensionsv1.JSONSchemaProps{Type: schemaTypeString, Format: byteFormat}
+		}
+
+		itemProps := makeSchema(t.Elem(), walking)
+		return apiextensionsv1.JSONSchemaProps{
+			Type: schemaTypeArray,
+			Items: &apiextensionsv1.JSONSchemaPropsOrArray{
+				Schema: &itemProps,
+			},
+		}
+	case reflect.String:
+		props := apiextensionsv1.JSONSchemaProps{Type: schemaTypeString}
+		for _, value := range schemaEnums[t] {
+			props.Enum = append(props.Enum, apiextensionsv1.JSON{Raw: []byte(fmt.Sprintf("%q", value))})
+		}
+		return props
+	case reflect.Bool:
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeBoolean}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeInteger}
+	case reflect.Float32, reflect.Float64:
+		return apiextensionsv1.JSONSchemaProps{Type: schemaTypeNumber}
+	default:
+		return apiextensionsv1.JSONSchemaProps{XPreserveUnknownFields: boolPtr(true)}
+	}
+}
+
+func parseJSONTag(field reflect.StructField) (string, string) {
+	tag := field.Tag.Get("json")
+	if i := strings.Index(tag, jsonTagSeparator); i >= 0 {
+		return tag[:i], tag[i+1:]
+	}
+
+	return tag, ""
+}
+
+func containsType(slice []reflect.Type, t reflect.Type) bool {
+	for _, item := range slice {
+		if item == t {
+			return true
+		}
+	}
+
+	return false
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
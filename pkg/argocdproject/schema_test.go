@@ -0,0 +1,79 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Schema", func() {
+	ginkgo.It("generates a CustomResourceDefinition", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.Schema(nil, &out)).To(g.Succeed())
+
+		var crd apiextensionsv1.CustomResourceDefinition
+		g.Expect(yaml.UnmarshalStrict(out.Bytes(), &crd)).To(g.Succeed())
+		g.Expect(crd.Name).To(g.Equal("argocdprojects.incognia.com"))
+		g.Expect(crd.Spec.Group).To(g.Equal("incognia.com"))
+		g.Expect(crd.Spec.Names.Kind).To(g.Equal("ArgoCDProject"))
+		g.Expect(crd.Spec.Versions).To(g.HaveLen(2))
+		g.Expect(crd.Spec.Versions[0].Name).To(g.Equal("v1alpha1"))
+		g.Expect(crd.Spec.Versions[0].Storage).To(g.BeFalse())
+		g.Expect(crd.Spec.Versions[1].Name).To(g.Equal("v1beta1"))
+		g.Expect(crd.Spec.Versions[1].Storage).To(g.BeTrue())
+
+		props := crd.Spec.Versions[1].Schema.OpenAPIV3Schema
+		g.Expect(props.Properties["metadata"]).To(g.Equal(apiextensionsv1.JSONSchemaProps{Type: "object"}))
+		g.Expect(props.Properties).To(g.HaveKey("spec"))
+		g.Expect(props.Properties["status"].Properties["conditions"].Items.Schema.Properties).To(g.HaveKey("reason"))
+		g.Expect(crd.Spec.Versions[1].Subresources.Status).NotTo(g.BeNil())
+	})
+
+	ginkgo.It("generates the OpenAPI schema from the types", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.Schema([]string{"-format", "openapi"}, &out)).To(g.Succeed())
+
+		var props apiextensionsv1.JSONSchemaProps
+		g.Expect(json.Unmarshal(out.Bytes(), &props)).To(g.Succeed())
+
+		spec := props.Properties["spec"]
+		g.Expect(spec.Properties["environments"].Items.Schema.Type).To(g.Equal("string"))
+		g.Expect(spec.Properties["changeFreeze"].Type).To(g.Equal("boolean"))
+		g.Expect(spec.Properties["accessControl"].Properties["readOnlyGroups"].Items.Schema.Type).To(g.Equal("string"))
+		g.Expect(spec.Properties["accessControl"].Properties["breakGlass"].Properties["expiresAt"]).To(g.Equal(apiextensionsv1.JSONSchemaProps{
+			Type:   "string",
+			Format: "date-time",
+		}))
+		g.Expect(spec.Properties["banner"].Properties["severity"].Enum).To(g.Equal([]apiextensionsv1.JSON{
+			{Raw: []byte(`"info"`)},
+			{Raw: []byte(`"warning"`)},
+			{Raw: []byte(`"critical"`)},
+		}))
+
+		app := spec.Properties["applicationTemplates"].Items.Schema
+		g.Expect(*app.Properties["metadata"].XPreserveUnknownFields).To(g.BeTrue())
+		g.Expect(app.Properties["spec"].Properties["destination"].Properties).To(g.HaveKey("namespace"))
+	})
+
+	ginkgo.It("generates the OpenAPI schema of previous apiVersions", func() {
+		var out bytes.Buffer
+		g.Expect(argocdproject.Schema([]string{"-format", "openapi", "-api-version", "incognia.com/v1alpha1"}, &out)).To(g.Succeed())
+
+		var props apiextensionsv1.JSONSchemaProps
+		g.Expect(json.Unmarshal(out.Bytes(), &props)).To(g.Succeed())
+
+		spec := props.Properties["spec"]
+		g.Expect(spec.Properties["environment"].Type).To(g.Equal("string"))
+		g.Expect(spec.Properties["accessControl"].Properties["ReadOnly"].Items.Schema.Type).To(g.Equal("string"))
+	})
+
+	ginkgo.It("fails on unknown formats", func() {
+		g.Expect(argocdproject.Schema([]string{"-format", "xsd"}, &bytes.Buffer{})).To(g.MatchError("unknown format xsd"))
+	})
+})
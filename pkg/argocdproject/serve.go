@@ -0,0 +1,193 @@
+package argocdproject
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	serveAddress         = ":8080"
+	serveShutdownTimeout = 30 * time.Second
+
+	generatePath = "/generate"
+	healthPath   = "/healthz"
+	metricsPath  = "/metrics"
+
+	manifestsContentType = "application/yaml"
+	textContentType      = "text/plain; charset=utf-8"
+	metricsContentType   = "text/plain; version=0.0.4; charset=utf-8"
+
+	// maxProjectSize bounds the project files read by the generate endpoint.
+	maxProjectSize = 1 << 20
+)
+
+// Serve serves the generation of manifests over HTTP until it is terminated,
+// so project files are previewed without running the plugin for each one.
+func Serve(args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	address := flags.String("address", serveAddress, "address the server listens on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := &http.Server{
+		Addr:    *address,
+		Handler: NewHandler(),
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if _, err := fmt.Fprintf(out, "serving on %s\n", *address); err != nil {
+		return err
+	}
+
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// NewHandler returns the HTTP handler of Serve, for tools serving it on their
+// own servers. POST /generate takes a project file as the body and returns its
+// manifests, where the query parameters env, namespace, output, validation,
+// omit-empty, empty-templates and set match the flags of the plugin. GET
+// /healthz and GET /metrics report the health and the Prometheus metrics of the
+// handler.
+func NewHandler() http.Handler {
+	metrics := &generateMetrics{
+		requests: map[int]uint64{},
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(generatePath, metrics.instrument(http.HandlerFunc(handleGenerate)))
+	mux.HandleFunc(healthPath, handleHealth)
+	mux.Handle(metricsPath, metrics)
+
+	return mux
+}
+
+func handleGenerate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxProjectSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	query := r.URL.Query()
+	options := Options{
+		Environment:    query.Get("env"),
+		Namespace:      query.Get("namespace"),
+		Validation:     ValidationLevel(query.Get("validation")),
+		Overrides:      query["set"],
+		Output:         Output(query.Get("output")),
+		OmitEmpty:      query.Get("omit-empty") == "true",
+		EmptyTemplates: EmptyTemplatesPolicy(query.Get("empty-templates")),
+		// callers must not read the files of the server
+		RejectPaths: true,
+	}
+
+	// errors are logged instead of answered, as they may quote the files
+	// the project names
+	var out bytes.Buffer
+	if err := GenerateManifestsWithOptions(data, options, &out); err != nil {
+		log.Printf("%s: %v", generatePath, err)
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("Content-Type", manifestsContentType)
+	out.WriteTo(w)
+}
+
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", textContentType)
+	io.WriteString(w, "ok\n")
+}
+
+// generateMetrics counts the requests of the generate endpoint by status code
+// and sums their duration, written in the Prometheus text format.
+type generateMetrics struct {
+	mutex    sync.Mutex
+	requests map[int]uint64
+	count    uint64
+	seconds  float64
+}
+
+func (m *generateMetrics) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+		m.observe(recorder.status, time.Since(start))
+	})
+}
+
+func (m *generateMetrics) observe(status int, duration time.Duration) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.requests[status]++
+	m.count++
+	m.seconds += duration.Seconds()
+}
+
+func (m *generateMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	statuses := make([]int, 0, len(m.requests))
+	for status := range m.requests {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	w.Header().Set("Content-Type", metricsContentType)
+	fmt.Fprintln(w, "# HELP argocdproject_generate_requests_total Requests to generate manifests by status code.")
+	fmt.Fprintln(w, "# TYPE argocdproject_generate_requests_total counter")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "argocdproject_generate_requests_total{code=%q} %d\n", strconv.Itoa(status), m.requests[status])
+	}
+	fmt.Fprintln(w, "# HELP argocdproject_generate_duration_seconds Duration of the requests to generate manifests.")
+	fmt.Fprintln(w, "# TYPE argocdproject_generate_duration_seconds summary")
+	fmt.Fprintf(w, "argocdproject_generate_duration_seconds_sum %g\n", m.seconds)
+	fmt.Fprintf(w, "argocdproject_generate_duration_seconds_count %d\n", m.count)
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
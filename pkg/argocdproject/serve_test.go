@@ -0,0 +1,98 @@
+package argocdproject_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Serve", func() {
+	const project = `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          name: global-product
+          namespace: payroll
+`
+
+	var server *httptest.Server
+	ginkgo.BeforeEach(func() {
+		server = httptest.NewServer(argocdproject.NewHandler())
+		ginkgo.DeferCleanup(server.Close)
+	})
+
+	request := func(method string, path string, body string) (int, string) {
+		req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		res, err := http.DefaultClient.Do(req)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		defer res.Body.Close()
+
+		data, err := ioutil.ReadAll(res.Body)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		return res.StatusCode, string(data)
+	}
+
+	ginkgo.It("generates the manifests of project files", func() {
+		status, body := request(http.MethodPost, "/generate?env=staging&output=applications", project)
+		g.Expect(status).To(g.Equal(http.StatusOK))
+		g.Expect(body).NotTo(g.ContainSubstring("kind: AppProject\n"))
+		g.Expect(body).To(g.ContainSubstring("kind: Application\n"))
+		g.Expect(body).To(g.ContainSubstring("path: ./k8s/overlays/staging\n"))
+	})
+
+	ginkgo.DescribeTable("rejects requests", func(method string, path string, body string, expectedStatus int, expectedBody string) {
+		status, actualBody := request(method, path, body)
+		g.Expect(status).To(g.Equal(expectedStatus))
+		g.Expect(actualBody).To(g.ContainSubstring(expectedBody))
+	},
+		ginkgo.Entry("with other methods", http.MethodGet, "/generate", "", http.StatusMethodNotAllowed, "Method Not Allowed"),
+		ginkgo.Entry("with unknown outputs", http.MethodPost, "/generate?output=secrets", project, http.StatusUnprocessableEntity, "Unprocessable Entity"),
+		ginkgo.Entry("with invalid project files", http.MethodPost, "/generate?validation=strict", "kind: ArgoCDProject\n", http.StatusUnprocessableEntity, "Unprocessable Entity"),
+		ginkgo.Entry("with cluster registries", http.MethodPost, "/generate", project+"  clusterRegistry: /etc/passwd\n", http.StatusUnprocessableEntity, "Unprocessable Entity"),
+		ginkgo.Entry("with policy templates", http.MethodPost, "/generate", project+"  accessControl:\n    policyTemplates: /etc/passwd\n", http.StatusUnprocessableEntity, "Unprocessable Entity"),
+	)
+
+	ginkgo.It("answers errors without quoting them", func() {
+		status, body := request(http.MethodPost, "/generate", project+"  clusterRegistry: /etc/passwd\n")
+		g.Expect(status).To(g.Equal(http.StatusUnprocessableEntity))
+		g.Expect(body).To(g.Equal("Unprocessable Entity\n"))
+	})
+
+	ginkgo.It("reports its health", func() {
+		status, body := request(http.MethodGet, "/healthz", "")
+		g.Expect(status).To(g.Equal(http.StatusOK))
+		g.Expect(body).To(g.Equal("ok\n"))
+	})
+
+	ginkgo.It("reports the requests to generate manifests", func() {
+		request(http.MethodPost, "/generate", project)
+		request(http.MethodPost, "/generate", project)
+		request(http.MethodPost, "/generate?output=secrets", project)
+
+		status, body := request(http.MethodGet, "/metrics", "")
+		g.Expect(status).To(g.Equal(http.StatusOK))
+		g.Expect(body).To(g.ContainSubstring("argocdproject_generate_requests_total{code=\"200\"} 2\n"))
+		g.Expect(body).To(g.ContainSubstring("argocdproject_generate_requests_total{code=\"422\"} 1\n"))
+		g.Expect(body).To(g.ContainSubstring("argocdproject_generate_duration_seconds_count 3\n"))
+	})
+})
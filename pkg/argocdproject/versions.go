@@ -0,0 +1,205 @@
+package argocdproject
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	v1alpha1APIVersion = "incognia.com/v1alpha1"
+	v1beta1APIVersion  = "incognia.com/v1beta1"
+
+	// projectAPIVersion is the latest apiVersion, which new project files are
+	// written in and older ones are converted to.
+	projectAPIVersion = v1beta1APIVersion
+
+	apiVersionSeparator = " or "
+)
+
+var (
+	// apiVersions are the apiVersions of project files decoded by the plugin,
+	// from the oldest to the latest.
+	apiVersions = []string{
+		v1alpha1APIVersion,
+		v1beta1APIVersion,
+	}
+)
+
+// ArgoCDProjectV1beta1 is a project file of the incognia.com/v1beta1
+// apiVersion, which lists the environments of the project and names the
+// groups of each access level as such. It is decoded into ArgoCDProject, the
+// hub the plugin generates manifests from.
+type ArgoCDProjectV1beta1 struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              ProjectSpecV1beta1 `json:"spec,omitempty"`
+}
+
+type ProjectSpecV1beta1 struct {
+	AccessControl                   AccessControlV1beta1                  `json:"accessControl,omitempty"`
+	Environments                    []string                              `json:"environments,omitempty"`
+	Info                            []argov1alpha1.Info                   `json:"info,omitempty"`
+	DenyDestinations                []argov1alpha1.ApplicationDestination `json:"denyDestinations,omitempty"`
+	ClusterRegistry                 string                                `json:"clusterRegistry,omitempty"`
+	Banner                          *Banner                               `json:"banner,omitempty"`
+	ChangeFreeze                    bool                                  `json:"changeFreeze,omitempty"`
+	Namespace                       string                                `json:"namespace,omitempty"`
+	ApplicationNamespace            string                                `json:"applicationNamespace,omitempty"`
+	PermitOnlyProjectScopedClusters bool                                  `json:"permitOnlyProjectScopedClusters,omitempty"`
+	DestinationServiceAccounts      []DestinationServiceAccount           `json:"destinationServiceAccounts,omitempty"`
+	AppProject                      argov1alpha1.AppProject               `json:"appProjectTemplate,omitempty"`
+	ApplicationTemplates            []argov1alpha1.Application            `json:"applicationTemplates,omitempty"`
+}
+
+type AccessControlV1beta1 struct {
+	Disabled                 bool                `json:"disabled,omitempty"`
+	PolicyTemplates          string              `json:"policyTemplates,omitempty"`
+	ReadOnlyGroups           []string            `json:"readOnlyGroups,omitempty"`
+	ReadSyncGroups           []string            `json:"readSyncGroups,omitempty"`
+	OverrideParametersGroups []string            `json:"overrideParametersGroups,omitempty"`
+	Groups                   map[string][]string `json:"groups,omitempty"`
+	BreakGlass               *BreakGlass         `json:"breakGlass,omitempty"`
+	ClusterCapabilities      []string            `json:"clusterCapabilities,omitempty"`
+}
+
+// decodeProject decodes a project file of any apiVersion into the hub, where
+// files without apiVersion are decoded as v1alpha1, as they have always been.
+func decodeProject(data []byte, strict bool) (*ArgoCDProject, error) {
+	unmarshal := yaml.Unmarshal
+	if strict {
+		unmarshal = yaml.UnmarshalStrict
+	}
+
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return nil, err
+	}
+
+	if typeMeta.APIVersion == v1beta1APIVersion {
+		var argocdProject ArgoCDProjectV1beta1
+		if err := unmarshal(data, &argocdProject); err != nil {
+			return nil, err
+		}
+
+		return convertFromV1beta1(&argocdProject), nil
+	}
+
+	var argocdProject ArgoCDProject
+	if err := unmarshal(data, &argocdProject); err != nil {
+		return nil, err
+	}
+
+	return &argocdProject, nil
+}
+
+// encodeProject returns the hub as a project file of an apiVersion.
+func encodeProject(argocdProject *ArgoCDProject, apiVersion string) interface{} {
+	if apiVersion == v1beta1APIVersion {
+		return convertToV1beta1(argocdProject)
+	}
+
+	return argocdProject
+}
+
+func convertFromV1beta1(in *ArgoCDProjectV1beta1) *ArgoCDProject {
+	out := &ArgoCDProject{
+		TypeMeta:   in.TypeMeta,
+		ObjectMeta: in.ObjectMeta,
+		Spec: ProjectSpec{
+			AccessControl: AppProjectAccessControl{
+				Disabled:            in.Spec.AccessControl.Disabled,
+				PolicyTemplates:     in.Spec.AccessControl.PolicyTemplates,
+				ReadOnly:            in.Spec.AccessControl.ReadOnlyGroups,
+				ReadSync:            in.Spec.AccessControl.ReadSyncGroups,
+				OverrideParameters:  in.Spec.AccessControl.OverrideParametersGroups,
+				Groups:              in.Spec.AccessControl.Groups,
+				BreakGlass:          in.Spec.AccessControl.BreakGlass,
+				ClusterCapabilities: in.Spec.AccessControl.ClusterCapabilities,
+			},
+			Environments:                    in.Spec.Environments,
+			Info:                            in.Spec.Info,
+			DenyDestinations:                in.Spec.DenyDestinations,
+			ClusterRegistry:                 in.Spec.ClusterRegistry,
+			Banner:                          in.Spec.Banner,
+			ChangeFreeze:                    in.Spec.ChangeFreeze,
+			Namespace:                       in.Spec.Namespace,
+			ApplicationNamespace:            in.Spec.ApplicationNamespace,
+			PermitOnlyProjectScopedClusters: in.Spec.PermitOnlyProjectScopedClusters,
+			DestinationServiceAccounts:      in.Spec.DestinationServiceAccounts,
+			AppProject:                      in.Spec.AppProject,
+			ApplicationTemplates:            in.Spec.ApplicationTemplates,
+		},
+	}
+
+	// A single environment is the environment of the project, as in v1alpha1.
+	if len(out.Spec.Environments) == 1 {
+		out.Spec.Environment, out.Spec.Environments = out.Spec.Environments[0], nil
+	}
+
+	return out
+}
+
+func convertToV1beta1(in *ArgoCDProject) *ArgoCDProjectV1beta1 {
+	out := &ArgoCDProjectV1beta1{
+		TypeMeta:   in.TypeMeta,
+		ObjectMeta: in.ObjectMeta,
+		Spec: ProjectSpecV1beta1{
+			AccessControl: AccessControlV1beta1{
+				Disabled:                 in.Spec.AccessControl.Disabled,
+				PolicyTemplates:          in.Spec.AccessControl.PolicyTemplates,
+				ReadOnlyGroups:           in.Spec.AccessControl.ReadOnly,
+				ReadSyncGroups:           in.Spec.AccessControl.ReadSync,
+				OverrideParametersGroups: in.Spec.AccessControl.OverrideParameters,
+				Groups:                   in.Spec.AccessControl.Groups,
+				BreakGlass:               in.Spec.AccessControl.BreakGlass,
+				ClusterCapabilities:      in.Spec.AccessControl.ClusterCapabilities,
+			},
+			Environments:                    in.Spec.Environments,
+			Info:                            in.Spec.Info,
+			DenyDestinations:                in.Spec.DenyDestinations,
+			ClusterRegistry:                 in.Spec.ClusterRegistry,
+			Banner:                          in.Spec.Banner,
+			ChangeFreeze:                    in.Spec.ChangeFreeze,
+			Namespace:                       in.Spec.Namespace,
+			ApplicationNamespace:            in.Spec.ApplicationNamespace,
+			PermitOnlyProjectScopedClusters: in.Spec.PermitOnlyProjectScopedClusters,
+			DestinationServiceAccounts:      in.Spec.DestinationServiceAccounts,
+			AppProject:                      in.Spec.AppProject,
+			ApplicationTemplates:            in.Spec.ApplicationTemplates,
+		},
+	}
+	out.APIVersion = v1beta1APIVersion
+
+	if in.Spec.Environment != "" {
+		out.Spec.Environments = append([]string{in.Spec.Environment}, in.Spec.Environments...)
+	}
+
+	return out
+}
+
+// validateConfigType rejects files of other resources even when decoding
+// leniently, such as a Deployment the plugin is pointed at by mistake, or of
+// apiVersions the plugin does not know, whose fields would otherwise be
+// ignored, while files without apiVersion nor kind are accepted, as Kustomize
+// has always done.
+func validateConfigType(argocdProject *ArgoCDProject) error {
+	kind := reflect.TypeOf(*argocdProject).Name()
+	if argocdProject.Kind != "" && argocdProject.Kind != kind || argocdProject.APIVersion != "" && !containsString(apiVersions, argocdProject.APIVersion) {
+		return fmt.Errorf("file is %s %s instead of an %s of %s, the only files the plugin generates manifests from", argocdProject.APIVersion, argocdProject.Kind, kind, strings.Join(apiVersions, apiVersionSeparator))
+	}
+
+	return nil
+}
+
+func validateAPIVersion(apiVersion string) error {
+	if !containsString(apiVersions, apiVersion) {
+		return fmt.Errorf("apiVersion is %q instead of %s", apiVersion, strings.Join(apiVersions, apiVersionSeparator))
+	}
+
+	return nil
+}
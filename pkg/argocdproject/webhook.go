@@ -0,0 +1,143 @@
+package argocdproject
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	webhookAddress = ":8443"
+	validatePath   = "/validate"
+
+	admissionContentType = "application/json"
+)
+
+// Webhook serves the validating admission webhook of ArgoCDProject resources
+// over HTTPS until it is terminated.
+func Webhook(args []string, out io.Writer) error {
+	var options Options
+	flags := flag.NewFlagSet("webhook", flag.ContinueOnError)
+	address := flags.String("address", webhookAddress, "address the server listens on")
+	certFile := flags.String("tls-cert-file", "", "path of the certificate of the server")
+	keyFile := flags.String("tls-private-key-file", "", "path of the private key of the certificate")
+	flags.StringVar(&options.Dir, "dir", "", "directory the relative paths of ArgoCDProject resources are read from")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *certFile == "" || *keyFile == "" {
+		return errors.New("-tls-cert-file and -tls-private-key-file are required")
+	}
+
+	server := &http.Server{
+		Addr:    *address,
+		Handler: NewWebhookHandler(options),
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		<-signals
+		ctx, cancel := context.WithTimeout(context.Background(), serveShutdownTimeout)
+		defer cancel()
+		server.Shutdown(ctx)
+	}()
+
+	if _, err := fmt.Fprintf(out, "serving on %s\n", *address); err != nil {
+		return err
+	}
+
+	if err := server.ListenAndServeTLS(*certFile, *keyFile); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	return nil
+}
+
+// NewWebhookHandler returns the HTTP handler of Webhook, for tools serving it
+// on their own servers. POST /validate reviews the admission of ArgoCDProject
+// resources, denying those the plugin fails to generate in strict validation
+// with the error it writes, and GET /healthz reports its health.
+func NewWebhookHandler(options Options) http.Handler {
+	options.Validation = ValidationStrict
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(validatePath, func(w http.ResponseWriter, r *http.Request) {
+		handleValidate(w, r, options)
+	})
+	mux.HandleFunc(healthPath, handleHealth)
+
+	return mux
+}
+
+func handleValidate(w http.ResponseWriter, r *http.Request, options Options) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	var review admissionv1.AdmissionReview
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxProjectSize)).Decode(&review); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if review.Request == nil {
+		http.Error(w, "request of the admission review is missing", http.StatusBadRequest)
+		return
+	}
+
+	response := &admissionv1.AdmissionResponse{
+		UID:     review.Request.UID,
+		Allowed: true,
+	}
+	if err := validateAdmission(review.Request, options); err != nil {
+		response.Allowed = false
+		response.Result = &metav1.Status{
+			Status:  metav1.StatusFailure,
+			Message: err.Error(),
+			Reason:  metav1.StatusReasonInvalid,
+			Code:    http.StatusUnprocessableEntity,
+		}
+	}
+
+	w.Header().Set("Content-Type", admissionContentType)
+	json.NewEncoder(w).Encode(admissionv1.AdmissionReview{
+		TypeMeta: review.TypeMeta,
+		Response: response,
+	})
+}
+
+// validateAdmission generates the manifests of the object admitted, where its
+// status, written by the controller, is not part of project files.
+func validateAdmission(request *admissionv1.AdmissionRequest, options Options) error {
+	if request.Operation == admissionv1.Delete {
+		return nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal(request.Object.Raw, &object); err != nil {
+		return err
+	}
+	delete(object, yamlStatusField)
+
+	data, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+
+	return GenerateManifestsWithOptions(data, options, ioutil.Discard)
+}
@@ -0,0 +1,121 @@
+package argocdproject_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+)
+
+var _ = ginkgo.Describe("Webhook", func() {
+	const project = `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+  namespace: argocd
+spec:
+  environments:
+    - production
+  applicationTemplates:
+    - metadata:
+        name: payroll
+      spec:
+        source:
+          repoURL: https://github.com/inloco/payroll.git
+        destination:
+          server: https://kubernetes.default.svc
+          namespace: payroll
+status:
+  observedGeneration: 1
+`
+
+	var server *httptest.Server
+	ginkgo.BeforeEach(func() {
+		server = httptest.NewServer(argocdproject.NewWebhookHandler(argocdproject.Options{}))
+		ginkgo.DeferCleanup(server.Close)
+	})
+
+	review := func(operation admissionv1.Operation, manifest string) *admissionv1.AdmissionResponse {
+		object, err := yaml.YAMLToJSON([]byte(manifest))
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		body, err := json.Marshal(admissionv1.AdmissionReview{
+			Request: &admissionv1.AdmissionRequest{
+				UID:       "705ab4f5-6393-11e8-b7cc-42010a800002",
+				Operation: operation,
+				Object:    runtime.RawExtension{Raw: object},
+			},
+		})
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		res, err := http.Post(server.URL+"/validate", "application/json", bytes.NewReader(body))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		defer res.Body.Close()
+		g.Expect(res.StatusCode).To(g.Equal(http.StatusOK))
+
+		var actual admissionv1.AdmissionReview
+		g.Expect(json.NewDecoder(res.Body).Decode(&actual)).To(g.Succeed())
+		g.Expect(actual.Response.UID).To(g.BeEquivalentTo("705ab4f5-6393-11e8-b7cc-42010a800002"))
+
+		return actual.Response
+	}
+
+	ginkgo.It("allows valid projects", func() {
+		response := review(admissionv1.Create, project)
+		g.Expect(response.Allowed).To(g.BeTrue())
+	})
+
+	ginkgo.DescribeTable("denies invalid projects with the errors of the plugin", func(manifest string) {
+		data, err := yaml.YAMLToJSON([]byte(manifest))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		expectedErr := argocdproject.GenerateManifestsWithOptions(data, argocdproject.Options{
+			Validation: argocdproject.ValidationStrict,
+		}, ioutil.Discard)
+		g.Expect(expectedErr).To(g.HaveOccurred())
+
+		response := review(admissionv1.Update, manifest)
+		g.Expect(response.Allowed).To(g.BeFalse())
+		g.Expect(response.Result.Message).To(g.Equal(expectedErr.Error()))
+	},
+		ginkgo.Entry("with unknown fields", `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environment: production
+`),
+		ginkgo.Entry("with repeated environments", `
+apiVersion: incognia.com/v1beta1
+kind: ArgoCDProject
+metadata:
+  name: employees
+spec:
+  environments:
+    - production
+    - production
+`),
+	)
+
+	ginkgo.It("allows deleting projects", func() {
+		response := review(admissionv1.Delete, "{}")
+		g.Expect(response.Allowed).To(g.BeTrue())
+	})
+
+	ginkgo.It("rejects reviews without requests", func() {
+		res, err := http.Post(server.URL+"/validate", "application/json", bytes.NewReader([]byte("{}")))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		res.Body.Close()
+		g.Expect(res.StatusCode).To(g.Equal(http.StatusBadRequest))
+	})
+})
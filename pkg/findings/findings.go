@@ -0,0 +1,297 @@
+// Package findings reports the problems found by lint and by the validator
+// plugins as JSON or as SARIF, so code scanning and dashboards ingest them.
+package findings
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	FormatJSON  = "json"
+	FormatSARIF = "sarif"
+
+	// DirEnv is the directory the validator plugins, which Kustomize runs
+	// without flags, write their reports to, one per plugin configuration.
+	DirEnv = "FINDINGS_DIR"
+	// FormatEnv is the format of the reports of the validator plugins, SARIF
+	// unless it is json.
+	FormatEnv = "FINDINGS_FORMAT"
+
+	sarifVersion        = "2.1.0"
+	sarifSchema         = "https://json.schemastore.org/sarif-2.1.0.json"
+	sarifLevel          = "error"
+	sarifResourceKind   = "resource"
+	toolInformationURI  = "https://github.com/inloco/iac-kustomize-plugins"
+	fileScheme          = "file"
+	messageSeparator    = ": "
+	reportNameSeparator = "-"
+
+	// originAnnotation is where Kustomize records the file a resource was read
+	// from, when the build sets buildMetadata: [originAnnotations].
+	originAnnotation = "config.kubernetes.io/origin"
+)
+
+var (
+	kustomizationFileNames = []string{"kustomization.yaml", "kustomization.yml", "Kustomization"}
+)
+
+// Finding is a problem of a file or of a resource.
+type Finding struct {
+	Path     string `json:"path,omitempty"`
+	Resource string `json:"resource,omitempty"`
+	Rule     string `json:"rule"`
+	Message  string `json:"message"`
+}
+
+// Lines returns the findings of resources as the lines of the errors of the
+// validator plugins.
+func Lines(findings []Finding) string {
+	lines := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		lines = append(lines, finding.Resource+messageSeparator+finding.Message)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Write writes findings as a JSON array or as a SARIF log of tool.
+func Write(out io.Writer, format string, tool string, findings []Finding) error {
+	var v interface{}
+	switch format {
+	case FormatJSON:
+		if findings == nil {
+			findings = make([]Finding, 0)
+		}
+		v = findings
+	case FormatSARIF:
+		v = makeSARIFLog(tool, findings)
+	default:
+		return fmt.Errorf("unknown format %s", format)
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// SourcePath returns the file a resource was read from, relative to the
+// kustomization, from the annotations Kustomize records it in, or an empty
+// path when they are missing or name a remote file.
+func SourcePath(annotations map[string]string) string {
+	var origin struct {
+		Path string `json:"path"`
+		Repo string `json:"repo"`
+	}
+	if err := yaml.Unmarshal([]byte(annotations[originAnnotation]), &origin); err != nil || origin.Repo != "" {
+		return ""
+	}
+
+	return origin.Path
+}
+
+// WriteReportFromEnv writes the findings of a plugin configuration to the
+// directory of DirEnv, if set, even when there are none, so fixed problems
+// are cleared from the tools ingesting the reports. Paths are made absolute,
+// as Kustomize runs plugins from the kustomization, and findings without one
+// are located at the kustomization itself, so every finding has a file.
+func WriteReportFromEnv(tool string, name string, findings []Finding) error {
+	dir, exists := os.LookupEnv(DirEnv)
+	if !exists || dir == "" {
+		return nil
+	}
+
+	located, err := locate(findings)
+	if err != nil {
+		return err
+	}
+
+	format := os.Getenv(FormatEnv)
+	if format == "" {
+		format = FormatSARIF
+	}
+
+	reportName := tool
+	if name != "" {
+		reportName += reportNameSeparator + name
+	}
+
+	file, err := os.Create(filepath.Join(dir, fmt.Sprintf("%s.%s", reportName, format)))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := Write(file, format, tool, located); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func locate(findings []Finding) ([]Finding, error) {
+	root, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	kustomizationPath := root
+	for _, fileName := range kustomizationFileNames {
+		path := filepath.Join(root, fileName)
+		if _, err := os.Stat(path); err == nil {
+			kustomizationPath = path
+			break
+		}
+	}
+
+	located := make([]Finding, 0, len(findings))
+	for _, finding := range findings {
+		switch {
+		case finding.Path == "":
+			finding.Path = kustomizationPath
+		case !filepath.IsAbs(finding.Path):
+			finding.Path = filepath.Join(root, finding.Path)
+		}
+		located = append(located, finding)
+	}
+
+	return located, nil
+}
+
+// artifactURI returns the URI of a path, where relative paths are relative to
+// the root of the repository.
+func artifactURI(path string) string {
+	if !filepath.IsAbs(path) {
+		return filepath.ToSlash(path)
+	}
+
+	return (&url.URL{
+		Scheme: fileScheme,
+		Path:   filepath.ToSlash(path),
+	}).String()
+}
+
+// The types below are the subset of SARIF 2.1.0 written for findings.
+type sarifLog struct {
+	Version string     `json:"version"`
+	Schema  string     `json:"$schema"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	InformationURI string      `json:"informationUri"`
+	Rules          []sarifRule `json:"rules,omitempty"`
+}
+
+type sarifRule struct {
+	ID string `json:"id"`
+}
+
+type sarifResult struct {
+	RuleID    string          `json:"ruleId"`
+	Level     string          `json:"level"`
+	Message   sarifMessage    `json:"message"`
+	Locations []sarifLocation `json:"locations,omitempty"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation *sarifPhysicalLocation `json:"physicalLocation,omitempty"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+func makeSARIFLog(tool string, findings []Finding) sarifLog {
+	ruleIDs := make(map[string]bool)
+	results := make([]sarifResult, 0, len(findings))
+	for _, finding := range findings {
+		ruleIDs[finding.Rule] = true
+
+		var location sarifLocation
+		if finding.Path != "" {
+			location.PhysicalLocation = &sarifPhysicalLocation{
+				ArtifactLocation: sarifArtifactLocation{
+					URI: artifactURI(finding.Path),
+				},
+			}
+		}
+		if finding.Resource != "" {
+			location.LogicalLocations = []sarifLogicalLocation{
+				{
+					Name: finding.Resource,
+					Kind: sarifResourceKind,
+				},
+			}
+		}
+
+		result := sarifResult{
+			RuleID: finding.Rule,
+			Level:  sarifLevel,
+			Message: sarifMessage{
+				Text: finding.Message,
+			},
+		}
+		if location.PhysicalLocation != nil || location.LogicalLocations != nil {
+			result.Locations = []sarifLocation{location}
+		}
+		results = append(results, result)
+	}
+
+	rules := make([]sarifRule, 0, len(ruleIDs))
+	for id := range ruleIDs {
+		rules = append(rules, sarifRule{ID: id})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+
+	return sarifLog{
+		Version: sarifVersion,
+		Schema:  sarifSchema,
+		Runs: []sarifRun{
+			{
+				Tool: sarifTool{
+					Driver: sarifDriver{
+						Name:           tool,
+						InformationURI: toolInformationURI,
+						Rules:          rules,
+					},
+				},
+				Results: results,
+			},
+		},
+	}
+}
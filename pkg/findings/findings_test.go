@@ -0,0 +1,148 @@
+package findings_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+)
+
+var _ = ginkgo.Describe("Findings", func() {
+	found := []findings.Finding{
+		{
+			Path:    "employees.argoCDProject.yaml",
+			Rule:    "schema",
+			Message: "metadata.name is empty",
+		},
+		{
+			Resource: "Pod debug",
+			Rule:     "registry",
+			Message:  "busybox",
+		},
+	}
+
+	ginkgo.It("writes SARIF logs", func() {
+		var out bytes.Buffer
+		g.Expect(findings.Write(&out, findings.FormatSARIF, "lint", found)).To(g.Succeed())
+		g.Expect(out.String()).To(g.MatchJSON(`{
+  "version": "2.1.0",
+  "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+  "runs": [
+    {
+      "tool": {
+        "driver": {
+          "name": "lint",
+          "informationUri": "https://github.com/inloco/iac-kustomize-plugins",
+          "rules": [{"id": "registry"}, {"id": "schema"}]
+        }
+      },
+      "results": [
+        {
+          "ruleId": "schema",
+          "level": "error",
+          "message": {"text": "metadata.name is empty"},
+          "locations": [{"physicalLocation": {"artifactLocation": {"uri": "employees.argoCDProject.yaml"}}}]
+        },
+        {
+          "ruleId": "registry",
+          "level": "error",
+          "message": {"text": "busybox"},
+          "locations": [{"logicalLocations": [{"name": "Pod debug", "kind": "resource"}]}]
+        }
+      ]
+    }
+  ]
+}`))
+	})
+
+	ginkgo.It("writes JSON arrays", func() {
+		var out bytes.Buffer
+		g.Expect(findings.Write(&out, findings.FormatJSON, "lint", nil)).To(g.Succeed())
+		g.Expect(out.String()).To(g.MatchJSON(`[]`))
+	})
+
+	ginkgo.It("fails on unknown formats", func() {
+		g.Expect(findings.Write(&bytes.Buffer{}, "xml", "lint", found)).To(g.MatchError("unknown format xml"))
+	})
+
+	ginkgo.It("writes reports of located findings to the directory of the environment", func() {
+		dir, err := ioutil.TempDir("", "findings")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		kustomizationDir, err := ioutil.TempDir("", "kustomization")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, kustomizationDir)
+		kustomizationDir, err = filepath.EvalSymlinks(kustomizationDir)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(ioutil.WriteFile(filepath.Join(kustomizationDir, "kustomization.yaml"), nil, 0644)).To(g.Succeed())
+
+		wd, err := os.Getwd()
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(os.Chdir(kustomizationDir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Chdir, wd)
+
+		g.Expect(os.Setenv("FINDINGS_DIR", dir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_DIR")
+		g.Expect(os.Setenv("FINDINGS_FORMAT", "json")).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_FORMAT")
+
+		g.Expect(findings.WriteReportFromEnv("registryallowlist", "images", found)).To(g.Succeed())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "registryallowlist-images.json"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		var actual []findings.Finding
+		g.Expect(json.Unmarshal(data, &actual)).To(g.Succeed())
+		g.Expect(actual).To(g.Equal([]findings.Finding{
+			{
+				Path:    filepath.Join(kustomizationDir, "employees.argoCDProject.yaml"),
+				Rule:    "schema",
+				Message: "metadata.name is empty",
+			},
+			{
+				Path:     filepath.Join(kustomizationDir, "kustomization.yaml"),
+				Resource: "Pod debug",
+				Rule:     "registry",
+				Message:  "busybox",
+			},
+		}))
+	})
+
+	ginkgo.It("writes absolute paths as file URIs", func() {
+		var out bytes.Buffer
+		g.Expect(findings.Write(&out, findings.FormatSARIF, "registryallowlist", []findings.Finding{
+			{
+				Path:     "/repo/k8s/kustomization.yaml",
+				Resource: "Pod debug",
+				Rule:     "registry",
+				Message:  "busybox",
+			},
+		})).To(g.Succeed())
+		g.Expect(out.String()).To(g.ContainSubstring(`"uri": "file:///repo/k8s/kustomization.yaml"`))
+	})
+
+	ginkgo.DescribeTable("reads the source paths of resources", func(origin string, expected string) {
+		g.Expect(findings.SourcePath(map[string]string{
+			"config.kubernetes.io/origin": origin,
+		})).To(g.Equal(expected))
+	},
+		ginkgo.Entry("of local files", "path: pods/debug.yaml\n", "pods/debug.yaml"),
+		ginkgo.Entry("without origins", "", ""),
+		ginkgo.Entry("of remote files", "path: pods/debug.yaml\nrepo: https://github.com/inloco/pods\nref: main\n", ""),
+	)
+
+	ginkgo.It("writes no reports without the environment", func() {
+		g.Expect(findings.WriteReportFromEnv("registryallowlist", "images", found)).To(g.Succeed())
+	})
+
+	ginkgo.It("joins the findings of resources as lines", func() {
+		g.Expect(findings.Lines(found[1:])).To(g.Equal("Pod debug: busybox"))
+	})
+})
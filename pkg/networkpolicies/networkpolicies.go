@@ -0,0 +1,246 @@
+// Package networkpolicies generates the baseline NetworkPolicies of a
+// namespace, for the Kustomize plugin and the Tenant plugin composing it.
+package networkpolicies
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	yamlSeparator = "---\n"
+
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+	kubeSystem         = "kube-system"
+	dnsPort            = 53
+
+	defaultDenyName        = "default-deny"
+	allowSameNamespaceName = "allow-same-namespace"
+	allowDNSName           = "allow-dns"
+	allowNamespacesName    = "allow-namespaces"
+	allowEgressCIDRsName   = "allow-egress-cidrs"
+)
+
+type NetworkPolicies struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	Ports             []int32  `json:"ports,omitempty"`
+	EgressCIDRs       []string `json:"egressCIDRs,omitempty"`
+}
+
+type namedPolicySpec struct {
+	name string
+	spec networkingv1.NetworkPolicySpec
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var networkPolicies NetworkPolicies
+	if err := pluginconfig.Unmarshal(data, &networkPolicies); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&networkPolicies)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(networkPolicies *NetworkPolicies) ([][]byte, error) {
+	if networkPolicies.Namespace == "" {
+		return nil, fmt.Errorf("metadata.namespace is empty")
+	}
+
+	for _, cidr := range networkPolicies.Spec.EgressCIDRs {
+		if _, _, err := net.ParseCIDR(cidr); err != nil {
+			return nil, err
+		}
+	}
+
+	policies := []namedPolicySpec{
+		namedPolicySpec{defaultDenyName, makeDefaultDeny()},
+		namedPolicySpec{allowSameNamespaceName, makeAllowSameNamespace()},
+		namedPolicySpec{allowDNSName, makeAllowDNS()},
+	}
+
+	if len(networkPolicies.Spec.AllowedNamespaces) > 0 {
+		policies = append(policies, namedPolicySpec{allowNamespacesName, makeAllowNamespaces(&networkPolicies.Spec)})
+	}
+
+	if len(networkPolicies.Spec.EgressCIDRs) > 0 {
+		policies = append(policies, namedPolicySpec{allowEgressCIDRsName, makeAllowEgressCIDRs(&networkPolicies.Spec)})
+	}
+
+	manifests := make([][]byte, 0, len(policies))
+	for _, policy := range policies {
+		b, err := makeNetworkPolicy(networkPolicies, policy.name, policy.spec)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+func makeNetworkPolicy(networkPolicies *NetworkPolicies, name string, spec networkingv1.NetworkPolicySpec) ([]byte, error) {
+	objectMeta := *networkPolicies.ObjectMeta.DeepCopy()
+	objectMeta.Name = name
+
+	networkPolicy := networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(networkingv1.NetworkPolicy{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Spec:       spec,
+	}
+
+	return yaml.Marshal(networkPolicy)
+}
+
+func makeDefaultDeny() networkingv1.NetworkPolicySpec {
+	return networkingv1.NetworkPolicySpec{
+		PolicyTypes: []networkingv1.PolicyType{
+			networkingv1.PolicyTypeIngress,
+			networkingv1.PolicyTypeEgress,
+		},
+	}
+}
+
+func makeAllowSameNamespace() networkingv1.NetworkPolicySpec {
+	peers := []networkingv1.NetworkPolicyPeer{
+		networkingv1.NetworkPolicyPeer{
+			PodSelector: &metav1.LabelSelector{},
+		},
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			networkingv1.NetworkPolicyIngressRule{
+				From: peers,
+			},
+		},
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			networkingv1.NetworkPolicyEgressRule{
+				To: peers,
+			},
+		},
+		PolicyTypes: []networkingv1.PolicyType{
+			networkingv1.PolicyTypeIngress,
+			networkingv1.PolicyTypeEgress,
+		},
+	}
+}
+
+func makeAllowDNS() networkingv1.NetworkPolicySpec {
+	return networkingv1.NetworkPolicySpec{
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			networkingv1.NetworkPolicyEgressRule{
+				To: []networkingv1.NetworkPolicyPeer{
+					networkingv1.NetworkPolicyPeer{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{
+								namespaceNameLabel: kubeSystem,
+							},
+						},
+					},
+				},
+				Ports: []networkingv1.NetworkPolicyPort{
+					makePort(corev1.ProtocolUDP, dnsPort),
+					makePort(corev1.ProtocolTCP, dnsPort),
+				},
+			},
+		},
+		PolicyTypes: []networkingv1.PolicyType{
+			networkingv1.PolicyTypeEgress,
+		},
+	}
+}
+
+func makeAllowNamespaces(spec *Spec) networkingv1.NetworkPolicySpec {
+	var ports []networkingv1.NetworkPolicyPort
+	for _, port := range spec.Ports {
+		ports = append(ports, makePort(corev1.ProtocolTCP, port))
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		Ingress: []networkingv1.NetworkPolicyIngressRule{
+			networkingv1.NetworkPolicyIngressRule{
+				From: []networkingv1.NetworkPolicyPeer{
+					networkingv1.NetworkPolicyPeer{
+						NamespaceSelector: &metav1.LabelSelector{
+							MatchExpressions: []metav1.LabelSelectorRequirement{
+								metav1.LabelSelectorRequirement{
+									Key:      namespaceNameLabel,
+									Operator: metav1.LabelSelectorOpIn,
+									Values:   spec.AllowedNamespaces,
+								},
+							},
+						},
+					},
+				},
+				Ports: ports,
+			},
+		},
+		PolicyTypes: []networkingv1.PolicyType{
+			networkingv1.PolicyTypeIngress,
+		},
+	}
+}
+
+func makeAllowEgressCIDRs(spec *Spec) networkingv1.NetworkPolicySpec {
+	var peers []networkingv1.NetworkPolicyPeer
+	for _, cidr := range spec.EgressCIDRs {
+		peers = append(peers, networkingv1.NetworkPolicyPeer{
+			IPBlock: &networkingv1.IPBlock{
+				CIDR: cidr,
+			},
+		})
+	}
+
+	return networkingv1.NetworkPolicySpec{
+		Egress: []networkingv1.NetworkPolicyEgressRule{
+			networkingv1.NetworkPolicyEgressRule{
+				To: peers,
+			},
+		},
+		PolicyTypes: []networkingv1.PolicyType{
+			networkingv1.PolicyTypeEgress,
+		},
+	}
+}
+
+func makePort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	p := intstr.FromInt(int(port))
+	return networkingv1.NetworkPolicyPort{
+		Protocol: &protocol,
+		Port:     &p,
+	}
+}
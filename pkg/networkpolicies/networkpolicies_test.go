@@ -0,0 +1,107 @@
+package networkpolicies_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	networkingv1 "k8s.io/api/networking/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/networkpolicies"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("NetworkPolicies", func() {
+	ginkgo.DescribeTable("", func(spec string, expectedNames []string) {
+		data := []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NetworkPolicies
+metadata:
+  name: baseline
+  namespace: my-app
+spec: ` + spec + `
+`)
+
+		var config networkpolicies.NetworkPolicies
+		g.Expect(yaml.Unmarshal(data, &config)).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(networkpolicies.GenerateManifests(data, &out)).To(g.Succeed())
+
+		policies := make(map[string]networkingv1.NetworkPolicy)
+		var names []string
+		for _, resource := range separatorYaml.Split(out.String(), -1) {
+			var networkPolicy networkingv1.NetworkPolicy
+			g.Expect(yaml.Unmarshal([]byte(resource), &networkPolicy)).To(g.Succeed())
+			g.Expect(networkPolicy.Kind).To(g.Equal("NetworkPolicy"))
+			g.Expect(networkPolicy.Namespace).To(g.Equal("my-app"))
+
+			policies[networkPolicy.Name] = networkPolicy
+			names = append(names, networkPolicy.Name)
+		}
+		g.Expect(names).To(g.Equal(expectedNames))
+
+		ginkgo.By("denying all traffic by default", func() {
+			defaultDeny := policies["default-deny"]
+			g.Expect(defaultDeny.Spec.Ingress).To(g.BeEmpty())
+			g.Expect(defaultDeny.Spec.Egress).To(g.BeEmpty())
+			g.Expect(defaultDeny.Spec.PolicyTypes).To(g.ConsistOf(networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress))
+		})
+
+		if allowNamespaces, exists := policies["allow-namespaces"]; exists {
+			ginkgo.By("allowing ingress from the given namespaces", func() {
+				g.Expect(allowNamespaces.Spec.Ingress).To(g.HaveLen(1))
+				g.Expect(allowNamespaces.Spec.Ingress[0].From[0].NamespaceSelector.MatchExpressions[0].Values).To(g.Equal(config.Spec.AllowedNamespaces))
+				g.Expect(allowNamespaces.Spec.Ingress[0].Ports).To(g.HaveLen(len(config.Spec.Ports)))
+			})
+		}
+
+		if allowEgressCIDRs, exists := policies["allow-egress-cidrs"]; exists {
+			ginkgo.By("allowing egress to the given CIDRs", func() {
+				g.Expect(allowEgressCIDRs.Spec.Egress).To(g.HaveLen(1))
+				g.Expect(allowEgressCIDRs.Spec.Egress[0].To).To(g.HaveLen(len(config.Spec.EgressCIDRs)))
+			})
+		}
+	},
+		ginkgo.Entry("baseline only", "{}", []string{
+			"default-deny",
+			"allow-same-namespace",
+			"allow-dns",
+		}),
+		ginkgo.Entry("with allowed namespaces and egress CIDRs", `
+  allowedNamespaces:
+    - ingress-nginx
+    - monitoring
+  ports:
+    - 8080
+    - 9090
+  egressCIDRs:
+    - 10.0.0.0/8
+`, []string{
+			"default-deny",
+			"allow-same-namespace",
+			"allow-dns",
+			"allow-namespaces",
+			"allow-egress-cidrs",
+		}),
+	)
+
+	ginkgo.It("fails with an invalid CIDR", func() {
+		var out bytes.Buffer
+		g.Expect(networkpolicies.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: NetworkPolicies
+metadata:
+  name: baseline
+  namespace: my-app
+spec:
+  egressCIDRs:
+    - 10.0.0.0
+`), &out)).NotTo(g.Succeed())
+	})
+})
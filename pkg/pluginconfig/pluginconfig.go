@@ -0,0 +1,51 @@
+// Package pluginconfig decodes the configurations Kustomize runs plugins with,
+// rejecting the files of other resources, such as a Deployment a plugin is
+// pointed at by mistake, whose fields would otherwise be silently ignored.
+package pluginconfig
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+)
+
+const (
+	apiVersionSeparator = " or "
+)
+
+var (
+	// APIVersions are the apiVersions of the configurations of the plugins.
+	APIVersions = []string{
+		"incognia.com/v1alpha1",
+	}
+)
+
+// Unmarshal decodes a configuration into config, a pointer to the struct
+// named after the kind of the plugin. Files without apiVersion nor kind are
+// accepted, as Kustomize has always done.
+func Unmarshal(data []byte, config interface{}) error {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal(data, &typeMeta); err != nil {
+		return err
+	}
+
+	kind := reflect.TypeOf(config).Elem().Name()
+	if typeMeta.Kind != "" && typeMeta.Kind != kind || typeMeta.APIVersion != "" && !containsString(APIVersions, typeMeta.APIVersion) {
+		return fmt.Errorf("file is %s %s instead of %s %s, the only files the plugin reads", typeMeta.APIVersion, typeMeta.Kind, strings.Join(APIVersions, apiVersionSeparator), kind)
+	}
+
+	return yaml.Unmarshal(data, config)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
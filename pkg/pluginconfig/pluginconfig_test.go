@@ -0,0 +1,48 @@
+package pluginconfig_test
+
+import (
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+type ImageDigests struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Lockfile string `json:"lockfile,omitempty"`
+	} `json:"spec,omitempty"`
+}
+
+var _ = ginkgo.Describe("PluginConfig", func() {
+	ginkgo.It("decodes configurations of the plugin", func() {
+		var imageDigests ImageDigests
+		g.Expect(pluginconfig.Unmarshal([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ImageDigests
+metadata:
+  name: image-digests
+spec:
+  lockfile: ./images.lock.yaml
+`), &imageDigests)).To(g.Succeed())
+		g.Expect(imageDigests.Name).To(g.Equal("image-digests"))
+		g.Expect(imageDigests.Spec.Lockfile).To(g.Equal("./images.lock.yaml"))
+	})
+
+	ginkgo.DescribeTable("rejects files of other resources", func(config string, expectedError string) {
+		var imageDigests ImageDigests
+		err := pluginconfig.Unmarshal([]byte(config), &imageDigests)
+		if expectedError == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without apiVersion nor kind", "metadata:\n  name: image-digests\n", ""),
+		ginkgo.Entry("of other versions", "apiVersion: incognia.com/v9\nkind: ImageDigests\n", "file is incognia.com/v9 ImageDigests instead of incognia.com/v1alpha1 ImageDigests, the only files the plugin reads"),
+		ginkgo.Entry("of other kinds", "apiVersion: apps/v1\nkind: Deployment\n", "file is apps/v1 Deployment instead of incognia.com/v1alpha1 ImageDigests, the only files the plugin reads"),
+		ginkgo.Entry("of other plugins", "apiVersion: incognia.com/v1alpha1\nkind: SealedSecret\n", "file is incognia.com/v1alpha1 SealedSecret instead of incognia.com/v1alpha1 ImageDigests, the only files the plugin reads"),
+	)
+})
@@ -0,0 +1,293 @@
+// Package teamrbac generates the in-cluster RBAC of the access levels of a
+// team, for the Kustomize plugin and the Tenant plugin composing it.
+package teamrbac
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	yamlSeparator = "---\n"
+
+	namespacedReadOnlyRoleName   = "namespaced-ro"
+	unnamespacedReadOnlyRoleName = "unnamespaced-ro"
+
+	verbGet   = "get"
+	verbPatch = "patch"
+	verbList  = "list"
+	verbWatch = "watch"
+)
+
+type accessLevel int
+
+const (
+	ReadOnly accessLevel = iota
+	ReadSync
+)
+
+func (a accessLevel) String() string {
+	switch a {
+	case ReadOnly:
+		return "read-only"
+	case ReadSync:
+		return "read-sync"
+	default:
+		panic(fmt.Sprintf("unknown access level %d", a))
+	}
+}
+
+// Rules are what the access level grants in-cluster besides the namespaced-ro
+// ClusterRole. Argo CD runs the actions of read-sync, such as restarting a
+// deployment, with its own credentials, so read-sync groups are only allowed
+// to patch deployments and rollouts, which changes any of their fields, when
+// patchWorkloads opts in.
+func (a accessLevel) Rules(patchWorkloads bool) []rbacv1.PolicyRule {
+	switch a {
+	case ReadOnly:
+		return nil
+	case ReadSync:
+		verbs := []string{
+			verbGet,
+			verbList,
+			verbWatch,
+		}
+		if patchWorkloads {
+			verbs = append(verbs, verbPatch)
+		}
+
+		return []rbacv1.PolicyRule{
+			rbacv1.PolicyRule{
+				APIGroups: []string{
+					"apps",
+				},
+				Resources: []string{
+					"deployments",
+				},
+				Verbs: verbs,
+			},
+			rbacv1.PolicyRule{
+				APIGroups: []string{
+					"argoproj.io",
+				},
+				Resources: []string{
+					"rollouts",
+					"rollouts/status",
+				},
+				Verbs: verbs,
+			},
+		}
+	default:
+		panic(fmt.Sprintf("unknown access level %d", a))
+	}
+}
+
+type TeamRBAC struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Namespaces    []string          `json:"namespaces,omitempty"`
+	AccessControl TeamAccessControl `json:"accessControl,omitempty"`
+	ClusterWide   bool              `json:"clusterWide,omitempty"`
+
+	// PatchWorkloads allows read-sync groups to patch deployments and
+	// rollouts, which Argo CD does not.
+	PatchWorkloads bool `json:"patchWorkloads,omitempty"`
+}
+
+type TeamAccessControl struct {
+	ReadOnly []string `json:"ReadOnly,omitempty"`
+	ReadSync []string `json:"ReadSync,omitempty"`
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var teamRBAC TeamRBAC
+	if err := pluginconfig.Unmarshal(data, &teamRBAC); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&teamRBAC)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(teamRBAC *TeamRBAC) ([][]byte, error) {
+	if teamRBAC.Name == "" {
+		return nil, fmt.Errorf("metadata.name is empty")
+	}
+
+	namespaces := teamRBAC.Spec.Namespaces
+	if len(namespaces) == 0 && teamRBAC.Namespace != "" {
+		namespaces = []string{
+			teamRBAC.Namespace,
+		}
+	}
+	if len(namespaces) == 0 && !teamRBAC.Spec.ClusterWide {
+		return nil, fmt.Errorf("spec.namespaces is empty")
+	}
+
+	var manifests [][]byte
+
+	// bindings without subjects grant nothing, so they are not generated
+	// along with the roles only they would bind
+	readOnly := len(makeGroups(ReadOnly, teamRBAC)) > 0
+	readSync := len(makeGroups(ReadSync, teamRBAC)) > 0
+
+	for _, namespace := range namespaces {
+		if readOnly {
+			readOnlyRoleBinding, err := makeRoleBinding(ReadOnly, teamRBAC, namespace)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, readOnlyRoleBinding)
+		}
+
+		if readSync {
+			readSyncRole, err := makeRole(ReadSync, teamRBAC, namespace)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, readSyncRole)
+
+			readSyncRoleBinding, err := makeRoleBinding(ReadSync, teamRBAC, namespace)
+			if err != nil {
+				return nil, err
+			}
+			manifests = append(manifests, readSyncRoleBinding)
+		}
+	}
+
+	if teamRBAC.Spec.ClusterWide && readOnly {
+		clusterRoleBinding, err := makeClusterRoleBinding(teamRBAC)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, clusterRoleBinding)
+	}
+
+	return manifests, nil
+}
+
+func makeName(accessLevel accessLevel, teamRBAC *TeamRBAC) string {
+	return fmt.Sprintf("%s:%s", teamRBAC.Name, accessLevel)
+}
+
+func makeGroups(accessLevel accessLevel, teamRBAC *TeamRBAC) []string {
+	switch accessLevel {
+	case ReadOnly:
+		// read-sync groups are also granted read-only, like the argocdproject
+		// read-sync role inherits from read-only
+		groups := append([]string{}, teamRBAC.Spec.AccessControl.ReadOnly...)
+		return append(groups, teamRBAC.Spec.AccessControl.ReadSync...)
+	case ReadSync:
+		return teamRBAC.Spec.AccessControl.ReadSync
+	default:
+		panic(fmt.Sprintf("unknown access level %d", accessLevel))
+	}
+}
+
+func makeRole(accessLevel accessLevel, teamRBAC *TeamRBAC, namespace string) ([]byte, error) {
+	role := rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.Role{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      makeName(accessLevel, teamRBAC),
+			Labels:    teamRBAC.Labels,
+		},
+		Rules: accessLevel.Rules(teamRBAC.Spec.PatchWorkloads),
+	}
+
+	return yaml.Marshal(role)
+}
+
+func makeRoleBinding(accessLevel accessLevel, teamRBAC *TeamRBAC, namespace string) ([]byte, error) {
+	roleRef := rbacv1.RoleRef{
+		APIGroup: rbacv1.GroupName,
+		Kind:     reflect.TypeOf(rbacv1.Role{}).Name(),
+		Name:     makeName(accessLevel, teamRBAC),
+	}
+	if accessLevel == ReadOnly {
+		roleRef = rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     reflect.TypeOf(rbacv1.ClusterRole{}).Name(),
+			Name:     namespacedReadOnlyRoleName,
+		}
+	}
+
+	roleBinding := rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.RoleBinding{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: namespace,
+			Name:      makeName(accessLevel, teamRBAC),
+			Labels:    teamRBAC.Labels,
+		},
+		RoleRef:  roleRef,
+		Subjects: makeSubjects(makeGroups(accessLevel, teamRBAC)),
+	}
+
+	return yaml.Marshal(roleBinding)
+}
+
+func makeClusterRoleBinding(teamRBAC *TeamRBAC) ([]byte, error) {
+	clusterRoleBinding := rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(rbacv1.ClusterRoleBinding{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   makeName(ReadOnly, teamRBAC),
+			Labels: teamRBAC.Labels,
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     reflect.TypeOf(rbacv1.ClusterRole{}).Name(),
+			Name:     unnamespacedReadOnlyRoleName,
+		},
+		Subjects: makeSubjects(makeGroups(ReadOnly, teamRBAC)),
+	}
+
+	return yaml.Marshal(clusterRoleBinding)
+}
+
+func makeSubjects(names []string) []rbacv1.Subject {
+	var subjects []rbacv1.Subject
+
+	for _, name := range names {
+		subjects = append(subjects, rbacv1.Subject{
+			APIGroup: rbacv1.GroupName,
+			Kind:     rbacv1.GroupKind,
+			Name:     name,
+		})
+	}
+
+	return subjects
+}
@@ -0,0 +1,154 @@
+package teamrbac_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/teamrbac"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("TeamRBAC", func() {
+	ginkgo.It("generates roles and bindings for each namespace", func() {
+		var out bytes.Buffer
+		g.Expect(teamrbac.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamRBAC
+metadata:
+  name: sre
+spec:
+  namespaces:
+    - my-app
+    - my-app-jobs
+  accessControl:
+    ReadOnly:
+      - security:eng-0
+    ReadSync:
+      - sre:eng-0
+  clusterWide: true
+`), &out)).To(g.Succeed())
+
+		kinds := make(map[string]int)
+		for _, resource := range separatorYaml.Split(out.String(), -1) {
+			var meta metav1.TypeMeta
+			g.Expect(yaml.Unmarshal([]byte(resource), &meta)).To(g.Succeed())
+			g.Expect(meta.APIVersion).To(g.Equal(rbacv1.SchemeGroupVersion.String()))
+			kinds[meta.Kind]++
+
+			switch meta.Kind {
+			case "RoleBinding":
+				var roleBinding rbacv1.RoleBinding
+				g.Expect(yaml.Unmarshal([]byte(resource), &roleBinding)).To(g.Succeed())
+
+				switch roleBinding.Name {
+				case "sre:read-only":
+					g.Expect(roleBinding.RoleRef.Kind).To(g.Equal("ClusterRole"))
+					g.Expect(roleBinding.RoleRef.Name).To(g.Equal("namespaced-ro"))
+					g.Expect(subjectNames(roleBinding.Subjects)).To(g.Equal([]string{"security:eng-0", "sre:eng-0"}))
+				case "sre:read-sync":
+					g.Expect(roleBinding.RoleRef.Kind).To(g.Equal("Role"))
+					g.Expect(roleBinding.RoleRef.Name).To(g.Equal("sre:read-sync"))
+					g.Expect(subjectNames(roleBinding.Subjects)).To(g.Equal([]string{"sre:eng-0"}))
+				default:
+					ginkgo.Fail("unexpected RoleBinding " + roleBinding.Name)
+				}
+			case "Role":
+				var role rbacv1.Role
+				g.Expect(yaml.Unmarshal([]byte(resource), &role)).To(g.Succeed())
+				g.Expect(role.Name).To(g.Equal("sre:read-sync"))
+				g.Expect(role.Rules).To(g.HaveLen(2))
+			case "ClusterRoleBinding":
+				var clusterRoleBinding rbacv1.ClusterRoleBinding
+				g.Expect(yaml.Unmarshal([]byte(resource), &clusterRoleBinding)).To(g.Succeed())
+				g.Expect(clusterRoleBinding.RoleRef.Name).To(g.Equal("unnamespaced-ro"))
+				g.Expect(subjectNames(clusterRoleBinding.Subjects)).To(g.Equal([]string{"security:eng-0", "sre:eng-0"}))
+			}
+		}
+
+		g.Expect(kinds).To(g.Equal(map[string]int{
+			"Role":               2,
+			"RoleBinding":        4,
+			"ClusterRoleBinding": 1,
+		}))
+	})
+
+	ginkgo.DescribeTable("grants read-sync groups to patch workloads only when opted in", func(patchWorkloads string, verbs []string) {
+		var out bytes.Buffer
+		g.Expect(teamrbac.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamRBAC
+metadata:
+  name: sre
+  namespace: my-app
+spec:
+  accessControl:
+    ReadSync: [sre:eng-0]
+`+patchWorkloads), &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(3))
+
+		var role rbacv1.Role
+		g.Expect(yaml.Unmarshal([]byte(resources[1]), &role)).To(g.Succeed())
+		g.Expect(role.Rules).To(g.HaveLen(2))
+		for _, rule := range role.Rules {
+			g.Expect(rule.Verbs).To(g.Equal(verbs))
+		}
+	},
+		ginkgo.Entry("by default", "", []string{"get", "list", "watch"}),
+		ginkgo.Entry("with patchWorkloads", "  patchWorkloads: true\n", []string{"get", "list", "watch", "patch"}),
+	)
+
+	ginkgo.It("generates no bindings without subjects", func() {
+		var out bytes.Buffer
+		g.Expect(teamrbac.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamRBAC
+metadata:
+  name: sre
+spec:
+  namespaces: [my-app]
+  accessControl:
+    ReadOnly: [security:eng-0]
+`), &out)).To(g.Succeed())
+		g.Expect(separatorYaml.Split(out.String(), -1)).To(g.HaveLen(1))
+
+		var roleBinding rbacv1.RoleBinding
+		g.Expect(yaml.Unmarshal(bytes.TrimPrefix(out.Bytes(), []byte("---\n")), &roleBinding)).To(g.Succeed())
+		g.Expect(roleBinding.Name).To(g.Equal("sre:read-only"))
+		g.Expect(subjectNames(roleBinding.Subjects)).To(g.Equal([]string{"security:eng-0"}))
+	})
+
+	ginkgo.It("fails without namespaces", func() {
+		var out bytes.Buffer
+		g.Expect(teamrbac.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamRBAC
+metadata:
+  name: sre
+spec:
+  accessControl:
+    ReadSync:
+      - sre:eng-0
+`), &out)).To(g.MatchError("spec.namespaces is empty"))
+	})
+})
+
+func subjectNames(subjects []rbacv1.Subject) []string {
+	var names []string
+	for _, subject := range subjects {
+		g.Expect(subject.Kind).To(g.Equal(rbacv1.GroupKind))
+		names = append(names, subject.Name)
+	}
+
+	return names
+}
@@ -0,0 +1,167 @@
+// Package tenantnamespace generates the Namespace of a tenant, labeled with
+// its ownership and pod security, for the Kustomize plugin and the Tenant
+// plugin composing it.
+package tenantnamespace
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	yamlSeparator = "---\n"
+
+	teamLabel        = "incognia.com/team"
+	serviceLabel     = "incognia.com/service"
+	costCenterLabel  = "incognia.com/cost-center"
+	environmentLabel = "incognia.com/environment"
+
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityAuditLabel   = "pod-security.kubernetes.io/audit"
+	podSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+)
+
+type PodSecurityLevel string
+
+const (
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+	PodSecurityBaseline   PodSecurityLevel = "baseline"
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+)
+
+type TenantNamespace struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Team        string           `json:"team,omitempty"`
+	Service     string           `json:"service,omitempty"`
+	CostCenter  string           `json:"costCenter,omitempty"`
+	Environment string           `json:"environment,omitempty"`
+	PodSecurity PodSecurityLevel `json:"podSecurity,omitempty"`
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var tenantNamespace TenantNamespace
+	if err := pluginconfig.Unmarshal(data, &tenantNamespace); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&tenantNamespace)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(tenantNamespace *TenantNamespace) ([][]byte, error) {
+	namespace, err := makeNamespace(tenantNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{namespace}, nil
+}
+
+func makeNamespace(tenantNamespace *TenantNamespace) ([]byte, error) {
+	labels, err := makeLabels(&tenantNamespace.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	objectMeta := *tenantNamespace.ObjectMeta.DeepCopy()
+	if objectMeta.Name == "" {
+		objectMeta.Name = tenantNamespace.Spec.Service
+	}
+	if objectMeta.Name == "" {
+		return nil, fmt.Errorf("metadata.name and spec.service are empty")
+	}
+	if errs := validation.IsDNS1123Label(objectMeta.Name); len(errs) > 0 {
+		return nil, fmt.Errorf("namespace name %s is invalid: %s", objectMeta.Name, strings.Join(errs, ", "))
+	}
+
+	if objectMeta.Labels == nil {
+		objectMeta.Labels = make(map[string]string, len(labels))
+	}
+	for key, value := range labels {
+		objectMeta.Labels[key] = value
+	}
+
+	namespace := corev1.Namespace{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Namespace{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+	}
+
+	return yaml.Marshal(namespace)
+}
+
+func makeLabels(spec *Spec) (map[string]string, error) {
+	if spec.Team == "" {
+		return nil, fmt.Errorf("spec.team is empty")
+	}
+
+	if spec.CostCenter == "" {
+		return nil, fmt.Errorf("spec.costCenter is empty")
+	}
+
+	if spec.Environment == "" {
+		return nil, fmt.Errorf("spec.environment is empty")
+	}
+
+	podSecurity := spec.PodSecurity
+	if podSecurity == "" {
+		podSecurity = PodSecurityRestricted
+	}
+
+	switch podSecurity {
+	case PodSecurityPrivileged, PodSecurityBaseline, PodSecurityRestricted:
+	default:
+		return nil, fmt.Errorf("unknown pod security level %s", podSecurity)
+	}
+
+	labels := map[string]string{
+		teamLabel:               spec.Team,
+		costCenterLabel:         spec.CostCenter,
+		environmentLabel:        spec.Environment,
+		podSecurityEnforceLabel: string(podSecurity),
+		podSecurityAuditLabel:   string(podSecurity),
+		podSecurityWarnLabel:    string(podSecurity),
+	}
+
+	if spec.Service != "" {
+		labels[serviceLabel] = spec.Service
+	}
+
+	for key, value := range labels {
+		if errs := validation.IsValidLabelValue(value); len(errs) > 0 {
+			return nil, fmt.Errorf("label %s value %s is invalid: %s", key, value, strings.Join(errs, ", "))
+		}
+	}
+
+	return labels, nil
+}
@@ -0,0 +1,97 @@
+package tenantnamespace_test
+
+import (
+	"bytes"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/tenantnamespace"
+)
+
+var _ = ginkgo.Describe("TenantNamespace", func() {
+	ginkgo.DescribeTable("", func(config string, expectedName string, expectedLabels map[string]string) {
+		var out bytes.Buffer
+		g.Expect(tenantnamespace.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TenantNamespace
+`+config), &out)).To(g.Succeed())
+
+		var namespace corev1.Namespace
+		g.Expect(yaml.Unmarshal(out.Bytes(), &namespace)).To(g.Succeed())
+
+		g.Expect(namespace.Kind).To(g.Equal("Namespace"))
+		g.Expect(namespace.Name).To(g.Equal(expectedName))
+		g.Expect(namespace.Labels).To(g.Equal(expectedLabels))
+	},
+		ginkgo.Entry("with name from service", `
+spec:
+  team: sre
+  service: my-app
+  costCenter: cc-1234
+  environment: production
+`, "my-app", map[string]string{
+			"incognia.com/team":                  "sre",
+			"incognia.com/service":               "my-app",
+			"incognia.com/cost-center":           "cc-1234",
+			"incognia.com/environment":           "production",
+			"pod-security.kubernetes.io/enforce": "restricted",
+			"pod-security.kubernetes.io/audit":   "restricted",
+			"pod-security.kubernetes.io/warn":    "restricted",
+		}),
+		ginkgo.Entry("with explicit name, labels and pod security", `
+metadata:
+  name: monitoring
+  labels:
+    extra: label
+spec:
+  team: sre
+  costCenter: cc-1234
+  environment: staging
+  podSecurity: privileged
+`, "monitoring", map[string]string{
+			"extra":                              "label",
+			"incognia.com/team":                  "sre",
+			"incognia.com/cost-center":           "cc-1234",
+			"incognia.com/environment":           "staging",
+			"pod-security.kubernetes.io/enforce": "privileged",
+			"pod-security.kubernetes.io/audit":   "privileged",
+			"pod-security.kubernetes.io/warn":    "privileged",
+		}),
+	)
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(tenantnamespace.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TenantNamespace
+spec:
+`+spec), &out)).To(g.MatchError(g.ContainSubstring(expectedError)))
+	},
+		ginkgo.Entry("without team", `
+  service: my-app
+  costCenter: cc-1234
+  environment: production
+`, "spec.team is empty"),
+		ginkgo.Entry("without name", `
+  team: sre
+  costCenter: cc-1234
+  environment: production
+`, "metadata.name and spec.service are empty"),
+		ginkgo.Entry("with unknown pod security level", `
+  team: sre
+  service: my-app
+  costCenter: cc-1234
+  environment: production
+  podSecurity: permissive
+`, "unknown pod security level permissive"),
+		ginkgo.Entry("with invalid label value", `
+  team: sre
+  service: my-app
+  costCenter: cost center
+  environment: production
+`, "label incognia.com/cost-center value cost center is invalid"),
+	)
+})
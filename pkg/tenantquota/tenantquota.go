@@ -0,0 +1,188 @@
+// Package tenantquota generates the ResourceQuota and LimitRange of a tenant
+// size, for the Kustomize plugin and the Tenant plugin composing it.
+package tenantquota
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	yamlSeparator = "---\n"
+
+	productionEnvironment = "production"
+)
+
+type Size string
+
+const (
+	Small  Size = "small"
+	Medium Size = "medium"
+	Large  Size = "large"
+)
+
+type preset struct {
+	quota          corev1.ResourceList
+	defaultRequest corev1.ResourceList
+	defaultLimit   corev1.ResourceList
+	max            corev1.ResourceList
+}
+
+var (
+	productionPresets = map[Size]preset{
+		Small:  makePreset("4", "8Gi", "50", "2", "4Gi"),
+		Medium: makePreset("16", "32Gi", "150", "4", "8Gi"),
+		Large:  makePreset("64", "128Gi", "500", "8", "32Gi"),
+	}
+
+	nonProductionPresets = map[Size]preset{
+		Small:  makePreset("2", "4Gi", "25", "1", "2Gi"),
+		Medium: makePreset("8", "16Gi", "75", "2", "4Gi"),
+		Large:  makePreset("32", "64Gi", "250", "4", "16Gi"),
+	}
+)
+
+type TenantQuota struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Size        Size   `json:"size,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var tenantQuota TenantQuota
+	if err := pluginconfig.Unmarshal(data, &tenantQuota); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&tenantQuota)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makePreset(cpu, memory, pods, containerCPU, containerMemory string) preset {
+	return preset{
+		quota: corev1.ResourceList{
+			corev1.ResourceRequestsCPU:    resource.MustParse(cpu),
+			corev1.ResourceRequestsMemory: resource.MustParse(memory),
+			corev1.ResourceLimitsCPU:      resource.MustParse(cpu),
+			corev1.ResourceLimitsMemory:   resource.MustParse(memory),
+			corev1.ResourcePods:           resource.MustParse(pods),
+		},
+		defaultRequest: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		defaultLimit: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("500m"),
+			corev1.ResourceMemory: resource.MustParse("512Mi"),
+		},
+		max: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse(containerCPU),
+			corev1.ResourceMemory: resource.MustParse(containerMemory),
+		},
+	}
+}
+
+func lookupPreset(spec *Spec) (preset, error) {
+	if spec.Size == "" {
+		return preset{}, fmt.Errorf("spec.size is empty")
+	}
+
+	if spec.Environment == "" {
+		return preset{}, fmt.Errorf("spec.environment is empty")
+	}
+
+	presets := nonProductionPresets
+	if spec.Environment == productionEnvironment {
+		presets = productionPresets
+	}
+
+	p, exists := presets[spec.Size]
+	if !exists {
+		return preset{}, fmt.Errorf("unknown size %s", spec.Size)
+	}
+
+	return p, nil
+}
+
+func makeManifests(tenantQuota *TenantQuota) ([][]byte, error) {
+	p, err := lookupPreset(&tenantQuota.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	resourceQuota, err := makeResourceQuota(tenantQuota, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	limitRange, err := makeLimitRange(tenantQuota, &p)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{resourceQuota, limitRange}, nil
+}
+
+func makeResourceQuota(tenantQuota *TenantQuota, p *preset) ([]byte, error) {
+	resourceQuota := corev1.ResourceQuota{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ResourceQuota{}).Name(),
+		},
+		ObjectMeta: tenantQuota.ObjectMeta,
+		Spec: corev1.ResourceQuotaSpec{
+			Hard: p.quota,
+		},
+	}
+
+	return yaml.Marshal(resourceQuota)
+}
+
+func makeLimitRange(tenantQuota *TenantQuota, p *preset) ([]byte, error) {
+	limitRange := corev1.LimitRange{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.LimitRange{}).Name(),
+		},
+		ObjectMeta: tenantQuota.ObjectMeta,
+		Spec: corev1.LimitRangeSpec{
+			Limits: []corev1.LimitRangeItem{
+				corev1.LimitRangeItem{
+					Type:           corev1.LimitTypeContainer,
+					Default:        p.defaultLimit,
+					DefaultRequest: p.defaultRequest,
+					Max:            p.max,
+				},
+			},
+		},
+	}
+
+	return yaml.Marshal(limitRange)
+}
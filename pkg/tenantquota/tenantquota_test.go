@@ -0,0 +1,67 @@
+package tenantquota_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/tenantquota"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("TenantQuota", func() {
+	generate := func(spec string, out *bytes.Buffer) error {
+		return tenantquota.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TenantQuota
+metadata:
+  name: tenant
+  namespace: my-app
+spec: `+spec+`
+`), out)
+	}
+
+	ginkgo.DescribeTable("", func(spec string, expectedCPU string, expectedMaxMemory string) {
+		var out bytes.Buffer
+		g.Expect(generate(spec, &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(2))
+
+		var resourceQuota corev1.ResourceQuota
+		g.Expect(yaml.Unmarshal([]byte(resources[0]), &resourceQuota)).To(g.Succeed())
+		g.Expect(resourceQuota.Kind).To(g.Equal("ResourceQuota"))
+		g.Expect(resourceQuota.Name).To(g.Equal("tenant"))
+		g.Expect(resourceQuota.Namespace).To(g.Equal("my-app"))
+		g.Expect(resourceQuota.Spec.Hard[corev1.ResourceRequestsCPU]).To(g.Equal(resource.MustParse(expectedCPU)))
+
+		var limitRange corev1.LimitRange
+		g.Expect(yaml.Unmarshal([]byte(resources[1]), &limitRange)).To(g.Succeed())
+		g.Expect(limitRange.Kind).To(g.Equal("LimitRange"))
+		g.Expect(limitRange.Name).To(g.Equal("tenant"))
+		g.Expect(limitRange.Spec.Limits).To(g.HaveLen(1))
+		g.Expect(limitRange.Spec.Limits[0].Type).To(g.Equal(corev1.LimitTypeContainer))
+		g.Expect(limitRange.Spec.Limits[0].Max[corev1.ResourceMemory]).To(g.Equal(resource.MustParse(expectedMaxMemory)))
+	},
+		ginkgo.Entry("small in production", "{size: small, environment: production}", "4", "4Gi"),
+		ginkgo.Entry("small in staging", "{size: small, environment: staging}", "2", "2Gi"),
+		ginkgo.Entry("large in production", "{size: large, environment: production}", "64", "32Gi"),
+	)
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(generate(spec, &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without size", "{environment: production}", "spec.size is empty"),
+		ginkgo.Entry("without environment", "{size: medium}", "spec.environment is empty"),
+		ginkgo.Entry("with unknown size", "{size: huge, environment: production}", "unknown size huge"),
+	)
+})
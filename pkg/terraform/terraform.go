@@ -0,0 +1,168 @@
+// Package terraform reads the outputs of Terraform, as written by `terraform
+// output -json`, so plugins substitute values of the infrastructure, such as
+// cluster endpoints, role ARNs and domain names, into their templates.
+package terraform
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// OutputsEnv is the path of the outputs file read by plugins, which
+	// Kustomize runs without flags.
+	OutputsEnv = "TERRAFORM_OUTPUTS"
+
+	pathSeparator = "."
+)
+
+var (
+	// Placeholders are {{terraform.name}}, where name may index the maps and
+	// lists of values by dots, as in {{terraform.cluster.endpoint}}.
+	placeholder = regexp.MustCompile(`\{\{\s*terraform\.([^{}\s]+)\s*\}\}`)
+)
+
+// Outputs are the values of the outputs of Terraform by name.
+type Outputs map[string]interface{}
+
+// sensitive replaces the values of sensitive outputs, which are never
+// substituted, as manifests are committed and shown in diffs.
+type sensitive struct{}
+
+type output struct {
+	Sensitive bool            `json:"sensitive"`
+	Type      json.RawMessage `json:"type"`
+	Value     interface{}     `json:"value"`
+}
+
+// ReadOutputs reads a file written by `terraform output -json`.
+func ReadOutputs(path string) (Outputs, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var outputs map[string]output
+	if err := json.Unmarshal(data, &outputs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	values := make(Outputs, len(outputs))
+	for name, output := range outputs {
+		if output.Sensitive {
+			values[name] = sensitive{}
+			continue
+		}
+		values[name] = output.Value
+	}
+
+	return values, nil
+}
+
+// ReadOutputsFromEnv reads the file of OutputsEnv, returning nil outputs when
+// it is unset.
+func ReadOutputsFromEnv() (Outputs, error) {
+	path, exists := os.LookupEnv(OutputsEnv)
+	if !exists || path == "" {
+		return nil, nil
+	}
+
+	return ReadOutputs(path)
+}
+
+// Lookup returns the value of an output, indexing its maps and lists by the
+// dots of the path, failing on sensitive outputs.
+func (o Outputs) Lookup(path string) (interface{}, error) {
+	var value interface{} = map[string]interface{}(o)
+	for _, segment := range strings.Split(path, pathSeparator) {
+		switch v := value.(type) {
+		case sensitive:
+			return nil, fmt.Errorf("terraform output %s is sensitive", path)
+		case map[string]interface{}:
+			item, exists := v[segment]
+			if !exists {
+				return nil, fmt.Errorf("terraform output %s is missing", path)
+			}
+			value = item
+		case []interface{}:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("terraform output %s is missing", path)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("terraform output %s is missing", path)
+		}
+	}
+
+	if _, ok := value.(sensitive); ok {
+		return nil, fmt.Errorf("terraform output %s is sensitive", path)
+	}
+
+	return value, nil
+}
+
+// Substitute replaces the placeholders of s with the values of the outputs,
+// which must be strings, numbers or booleans.
+func (o Outputs) Substitute(s string) (string, error) {
+	var err error
+	substituted := placeholder.ReplaceAllStringFunc(s, func(match string) string {
+		if err != nil {
+			return match
+		}
+
+		path := placeholder.FindStringSubmatch(match)[1]
+
+		var value interface{}
+		value, err = o.Lookup(path)
+		if err != nil {
+			return match
+		}
+
+		switch v := value.(type) {
+		case string:
+			return v
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			return strconv.FormatBool(v)
+		default:
+			err = fmt.Errorf("terraform output %s is not a string, number or boolean", path)
+			return match
+		}
+	})
+
+	return substituted, err
+}
+
+// SubstituteAll replaces the placeholders of every string of a decoded JSON
+// document.
+func (o Outputs) SubstituteAll(document interface{}) (interface{}, error) {
+	switch v := document.(type) {
+	case string:
+		return o.Substitute(v)
+	case map[string]interface{}:
+		for key, value := range v {
+			substituted, err := o.SubstituteAll(value)
+			if err != nil {
+				return nil, err
+			}
+			v[key] = substituted
+		}
+	case []interface{}:
+		for i, value := range v {
+			substituted, err := o.SubstituteAll(value)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = substituted
+		}
+	}
+
+	return document, nil
+}
@@ -0,0 +1,77 @@
+package terraform_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/terraform"
+)
+
+var _ = ginkgo.Describe("Outputs", func() {
+	const outputs = `{
+  "cluster_endpoint": {"sensitive": false, "type": "string", "value": "https://eks.example.com"},
+  "node_count": {"sensitive": false, "type": "number", "value": 3},
+  "roles": {"sensitive": false, "type": ["object", {"deployer": "string"}], "value": {"deployer": "arn:aws:iam::123456789012:role/deployer"}},
+  "domains": {"sensitive": false, "type": ["list", "string"], "value": ["example.com", "example.org"]},
+  "database": {"sensitive": true, "type": ["object", {"password": "string"}], "value": {"password": "hunter2"}}
+}`
+
+	var outputsPath string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "terraform")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+
+		outputsPath = filepath.Join(d, "outputs.json")
+		g.Expect(ioutil.WriteFile(outputsPath, []byte(outputs), 0644)).To(g.Succeed())
+	})
+
+	ginkgo.DescribeTable("substitutes placeholders", func(s string, expected string) {
+		outputs, err := terraform.ReadOutputs(outputsPath)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(outputs.Substitute(s)).To(g.Equal(expected))
+	},
+		ginkgo.Entry("of strings", "{{terraform.cluster_endpoint}}", "https://eks.example.com"),
+		ginkgo.Entry("of numbers", "{{ terraform.node_count }} nodes", "3 nodes"),
+		ginkgo.Entry("of objects", "{{terraform.roles.deployer}}", "arn:aws:iam::123456789012:role/deployer"),
+		ginkgo.Entry("of lists", "api.{{terraform.domains.1}}", "api.example.org"),
+		ginkgo.Entry("of other templates", "{{project}}", "{{project}}"),
+	)
+
+	ginkgo.DescribeTable("fails on placeholders", func(s string, expectedErr string) {
+		outputs, err := terraform.ReadOutputs(outputsPath)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		_, err = outputs.Substitute(s)
+		g.Expect(err).To(g.MatchError(expectedErr))
+	},
+		ginkgo.Entry("of missing outputs", "{{terraform.vpc_id}}", "terraform output vpc_id is missing"),
+		ginkgo.Entry("of missing indexes", "{{terraform.domains.2}}", "terraform output domains.2 is missing"),
+		ginkgo.Entry("of objects", "{{terraform.roles}}", "terraform output roles is not a string, number or boolean"),
+		ginkgo.Entry("of sensitive outputs", "{{terraform.database}}", "terraform output database is sensitive"),
+		ginkgo.Entry("indexing sensitive outputs", "{{terraform.database.password}}", "terraform output database.password is sensitive"),
+	)
+
+	ginkgo.It("substitutes the placeholders of documents", func() {
+		outputs, err := terraform.ReadOutputs(outputsPath)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		document, err := outputs.SubstituteAll(map[string]interface{}{
+			"server": "{{terraform.cluster_endpoint}}",
+			"hosts":  []interface{}{"{{terraform.domains.0}}", true},
+		})
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(document).To(g.Equal(map[string]interface{}{
+			"server": "https://eks.example.com",
+			"hosts":  []interface{}{"example.com", true},
+		}))
+	})
+
+	ginkgo.It("reads no outputs without the environment variable", func() {
+		g.Expect(terraform.ReadOutputsFromEnv()).To(g.BeNil())
+	})
+})
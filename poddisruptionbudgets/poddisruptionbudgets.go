@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-pod-disruption-budget"
+
+	defaultMinReplicas = 2
+)
+
+var (
+	podDisruptionBudgetKind = reflect.TypeOf(policyv1.PodDisruptionBudget{}).Name()
+
+	defaultKinds = []string{
+		"Deployment",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	defaultMinAvailable = intstr.FromString("50%")
+)
+
+type PodDisruptionBudgets struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds          []string            `json:"kinds,omitempty"`
+	MinReplicas    int32               `json:"minReplicas,omitempty"`
+	MinAvailable   *intstr.IntOrString `json:"minAvailable,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+type workloadKey struct {
+	namespace string
+	name      string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var podDisruptionBudgets PodDisruptionBudgets
+	if err := pluginconfig.Unmarshal(data, &podDisruptionBudgets); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	nodes, err = transform(&podDisruptionBudgets, nodes)
+	if err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(podDisruptionBudgets *PodDisruptionBudgets, nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	spec := &podDisruptionBudgets.Spec
+
+	if spec.MinAvailable != nil && spec.MaxUnavailable != nil {
+		return nil, fmt.Errorf("spec.minAvailable and spec.maxUnavailable are mutually exclusive")
+	}
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	minReplicas := spec.MinReplicas
+	if minReplicas == 0 {
+		minReplicas = defaultMinReplicas
+	}
+
+	existing := make(map[workloadKey]bool)
+	for _, node := range nodes {
+		if node.GetKind() == podDisruptionBudgetKind {
+			existing[workloadKey{
+				namespace: node.GetNamespace(),
+				name:      node.GetName(),
+			}] = true
+		}
+	}
+
+	var budgets []*kyaml.RNode
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		if existing[workloadKey{
+			namespace: node.GetNamespace(),
+			name:      node.GetName(),
+		}] {
+			continue
+		}
+
+		replicas, err := readReplicas(node)
+		if err != nil {
+			return nil, err
+		}
+		if replicas < minReplicas {
+			continue
+		}
+
+		var selector metav1.LabelSelector
+		if err := decodeField(node, []string{"spec", "selector"}, &selector); err != nil {
+			return nil, err
+		}
+		if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+			return nil, fmt.Errorf("%s %s has no spec.selector", node.GetKind(), node.GetName())
+		}
+
+		budget, err := makePodDisruptionBudget(spec, node, replicas, &selector)
+		if err != nil {
+			return nil, err
+		}
+		budgets = append(budgets, budget)
+	}
+
+	return append(nodes, budgets...), nil
+}
+
+func makePodDisruptionBudget(spec *Spec, node *kyaml.RNode, replicas int32, selector *metav1.LabelSelector) (*kyaml.RNode, error) {
+	budgetSpec := policyv1.PodDisruptionBudgetSpec{
+		Selector: selector,
+	}
+
+	if spec.MaxUnavailable != nil {
+		budgetSpec.MaxUnavailable = spec.MaxUnavailable
+	} else {
+		minAvailable := defaultMinAvailable
+		if spec.MinAvailable != nil {
+			minAvailable = *spec.MinAvailable
+		}
+
+		count, err := computeMinAvailable(minAvailable, replicas)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %w", node.GetKind(), node.GetName(), err)
+		}
+
+		value := intstr.FromInt(int(count))
+		budgetSpec.MinAvailable = &value
+	}
+
+	budget := policyv1.PodDisruptionBudget{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: policyv1.SchemeGroupVersion.String(),
+			Kind:       podDisruptionBudgetKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      node.GetName(),
+			Namespace: node.GetNamespace(),
+			Labels:    node.GetLabels(),
+		},
+		Spec: budgetSpec,
+	}
+
+	b, err := yaml.Marshal(budget)
+	if err != nil {
+		return nil, err
+	}
+
+	return kyaml.Parse(string(b))
+}
+
+// computeMinAvailable resolves minAvailable against the number of replicas,
+// keeping at least one pod evictable so node drains are never blocked.
+func computeMinAvailable(minAvailable intstr.IntOrString, replicas int32) (int32, error) {
+	count, err := intstr.GetScaledValueFromIntOrPercent(&minAvailable, int(replicas), true)
+	if err != nil {
+		return 0, err
+	}
+
+	if int32(count) > replicas-1 {
+		return replicas - 1, nil
+	}
+
+	return int32(count), nil
+}
+
+func readReplicas(node *kyaml.RNode) (int32, error) {
+	value, err := node.Pipe(kyaml.Lookup("spec", "replicas"))
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 1, nil
+	}
+
+	replicas, err := strconv.ParseInt(kyaml.GetValue(value), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(replicas), nil
+}
+
+func decodeField(node *kyaml.RNode, path []string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
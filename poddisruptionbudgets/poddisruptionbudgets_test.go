@@ -0,0 +1,155 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/poddisruptionbudgets"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  replicas: 4
+  selector:
+    matchLabels:
+      app: my-app
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: my-rollout
+  namespace: my-namespace
+spec:
+  replicas: 2
+  selector:
+    matchLabels:
+      app: my-rollout
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: single
+  namespace: my-namespace
+spec:
+  selector:
+    matchLabels:
+      app: single
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: explicit
+  namespace: my-namespace
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: explicit
+---
+apiVersion: policy/v1
+kind: PodDisruptionBudget
+metadata:
+  name: explicit
+  namespace: my-namespace
+spec:
+  maxUnavailable: 2
+  selector:
+    matchLabels:
+      app: explicit
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("PodDisruptionBudgets", func() {
+	ginkgo.DescribeTable("", func(config string, expected map[string]policyv1.PodDisruptionBudgetSpec) {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		budgets := make(map[string]policyv1.PodDisruptionBudgetSpec)
+		for _, manifest := range separatorYaml.Split(out.String(), -1) {
+			var budget policyv1.PodDisruptionBudget
+			g.Expect(yaml.Unmarshal([]byte(manifest), &budget)).To(g.Succeed())
+			if budget.Kind == "PodDisruptionBudget" {
+				g.Expect(budget.Namespace).To(g.Equal("my-namespace"))
+				budgets[budget.Name] = budget.Spec
+			}
+		}
+
+		g.Expect(budgets).To(g.Equal(expected))
+	},
+		ginkgo.Entry("with default policy", `
+apiVersion: incognia.com/v1alpha1
+kind: PodDisruptionBudgets
+metadata:
+  name: pod-disruption-budgets
+`, map[string]policyv1.PodDisruptionBudgetSpec{
+			"my-app":     makeMinAvailable("my-app", 2),
+			"my-rollout": makeMinAvailable("my-rollout", 1),
+			"explicit":   makeMaxUnavailable("explicit", intstr.FromInt(2)),
+		}),
+		ginkgo.Entry("with minAvailable capped to keep a pod evictable", `
+apiVersion: incognia.com/v1alpha1
+kind: PodDisruptionBudgets
+metadata:
+  name: pod-disruption-budgets
+spec:
+  minAvailable: 100%
+`, map[string]policyv1.PodDisruptionBudgetSpec{
+			"my-app":     makeMinAvailable("my-app", 3),
+			"my-rollout": makeMinAvailable("my-rollout", 1),
+			"explicit":   makeMaxUnavailable("explicit", intstr.FromInt(2)),
+		}),
+		ginkgo.Entry("with maxUnavailable", `
+apiVersion: incognia.com/v1alpha1
+kind: PodDisruptionBudgets
+metadata:
+  name: pod-disruption-budgets
+spec:
+  maxUnavailable: 25%
+`, map[string]policyv1.PodDisruptionBudgetSpec{
+			"my-app":     makeMaxUnavailable("my-app", intstr.FromString("25%")),
+			"my-rollout": makeMaxUnavailable("my-rollout", intstr.FromString("25%")),
+			"explicit":   makeMaxUnavailable("explicit", intstr.FromInt(2)),
+		}),
+	)
+})
+
+func makeSelector(app string) *metav1.LabelSelector {
+	return &metav1.LabelSelector{
+		MatchLabels: map[string]string{
+			"app": app,
+		},
+	}
+}
+
+func makeMinAvailable(app string, minAvailable int) policyv1.PodDisruptionBudgetSpec {
+	value := intstr.FromInt(minAvailable)
+	return policyv1.PodDisruptionBudgetSpec{
+		MinAvailable: &value,
+		Selector:     makeSelector(app),
+	}
+}
+
+func makeMaxUnavailable(app string, maxUnavailable intstr.IntOrString) policyv1.PodDisruptionBudgetSpec {
+	return policyv1.PodDisruptionBudgetSpec{
+		MaxUnavailable: &maxUnavailable,
+		Selector:       makeSelector(app),
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+	podSecurityAuditLabel   = "pod-security.kubernetes.io/audit"
+	podSecurityWarnLabel    = "pod-security.kubernetes.io/warn"
+)
+
+var (
+	namespaceKind = reflect.TypeOf(corev1.Namespace{}).Name()
+)
+
+type Level string
+
+const (
+	Privileged Level = "privileged"
+	Baseline   Level = "baseline"
+	Restricted Level = "restricted"
+)
+
+func (l Level) Validate() error {
+	switch l {
+	case Privileged, Baseline, Restricted:
+		return nil
+	default:
+		return fmt.Errorf("unknown pod security level %s", l)
+	}
+}
+
+type PodSecurityLabels struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	EnvironmentLabel string           `json:"environmentLabel,omitempty"`
+	DefaultLevel     Level            `json:"defaultLevel,omitempty"`
+	Environments     map[string]Level `json:"environments,omitempty"`
+	Exceptions       []Exception      `json:"exceptions,omitempty"`
+}
+
+type Exception struct {
+	Namespace string `json:"namespace,omitempty"`
+	Level     Level  `json:"level,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var podSecurityLabels PodSecurityLabels
+	if err := pluginconfig.Unmarshal(data, &podSecurityLabels); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&podSecurityLabels, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(podSecurityLabels *PodSecurityLabels, nodes []*kyaml.RNode) error {
+	spec := &podSecurityLabels.Spec
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	defaultLevel := spec.DefaultLevel
+	if defaultLevel == "" {
+		defaultLevel = Restricted
+	}
+	if err := defaultLevel.Validate(); err != nil {
+		return err
+	}
+
+	for environment, level := range spec.Environments {
+		if err := level.Validate(); err != nil {
+			return fmt.Errorf("environment %s: %w", environment, err)
+		}
+	}
+
+	exceptions := make(map[string]Level, len(spec.Exceptions))
+	for _, exception := range spec.Exceptions {
+		if exception.Namespace == "" {
+			return fmt.Errorf("exception without namespace")
+		}
+
+		if err := exception.Level.Validate(); err != nil {
+			return fmt.Errorf("exception %s: %w", exception.Namespace, err)
+		}
+
+		if _, exists := exceptions[exception.Namespace]; exists {
+			return fmt.Errorf("exception %s is duplicated", exception.Namespace)
+		}
+		exceptions[exception.Namespace] = exception.Level
+	}
+
+	for _, node := range nodes {
+		if node.GetKind() != namespaceKind {
+			continue
+		}
+
+		level, exists := exceptions[node.GetName()]
+		if !exists {
+			level, exists = spec.Environments[node.GetLabels()[environmentLabel]]
+		}
+		if !exists {
+			level = defaultLevel
+		}
+
+		for _, label := range []string{podSecurityEnforceLabel, podSecurityAuditLabel, podSecurityWarnLabel} {
+			if err := node.PipeE(kyaml.SetLabel(label, string(level))); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
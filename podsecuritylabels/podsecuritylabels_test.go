@@ -0,0 +1,148 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/podsecuritylabels"
+)
+
+const (
+	enforceLabel = "pod-security.kubernetes.io/enforce"
+
+	resources = `
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: production
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: my-app-staging
+  labels:
+    incognia.com/environment: staging
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: monitoring
+  labels:
+    incognia.com/environment: production
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: unlabeled
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-app
+  namespace: my-app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("PodSecurityLabels", func() {
+	ginkgo.It("labels namespaces by environment and exceptions", func() {
+		levels := transform([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PodSecurityLabels
+metadata:
+  name: pod-security-labels
+spec:
+  defaultLevel: baseline
+  environments:
+    production: restricted
+  exceptions:
+    - namespace: monitoring
+      level: privileged
+`))
+
+		g.Expect(levels).To(g.Equal(map[string]map[string]string{
+			"my-app": {
+				"pod-security.kubernetes.io/enforce": "restricted",
+				"pod-security.kubernetes.io/audit":   "restricted",
+				"pod-security.kubernetes.io/warn":    "restricted",
+			},
+			"my-app-staging": {
+				"pod-security.kubernetes.io/enforce": "baseline",
+				"pod-security.kubernetes.io/audit":   "baseline",
+				"pod-security.kubernetes.io/warn":    "baseline",
+			},
+			"monitoring": {
+				"pod-security.kubernetes.io/enforce": "privileged",
+				"pod-security.kubernetes.io/audit":   "privileged",
+				"pod-security.kubernetes.io/warn":    "privileged",
+			},
+			"unlabeled": {
+				"pod-security.kubernetes.io/enforce": "baseline",
+				"pod-security.kubernetes.io/audit":   "baseline",
+				"pod-security.kubernetes.io/warn":    "baseline",
+			},
+		}))
+	})
+
+	ginkgo.It("defaults to restricted", func() {
+		levels := transform([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PodSecurityLabels
+metadata:
+  name: pod-security-labels
+`))
+
+		for _, labels := range levels {
+			g.Expect(labels).To(g.HaveKeyWithValue(enforceLabel, "restricted"))
+		}
+	})
+
+	ginkgo.It("fails with unknown levels", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PodSecurityLabels
+metadata:
+  name: pod-security-labels
+spec:
+  environments:
+    production: strict
+`), strings.NewReader(resources), &out)).To(g.MatchError("environment production: unknown pod security level strict"))
+	})
+})
+
+func transform(config []byte) map[string]map[string]string {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests(config, strings.NewReader(resources), &out)).To(g.Succeed())
+
+	levels := make(map[string]map[string]string)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var namespace corev1.Namespace
+		g.Expect(yaml.Unmarshal([]byte(manifest), &namespace)).To(g.Succeed())
+		if namespace.Kind != "Namespace" {
+			g.Expect(namespace.Labels).To(g.BeEmpty())
+			continue
+		}
+
+		labels := make(map[string]string)
+		for key, value := range namespace.Labels {
+			if strings.HasPrefix(key, "pod-security.kubernetes.io/") {
+				labels[key] = value
+			}
+		}
+		levels[namespace.Name] = labels
+	}
+
+	return levels
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+)
+
+type priorityClass struct {
+	name             string
+	value            int32
+	preemptionPolicy corev1.PreemptionPolicy
+	description      string
+}
+
+var (
+	standardPriorityClasses = []priorityClass{
+		priorityClass{
+			name:             "incognia-critical",
+			value:            1000000,
+			preemptionPolicy: corev1.PreemptLowerPriority,
+			description:      "Services whose unavailability causes an outage.",
+		},
+		priorityClass{
+			name:             "incognia-high",
+			value:            100000,
+			preemptionPolicy: corev1.PreemptLowerPriority,
+			description:      "Services whose unavailability degrades the product.",
+		},
+		priorityClass{
+			name:             "incognia-default",
+			value:            10000,
+			preemptionPolicy: corev1.PreemptLowerPriority,
+			description:      "Services without special scheduling requirements.",
+		},
+		priorityClass{
+			name:             "incognia-low",
+			value:            1000,
+			preemptionPolicy: corev1.PreemptNever,
+			description:      "Batch and best-effort workloads, which never preempt others.",
+		},
+	}
+)
+
+type PriorityClasses struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	GlobalDefault string `json:"globalDefault,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var priorityClasses PriorityClasses
+	if err := pluginconfig.Unmarshal(data, &priorityClasses); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&priorityClasses)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(priorityClasses *PriorityClasses) ([][]byte, error) {
+	globalDefault := priorityClasses.Spec.GlobalDefault
+
+	var found bool
+	manifests := make([][]byte, 0, len(standardPriorityClasses))
+	for _, class := range standardPriorityClasses {
+		isGlobalDefault := class.name == globalDefault
+		found = found || isGlobalDefault
+
+		b, err := makePriorityClass(priorityClasses, &class, isGlobalDefault)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	if globalDefault != "" && !found {
+		return nil, fmt.Errorf("unknown priority class %s", globalDefault)
+	}
+
+	return manifests, nil
+}
+
+func makePriorityClass(priorityClasses *PriorityClasses, class *priorityClass, globalDefault bool) ([]byte, error) {
+	preemptionPolicy := class.preemptionPolicy
+
+	manifest := schedulingv1.PriorityClass{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schedulingv1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(schedulingv1.PriorityClass{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        class.name,
+			Labels:      priorityClasses.Labels,
+			Annotations: priorityClasses.Annotations,
+		},
+		Value:            class.value,
+		GlobalDefault:    globalDefault,
+		PreemptionPolicy: &preemptionPolicy,
+		Description:      class.description,
+	}
+
+	return yaml.Marshal(manifest)
+}
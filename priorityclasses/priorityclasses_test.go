@@ -0,0 +1,69 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	schedulingv1 "k8s.io/api/scheduling/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/priorityclasses"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("PriorityClasses", func() {
+	ginkgo.It("generates the standard priority classes", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PriorityClasses
+metadata:
+  name: priority-classes
+spec:
+  globalDefault: incognia-default
+`), &out)).To(g.Succeed())
+
+		values := make(map[string]int32)
+		var globalDefaults []string
+		for _, resource := range separatorYaml.Split(out.String(), -1) {
+			var priorityClass schedulingv1.PriorityClass
+			g.Expect(yaml.Unmarshal([]byte(resource), &priorityClass)).To(g.Succeed())
+			g.Expect(priorityClass.Kind).To(g.Equal("PriorityClass"))
+
+			values[priorityClass.Name] = priorityClass.Value
+			if priorityClass.GlobalDefault {
+				globalDefaults = append(globalDefaults, priorityClass.Name)
+			}
+
+			if priorityClass.Name == "incognia-low" {
+				g.Expect(*priorityClass.PreemptionPolicy).To(g.Equal(corev1.PreemptNever))
+			}
+		}
+
+		g.Expect(values).To(g.Equal(map[string]int32{
+			"incognia-critical": 1000000,
+			"incognia-high":     100000,
+			"incognia-default":  10000,
+			"incognia-low":      1000,
+		}))
+		g.Expect(globalDefaults).To(g.Equal([]string{"incognia-default"}))
+	})
+
+	ginkgo.It("fails with an unknown global default", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PriorityClasses
+metadata:
+  name: priority-classes
+spec:
+  globalDefault: incognia-medium
+`), &out)).To(g.MatchError("unknown priority class incognia-medium"))
+	})
+})
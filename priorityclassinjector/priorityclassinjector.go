@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+	cronJobKind             = "CronJob"
+	priorityClassNameField  = "priorityClassName"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podSpecPath = []string{
+		"spec",
+		"template",
+		"spec",
+	}
+
+	cronJobPodSpecPath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+		"spec",
+	}
+)
+
+type PriorityClassInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds            []string `json:"kinds,omitempty"`
+	EnvironmentLabel string   `json:"environmentLabel,omitempty"`
+	Rules            []Rule   `json:"rules,omitempty"`
+}
+
+type Rule struct {
+	Selector          *metav1.LabelSelector `json:"selector,omitempty"`
+	Environments      []string              `json:"environments,omitempty"`
+	PriorityClassName string                `json:"priorityClassName,omitempty"`
+}
+
+type rule struct {
+	selector          labels.Selector
+	environments      []string
+	priorityClassName string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var priorityClassInjector PriorityClassInjector
+	if err := pluginconfig.Unmarshal(data, &priorityClassInjector); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&priorityClassInjector, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(priorityClassInjector *PriorityClassInjector, nodes []*kyaml.RNode) error {
+	kinds := priorityClassInjector.Spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	environmentLabel := priorityClassInjector.Spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	rules, err := makeRules(priorityClassInjector.Spec.Rules)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		path := podSpecPath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodSpecPath
+		}
+
+		podSpec, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if podSpec == nil {
+			continue
+		}
+
+		current, err := podSpec.Pipe(kyaml.Lookup(priorityClassNameField))
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			continue
+		}
+
+		nodeLabels := labels.Set(node.GetLabels())
+		for _, r := range rules {
+			if !r.selector.Matches(nodeLabels) {
+				continue
+			}
+
+			if len(r.environments) > 0 && !containsString(r.environments, nodeLabels[environmentLabel]) {
+				continue
+			}
+
+			if err := podSpec.PipeE(kyaml.SetField(priorityClassNameField, kyaml.NewScalarRNode(r.priorityClassName))); err != nil {
+				return err
+			}
+			break
+		}
+	}
+
+	return nil
+}
+
+func makeRules(specRules []Rule) ([]rule, error) {
+	rules := make([]rule, 0, len(specRules))
+
+	for i, specRule := range specRules {
+		if specRule.PriorityClassName == "" {
+			return nil, fmt.Errorf("spec.rules[%d].priorityClassName is empty", i)
+		}
+
+		selector := labels.Everything()
+		if specRule.Selector != nil {
+			s, err := metav1.LabelSelectorAsSelector(specRule.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("spec.rules[%d].selector: %w", i, err)
+			}
+			selector = s
+		}
+
+		rules = append(rules, rule{
+			selector:          selector,
+			environments:      specRule.Environments,
+			priorityClassName: specRule.PriorityClassName,
+		})
+	}
+
+	return rules, nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
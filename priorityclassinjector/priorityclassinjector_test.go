@@ -0,0 +1,147 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/priorityclassinjector"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: PriorityClassInjector
+metadata:
+  name: priority-class-injector
+spec:
+  rules:
+    - selector:
+        matchLabels:
+          incognia.com/tier: critical
+      environments:
+        - production
+      priorityClassName: incognia-critical
+    - selector:
+        matchExpressions:
+          - key: incognia.com/tier
+            operator: In
+            values:
+              - batch
+      priorityClassName: incognia-low
+    - priorityClassName: incognia-default
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: critical-production
+  labels:
+    incognia.com/tier: critical
+    incognia.com/environment: production
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: critical-staging
+  labels:
+    incognia.com/tier: critical
+    incognia.com/environment: staging
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: batch
+  labels:
+    incognia.com/tier: batch
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: explicit
+  labels:
+    incognia.com/tier: critical
+    incognia.com/environment: production
+spec:
+  template:
+    spec:
+      priorityClassName: incognia-high
+      containers:
+        - name: app
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: service
+`
+)
+
+var _ = ginkgo.Describe("PriorityClassInjector", func() {
+	ginkgo.It("assigns priority classes by the first matching rule", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).To(g.BeNil())
+
+		priorityClassNames := make(map[string]string)
+		for _, node := range nodes {
+			path := []string{"spec", "template", "spec", "priorityClassName"}
+			if node.GetKind() == "CronJob" {
+				path = []string{"spec", "jobTemplate", "spec", "template", "spec", "priorityClassName"}
+			}
+
+			value, err := node.Pipe(kyaml.Lookup(path...))
+			g.Expect(err).To(g.BeNil())
+			if value != nil {
+				priorityClassNames[node.GetName()] = kyaml.GetValue(value)
+			}
+		}
+
+		g.Expect(priorityClassNames).To(g.Equal(map[string]string{
+			"critical-production": "incognia-critical",
+			"critical-staging":    "incognia-default",
+			"batch":               "incognia-low",
+			"explicit":            "incognia-high",
+		}))
+	})
+
+	ginkgo.It("fails on rules without priority class", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: PriorityClassInjector
+metadata:
+  name: priority-class-injector
+spec:
+  rules:
+    - environments:
+        - production
+`), strings.NewReader(resources), &out)).To(g.MatchError("spec.rules[0].priorityClassName is empty"))
+	})
+})
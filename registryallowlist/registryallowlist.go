@@ -0,0 +1,312 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	pluginName   = "registryallowlist"
+	registryRule = "registry"
+
+	skipAnnotation = "incognia.com/skip-registry-allowlist"
+
+	defaultRegistry = "docker.io"
+	localhost       = "localhost"
+
+	podKind     = "Pod"
+	cronJobKind = "CronJob"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Pod",
+		"ReplicaSet",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+
+	containerFields = []string{
+		"initContainers",
+		"containers",
+		"ephemeralContainers",
+	}
+)
+
+type RegistryAllowlist struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Registries []string `json:"registries,omitempty"`
+	Kinds      []string `json:"kinds,omitempty"`
+}
+
+func main() {
+	// KRM functions are run without arguments, reading their configuration
+	// from the functionConfig of the ResourceList of the standard input
+	if len(os.Args) < 2 {
+		if err := RunFunction(os.Stdin, os.Stdout); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var registryAllowlist RegistryAllowlist
+	if err := pluginconfig.Unmarshal(data, &registryAllowlist); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := validate(&registryAllowlist, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+// RunFunction runs the plugin as a KRM function, validating the items of a
+// ResourceList with the configuration of its functionConfig.
+func RunFunction(in io.Reader, out io.Writer) error {
+	rw := &kio.ByteReadWriter{
+		Reader:                in,
+		Writer:                out,
+		OmitReaderAnnotations: true,
+		KeepReaderAnnotations: true,
+	}
+
+	nodes, err := rw.Read()
+	if err != nil {
+		return err
+	}
+
+	if rw.FunctionConfig == nil {
+		return fmt.Errorf("functionConfig is missing")
+	}
+
+	data, err := rw.FunctionConfig.String()
+	if err != nil {
+		return err
+	}
+
+	var registryAllowlist RegistryAllowlist
+	if err := pluginconfig.Unmarshal([]byte(data), &registryAllowlist); err != nil {
+		return err
+	}
+
+	if err := validate(&registryAllowlist, nodes); err != nil {
+		return err
+	}
+
+	return rw.Write(nodes)
+}
+
+func validate(registryAllowlist *RegistryAllowlist, nodes []*kyaml.RNode) error {
+	spec := &registryAllowlist.Spec
+
+	if len(spec.Registries) == 0 {
+		return fmt.Errorf("spec.registries is empty")
+	}
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	var found []findings.Finding
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		podSpec, err := lookupPodSpec(node)
+		if err != nil {
+			return err
+		}
+		if podSpec == nil {
+			continue
+		}
+
+		images, err := readImages(podSpec)
+		if err != nil {
+			return err
+		}
+
+		for _, image := range images {
+			if isAllowed(spec.Registries, image) {
+				continue
+			}
+
+			found = append(found, findings.Finding{
+				Path:     findings.SourcePath(node.GetAnnotations()),
+				Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+				Rule:     registryRule,
+				Message:  image,
+			})
+		}
+	}
+
+	if err := findings.WriteReportFromEnv(pluginName, registryAllowlist.Name, found); err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("images from registries outside the allowlist:\n%s", findings.Lines(found))
+	}
+
+	return nil
+}
+
+func lookupPodSpec(node *kyaml.RNode) (*kyaml.RNode, error) {
+	switch node.GetKind() {
+	case podKind:
+		return node.Pipe(kyaml.Lookup("spec"))
+	case cronJobKind:
+		return node.Pipe(kyaml.Lookup(append(cronJobPodTemplatePath, "spec")...))
+	default:
+		return node.Pipe(kyaml.Lookup(append(podTemplatePath, "spec")...))
+	}
+}
+
+func readImages(podSpec *kyaml.RNode) ([]string, error) {
+	var images []string
+	for _, field := range containerFields {
+		containers, err := podSpec.Pipe(kyaml.Lookup(field))
+		if err != nil {
+			return nil, err
+		}
+		if containers == nil {
+			continue
+		}
+
+		elements, err := containers.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, container := range elements {
+			image, err := container.Pipe(kyaml.Lookup("image"))
+			if err != nil {
+				return nil, err
+			}
+			if image == nil {
+				continue
+			}
+
+			images = append(images, kyaml.GetValue(image))
+		}
+	}
+
+	return images, nil
+}
+
+// isAllowed matches the registry of an image against allowlist entries, which
+// are either registry host patterns (e.g. *.dkr.ecr.*.amazonaws.com) or
+// repository prefixes (e.g. ghcr.io/inloco).
+func isAllowed(registries []string, image string) bool {
+	qualified := qualifyImage(image)
+	host := strings.SplitN(qualified, "/", 2)[0]
+
+	for _, registry := range registries {
+		if strings.Contains(registry, "/") {
+			if strings.HasPrefix(qualified, strings.TrimSuffix(registry, "/")+"/") {
+				return true
+			}
+
+			continue
+		}
+
+		if matched, _ := path.Match(registry, host); matched {
+			return true
+		}
+	}
+
+	return false
+}
+
+// qualifyImage prepends the default registry to images that omit it, following
+// the same rules as the Docker CLI.
+func qualifyImage(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == localhost) {
+		return image
+	}
+
+	return defaultRegistry + "/" + image
+}
+
+func resourceName(node *kyaml.RNode) string {
+	if namespace := node.GetNamespace(); namespace != "" {
+		return namespace + "/" + node.GetName()
+	}
+
+	return node.GetName()
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,203 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/registryallowlist"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: RegistryAllowlist
+metadata:
+  name: registry-allowlist
+spec:
+  registries:
+    - "*.dkr.ecr.*.amazonaws.com"
+    - ghcr.io/inloco
+`
+
+	allowed = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: allowed
+  namespace: my-namespace
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+          image: ghcr.io/inloco/init:1.0.0
+      containers:
+        - name: app
+          image: 123456789012.dkr.ecr.us-east-1.amazonaws.com/my-app:1.0.0
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: not-a-workload
+data:
+  image: nginx
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: exempted
+  annotations:
+    incognia.com/skip-registry-allowlist: "true"
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+          image: nginx
+`
+)
+
+var _ = ginkgo.Describe("RegistryAllowlist", func() {
+	ginkgo.It("passes resources through when images are allowed", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(allowed), &out)).To(g.Succeed())
+		g.Expect(out.String()).To(g.ContainSubstring("name: not-a-workload"))
+		g.Expect(out.String()).To(g.ContainSubstring("name: exempted"))
+	})
+
+	ginkgo.It("lists images outside the allowlist", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(allowed+`
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+  namespace: my-namespace
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: report
+              image: ghcr.io/someone-else/report:1.0.0
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+    - name: debug
+      image: busybox
+`), &out)).To(g.MatchError(`images from registries outside the allowlist:
+CronJob my-namespace/report: ghcr.io/someone-else/report:1.0.0
+Pod debug: busybox`))
+	})
+
+	ginkgo.It("reports images outside the allowlist", func() {
+		dir, err := ioutil.TempDir("", "registryallowlist")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		g.Expect(os.Setenv("FINDINGS_DIR", dir)).To(g.Succeed())
+		ginkgo.DeferCleanup(os.Unsetenv, "FINDINGS_DIR")
+
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+    - name: debug
+      image: busybox
+`), &out)).To(g.HaveOccurred())
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, "registryallowlist-registry-allowlist.sarif"))
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(string(data)).To(g.ContainSubstring(`"ruleId": "registry"`))
+		g.Expect(string(data)).To(g.ContainSubstring(`"name": "Pod debug"`))
+	})
+
+	ginkgo.It("runs as a KRM function", func() {
+		var out bytes.Buffer
+		g.Expect(main.RunFunction(strings.NewReader(`
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  apiVersion: incognia.com/v1alpha1
+  kind: RegistryAllowlist
+  metadata:
+    name: registry-allowlist
+  spec:
+    registries:
+      - ghcr.io/inloco
+items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: allowed
+    spec:
+      containers:
+        - name: app
+          image: ghcr.io/inloco/app:1.0.0
+`), &out)).To(g.Succeed())
+
+		g.Expect(out.String()).To(g.HavePrefix("apiVersion: config.kubernetes.io/v1\nkind: ResourceList\nitems:\n"))
+		g.Expect(out.String()).To(g.ContainSubstring("image: ghcr.io/inloco/app:1.0.0"))
+	})
+
+	ginkgo.It("fails on images outside the allowlist as a KRM function", func() {
+		var out bytes.Buffer
+		g.Expect(main.RunFunction(strings.NewReader(`
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+functionConfig:
+  apiVersion: incognia.com/v1alpha1
+  kind: RegistryAllowlist
+  metadata:
+    name: registry-allowlist
+  spec:
+    registries:
+      - ghcr.io/inloco
+items:
+  - apiVersion: v1
+    kind: Pod
+    metadata:
+      name: debug
+    spec:
+      containers:
+        - name: debug
+          image: busybox
+`), &out)).To(g.MatchError(`images from registries outside the allowlist:
+Pod debug: busybox`))
+	})
+
+	ginkgo.It("fails without functionConfig as a KRM function", func() {
+		var out bytes.Buffer
+		g.Expect(main.RunFunction(strings.NewReader(`
+apiVersion: config.kubernetes.io/v1
+kind: ResourceList
+items: []
+`), &out)).To(g.MatchError("functionConfig is missing"))
+	})
+
+	ginkgo.It("fails without registries", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: RegistryAllowlist
+metadata:
+  name: registry-allowlist
+`), strings.NewReader(allowed), &out)).To(g.MatchError("spec.registries is empty"))
+	})
+})
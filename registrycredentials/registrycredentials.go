@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	behaviorAnnotation = "kustomize.config.k8s.io/behavior"
+	behaviorMerge      = "merge"
+)
+
+var (
+	ecrServerRegexp = regexp.MustCompile(`^(?:https://)?[0-9]+\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com`)
+)
+
+type RegistryCredentials struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Registries      []Registry `json:"registries,omitempty"`
+	ServiceAccounts []string   `json:"serviceAccounts,omitempty"`
+}
+
+type Registry struct {
+	Server      string `json:"server,omitempty"`
+	UsernameEnv string `json:"usernameEnv,omitempty"`
+	PasswordEnv string `json:"passwordEnv,omitempty"`
+	ECR         *ECR   `json:"ecr,omitempty"`
+}
+
+type ECR struct {
+	Region string `json:"region,omitempty"`
+}
+
+type ECRClient interface {
+	GetAuthorizationToken(ctx context.Context, params *ecr.GetAuthorizationTokenInput, optFns ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error)
+}
+
+type ECRClientFactory func(region string) (ECRClient, error)
+
+type dockerConfigJSON struct {
+	Auths map[string]dockerConfigEntry `json:"auths"`
+}
+
+type dockerConfigEntry struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Auth     string `json:"auth"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	return GenerateManifestsWithECRClientFactory(newECRClient, data, out)
+}
+
+func GenerateManifestsWithECRClientFactory(newECRClient ECRClientFactory, data []byte, out io.Writer) error {
+	var registryCredentials RegistryCredentials
+	if err := pluginconfig.Unmarshal(data, &registryCredentials); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(newECRClient, &registryCredentials)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newECRClient(region string) (ECRClient, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return ecr.NewFromConfig(cfg), nil
+}
+
+func makeManifests(newECRClient ECRClientFactory, registryCredentials *RegistryCredentials) ([][]byte, error) {
+	var manifests [][]byte
+
+	secret, err := makeSecret(newECRClient, registryCredentials)
+	if err != nil {
+		return nil, err
+	}
+	manifests = append(manifests, secret)
+
+	for _, name := range registryCredentials.Spec.ServiceAccounts {
+		serviceAccount, err := makeServiceAccount(registryCredentials, name)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, serviceAccount)
+	}
+
+	return manifests, nil
+}
+
+func makeSecret(newECRClient ECRClientFactory, registryCredentials *RegistryCredentials) ([]byte, error) {
+	dockerConfig := dockerConfigJSON{
+		Auths: make(map[string]dockerConfigEntry),
+	}
+
+	for _, registry := range registryCredentials.Spec.Registries {
+		if registry.Server == "" {
+			return nil, fmt.Errorf("registry without server")
+		}
+
+		if _, exists := dockerConfig.Auths[registry.Server]; exists {
+			return nil, fmt.Errorf("registry %s is duplicated", registry.Server)
+		}
+
+		username, password, err := resolveCredentials(newECRClient, &registry)
+		if err != nil {
+			return nil, err
+		}
+
+		dockerConfig.Auths[registry.Server] = dockerConfigEntry{
+			Username: username,
+			Password: password,
+			Auth:     base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))),
+		}
+	}
+
+	b, err := json.Marshal(dockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Secret{}).Name(),
+		},
+		ObjectMeta: registryCredentials.ObjectMeta,
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: b,
+		},
+	}
+
+	return yaml.Marshal(secret)
+}
+
+func resolveCredentials(newECRClient ECRClientFactory, registry *Registry) (string, string, error) {
+	if registry.ECR != nil {
+		return resolveECRCredentials(newECRClient, registry)
+	}
+
+	username, exists := os.LookupEnv(registry.UsernameEnv)
+	if !exists {
+		return "", "", fmt.Errorf("registry %s: %s is empty", registry.Server, registry.UsernameEnv)
+	}
+
+	password, exists := os.LookupEnv(registry.PasswordEnv)
+	if !exists {
+		return "", "", fmt.Errorf("registry %s: %s is empty", registry.Server, registry.PasswordEnv)
+	}
+
+	return username, password, nil
+}
+
+func resolveECRCredentials(newECRClient ECRClientFactory, registry *Registry) (string, string, error) {
+	region := registry.ECR.Region
+	if region == "" {
+		matches := ecrServerRegexp.FindStringSubmatch(registry.Server)
+		if matches == nil {
+			return "", "", fmt.Errorf("registry %s: unable to infer ECR region", registry.Server)
+		}
+		region = matches[1]
+	}
+
+	client, err := newECRClient(region)
+	if err != nil {
+		return "", "", err
+	}
+
+	output, err := client.GetAuthorizationToken(context.Background(), &ecr.GetAuthorizationTokenInput{})
+	if err != nil {
+		return "", "", err
+	}
+
+	if len(output.AuthorizationData) == 0 {
+		return "", "", fmt.Errorf("registry %s: ECR returned no authorization data", registry.Server)
+	}
+
+	token, err := base64.StdEncoding.DecodeString(aws.ToString(output.AuthorizationData[0].AuthorizationToken))
+	if err != nil {
+		return "", "", err
+	}
+
+	parts := strings.SplitN(string(token), ":", 2)
+	if len(parts) != 2 {
+		return "", "", fmt.Errorf("registry %s: malformed ECR authorization token", registry.Server)
+	}
+
+	return parts[0], parts[1], nil
+}
+
+func makeServiceAccount(registryCredentials *RegistryCredentials, name string) ([]byte, error) {
+	serviceAccount := corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ServiceAccount{}).Name(),
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: registryCredentials.Namespace,
+			Annotations: map[string]string{
+				behaviorAnnotation: behaviorMerge,
+			},
+		},
+		ImagePullSecrets: []corev1.LocalObjectReference{
+			corev1.LocalObjectReference{
+				Name: registryCredentials.Name,
+			},
+		},
+	}
+
+	return yaml.Marshal(serviceAccount)
+}
@@ -0,0 +1,139 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"reflect"
+	"regexp"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/registrycredentials"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+
+	secretGVK         = corev1.SchemeGroupVersion.WithKind(reflect.TypeOf(corev1.Secret{}).Name())
+	serviceAccountGVK = corev1.SchemeGroupVersion.WithKind(reflect.TypeOf(corev1.ServiceAccount{}).Name())
+)
+
+type fakeECRClient string
+
+func (c fakeECRClient) GetAuthorizationToken(context.Context, *ecr.GetAuthorizationTokenInput, ...func(*ecr.Options)) (*ecr.GetAuthorizationTokenOutput, error) {
+	return &ecr.GetAuthorizationTokenOutput{
+		AuthorizationData: []ecrtypes.AuthorizationData{
+			{
+				AuthorizationToken: aws.String(base64.StdEncoding.EncodeToString([]byte("AWS:token-" + string(c)))),
+			},
+		},
+	}, nil
+}
+
+func newFakeECRClient(region string) (main.ECRClient, error) {
+	return fakeECRClient(region), nil
+}
+
+var _ = ginkgo.Describe("RegistryCredentials", func() {
+	g.Expect(os.Setenv("GHCR_USERNAME", "bot")).To(g.Succeed())
+	g.Expect(os.Setenv("GHCR_PASSWORD", "secret")).To(g.Succeed())
+
+	registryCredentials := main.RegistryCredentials{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   "incognia.com",
+				Version: "v1alpha1",
+			}.String(),
+			Kind: "RegistryCredentials",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "registry-credentials",
+			Namespace: "my-namespace",
+		},
+		Spec: main.Spec{
+			Registries: []main.Registry{
+				{
+					Server:      "ghcr.io",
+					UsernameEnv: "GHCR_USERNAME",
+					PasswordEnv: "GHCR_PASSWORD",
+				},
+				{
+					Server: "123456789876.dkr.ecr.us-east-1.amazonaws.com",
+					ECR:    &main.ECR{},
+				},
+			},
+			ServiceAccounts: []string{
+				"default",
+			},
+		},
+	}
+
+	var out bytes.Buffer
+	if data, err := yaml.Marshal(registryCredentials); g.Expect(err).To(g.BeNil()) {
+		g.Expect(main.GenerateManifestsWithECRClientFactory(newFakeECRClient, data, &out)).To(g.Succeed())
+	}
+
+	var secret corev1.Secret
+	var serviceAccounts []corev1.ServiceAccount
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var meta metav1.TypeMeta
+		g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+
+		switch meta.GroupVersionKind() {
+		case secretGVK:
+			g.Expect(yaml.Unmarshal([]byte(manifest), &secret)).To(g.Succeed())
+		case serviceAccountGVK:
+			var serviceAccount corev1.ServiceAccount
+			g.Expect(yaml.Unmarshal([]byte(manifest), &serviceAccount)).To(g.Succeed())
+			serviceAccounts = append(serviceAccounts, serviceAccount)
+		default:
+			ginkgo.Fail("unexpected GVK")
+		}
+	}
+
+	ginkgo.It("contains expected Secret", func() {
+		g.Expect(secret.Name).To(g.Equal("registry-credentials"))
+		g.Expect(secret.Namespace).To(g.Equal("my-namespace"))
+		g.Expect(secret.Type).To(g.Equal(corev1.SecretTypeDockerConfigJson))
+
+		var dockerConfig map[string]map[string]map[string]string
+		g.Expect(json.Unmarshal(secret.Data[corev1.DockerConfigJsonKey], &dockerConfig)).To(g.Succeed())
+		g.Expect(dockerConfig).To(g.Equal(map[string]map[string]map[string]string{
+			"auths": {
+				"ghcr.io": {
+					"username": "bot",
+					"password": "secret",
+					"auth":     base64.StdEncoding.EncodeToString([]byte("bot:secret")),
+				},
+				"123456789876.dkr.ecr.us-east-1.amazonaws.com": {
+					"username": "AWS",
+					"password": "token-us-east-1",
+					"auth":     base64.StdEncoding.EncodeToString([]byte("AWS:token-us-east-1")),
+				},
+			},
+		}))
+	})
+
+	ginkgo.It("contains expected ServiceAccounts", func() {
+		g.Expect(serviceAccounts).To(g.HaveLen(1))
+		g.Expect(serviceAccounts[0].Name).To(g.Equal("default"))
+		g.Expect(serviceAccounts[0].Namespace).To(g.Equal("my-namespace"))
+		g.Expect(serviceAccounts[0].Annotations).To(g.HaveKeyWithValue("kustomize.config.k8s.io/behavior", "merge"))
+		g.Expect(serviceAccounts[0].ImagePullSecrets).To(g.Equal([]corev1.LocalObjectReference{
+			{
+				Name: "registry-credentials",
+			},
+		}))
+	})
+})
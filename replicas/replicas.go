@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+	replicasField           = "replicas"
+)
+
+var (
+	defaultKinds = []string{
+		"Deployment",
+		"Rollout",
+		"StatefulSet",
+	}
+)
+
+type Replicas struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds            []string         `json:"kinds,omitempty"`
+	Environment      string           `json:"environment,omitempty"`
+	EnvironmentLabel string           `json:"environmentLabel,omitempty"`
+	Environments     map[string]int32 `json:"environments,omitempty"`
+	Overrides        []Override       `json:"overrides,omitempty"`
+}
+
+type Override struct {
+	Kind         string           `json:"kind,omitempty"`
+	Name         string           `json:"name,omitempty"`
+	Environments map[string]int32 `json:"environments,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var replicas Replicas
+	if err := pluginconfig.Unmarshal(data, &replicas); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&replicas, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(replicas *Replicas, nodes []*kyaml.RNode) error {
+	spec := &replicas.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	if err := validate(spec); err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		environment := spec.Environment
+		if environment == "" {
+			environment = node.GetLabels()[environmentLabel]
+		}
+
+		count, exists := lookupReplicas(spec, node, environment)
+		if !exists {
+			continue
+		}
+
+		if err := node.PipeE(
+			kyaml.LookupCreate(kyaml.MappingNode, "spec"),
+			kyaml.SetField(replicasField, kyaml.NewScalarRNode(strconv.Itoa(int(count)))),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func validate(spec *Spec) error {
+	for environment, count := range spec.Environments {
+		if count < 0 {
+			return fmt.Errorf("environment %s has negative replicas", environment)
+		}
+	}
+
+	for i, override := range spec.Overrides {
+		if override.Name == "" {
+			return fmt.Errorf("spec.overrides[%d].name is empty", i)
+		}
+
+		for environment, count := range override.Environments {
+			if count < 0 {
+				return fmt.Errorf("override %s: environment %s has negative replicas", override.Name, environment)
+			}
+		}
+	}
+
+	return nil
+}
+
+func lookupReplicas(spec *Spec, node *kyaml.RNode, environment string) (int32, bool) {
+	for _, override := range spec.Overrides {
+		if override.Name != node.GetName() {
+			continue
+		}
+
+		if override.Kind != "" && override.Kind != node.GetKind() {
+			continue
+		}
+
+		if count, exists := override.Environments[environment]; exists {
+			return count, true
+		}
+	}
+
+	count, exists := spec.Environments[environment]
+	return count, exists
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/replicas"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    incognia.com/environment: production
+spec:
+  replicas: 1
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: my-rollout
+  labels:
+    incognia.com/environment: staging
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: my-database
+  labels:
+    incognia.com/environment: production
+spec:
+  replicas: 3
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unknown-environment
+spec:
+  replicas: 2
+`
+)
+
+var _ = ginkgo.Describe("Replicas", func() {
+	ginkgo.DescribeTable("", func(config string, expected map[string]string) {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).To(g.BeNil())
+
+		actual := make(map[string]string)
+		for _, node := range nodes {
+			value, err := node.Pipe(kyaml.Lookup("spec", "replicas"))
+			g.Expect(err).To(g.BeNil())
+			actual[node.GetName()] = kyaml.GetValue(value)
+		}
+
+		g.Expect(actual).To(g.Equal(expected))
+	},
+		ginkgo.Entry("by workload environment", `
+apiVersion: incognia.com/v1alpha1
+kind: Replicas
+metadata:
+  name: replicas
+spec:
+  environments:
+    production: 3
+    staging: 1
+  overrides:
+    - kind: StatefulSet
+      name: my-database
+      environments:
+        production: 5
+`, map[string]string{
+			"my-app":              "3",
+			"my-rollout":          "1",
+			"my-database":         "5",
+			"unknown-environment": "2",
+		}),
+		ginkgo.Entry("by configured environment", `
+apiVersion: incognia.com/v1alpha1
+kind: Replicas
+metadata:
+  name: replicas
+spec:
+  environment: staging
+  environments:
+    staging: 1
+`, map[string]string{
+			"my-app":              "1",
+			"my-rollout":          "1",
+			"my-database":         "1",
+			"unknown-environment": "1",
+		}),
+	)
+
+	ginkgo.It("fails with negative replicas", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Replicas
+metadata:
+  name: replicas
+spec:
+  environments:
+    production: -1
+`), strings.NewReader(resources), &out)).To(g.MatchError("environment production has negative replicas"))
+	})
+})
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	configMapName              = "argocd-cm"
+	healthKeyPrefix            = "resource.customizations.health."
+	actionsKeyPrefix           = "resource.customizations.actions."
+	ignoreDifferencesKeyPrefix = "resource.customizations.ignoreDifferences."
+	groupKindSeparator         = "_"
+)
+
+type ResourceCustomizations struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Resources []Resource `json:"resources,omitempty"`
+}
+
+type Resource struct {
+	Group             string             `json:"group,omitempty"`
+	Kind              string             `json:"kind,omitempty"`
+	Health            string             `json:"health,omitempty"`
+	Actions           *Actions           `json:"actions,omitempty"`
+	IgnoreDifferences *IgnoreDifferences `json:"ignoreDifferences,omitempty"`
+}
+
+type Actions struct {
+	Discovery   string   `json:"discovery,omitempty"`
+	Definitions []Action `json:"definitions,omitempty"`
+}
+
+type Action struct {
+	Name   string `json:"name,omitempty"`
+	Script string `json:"script,omitempty"`
+}
+
+type IgnoreDifferences struct {
+	JSONPointers          []string `json:"jsonPointers,omitempty"`
+	JQPathExpressions     []string `json:"jqPathExpressions,omitempty"`
+	ManagedFieldsManagers []string `json:"managedFieldsManagers,omitempty"`
+}
+
+// The types below mirror the resource actions settings of Argo CD, whose
+// scripts are inlined in argocd-cm.
+
+type resourceActions struct {
+	DiscoveryLua string             `json:"discovery.lua"`
+	Definitions  []actionDefinition `json:"definitions"`
+}
+
+type actionDefinition struct {
+	Name      string `json:"name"`
+	ActionLua string `json:"action.lua"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var resourceCustomizations ResourceCustomizations
+	if err := pluginconfig.Unmarshal(data, &resourceCustomizations); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&resourceCustomizations)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(resourceCustomizations *ResourceCustomizations) ([][]byte, error) {
+	spec := &resourceCustomizations.Spec
+
+	if len(spec.Resources) == 0 {
+		return nil, fmt.Errorf("spec.resources is empty")
+	}
+
+	data := make(map[string]string)
+	seen := make(map[string]bool)
+	for i, resource := range spec.Resources {
+		groupKind, err := makeGroupKind(&resource)
+		if err != nil {
+			return nil, fmt.Errorf("spec.resources[%d]: %w", i, err)
+		}
+
+		if seen[groupKind] {
+			return nil, fmt.Errorf("spec.resources[%d]: %s is customized more than once", i, groupKind)
+		}
+		seen[groupKind] = true
+
+		if resource.Health == "" && resource.Actions == nil && resource.IgnoreDifferences == nil {
+			return nil, fmt.Errorf("spec.resources[%d]: %s has no customizations", i, groupKind)
+		}
+
+		if resource.Health != "" {
+			script, err := readScript(resource.Health)
+			if err != nil {
+				return nil, fmt.Errorf("spec.resources[%d]: %w", i, err)
+			}
+			data[healthKeyPrefix+groupKind] = script
+		}
+
+		if resource.Actions != nil {
+			actions, err := makeActions(resource.Actions)
+			if err != nil {
+				return nil, fmt.Errorf("spec.resources[%d]: %w", i, err)
+			}
+
+			b, err := yaml.Marshal(actions)
+			if err != nil {
+				return nil, err
+			}
+			data[actionsKeyPrefix+groupKind] = string(b)
+		}
+
+		if ignoreDifferences := resource.IgnoreDifferences; ignoreDifferences != nil {
+			if len(ignoreDifferences.JSONPointers) == 0 && len(ignoreDifferences.JQPathExpressions) == 0 && len(ignoreDifferences.ManagedFieldsManagers) == 0 {
+				return nil, fmt.Errorf("spec.resources[%d]: ignoreDifferences of %s is empty", i, groupKind)
+			}
+
+			b, err := yaml.Marshal(ignoreDifferences)
+			if err != nil {
+				return nil, err
+			}
+			data[ignoreDifferencesKeyPrefix+groupKind] = string(b)
+		}
+	}
+
+	objectMeta := *resourceCustomizations.ObjectMeta.DeepCopy()
+	objectMeta.Name = configMapName
+
+	b, err := yaml.Marshal(corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ConfigMap{}).Name(),
+		},
+		ObjectMeta: objectMeta,
+		Data:       data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{b}, nil
+}
+
+// Argo CD keys customizations by group and kind joined by an underscore, and
+// by the kind alone for resources of the core group.
+func makeGroupKind(resource *Resource) (string, error) {
+	if resource.Kind == "" {
+		return "", fmt.Errorf("kind is empty")
+	}
+
+	if strings.Contains(resource.Group, groupKindSeparator) || strings.Contains(resource.Kind, groupKindSeparator) {
+		return "", fmt.Errorf("group and kind must not contain %q", groupKindSeparator)
+	}
+
+	if resource.Group == "" {
+		return resource.Kind, nil
+	}
+
+	return resource.Group + groupKindSeparator + resource.Kind, nil
+}
+
+func makeActions(actions *Actions) (*resourceActions, error) {
+	if actions.Discovery == "" {
+		return nil, fmt.Errorf("actions have no discovery script")
+	}
+
+	if len(actions.Definitions) == 0 {
+		return nil, fmt.Errorf("actions have no definitions")
+	}
+
+	discovery, err := readScript(actions.Discovery)
+	if err != nil {
+		return nil, err
+	}
+
+	definitions := make([]actionDefinition, 0, len(actions.Definitions))
+	for i, action := range actions.Definitions {
+		if action.Name == "" {
+			return nil, fmt.Errorf("actions.definitions[%d] has no name", i)
+		}
+
+		if action.Script == "" {
+			return nil, fmt.Errorf("action %s has no script", action.Name)
+		}
+
+		script, err := readScript(action.Script)
+		if err != nil {
+			return nil, err
+		}
+
+		definitions = append(definitions, actionDefinition{
+			Name:      action.Name,
+			ActionLua: script,
+		})
+	}
+
+	return &resourceActions{
+		DiscoveryLua: discovery,
+		Definitions:  definitions,
+	}, nil
+}
+
+func readScript(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	if strings.TrimSpace(string(data)) == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+
+	return string(data), nil
+}
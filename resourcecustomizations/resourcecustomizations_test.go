@@ -0,0 +1,127 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/resourcecustomizations"
+)
+
+const (
+	health = `hs = {}
+hs.status = "Healthy"
+return hs
+`
+
+	discovery = `actions = {}
+actions["restart"] = {}
+return actions
+`
+
+	restart = `obj.metadata.annotations["kubectl.kubernetes.io/restartedAt"] = os.date("!%Y-%m-%dT%XZ")
+return obj
+`
+)
+
+var _ = ginkgo.Describe("ResourceCustomizations", func() {
+	var dir string
+	ginkgo.BeforeEach(func() {
+		d, err := ioutil.TempDir("", "resourcecustomizations")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, d)
+		dir = d
+
+		for name, script := range map[string]string{
+			"health.lua":    health,
+			"discovery.lua": discovery,
+			"restart.lua":   restart,
+			"empty.lua":     "\n",
+		} {
+			g.Expect(ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0644)).To(g.Succeed())
+		}
+	})
+
+	generate := func(spec string) (*corev1.ConfigMap, error) {
+		var out bytes.Buffer
+		if err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ResourceCustomizations
+metadata:
+  name: resource-customizations
+  namespace: argocd
+  annotations:
+    kustomize.config.k8s.io/behavior: merge
+spec:
+`+spec), &out); err != nil {
+			return nil, err
+		}
+
+		var configMap corev1.ConfigMap
+		g.Expect(yaml.Unmarshal(out.Bytes(), &configMap)).To(g.Succeed())
+
+		return &configMap, nil
+	}
+
+	ginkgo.It("generates argocd-cm", func() {
+		configMap, err := generate(`
+  resources:
+    - group: cert-manager.io
+      kind: Certificate
+      health: ` + filepath.Join(dir, "health.lua") + `
+    - group: argoproj.io
+      kind: Rollout
+      actions:
+        discovery: ` + filepath.Join(dir, "discovery.lua") + `
+        definitions:
+          - name: restart
+            script: ` + filepath.Join(dir, "restart.lua") + `
+    - kind: Service
+      ignoreDifferences:
+        jsonPointers: [/spec/clusterIP]
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		g.Expect(configMap.Name).To(g.Equal("argocd-cm"))
+		g.Expect(configMap.Namespace).To(g.Equal("argocd"))
+		g.Expect(configMap.Annotations).To(g.HaveKeyWithValue("kustomize.config.k8s.io/behavior", "merge"))
+		g.Expect(configMap.Data).To(g.Equal(map[string]string{
+			"resource.customizations.health.cert-manager.io_Certificate": health,
+			"resource.customizations.actions.argoproj.io_Rollout": `definitions:
+- action.lua: |
+    obj.metadata.annotations["kubectl.kubernetes.io/restartedAt"] = os.date("!%Y-%m-%dT%XZ")
+    return obj
+  name: restart
+discovery.lua: |
+  actions = {}
+  actions["restart"] = {}
+  return actions
+`,
+			"resource.customizations.ignoreDifferences.Service": "jsonPointers:\n- /spec/clusterIP\n",
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := generate(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without resources", "", "spec.resources is empty"),
+		ginkgo.Entry("without kind", "  resources:\n    - group: apps\n", "spec.resources[0]: kind is empty"),
+		ginkgo.Entry("without customizations", "  resources:\n    - group: apps\n      kind: Deployment\n", "spec.resources[0]: apps_Deployment has no customizations"),
+		ginkgo.Entry("with repeated resources", "  resources:\n    - kind: Service\n      ignoreDifferences:\n        jsonPointers: [/spec/clusterIP]\n    - kind: Service\n      ignoreDifferences:\n        jsonPointers: [/spec/clusterIPs]\n", "spec.resources[1]: Service is customized more than once"),
+		ginkgo.Entry("with empty ignoreDifferences", "  resources:\n    - kind: Service\n      ignoreDifferences: {}\n", "spec.resources[0]: ignoreDifferences of Service is empty"),
+		ginkgo.Entry("with actions without discovery", "  resources:\n    - kind: Pod\n      actions:\n        definitions:\n          - name: restart\n", "spec.resources[0]: actions have no discovery script"),
+	)
+
+	ginkgo.It("fails on empty scripts", func() {
+		script := filepath.Join(dir, "empty.lua")
+		_, err := generate("  resources:\n    - kind: Pod\n      health: " + script + "\n")
+		g.Expect(err).To(g.MatchError("spec.resources[0]: " + script + " is empty"))
+	})
+})
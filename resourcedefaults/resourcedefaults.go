@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultEnvironmentLabel = "incognia.com/environment"
+	skipAnnotation          = "incognia.com/skip-resource-defaults"
+	cronJobKind             = "CronJob"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+
+	containerFields = []string{
+		"initContainers",
+		"containers",
+	}
+)
+
+type ResourceDefaults struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds            []string                               `json:"kinds,omitempty"`
+	Environment      string                                 `json:"environment,omitempty"`
+	EnvironmentLabel string                                 `json:"environmentLabel,omitempty"`
+	Default          *corev1.ResourceRequirements           `json:"default,omitempty"`
+	Environments     map[string]corev1.ResourceRequirements `json:"environments,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var resourceDefaults ResourceDefaults
+	if err := pluginconfig.Unmarshal(data, &resourceDefaults); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&resourceDefaults, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(resourceDefaults *ResourceDefaults, nodes []*kyaml.RNode) error {
+	spec := &resourceDefaults.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	environmentLabel := spec.EnvironmentLabel
+	if environmentLabel == "" {
+		environmentLabel = defaultEnvironmentLabel
+	}
+
+	if spec.Environment != "" {
+		if _, exists := spec.Environments[spec.Environment]; !exists && spec.Default == nil {
+			return fmt.Errorf("environment %s has no profile", spec.Environment)
+		}
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" || template.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		environment := spec.Environment
+		if environment == "" {
+			environment = node.GetLabels()[environmentLabel]
+		}
+
+		profile, exists := spec.Environments[environment]
+		if !exists {
+			if spec.Default == nil {
+				continue
+			}
+			profile = *spec.Default
+		}
+
+		for _, field := range containerFields {
+			containers, err := template.Pipe(kyaml.Lookup("spec", field))
+			if err != nil {
+				return err
+			}
+			if containers == nil {
+				continue
+			}
+
+			elements, err := containers.Elements()
+			if err != nil {
+				return err
+			}
+
+			for _, container := range elements {
+				if err := setDefaults(container, "requests", profile.Requests); err != nil {
+					return err
+				}
+
+				if err := setDefaults(container, "limits", profile.Limits); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+func setDefaults(container *kyaml.RNode, field string, defaults corev1.ResourceList) error {
+	names := make([]string, 0, len(defaults))
+	for name := range defaults {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		current, err := container.Pipe(kyaml.Lookup("resources", field, name))
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			continue
+		}
+
+		quantity := defaults[corev1.ResourceName(name)]
+		if err := container.PipeE(
+			kyaml.LookupCreate(kyaml.MappingNode, "resources", field),
+			kyaml.SetField(name, kyaml.NewStringRNode(quantity.String())),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
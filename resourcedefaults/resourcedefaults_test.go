@@ -0,0 +1,146 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/resourcedefaults"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: ResourceDefaults
+metadata:
+  name: resource-defaults
+spec:
+  default:
+    requests:
+      cpu: 100m
+      memory: 128Mi
+    limits:
+      memory: 256Mi
+  environments:
+    production:
+      requests:
+        cpu: 500m
+        memory: 512Mi
+      limits:
+        memory: 1Gi
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: production
+  labels:
+    incognia.com/environment: production
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: init
+      containers:
+        - name: app
+          resources:
+            requests:
+              cpu: "2"
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: staging
+  labels:
+    incognia.com/environment: staging
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: skipped
+  annotations:
+    incognia.com/skip-resource-defaults: "true"
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("ResourceDefaults", func() {
+	ginkgo.It("defaults missing requests and limits by environment", func() {
+		deployments := transform(config)
+
+		production := deployments["production"].Spec.Template.Spec
+		g.Expect(production.InitContainers[0].Resources).To(g.Equal(corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("500m"),
+				corev1.ResourceMemory: resource.MustParse("512Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		}))
+		g.Expect(production.Containers[0].Resources).To(g.Equal(corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("2"),
+				corev1.ResourceMemory: resource.MustParse("512Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("1Gi"),
+			},
+		}))
+
+		g.Expect(deployments["staging"].Spec.Template.Spec.Containers[0].Resources).To(g.Equal(corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("100m"),
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("256Mi"),
+			},
+		}))
+
+		g.Expect(deployments["skipped"].Spec.Template.Spec.Containers[0].Resources).To(g.BeZero())
+	})
+
+	ginkgo.It("uses the configured environment over workload labels", func() {
+		deployments := transform(config + `
+  environment: production
+`)
+
+		g.Expect(deployments["staging"].Spec.Template.Spec.Containers[0].Resources.Requests).To(g.HaveKeyWithValue(corev1.ResourceCPU, resource.MustParse("500m")))
+	})
+})
+
+func transform(config string) map[string]appsv1.Deployment {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	deployments := make(map[string]appsv1.Deployment)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+		deployments[deployment.Name] = deployment
+	}
+
+	return deployments
+}
@@ -0,0 +1,499 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	serviceAnnotation = "incognia.com/rollout-service"
+
+	deploymentKind              = "Deployment"
+	horizontalPodAutoscalerKind = "HorizontalPodAutoscaler"
+	serviceKind                 = "Service"
+
+	rolloutAPIVersion = "argoproj.io/v1alpha1"
+	rolloutKind       = "Rollout"
+
+	canaryServiceSuffix  = "-canary"
+	previewServiceSuffix = "-preview"
+)
+
+type RolloutConverter struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Names     []string              `json:"names,omitempty"`
+	Selector  *metav1.LabelSelector `json:"selector,omitempty"`
+	Canary    *Canary               `json:"canary,omitempty"`
+	BlueGreen *BlueGreen            `json:"blueGreen,omitempty"`
+}
+
+type Canary struct {
+	Steps          []CanaryStep        `json:"steps,omitempty"`
+	Analysis       *Analysis           `json:"analysis,omitempty"`
+	MaxSurge       *intstr.IntOrString `json:"maxSurge,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+type CanaryStep struct {
+	SetWeight *int32    `json:"setWeight,omitempty"`
+	Pause     *Pause    `json:"pause,omitempty"`
+	Analysis  *Analysis `json:"analysis,omitempty"`
+}
+
+type Pause struct {
+	Duration string `json:"duration,omitempty"`
+}
+
+type BlueGreen struct {
+	AutoPromotionEnabled  *bool     `json:"autoPromotionEnabled,omitempty"`
+	ScaleDownDelaySeconds *int32    `json:"scaleDownDelaySeconds,omitempty"`
+	PrePromotionAnalysis  *Analysis `json:"prePromotionAnalysis,omitempty"`
+	PostPromotionAnalysis *Analysis `json:"postPromotionAnalysis,omitempty"`
+}
+
+type Analysis struct {
+	Templates []string      `json:"templates,omitempty"`
+	Args      []AnalysisArg `json:"args,omitempty"`
+}
+
+type AnalysisArg struct {
+	Name  string `json:"name,omitempty"`
+	Value string `json:"value,omitempty"`
+}
+
+// The types below mirror the subset of the Argo Rollouts API written by this
+// plugin, which avoids depending on the whole Argo Rollouts module.
+
+type rolloutStrategy struct {
+	Canary    *canaryStrategy    `json:"canary,omitempty"`
+	BlueGreen *blueGreenStrategy `json:"blueGreen,omitempty"`
+}
+
+type canaryStrategy struct {
+	StableService  string              `json:"stableService,omitempty"`
+	CanaryService  string              `json:"canaryService,omitempty"`
+	Steps          []canaryStep        `json:"steps,omitempty"`
+	Analysis       *rolloutAnalysis    `json:"analysis,omitempty"`
+	MaxSurge       *intstr.IntOrString `json:"maxSurge,omitempty"`
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+type canaryStep struct {
+	SetWeight *int32           `json:"setWeight,omitempty"`
+	Pause     *Pause           `json:"pause,omitempty"`
+	Analysis  *rolloutAnalysis `json:"analysis,omitempty"`
+}
+
+type blueGreenStrategy struct {
+	ActiveService         string           `json:"activeService"`
+	PreviewService        string           `json:"previewService,omitempty"`
+	AutoPromotionEnabled  *bool            `json:"autoPromotionEnabled,omitempty"`
+	ScaleDownDelaySeconds *int32           `json:"scaleDownDelaySeconds,omitempty"`
+	PrePromotionAnalysis  *rolloutAnalysis `json:"prePromotionAnalysis,omitempty"`
+	PostPromotionAnalysis *rolloutAnalysis `json:"postPromotionAnalysis,omitempty"`
+}
+
+type rolloutAnalysis struct {
+	Templates []analysisTemplate `json:"templates,omitempty"`
+	Args      []AnalysisArg      `json:"args,omitempty"`
+}
+
+type analysisTemplate struct {
+	TemplateName string `json:"templateName"`
+}
+
+type workloadKey struct {
+	namespace string
+	name      string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var rolloutConverter RolloutConverter
+	if err := pluginconfig.Unmarshal(data, &rolloutConverter); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	nodes, err = transform(&rolloutConverter, nodes)
+	if err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(rolloutConverter *RolloutConverter, nodes []*kyaml.RNode) ([]*kyaml.RNode, error) {
+	spec := &rolloutConverter.Spec
+
+	if spec.Canary == nil && spec.BlueGreen == nil {
+		return nil, fmt.Errorf("spec.canary and spec.blueGreen are empty")
+	}
+	if spec.Canary != nil && spec.BlueGreen != nil {
+		return nil, fmt.Errorf("spec.canary and spec.blueGreen are mutually exclusive")
+	}
+
+	if len(spec.Names) == 0 && spec.Selector == nil {
+		return nil, fmt.Errorf("spec.names and spec.selector are empty")
+	}
+
+	selector := labels.Nothing()
+	if spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return nil, fmt.Errorf("spec.selector: %w", err)
+		}
+		selector = s
+	}
+
+	converted := make(map[workloadKey]bool)
+	var services []*kyaml.RNode
+	for _, node := range nodes {
+		if node.GetKind() != deploymentKind {
+			continue
+		}
+
+		if !containsString(spec.Names, node.GetName()) && !selector.Matches(labels.Set(node.GetLabels())) {
+			continue
+		}
+
+		service, err := findService(node, nodes)
+		if err != nil {
+			return nil, err
+		}
+
+		strategy, extraService, err := makeStrategy(spec, node, service)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := convert(node, strategy); err != nil {
+			return nil, err
+		}
+
+		if extraService != nil {
+			services = append(services, extraService)
+		}
+
+		converted[workloadKey{
+			namespace: node.GetNamespace(),
+			name:      node.GetName(),
+		}] = true
+	}
+
+	for _, node := range nodes {
+		if node.GetKind() != horizontalPodAutoscalerKind {
+			continue
+		}
+
+		if err := retarget(node, converted); err != nil {
+			return nil, err
+		}
+	}
+
+	return append(nodes, services...), nil
+}
+
+// findService looks up the Service, in the same namespace, selecting the pods
+// of the Deployment. The annotation on the Deployment takes precedence, which
+// disambiguates workloads exposed by several Services.
+func findService(deployment *kyaml.RNode, nodes []*kyaml.RNode) (*kyaml.RNode, error) {
+	name := deployment.GetAnnotations()[serviceAnnotation]
+
+	var podLabels map[string]string
+	if err := decodeField(deployment, []string{"spec", "template", "metadata", "labels"}, &podLabels); err != nil {
+		return nil, err
+	}
+
+	var matches []*kyaml.RNode
+	for _, node := range nodes {
+		if node.GetKind() != serviceKind || node.GetNamespace() != deployment.GetNamespace() {
+			continue
+		}
+
+		if name != "" {
+			if node.GetName() == name {
+				return node, nil
+			}
+			continue
+		}
+
+		var serviceSelector map[string]string
+		if err := decodeField(node, []string{"spec", "selector"}, &serviceSelector); err != nil {
+			return nil, err
+		}
+		if len(serviceSelector) == 0 {
+			continue
+		}
+
+		if labels.SelectorFromSet(serviceSelector).Matches(labels.Set(podLabels)) {
+			matches = append(matches, node)
+		}
+	}
+
+	if name != "" {
+		return nil, fmt.Errorf("%s %s: Service %s not found", deployment.GetKind(), deployment.GetName(), name)
+	}
+
+	if len(matches) > 1 {
+		return nil, fmt.Errorf("%s %s is selected by multiple Services, set the %s annotation", deployment.GetKind(), deployment.GetName(), serviceAnnotation)
+	}
+
+	if len(matches) == 0 {
+		return nil, nil
+	}
+
+	return matches[0], nil
+}
+
+func makeStrategy(spec *Spec, deployment *kyaml.RNode, service *kyaml.RNode) (*rolloutStrategy, *kyaml.RNode, error) {
+	if spec.BlueGreen != nil {
+		if service == nil {
+			return nil, nil, fmt.Errorf("%s %s is not selected by any Service", deployment.GetKind(), deployment.GetName())
+		}
+
+		preview, err := copyService(service, previewServiceSuffix)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return &rolloutStrategy{
+			BlueGreen: &blueGreenStrategy{
+				ActiveService:         service.GetName(),
+				PreviewService:        preview.GetName(),
+				AutoPromotionEnabled:  spec.BlueGreen.AutoPromotionEnabled,
+				ScaleDownDelaySeconds: spec.BlueGreen.ScaleDownDelaySeconds,
+				PrePromotionAnalysis:  makeAnalysis(spec.BlueGreen.PrePromotionAnalysis),
+				PostPromotionAnalysis: makeAnalysis(spec.BlueGreen.PostPromotionAnalysis),
+			},
+		}, preview, nil
+	}
+
+	canary := &canaryStrategy{
+		Analysis:       makeAnalysis(spec.Canary.Analysis),
+		MaxSurge:       spec.Canary.MaxSurge,
+		MaxUnavailable: spec.Canary.MaxUnavailable,
+	}
+
+	for _, step := range spec.Canary.Steps {
+		canary.Steps = append(canary.Steps, canaryStep{
+			SetWeight: step.SetWeight,
+			Pause:     step.Pause,
+			Analysis:  makeAnalysis(step.Analysis),
+		})
+	}
+
+	if service == nil {
+		return &rolloutStrategy{
+			Canary: canary,
+		}, nil, nil
+	}
+
+	canaryService, err := copyService(service, canaryServiceSuffix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	canary.StableService = service.GetName()
+	canary.CanaryService = canaryService.GetName()
+
+	return &rolloutStrategy{
+		Canary: canary,
+	}, canaryService, nil
+}
+
+func makeAnalysis(analysis *Analysis) *rolloutAnalysis {
+	if analysis == nil {
+		return nil
+	}
+
+	templates := make([]analysisTemplate, 0, len(analysis.Templates))
+	for _, template := range analysis.Templates {
+		templates = append(templates, analysisTemplate{
+			TemplateName: template,
+		})
+	}
+
+	return &rolloutAnalysis{
+		Templates: templates,
+		Args:      analysis.Args,
+	}
+}
+
+// copyService duplicates a Service under a suffixed name, dropping the fields
+// allocated by the cluster so both can coexist.
+func copyService(service *kyaml.RNode, suffix string) (*kyaml.RNode, error) {
+	node := service.Copy()
+
+	if err := node.SetName(service.GetName() + suffix); err != nil {
+		return nil, err
+	}
+
+	specNode, err := node.Pipe(kyaml.Lookup("spec"))
+	if err != nil {
+		return nil, err
+	}
+	if specNode == nil {
+		return node, nil
+	}
+
+	for _, field := range []string{"clusterIP", "clusterIPs"} {
+		if _, err := specNode.Pipe(kyaml.Clear(field)); err != nil {
+			return nil, err
+		}
+	}
+
+	ports, err := specNode.Pipe(kyaml.Lookup("ports"))
+	if err != nil {
+		return nil, err
+	}
+	if ports == nil {
+		return node, nil
+	}
+
+	elements, err := ports.Elements()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, port := range elements {
+		if _, err := port.Pipe(kyaml.Clear("nodePort")); err != nil {
+			return nil, err
+		}
+	}
+
+	return node, nil
+}
+
+func convert(node *kyaml.RNode, strategy *rolloutStrategy) error {
+	node.SetApiVersion(rolloutAPIVersion)
+	node.SetKind(rolloutKind)
+
+	specNode, err := node.Pipe(kyaml.Lookup("spec"))
+	if err != nil {
+		return err
+	}
+	if specNode == nil {
+		return fmt.Errorf("%s %s has no spec", rolloutKind, node.GetName())
+	}
+
+	return encodeField(specNode, "strategy", strategy)
+}
+
+// retarget points HorizontalPodAutoscalers at the Rollouts replacing the
+// Deployments they used to scale.
+func retarget(node *kyaml.RNode, converted map[workloadKey]bool) error {
+	scaleTargetRef, err := node.Pipe(kyaml.Lookup("spec", "scaleTargetRef"))
+	if err != nil {
+		return err
+	}
+	if scaleTargetRef == nil {
+		return nil
+	}
+
+	kind, err := scaleTargetRef.Pipe(kyaml.Lookup("kind"))
+	if err != nil {
+		return err
+	}
+	if kind == nil || kyaml.GetValue(kind) != deploymentKind {
+		return nil
+	}
+
+	name, err := scaleTargetRef.Pipe(kyaml.Lookup("name"))
+	if err != nil {
+		return err
+	}
+	if name == nil || !converted[workloadKey{
+		namespace: node.GetNamespace(),
+		name:      kyaml.GetValue(name),
+	}] {
+		return nil
+	}
+
+	if err := scaleTargetRef.PipeE(kyaml.SetField("apiVersion", kyaml.NewStringRNode(rolloutAPIVersion))); err != nil {
+		return err
+	}
+
+	return scaleTargetRef.PipeE(kyaml.SetField("kind", kyaml.NewStringRNode(rolloutKind)))
+}
+
+func decodeField(node *kyaml.RNode, path []string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
+
+func encodeField(node *kyaml.RNode, field string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	value, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return node.PipeE(kyaml.SetField(field, value))
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
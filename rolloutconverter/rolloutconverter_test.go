@@ -0,0 +1,169 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/rolloutconverter"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    app: my-app
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: my-app
+  strategy:
+    type: RollingUpdate
+  template:
+    metadata:
+      labels:
+        app: my-app
+    spec:
+      containers:
+        - name: app
+          image: my-app:1.0.0
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: other
+  labels:
+    app: other
+spec:
+  template:
+    metadata:
+      labels:
+        app: other
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+spec:
+  clusterIP: 10.0.0.10
+  selector:
+    app: my-app
+  ports:
+    - port: 80
+---
+apiVersion: autoscaling/v2
+kind: HorizontalPodAutoscaler
+metadata:
+  name: my-app
+spec:
+  scaleTargetRef:
+    apiVersion: apps/v1
+    kind: Deployment
+    name: my-app
+  maxReplicas: 10
+`
+)
+
+var _ = ginkgo.Describe("RolloutConverter", func() {
+	ginkgo.It("converts Deployments into canary Rollouts", func() {
+		nodes := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: RolloutConverter
+metadata:
+  name: rollout-converter
+spec:
+  names:
+    - my-app
+  canary:
+    steps:
+      - setWeight: 20
+      - pause:
+          duration: 5m
+    analysis:
+      templates:
+        - success-rate
+`)
+		g.Expect(nodes).To(g.HaveKey("Rollout/my-app"))
+		g.Expect(nodes).To(g.HaveKey("Deployment/other"))
+		g.Expect(nodes).To(g.HaveKey("Service/my-app-canary"))
+
+		rollout := nodes["Rollout/my-app"]
+		g.Expect(rollout.GetApiVersion()).To(g.Equal("argoproj.io/v1alpha1"))
+		g.Expect(lookup(rollout, "spec", "strategy", "canary", "stableService")).To(g.Equal("my-app"))
+		g.Expect(lookup(rollout, "spec", "strategy", "canary", "canaryService")).To(g.Equal("my-app-canary"))
+		g.Expect(lookup(rollout, "spec", "strategy", "canary", "analysis", "templates", "[templateName=success-rate]", "templateName")).To(g.Equal("success-rate"))
+		g.Expect(lookup(rollout, "spec", "strategy", "type")).To(g.BeEmpty())
+		g.Expect(lookup(rollout, "spec", "template", "spec", "containers", "[name=app]", "image")).To(g.Equal("my-app:1.0.0"))
+
+		g.Expect(lookup(nodes["Service/my-app-canary"], "spec", "clusterIP")).To(g.BeEmpty())
+
+		g.Expect(lookup(nodes["HorizontalPodAutoscaler/my-app"], "spec", "scaleTargetRef", "kind")).To(g.Equal("Rollout"))
+	})
+
+	ginkgo.It("converts Deployments into blue-green Rollouts", func() {
+		nodes := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: RolloutConverter
+metadata:
+  name: rollout-converter
+spec:
+  selector:
+    matchLabels:
+      app: my-app
+  blueGreen:
+    autoPromotionEnabled: false
+`)
+		g.Expect(nodes).To(g.HaveKey("Service/my-app-preview"))
+		g.Expect(lookup(nodes["Rollout/my-app"], "spec", "strategy", "blueGreen", "activeService")).To(g.Equal("my-app"))
+		g.Expect(lookup(nodes["Rollout/my-app"], "spec", "strategy", "blueGreen", "previewService")).To(g.Equal("my-app-preview"))
+	})
+
+	ginkgo.It("fails blue-green Rollouts without Services", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: RolloutConverter
+metadata:
+  name: rollout-converter
+spec:
+  names:
+    - other
+  blueGreen: {}
+`), strings.NewReader(resources), &out)).To(g.MatchError("Deployment other is not selected by any Service"))
+	})
+})
+
+func transform(config string) map[string]*kyaml.RNode {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	nodes, err := (&kio.ByteReader{
+		Reader: &out,
+	}).Read()
+	g.Expect(err).NotTo(g.HaveOccurred())
+
+	byName := make(map[string]*kyaml.RNode)
+	for _, node := range nodes {
+		byName[node.GetKind()+"/"+node.GetName()] = node
+	}
+
+	return byName
+}
+
+func lookup(node *kyaml.RNode, path ...string) string {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	g.Expect(err).NotTo(g.HaveOccurred())
+	if value == nil {
+		return ""
+	}
+
+	return kyaml.GetValue(value)
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	sealedSecretsGroup   = "bitnami.com"
+	sealedSecretsVersion = "v1alpha1"
+	sealedSecretKind     = "SealedSecret"
+
+	namespaceWideAnnotation = "sealedsecrets.bitnami.com/namespace-wide"
+	clusterWideAnnotation   = "sealedsecrets.bitnami.com/cluster-wide"
+
+	sessionKeyBytes = 32
+
+	// seedEnv is a secret that, when set, makes sealing deterministic. It must
+	// never be committed, as whoever has it and the certificate can check
+	// guesses of the plaintexts against the ciphertexts.
+	seedEnv = "SEALED_SECRET_SEED"
+)
+
+type Scope string
+
+const (
+	StrictScope        Scope = "strict"
+	NamespaceWideScope Scope = "namespace-wide"
+	ClusterWideScope   Scope = "cluster-wide"
+)
+
+// label is what secrets are sealed to, so they can only be unsealed in the
+// namespace they were sealed for unless the scope is cluster-wide.
+func (s Scope) label(namespace string, name string) ([]byte, error) {
+	switch s {
+	case StrictScope, "":
+		if namespace == "" {
+			return nil, fmt.Errorf("metadata.namespace is empty, which the %s scope requires", StrictScope)
+		}
+		return []byte(fmt.Sprintf("%s/%s", namespace, name)), nil
+	case NamespaceWideScope:
+		if namespace == "" {
+			return nil, fmt.Errorf("metadata.namespace is empty, which the %s scope requires", NamespaceWideScope)
+		}
+		return []byte(namespace), nil
+	case ClusterWideScope:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown scope %s", s)
+	}
+}
+
+func (s Scope) annotations() map[string]string {
+	switch s {
+	case NamespaceWideScope:
+		return map[string]string{
+			namespaceWideAnnotation: "true",
+		}
+	case ClusterWideScope:
+		return map[string]string{
+			clusterWideAnnotation: "true",
+		}
+	default:
+		return nil
+	}
+}
+
+type SealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Certificate string            `json:"certificate,omitempty"`
+	Scope       Scope             `json:"scope,omitempty"`
+	Type        corev1.SecretType `json:"type,omitempty"`
+	Data        map[string]string `json:"data,omitempty"`
+	Envs        map[string]string `json:"envs,omitempty"`
+	Files       map[string]string `json:"files,omitempty"`
+}
+
+type bitnamiSealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              bitnamiSealedSecretSpec `json:"spec"`
+}
+
+type bitnamiSealedSecretSpec struct {
+	Template      bitnamiSecretTemplateSpec `json:"template,omitempty"`
+	EncryptedData map[string]string         `json:"encryptedData"`
+}
+
+type bitnamiSecretTemplateSpec struct {
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Type              corev1.SecretType `json:"type,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var sealedSecret SealedSecret
+	if err := pluginconfig.Unmarshal(data, &sealedSecret); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&sealedSecret)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(sealedSecret *SealedSecret) ([][]byte, error) {
+	publicKey, err := readPublicKey(sealedSecret.Spec.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintexts, err := readPlaintexts(&sealedSecret.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest, err := makeSealedSecret(sealedSecret, publicKey, plaintexts)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{manifest}, nil
+}
+
+func readPublicKey(certificatePath string) (*rsa.PublicKey, error) {
+	if certificatePath == "" {
+		return nil, fmt.Errorf("spec.certificate is empty")
+	}
+
+	data, err := ioutil.ReadFile(certificatePath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s has no PEM block", certificatePath)
+	}
+
+	certificate, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	publicKey, ok := certificate.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s has no RSA public key", certificatePath)
+	}
+
+	return publicKey, nil
+}
+
+func readPlaintexts(spec *Spec) (map[string][]byte, error) {
+	plaintexts := make(map[string][]byte)
+
+	add := func(key string, value []byte) error {
+		if _, exists := plaintexts[key]; exists {
+			return fmt.Errorf("key %s is duplicated", key)
+		}
+		plaintexts[key] = value
+		return nil
+	}
+
+	for key, value := range spec.Data {
+		if err := add(key, []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, env := range spec.Envs {
+		value, exists := os.LookupEnv(env)
+		if !exists {
+			return nil, fmt.Errorf("%s is empty", env)
+		}
+
+		if err := add(key, []byte(value)); err != nil {
+			return nil, err
+		}
+	}
+
+	for key, path := range spec.Files {
+		value, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := add(key, value); err != nil {
+			return nil, err
+		}
+	}
+
+	return plaintexts, nil
+}
+
+func makeSealedSecret(sealedSecret *SealedSecret, publicKey *rsa.PublicKey, plaintexts map[string][]byte) ([]byte, error) {
+	label, err := sealedSecret.Spec.Scope.label(sealedSecret.Namespace, sealedSecret.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(plaintexts))
+	for key := range plaintexts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	encryptedData := make(map[string]string, len(plaintexts))
+	for _, key := range keys {
+		ciphertext, err := hybridEncrypt(publicKey, plaintexts[key], label, key)
+		if err != nil {
+			return nil, err
+		}
+		encryptedData[key] = base64.StdEncoding.EncodeToString(ciphertext)
+	}
+
+	objectMeta := *sealedSecret.ObjectMeta.DeepCopy()
+	for key, value := range sealedSecret.Spec.Scope.annotations() {
+		metav1.SetMetaDataAnnotation(&objectMeta, key, value)
+	}
+
+	secretType := sealedSecret.Spec.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+
+	manifest := bitnamiSealedSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   sealedSecretsGroup,
+				Version: sealedSecretsVersion,
+			}.String(),
+			Kind: sealedSecretKind,
+		},
+		ObjectMeta: objectMeta,
+		Spec: bitnamiSealedSecretSpec{
+			Template: bitnamiSecretTemplateSpec{
+				ObjectMeta: objectMeta,
+				Type:       secretType,
+			},
+			EncryptedData: encryptedData,
+		},
+	}
+
+	return yaml.Marshal(manifest)
+}
+
+// hybridEncrypt implements the same scheme as kubeseal. When seedEnv is set, it
+// draws its randomness from a stream keyed by the seed and seeded by the inputs,
+// so the same inputs always seal to the same ciphertext and re-running the
+// build does not produce spurious diffs.
+func hybridEncrypt(publicKey *rsa.PublicKey, plaintext []byte, label []byte, key string) ([]byte, error) {
+	rnd := rand.Reader
+	if seed, exists := os.LookupEnv(seedEnv); exists && seed != "" {
+		rnd = newDeterministicReader([]byte(seed), publicKey, plaintext, label, key)
+	}
+
+	sessionKey := make([]byte, sessionKeyBytes)
+	if _, err := io.ReadFull(rnd, sessionKey); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaCiphertext, err := rsa.EncryptOAEP(sha256.New(), rnd, publicKey, sessionKey, label)
+	if err != nil {
+		return nil, err
+	}
+
+	ciphertext := make([]byte, 2)
+	binary.BigEndian.PutUint16(ciphertext, uint16(len(rsaCiphertext)))
+	ciphertext = append(ciphertext, rsaCiphertext...)
+
+	zeroNonce := make([]byte, aead.NonceSize())
+
+	return aead.Seal(ciphertext, zeroNonce, plaintext, nil), nil
+}
+
+type deterministicReader struct {
+	seed    []byte
+	counter uint64
+	buffer  []byte
+}
+
+func newDeterministicReader(seed []byte, publicKey *rsa.PublicKey, plaintext []byte, label []byte, key string) *deterministicReader {
+	mac := hmac.New(sha256.New, seed)
+	for _, part := range [][]byte{x509.MarshalPKCS1PublicKey(publicKey), label, []byte(key), plaintext} {
+		length := make([]byte, 8)
+		binary.BigEndian.PutUint64(length, uint64(len(part)))
+		mac.Write(length)
+		mac.Write(part)
+	}
+
+	return &deterministicReader{
+		seed: mac.Sum(nil),
+	}
+}
+
+func (r *deterministicReader) Read(p []byte) (int, error) {
+	for len(r.buffer) < len(p) {
+		counter := make([]byte, 8)
+		binary.BigEndian.PutUint64(counter, r.counter)
+		r.counter++
+
+		mac := hmac.New(sha256.New, r.seed)
+		mac.Write(counter)
+		r.buffer = append(r.buffer, mac.Sum(nil)...)
+	}
+
+	n := copy(p, r.buffer)
+	r.buffer = r.buffer[n:]
+
+	return n, nil
+}
@@ -0,0 +1,221 @@
+package main_test
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/sealedsecret"
+)
+
+type sealedSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              struct {
+		Template struct {
+			metav1.ObjectMeta `json:"metadata,omitempty"`
+			Type              string `json:"type,omitempty"`
+		} `json:"template,omitempty"`
+		EncryptedData map[string]string `json:"encryptedData"`
+	} `json:"spec"`
+}
+
+var _ = ginkgo.Describe("SealedSecret", func() {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	g.Expect(err).To(g.BeNil())
+
+	workingDir, err := os.MkdirTemp("", "*")
+	g.Expect(err).To(g.BeNil())
+
+	certificatePath := filepath.Join(workingDir, "cert.pem")
+	g.Expect(writeCertificate(certificatePath, privateKey)).To(g.Succeed())
+
+	secretFilePath := filepath.Join(workingDir, "secret.txt")
+	g.Expect(os.WriteFile(secretFilePath, []byte("from-file"), 0600)).To(g.Succeed())
+
+	g.Expect(os.Setenv("SEALED_SECRET_TEST_VALUE", "from-env")).To(g.Succeed())
+
+	ginkgo.DescribeTable("", func(scope string, expectedLabel string, expectedAnnotations map[string]string) {
+		incogniaSealedSecret := main.SealedSecret{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: schema.GroupVersion{
+					Group:   "incognia.com",
+					Version: "v1alpha1",
+				}.String(),
+				Kind: "SealedSecret",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "my-app",
+				Namespace: "my-namespace",
+			},
+			Spec: main.Spec{
+				Certificate: certificatePath,
+				Data: map[string]string{
+					"literal": "from-data",
+				},
+				Envs: map[string]string{
+					"env": "SEALED_SECRET_TEST_VALUE",
+				},
+				Files: map[string]string{
+					"file": secretFilePath,
+				},
+			},
+		}
+		incogniaSealedSecret.Spec.Scope = main.Scope(scope)
+
+		data, err := yaml.Marshal(incogniaSealedSecret)
+		g.Expect(err).To(g.BeNil())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests(data, &out)).To(g.Succeed())
+
+		var manifest sealedSecret
+		g.Expect(yaml.Unmarshal(out.Bytes(), &manifest)).To(g.Succeed())
+
+		ginkgo.By("contains expected metadata", func() {
+			g.Expect(manifest.APIVersion).To(g.Equal("bitnami.com/v1alpha1"))
+			g.Expect(manifest.Kind).To(g.Equal("SealedSecret"))
+			g.Expect(manifest.Name).To(g.Equal("my-app"))
+			g.Expect(manifest.Namespace).To(g.Equal("my-namespace"))
+			g.Expect(manifest.Annotations).To(g.Equal(expectedAnnotations))
+			g.Expect(manifest.Spec.Template.Type).To(g.Equal("Opaque"))
+		})
+
+		ginkgo.By("contains decryptable data", func() {
+			decrypted := make(map[string]string)
+			for key, value := range manifest.Spec.EncryptedData {
+				plaintext, err := hybridDecrypt(privateKey, value, []byte(expectedLabel))
+				g.Expect(err).To(g.BeNil())
+				decrypted[key] = string(plaintext)
+			}
+
+			g.Expect(decrypted).To(g.Equal(map[string]string{
+				"literal": "from-data",
+				"env":     "from-env",
+				"file":    "from-file",
+			}))
+		})
+
+		ginkgo.By("is randomized", func() {
+			var again bytes.Buffer
+			g.Expect(main.GenerateManifests(data, &again)).To(g.Succeed())
+			g.Expect(again.String()).NotTo(g.Equal(out.String()))
+		})
+
+		ginkgo.By("is deterministic with a seed", func() {
+			g.Expect(os.Setenv("SEALED_SECRET_SEED", "seed")).To(g.Succeed())
+			ginkgo.DeferCleanup(os.Unsetenv, "SEALED_SECRET_SEED")
+
+			var seeded, again bytes.Buffer
+			g.Expect(main.GenerateManifests(data, &seeded)).To(g.Succeed())
+			g.Expect(main.GenerateManifests(data, &again)).To(g.Succeed())
+			g.Expect(again.String()).To(g.Equal(seeded.String()))
+
+			g.Expect(os.Setenv("SEALED_SECRET_SEED", "other-seed")).To(g.Succeed())
+
+			var otherSeed bytes.Buffer
+			g.Expect(main.GenerateManifests(data, &otherSeed)).To(g.Succeed())
+			g.Expect(otherSeed.String()).NotTo(g.Equal(seeded.String()))
+		})
+	},
+		ginkgo.Entry("with strict scope", "strict", "my-namespace/my-app", nil),
+		ginkgo.Entry("with namespace-wide scope", "namespace-wide", "my-namespace", map[string]string{
+			"sealedsecrets.bitnami.com/namespace-wide": "true",
+		}),
+		ginkgo.Entry("with cluster-wide scope", "cluster-wide", "", map[string]string{
+			"sealedsecrets.bitnami.com/cluster-wide": "true",
+		}),
+	)
+
+	ginkgo.DescribeTable("without a namespace", func(scope string, expectedErr string) {
+		var out bytes.Buffer
+		err := main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: SealedSecret
+metadata:
+  name: my-app
+spec:
+  certificate: `+certificatePath+`
+  scope: `+scope+`
+  data:
+    literal: from-data
+`), &out)
+		if expectedErr == "" {
+			g.Expect(err).NotTo(g.HaveOccurred())
+			return
+		}
+		g.Expect(err).To(g.MatchError(expectedErr))
+	},
+		ginkgo.Entry("fails with the default scope", `""`, "metadata.namespace is empty, which the strict scope requires"),
+		ginkgo.Entry("fails with strict scope", "strict", "metadata.namespace is empty, which the strict scope requires"),
+		ginkgo.Entry("fails with namespace-wide scope", "namespace-wide", "metadata.namespace is empty, which the namespace-wide scope requires"),
+		ginkgo.Entry("seals with cluster-wide scope", "cluster-wide", ""),
+	)
+})
+
+func writeCertificate(path string, privateKey *rsa.PrivateKey) error {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject: pkix.Name{
+			CommonName: "sealed-secret",
+		},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(path, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: der,
+	}), 0600)
+}
+
+func hybridDecrypt(privateKey *rsa.PrivateKey, value string, label []byte) ([]byte, error) {
+	ciphertext, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, err
+	}
+
+	rsaLength := int(binary.BigEndian.Uint16(ciphertext))
+	rsaCiphertext := ciphertext[2 : 2+rsaLength]
+	aesCiphertext := ciphertext[2+rsaLength:]
+
+	sessionKey, err := rsa.DecryptOAEP(sha256.New(), nil, privateKey, rsaCiphertext, label)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(sessionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return aead.Open(nil, make([]byte, aead.NonceSize()), aesCiphertext, nil)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	cronJobKind             = "CronJob"
+	serviceAccountNameField = "serviceAccountName"
+	defaultServiceAccount   = "default"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podSpecPath = []string{
+		"spec",
+		"template",
+		"spec",
+	}
+
+	cronJobPodSpecPath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+		"spec",
+	}
+)
+
+type ServiceAccountInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	ServiceAccountName string                `json:"serviceAccountName,omitempty"`
+	Selector           *metav1.LabelSelector `json:"selector,omitempty"`
+	Kinds              []string              `json:"kinds,omitempty"`
+	Override           bool                  `json:"override,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var serviceAccountInjector ServiceAccountInjector
+	if err := pluginconfig.Unmarshal(data, &serviceAccountInjector); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&serviceAccountInjector, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(serviceAccountInjector *ServiceAccountInjector, nodes []*kyaml.RNode) error {
+	spec := &serviceAccountInjector.Spec
+
+	if spec.ServiceAccountName == "" {
+		return fmt.Errorf("spec.serviceAccountName is empty")
+	}
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	selector := labels.Everything()
+	if spec.Selector != nil {
+		s, err := metav1.LabelSelectorAsSelector(spec.Selector)
+		if err != nil {
+			return fmt.Errorf("spec.selector: %w", err)
+		}
+		selector = s
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if serviceAccountInjector.Namespace != "" && node.GetNamespace() != serviceAccountInjector.Namespace {
+			continue
+		}
+
+		if !selector.Matches(labels.Set(node.GetLabels())) {
+			continue
+		}
+
+		path := podSpecPath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodSpecPath
+		}
+
+		podSpec, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if podSpec == nil {
+			continue
+		}
+
+		current, err := podSpec.Pipe(kyaml.Lookup(serviceAccountNameField))
+		if err != nil {
+			return err
+		}
+		if current != nil && kyaml.GetValue(current) != defaultServiceAccount && !spec.Override {
+			continue
+		}
+
+		if err := podSpec.PipeE(kyaml.SetField(serviceAccountNameField, kyaml.NewScalarRNode(spec.ServiceAccountName))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
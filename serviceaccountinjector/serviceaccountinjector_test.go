@@ -0,0 +1,144 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/serviceaccountinjector"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unset
+  labels:
+    app: my-app
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: default
+  labels:
+    app: my-app
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          serviceAccountName: default
+          containers:
+            - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: explicit
+  labels:
+    app: my-app
+spec:
+  template:
+    spec:
+      serviceAccountName: other
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: unselected
+  labels:
+    app: other-app
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+`
+)
+
+var _ = ginkgo.Describe("ServiceAccountInjector", func() {
+	ginkgo.DescribeTable("", func(config string, expected map[string]string) {
+		g.Expect(transform(config)).To(g.Equal(expected))
+	},
+		ginkgo.Entry("without override", `
+apiVersion: incognia.com/v1alpha1
+kind: ServiceAccountInjector
+metadata:
+  name: service-account-injector
+spec:
+  serviceAccountName: my-app
+  selector:
+    matchLabels:
+      app: my-app
+`, map[string]string{
+			"unset":    "my-app",
+			"default":  "my-app",
+			"explicit": "other",
+		}),
+		ginkgo.Entry("with override", `
+apiVersion: incognia.com/v1alpha1
+kind: ServiceAccountInjector
+metadata:
+  name: service-account-injector
+spec:
+  serviceAccountName: my-app
+  selector:
+    matchLabels:
+      app: my-app
+  override: true
+`, map[string]string{
+			"unset":    "my-app",
+			"default":  "my-app",
+			"explicit": "my-app",
+		}),
+	)
+
+	ginkgo.It("fails without service account name", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ServiceAccountInjector
+metadata:
+  name: service-account-injector
+`), strings.NewReader(resources), &out)).To(g.MatchError("spec.serviceAccountName is empty"))
+	})
+})
+
+func transform(config string) map[string]string {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                &out,
+		OmitReaderAnnotations: true,
+	}).Read()
+	g.Expect(err).To(g.BeNil())
+
+	serviceAccountNames := make(map[string]string)
+	for _, node := range nodes {
+		path := []string{"spec", "template", "spec", "serviceAccountName"}
+		if node.GetKind() == "CronJob" {
+			path = []string{"spec", "jobTemplate", "spec", "template", "spec", "serviceAccountName"}
+		}
+
+		value, err := node.Pipe(kyaml.Lookup(path...))
+		g.Expect(err).To(g.BeNil())
+		if value != nil {
+			serviceAccountNames[node.GetName()] = kyaml.GetValue(value)
+		}
+	}
+
+	return serviceAccountNames
+}
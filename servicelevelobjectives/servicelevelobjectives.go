@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	prometheusRuleKind = "PrometheusRule"
+
+	errorRatioRecord = "slo:sli_error:ratio_rate"
+	burnAlert        = "SLOErrorBudgetBurn"
+
+	sloLabel      = "slo"
+	serviceLabel  = "service"
+	severityLabel = "severity"
+	windowLabel   = "long_window"
+
+	defaultWindow = "30d"
+)
+
+var (
+	monitoringGroupVersion = schema.GroupVersion{
+		Group:   "monitoring.coreos.com",
+		Version: "v1",
+	}
+
+	// defaultBurnRates are the multiwindow, multi-burn-rate alerts recommended
+	// by the Site Reliability Workbook for a 30 days window, whose factors are
+	// scaled to the window of each objective.
+	defaultBurnRates = []BurnRate{
+		BurnRate{
+			LongWindow:  "1h",
+			ShortWindow: "5m",
+			Factor:      14.4,
+			Severity:    "page",
+		},
+		BurnRate{
+			LongWindow:  "6h",
+			ShortWindow: "30m",
+			Factor:      6,
+			Severity:    "page",
+		},
+		BurnRate{
+			LongWindow:  "1d",
+			ShortWindow: "2h",
+			Factor:      3,
+			Severity:    "ticket",
+		},
+		BurnRate{
+			LongWindow:  "3d",
+			ShortWindow: "6h",
+			Factor:      1,
+			Severity:    "ticket",
+		},
+	}
+)
+
+type ServiceLevelObjectives struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Service    string      `json:"service,omitempty"`
+	Objectives []Objective `json:"objectives,omitempty"`
+	BurnRates  []BurnRate  `json:"burnRates,omitempty"`
+}
+
+type Objective struct {
+	Name         string        `json:"name,omitempty"`
+	Target       float64       `json:"target,omitempty"`
+	Window       string        `json:"window,omitempty"`
+	Availability *Availability `json:"availability,omitempty"`
+	Latency      *Latency      `json:"latency,omitempty"`
+}
+
+type Availability struct {
+	Metric        string `json:"metric,omitempty"`
+	Selector      string `json:"selector,omitempty"`
+	ErrorSelector string `json:"errorSelector,omitempty"`
+}
+
+type Latency struct {
+	Metric    string `json:"metric,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+	Threshold string `json:"threshold,omitempty"`
+}
+
+type BurnRate struct {
+	LongWindow  string  `json:"longWindow,omitempty"`
+	ShortWindow string  `json:"shortWindow,omitempty"`
+	Factor      float64 `json:"factor,omitempty"`
+	Severity    string  `json:"severity,omitempty"`
+}
+
+// The types below mirror the subset of the Prometheus Operator API written by
+// this plugin, which avoids depending on the whole Prometheus Operator module.
+
+type prometheusRule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              prometheusRuleSpec `json:"spec"`
+}
+
+type prometheusRuleSpec struct {
+	Groups []ruleGroup `json:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `json:"name"`
+	Rules []rule `json:"rules"`
+}
+
+type rule struct {
+	Record      string            `json:"record,omitempty"`
+	Alert       string            `json:"alert,omitempty"`
+	Expr        string            `json:"expr"`
+	For         string            `json:"for,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var serviceLevelObjectives ServiceLevelObjectives
+	if err := pluginconfig.Unmarshal(data, &serviceLevelObjectives); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&serviceLevelObjectives)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(serviceLevelObjectives *ServiceLevelObjectives) ([][]byte, error) {
+	spec := &serviceLevelObjectives.Spec
+
+	service := spec.Service
+	if service == "" {
+		service = serviceLevelObjectives.Name
+	}
+
+	for i, burnRate := range spec.BurnRates {
+		if burnRate.LongWindow == "" || burnRate.ShortWindow == "" || burnRate.Factor <= 0 || burnRate.Severity == "" {
+			return nil, fmt.Errorf("spec.burnRates[%d] is incomplete", i)
+		}
+
+		for _, window := range []string{burnRate.LongWindow, burnRate.ShortWindow} {
+			if _, err := parseWindow(window); err != nil {
+				return nil, fmt.Errorf("spec.burnRates[%d]: %w", i, err)
+			}
+		}
+	}
+
+	if len(spec.Objectives) == 0 {
+		return nil, fmt.Errorf("spec.objectives is empty")
+	}
+
+	names := make(map[string]bool, len(spec.Objectives))
+	groups := make([]ruleGroup, 0, len(spec.Objectives))
+	for _, objective := range spec.Objectives {
+		if objective.Name == "" {
+			return nil, fmt.Errorf("objective without name")
+		}
+
+		if names[objective.Name] {
+			return nil, fmt.Errorf("objective %s is duplicated", objective.Name)
+		}
+		names[objective.Name] = true
+
+		group, err := makeRuleGroup(service, &objective, spec.BurnRates)
+		if err != nil {
+			return nil, fmt.Errorf("objective %s: %w", objective.Name, err)
+		}
+		groups = append(groups, *group)
+	}
+
+	b, err := yaml.Marshal(prometheusRule{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringGroupVersion.String(),
+			Kind:       prometheusRuleKind,
+		},
+		ObjectMeta: serviceLevelObjectives.ObjectMeta,
+		Spec: prometheusRuleSpec{
+			Groups: groups,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{b}, nil
+}
+
+func makeRuleGroup(service string, objective *Objective, burnRates []BurnRate) (*ruleGroup, error) {
+	if objective.Target <= 0 || objective.Target >= 100 {
+		return nil, fmt.Errorf("target must be between 0 and 100")
+	}
+
+	window := objective.Window
+	if window == "" {
+		window = defaultWindow
+	}
+
+	windowDuration, err := parseWindow(window)
+	if err != nil {
+		return nil, fmt.Errorf("window: %w", err)
+	}
+
+	if len(burnRates) == 0 {
+		burnRates = scaleBurnRates(defaultBurnRates, windowDuration)
+	}
+
+	for _, burnRate := range burnRates {
+		if longWindow, _ := parseWindow(burnRate.LongWindow); longWindow > windowDuration {
+			return nil, fmt.Errorf("burn rate window %s is longer than the window %s", burnRate.LongWindow, window)
+		}
+	}
+
+	errorRatio, err := makeErrorRatio(objective)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := map[string]string{
+		sloLabel:     objective.Name,
+		serviceLabel: service,
+	}
+
+	var rules []rule
+	for _, w := range collectWindows(burnRates) {
+		rules = append(rules, rule{
+			Record: errorRatioRecord + w,
+			Expr:   errorRatio(w),
+			Labels: labels,
+		})
+	}
+
+	budget := roundFloat((100 - objective.Target) / 100)
+	selector := fmt.Sprintf(`%s="%s", %s="%s"`, sloLabel, objective.Name, serviceLabel, service)
+
+	for _, burnRate := range burnRates {
+		threshold := formatFloat(burnRate.Factor) + " * " + formatFloat(budget)
+
+		rules = append(rules, rule{
+			Alert: burnAlert,
+			Expr: fmt.Sprintf(
+				"%s%s{%s} > (%s)\nand\n%s%s{%s} > (%s)",
+				errorRatioRecord, burnRate.LongWindow, selector, threshold,
+				errorRatioRecord, burnRate.ShortWindow, selector, threshold,
+			),
+			Labels: map[string]string{
+				sloLabel:      objective.Name,
+				serviceLabel:  service,
+				severityLabel: burnRate.Severity,
+				windowLabel:   burnRate.LongWindow,
+			},
+			Annotations: map[string]string{
+				"summary": fmt.Sprintf(
+					"%s is burning its %s error budget of %s%% over %s at %sx the sustainable rate",
+					service, window, formatFloat(objective.Target), objective.Name, formatFloat(burnRate.Factor),
+				),
+			},
+		})
+	}
+
+	return &ruleGroup{
+		Name:  fmt.Sprintf("slo-%s-%s", service, objective.Name),
+		Rules: rules,
+	}, nil
+}
+
+func makeErrorRatio(objective *Objective) (func(window string) string, error) {
+	switch {
+	case objective.Availability != nil && objective.Latency != nil:
+		return nil, fmt.Errorf("availability and latency are mutually exclusive")
+	case objective.Availability != nil:
+		availability := objective.Availability
+		if availability.Metric == "" || availability.ErrorSelector == "" {
+			return nil, fmt.Errorf("availability.metric and availability.errorSelector are required")
+		}
+
+		return func(window string) string {
+			return fmt.Sprintf(
+				"sum(rate(%s{%s}[%s]))\n/\nsum(rate(%s{%s}[%s]))",
+				availability.Metric, joinSelectors(availability.Selector, availability.ErrorSelector), window,
+				availability.Metric, availability.Selector, window,
+			)
+		}, nil
+	case objective.Latency != nil:
+		latency := objective.Latency
+		if latency.Metric == "" || latency.Threshold == "" {
+			return nil, fmt.Errorf("latency.metric and latency.threshold are required")
+		}
+
+		if _, err := strconv.ParseFloat(latency.Threshold, 64); err != nil {
+			return nil, fmt.Errorf("latency.threshold: %w", err)
+		}
+
+		return func(window string) string {
+			return fmt.Sprintf(
+				"1 - (\nsum(rate(%s_bucket{%s}[%s]))\n/\nsum(rate(%s_count{%s}[%s]))\n)",
+				latency.Metric, joinSelectors(latency.Selector, fmt.Sprintf(`le="%s"`, latency.Threshold)), window,
+				latency.Metric, latency.Selector, window,
+			)
+		}, nil
+	default:
+		return nil, fmt.Errorf("either availability or latency is required")
+	}
+}
+
+// scaleBurnRates scales the factors of burn rates meant for the default window
+// to another window, so each alert still fires once the same share of the
+// error budget is spent.
+func scaleBurnRates(burnRates []BurnRate, window time.Duration) []BurnRate {
+	defaultDuration, _ := parseWindow(defaultWindow)
+
+	scaled := make([]BurnRate, 0, len(burnRates))
+	for _, burnRate := range burnRates {
+		burnRate.Factor = roundFloat(burnRate.Factor * float64(window) / float64(defaultDuration))
+		scaled = append(scaled, burnRate)
+	}
+
+	return scaled
+}
+
+// roundFloat gets rid of floating point noise, such as 1 - 0.999 being
+// 0.0010000000000000009.
+func roundFloat(f float64) float64 {
+	return math.Round(f*1e12) / 1e12
+}
+
+// collectWindows lists every window used by the burn rates, shortest first,
+// so each error ratio is recorded once. Windows are expected to be valid.
+func collectWindows(burnRates []BurnRate) []string {
+	seen := make(map[string]bool)
+	var windows []string
+	for _, burnRate := range burnRates {
+		for _, window := range []string{burnRate.ShortWindow, burnRate.LongWindow} {
+			if !seen[window] {
+				seen[window] = true
+				windows = append(windows, window)
+			}
+		}
+	}
+
+	sort.SliceStable(windows, func(i, j int) bool {
+		durationI, _ := parseWindow(windows[i])
+		durationJ, _ := parseWindow(windows[j])
+		return durationI < durationJ
+	})
+
+	return windows
+}
+
+// parseWindow also understands the day unit of Prometheus durations, which
+// time.ParseDuration does not.
+func parseWindow(window string) (time.Duration, error) {
+	if strings.HasSuffix(window, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(window, "d"))
+		if err != nil {
+			return 0, fmt.Errorf("invalid window %s", window)
+		}
+
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+
+	duration, err := time.ParseDuration(window)
+	if err != nil {
+		return 0, fmt.Errorf("invalid window %s", window)
+	}
+
+	return duration, nil
+}
+
+func joinSelectors(selectors ...string) string {
+	var nonEmpty []string
+	for _, selector := range selectors {
+		if selector != "" {
+			nonEmpty = append(nonEmpty, selector)
+		}
+	}
+
+	return strings.Join(nonEmpty, ", ")
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
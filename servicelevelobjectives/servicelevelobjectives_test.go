@@ -0,0 +1,166 @@
+package main_test
+
+import (
+	"bytes"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/servicelevelobjectives"
+)
+
+type prometheusRule struct {
+	Kind     string `json:"kind"`
+	Metadata struct {
+		Name      string `json:"name"`
+		Namespace string `json:"namespace"`
+	} `json:"metadata"`
+	Spec struct {
+		Groups []struct {
+			Name  string `json:"name"`
+			Rules []struct {
+				Record string            `json:"record"`
+				Alert  string            `json:"alert"`
+				Expr   string            `json:"expr"`
+				Labels map[string]string `json:"labels"`
+			} `json:"rules"`
+		} `json:"groups"`
+	} `json:"spec"`
+}
+
+var _ = ginkgo.Describe("ServiceLevelObjectives", func() {
+	ginkgo.It("generates recording and multiwindow multi-burn-rate alerting rules", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ServiceLevelObjectives
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  objectives:
+    - name: availability
+      target: 99.9
+      availability:
+        metric: http_requests_total
+        selector: service="my-app"
+        errorSelector: code=~"5.."
+    - name: latency
+      target: 99
+      latency:
+        metric: http_request_duration_seconds
+        selector: service="my-app"
+        threshold: "0.3"
+`), &out)).To(g.Succeed())
+
+		var rule prometheusRule
+		g.Expect(yaml.Unmarshal(out.Bytes(), &rule)).To(g.Succeed())
+		g.Expect(rule.Kind).To(g.Equal("PrometheusRule"))
+		g.Expect(rule.Metadata.Namespace).To(g.Equal("my-namespace"))
+		g.Expect(rule.Spec.Groups).To(g.HaveLen(2))
+
+		availability := rule.Spec.Groups[0]
+		g.Expect(availability.Name).To(g.Equal("slo-my-app-availability"))
+		g.Expect(availability.Rules).To(g.HaveLen(11))
+
+		var records []string
+		for _, r := range availability.Rules[:7] {
+			records = append(records, r.Record)
+		}
+		g.Expect(records).To(g.Equal([]string{
+			"slo:sli_error:ratio_rate5m",
+			"slo:sli_error:ratio_rate30m",
+			"slo:sli_error:ratio_rate1h",
+			"slo:sli_error:ratio_rate2h",
+			"slo:sli_error:ratio_rate6h",
+			"slo:sli_error:ratio_rate1d",
+			"slo:sli_error:ratio_rate3d",
+		}))
+		g.Expect(availability.Rules[0].Expr).To(g.Equal("sum(rate(http_requests_total{service=\"my-app\", code=~\"5..\"}[5m]))\n/\nsum(rate(http_requests_total{service=\"my-app\"}[5m]))"))
+
+		alert := availability.Rules[7]
+		g.Expect(alert.Alert).To(g.Equal("SLOErrorBudgetBurn"))
+		g.Expect(alert.Labels).To(g.Equal(map[string]string{
+			"slo":         "availability",
+			"service":     "my-app",
+			"severity":    "page",
+			"long_window": "1h",
+		}))
+		g.Expect(alert.Expr).To(g.Equal("slo:sli_error:ratio_rate1h{slo=\"availability\", service=\"my-app\"} > (14.4 * 0.001)\nand\nslo:sli_error:ratio_rate5m{slo=\"availability\", service=\"my-app\"} > (14.4 * 0.001)"))
+
+		latency := rule.Spec.Groups[1]
+		g.Expect(latency.Rules[0].Expr).To(g.ContainSubstring(`http_request_duration_seconds_bucket{service="my-app", le="0.3"}[5m]`))
+		g.Expect(latency.Rules[7].Expr).To(g.ContainSubstring("> (14.4 * 0.01)"))
+	})
+
+	ginkgo.It("scales the default burn rates to the window", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ServiceLevelObjectives
+metadata:
+  name: my-app
+spec:
+  objectives:
+    - name: availability
+      target: 99.9
+      window: 28d
+      availability: {metric: http_requests_total, errorSelector: code=~"5.."}
+`), &out)).To(g.Succeed())
+
+		var rule prometheusRule
+		g.Expect(yaml.Unmarshal(out.Bytes(), &rule)).To(g.Succeed())
+
+		g.Expect(rule.Spec.Groups[0].Rules[7].Expr).To(g.ContainSubstring("> (13.44 * 0.001)"))
+		g.Expect(rule.Spec.Groups[0].Rules[8].Expr).To(g.ContainSubstring("> (5.6 * 0.001)"))
+		g.Expect(rule.Spec.Groups[0].Rules[9].Expr).To(g.ContainSubstring("> (2.8 * 0.001)"))
+		g.Expect(rule.Spec.Groups[0].Rules[10].Expr).To(g.ContainSubstring("> (0.933333333333 * 0.001)"))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ServiceLevelObjectives
+metadata:
+  name: my-app
+spec:
+`+spec), &out)).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without objectives", "  service: my-app\n", "spec.objectives is empty"),
+		ginkgo.Entry("with invalid target", `
+  objectives:
+    - name: availability
+      target: 100
+      availability: {metric: http_requests_total, errorSelector: code=~"5.."}
+`, "objective availability: target must be between 0 and 100"),
+		ginkgo.Entry("without indicator", `
+  objectives:
+    - name: availability
+      target: 99.9
+`, "objective availability: either availability or latency is required"),
+		ginkgo.Entry("with invalid burn rate window", `
+  objectives:
+    - name: availability
+      target: 99.9
+      availability: {metric: http_requests_total, errorSelector: code=~"5.."}
+  burnRates:
+    - {longWindow: 1w, shortWindow: 1h, factor: 1, severity: ticket}
+`, "spec.burnRates[0]: invalid window 1w"),
+		ginkgo.Entry("with invalid window", `
+  objectives:
+    - name: availability
+      target: 99.9
+      window: 1w
+      availability: {metric: http_requests_total, errorSelector: code=~"5.."}
+`, "objective availability: window: invalid window 1w"),
+		ginkgo.Entry("with burn rate windows longer than the window", `
+  objectives:
+    - name: availability
+      target: 99.9
+      window: 1d
+      availability: {metric: http_requests_total, errorSelector: code=~"5.."}
+`, "objective availability: burn rate window 3d is longer than the window 1d"),
+	)
+})
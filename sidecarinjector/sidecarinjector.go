@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	defaultAnnotation = "incognia.com/sidecars"
+	cronJobKind       = "CronJob"
+	sidecarSeparator  = ","
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+)
+
+type SidecarInjector struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Annotation string    `json:"annotation,omitempty"`
+	Kinds      []string  `json:"kinds,omitempty"`
+	Sidecars   []Sidecar `json:"sidecars,omitempty"`
+}
+
+type Sidecar struct {
+	Name         string               `json:"name,omitempty"`
+	Container    corev1.Container     `json:"container,omitempty"`
+	Volumes      []corev1.Volume      `json:"volumes,omitempty"`
+	Env          []corev1.EnvVar      `json:"env,omitempty"`
+	VolumeMounts []corev1.VolumeMount `json:"volumeMounts,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var sidecarInjector SidecarInjector
+	if err := pluginconfig.Unmarshal(data, &sidecarInjector); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&sidecarInjector, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(sidecarInjector *SidecarInjector, nodes []*kyaml.RNode) error {
+	spec := &sidecarInjector.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	annotation := spec.Annotation
+	if annotation == "" {
+		annotation = defaultAnnotation
+	}
+
+	sidecars := make(map[string]*Sidecar, len(spec.Sidecars))
+	for i := range spec.Sidecars {
+		sidecar := &spec.Sidecars[i]
+		if sidecar.Name == "" {
+			return fmt.Errorf("spec.sidecars[%d].name is empty", i)
+		}
+
+		if sidecar.Container.Name == "" {
+			sidecar.Container.Name = sidecar.Name
+		}
+
+		sidecars[sidecar.Name] = sidecar
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+		if template == nil {
+			continue
+		}
+
+		value := template.GetAnnotations()[annotation]
+		if value == "" {
+			value = node.GetAnnotations()[annotation]
+		}
+		if value == "" {
+			continue
+		}
+
+		for _, name := range strings.Split(value, sidecarSeparator) {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+
+			sidecar, exists := sidecars[name]
+			if !exists {
+				return fmt.Errorf("%s %s: unknown sidecar %s", node.GetKind(), node.GetName(), name)
+			}
+
+			if err := inject(template, sidecar); err != nil {
+				return fmt.Errorf("%s %s: %w", node.GetKind(), node.GetName(), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// inject wires the sidecar's env and volume mounts into the existing
+// containers before appending the sidecar itself, so it never receives them.
+func inject(template *kyaml.RNode, sidecar *Sidecar) error {
+	containers, err := template.Pipe(kyaml.LookupCreate(kyaml.SequenceNode, "spec", "containers"))
+	if err != nil {
+		return err
+	}
+
+	names, err := containers.ElementValues("name")
+	if err != nil {
+		return err
+	}
+	if containsString(names, sidecar.Container.Name) {
+		return nil
+	}
+
+	elements, err := containers.Elements()
+	if err != nil {
+		return err
+	}
+
+	for _, container := range elements {
+		for _, env := range sidecar.Env {
+			if err := appendElement(container, []string{"env"}, "name", env.Name, env); err != nil {
+				return err
+			}
+		}
+
+		for _, volumeMount := range sidecar.VolumeMounts {
+			if err := appendElement(container, []string{"volumeMounts"}, "mountPath", volumeMount.MountPath, volumeMount); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, volume := range sidecar.Volumes {
+		if err := appendElement(template, []string{"spec", "volumes"}, "name", volume.Name, volume); err != nil {
+			return err
+		}
+	}
+
+	return appendElement(template, []string{"spec", "containers"}, "name", sidecar.Container.Name, sidecar.Container)
+}
+
+// appendElement appends v to the list at path unless an element with the same
+// key is already there.
+func appendElement(node *kyaml.RNode, path []string, key string, value string, v interface{}) error {
+	list, err := node.Pipe(kyaml.LookupCreate(kyaml.SequenceNode, path...))
+	if err != nil {
+		return err
+	}
+
+	values, err := list.ElementValues(key)
+	if err != nil {
+		return err
+	}
+	if containsString(values, value) {
+		return nil
+	}
+
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	element, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return list.PipeE(kyaml.Append(element.YNode()))
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,137 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/sidecarinjector"
+)
+
+const (
+	config = `
+apiVersion: incognia.com/v1alpha1
+kind: SidecarInjector
+metadata:
+  name: sidecar-injector
+spec:
+  sidecars:
+    - name: cloudsql-proxy
+      container:
+        image: gcr.io/cloudsql-docker/gce-proxy:1.28.0
+        args:
+          - --structured-logs
+      env:
+        - name: DB_HOST
+          value: 127.0.0.1
+    - name: fluent-bit
+      container:
+        image: fluent/fluent-bit:1.8.12
+        volumeMounts:
+          - name: logs
+            mountPath: /var/log/app
+      volumes:
+        - name: logs
+          emptyDir: {}
+      volumeMounts:
+        - name: logs
+          mountPath: /var/log/app
+`
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: with-sidecars
+spec:
+  template:
+    metadata:
+      annotations:
+        incognia.com/sidecars: cloudsql-proxy, fluent-bit
+    spec:
+      containers:
+        - name: app
+          env:
+            - name: DB_HOST
+              value: db.internal
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: without-sidecars
+spec:
+  template:
+    spec:
+      containers:
+        - name: app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("SidecarInjector", func() {
+	ginkgo.It("injects sidecars into annotated workloads", func() {
+		deployments := transform(config, resources)
+
+		podSpec := deployments["with-sidecars"].Spec.Template.Spec
+		g.Expect(podSpec.Containers).To(g.HaveLen(3))
+		g.Expect(podSpec.Containers[1].Name).To(g.Equal("cloudsql-proxy"))
+		g.Expect(podSpec.Containers[2].Name).To(g.Equal("fluent-bit"))
+		g.Expect(podSpec.Containers[2].VolumeMounts).To(g.HaveLen(1))
+		g.Expect(podSpec.Volumes).To(g.ConsistOf(corev1.Volume{
+			Name: "logs",
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		}))
+
+		app := podSpec.Containers[0]
+		g.Expect(app.Env).To(g.ConsistOf(corev1.EnvVar{
+			Name:  "DB_HOST",
+			Value: "db.internal",
+		}))
+		g.Expect(app.VolumeMounts).To(g.ConsistOf(corev1.VolumeMount{
+			Name:      "logs",
+			MountPath: "/var/log/app",
+		}))
+
+		g.Expect(deployments["without-sidecars"].Spec.Template.Spec.Containers).To(g.HaveLen(1))
+	})
+
+	ginkgo.It("is idempotent", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		deployments := transform(config, out.String())
+		g.Expect(deployments["with-sidecars"].Spec.Template.Spec.Containers).To(g.HaveLen(3))
+		g.Expect(deployments["with-sidecars"].Spec.Template.Spec.Volumes).To(g.HaveLen(1))
+	})
+
+	ginkgo.It("fails on unknown sidecars", func() {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(config), strings.NewReader(strings.Replace(resources, "fluent-bit", "envoy", 1)), &out)).
+			To(g.MatchError("Deployment with-sidecars: unknown sidecar envoy"))
+	})
+})
+
+func transform(config string, resources string) map[string]appsv1.Deployment {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	deployments := make(map[string]appsv1.Deployment)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+		deployments[deployment.Name] = deployment
+	}
+
+	return deployments
+}
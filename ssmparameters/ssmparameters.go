@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	defaultKeyTemplate = "{{ .Base }}"
+)
+
+type SSMParameters struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Path          string `json:"path,omitempty"`
+	Recursive     bool   `json:"recursive,omitempty"`
+	KeyTemplate   string `json:"keyTemplate,omitempty"`
+	ConfigMapName string `json:"configMapName,omitempty"`
+	SecretName    string `json:"secretName,omitempty"`
+}
+
+var keyTemplateFuncs = template.FuncMap{
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+	"replace": func(old string, new string, s string) string {
+		return strings.ReplaceAll(s, old, new)
+	},
+}
+
+type KeyTemplateData struct {
+	Name     string
+	Relative string
+	Base     string
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	cfg, err := config.LoadDefaultConfig(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return GenerateManifestsWithClient(ssm.NewFromConfig(cfg), data, out)
+}
+
+func GenerateManifestsWithClient(client ssm.GetParametersByPathAPIClient, data []byte, out io.Writer) error {
+	var ssmParameters SSMParameters
+	if err := pluginconfig.Unmarshal(data, &ssmParameters); err != nil {
+		return err
+	}
+
+	parameters, err := getParameters(client, &ssmParameters)
+	if err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&ssmParameters, parameters)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func getParameters(client ssm.GetParametersByPathAPIClient, ssmParameters *SSMParameters) ([]ssmtypes.Parameter, error) {
+	if ssmParameters.Spec.Path == "" {
+		return nil, fmt.Errorf("spec.path is empty")
+	}
+
+	paginator := ssm.NewGetParametersByPathPaginator(client, &ssm.GetParametersByPathInput{
+		Path:           aws.String(ssmParameters.Spec.Path),
+		Recursive:      aws.Bool(ssmParameters.Spec.Recursive),
+		WithDecryption: aws.Bool(true),
+	})
+
+	var parameters []ssmtypes.Parameter
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		parameters = append(parameters, page.Parameters...)
+	}
+
+	sort.Slice(parameters, func(i, j int) bool {
+		return aws.ToString(parameters[i].Name) < aws.ToString(parameters[j].Name)
+	})
+
+	return parameters, nil
+}
+
+func makeManifests(ssmParameters *SSMParameters, parameters []ssmtypes.Parameter) ([][]byte, error) {
+	keyTemplate := ssmParameters.Spec.KeyTemplate
+	if keyTemplate == "" {
+		keyTemplate = defaultKeyTemplate
+	}
+
+	tmpl, err := template.New("keyTemplate").Option("missingkey=error").Funcs(keyTemplateFuncs).Parse(keyTemplate)
+	if err != nil {
+		return nil, err
+	}
+
+	plainData := make(map[string]string)
+	secureData := make(map[string][]byte)
+	for _, parameter := range parameters {
+		name := aws.ToString(parameter.Name)
+
+		key, err := makeKey(tmpl, ssmParameters.Spec.Path, name)
+		if err != nil {
+			return nil, err
+		}
+
+		_, plainExists := plainData[key]
+		_, secureExists := secureData[key]
+		if plainExists || secureExists {
+			return nil, fmt.Errorf("parameter %s maps to duplicated key %s", name, key)
+		}
+
+		switch parameter.Type {
+		case ssmtypes.ParameterTypeSecureString:
+			secureData[key] = []byte(aws.ToString(parameter.Value))
+		default:
+			plainData[key] = aws.ToString(parameter.Value)
+		}
+	}
+
+	var manifests [][]byte
+
+	if len(plainData) > 0 {
+		configMap, err := makeConfigMap(ssmParameters, plainData)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, configMap)
+	}
+
+	if len(secureData) > 0 {
+		secret, err := makeSecret(ssmParameters, secureData)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, secret)
+	}
+
+	return manifests, nil
+}
+
+func makeKey(tmpl *template.Template, prefix string, name string) (string, error) {
+	relative := strings.TrimPrefix(strings.TrimPrefix(name, prefix), "/")
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, KeyTemplateData{
+		Name:     name,
+		Relative: relative,
+		Base:     path.Base(name),
+	}); err != nil {
+		return "", err
+	}
+
+	key := sb.String()
+	if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+		return "", fmt.Errorf("parameter %s maps to invalid key '%s': %s", name, key, strings.Join(errs, ", "))
+	}
+
+	return key, nil
+}
+
+func makeObjectMeta(ssmParameters *SSMParameters, name string) metav1.ObjectMeta {
+	objectMeta := *ssmParameters.ObjectMeta.DeepCopy()
+	if name != "" {
+		objectMeta.Name = name
+	}
+
+	return objectMeta
+}
+
+func makeConfigMap(ssmParameters *SSMParameters, data map[string]string) ([]byte, error) {
+	configMap := corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.ConfigMap{}).Name(),
+		},
+		ObjectMeta: makeObjectMeta(ssmParameters, ssmParameters.Spec.ConfigMapName),
+		Data:       data,
+	}
+
+	return yaml.Marshal(configMap)
+}
+
+func makeSecret(ssmParameters *SSMParameters, data map[string][]byte) ([]byte, error) {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Secret{}).Name(),
+		},
+		ObjectMeta: makeObjectMeta(ssmParameters, ssmParameters.Spec.SecretName),
+		Type:       corev1.SecretTypeOpaque,
+		Data:       data,
+	}
+
+	return yaml.Marshal(secret)
+}
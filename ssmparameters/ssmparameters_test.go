@@ -0,0 +1,176 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/ssmparameters"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+
+	configMapGVK = corev1.SchemeGroupVersion.WithKind(reflect.TypeOf(corev1.ConfigMap{}).Name())
+	secretGVK    = corev1.SchemeGroupVersion.WithKind(reflect.TypeOf(corev1.Secret{}).Name())
+)
+
+type fakeClient []ssmtypes.Parameter
+
+func (c fakeClient) GetParametersByPath(_ context.Context, input *ssm.GetParametersByPathInput, _ ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	prefix := aws.ToString(input.Path)
+
+	var parameters []ssmtypes.Parameter
+	for _, parameter := range c {
+		name := aws.ToString(parameter.Name)
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if !aws.ToBool(input.Recursive) && strings.Contains(strings.TrimPrefix(name, prefix), "/") {
+			continue
+		}
+
+		parameters = append(parameters, parameter)
+	}
+
+	return &ssm.GetParametersByPathOutput{
+		Parameters: parameters,
+	}, nil
+}
+
+var parameters = fakeClient{
+	ssmtypes.Parameter{
+		Name:  aws.String("/my-app/production/host"),
+		Type:  ssmtypes.ParameterTypeString,
+		Value: aws.String("db.example.com"),
+	},
+	ssmtypes.Parameter{
+		Name:  aws.String("/my-app/production/password"),
+		Type:  ssmtypes.ParameterTypeSecureString,
+		Value: aws.String("hunter2"),
+	},
+	ssmtypes.Parameter{
+		Name:  aws.String("/my-app/production/cache/host"),
+		Type:  ssmtypes.ParameterTypeString,
+		Value: aws.String("cache.example.com"),
+	},
+}
+
+var _ = ginkgo.Describe("SSMParameters", func() {
+	ginkgo.DescribeTable("", SSMParameters,
+		ginkgo.Entry("without recursion",
+			makeSSMParameters(main.Spec{
+				Path: "/my-app/production/",
+			}),
+			map[string]string{
+				"host": "db.example.com",
+			},
+			map[string][]byte{
+				"password": []byte("hunter2"),
+			},
+		),
+		ginkgo.Entry("with recursion and key template",
+			makeSSMParameters(main.Spec{
+				Path:          "/my-app/production",
+				Recursive:     true,
+				KeyTemplate:   `{{ .Relative | replace "/" "." }}`,
+				ConfigMapName: "my-app-config",
+				SecretName:    "my-app-secret",
+			}),
+			map[string]string{
+				"host":       "db.example.com",
+				"cache.host": "cache.example.com",
+			},
+			map[string][]byte{
+				"password": []byte("hunter2"),
+			},
+		),
+	)
+
+	ginkgo.It("fails on duplicated keys", func() {
+		data, err := yaml.Marshal(makeSSMParameters(main.Spec{
+			Path:      "/my-app/production/",
+			Recursive: true,
+		}))
+		g.Expect(err).To(g.BeNil())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifestsWithClient(parameters, data, &out)).To(g.MatchError(g.ContainSubstring("duplicated key host")))
+	})
+})
+
+func SSMParameters(ssmParameters main.SSMParameters, expectedConfigMapData map[string]string, expectedSecretData map[string][]byte) {
+	var ssmParametersYaml []byte
+	if data, err := yaml.Marshal(ssmParameters); g.Expect(err).To(g.BeNil()) {
+		ssmParametersYaml = data
+	}
+
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifestsWithClient(parameters, ssmParametersYaml, &out)).To(g.Succeed())
+
+	var configMap corev1.ConfigMap
+	var secret corev1.Secret
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var meta metav1.TypeMeta
+		g.Expect(yaml.Unmarshal([]byte(manifest), &meta)).To(g.Succeed())
+
+		switch meta.GroupVersionKind() {
+		case configMapGVK:
+			g.Expect(yaml.Unmarshal([]byte(manifest), &configMap)).To(g.Succeed())
+		case secretGVK:
+			g.Expect(yaml.Unmarshal([]byte(manifest), &secret)).To(g.Succeed())
+		default:
+			ginkgo.Fail("unexpected GVK")
+		}
+	}
+
+	ginkgo.By("contains expected ConfigMap", func() {
+		expectedName := ssmParameters.Spec.ConfigMapName
+		if expectedName == "" {
+			expectedName = ssmParameters.Name
+		}
+
+		g.Expect(configMap.Name).To(g.Equal(expectedName))
+		g.Expect(configMap.Data).To(g.Equal(expectedConfigMapData))
+	})
+
+	ginkgo.By("contains expected Secret", func() {
+		expectedName := ssmParameters.Spec.SecretName
+		if expectedName == "" {
+			expectedName = ssmParameters.Name
+		}
+
+		g.Expect(secret.Name).To(g.Equal(expectedName))
+		g.Expect(secret.Type).To(g.Equal(corev1.SecretTypeOpaque))
+		g.Expect(secret.Data).To(g.Equal(expectedSecretData))
+	})
+}
+
+func makeSSMParameters(spec main.Spec) main.SSMParameters {
+	return main.SSMParameters{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   "incognia.com",
+				Version: "v1alpha1",
+			}.String(),
+			Kind: "SSMParameters",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-app",
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-standard-labels"
+
+	defaultServiceLabel = "incognia.com/service"
+
+	nameLabel      = "app.kubernetes.io/name"
+	instanceLabel  = "app.kubernetes.io/instance"
+	versionLabel   = "app.kubernetes.io/version"
+	partOfLabel    = "app.kubernetes.io/part-of"
+	managedByLabel = "app.kubernetes.io/managed-by"
+
+	serviceKind = "Service"
+	cronJobKind = "CronJob"
+)
+
+var (
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podTemplatePath = []string{
+		"spec",
+		"template",
+	}
+
+	cronJobPodTemplatePath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+	}
+
+	workloadSelectorPath = []string{
+		"spec",
+		"selector",
+		"matchLabels",
+	}
+
+	serviceSelectorPath = []string{
+		"spec",
+		"selector",
+	}
+)
+
+type StandardLabels struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Name         string   `json:"name,omitempty"`
+	Instance     string   `json:"instance,omitempty"`
+	PartOf       string   `json:"partOf,omitempty"`
+	ManagedBy    string   `json:"managedBy,omitempty"`
+	ServiceLabel string   `json:"serviceLabel,omitempty"`
+	Kinds        []string `json:"kinds,omitempty"`
+	Selectors    bool     `json:"selectors,omitempty"`
+	Override     bool     `json:"override,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var standardLabels StandardLabels
+	if err := pluginconfig.Unmarshal(data, &standardLabels); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&standardLabels, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(standardLabels *StandardLabels, nodes []*kyaml.RNode) error {
+	spec := &standardLabels.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	serviceLabel := spec.ServiceLabel
+	if serviceLabel == "" {
+		serviceLabel = defaultServiceLabel
+	}
+
+	for _, node := range nodes {
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		name := spec.Name
+		if name == "" {
+			name = node.GetLabels()[serviceLabel]
+		}
+
+		instance := spec.Instance
+		if instance == "" {
+			instance = name
+		}
+
+		// Only labels that do not change between releases are safe for
+		// selectors, which are immutable in workloads.
+		selectorLabels := map[string]string{
+			nameLabel:     name,
+			instanceLabel: instance,
+		}
+
+		labels := map[string]string{
+			nameLabel:      name,
+			instanceLabel:  instance,
+			partOfLabel:    spec.PartOf,
+			managedByLabel: spec.ManagedBy,
+		}
+
+		if node.GetKind() == serviceKind {
+			if err := setLabels(node, labels, spec.Override); err != nil {
+				return err
+			}
+
+			if spec.Selectors {
+				if err := setSelector(node, serviceSelectorPath, selectorLabels); err != nil {
+					return err
+				}
+			}
+
+			continue
+		}
+
+		if !containsString(kinds, node.GetKind()) {
+			if err := setLabels(node, labels, spec.Override); err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		path := podTemplatePath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodTemplatePath
+		}
+
+		template, err := node.Pipe(kyaml.Lookup(path...))
+		if err != nil {
+			return err
+		}
+
+		if template != nil {
+			version, err := readVersion(template)
+			if err != nil {
+				return err
+			}
+			labels[versionLabel] = version
+		}
+
+		if err := setLabels(node, labels, spec.Override); err != nil {
+			return err
+		}
+
+		if template == nil {
+			continue
+		}
+
+		if err := setLabels(template, labels, spec.Override); err != nil {
+			return err
+		}
+
+		if spec.Selectors && node.GetKind() != cronJobKind {
+			if err := setSelector(node, workloadSelectorPath, selectorLabels); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func setLabels(node *kyaml.RNode, labels map[string]string, override bool) error {
+	current := node.GetLabels()
+
+	for _, key := range sortedKeys(labels) {
+		if labels[key] == "" || len(validation.IsValidLabelValue(labels[key])) > 0 {
+			continue
+		}
+
+		if _, exists := current[key]; exists && !override {
+			continue
+		}
+
+		if err := node.PipeE(kyaml.SetLabel(key, labels[key])); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// setSelector adds labels to an existing selector only, as creating a selector
+// would change which pods are selected instead of narrowing it down.
+func setSelector(node *kyaml.RNode, path []string, labels map[string]string) error {
+	selector, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if selector == nil {
+		return nil
+	}
+
+	for _, key := range sortedKeys(labels) {
+		if labels[key] == "" {
+			continue
+		}
+
+		current, err := selector.Pipe(kyaml.Lookup(key))
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			continue
+		}
+
+		if err := selector.PipeE(kyaml.SetField(key, kyaml.NewStringRNode(labels[key]))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readVersion returns the image tag of the first container of a pod template.
+func readVersion(template *kyaml.RNode) (string, error) {
+	containers, err := template.Pipe(kyaml.Lookup("spec", "containers"))
+	if err != nil {
+		return "", err
+	}
+	if containers == nil {
+		return "", nil
+	}
+
+	elements, err := containers.Elements()
+	if err != nil {
+		return "", err
+	}
+	if len(elements) == 0 {
+		return "", nil
+	}
+
+	image, err := elements[0].Pipe(kyaml.Lookup("image"))
+	if err != nil {
+		return "", err
+	}
+	if image == nil {
+		return "", nil
+	}
+
+	return imageTag(kyaml.GetValue(image)), nil
+}
+
+// imageTag returns the tag of an image reference, ignoring its digest and the
+// port of its registry.
+func imageTag(image string) string {
+	image = strings.SplitN(image, "@", 2)[0]
+
+	i := strings.LastIndex(image, ":")
+	if i == -1 || strings.Contains(image[i:], "/") {
+		return ""
+	}
+
+	return image[i+1:]
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
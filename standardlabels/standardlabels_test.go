@@ -0,0 +1,156 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/standardlabels"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  labels:
+    incognia.com/service: my-app
+spec:
+  selector:
+    matchLabels:
+      app: my-app
+  template:
+    metadata:
+      labels:
+        app: my-app
+    spec:
+      containers:
+        - name: app
+          image: registry.example.com:5000/my-app:1.2.3@sha256:0123456789abcdef
+        - name: proxy
+          image: envoy:v1.20
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: my-app
+  labels:
+    incognia.com/service: my-app
+spec:
+  selector:
+    app: my-app
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: my-app
+  labels:
+    incognia.com/service: my-app
+    app.kubernetes.io/instance: custom
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unrelated
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: skipped
+  labels:
+    incognia.com/service: my-app
+  annotations:
+    incognia.com/skip-standard-labels: "true"
+`
+)
+
+var _ = ginkgo.Describe("StandardLabels", func() {
+	transform := func(spec string) map[string]*kyaml.RNode {
+		var out bytes.Buffer
+		g.Expect(main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: StandardLabels
+metadata:
+  name: standard-labels
+spec:
+  partOf: my-project
+`+spec), strings.NewReader(resources), &out)).To(g.Succeed())
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		byKindAndName := make(map[string]*kyaml.RNode)
+		for _, node := range nodes {
+			byKindAndName[node.GetKind()+"/"+node.GetName()] = node
+		}
+
+		return byKindAndName
+	}
+
+	lookup := func(node *kyaml.RNode, path ...string) map[string]string {
+		field, err := node.Pipe(kyaml.Lookup(path...))
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		m := make(map[string]string)
+		g.Expect(field.YNode().Decode(&m)).To(g.Succeed())
+		return m
+	}
+
+	ginkgo.It("labels resources and pod templates", func() {
+		nodes := transform("")
+
+		labels := map[string]string{
+			"incognia.com/service":       "my-app",
+			"app.kubernetes.io/name":     "my-app",
+			"app.kubernetes.io/instance": "my-app",
+			"app.kubernetes.io/version":  "1.2.3",
+			"app.kubernetes.io/part-of":  "my-project",
+		}
+		g.Expect(nodes["Deployment/my-app"].GetLabels()).To(g.Equal(labels))
+		g.Expect(lookup(nodes["Deployment/my-app"], "spec", "template", "metadata", "labels")).To(g.Equal(map[string]string{
+			"app":                        "my-app",
+			"app.kubernetes.io/name":     "my-app",
+			"app.kubernetes.io/instance": "my-app",
+			"app.kubernetes.io/version":  "1.2.3",
+			"app.kubernetes.io/part-of":  "my-project",
+		}))
+		g.Expect(lookup(nodes["Deployment/my-app"], "spec", "selector", "matchLabels")).To(g.Equal(map[string]string{
+			"app": "my-app",
+		}))
+
+		g.Expect(nodes["Service/my-app"].GetLabels()).NotTo(g.HaveKey("app.kubernetes.io/version"))
+		g.Expect(nodes["Service/my-app"].GetLabels()).To(g.HaveKeyWithValue("app.kubernetes.io/name", "my-app"))
+		g.Expect(lookup(nodes["Service/my-app"], "spec", "selector")).To(g.Equal(map[string]string{
+			"app": "my-app",
+		}))
+
+		g.Expect(nodes["ConfigMap/my-app"].GetLabels()).To(g.HaveKeyWithValue("app.kubernetes.io/instance", "custom"))
+		g.Expect(nodes["ConfigMap/unrelated"].GetLabels()).To(g.Equal(map[string]string{
+			"app.kubernetes.io/part-of": "my-project",
+		}))
+		g.Expect(nodes["ConfigMap/skipped"].GetLabels()).To(g.Equal(map[string]string{
+			"incognia.com/service": "my-app",
+		}))
+	})
+
+	ginkgo.It("adds stable labels to selectors", func() {
+		nodes := transform("  name: checkout\n  instance: checkout-blue\n  selectors: true\n  override: true\n")
+
+		selector := map[string]string{
+			"app":                        "my-app",
+			"app.kubernetes.io/name":     "checkout",
+			"app.kubernetes.io/instance": "checkout-blue",
+		}
+		g.Expect(lookup(nodes["Deployment/my-app"], "spec", "selector", "matchLabels")).To(g.Equal(selector))
+		g.Expect(lookup(nodes["Service/my-app"], "spec", "selector")).To(g.Equal(selector))
+		g.Expect(nodes["ConfigMap/my-app"].GetLabels()).To(g.HaveKeyWithValue("app.kubernetes.io/instance", "checkout-blue"))
+	})
+})
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	syncWaveAnnotation  = "argocd.argoproj.io/sync-wave"
+	applicationKind     = "Application"
+	applicationSetKind  = "ApplicationSet"
+	defaultLabel        = "app.kubernetes.io/name"
+	rollingSyncStrategy = "RollingSync"
+	inOperator          = "In"
+	cycleSeparator      = " -> "
+
+	defaultRetryLimit       = 5
+	defaultRetryDuration    = "30s"
+	defaultRetryFactor      = 2
+	defaultRetryMaxDuration = "5m"
+)
+
+type SyncDependencies struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kind            string              `json:"kind,omitempty"`
+	Dependencies    map[string][]string `json:"dependencies,omitempty"`
+	StartWave       int                 `json:"startWave,omitempty"`
+	ApplicationSets []string            `json:"applicationSets,omitempty"`
+	Label           string              `json:"label,omitempty"`
+}
+
+// The types below mirror the progressive sync strategy of ApplicationSets,
+// which is newer than the Argo CD module this repository depends on.
+
+type strategy struct {
+	Type        string      `json:"type"`
+	RollingSync rollingSync `json:"rollingSync"`
+}
+
+type rollingSync struct {
+	Steps []step `json:"steps"`
+}
+
+type step struct {
+	MatchExpressions []matchExpression `json:"matchExpressions"`
+}
+
+type matchExpression struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var syncDependencies SyncDependencies
+	if err := pluginconfig.Unmarshal(data, &syncDependencies); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&syncDependencies, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(syncDependencies *SyncDependencies, nodes []*kyaml.RNode) error {
+	spec := &syncDependencies.Spec
+
+	if len(spec.Dependencies) == 0 {
+		return fmt.Errorf("spec.dependencies is empty")
+	}
+
+	kind := spec.Kind
+	if kind == "" {
+		kind = applicationKind
+	}
+
+	label := spec.Label
+	if label == "" {
+		label = defaultLabel
+	}
+
+	levels, err := makeLevels(spec.Dependencies)
+	if err != nil {
+		return err
+	}
+
+	found := make(map[string]bool)
+	for _, node := range nodes {
+		if node.GetKind() != kind {
+			continue
+		}
+
+		level, exists := levels[node.GetName()]
+		if !exists {
+			continue
+		}
+		found[node.GetName()] = true
+
+		if err := node.PipeE(kyaml.SetAnnotation(syncWaveAnnotation, strconv.Itoa(spec.StartWave+level))); err != nil {
+			return err
+		}
+
+		if kind == applicationKind && len(spec.Dependencies[node.GetName()]) > 0 {
+			if err := setRetry(node); err != nil {
+				return fmt.Errorf("%s %s: %w", kind, node.GetName(), err)
+			}
+		}
+	}
+
+	if len(spec.ApplicationSets) == 0 {
+		for _, name := range sortedKeys(levels) {
+			if !found[name] {
+				return fmt.Errorf("%s %s not found", kind, name)
+			}
+		}
+	}
+
+	for _, name := range spec.ApplicationSets {
+		var applicationSet *kyaml.RNode
+		for _, node := range nodes {
+			if node.GetKind() == applicationSetKind && node.GetName() == name {
+				applicationSet = node
+				break
+			}
+		}
+		if applicationSet == nil {
+			return fmt.Errorf("%s %s not found", applicationSetKind, name)
+		}
+
+		applicationSetSpec, err := applicationSet.Pipe(kyaml.LookupCreate(kyaml.MappingNode, "spec"))
+		if err != nil {
+			return err
+		}
+
+		if err := encodeField(applicationSetSpec, "strategy", makeStrategy(levels, label)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// makeLevels returns the position of each node in a topological order of the
+// graph, where nodes only depend on nodes of lower levels, so independent
+// nodes share a level and sync together.
+func makeLevels(dependencies map[string][]string) (map[string]int, error) {
+	levels := make(map[string]int)
+	visiting := make(map[string]bool)
+
+	var visit func(name string, path []string) (int, error)
+	visit = func(name string, path []string) (int, error) {
+		if level, exists := levels[name]; exists {
+			return level, nil
+		}
+
+		path = append(path, name)
+		if visiting[name] {
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			return 0, fmt.Errorf("dependency cycle: %s", strings.Join(path[start:], cycleSeparator))
+		}
+		visiting[name] = true
+
+		level := 0
+		for _, dependency := range dependencies[name] {
+			if dependency == "" {
+				return 0, fmt.Errorf("%s has an empty dependency", name)
+			}
+
+			l, err := visit(dependency, path)
+			if err != nil {
+				return 0, err
+			}
+
+			if l+1 > level {
+				level = l + 1
+			}
+		}
+
+		visiting[name] = false
+		levels[name] = level
+
+		return level, nil
+	}
+
+	names := make([]string, 0, len(dependencies))
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, err := visit(name, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return levels, nil
+}
+
+func makeStrategy(levels map[string]int, label string) *strategy {
+	var steps []step
+	for _, name := range sortedKeys(levels) {
+		level := levels[name]
+		for len(steps) <= level {
+			steps = append(steps, step{
+				MatchExpressions: []matchExpression{
+					{
+						Key:      label,
+						Operator: inOperator,
+					},
+				},
+			})
+		}
+
+		expression := &steps[level].MatchExpressions[0]
+		expression.Values = append(expression.Values, name)
+	}
+
+	return &strategy{
+		Type: rollingSyncStrategy,
+		RollingSync: rollingSync{
+			Steps: steps,
+		},
+	}
+}
+
+// setRetry makes Applications retry their syncs while their dependencies are
+// still progressing, unless they already define how to retry.
+func setRetry(node *kyaml.RNode) error {
+	syncPolicy, err := node.Pipe(kyaml.LookupCreate(kyaml.MappingNode, "spec", "syncPolicy"))
+	if err != nil {
+		return err
+	}
+	if syncPolicy.Field("retry") != nil {
+		return nil
+	}
+
+	factor := int64(defaultRetryFactor)
+
+	return encodeField(syncPolicy, "retry", argov1alpha1.RetryStrategy{
+		Limit: defaultRetryLimit,
+		Backoff: &argov1alpha1.Backoff{
+			Duration:    defaultRetryDuration,
+			Factor:      &factor,
+			MaxDuration: defaultRetryMaxDuration,
+		},
+	})
+}
+
+func encodeField(node *kyaml.RNode, field string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	value, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return node.PipeE(kyaml.SetField(field, value))
+}
+
+func sortedKeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
@@ -0,0 +1,173 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/syncdependencies"
+)
+
+const (
+	resources = `
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: database
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: queues
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: payments
+spec:
+  syncPolicy:
+    automated: {}
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Application
+metadata:
+  name: gateway
+spec:
+  syncPolicy:
+    retry:
+      limit: 1
+---
+apiVersion: argoproj.io/v1alpha1
+kind: ApplicationSet
+metadata:
+  name: services
+spec:
+  generators: []
+`
+
+	dependencies = `
+  dependencies:
+    payments: [database, queues]
+    gateway: [payments]
+`
+)
+
+var _ = ginkgo.Describe("SyncDependencies", func() {
+	transform := func(spec string) (map[string]*kyaml.RNode, error) {
+		var out bytes.Buffer
+		if err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: SyncDependencies
+metadata:
+  name: sync-dependencies
+spec:
+`+spec), strings.NewReader(resources), &out); err != nil {
+			return nil, err
+		}
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		byName := make(map[string]*kyaml.RNode)
+		for _, node := range nodes {
+			byName[node.GetName()] = node
+		}
+
+		return byName, nil
+	}
+
+	decode := func(node *kyaml.RNode, field string) interface{} {
+		var object map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(node.MustString()), &object)).To(g.Succeed())
+
+		return object["spec"].(map[string]interface{})[field]
+	}
+
+	ginkgo.It("orders Applications by their dependencies", func() {
+		nodes, err := transform(dependencies + "  startWave: 1\n")
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		for name, wave := range map[string]string{
+			"database": "1",
+			"queues":   "1",
+			"payments": "2",
+			"gateway":  "3",
+		} {
+			g.Expect(nodes[name].GetAnnotations()).To(g.HaveKeyWithValue("argocd.argoproj.io/sync-wave", wave))
+		}
+
+		g.Expect(nodes["database"].Field("spec")).To(g.BeNil())
+		g.Expect(decode(nodes["payments"], "syncPolicy")).To(g.Equal(map[string]interface{}{
+			"automated": map[string]interface{}{},
+			"retry": map[string]interface{}{
+				"limit": float64(5),
+				"backoff": map[string]interface{}{
+					"duration":    "30s",
+					"factor":      float64(2),
+					"maxDuration": "5m",
+				},
+			},
+		}))
+		g.Expect(decode(nodes["gateway"], "syncPolicy")).To(g.Equal(map[string]interface{}{
+			"retry": map[string]interface{}{
+				"limit": float64(1),
+			},
+		}))
+	})
+
+	ginkgo.It("sets progressive sync steps of ApplicationSets", func() {
+		nodes, err := transform(`
+  dependencies:
+    api: [database]
+    worker: [database]
+    database: []
+  applicationSets: [services]
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		g.Expect(decode(nodes["services"], "strategy")).To(g.Equal(map[string]interface{}{
+			"type": "RollingSync",
+			"rollingSync": map[string]interface{}{
+				"steps": []interface{}{
+					map[string]interface{}{
+						"matchExpressions": []interface{}{
+							map[string]interface{}{
+								"key":      "app.kubernetes.io/name",
+								"operator": "In",
+								"values":   []interface{}{"database"},
+							},
+						},
+					},
+					map[string]interface{}{
+						"matchExpressions": []interface{}{
+							map[string]interface{}{
+								"key":      "app.kubernetes.io/name",
+								"operator": "In",
+								"values":   []interface{}{"api", "worker"},
+							},
+						},
+					},
+				},
+			},
+		}))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := transform(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("without dependencies", "", "spec.dependencies is empty"),
+		ginkgo.Entry("with cycles", "  dependencies:\n    database: [payments]\n    payments: [queues]\n    queues: [database]\n", "dependency cycle: database -> payments -> queues -> database"),
+		ginkgo.Entry("with self dependencies", "  dependencies:\n    database: [database]\n", "dependency cycle: database -> database"),
+		ginkgo.Entry("with unknown Applications", "  dependencies:\n    payments: [cache]\n", "Application cache not found"),
+		ginkgo.Entry("with unknown ApplicationSets", dependencies+"  applicationSets: [workers]\n", "ApplicationSet workers not found"),
+	)
+})
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	syncWaveAnnotation = "argocd.argoproj.io/sync-wave"
+)
+
+type Class string
+
+const (
+	CRDs       Class = "crds"
+	Namespaces Class = "namespaces"
+	Config     Class = "config"
+	Workloads  Class = "workloads"
+	Post       Class = "post"
+)
+
+func (c Class) Validate() error {
+	switch c {
+	case CRDs, Namespaces, Config, Workloads, Post:
+		return nil
+	default:
+		return fmt.Errorf("unknown class %s", c)
+	}
+}
+
+var (
+	defaultWaves = map[Class]int{
+		CRDs:       -3,
+		Namespaces: -2,
+		Config:     -1,
+		Workloads:  0,
+		Post:       1,
+	}
+
+	defaultClasses = map[string]Class{
+		"CustomResourceDefinition": CRDs,
+		"Namespace":                Namespaces,
+		"ClusterRole":              Config,
+		"ClusterRoleBinding":       Config,
+		"ConfigMap":                Config,
+		"ExternalSecret":           Config,
+		"LimitRange":               Config,
+		"NetworkPolicy":            Config,
+		"PersistentVolume":         Config,
+		"PersistentVolumeClaim":    Config,
+		"PriorityClass":            Config,
+		"ResourceQuota":            Config,
+		"Role":                     Config,
+		"RoleBinding":              Config,
+		"SealedSecret":             Config,
+		"Secret":                   Config,
+		"ServiceAccount":           Config,
+		"StorageClass":             Config,
+		"Job":                      Post,
+	}
+)
+
+type SyncWaves struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Waves     map[Class]int    `json:"waves,omitempty"`
+	Classes   map[string]Class `json:"classes,omitempty"`
+	Overrides []Override       `json:"overrides,omitempty"`
+}
+
+type Override struct {
+	Kind      string `json:"kind,omitempty"`
+	Name      string `json:"name,omitempty"`
+	Namespace string `json:"namespace,omitempty"`
+	Wave      int    `json:"wave,omitempty"`
+}
+
+func (o *Override) Matches(node *kyaml.RNode) bool {
+	if o.Namespace != "" && o.Namespace != node.GetNamespace() {
+		return false
+	}
+
+	return o.Kind == node.GetKind() && o.Name == node.GetName()
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var syncWaves SyncWaves
+	if err := pluginconfig.Unmarshal(data, &syncWaves); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&syncWaves, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(syncWaves *SyncWaves, nodes []*kyaml.RNode) error {
+	spec := &syncWaves.Spec
+
+	waves := make(map[Class]int, len(defaultWaves))
+	for class, wave := range defaultWaves {
+		waves[class] = wave
+	}
+	for class, wave := range spec.Waves {
+		if err := class.Validate(); err != nil {
+			return fmt.Errorf("spec.waves: %w", err)
+		}
+		waves[class] = wave
+	}
+
+	classes := make(map[string]Class, len(defaultClasses))
+	for kind, class := range defaultClasses {
+		classes[kind] = class
+	}
+	for kind, class := range spec.Classes {
+		if err := class.Validate(); err != nil {
+			return fmt.Errorf("spec.classes: %s: %w", kind, err)
+		}
+		classes[kind] = class
+	}
+
+	for i, override := range spec.Overrides {
+		if override.Kind == "" || override.Name == "" {
+			return fmt.Errorf("spec.overrides[%d] requires kind and name", i)
+		}
+	}
+
+	matched := make([]bool, len(spec.Overrides))
+	for _, node := range nodes {
+		wave, overridden := 0, false
+		for i := range spec.Overrides {
+			override := &spec.Overrides[i]
+			if override.Matches(node) {
+				wave, overridden = override.Wave, true
+				matched[i] = true
+			}
+		}
+
+		if !overridden {
+			// Waves set on the resources themselves are overrides as well.
+			if _, exists := node.GetAnnotations()[syncWaveAnnotation]; exists {
+				continue
+			}
+
+			class, exists := classes[node.GetKind()]
+			if !exists {
+				class = Workloads
+			}
+			wave = waves[class]
+		}
+
+		if err := node.PipeE(kyaml.SetAnnotation(syncWaveAnnotation, strconv.Itoa(wave))); err != nil {
+			return err
+		}
+	}
+
+	for i, override := range spec.Overrides {
+		if !matched[i] {
+			return fmt.Errorf("spec.overrides[%d]: %s %s matches no resources", i, override.Kind, override.Name)
+		}
+	}
+
+	return nil
+}
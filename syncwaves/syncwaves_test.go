@@ -0,0 +1,131 @@
+package main_test
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+
+	"github.com/inloco/iac-kustomize-plugins/syncwaves"
+)
+
+const (
+	resources = `
+apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: rollouts.argoproj.io
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: payments
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: api
+  namespace: payments
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  namespace: payments
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrations
+  namespace: payments
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: smoke-tests
+  namespace: payments
+  annotations:
+    argocd.argoproj.io/sync-wave: "5"
+---
+apiVersion: argoproj.io/v1alpha1
+kind: Rollout
+metadata:
+  name: worker
+  namespace: payments
+`
+)
+
+var _ = ginkgo.Describe("SyncWaves", func() {
+	transform := func(spec string) (map[string]string, error) {
+		var out bytes.Buffer
+		if err := main.TransformManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: SyncWaves
+metadata:
+  name: sync-waves
+spec:
+`+spec), strings.NewReader(resources), &out); err != nil {
+			return nil, err
+		}
+
+		nodes, err := (&kio.ByteReader{
+			Reader:                &out,
+			OmitReaderAnnotations: true,
+		}).Read()
+		g.Expect(err).NotTo(g.HaveOccurred())
+
+		waves := make(map[string]string)
+		for _, node := range nodes {
+			waves[node.GetKind()+"/"+node.GetName()] = node.GetAnnotations()["argocd.argoproj.io/sync-wave"]
+		}
+
+		return waves, nil
+	}
+
+	ginkgo.It("assigns waves by kind class", func() {
+		waves, err := transform("")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(waves).To(g.Equal(map[string]string{
+			"CustomResourceDefinition/rollouts.argoproj.io": "-3",
+			"Namespace/payments":                            "-2",
+			"ConfigMap/api":                                 "-1",
+			"Deployment/api":                                "0",
+			"Job/migrations":                                "1",
+			"Job/smoke-tests":                               "5",
+			"Rollout/worker":                                "0",
+		}))
+	})
+
+	ginkgo.It("honors custom waves, classes and overrides", func() {
+		waves, err := transform(`
+  waves:
+    post: 10
+  classes:
+    Rollout: post
+  overrides:
+    - kind: Job
+      name: migrations
+      namespace: payments
+      wave: -1
+    - kind: Job
+      name: smoke-tests
+      wave: 20
+`)
+		g.Expect(err).NotTo(g.HaveOccurred())
+		g.Expect(waves).To(g.HaveKeyWithValue("Rollout/worker", "10"))
+		g.Expect(waves).To(g.HaveKeyWithValue("Job/migrations", "-1"))
+		g.Expect(waves).To(g.HaveKeyWithValue("Job/smoke-tests", "20"))
+	})
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		_, err := transform(spec)
+		g.Expect(err).To(g.MatchError(expectedError))
+	},
+		ginkgo.Entry("with unknown wave classes", "  waves:\n    jobs: 1\n", "spec.waves: unknown class jobs"),
+		ginkgo.Entry("with unknown kind classes", "  classes:\n    Rollout: apps\n", "spec.classes: Rollout: unknown class apps"),
+		ginkgo.Entry("with incomplete overrides", "  overrides:\n    - kind: Job\n", "spec.overrides[0] requires kind and name"),
+		ginkgo.Entry("with unmatched overrides", "  overrides:\n    - kind: Job\n      name: seed\n", "spec.overrides[0]: Job seed matches no resources"),
+	)
+})
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	pluginGroup   = "incognia.com"
+	pluginVersion = "v1alpha1"
+
+	argocdProjectKind = "ArgoCDProject"
+
+	outputConfigs   = "configs"
+	outputManifests = "manifests"
+)
+
+type TeamsRegistry struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Registry        string `json:"registry,omitempty"`
+	ClusterRegistry string `json:"clusterRegistry,omitempty"`
+	Output          string `json:"output,omitempty"`
+}
+
+// Registry is the file of spec.registry.
+type Registry struct {
+	Teams map[string]Team `json:"teams,omitempty"`
+}
+
+type Team struct {
+	Groups       Groups   `json:"groups,omitempty"`
+	Environments []string `json:"environments,omitempty"`
+	Clusters     []string `json:"clusters,omitempty"`
+	Namespaces   []string `json:"namespaces,omitempty"`
+}
+
+type Groups struct {
+	ReadOnly           []string `json:"ReadOnly,omitempty"`
+	ReadSync           []string `json:"ReadSync,omitempty"`
+	OverrideParameters []string `json:"OverrideParameters,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var teamsRegistry TeamsRegistry
+	if err := pluginconfig.Unmarshal(data, &teamsRegistry); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&teamsRegistry)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(teamsRegistry *TeamsRegistry) ([][]byte, error) {
+	if teamsRegistry.Spec.Registry == "" {
+		return nil, fmt.Errorf("spec.registry is empty")
+	}
+
+	switch teamsRegistry.Spec.Output {
+	case "", outputConfigs, outputManifests:
+	default:
+		return nil, fmt.Errorf("unknown output %s", teamsRegistry.Spec.Output)
+	}
+
+	registry, err := readRegistry(teamsRegistry.Spec.Registry)
+	if err != nil {
+		return nil, err
+	}
+
+	// the teams are sorted, so the output does not change between builds
+	names := make([]string, 0, len(registry.Teams))
+	for name := range registry.Teams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifests [][]byte
+	for _, name := range names {
+		team := registry.Teams[name]
+
+		config, err := makeArgoCDProject(teamsRegistry, name, &team)
+		if err != nil {
+			return nil, fmt.Errorf("%s: teams.%s: %w", teamsRegistry.Spec.Registry, name, err)
+		}
+
+		b, err := yaml.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		if teamsRegistry.Spec.Output != outputManifests {
+			manifests = append(manifests, b)
+			continue
+		}
+
+		appProject, err := makeAppProject(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: teams.%s: %w", teamsRegistry.Spec.Registry, name, err)
+		}
+		manifests = append(manifests, appProject)
+	}
+
+	return manifests, nil
+}
+
+func readRegistry(path string) (*Registry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var registry Registry
+	if err := yaml.UnmarshalStrict(data, &registry); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	if len(registry.Teams) == 0 {
+		return nil, fmt.Errorf("%s: teams is empty", path)
+	}
+
+	return &registry, nil
+}
+
+func makeArgoCDProject(teamsRegistry *TeamsRegistry, name string, team *Team) (*argocdproject.ArgoCDProject, error) {
+	if len(team.Clusters) == 0 {
+		return nil, fmt.Errorf("clusters is empty")
+	}
+
+	namespaces := team.Namespaces
+	if len(namespaces) == 0 {
+		namespaces = []string{
+			name,
+		}
+	}
+
+	var destinations []argov1alpha1.ApplicationDestination
+	for _, cluster := range team.Clusters {
+		for _, namespace := range namespaces {
+			destinations = append(destinations, argov1alpha1.ApplicationDestination{
+				Name:      cluster,
+				Namespace: namespace,
+			})
+		}
+	}
+
+	argocdProject := &argocdproject.ArgoCDProject{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{Group: pluginGroup, Version: pluginVersion}.String(),
+			Kind:       argocdProjectKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: teamsRegistry.Labels,
+		},
+		Spec: argocdproject.ProjectSpec{
+			AccessControl: argocdproject.AppProjectAccessControl{
+				ReadOnly:           team.Groups.ReadOnly,
+				ReadSync:           team.Groups.ReadSync,
+				OverrideParameters: team.Groups.OverrideParameters,
+			},
+			Environments:    team.Environments,
+			ClusterRegistry: teamsRegistry.Spec.ClusterRegistry,
+			AppProject: argov1alpha1.AppProject{
+				Spec: argov1alpha1.AppProjectSpec{
+					Destinations: destinations,
+				},
+			},
+		},
+	}
+
+	return argocdProject, nil
+}
+
+// makeAppProject renders the AppProject of the ArgoCDProject of a team, so the
+// plugin can be used as a generator without running the configs through the
+// ArgoCDProject plugin.
+func makeAppProject(config []byte) ([]byte, error) {
+	var out bytes.Buffer
+	options := argocdproject.Options{
+		Output: argocdproject.OutputAppProject,
+	}
+	if err := argocdproject.GenerateManifestsWithOptions(config, options, &out); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimPrefix(out.Bytes(), []byte(yamlSeparator)), nil
+}
@@ -0,0 +1,197 @@
+package main_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/teamsregistry"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("TeamsRegistry", func() {
+	var registry string
+	ginkgo.BeforeEach(func() {
+		dir, err := ioutil.TempDir("", "teamsregistry")
+		g.Expect(err).NotTo(g.HaveOccurred())
+		ginkgo.DeferCleanup(os.RemoveAll, dir)
+
+		registry = filepath.Join(dir, "teams.yaml")
+		g.Expect(ioutil.WriteFile(registry, []byte(`
+teams:
+  sre:
+    groups:
+      ReadOnly:
+        - security:eng-0
+      ReadSync:
+        - sre:eng-0
+    environments:
+      - production
+    clusters:
+      - in-cluster
+  data:
+    groups:
+      ReadSync:
+        - data:eng-0
+    clusters:
+      - in-cluster
+    namespaces:
+      - etl
+      - warehouse
+`), 0644)).To(g.Succeed())
+	})
+
+	ginkgo.It("generates an ArgoCDProject per team", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+`), &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(2))
+
+		var configs []map[string]interface{}
+		for _, resource := range resources {
+			var config map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(resource), &config)).To(g.Succeed())
+			g.Expect(config).To(g.HaveKeyWithValue("apiVersion", "incognia.com/v1alpha1"))
+			g.Expect(config).To(g.HaveKeyWithValue("kind", "ArgoCDProject"))
+
+			configs = append(configs, config)
+		}
+
+		g.Expect(configs[0]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "data")))
+		g.Expect(configs[0]).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("accessControl", g.HaveKeyWithValue("ReadSync", g.ConsistOf("data:eng-0"))),
+			g.HaveKeyWithValue("appProjectTemplate", g.HaveKeyWithValue("spec", g.HaveKeyWithValue("destinations", g.ConsistOf(
+				g.And(g.HaveKeyWithValue("name", "in-cluster"), g.HaveKeyWithValue("namespace", "etl")),
+				g.And(g.HaveKeyWithValue("name", "in-cluster"), g.HaveKeyWithValue("namespace", "warehouse")),
+			)))),
+		)))
+
+		g.Expect(configs[1]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "sre")))
+		g.Expect(configs[1]).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("environments", g.ConsistOf("production")),
+			g.HaveKeyWithValue("appProjectTemplate", g.HaveKeyWithValue("spec", g.HaveKeyWithValue("destinations", g.ConsistOf(
+				g.And(g.HaveKeyWithValue("name", "in-cluster"), g.HaveKeyWithValue("namespace", "sre")),
+			)))),
+		)))
+	})
+
+	ginkgo.It("keeps the cluster registry as written", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+  clusterRegistry: ./clusters.yaml
+`), &out)).To(g.Succeed())
+
+		for _, resource := range separatorYaml.Split(out.String(), -1) {
+			var config map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(resource), &config)).To(g.Succeed())
+			g.Expect(config).To(g.HaveKeyWithValue("spec", g.HaveKeyWithValue("clusterRegistry", "./clusters.yaml")))
+		}
+	})
+
+	ginkgo.It("generates an AppProject per team", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+  output: manifests
+`), &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(2))
+
+		var appProject argov1alpha1.AppProject
+		g.Expect(yaml.Unmarshal([]byte(resources[1]), &appProject)).To(g.Succeed())
+		g.Expect(appProject.Kind).To(g.Equal("AppProject"))
+		g.Expect(appProject.Name).To(g.Equal("sre"))
+
+		groups := make(map[string][]string)
+		for _, role := range appProject.Spec.Roles {
+			groups[role.Name] = role.Groups
+		}
+		g.Expect(groups).To(g.HaveKeyWithValue("read-only", g.ConsistOf("security:eng-0")))
+		g.Expect(groups).To(g.HaveKeyWithValue("read-sync", g.ConsistOf("sre:eng-0")))
+	})
+
+	ginkgo.It("fails on teams without clusters", func() {
+		g.Expect(ioutil.WriteFile(registry, []byte(`
+teams:
+  sre:
+    groups:
+      ReadSync:
+        - sre:eng-0
+`), 0644)).To(g.Succeed())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+`), &out)).To(g.MatchError(registry + ": teams.sre: clusters is empty"))
+	})
+
+	ginkgo.It("fails on unknown outputs", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+  output: helm
+`), &out)).To(g.MatchError("unknown output helm"))
+	})
+
+	ginkgo.It("fails on files of other resources", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: ArgoCDProject
+metadata:
+  name: platform
+spec:
+  registry: `+registry+`
+`), &out)).To(g.MatchError("file is incognia.com/v1alpha1 ArgoCDProject instead of incognia.com/v1alpha1 TeamsRegistry, the only files the plugin reads"))
+	})
+
+	ginkgo.It("fails without registry", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TeamsRegistry
+metadata:
+  name: platform
+`), &out)).To(g.MatchError("spec.registry is empty"))
+	})
+})
@@ -0,0 +1,548 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	argov1alpha1 "github.com/argoproj/argo-cd/v2/pkg/apis/application/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/argocdproject"
+	"github.com/inloco/iac-kustomize-plugins/pkg/networkpolicies"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+	"github.com/inloco/iac-kustomize-plugins/pkg/teamrbac"
+	"github.com/inloco/iac-kustomize-plugins/pkg/tenantnamespace"
+	"github.com/inloco/iac-kustomize-plugins/pkg/tenantquota"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	pluginGroup   = "incognia.com"
+	pluginVersion = "v1alpha1"
+
+	tenantNamespaceKind = "TenantNamespace"
+	tenantQuotaKind     = "TenantQuota"
+	networkPoliciesKind = "NetworkPolicies"
+	teamRBACKind        = "TeamRBAC"
+	argocdProjectKind   = "ArgoCDProject"
+
+	tenantQuotaName     = "tenant"
+	networkPoliciesName = "baseline"
+
+	defaultDestinationServer = "https://kubernetes.default.svc"
+
+	outputConfigs     = "configs"
+	outputComposition = "composition"
+	outputDefinition  = "definition"
+
+	decoderBufferLen = 4096
+
+	crossplaneAPIVersion            = "apiextensions.crossplane.io/v1"
+	compositeResourceDefinitionKind = "CompositeResourceDefinition"
+	compositionKind                 = "Composition"
+	compositeTenantKind             = "XTenant"
+	kubernetesObjectAPIVersion      = "kubernetes.crossplane.io/v1alpha1"
+	kubernetesObjectKind            = "Object"
+)
+
+type Tenant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Team          string        `json:"team,omitempty"`
+	Service       string        `json:"service,omitempty"`
+	CostCenter    string        `json:"costCenter,omitempty"`
+	Environment   string        `json:"environment,omitempty"`
+	PodSecurity   string        `json:"podSecurity,omitempty"`
+	Size          string        `json:"size,omitempty"`
+	Network       Network       `json:"network,omitempty"`
+	AccessControl AccessControl `json:"accessControl,omitempty"`
+	ArgoCD        ArgoCD        `json:"argocd,omitempty"`
+	Output        string        `json:"output,omitempty"`
+}
+
+type Network struct {
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+	Ports             []int32  `json:"ports,omitempty"`
+	EgressCIDRs       []string `json:"egressCIDRs,omitempty"`
+}
+
+type AccessControl struct {
+	ReadOnly []string `json:"ReadOnly,omitempty"`
+	ReadSync []string `json:"ReadSync,omitempty"`
+}
+
+type ArgoCD struct {
+	DestinationServer    string                     `json:"destinationServer,omitempty"`
+	ApplicationTemplates []argov1alpha1.Application `json:"applicationTemplates,omitempty"`
+}
+
+var (
+	// generators render the configurations of the plugins a tenant expands
+	// into, so compositions apply resources rather than configurations no
+	// controller reconciles.
+	generators = map[string]func(data []byte, out io.Writer) error{
+		tenantNamespaceKind: tenantnamespace.GenerateManifests,
+		tenantQuotaKind:     tenantquota.GenerateManifests,
+		networkPoliciesKind: networkpolicies.GenerateManifests,
+		teamRBACKind:        teamrbac.GenerateManifests,
+		argocdProjectKind:   argocdproject.GenerateManifests,
+	}
+)
+
+type pluginConfig struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              interface{} `json:"spec,omitempty"`
+}
+
+// The types below are the subset of the Crossplane APIs the composition output
+// writes, so the plugin does not depend on Crossplane.
+type compositeResourceDefinition struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              compositeResourceDefinitionSpec `json:"spec,omitempty"`
+}
+
+type compositeResourceDefinitionSpec struct {
+	Group    string                                        `json:"group,omitempty"`
+	Names    apiextensionsv1.CustomResourceDefinitionNames `json:"names,omitempty"`
+	Versions []compositeResourceDefinitionVersion          `json:"versions,omitempty"`
+}
+
+type compositeResourceDefinitionVersion struct {
+	Name          string                                    `json:"name,omitempty"`
+	Served        bool                                      `json:"served"`
+	Referenceable bool                                      `json:"referenceable"`
+	Schema        *apiextensionsv1.CustomResourceValidation `json:"schema,omitempty"`
+}
+
+type composition struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              compositionSpec `json:"spec,omitempty"`
+}
+
+type compositionSpec struct {
+	CompositeTypeRef typeReference      `json:"compositeTypeRef"`
+	Resources        []composedTemplate `json:"resources,omitempty"`
+}
+
+type typeReference struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+}
+
+type composedTemplate struct {
+	Name string           `json:"name,omitempty"`
+	Base kubernetesObject `json:"base"`
+}
+
+type kubernetesObject struct {
+	metav1.TypeMeta `json:",inline"`
+	Spec            kubernetesObjectSpec `json:"spec,omitempty"`
+}
+
+type kubernetesObjectSpec struct {
+	ForProvider kubernetesObjectParameters `json:"forProvider,omitempty"`
+}
+
+type kubernetesObjectParameters struct {
+	Manifest map[string]interface{} `json:"manifest,omitempty"`
+}
+
+type compositeTenant struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              compositeTenantSpec `json:"spec,omitempty"`
+}
+
+type compositeTenantSpec struct {
+	Spec           `json:",inline"`
+	CompositionRef corev1.LocalObjectReference `json:"compositionRef"`
+}
+
+type tenantNamespaceSpec struct {
+	Team        string `json:"team,omitempty"`
+	Service     string `json:"service,omitempty"`
+	CostCenter  string `json:"costCenter,omitempty"`
+	Environment string `json:"environment,omitempty"`
+	PodSecurity string `json:"podSecurity,omitempty"`
+}
+
+type tenantQuotaSpec struct {
+	Size        string `json:"size,omitempty"`
+	Environment string `json:"environment,omitempty"`
+}
+
+type teamRBACSpec struct {
+	Namespaces    []string      `json:"namespaces,omitempty"`
+	AccessControl AccessControl `json:"accessControl,omitempty"`
+}
+
+type argocdProjectSpec struct {
+	AccessControl        AccessControl              `json:"accessControl,omitempty"`
+	Environment          string                     `json:"environment,omitempty"`
+	AppProject           appProjectTemplate         `json:"appProjectTemplate,omitempty"`
+	ApplicationTemplates []argov1alpha1.Application `json:"applicationTemplates,omitempty"`
+}
+
+type appProjectTemplate struct {
+	Spec appProjectTemplateSpec `json:"spec,omitempty"`
+}
+
+type appProjectTemplateSpec struct {
+	Destinations []argov1alpha1.ApplicationDestination `json:"destinations,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var tenant Tenant
+	if err := pluginconfig.Unmarshal(data, &tenant); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(&tenant)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func makeManifests(tenant *Tenant) ([][]byte, error) {
+	if tenant.Name == "" {
+		return nil, fmt.Errorf("metadata.name is empty")
+	}
+
+	// the definition is shared by the compositions of every tenant, so it is
+	// generated on its own rather than once per tenant
+	if tenant.Spec.Output == outputDefinition {
+		return marshalResources(makeCompositeResourceDefinition())
+	}
+
+	if tenant.Spec.Team == "" {
+		return nil, fmt.Errorf("spec.team is empty")
+	}
+
+	configs := []pluginConfig{
+		makeTenantNamespace(tenant),
+		makeTenantQuota(tenant),
+		makeNetworkPolicies(tenant),
+		makeTeamRBAC(tenant),
+		makeArgoCDProject(tenant),
+	}
+
+	var resources []interface{}
+	switch tenant.Spec.Output {
+	case "", outputConfigs:
+		for _, config := range configs {
+			resources = append(resources, config)
+		}
+	case outputComposition:
+		objects, err := renderConfigs(configs)
+		if err != nil {
+			return nil, err
+		}
+
+		resources = []interface{}{
+			makeComposition(tenant, objects),
+			makeCompositeTenant(tenant),
+		}
+	default:
+		return nil, fmt.Errorf("unknown output %s", tenant.Spec.Output)
+	}
+
+	return marshalResources(resources...)
+}
+
+func marshalResources(resources ...interface{}) ([][]byte, error) {
+	manifests := make([][]byte, 0, len(resources))
+	for _, resource := range resources {
+		b, err := yaml.Marshal(resource)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, b)
+	}
+
+	return manifests, nil
+}
+
+// renderConfigs runs the generator of each configuration, returning the
+// resources they generate.
+func renderConfigs(configs []pluginConfig) ([]map[string]interface{}, error) {
+	var objects []map[string]interface{}
+	for _, config := range configs {
+		data, err := yaml.Marshal(config)
+		if err != nil {
+			return nil, err
+		}
+
+		var out bytes.Buffer
+		if err := generators[config.Kind](data, &out); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", config.Kind, config.Name, err)
+		}
+
+		decoder := utilyaml.NewYAMLOrJSONDecoder(&out, decoderBufferLen)
+		for {
+			var object map[string]interface{}
+			if err := decoder.Decode(&object); err == io.EOF {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+
+			if len(object) == 0 {
+				continue
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	return objects, nil
+}
+
+func makeTypeMeta(kind string) metav1.TypeMeta {
+	return metav1.TypeMeta{
+		APIVersion: schema.GroupVersion{
+			Group:   pluginGroup,
+			Version: pluginVersion,
+		}.String(),
+		Kind: kind,
+	}
+}
+
+func makeTenantNamespace(tenant *Tenant) pluginConfig {
+	return pluginConfig{
+		TypeMeta: makeTypeMeta(tenantNamespaceKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        tenant.Name,
+			Labels:      tenant.Labels,
+			Annotations: tenant.Annotations,
+		},
+		Spec: tenantNamespaceSpec{
+			Team:        tenant.Spec.Team,
+			Service:     tenant.Spec.Service,
+			CostCenter:  tenant.Spec.CostCenter,
+			Environment: tenant.Spec.Environment,
+			PodSecurity: tenant.Spec.PodSecurity,
+		},
+	}
+}
+
+func makeTenantQuota(tenant *Tenant) pluginConfig {
+	return pluginConfig{
+		TypeMeta: makeTypeMeta(tenantQuotaKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      tenantQuotaName,
+			Namespace: tenant.Name,
+		},
+		Spec: tenantQuotaSpec{
+			Size:        tenant.Spec.Size,
+			Environment: tenant.Spec.Environment,
+		},
+	}
+}
+
+func makeNetworkPolicies(tenant *Tenant) pluginConfig {
+	return pluginConfig{
+		TypeMeta: makeTypeMeta(networkPoliciesKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      networkPoliciesName,
+			Namespace: tenant.Name,
+		},
+		Spec: tenant.Spec.Network,
+	}
+}
+
+func makeTeamRBAC(tenant *Tenant) pluginConfig {
+	return pluginConfig{
+		TypeMeta: makeTypeMeta(teamRBACKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name: tenant.Spec.Team,
+		},
+		Spec: teamRBACSpec{
+			Namespaces: []string{
+				tenant.Name,
+			},
+			AccessControl: tenant.Spec.AccessControl,
+		},
+	}
+}
+
+func makeArgoCDProject(tenant *Tenant) pluginConfig {
+	destinationServer := tenant.Spec.ArgoCD.DestinationServer
+	if destinationServer == "" {
+		destinationServer = defaultDestinationServer
+	}
+
+	return pluginConfig{
+		TypeMeta: makeTypeMeta(argocdProjectKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name: tenant.Name,
+		},
+		Spec: argocdProjectSpec{
+			AccessControl: tenant.Spec.AccessControl,
+			Environment:   tenant.Spec.Environment,
+			AppProject: appProjectTemplate{
+				Spec: appProjectTemplateSpec{
+					Destinations: []argov1alpha1.ApplicationDestination{
+						argov1alpha1.ApplicationDestination{
+							Server:    destinationServer,
+							Namespace: tenant.Name,
+						},
+					},
+				},
+			},
+			ApplicationTemplates: tenant.Spec.ArgoCD.ApplicationTemplates,
+		},
+	}
+}
+
+// makeCompositeResourceDefinition defines XTenant, whose spec is the one of
+// Tenant, as the composite resource of the compositions of tenants.
+func makeCompositeResourceDefinition() compositeResourceDefinition {
+	names := apiextensionsv1.CustomResourceDefinitionNames{
+		Kind:   compositeTenantKind,
+		Plural: strings.ToLower(compositeTenantKind) + "s",
+	}
+
+	return compositeResourceDefinition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: crossplaneAPIVersion,
+			Kind:       compositeResourceDefinitionKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: fmt.Sprintf("%s.%s", names.Plural, pluginGroup),
+		},
+		Spec: compositeResourceDefinitionSpec{
+			Group: pluginGroup,
+			Names: names,
+			Versions: []compositeResourceDefinitionVersion{
+				compositeResourceDefinitionVersion{
+					Name:          pluginVersion,
+					Served:        true,
+					Referenceable: true,
+					Schema: &apiextensionsv1.CustomResourceValidation{
+						OpenAPIV3Schema: &apiextensionsv1.JSONSchemaProps{
+							Type: "object",
+							Properties: map[string]apiextensionsv1.JSONSchemaProps{
+								"spec": apiextensionsv1.JSONSchemaProps{
+									Type:                   "object",
+									XPreserveUnknownFields: boolPtr(true),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// makeComposition composes the resources generated for a tenant as objects of
+// provider-kubernetes, applied to the cluster as they are.
+func makeComposition(tenant *Tenant, objects []map[string]interface{}) composition {
+	resources := make([]composedTemplate, 0, len(objects))
+	for _, object := range objects {
+		kind, _, _ := unstructured.NestedString(object, "kind")
+		name, _, _ := unstructured.NestedString(object, "metadata", "name")
+
+		resources = append(resources, composedTemplate{
+			Name: strings.ToLower(fmt.Sprintf("%s-%s", kind, name)),
+			Base: kubernetesObject{
+				TypeMeta: metav1.TypeMeta{
+					APIVersion: kubernetesObjectAPIVersion,
+					Kind:       kubernetesObjectKind,
+				},
+				Spec: kubernetesObjectSpec{
+					ForProvider: kubernetesObjectParameters{
+						Manifest: object,
+					},
+				},
+			},
+		})
+	}
+
+	return composition{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: crossplaneAPIVersion,
+			Kind:       compositionKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        tenant.Name,
+			Labels:      tenant.Labels,
+			Annotations: tenant.Annotations,
+		},
+		Spec: compositionSpec{
+			CompositeTypeRef: typeReference{
+				APIVersion: makeTypeMeta(compositeTenantKind).APIVersion,
+				Kind:       compositeTenantKind,
+			},
+			Resources: resources,
+		},
+	}
+}
+
+func makeCompositeTenant(tenant *Tenant) compositeTenant {
+	spec := tenant.Spec
+	spec.Output = ""
+
+	return compositeTenant{
+		TypeMeta: makeTypeMeta(compositeTenantKind),
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        tenant.Name,
+			Labels:      tenant.Labels,
+			Annotations: tenant.Annotations,
+		},
+		Spec: compositeTenantSpec{
+			Spec: spec,
+			CompositionRef: corev1.LocalObjectReference{
+				Name: tenant.Name,
+			},
+		},
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
@@ -0,0 +1,207 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/tenant"
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("Tenant", func() {
+	ginkgo.It("expands into the onboarding plugins", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: my-app
+spec:
+  team: sre
+  service: my-app
+  costCenter: cc-1234
+  environment: production
+  size: medium
+  network:
+    allowedNamespaces:
+      - ingress-nginx
+  accessControl:
+    ReadOnly:
+      - security:eng-0
+    ReadSync:
+      - sre:eng-0
+`), &out)).To(g.Succeed())
+
+		configs := make(map[string]map[string]interface{})
+		for _, resource := range separatorYaml.Split(out.String(), -1) {
+			var config map[string]interface{}
+			g.Expect(yaml.Unmarshal([]byte(resource), &config)).To(g.Succeed())
+			g.Expect(config).To(g.HaveKeyWithValue("apiVersion", "incognia.com/v1alpha1"))
+
+			configs[config["kind"].(string)] = config
+		}
+
+		g.Expect(configs).To(g.HaveLen(5))
+
+		g.Expect(configs["TenantNamespace"]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "my-app")))
+		g.Expect(configs["TenantNamespace"]).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("team", "sre"),
+			g.HaveKeyWithValue("costCenter", "cc-1234"),
+			g.HaveKeyWithValue("environment", "production"),
+		)))
+
+		g.Expect(configs["TenantQuota"]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("namespace", "my-app")))
+		g.Expect(configs["TenantQuota"]).To(g.HaveKeyWithValue("spec", g.HaveKeyWithValue("size", "medium")))
+
+		g.Expect(configs["NetworkPolicies"]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("namespace", "my-app")))
+		g.Expect(configs["NetworkPolicies"]).To(g.HaveKeyWithValue("spec", g.HaveKeyWithValue("allowedNamespaces", g.ConsistOf("ingress-nginx"))))
+
+		g.Expect(configs["TeamRBAC"]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "sre")))
+		g.Expect(configs["TeamRBAC"]).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("namespaces", g.ConsistOf("my-app")),
+			g.HaveKeyWithValue("accessControl", g.HaveKeyWithValue("ReadSync", g.ConsistOf("sre:eng-0"))),
+		)))
+
+		g.Expect(configs["ArgoCDProject"]).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "my-app")))
+		g.Expect(configs["ArgoCDProject"]).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("environment", "production"),
+			g.HaveKeyWithValue("appProjectTemplate", g.HaveKeyWithValue("spec", g.HaveKeyWithValue("destinations", g.ConsistOf(g.And(
+				g.HaveKeyWithValue("server", "https://kubernetes.default.svc"),
+				g.HaveKeyWithValue("namespace", "my-app"),
+			))))),
+		)))
+	})
+
+	ginkgo.It("composes the resources of the onboarding plugins with Crossplane", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: my-app
+spec:
+  team: sre
+  costCenter: cc-1234
+  environment: production
+  size: small
+  accessControl:
+    ReadSync:
+      - sre:eng-0
+  output: composition
+`), &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(2))
+
+		var composition struct {
+			Spec struct {
+				CompositeTypeRef map[string]string `json:"compositeTypeRef"`
+				Resources        []struct {
+					Name string `json:"name"`
+					Base struct {
+						Kind string `json:"kind"`
+						Spec struct {
+							ForProvider struct {
+								Manifest map[string]interface{} `json:"manifest"`
+							} `json:"forProvider"`
+						} `json:"spec"`
+					} `json:"base"`
+				} `json:"resources"`
+			} `json:"spec"`
+		}
+		g.Expect(yaml.Unmarshal([]byte(resources[0]), &composition)).To(g.Succeed())
+		g.Expect(composition.Spec.CompositeTypeRef).To(g.Equal(map[string]string{
+			"apiVersion": "incognia.com/v1alpha1",
+			"kind":       "XTenant",
+		}))
+
+		kinds := make(map[string]int)
+		for _, resource := range composition.Spec.Resources {
+			g.Expect(resource.Base.Kind).To(g.Equal("Object"))
+			kinds[resource.Base.Spec.ForProvider.Manifest["kind"].(string)]++
+		}
+		g.Expect(composition.Spec.Resources[0].Name).To(g.Equal("namespace-my-app"))
+		g.Expect(kinds).To(g.And(
+			g.HaveKeyWithValue("Namespace", 1),
+			g.HaveKeyWithValue("ResourceQuota", 1),
+			g.HaveKeyWithValue("LimitRange", 1),
+			g.HaveKey("NetworkPolicy"),
+			g.HaveKeyWithValue("Role", 1),
+			g.HaveKeyWithValue("AppProject", 1),
+		))
+		g.Expect(kinds).NotTo(g.HaveKey("TenantNamespace"))
+
+		var xr map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(resources[1]), &xr)).To(g.Succeed())
+		g.Expect(xr).To(g.HaveKeyWithValue("kind", "XTenant"))
+		g.Expect(xr).To(g.HaveKeyWithValue("spec", g.And(
+			g.HaveKeyWithValue("team", "sre"),
+			g.HaveKeyWithValue("compositionRef", g.HaveKeyWithValue("name", "my-app")),
+			g.Not(g.HaveKey("output")),
+		)))
+	})
+
+	ginkgo.It("fails to compose tenants the onboarding plugins reject", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: my-app
+spec:
+  team: sre
+  environment: production
+  output: composition
+`), &out)).To(g.MatchError("TenantNamespace my-app: spec.costCenter is empty"))
+	})
+
+	ginkgo.It("generates the definition of the compositions on its own", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: xtenants
+spec:
+  output: definition
+`), &out)).To(g.Succeed())
+
+		resources := separatorYaml.Split(out.String(), -1)
+		g.Expect(resources).To(g.HaveLen(1))
+
+		var xrd map[string]interface{}
+		g.Expect(yaml.Unmarshal([]byte(resources[0]), &xrd)).To(g.Succeed())
+		g.Expect(xrd).To(g.HaveKeyWithValue("kind", "CompositeResourceDefinition"))
+		g.Expect(xrd).To(g.HaveKeyWithValue("metadata", g.HaveKeyWithValue("name", "xtenants.incognia.com")))
+	})
+
+	ginkgo.It("fails on unknown outputs", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: my-app
+spec:
+  team: sre
+  output: helm
+`), &out)).To(g.MatchError("unknown output helm"))
+	})
+
+	ginkgo.It("fails without team", func() {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests([]byte(`
+apiVersion: incognia.com/v1alpha1
+kind: Tenant
+metadata:
+  name: my-app
+`), &out)).To(g.MatchError("spec.team is empty"))
+	})
+})
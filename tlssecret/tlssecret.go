@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/youmark/pkcs8"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	pemCertificateType = "CERTIFICATE"
+	pemPrivateKeyType  = "PRIVATE KEY"
+)
+
+type TLSSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Files           *Files          `json:"files,omitempty"`
+	ACM             *ACM            `json:"acm,omitempty"`
+	MinimumValidity metav1.Duration `json:"minimumValidity,omitempty"`
+}
+
+type Files struct {
+	Certificate string   `json:"certificate,omitempty"`
+	Chain       []string `json:"chain,omitempty"`
+	Key         string   `json:"key,omitempty"`
+}
+
+type ACM struct {
+	CertificateARN string `json:"certificateARN,omitempty"`
+	Region         string `json:"region,omitempty"`
+	PassphraseEnv  string `json:"passphraseEnv,omitempty"`
+	Key            string `json:"key,omitempty"`
+}
+
+type ACMClient interface {
+	GetCertificate(ctx context.Context, params *acm.GetCertificateInput, optFns ...func(*acm.Options)) (*acm.GetCertificateOutput, error)
+	ExportCertificate(ctx context.Context, params *acm.ExportCertificateInput, optFns ...func(*acm.Options)) (*acm.ExportCertificateOutput, error)
+}
+
+type ACMClientFactory func(region string) (ACMClient, error)
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	return GenerateManifestsWithACMClientFactory(newACMClient, data, out)
+}
+
+func GenerateManifestsWithACMClientFactory(newACMClient ACMClientFactory, data []byte, out io.Writer) error {
+	var tlsSecret TLSSecret
+	if err := pluginconfig.Unmarshal(data, &tlsSecret); err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(newACMClient, &tlsSecret)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newACMClient(region string) (ACMClient, error) {
+	var optFns []func(*config.LoadOptions) error
+	if region != "" {
+		optFns = append(optFns, config.WithRegion(region))
+	}
+
+	cfg, err := config.LoadDefaultConfig(context.Background(), optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	return acm.NewFromConfig(cfg), nil
+}
+
+func makeManifests(newACMClient ACMClientFactory, tlsSecret *TLSSecret) ([][]byte, error) {
+	var certificates []byte
+	var key []byte
+	var err error
+
+	switch {
+	case tlsSecret.Spec.Files != nil && tlsSecret.Spec.ACM != nil:
+		return nil, fmt.Errorf("spec.files and spec.acm are mutually exclusive")
+	case tlsSecret.Spec.Files != nil:
+		certificates, key, err = readFiles(tlsSecret.Spec.Files)
+	case tlsSecret.Spec.ACM != nil:
+		certificates, key, err = readACM(newACMClient, tlsSecret.Spec.ACM)
+	default:
+		return nil, fmt.Errorf("one of spec.files or spec.acm must be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := assembleChain(certificates)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validate(chain, key, tlsSecret.Spec.MinimumValidity.Duration); err != nil {
+		return nil, err
+	}
+
+	secret, err := makeSecret(tlsSecret, chain, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{secret}, nil
+}
+
+func readFiles(files *Files) ([]byte, []byte, error) {
+	if files.Certificate == "" || files.Key == "" {
+		return nil, nil, fmt.Errorf("spec.files.certificate and spec.files.key must be set")
+	}
+
+	certificates, err := ioutil.ReadFile(files.Certificate)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, path := range files.Chain {
+		chain, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		certificates = append(certificates, '\n')
+		certificates = append(certificates, chain...)
+	}
+
+	key, err := ioutil.ReadFile(files.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return certificates, key, nil
+}
+
+func readACM(newACMClient ACMClientFactory, spec *ACM) ([]byte, []byte, error) {
+	if spec.CertificateARN == "" {
+		return nil, nil, fmt.Errorf("spec.acm.certificateARN is empty")
+	}
+
+	client, err := newACMClient(spec.Region)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if spec.PassphraseEnv == "" {
+		if spec.Key == "" {
+			return nil, nil, fmt.Errorf("one of spec.acm.passphraseEnv or spec.acm.key must be set")
+		}
+
+		output, err := client.GetCertificate(context.Background(), &acm.GetCertificateInput{
+			CertificateArn: aws.String(spec.CertificateARN),
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+
+		key, err := ioutil.ReadFile(spec.Key)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		certificates := aws.ToString(output.Certificate) + "\n" + aws.ToString(output.CertificateChain)
+		return []byte(certificates), key, nil
+	}
+
+	passphrase, exists := os.LookupEnv(spec.PassphraseEnv)
+	if !exists {
+		return nil, nil, fmt.Errorf("%s is empty", spec.PassphraseEnv)
+	}
+
+	output, err := client.ExportCertificate(context.Background(), &acm.ExportCertificateInput{
+		CertificateArn: aws.String(spec.CertificateARN),
+		Passphrase:     []byte(passphrase),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key, err := decryptPrivateKey([]byte(aws.ToString(output.PrivateKey)), []byte(passphrase))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certificates := aws.ToString(output.Certificate) + "\n" + aws.ToString(output.CertificateChain)
+	return []byte(certificates), key, nil
+}
+
+func decryptPrivateKey(data []byte, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("exported private key has no PEM block")
+	}
+
+	privateKey, err := pkcs8.ParsePKCS8PrivateKey(block.Bytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  pemPrivateKeyType,
+		Bytes: der,
+	}), nil
+}
+
+// assembleChain orders the given certificates from the leaf up to, but not
+// including, the self-signed root (unless the leaf itself is self-signed).
+func assembleChain(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != pemCertificateType {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("no certificates found")
+	}
+
+	chain := []*x509.Certificate{
+		certificates[0],
+	}
+	remaining := certificates[1:]
+
+	for {
+		current := chain[len(chain)-1]
+		if isSelfSigned(current) {
+			if len(chain) > 1 {
+				chain = chain[:len(chain)-1]
+			}
+			break
+		}
+
+		var next *x509.Certificate
+		var unused []*x509.Certificate
+		for _, certificate := range remaining {
+			switch {
+			case certificate.Equal(current):
+			case next == nil && bytes.Equal(certificate.RawSubject, current.RawIssuer):
+				next = certificate
+			default:
+				unused = append(unused, certificate)
+			}
+		}
+		remaining = unused
+
+		if next == nil {
+			break
+		}
+
+		if err := current.CheckSignatureFrom(next); err != nil {
+			return nil, fmt.Errorf("certificate %s is not signed by %s: %w", current.Subject, next.Subject, err)
+		}
+		chain = append(chain, next)
+	}
+
+	for _, certificate := range remaining {
+		if !isSelfSigned(certificate) {
+			return nil, fmt.Errorf("certificate %s is not part of the chain of %s", certificate.Subject, certificates[0].Subject)
+		}
+	}
+
+	return chain, nil
+}
+
+func isSelfSigned(certificate *x509.Certificate) bool {
+	return bytes.Equal(certificate.RawSubject, certificate.RawIssuer) && certificate.CheckSignatureFrom(certificate) == nil
+}
+
+func validate(chain []*x509.Certificate, key []byte, minimumValidity time.Duration) error {
+	deadline := time.Now().Add(minimumValidity)
+	for _, certificate := range chain {
+		if time.Now().Before(certificate.NotBefore) {
+			return fmt.Errorf("certificate %s is not valid before %s", certificate.Subject, certificate.NotBefore)
+		}
+
+		if deadline.After(certificate.NotAfter) {
+			return fmt.Errorf("certificate %s expires at %s", certificate.Subject, certificate.NotAfter)
+		}
+	}
+
+	if _, err := tls.X509KeyPair(encodeChain(chain[:1]), key); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func encodeChain(chain []*x509.Certificate) []byte {
+	var b []byte
+	for _, certificate := range chain {
+		b = append(b, pem.EncodeToMemory(&pem.Block{
+			Type:  pemCertificateType,
+			Bytes: certificate.Raw,
+		})...)
+	}
+
+	return b
+}
+
+func makeSecret(tlsSecret *TLSSecret, chain []*x509.Certificate, key []byte) ([]byte, error) {
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Secret{}).Name(),
+		},
+		ObjectMeta: tlsSecret.ObjectMeta,
+		Type:       corev1.SecretTypeTLS,
+		Data: map[string][]byte{
+			corev1.TLSCertKey:       encodeChain(chain),
+			corev1.TLSPrivateKeyKey: key,
+		},
+	}
+
+	return yaml.Marshal(secret)
+}
@@ -0,0 +1,216 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	"github.com/youmark/pkcs8"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/tlssecret"
+)
+
+type certificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+}
+
+func makeCertificate(name string, parent *certificate, notAfter time.Time) *certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	g.Expect(err).To(g.BeNil())
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject: pkix.Name{
+			CommonName: name,
+		},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              notAfter,
+		IsCA:                  parent == nil || name != "leaf",
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+
+	signerCertificate, signerKey := template, key
+	if parent != nil {
+		signerCertificate, signerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, signerCertificate, &key.PublicKey, signerKey)
+	g.Expect(err).To(g.BeNil())
+
+	parsed, err := x509.ParseCertificate(der)
+	g.Expect(err).To(g.BeNil())
+
+	return &certificate{
+		certificate: parsed,
+		key:         key,
+		pem: pem.EncodeToMemory(&pem.Block{
+			Type:  "CERTIFICATE",
+			Bytes: der,
+		}),
+	}
+}
+
+func encodeKey(key *ecdsa.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	g.Expect(err).To(g.BeNil())
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: der,
+	})
+}
+
+type fakeACMClient struct {
+	leaf         *certificate
+	intermediate *certificate
+	passphrase   []byte
+}
+
+func (c *fakeACMClient) GetCertificate(context.Context, *acm.GetCertificateInput, ...func(*acm.Options)) (*acm.GetCertificateOutput, error) {
+	return &acm.GetCertificateOutput{
+		Certificate:      aws.String(string(c.leaf.pem)),
+		CertificateChain: aws.String(string(c.intermediate.pem)),
+	}, nil
+}
+
+func (c *fakeACMClient) ExportCertificate(_ context.Context, input *acm.ExportCertificateInput, _ ...func(*acm.Options)) (*acm.ExportCertificateOutput, error) {
+	g.Expect(input.Passphrase).To(g.Equal(c.passphrase))
+
+	der, err := pkcs8.MarshalPrivateKey(c.leaf.key, c.passphrase, nil)
+	g.Expect(err).To(g.BeNil())
+
+	return &acm.ExportCertificateOutput{
+		Certificate:      aws.String(string(c.leaf.pem)),
+		CertificateChain: aws.String(string(c.intermediate.pem)),
+		PrivateKey: aws.String(string(pem.EncodeToMemory(&pem.Block{
+			Type:  "ENCRYPTED PRIVATE KEY",
+			Bytes: der,
+		}))),
+	}, nil
+}
+
+var _ = ginkgo.Describe("TLSSecret", func() {
+	validity := time.Now().Add(30 * 24 * time.Hour)
+	root := makeCertificate("root", nil, validity)
+	intermediate := makeCertificate("intermediate", root, validity)
+	leaf := makeCertificate("leaf", intermediate, validity)
+
+	workingDir, err := os.MkdirTemp("", "*")
+	g.Expect(err).To(g.BeNil())
+
+	writeFile := func(name string, data []byte) string {
+		path := filepath.Join(workingDir, name)
+		g.Expect(os.WriteFile(path, data, 0600)).To(g.Succeed())
+		return path
+	}
+
+	rootPath := writeFile("root.pem", root.pem)
+	intermediatePath := writeFile("intermediate.pem", intermediate.pem)
+	leafPath := writeFile("leaf.pem", leaf.pem)
+	keyPath := writeFile("leaf.key", encodeKey(leaf.key))
+	otherKeyPath := writeFile("other.key", encodeKey(intermediate.key))
+
+	client := &fakeACMClient{
+		leaf:         leaf,
+		intermediate: intermediate,
+		passphrase:   []byte("passphrase"),
+	}
+	g.Expect(os.Setenv("TLS_SECRET_PASSPHRASE", string(client.passphrase))).To(g.Succeed())
+
+	newFakeACMClient := func(string) (main.ACMClient, error) {
+		return client, nil
+	}
+
+	expectedCertificates := append(append([]byte{}, leaf.pem...), intermediate.pem...)
+
+	ginkgo.DescribeTable("", func(spec string, expectedKey []byte) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifestsWithACMClientFactory(newFakeACMClient, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TLSSecret
+metadata:
+  name: my-app-tls
+spec:
+`+spec), &out)).To(g.Succeed())
+
+		var secret corev1.Secret
+		g.Expect(yaml.Unmarshal(out.Bytes(), &secret)).To(g.Succeed())
+
+		g.Expect(secret.Name).To(g.Equal("my-app-tls"))
+		g.Expect(secret.Type).To(g.Equal(corev1.SecretTypeTLS))
+		g.Expect(secret.Data).To(g.Equal(map[string][]byte{
+			corev1.TLSCertKey:       expectedCertificates,
+			corev1.TLSPrivateKeyKey: expectedKey,
+		}))
+	},
+		ginkgo.Entry("with files in any order", `
+  files:
+    certificate: `+leafPath+`
+    chain:
+      - `+rootPath+`
+      - `+intermediatePath+`
+    key: `+keyPath+`
+`, encodeKey(leaf.key)),
+		ginkgo.Entry("with ACM certificate and local key", `
+  acm:
+    certificateARN: arn:aws:acm:us-east-1:123456789876:certificate/my-app
+    key: `+keyPath+`
+`, encodeKey(leaf.key)),
+		ginkgo.Entry("with exported ACM certificate", `
+  acm:
+    certificateARN: arn:aws:acm:us-east-1:123456789876:certificate/my-app
+    passphraseEnv: TLS_SECRET_PASSPHRASE
+`, encodeKey(leaf.key)),
+	)
+
+	ginkgo.DescribeTable("fails", func(spec string, expectedError string) {
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifestsWithACMClientFactory(newFakeACMClient, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: TLSSecret
+metadata:
+  name: my-app-tls
+spec:
+`+spec), &out)).To(g.MatchError(g.ContainSubstring(expectedError)))
+	},
+		ginkgo.Entry("when expiring soon", `
+  files:
+    certificate: `+leafPath+`
+    chain:
+      - `+intermediatePath+`
+    key: `+keyPath+`
+  minimumValidity: 1440h
+`, "expires at"),
+		ginkgo.Entry("when key does not match", `
+  files:
+    certificate: `+leafPath+`
+    key: `+otherKeyPath+`
+`, "private key does not match public key"),
+		ginkgo.Entry("when chain is unrelated", `
+  files:
+    certificate: `+leafPath+`
+    chain:
+      - `+intermediatePath+`
+      - `+writeFile("unrelated.pem", makeCertificate("unrelated", root, validity).pem)+`
+    key: `+keyPath+`
+`, "is not part of the chain"),
+	)
+})
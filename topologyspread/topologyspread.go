@@ -0,0 +1,247 @@
+package main
+
+import (
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	skipAnnotation = "incognia.com/skip-topology-spread"
+
+	defaultMinReplicas = 2
+	defaultMaxSkew     = 1
+)
+
+var (
+	defaultKinds = []string{
+		"Deployment",
+		"Rollout",
+		"StatefulSet",
+	}
+)
+
+type TopologySpread struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Kinds       []string    `json:"kinds,omitempty"`
+	MinReplicas int32       `json:"minReplicas,omitempty"`
+	Zone        *Constraint `json:"zone,omitempty"`
+	Host        *Constraint `json:"host,omitempty"`
+}
+
+type Constraint struct {
+	Disabled          bool                                 `json:"disabled,omitempty"`
+	MaxSkew           int32                                `json:"maxSkew,omitempty"`
+	WhenUnsatisfiable corev1.UnsatisfiableConstraintAction `json:"whenUnsatisfiable,omitempty"`
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	var topologySpread TopologySpread
+	if err := pluginconfig.Unmarshal(data, &topologySpread); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := transform(&topologySpread, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func transform(topologySpread *TopologySpread, nodes []*kyaml.RNode) error {
+	spec := &topologySpread.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	minReplicas := spec.MinReplicas
+	if minReplicas == 0 {
+		minReplicas = defaultMinReplicas
+	}
+
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		replicas, err := readReplicas(node)
+		if err != nil {
+			return err
+		}
+		if replicas < minReplicas {
+			continue
+		}
+
+		podSpec, err := node.Pipe(kyaml.Lookup("spec", "template", "spec"))
+		if err != nil {
+			return err
+		}
+		if podSpec == nil {
+			continue
+		}
+
+		current, err := podSpec.Pipe(kyaml.Lookup("topologySpreadConstraints"))
+		if err != nil {
+			return err
+		}
+		if current != nil {
+			continue
+		}
+
+		var selector metav1.LabelSelector
+		if err := decodeField(node, []string{"spec", "selector"}, &selector); err != nil {
+			return err
+		}
+		if len(selector.MatchLabels) == 0 && len(selector.MatchExpressions) == 0 {
+			continue
+		}
+
+		var constraints []corev1.TopologySpreadConstraint
+		if constraint := makeConstraint(spec.Zone, corev1.LabelTopologyZone, &selector); constraint != nil {
+			constraints = append(constraints, *constraint)
+		}
+		if constraint := makeConstraint(spec.Host, corev1.LabelHostname, &selector); constraint != nil {
+			constraints = append(constraints, *constraint)
+		}
+		if len(constraints) == 0 {
+			continue
+		}
+
+		if err := encodeField(podSpec, "topologySpreadConstraints", constraints); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readReplicas(node *kyaml.RNode) (int32, error) {
+	value, err := node.Pipe(kyaml.Lookup("spec", "replicas"))
+	if err != nil {
+		return 0, err
+	}
+	if value == nil {
+		return 1, nil
+	}
+
+	replicas, err := strconv.ParseInt(kyaml.GetValue(value), 10, 32)
+	if err != nil {
+		return 0, err
+	}
+
+	return int32(replicas), nil
+}
+
+func makeConstraint(constraint *Constraint, topologyKey string, selector *metav1.LabelSelector) *corev1.TopologySpreadConstraint {
+	if constraint == nil {
+		constraint = &Constraint{}
+	}
+
+	if constraint.Disabled {
+		return nil
+	}
+
+	maxSkew := constraint.MaxSkew
+	if maxSkew == 0 {
+		maxSkew = defaultMaxSkew
+	}
+
+	whenUnsatisfiable := constraint.WhenUnsatisfiable
+	if whenUnsatisfiable == "" {
+		whenUnsatisfiable = corev1.ScheduleAnyway
+	}
+
+	return &corev1.TopologySpreadConstraint{
+		MaxSkew:           maxSkew,
+		TopologyKey:       topologyKey,
+		WhenUnsatisfiable: whenUnsatisfiable,
+		LabelSelector:     selector,
+	}
+}
+
+func decodeField(node *kyaml.RNode, path []string, v interface{}) error {
+	value, err := node.Pipe(kyaml.Lookup(path...))
+	if err != nil {
+		return err
+	}
+	if value == nil {
+		return nil
+	}
+
+	s, err := value.String()
+	if err != nil {
+		return err
+	}
+
+	return yaml.Unmarshal([]byte(s), v)
+}
+
+func encodeField(node *kyaml.RNode, field string, v interface{}) error {
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	value, err := kyaml.Parse(string(b))
+	if err != nil {
+		return err
+	}
+
+	return node.PipeE(kyaml.SetField(field, value))
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,151 @@
+package main_test
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/topologyspread"
+)
+
+const (
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: replicated
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: replicated
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: single
+spec:
+  selector:
+    matchLabels:
+      app: single
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: skipped
+  annotations:
+    incognia.com/skip-topology-spread: "true"
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: skipped
+  template:
+    spec:
+      containers:
+        - name: app
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: explicit
+spec:
+  replicas: 3
+  selector:
+    matchLabels:
+      app: explicit
+  template:
+    spec:
+      topologySpreadConstraints:
+        - maxSkew: 2
+          topologyKey: topology.kubernetes.io/zone
+          whenUnsatisfiable: DoNotSchedule
+      containers:
+        - name: app
+`
+)
+
+var (
+	separatorYaml = regexp.MustCompile("\n---\n")
+)
+
+var _ = ginkgo.Describe("TopologySpread", func() {
+	ginkgo.It("spreads replicated workloads across zones and hosts", func() {
+		constraints := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: TopologySpread
+metadata:
+  name: topology-spread
+spec:
+  zone:
+    whenUnsatisfiable: DoNotSchedule
+`)
+
+		selector := &metav1.LabelSelector{
+			MatchLabels: map[string]string{
+				"app": "replicated",
+			},
+		}
+		g.Expect(constraints["replicated"]).To(g.Equal([]corev1.TopologySpreadConstraint{
+			corev1.TopologySpreadConstraint{
+				MaxSkew:           1,
+				TopologyKey:       "topology.kubernetes.io/zone",
+				WhenUnsatisfiable: corev1.DoNotSchedule,
+				LabelSelector:     selector,
+			},
+			corev1.TopologySpreadConstraint{
+				MaxSkew:           1,
+				TopologyKey:       "kubernetes.io/hostname",
+				WhenUnsatisfiable: corev1.ScheduleAnyway,
+				LabelSelector:     selector,
+			},
+		}))
+		g.Expect(constraints["single"]).To(g.BeEmpty())
+		g.Expect(constraints["skipped"]).To(g.BeEmpty())
+		g.Expect(constraints["explicit"]).To(g.ConsistOf(g.HaveField("MaxSkew", int32(2))))
+	})
+
+	ginkgo.It("honors the replica threshold and disabled constraints", func() {
+		constraints := transform(`
+apiVersion: incognia.com/v1alpha1
+kind: TopologySpread
+metadata:
+  name: topology-spread
+spec:
+  minReplicas: 1
+  host:
+    disabled: true
+`)
+
+		g.Expect(constraints["single"]).To(g.ConsistOf(g.HaveField("TopologyKey", "topology.kubernetes.io/zone")))
+	})
+})
+
+func transform(config string) map[string][]corev1.TopologySpreadConstraint {
+	var out bytes.Buffer
+	g.Expect(main.TransformManifests([]byte(config), strings.NewReader(resources), &out)).To(g.Succeed())
+
+	constraints := make(map[string][]corev1.TopologySpreadConstraint)
+	for _, manifest := range separatorYaml.Split(out.String(), -1) {
+		var deployment appsv1.Deployment
+		g.Expect(yaml.Unmarshal([]byte(manifest), &deployment)).To(g.Succeed())
+		constraints[deployment.Name] = deployment.Spec.Template.Spec.TopologySpreadConstraints
+	}
+
+	return constraints
+}
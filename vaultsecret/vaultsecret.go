@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+	yamlSeparator  = "---\n"
+
+	vaultAddrEnv      = "VAULT_ADDR"
+	vaultNamespaceEnv = "VAULT_NAMESPACE"
+	vaultTokenEnv     = "VAULT_TOKEN"
+	vaultRoleIDEnv    = "VAULT_ROLE_ID"
+	vaultSecretIDEnv  = "VAULT_SECRET_ID"
+	vaultK8sRoleEnv   = "VAULT_K8S_ROLE"
+	vaultK8sMountEnv  = "VAULT_K8S_MOUNT"
+	vaultK8sTokenEnv  = "VAULT_K8S_TOKEN_PATH"
+
+	vaultTokenHeader     = "X-Vault-Token"
+	vaultNamespaceHeader = "X-Vault-Namespace"
+
+	defaultKVMount      = "secret"
+	defaultK8sMount     = "kubernetes"
+	defaultK8sTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+)
+
+type VaultSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Type  corev1.SecretType `json:"type,omitempty"`
+	Paths []Path            `json:"paths,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+type Path struct {
+	Name    string `json:"name,omitempty"`
+	Mount   string `json:"mount,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Version int    `json:"version,omitempty"`
+}
+
+type vaultClient struct {
+	address   string
+	namespace string
+	token     string
+	client    *http.Client
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := GenerateManifests(data, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func GenerateManifests(data []byte, out io.Writer) error {
+	var vaultSecret VaultSecret
+	if err := pluginconfig.Unmarshal(data, &vaultSecret); err != nil {
+		return err
+	}
+
+	client, err := newVaultClient()
+	if err != nil {
+		return err
+	}
+
+	manifests, err := makeManifests(client, &vaultSecret)
+	if err != nil {
+		return err
+	}
+
+	for _, manifest := range manifests {
+		if _, err := out.Write([]byte(yamlSeparator)); err != nil {
+			return err
+		}
+
+		if _, err := out.Write(manifest); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func newVaultClient() (*vaultClient, error) {
+	address, exists := os.LookupEnv(vaultAddrEnv)
+	if !exists {
+		return nil, fmt.Errorf("%s is empty", vaultAddrEnv)
+	}
+
+	client := &vaultClient{
+		address:   strings.TrimSuffix(address, "/"),
+		namespace: os.Getenv(vaultNamespaceEnv),
+		client:    http.DefaultClient,
+	}
+
+	token, err := client.login()
+	if err != nil {
+		return nil, err
+	}
+	client.token = token
+
+	return client, nil
+}
+
+func (c *vaultClient) login() (string, error) {
+	if token, exists := os.LookupEnv(vaultTokenEnv); exists {
+		return token, nil
+	}
+
+	if roleID, exists := os.LookupEnv(vaultRoleIDEnv); exists {
+		return c.authenticate("approle", map[string]string{
+			"role_id":   roleID,
+			"secret_id": os.Getenv(vaultSecretIDEnv),
+		})
+	}
+
+	if role, exists := os.LookupEnv(vaultK8sRoleEnv); exists {
+		tokenPath := defaultK8sTokenPath
+		if path, exists := os.LookupEnv(vaultK8sTokenEnv); exists {
+			tokenPath = path
+		}
+
+		jwt, err := ioutil.ReadFile(tokenPath)
+		if err != nil {
+			return "", err
+		}
+
+		mount := defaultK8sMount
+		if m, exists := os.LookupEnv(vaultK8sMountEnv); exists {
+			mount = m
+		}
+
+		return c.authenticate(mount, map[string]string{
+			"role": role,
+			"jwt":  strings.TrimSpace(string(jwt)),
+		})
+	}
+
+	return "", fmt.Errorf("one of %s, %s or %s must be set", vaultTokenEnv, vaultRoleIDEnv, vaultK8sRoleEnv)
+}
+
+func (c *vaultClient) authenticate(mount string, payload map[string]string) (string, error) {
+	var response struct {
+		Auth struct {
+			ClientToken string `json:"client_token"`
+		} `json:"auth"`
+	}
+	if err := c.do(http.MethodPost, fmt.Sprintf("auth/%s/login", mount), payload, &response); err != nil {
+		return "", err
+	}
+
+	if response.Auth.ClientToken == "" {
+		return "", fmt.Errorf("vault login on auth/%s returned no token", mount)
+	}
+
+	return response.Auth.ClientToken, nil
+}
+
+func (c *vaultClient) readKV(path Path) (map[string]string, error) {
+	mount := path.Mount
+	if mount == "" {
+		mount = defaultKVMount
+	}
+
+	endpoint := fmt.Sprintf("%s/data/%s", strings.Trim(mount, "/"), strings.Trim(path.Path, "/"))
+	if path.Version > 0 {
+		endpoint = fmt.Sprintf("%s?version=%d", endpoint, path.Version)
+	}
+
+	var response struct {
+		Data struct {
+			Data map[string]interface{} `json:"data"`
+		} `json:"data"`
+	}
+	if err := c.do(http.MethodGet, endpoint, nil, &response); err != nil {
+		return nil, err
+	}
+
+	if response.Data.Data == nil {
+		return nil, fmt.Errorf("vault path %s has no data", endpoint)
+	}
+
+	values := make(map[string]string, len(response.Data.Data))
+	for key, value := range response.Data.Data {
+		switch v := value.(type) {
+		case string:
+			values[key] = v
+		default:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, err
+			}
+			values[key] = string(b)
+		}
+	}
+
+	return values, nil
+}
+
+func (c *vaultClient) do(method string, endpoint string, payload interface{}, v interface{}) error {
+	var body io.Reader
+	if payload != nil {
+		b, err := json.Marshal(payload)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.address, endpoint), body)
+	if err != nil {
+		return err
+	}
+
+	if c.token != "" {
+		req.Header.Set(vaultTokenHeader, c.token)
+	}
+	if c.namespace != "" {
+		req.Header.Set(vaultNamespaceHeader, c.namespace)
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("vault %s %s returned %s: %s", method, endpoint, res.Status, strings.TrimSpace(string(b)))
+	}
+
+	return json.Unmarshal(b, v)
+}
+
+func makeManifests(client *vaultClient, vaultSecret *VaultSecret) ([][]byte, error) {
+	values := make(map[string]map[string]string)
+	for _, path := range vaultSecret.Spec.Paths {
+		name := path.Name
+		if name == "" {
+			return nil, fmt.Errorf("path %s has no name", path.Path)
+		}
+
+		if _, exists := values[name]; exists {
+			return nil, fmt.Errorf("path name %s is duplicated", name)
+		}
+
+		kv, err := client.readKV(path)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = kv
+	}
+
+	data, err := makeData(vaultSecret, values)
+	if err != nil {
+		return nil, err
+	}
+
+	secret, err := makeSecret(vaultSecret, data)
+	if err != nil {
+		return nil, err
+	}
+
+	return [][]byte{secret}, nil
+}
+
+func makeData(vaultSecret *VaultSecret, values map[string]map[string]string) (map[string][]byte, error) {
+	data := make(map[string][]byte)
+
+	if len(vaultSecret.Spec.Data) == 0 {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			for key, value := range values[name] {
+				if _, exists := data[key]; exists {
+					return nil, fmt.Errorf("key %s from path %s is duplicated", key, name)
+				}
+				data[key] = []byte(value)
+			}
+		}
+
+		return data, nil
+	}
+
+	for key, text := range vaultSecret.Spec.Data {
+		tmpl, err := template.New(key).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, err
+		}
+
+		var sb strings.Builder
+		if err := tmpl.Execute(&sb, values); err != nil {
+			return nil, err
+		}
+		data[key] = []byte(sb.String())
+	}
+
+	return data, nil
+}
+
+func makeSecret(vaultSecret *VaultSecret, data map[string][]byte) ([]byte, error) {
+	secretType := vaultSecret.Spec.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+
+	secret := corev1.Secret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       reflect.TypeOf(corev1.Secret{}).Name(),
+		},
+		ObjectMeta: vaultSecret.ObjectMeta,
+		Type:       secretType,
+		Data:       data,
+	}
+
+	return yaml.Marshal(secret)
+}
@@ -0,0 +1,198 @@
+package main_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"sigs.k8s.io/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/vaultsecret"
+)
+
+const (
+	vaultAddrEnv     = "VAULT_ADDR"
+	vaultTokenEnv    = "VAULT_TOKEN"
+	vaultRoleIDEnv   = "VAULT_ROLE_ID"
+	vaultSecretIDEnv = "VAULT_SECRET_ID"
+
+	rootToken    = "root-token"
+	approleToken = "approle-token"
+)
+
+var kv = map[string]map[string]interface{}{
+	"/v1/secret/data/my-app/db": {
+		"username": "app",
+		"password": "hunter2",
+	},
+	"/v1/kv/data/my-app/api": {
+		"key":  "abc123",
+		"port": 8080,
+	},
+}
+
+func vaultHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/v1/auth/approle/login" {
+		var payload map[string]string
+		g.Expect(json.NewDecoder(r.Body).Decode(&payload)).To(g.Succeed())
+		g.Expect(payload).To(g.Equal(map[string]string{
+			"role_id":   "role",
+			"secret_id": "secret",
+		}))
+
+		g.Expect(json.NewEncoder(w).Encode(map[string]interface{}{
+			"auth": map[string]string{
+				"client_token": approleToken,
+			},
+		})).To(g.Succeed())
+		return
+	}
+
+	token := r.Header.Get("X-Vault-Token")
+	if token != rootToken && token != approleToken {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+
+	data, exists := kv[r.URL.Path]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	g.Expect(json.NewEncoder(w).Encode(map[string]interface{}{
+		"data": map[string]interface{}{
+			"data": data,
+		},
+	})).To(g.Succeed())
+}
+
+var _ = ginkgo.Describe("VaultSecret", func() {
+	server := httptest.NewServer(http.HandlerFunc(vaultHandler))
+
+	ginkgo.BeforeEach(func() {
+		g.Expect(os.Setenv(vaultAddrEnv, server.URL)).To(g.Succeed())
+		g.Expect(os.Setenv(vaultTokenEnv, rootToken)).To(g.Succeed())
+		g.Expect(os.Unsetenv(vaultRoleIDEnv)).To(g.Succeed())
+		g.Expect(os.Unsetenv(vaultSecretIDEnv)).To(g.Succeed())
+	})
+
+	ginkgo.DescribeTable("", VaultSecret,
+		ginkgo.Entry("with all keys",
+			makeVaultSecret(main.Spec{
+				Paths: []main.Path{
+					{
+						Name: "db",
+						Path: "my-app/db",
+					},
+					{
+						Name:  "api",
+						Mount: "kv",
+						Path:  "my-app/api",
+					},
+				},
+			}),
+			map[string][]byte{
+				"username": []byte("app"),
+				"password": []byte("hunter2"),
+				"key":      []byte("abc123"),
+				"port":     []byte("8080"),
+			},
+		),
+		ginkgo.Entry("with templated values",
+			makeVaultSecret(main.Spec{
+				Paths: []main.Path{
+					{
+						Name: "db",
+						Path: "my-app/db",
+					},
+				},
+				Data: map[string]string{
+					"DATABASE_URL": "postgres://{{ .db.username }}:{{ .db.password }}@db:5432/app",
+				},
+			}),
+			map[string][]byte{
+				"DATABASE_URL": []byte("postgres://app:hunter2@db:5432/app"),
+			},
+		),
+	)
+
+	ginkgo.It("logs in with AppRole", func() {
+		g.Expect(os.Unsetenv(vaultTokenEnv)).To(g.Succeed())
+		g.Expect(os.Setenv(vaultRoleIDEnv, "role")).To(g.Succeed())
+		g.Expect(os.Setenv(vaultSecretIDEnv, "secret")).To(g.Succeed())
+
+		VaultSecret(
+			makeVaultSecret(main.Spec{
+				Paths: []main.Path{
+					{
+						Name: "db",
+						Path: "my-app/db",
+					},
+				},
+			}),
+			map[string][]byte{
+				"username": []byte("app"),
+				"password": []byte("hunter2"),
+			},
+		)
+	})
+
+	ginkgo.It("fails on missing template keys", func() {
+		data, err := yaml.Marshal(makeVaultSecret(main.Spec{
+			Paths: []main.Path{
+				{
+					Name: "db",
+					Path: "my-app/db",
+				},
+			},
+			Data: map[string]string{
+				"DATABASE_URL": "{{ .db.hostname }}",
+			},
+		}))
+		g.Expect(err).To(g.BeNil())
+
+		var out bytes.Buffer
+		g.Expect(main.GenerateManifests(data, &out)).NotTo(g.Succeed())
+	})
+})
+
+func VaultSecret(vaultSecret main.VaultSecret, expectedData map[string][]byte) {
+	var vaultSecretYaml []byte
+	if data, err := yaml.Marshal(vaultSecret); g.Expect(err).To(g.BeNil()) {
+		vaultSecretYaml = data
+	}
+
+	var out bytes.Buffer
+	g.Expect(main.GenerateManifests(vaultSecretYaml, &out)).To(g.Succeed())
+
+	var secret corev1.Secret
+	g.Expect(yaml.Unmarshal(out.Bytes(), &secret)).To(g.Succeed())
+
+	g.Expect(secret.Name).To(g.Equal(vaultSecret.Name))
+	g.Expect(secret.Type).To(g.Equal(corev1.SecretTypeOpaque))
+	g.Expect(secret.Data).To(g.Equal(expectedData))
+}
+
+func makeVaultSecret(spec main.Spec) main.VaultSecret {
+	return main.VaultSecret{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: schema.GroupVersion{
+				Group:   "incognia.com",
+				Version: "v1alpha1",
+			}.String(),
+			Kind: "VaultSecret",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "my-app",
+		},
+		Spec: spec,
+	}
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	kyaml "sigs.k8s.io/kustomize/kyaml/yaml"
+
+	"github.com/inloco/iac-kustomize-plugins/pkg/findings"
+	"github.com/inloco/iac-kustomize-plugins/pkg/pluginconfig"
+)
+
+const (
+	panicSeparator = ": "
+
+	pluginName        = "vulnerabilitygate"
+	vulnerabilityRule = "vulnerability"
+	unscannedRule     = "unscanned"
+
+	skipAnnotation = "incognia.com/skip-vulnerability-gate"
+
+	cronJobKind      = "CronJob"
+	defaultTag       = "latest"
+	criticalSeverity = "CRITICAL"
+	expiresLayout    = "2006-01-02"
+)
+
+var (
+	ecrImageRegexp = regexp.MustCompile(`^(([0-9]+)\.dkr\.ecr(?:-fips)?\.([a-z0-9-]+)\.amazonaws\.com/([^:@]+))(?::([^:@]+))?(?:@([^:@]+:[0-9a-f]+))?$`)
+
+	defaultKinds = []string{
+		"CronJob",
+		"DaemonSet",
+		"Deployment",
+		"Job",
+		"Rollout",
+		"StatefulSet",
+	}
+
+	podSpecPath = []string{
+		"spec",
+		"template",
+		"spec",
+	}
+
+	cronJobPodSpecPath = []string{
+		"spec",
+		"jobTemplate",
+		"spec",
+		"template",
+		"spec",
+	}
+
+	containerFields = []string{
+		"containers",
+		"initContainers",
+	}
+
+	// Basic scanning completes once, while enhanced scanning stays active and
+	// keeps findings up to date.
+	scannedStatuses = []string{
+		string(ecrtypes.ScanStatusComplete),
+		string(ecrtypes.ScanStatusActive),
+	}
+
+	defaultThresholds = map[string]int32{
+		criticalSeverity: 0,
+	}
+)
+
+type VulnerabilityGate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              Spec `json:"spec,omitempty"`
+}
+
+type Spec struct {
+	Thresholds     map[string]int32 `json:"thresholds,omitempty"`
+	Waivers        []Waiver         `json:"waivers,omitempty"`
+	AllowUnscanned bool             `json:"allowUnscanned,omitempty"`
+	Kinds          []string         `json:"kinds,omitempty"`
+}
+
+type Waiver struct {
+	Image   string `json:"image,omitempty"`
+	Expires string `json:"expires,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+type ECRClient interface {
+	DescribeImageScanFindings(ctx context.Context, params *ecr.DescribeImageScanFindingsInput, optFns ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error)
+}
+
+type ECRClientFactory func(region string) (ECRClient, error)
+
+type ecrImage struct {
+	repositoryURI string
+	registryID    string
+	region        string
+	repository    string
+	tag           string
+	digest        string
+}
+
+// scanner looks findings up on ECR, querying each image and creating each
+// regional client at most once per build.
+type scanner struct {
+	newECRClient ECRClientFactory
+	clients      map[string]ECRClient
+	counts       map[string]map[string]int32
+}
+
+func main() {
+	filePath := os.Args[1]
+
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+
+	if err := TransformManifests(data, os.Stdin, os.Stdout); err != nil {
+		log.Panic(filePath, panicSeparator, err)
+	}
+}
+
+func TransformManifests(data []byte, in io.Reader, out io.Writer) error {
+	return TransformManifestsWithECRClientFactory(newECRClient, data, in, out)
+}
+
+func TransformManifestsWithECRClientFactory(newECRClient ECRClientFactory, data []byte, in io.Reader, out io.Writer) error {
+	var vulnerabilityGate VulnerabilityGate
+	if err := pluginconfig.Unmarshal(data, &vulnerabilityGate); err != nil {
+		return err
+	}
+
+	nodes, err := (&kio.ByteReader{
+		Reader:                in,
+		OmitReaderAnnotations: true,
+	}).Read()
+	if err != nil {
+		return err
+	}
+
+	if err := validate(newECRClient, &vulnerabilityGate, nodes); err != nil {
+		return err
+	}
+
+	return (&kio.ByteWriter{
+		Writer: out,
+	}).Write(nodes)
+}
+
+func newECRClient(region string) (ECRClient, error) {
+	cfg, err := config.LoadDefaultConfig(context.Background(), config.WithRegion(region))
+	if err != nil {
+		return nil, err
+	}
+
+	return ecr.NewFromConfig(cfg), nil
+}
+
+func validate(newECRClient ECRClientFactory, vulnerabilityGate *VulnerabilityGate, nodes []*kyaml.RNode) error {
+	spec := &vulnerabilityGate.Spec
+
+	kinds := spec.Kinds
+	if len(kinds) == 0 {
+		kinds = defaultKinds
+	}
+
+	thresholds := defaultThresholds
+	if len(spec.Thresholds) > 0 {
+		thresholds = make(map[string]int32, len(spec.Thresholds))
+		for severity, threshold := range spec.Thresholds {
+			thresholds[strings.ToUpper(severity)] = threshold
+		}
+	}
+
+	waivers := make(map[string]time.Time, len(spec.Waivers))
+	for _, waiver := range spec.Waivers {
+		expires, err := time.Parse(expiresLayout, waiver.Expires)
+		if err != nil {
+			return fmt.Errorf("waiver of %s has invalid expiry date %q", waiver.Image, waiver.Expires)
+		}
+		waivers[waiver.Image] = expires
+	}
+
+	s := &scanner{
+		newECRClient: newECRClient,
+		clients:      make(map[string]ECRClient),
+		counts:       make(map[string]map[string]int32),
+	}
+
+	var found []findings.Finding
+	for _, node := range nodes {
+		if !containsString(kinds, node.GetKind()) {
+			continue
+		}
+
+		if node.GetAnnotations()[skipAnnotation] == "true" {
+			continue
+		}
+
+		path := podSpecPath
+		if node.GetKind() == cronJobKind {
+			path = cronJobPodSpecPath
+		}
+
+		images, err := readImages(node, path)
+		if err != nil {
+			return err
+		}
+
+		for _, image := range images {
+			matches := ecrImageRegexp.FindStringSubmatch(image)
+			if matches == nil {
+				continue
+			}
+
+			ecrImage := &ecrImage{
+				repositoryURI: matches[1],
+				registryID:    matches[2],
+				region:        matches[3],
+				repository:    matches[4],
+				tag:           matches[5],
+				digest:        matches[6],
+			}
+
+			expires, waived := waivers[image]
+			if !waived {
+				expires, waived = waivers[ecrImage.repositoryURI]
+			}
+			if waived && time.Now().Before(expires.AddDate(0, 0, 1)) {
+				continue
+			}
+
+			counts, err := s.scan(image, ecrImage)
+			if err != nil {
+				return fmt.Errorf("%s %s: %w", node.GetKind(), node.GetName(), err)
+			}
+			if counts == nil {
+				if !spec.AllowUnscanned {
+					found = append(found, findings.Finding{
+						Path:     findings.SourcePath(node.GetAnnotations()),
+						Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+						Rule:     unscannedRule,
+						Message:  fmt.Sprintf("%s has not been scanned", image),
+					})
+				}
+				continue
+			}
+
+			for _, severity := range sortedKeys(thresholds) {
+				if counts[severity] <= thresholds[severity] {
+					continue
+				}
+
+				message := fmt.Sprintf("%s has %d %s findings, above the threshold of %d", image, counts[severity], severity, thresholds[severity])
+				if waived {
+					message += fmt.Sprintf(" (waiver expired on %s)", expires.Format(expiresLayout))
+				}
+				found = append(found, findings.Finding{
+					Path:     findings.SourcePath(node.GetAnnotations()),
+					Resource: fmt.Sprintf("%s %s", node.GetKind(), resourceName(node)),
+					Rule:     vulnerabilityRule,
+					Message:  message,
+				})
+			}
+		}
+	}
+
+	if err := findings.WriteReportFromEnv(pluginName, vulnerabilityGate.Name, found); err != nil {
+		return err
+	}
+
+	if len(found) > 0 {
+		return fmt.Errorf("images failing the vulnerability gate:\n%s", findings.Lines(found))
+	}
+
+	return nil
+}
+
+func readImages(node *kyaml.RNode, path []string) ([]string, error) {
+	var images []string
+	for _, field := range containerFields {
+		containers, err := node.Pipe(kyaml.Lookup(append(path, field)...))
+		if err != nil {
+			return nil, err
+		}
+		if containers == nil {
+			continue
+		}
+
+		elements, err := containers.Elements()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, container := range elements {
+			image, err := container.Pipe(kyaml.Lookup("image"))
+			if err != nil {
+				return nil, err
+			}
+			if image == nil {
+				continue
+			}
+
+			if !containsString(images, kyaml.GetValue(image)) {
+				images = append(images, kyaml.GetValue(image))
+			}
+		}
+	}
+
+	return images, nil
+}
+
+// scan returns the finding counts by severity of an image, or nil when it has
+// not been scanned yet.
+func (s *scanner) scan(image string, ecrImage *ecrImage) (map[string]int32, error) {
+	if counts, exists := s.counts[image]; exists {
+		return counts, nil
+	}
+
+	client, exists := s.clients[ecrImage.region]
+	if !exists {
+		c, err := s.newECRClient(ecrImage.region)
+		if err != nil {
+			return nil, err
+		}
+		s.clients[ecrImage.region] = c
+		client = c
+	}
+
+	imageID := &ecrtypes.ImageIdentifier{}
+	if ecrImage.digest != "" {
+		imageID.ImageDigest = aws.String(ecrImage.digest)
+	} else if ecrImage.tag != "" {
+		imageID.ImageTag = aws.String(ecrImage.tag)
+	} else {
+		imageID.ImageTag = aws.String(defaultTag)
+	}
+
+	output, err := client.DescribeImageScanFindings(context.Background(), &ecr.DescribeImageScanFindingsInput{
+		RegistryId:     aws.String(ecrImage.registryID),
+		RepositoryName: aws.String(ecrImage.repository),
+		ImageId:        imageID,
+	})
+
+	var scanNotFound *ecrtypes.ScanNotFoundException
+	if errors.As(err, &scanNotFound) {
+		s.counts[image] = nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if output.ImageScanStatus == nil || !containsString(scannedStatuses, string(output.ImageScanStatus.Status)) || output.ImageScanFindings == nil {
+		s.counts[image] = nil
+		return nil, nil
+	}
+
+	counts := make(map[string]int32, len(output.ImageScanFindings.FindingSeverityCounts))
+	for severity, count := range output.ImageScanFindings.FindingSeverityCounts {
+		counts[strings.ToUpper(severity)] = count
+	}
+	s.counts[image] = counts
+
+	return counts, nil
+}
+
+func resourceName(node *kyaml.RNode) string {
+	if namespace := node.GetNamespace(); namespace != "" {
+		return namespace + "/" + node.GetName()
+	}
+
+	return node.GetName()
+}
+
+func sortedKeys(m map[string]int32) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,141 @@
+package main_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ecr"
+	ecrtypes "github.com/aws/aws-sdk-go-v2/service/ecr/types"
+	"github.com/onsi/ginkgo/v2"
+	g "github.com/onsi/gomega"
+
+	"github.com/inloco/iac-kustomize-plugins/vulnerabilitygate"
+)
+
+const (
+	registry = "123456789876.dkr.ecr.us-east-1.amazonaws.com"
+
+	resources = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: my-app
+  namespace: my-namespace
+spec:
+  template:
+    spec:
+      initContainers:
+        - name: migrations
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/vulnerable:1.0.0
+      containers:
+        - name: app
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/vulnerable:1.0.0
+        - name: worker
+          image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/clean@sha256:0123456789abcdef
+        - name: sidecar
+          image: envoyproxy/envoy:v1.21.0
+---
+apiVersion: batch/v1
+kind: CronJob
+metadata:
+  name: report
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+            - name: report
+              image: 123456789876.dkr.ecr.us-east-1.amazonaws.com/unscanned
+`
+)
+
+type fakeECRClient struct {
+	calls *int
+}
+
+func (c fakeECRClient) DescribeImageScanFindings(_ context.Context, input *ecr.DescribeImageScanFindingsInput, _ ...func(*ecr.Options)) (*ecr.DescribeImageScanFindingsOutput, error) {
+	*c.calls++
+
+	counts := map[string]int32{
+		"MEDIUM": 4,
+	}
+
+	switch aws.ToString(input.RepositoryName) {
+	case "unscanned":
+		if aws.ToString(input.ImageId.ImageTag) != "latest" {
+			panic("unexpected tag")
+		}
+
+		return nil, &ecrtypes.ScanNotFoundException{}
+	case "vulnerable":
+		counts["CRITICAL"] = 2
+		counts["HIGH"] = 5
+	case "clean":
+		if aws.ToString(input.ImageId.ImageDigest) != "sha256:0123456789abcdef" {
+			panic("unexpected digest")
+		}
+	}
+
+	return &ecr.DescribeImageScanFindingsOutput{
+		ImageScanStatus: &ecrtypes.ImageScanStatus{
+			Status: ecrtypes.ScanStatusComplete,
+		},
+		ImageScanFindings: &ecrtypes.ImageScanFindings{
+			FindingSeverityCounts: counts,
+		},
+	}, nil
+}
+
+var _ = ginkgo.Describe("VulnerabilityGate", func() {
+	var calls int
+	newFakeECRClient := func(string) (main.ECRClient, error) {
+		return fakeECRClient{
+			calls: &calls,
+		}, nil
+	}
+
+	ginkgo.BeforeEach(func() {
+		calls = 0
+	})
+
+	transform := func(spec string) error {
+		var out bytes.Buffer
+		return main.TransformManifestsWithECRClientFactory(newFakeECRClient, []byte(`
+apiVersion: incognia.com/v1alpha1
+kind: VulnerabilityGate
+metadata:
+  name: vulnerability-gate
+spec:
+`+spec), strings.NewReader(resources), &out)
+	}
+
+	ginkgo.It("lists images exceeding thresholds", func() {
+		g.Expect(transform("  kinds: [Deployment]\n")).To(g.MatchError(`images failing the vulnerability gate:
+Deployment my-namespace/my-app: ` + registry + `/vulnerable:1.0.0 has 2 CRITICAL findings, above the threshold of 0`))
+		g.Expect(calls).To(g.Equal(2))
+	})
+
+	ginkgo.It("applies custom thresholds", func() {
+		g.Expect(transform("  kinds: [Deployment]\n  thresholds:\n    critical: 2\n    medium: 3\n")).To(g.MatchError(`images failing the vulnerability gate:
+Deployment my-namespace/my-app: ` + registry + `/vulnerable:1.0.0 has 4 MEDIUM findings, above the threshold of 3
+Deployment my-namespace/my-app: ` + registry + `/clean@sha256:0123456789abcdef has 4 MEDIUM findings, above the threshold of 3`))
+	})
+
+	ginkgo.It("fails on unscanned images unless allowed", func() {
+		g.Expect(transform("  waivers:\n    - image: " + registry + "/vulnerable\n      expires: \"2999-12-31\"\n")).To(g.MatchError(`images failing the vulnerability gate:
+CronJob report: ` + registry + `/unscanned has not been scanned`))
+
+		g.Expect(transform("  allowUnscanned: true\n  waivers:\n    - image: " + registry + "/vulnerable:1.0.0\n      expires: \"2999-12-31\"\n")).To(g.Succeed())
+	})
+
+	ginkgo.It("ignores expired waivers", func() {
+		g.Expect(transform("  kinds: [Deployment]\n  waivers:\n    - image: " + registry + "/vulnerable\n      expires: \"2000-01-01\"\n")).To(g.MatchError(g.ContainSubstring("(waiver expired on 2000-01-01)")))
+	})
+
+	ginkgo.It("fails on invalid expiry dates", func() {
+		g.Expect(transform("  waivers:\n    - image: " + registry + "/vulnerable\n      expires: tomorrow\n")).To(g.MatchError(`waiver of ` + registry + `/vulnerable has invalid expiry date "tomorrow"`))
+	})
+})